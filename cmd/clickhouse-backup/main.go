@@ -1,14 +1,20 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"github.com/Altinity/clickhouse-backup/pkg/logcli"
+	"github.com/Altinity/clickhouse-backup/pkg/resources"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/tracing"
 
 	"github.com/Altinity/clickhouse-backup/pkg/backup"
 	"github.com/Altinity/clickhouse-backup/pkg/server"
@@ -49,6 +55,23 @@ func main() {
 			Required: false,
 			Usage:    "internal parameter for API call",
 		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Hidden: false,
+			Usage:  "Cancel command and exit with error after this duration, 0 means without timeout, ignored by `server` and `watch`",
+		},
+		cli.StringFlag{
+			Name:   "progress",
+			Value:  status.ProgressAuto,
+			Hidden: false,
+			Usage:  "Show progress of upload, download and restore, `bar` draw progress bar, `json` write one JSON record with bytes done and total, ETA, current table and part per line into stdout and logs into stderr, `export` into stdout writes progress into stderr, `auto` means `bar` when progress output is terminal, `none` disable progress",
+		},
+		cli.DurationFlag{
+			Name:   "shutdown-timeout",
+			Value:  time.Minute,
+			Hidden: false,
+			Usage:  "After SIGTERM or SIGINT wait this duration while command finish current part, save resumable state and clean frozen data, then exit, second signal exit immediately",
+		},
 	}
 	cliapp.CommandNotFound = func(c *cli.Context, command string) {
 		fmt.Printf("Error. Unknown command: '%s'\n\n", command)
@@ -65,9 +88,9 @@ func main() {
 		{
 			Name:      "tables",
 			Usage:     "List of tables, exclude skip_tables",
-			UsageText: "clickhouse-backup tables [-t, --tables=<db>.<table>]] [--all]",
+			UsageText: "clickhouse-backup tables [-t, --tables=<db>.<table>]] [--exclude-tables=<db>.<table>] [--all]",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithExcludeTables(c.String("exclude-tables")))
 				return b.PrintTables(c.Bool("all"), c.String("table"))
 			},
 			Flags: append(cliapp.Flags,
@@ -81,16 +104,56 @@ func main() {
 					Hidden: false,
 					Usage:  "list tables only match with table name patterns, separated by comma, allow ? and * as wildcard",
 				},
+				cli.StringFlag{
+					Name:   "exclude-tables",
+					Hidden: false,
+					Usage:  "skip tables matched with table name patterns, separated by comma, allow ? and * as wildcard, exclude patterns have precedence over --tables",
+				},
+			),
+		},
+		{
+			Name:      "estimate",
+			Usage:     "Estimate size of backup and check free space on disks",
+			UsageText: "clickhouse-backup estimate [--partitions=<partition_names>] [--diff-from-remote=<backup_name>] [--exclude-tables=<db>.<table>] [<db>.<table>]",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithExcludeTables(c.String("exclude-tables")))
+				tablePattern := c.Args().First()
+				if tablePattern == "" {
+					tablePattern = c.String("t")
+				}
+				return b.Estimate(tablePattern, c.StringSlice("partitions"), c.String("diff-from-remote"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Hidden: false,
+					Usage:  "estimate only tables matched with table name patterns, separated by comma, allow ? and * as wildcard, the same as positional argument",
+				},
+				cli.StringFlag{
+					Name:   "exclude-tables",
+					Hidden: false,
+					Usage:  "skip tables matched with table name patterns, separated by comma, allow ? and * as wildcard, exclude patterns have precedence over --tables",
+				},
+				cli.StringSliceFlag{
+					Name:   "partitions",
+					Hidden: false,
+					Usage:  "estimate only selected partition names, separated by comma, the same format as `create --partitions`",
+				},
+				cli.StringFlag{
+					Name:   "diff-from-remote",
+					Hidden: false,
+					Usage:  "show expected compressed size of parts which not present in selected remote backup, it's how much `upload --diff-from-remote` will upload",
+				},
 			),
 		},
 		{
 			Name:        "create",
 			Usage:       "Create new backup",
-			UsageText:   "clickhouse-backup create [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--rbac] [--configs] [--skip-check-parts-columns] <backup_name>",
+			UsageText:   "clickhouse-backup create [-t, --tables=<db>.<table>] [--exclude-tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--rbac] [--configs] [--skip-check-parts-columns] [--wait-mutations] [--skip-unchanged-from=<local_backup_name>] [--resumable] [--dry-run] <backup_name>",
 			Description: "Create new backup",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.CreateBackup(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("skip-check-parts-columns"), version, c.Int("command-id"))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithDryRun(c.Bool("dry-run")), backup.WithExcludeTables(c.String("exclude-tables")), backup.WithSkipUnchangedFrom(c.String("skip-unchanged-from")))
+				return b.CreateBackup(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("skip-check-parts-columns"), c.Bool("wait-mutations"), c.Bool("resume"), version, c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -98,6 +161,11 @@ func main() {
 					Hidden: false,
 					Usage:  "create backup only matched with table name patterns, separated by comma, allow ? and * as wildcard",
 				},
+				cli.StringFlag{
+					Name:   "exclude-tables",
+					Hidden: false,
+					Usage:  "skip tables matched with table name patterns, separated by comma, allow ? and * as wildcard, exclude patterns have precedence over --tables",
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -105,6 +173,8 @@ func main() {
 						"if PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"if PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"if PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"partition_id could be glob pattern with ?, * and [] wildcards, for example --partitions=2023-0[1-6]*\n" +
+						"only selected partitions are frozen with ALTER TABLE ... FREEZE PARTITION, tables without --partitions use clickhouse->freeze_partitions_rules\n" +
 						"values depends on field types in your table, use single quote for String and Date/DateTime related types\n" +
 						"look to system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -138,16 +208,39 @@ func main() {
 					Hidden: false,
 					Usage:  "skip check system.parts_columns to disallow backup inconsistent column types for data parts",
 				},
+				cli.BoolFlag{
+					Name:   "wait-mutations",
+					Hidden: false,
+					Usage:  "wait until in-progress mutations and lightweight deletes for backup tables finish before FREEZE, to avoid restore rows which application believes deleted",
+				},
+				cli.StringFlag{
+					Name:   "skip-unchanged-from",
+					Hidden: false,
+					Usage:  "local backup name, tables which active parts count, rows, bytes, modification time and data version didn't change since this backup are hardlinked from it without FREEZE, use the same backup in `--diff-from` to skip upload of them",
+				},
+				cli.BoolFlag{
+					Name:   "resume, resumable",
+					Hidden: false,
+					Usage:  "Save list of already created tables and continue interrupted create of the same backup without freeze them again, ignore when 'use_embedded_backup_restore: true'",
+				},
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Only print each active part which will be frozen with size, and local backups which backups_to_keep_local will delete, don't create backup",
+				},
 			),
 		},
 		{
 			Name:        "create_remote",
 			Usage:       "Create and upload new backup",
-			UsageText:   "clickhouse-backup create_remote [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--diff-from=<local_backup_name>] [--diff-from-remote=<local_backup_name>] [--schema] [--rbac] [--configs] [--resumable] [--skip-check-parts-columns] <backup_name>",
+			UsageText:   "clickhouse-backup create_remote [-t, --tables=<db>.<table>] [--exclude-tables=<db>.<table>] [--partitions=<partition_names>] [--diff-from=<local_backup_name>] [--diff-from-remote=<local_backup_name>] [--schema] [--rbac] [--configs] [--resumable] [--skip-check-parts-columns] [--wait-mutations] [--skip-unchanged-from=<local_backup_name>] [--on-cluster] <backup_name>",
 			Description: "Create and upload",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.CreateToRemote(c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("resume"), c.Bool("skip-check-parts-columns"), version, c.Int("command-id"))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithExcludeTables(c.String("exclude-tables")), backup.WithSkipUnchangedFrom(c.String("skip-unchanged-from")))
+				if c.Bool("on-cluster") {
+					return b.CreateToRemoteOnCluster(c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("skip-check-parts-columns"), c.Bool("wait-mutations"), c.Bool("resume"), version, c.Int("command-id"))
+				}
+				return b.CreateToRemote(c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("skip-check-parts-columns"), c.Bool("wait-mutations"), c.Bool("resume"), version, c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -155,6 +248,11 @@ func main() {
 					Hidden: false,
 					Usage:  "create and upload backup only matched with table name patterns, separated by comma, allow ? and * as wildcard",
 				},
+				cli.StringFlag{
+					Name:   "exclude-tables",
+					Hidden: false,
+					Usage:  "skip tables matched with table name patterns, separated by comma, allow ? and * as wildcard, exclude patterns have precedence over --tables",
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -162,6 +260,8 @@ func main() {
 						"if PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"if PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"if PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"partition_id could be glob pattern with ?, * and [] wildcards, for example --partitions=2023-0[1-6]*\n" +
+						"only selected partitions are frozen with ALTER TABLE ... FREEZE PARTITION, tables without --partitions use clickhouse->freeze_partitions_rules\n" +
 						"values depends on field types in your table, use single quote for String and Date/DateTime related types\n" +
 						"look to system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -203,21 +303,36 @@ func main() {
 				cli.BoolFlag{
 					Name:   "resume, resumable",
 					Hidden: false,
-					Usage:  "Save intermediate upload state and resume upload if backup exists on remote storage, ignore when 'remote_storage: custom' or 'use_embedded_backup_restore: true'",
+					Usage:  "Save intermediate create and upload state, continue interrupted create of local backup and resume upload if backup exists on remote storage, ignore when 'remote_storage: custom' or 'use_embedded_backup_restore: true'",
 				},
 				cli.BoolFlag{
 					Name:   "skip-check-parts-columns",
 					Hidden: false,
 					Usage:  "skip check system.parts_columns to disallow backup inconsistent column types for data parts",
 				},
+				cli.BoolFlag{
+					Name:   "wait-mutations",
+					Hidden: false,
+					Usage:  "wait until in-progress mutations and lightweight deletes for backup tables finish before FREEZE, to avoid restore rows which application believes deleted",
+				},
+				cli.StringFlag{
+					Name:   "skip-unchanged-from",
+					Hidden: false,
+					Usage:  "local backup name, tables which active parts count, rows, bytes, modification time and data version didn't change since this backup are hardlinked from it without FREEZE, use the same backup in `--diff-from` to skip upload of them",
+				},
+				cli.BoolFlag{
+					Name:   "on-cluster",
+					Hidden: false,
+					Usage:  "Run create_remote on one replica of each shard from `cluster` config section via API of `clickhouse-backup server` on each host, shard backups named `<backup_name>-shard<N>`, manifest uploaded as `.cluster/<backup_name>.json`",
+				},
 			),
 		},
 		{
 			Name:      "upload",
 			Usage:     "Upload backup to remote storage",
-			UsageText: "clickhouse-backup upload [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--diff-from=<local_backup_name>] [--diff-from-remote=<remote_backup_name>] [--resumable] <backup_name>",
+			UsageText: "clickhouse-backup upload [-t, --tables=<db>.<table>] [--exclude-tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--diff-from=<local_backup_name>] [--diff-from-remote=<remote_backup_name>] [--resumable] [--dry-run] <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithDryRun(c.Bool("dry-run")), backup.WithExcludeTables(c.String("exclude-tables")))
 				return b.Upload(c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
@@ -236,6 +351,11 @@ func main() {
 					Usage:  "Upload data only for matched table name patterns, separated by comma, allow ? and * as wildcard",
 					Hidden: false,
 				},
+				cli.StringFlag{
+					Name:   "exclude-tables",
+					Hidden: false,
+					Usage:  "skip tables matched with table name patterns, separated by comma, allow ? and * as wildcard, exclude patterns have precedence over --tables",
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -243,6 +363,7 @@ func main() {
 						"if PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"if PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"if PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"partition_id could be glob pattern with ?, * and [] wildcards, for example --partitions=2023-0[1-6]*\n" +
 						"values depends on field types in your table, use single quote for String and Date/DateTime related types\n" +
 						"look to system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -256,25 +377,62 @@ func main() {
 					Hidden: false,
 					Usage:  "Save intermediate upload state and resume upload if backup exists on remote storage, ignored with 'remote_storage: custom' or 'use_embedded_backup_restore: true'",
 				},
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Only print parts of each table which will be uploaded with sizes, and remote backups which retention policy will delete after upload, don't upload",
+				},
 			),
 		},
 		{
 			Name:      "list",
 			Usage:     "List of backups",
-			UsageText: "clickhouse-backup list [all|local|remote] [latest|previous]",
+			UsageText: "clickhouse-backup list [all|local|remote] [latest|previous] [--all-shards] [--cost] [--format=table|json|yaml|csv] [--newer-than=<duration>] [--name-template]",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.List(c.Args().Get(0), c.Args().Get(1))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithNameTemplateFilter(c.Bool("name-template")))
+				return b.List(c.Args().Get(0), c.Args().Get(1), c.Bool("all-shards"), c.Bool("cost"), c.String("format"), c.String("newer-than"))
 			},
-			Flags: cliapp.Flags,
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "all-shards",
+					Usage:  "For `list remote`, list backups for all shards when remote storage path contains {shard} macro, group backups by name and show shards where backup is missing",
+					Hidden: false,
+				},
+				cli.BoolFlag{
+					Name:   "cost",
+					Usage:  "For `list remote`, walk all objects in remote storage and estimate monthly storage cost for each backup, request cost for upload and download, and monthly storage cost for each retention scenario, prices from `cost` config section",
+					Hidden: false,
+				},
+				cli.StringFlag{
+					Name:   "format",
+					Usage:  "Output format: table, json, yaml or csv, with columns name, location, storage, created, size, compressed_size, parts_count, required, upload_duration and description",
+					Hidden: false,
+				},
+				cli.StringFlag{
+					Name:   "newer-than",
+					Usage:  "Show only backups created during last duration, like `7d`, `2w` or `12h`",
+					Hidden: false,
+				},
+				cli.BoolFlag{
+					Name:   "name-template",
+					Usage:  "Show only backups which names matched general->backup_name_template resolved for current server, {time:layout} and {datetime:layout} match any value, requires ClickHouse connection to resolve macros",
+					Hidden: false,
+				},
+			),
 		},
 		{
 			Name:      "download",
 			Usage:     "Download backup from remote storage",
-			UsageText: "clickhouse-backup download [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--resumable] <backup_name>",
+			UsageText: "clickhouse-backup download [-t, --tables=<db>.<table>] [--exclude-tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--restore-table-mapping=<originTable>:<targetTable>[,<...>]] [--partitions=<partition_names>] [--partitions-where=<expression>] [-s, --schema] [--resumable] [--max-rehydration-wait=<duration>] <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.Download(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"), c.Int("command-id"))
+				if err := backup.ValidateMaxRehydrationWait(c.String("max-rehydration-wait")); err != nil {
+					return err
+				}
+				if err := backup.ValidateDownloadMapping(c.StringSlice("restore-database-mapping"), c.StringSlice("restore-table-mapping")); err != nil {
+					return err
+				}
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithExcludeTables(c.String("exclude-tables")), backup.WithMaxRehydrationWait(c.String("max-rehydration-wait")), backup.WithDownloadMapping(c.StringSlice("restore-database-mapping"), c.StringSlice("restore-table-mapping")))
+				return b.Download(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.String("partitions-where"), c.Bool("s"), c.Bool("resume"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -282,6 +440,21 @@ func main() {
 					Usage:  "Download objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
 					Hidden: false,
 				},
+				cli.StringFlag{
+					Name:   "exclude-tables",
+					Hidden: false,
+					Usage:  "skip tables matched with table name patterns, separated by comma, allow ? and * as wildcard, exclude patterns have precedence over --tables",
+				},
+				cli.StringSliceFlag{
+					Name:   "restore-database-mapping, m",
+					Usage:  "Save downloaded databases with other names, next `restore` creates them with target names, general->restore_database_mapping is not applied to download",
+					Hidden: false,
+				},
+				cli.StringSliceFlag{
+					Name:   "restore-table-mapping",
+					Usage:  "Save downloaded tables with other names, next `restore` creates them with target names, general->restore_table_mapping is not applied to download",
+					Hidden: false,
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -289,9 +462,15 @@ func main() {
 						"if PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"if PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"if PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"partition_id could be glob pattern with ?, * and [] wildcards, for example --partitions=2023-0[1-6]*\n" +
 						"values depends on field types in your table, use single quote for String and Date/DateTime related types\n" +
 						"look to system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
+				cli.StringFlag{
+					Name:   "partitions-where",
+					Hidden: false,
+					Usage:  "Download backup data only for partitions which `partition_id` matched with ClickHouse SQL expression over `partition_id` only, for example --partitions-where=\"partition_id BETWEEN '202301' AND '202306'\"",
+				},
 				cli.BoolFlag{
 					Name:   "schema, s",
 					Hidden: false,
@@ -302,15 +481,40 @@ func main() {
 					Hidden: false,
 					Usage:  "Save intermediate download state and resume download if backup exists on local storage, ignored with 'remote_storage: custom' or 'use_embedded_backup_restore: true'",
 				},
+				cli.StringFlag{
+					Name:   "max-rehydration-wait",
+					Hidden: false,
+					Usage:  "Wait until objects in archival storage class (S3 GLACIER, DEEP_ARCHIVE, Azure Archive) are restored before download, like `12h`, 0s means only request restore and fail, overrides general->max_rehydration_wait",
+				},
 			),
 		},
 		{
 			Name:      "restore",
 			Usage:     "Create schema and restore data from backup",
-			UsageText: "clickhouse-backup restore  [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--partitions=<partitions_names>] [-s, --schema] [-d, --data] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] <backup_name>",
+			UsageText: "clickhouse-backup restore  [-t, --tables=<db>.<table>] [--exclude-tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--restore-table-mapping=<originTable>:<targetTable>[,<...>]] [--partitions=<partitions_names>] [--partitions-where=<expression>] [--to-timestamp=<timestamp>] [-s, --schema] [-d, --data] [--rm, --drop] [-i, --ignore-dependencies] [--preserve-uuid] [--materialize-external] [--materialized-views=restore|skip|rebuild] [--restore-data-mode=hardlink|move|copy] [--convert-engines=<FromEngine>=<ToEngine>[,<...>]] [--projections=restore|drop|rebuild] [--restore-disk-mapping=<sourceDisk>:<targetDisk>[,<...>]] [--rbac] [--configs] <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.Restore(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("ignore-dependencies"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Int("command-id"))
+				if err := backup.ValidateMaterializedViewsMode(c.String("materialized-views")); err != nil {
+					return err
+				}
+				if err := backup.ValidateRestoreDataMode(c.String("restore-data-mode")); err != nil {
+					return err
+				}
+				if err := backup.ValidateConvertEngines(c.StringSlice("convert-engines")); err != nil {
+					return err
+				}
+				if err := backup.ValidateProjectionsMode(c.String("projections")); err != nil {
+					return err
+				}
+				if err := backup.ValidateRestoreDiskMapping(c.StringSlice("restore-disk-mapping")); err != nil {
+					return err
+				}
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithExcludeTables(c.String("exclude-tables")), backup.WithMaterializedViewsMode(c.String("materialized-views")), backup.WithRestoreDataMode(c.String("restore-data-mode")), backup.WithConvertEngines(c.StringSlice("convert-engines")), backup.WithProjectionsMode(c.String("projections")), backup.WithRestoreDiskMapping(c.StringSlice("restore-disk-mapping")))
+				if c.Bool("rm") {
+					if err := confirmDestructiveAction(c, fmt.Sprintf("drop exists schema objects before restore %s", c.Args().First())); err != nil {
+						return err
+					}
+				}
+				return b.Restore(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("restore-table-mapping"), c.StringSlice("partitions"), c.String("partitions-where"), c.String("to-timestamp"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("ignore-dependencies"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("preserve-uuid"), c.Bool("materialize-external"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -318,11 +522,26 @@ func main() {
 					Usage:  "Restore only database and objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
 					Hidden: false,
 				},
+				cli.StringFlag{
+					Name:   "exclude-tables",
+					Hidden: false,
+					Usage:  "skip tables matched with table name patterns, separated by comma, allow ? and * as wildcard, exclude patterns have precedence over --tables",
+				},
 				cli.StringSliceFlag{
 					Name:   "restore-database-mapping, m",
 					Usage:  "Define the rule to restore data. For the database not defined in this struct, the program will not deal with it.",
 					Hidden: false,
 				},
+				cli.StringSliceFlag{
+					Name:   "restore-table-mapping",
+					Usage:  "Define the rule to restore data. For the table not defined in this struct, the program will not deal with it.",
+					Hidden: false,
+				},
+				cli.StringFlag{
+					Name:   "to-timestamp",
+					Usage:  "Point-in-time restore, after restore data insert rows from clickhouse->pitr_source where clickhouse->pitr_timestamp_column between FREEZE time of each table and this timestamp, RFC3339 or `YYYY-MM-DD hh:mm:ss` in UTC",
+					Hidden: false,
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -330,9 +549,15 @@ func main() {
 						"if PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"if PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"if PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"partition_id could be glob pattern with ?, * and [] wildcards, for example --partitions=2023-0[1-6]*\n" +
 						"values depends on field types in your table, use single quote for String and Date/DateTime related types\n" +
 						"look to system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
+				cli.StringFlag{
+					Name:   "partitions-where",
+					Hidden: false,
+					Usage:  "Restore backup data only for partitions which `partition_id` matched with ClickHouse SQL expression over `partition_id` only, for example --partitions-where=\"partition_id BETWEEN '202301' AND '202306'\"",
+				},
 				cli.BoolFlag{
 					Name:   "schema, s",
 					Hidden: false,
@@ -348,11 +573,59 @@ func main() {
 					Hidden: false,
 					Usage:  "Drop exists schema objects before restore",
 				},
+				cli.BoolFlag{
+					Name:   "yes, y",
+					Hidden: false,
+					Usage:  "Don't ask confirmation for destructive operation",
+				},
+				cli.BoolFlag{
+					Name:   "no-input",
+					Hidden: false,
+					Usage:  "Never wait for input, fail when destructive operation require confirmation and --yes is not passed",
+				},
 				cli.BoolFlag{
 					Name:   "i, ignore-dependencies",
 					Hidden: false,
 					Usage:  "Ignore dependencies when drop exists schema objects",
 				},
+				cli.BoolFlag{
+					Name:   "preserve-uuid",
+					Hidden: false,
+					Usage:  "Create tables with UUID from backup in Atomic databases, to keep UUID based object disk paths and `{uuid}` macros, skipped for tables which changed by --restore-database-mapping or --restore-table-mapping",
+				},
+				cli.BoolFlag{
+					Name:   "materialize-external",
+					Hidden: false,
+					Usage:  "Restore tables from MaterializedMySQL and MaterializedPostgreSQL databases as plain ReplacingMergeTree tables in database with default engine, instead of recreate database engine which replicate data from source again",
+				},
+				cli.StringFlag{
+					Name:   "materialized-views",
+					Value:  "restore",
+					Hidden: false,
+					Usage:  "How to restore materialized views: `restore` attach views and inner tables data from backup, `skip` don't restore views and inner tables, `rebuild` create views after data restore, views without TO target will POPULATE from restored source tables",
+				},
+				cli.StringFlag{
+					Name:   "restore-data-mode",
+					Value:  "hardlink",
+					Hidden: false,
+					Usage:  "How to place local backup data parts into tables: `hardlink` link files, `move` rename files, local backup will not contain data after restore, `copy` copy files, required when backup and clickhouse data placed on different filesystems",
+				},
+				cli.StringSliceFlag{
+					Name:   "convert-engines",
+					Hidden: false,
+					Usage:  "Change table engine in DDL during schema restore, comma separated `From=To` rules, `*` matches any part of engine name, for example `Replicated*MergeTree=*MergeTree` restore replicated tables on single node, `*MergeTree=Replicated*MergeTree` use default_replica_path and default_replica_name from clickhouse-server config",
+				},
+				cli.StringFlag{
+					Name:   "projections",
+					Value:  "restore",
+					Hidden: false,
+					Usage:  "How to restore table projections: `restore` create tables with projections from backup, `drop` remove projections from table definitions for servers which don't support them, `rebuild` remove projections during schema restore, then add and materialize them after data restore",
+				},
+				cli.StringSliceFlag{
+					Name:   "restore-disk-mapping",
+					Hidden: false,
+					Usage:  "Restore data parts from source disks which don't exist on destination server to other disks, and replace `storage_policy` and `disk` settings in DDL, comma separated `source:target` disk or storage policy names, for example `fast_ssd:default,tiered:default`",
+				},
 				cli.BoolFlag{
 					Name:   "rbac, restore-rbac, do-restore-rbac",
 					Hidden: false,
@@ -378,10 +651,39 @@ func main() {
 		{
 			Name:      "restore_remote",
 			Usage:     "Download and restore",
-			UsageText: "clickhouse-backup restore_remote [--schema] [--data] [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--partitions=<partitions_names>] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] [--skip-rbac] [--skip-configs] [--resumable] <backup_name>",
+			UsageText: "clickhouse-backup restore_remote [--schema] [--data] [-t, --tables=<db>.<table>] [--exclude-tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--restore-table-mapping=<originTable>:<targetTable>[,<...>]] [--partitions=<partitions_names>] [--partitions-where=<expression>] [--to-timestamp=<timestamp>] [--rm, --drop] [-i, --ignore-dependencies] [--preserve-uuid] [--materialize-external] [--materialized-views=restore|skip|rebuild] [--restore-data-mode=hardlink|move|copy] [--convert-engines=<FromEngine>=<ToEngine>[,<...>]] [--projections=restore|drop|rebuild] [--restore-disk-mapping=<sourceDisk>:<targetDisk>[,<...>]] [--rbac] [--configs] [--skip-rbac] [--skip-configs] [--resumable] [--on-cluster] [--max-rehydration-wait=<duration>] <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.RestoreFromRemote(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("i"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("resume"), c.Int("command-id"))
+				if err := backup.ValidateMaterializedViewsMode(c.String("materialized-views")); err != nil {
+					return err
+				}
+				if err := backup.ValidateRestoreDataMode(c.String("restore-data-mode")); err != nil {
+					return err
+				}
+				if err := backup.ValidateConvertEngines(c.StringSlice("convert-engines")); err != nil {
+					return err
+				}
+				if err := backup.ValidateProjectionsMode(c.String("projections")); err != nil {
+					return err
+				}
+				if err := backup.ValidateRestoreDiskMapping(c.StringSlice("restore-disk-mapping")); err != nil {
+					return err
+				}
+				if err := backup.ValidateMaxRehydrationWait(c.String("max-rehydration-wait")); err != nil {
+					return err
+				}
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithExcludeTables(c.String("exclude-tables")), backup.WithMaterializedViewsMode(c.String("materialized-views")), backup.WithRestoreDataMode(c.String("restore-data-mode")), backup.WithConvertEngines(c.StringSlice("convert-engines")), backup.WithProjectionsMode(c.String("projections")), backup.WithRestoreDiskMapping(c.StringSlice("restore-disk-mapping")), backup.WithMaxRehydrationWait(c.String("max-rehydration-wait")))
+				if c.Bool("rm") {
+					if err := confirmDestructiveAction(c, fmt.Sprintf("drop schema objects before restore %s", c.Args().First())); err != nil {
+						return err
+					}
+				}
+				if c.Bool("on-cluster") {
+					if len(c.StringSlice("restore-database-mapping")) > 0 || len(c.StringSlice("restore-table-mapping")) > 0 || c.String("to-timestamp") != "" || c.Bool("rbac-only") || c.Bool("configs-only") {
+						return fmt.Errorf("--on-cluster doesn't support --restore-database-mapping, --restore-table-mapping, --to-timestamp, --rbac-only and --configs-only")
+					}
+					return b.RestoreFromRemoteOnCluster(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.String("partitions-where"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("i"), c.Bool("resume"), c.Int("command-id"))
+				}
+				return b.RestoreFromRemote(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("restore-table-mapping"), c.StringSlice("partitions"), c.String("partitions-where"), c.String("to-timestamp"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("i"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("resume"), c.Bool("preserve-uuid"), c.Bool("materialize-external"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -389,11 +691,26 @@ func main() {
 					Usage:  "Download and restore objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
 					Hidden: false,
 				},
+				cli.StringFlag{
+					Name:   "exclude-tables",
+					Hidden: false,
+					Usage:  "skip tables matched with table name patterns, separated by comma, allow ? and * as wildcard, exclude patterns have precedence over --tables",
+				},
 				cli.StringSliceFlag{
 					Name:   "restore-database-mapping, m",
 					Usage:  "Define the rule to restore data. For the database not defined in this struct, the program will not deal with it.",
 					Hidden: false,
 				},
+				cli.StringSliceFlag{
+					Name:   "restore-table-mapping",
+					Usage:  "Define the rule to restore data. For the table not defined in this struct, the program will not deal with it.",
+					Hidden: false,
+				},
+				cli.StringFlag{
+					Name:   "to-timestamp",
+					Usage:  "Point-in-time restore, after restore data insert rows from clickhouse->pitr_source where clickhouse->pitr_timestamp_column between FREEZE time of each table and this timestamp, RFC3339 or `YYYY-MM-DD hh:mm:ss` in UTC",
+					Hidden: false,
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -401,9 +718,15 @@ func main() {
 						"if PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"if PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"if PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"partition_id could be glob pattern with ?, * and [] wildcards, for example --partitions=2023-0[1-6]*\n" +
 						"values depends on field types in your table, use single quote for String and Date/DateTime related types\n" +
 						"look to system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
+				cli.StringFlag{
+					Name:   "partitions-where",
+					Hidden: false,
+					Usage:  "Download and restore backup data only for partitions which `partition_id` matched with ClickHouse SQL expression over `partition_id` only, for example --partitions-where=\"partition_id BETWEEN '202301' AND '202306'\"",
+				},
 				cli.BoolFlag{
 					Name:   "schema, s",
 					Hidden: false,
@@ -419,11 +742,59 @@ func main() {
 					Hidden: false,
 					Usage:  "Drop schema objects before restore",
 				},
+				cli.BoolFlag{
+					Name:   "yes, y",
+					Hidden: false,
+					Usage:  "Don't ask confirmation for destructive operation",
+				},
+				cli.BoolFlag{
+					Name:   "no-input",
+					Hidden: false,
+					Usage:  "Never wait for input, fail when destructive operation require confirmation and --yes is not passed",
+				},
 				cli.BoolFlag{
 					Name:   "i, ignore-dependencies",
 					Hidden: false,
 					Usage:  "Ignore dependencies when drop exists schema objects",
 				},
+				cli.BoolFlag{
+					Name:   "preserve-uuid",
+					Hidden: false,
+					Usage:  "Create tables with UUID from backup in Atomic databases, to keep UUID based object disk paths and `{uuid}` macros, skipped for tables which changed by --restore-database-mapping or --restore-table-mapping",
+				},
+				cli.BoolFlag{
+					Name:   "materialize-external",
+					Hidden: false,
+					Usage:  "Restore tables from MaterializedMySQL and MaterializedPostgreSQL databases as plain ReplacingMergeTree tables in database with default engine, instead of recreate database engine which replicate data from source again",
+				},
+				cli.StringFlag{
+					Name:   "materialized-views",
+					Value:  "restore",
+					Hidden: false,
+					Usage:  "How to restore materialized views: `restore` attach views and inner tables data from backup, `skip` don't restore views and inner tables, `rebuild` create views after data restore, views without TO target will POPULATE from restored source tables",
+				},
+				cli.StringFlag{
+					Name:   "restore-data-mode",
+					Value:  "hardlink",
+					Hidden: false,
+					Usage:  "How to place local backup data parts into tables: `hardlink` link files, `move` rename files, local backup will not contain data after restore, `copy` copy files, required when backup and clickhouse data placed on different filesystems, `restore_remote_streaming: true` use `move` instead of `hardlink`",
+				},
+				cli.StringSliceFlag{
+					Name:   "convert-engines",
+					Hidden: false,
+					Usage:  "Change table engine in DDL during schema restore, comma separated `From=To` rules, `*` matches any part of engine name, for example `Replicated*MergeTree=*MergeTree` restore replicated tables on single node, `*MergeTree=Replicated*MergeTree` use default_replica_path and default_replica_name from clickhouse-server config",
+				},
+				cli.StringFlag{
+					Name:   "projections",
+					Value:  "restore",
+					Hidden: false,
+					Usage:  "How to restore table projections: `restore` create tables with projections from backup, `drop` remove projections from table definitions for servers which don't support them, `rebuild` remove projections during schema restore, then add and materialize them after data restore",
+				},
+				cli.StringSliceFlag{
+					Name:   "restore-disk-mapping",
+					Hidden: false,
+					Usage:  "Restore data parts from source disks which don't exist on destination server to other disks, and replace `storage_policy` and `disk` settings in DDL, comma separated `source:target` disk or storage policy names, for example `fast_ssd:default,tiered:default`",
+				},
 				cli.BoolFlag{
 					Name:   "rbac, restore-rbac, do-restore-rbac",
 					Hidden: false,
@@ -449,14 +820,24 @@ func main() {
 					Hidden: false,
 					Usage:  "Save intermediate upload state and resume upload if backup exists on remote storage, ignored with 'remote_storage: custom' or 'use_embedded_backup_restore: true'",
 				},
+				cli.BoolFlag{
+					Name:   "on-cluster",
+					Hidden: false,
+					Usage:  "Restore backup created by `create_remote --on-cluster`, schema restored once with ON CLUSTER DDL, data of `<backup_name>-shard<N>` restored on one replica of each shard via API of `clickhouse-backup server`, other replicas synced with SYSTEM SYNC REPLICA",
+				},
+				cli.StringFlag{
+					Name:   "max-rehydration-wait",
+					Hidden: false,
+					Usage:  "Wait until objects in archival storage class (S3 GLACIER, DEEP_ARCHIVE, Azure Archive) are restored before download, like `12h`, 0s means only request restore and fail, overrides general->max_rehydration_wait",
+				},
 			),
 		},
 		{
 			Name:      "delete",
 			Usage:     "Delete specific backup",
-			UsageText: "clickhouse-backup delete <local|remote> <backup_name>",
+			UsageText: "clickhouse-backup delete [--dry-run] <local|remote> <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithDryRun(c.Bool("dry-run")))
 				if c.Args().Get(1) == "" {
 					log.Errorf("Backup name must be defined")
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
@@ -465,9 +846,30 @@ func main() {
 					log.Errorf("Unknown command '%s'\n", c.Args().Get(0))
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
 				}
+				if !c.Bool("dry-run") {
+					if err := confirmDestructiveAction(c, fmt.Sprintf("delete %s backup %s", c.Args().Get(0), c.Args().Get(1))); err != nil {
+						return err
+					}
+				}
 				return b.Delete(c.Args().Get(0), c.Args().Get(1), c.Int("command-id"))
 			},
-			Flags: cliapp.Flags,
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "yes, y",
+					Hidden: false,
+					Usage:  "Don't ask confirmation for destructive operation",
+				},
+				cli.BoolFlag{
+					Name:   "no-input",
+					Hidden: false,
+					Usage:  "Never wait for input, fail when destructive operation require confirmation and --yes is not passed",
+				},
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Only print backup which will be deleted with size, and backups which require it as base of incremental backup",
+				},
+			),
 		},
 		{
 			Name:  "default-config",
@@ -486,13 +888,89 @@ func main() {
 			Flags: cliapp.Flags,
 		},
 		{
-			Name:  "clean",
-			Usage: "Remove data in 'shadow' folder from all 'path' folders available from 'system.disks'",
+			Name:      "check-config",
+			Usage:     "Validate config file, report unknown keys, optionally check ClickHouse connection and remote storage permissions, return non-zero exit code when any check failed",
+			UsageText: "clickhouse-backup check-config [--probe-clickhouse] [--probe-remote]",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return backup.CheckConfig(config.GetConfigPath(c), c.Bool("probe-clickhouse"), c.Bool("probe-remote"), os.Stdout)
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "probe-clickhouse",
+					Hidden: false,
+					Usage:  "Check ClickHouse connection, access to system tables and to local disk paths",
+				},
+				cli.BoolFlag{
+					Name:   "probe-remote",
+					Hidden: false,
+					Usage:  "Write, stat, read, list and delete probe object on general->remote_storage and each upload_mirrors item",
+				},
+			),
+		},
+		{
+			Name:      "clean",
+			Usage:     "Remove data in 'shadow' folder from all 'path' folders available from 'system.disks'",
+			UsageText: "clickhouse-backup clean [--shadow] [--orphaned] [--dry-run]",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithDryRun(c.Bool("dry-run")))
+				if c.Bool("orphaned") {
+					return b.CleanOrphaned(context.Background())
+				}
 				return b.Clean(context.Background())
 			},
-			Flags: cliapp.Flags,
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Only print content of 'shadow' folders which will be removed with sizes",
+				},
+				cli.BoolFlag{
+					Name:   "shadow",
+					Hidden: false,
+					Usage:  "Clean 'shadow' folders, default when no other mode selected",
+				},
+				cli.BoolFlag{
+					Name:   "orphaned",
+					Hidden: false,
+					Usage:  "Remove only items in 'shadow' folders left by crashed or killed commands, not modified during general->orphaned_shadow_min_age and not matched with local backup name, freezes on object disks released via SYSTEM UNFREEZE, reclaimed space is reported",
+				},
+			),
+		},
+		{
+			Name:      "clean_remote",
+			Usage:     "Remove old remote backups according to backups_to_keep_remote, keep_daily_remote, keep_weekly_remote, keep_monthly_remote and min_age_remote, keeps backups required by incremental chains",
+			UsageText: "clickhouse-backup clean_remote [--dry-run] [--orphans] [--yes]",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				if c.Bool("orphans") {
+					return b.CleanRemoteOrphans(c.Bool("dry-run"), func(action string) error {
+						return confirmDestructiveAction(c, action)
+					}, status.NotFromAPI)
+				}
+				return b.CleanRemote(c.Bool("dry-run"), status.NotFromAPI)
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "only print backups which will delete according to retention policy",
+				},
+				cli.BoolFlag{
+					Name:   "orphans",
+					Hidden: false,
+					Usage:  "Instead of retention, delete remote objects which are not referenced by any backup with metadata.json or its incremental chain, like data of failed uploads, objects modified during general->orphaned_remote_min_age are kept",
+				},
+				cli.BoolFlag{
+					Name:   "yes, y",
+					Hidden: false,
+					Usage:  "Don't ask confirmation for destructive operation",
+				},
+				cli.BoolFlag{
+					Name:   "no-input",
+					Hidden: false,
+					Usage:  "Never wait for input, fail when destructive operation require confirmation and --yes is not passed",
+				},
+			),
 		},
 		{
 			Name:  "clean_remote_broken",
@@ -503,19 +981,138 @@ func main() {
 			},
 			Flags: cliapp.Flags,
 		},
+		{
+			Name:      "verify",
+			Usage:     "Check backup integrity with sentinel files, written during `create` when `sentinel_files: true`",
+			UsageText: "clickhouse-backup verify [--remote] <backup_name>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				if c.Args().Get(0) == "" {
+					log.Errorf("Backup name must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				return b.Verify(c.Args().First(), c.Bool("remote"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Verify remote backup, check objects presence and sizes without download archives",
+				},
+			),
+		},
+		{
+			Name:      "copy-remote",
+			Usage:     "Copy remote backup between general->remote_storage and upload_mirrors items, server side for the same provider, through local host otherwise",
+			UsageText: "clickhouse-backup copy-remote --from=<remote_storage|mirror_name> --to=<remote_storage|mirror_name> <backup_name>",
+			Action: func(c *cli.Context) error {
+				if c.Args().Get(0) == "" {
+					log.Errorf("Backup name must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				if c.String("to") == "" {
+					log.Errorf("--to must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.CopyRemote(c.Args().First(), c.String("from"), c.String("to"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "from",
+					Hidden: false,
+					Usage:  "Source, general->remote_storage value or `name` of upload_mirrors item, empty means general->remote_storage",
+				},
+				cli.StringFlag{
+					Name:   "to",
+					Hidden: false,
+					Usage:  "Destination, general->remote_storage value or `name` of upload_mirrors item",
+				},
+			),
+		},
+		{
+			Name:      "export",
+			Usage:     "Write local or remote backup as single tar stream to stdout or file, for transfer between isolated environments",
+			UsageText: "clickhouse-backup export [--remote] [-o, --output=<file>] <backup_name>",
+			Action: func(c *cli.Context) error {
+				if c.Args().Get(0) == "" {
+					log.Errorf("Backup name must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				output := os.Stdout
+				if c.String("output") != "" && c.String("output") != "-" {
+					f, err := os.OpenFile(c.String("output"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+					if err != nil {
+						return err
+					}
+					defer func() {
+						if err := f.Close(); err != nil {
+							log.Warnf("can't close %s: %v", f.Name(), err)
+						}
+					}()
+					output = f
+				} else {
+					// stdout contains tar stream
+					log.SetHandler(logcli.New(os.Stderr))
+				}
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Export(c.Args().First(), c.Bool("remote"), output, version, c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Export backup from remote storage, objects are exported as is, without decompression",
+				},
+				cli.StringFlag{
+					Name:   "output, o",
+					Hidden: false,
+					Usage:  "Write tar stream to file instead of stdout",
+				},
+			),
+		},
+		{
+			Name:      "import",
+			Usage:     "Read tar stream produced by `export` from stdin or file, local backup imported to local disks, remote backup imported to remote storage",
+			UsageText: "clickhouse-backup import [-i, --input=<file>]",
+			Action: func(c *cli.Context) error {
+				input := os.Stdin
+				if c.String("input") != "" && c.String("input") != "-" {
+					f, err := os.Open(c.String("input"))
+					if err != nil {
+						return err
+					}
+					defer func() {
+						if err := f.Close(); err != nil {
+							log.Warnf("can't close %s: %v", f.Name(), err)
+						}
+					}()
+					input = f
+				}
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Import(input, c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "input, i",
+					Hidden: false,
+					Usage:  "Read tar stream from file instead of stdin",
+				},
+			),
+		},
 
 		{
 			Name:        "watch",
 			Usage:       "Run infinite loop which create full + incremental backup sequence to allow efficient backup sequences",
-			UsageText:   "clickhouse-backup watch [--watch-interval=1h] [--full-interval=24h] [--watch-backup-name-template=shard{shard}-{type}-{time:20060102150405}] [-t, --tables=<db>.<table>] [--partitions=<partitions_names>] [--schema] [--rbac] [--configs] [--skip-check-parts-columns]",
+			UsageText:   "clickhouse-backup watch [--watch-interval=1h|--incremental-interval=1h] [--full-interval=24h] [--watch-backup-name-template=shard{shard}-{type}-{time:20060102150405}] [-t, --tables=<db>.<table>] [--exclude-tables=<db>.<table>] [--partitions=<partitions_names>] [--schema] [--rbac] [--configs] [--skip-check-parts-columns]",
 			Description: "Execute create_remote + delete local, create full backup every `--full-interval`, create and upload incremental backup every `--watch-interval` use previous backup as base with `--diff-from-remote` option, use `backups_to_keep_remote` config option for properly deletion remote backups, will delete old backups which not have references from other backups",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithExcludeTables(c.String("exclude-tables")))
 				return b.Watch(c.String("watch-interval"), c.String("full-interval"), c.String("watch-backup-name-template"), c.String("tables"), c.StringSlice("partitions"), c.Bool("schema"), c.Bool("rbac"), c.Bool("configs"), c.Bool("skip-check-parts-columns"), version, c.Int("command-id"), nil, c)
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
-					Name:   "watch-interval",
+					Name:   "watch-interval, incremental-interval",
 					Usage:  "Interval for run 'create_remote' + 'delete local' for incremental backup, look format https://pkg.go.dev/time#ParseDuration",
 					Hidden: false,
 				},
@@ -534,6 +1131,11 @@ func main() {
 					Usage:  "Create and upload only objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
 					Hidden: false,
 				},
+				cli.StringFlag{
+					Name:   "exclude-tables",
+					Hidden: false,
+					Usage:  "skip tables matched with table name patterns, separated by comma, allow ? and * as wildcard, exclude patterns have precedence over --tables",
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -541,6 +1143,7 @@ func main() {
 						"if PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"if PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"if PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"partition_id could be glob pattern with ?, * and [] wildcards, for example --partitions=2023-0[1-6]*\n" +
 						"values depends on field types in your table, use single quote for String and Date/DateTime related types\n" +
 						"look to system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -579,7 +1182,7 @@ func main() {
 					Hidden: false,
 				},
 				cli.StringFlag{
-					Name:   "watch-interval",
+					Name:   "watch-interval, incremental-interval",
 					Usage:  "Interval for run 'create_remote' + 'delete local' for incremental backup, look format https://pkg.go.dev/time#ParseDuration",
 					Hidden: false,
 				},
@@ -596,7 +1199,114 @@ func main() {
 			),
 		},
 	}
-	if err := cliapp.Run(os.Args); err != nil {
+	for i := range cliapp.Commands {
+		cliapp.Commands[i].Before = func(c *cli.Context) error {
+			if err := setCommandTimeout(c); err != nil {
+				return err
+			}
+			setCommandShutdown(c)
+			setCommandResources(c)
+			return setCommandProgress(c)
+		}
+	}
+	err := cliapp.Run(os.Args)
+	status.Current.StopCLI(err)
+	stopProgress()
+	// finished spans shall be exported before exit
+	tracing.Shutdown(context.Background())
+	if err != nil {
 		log.Fatal(err.Error())
 	}
 }
+
+// setCommandTimeout - apply `--timeout`, context for command will cancel after timeout and command returns error, `server` and `watch` run without timeout
+func setCommandTimeout(c *cli.Context) error {
+	timeout := c.Duration("timeout")
+	if timeout <= 0 || c.Command.Name == "server" || c.Command.Name == "watch" {
+		return nil
+	}
+	status.Current.SetCLITimeout(timeout)
+	return nil
+}
+
+// setCommandResources - general->cpu_nice, io_nice_class and cgroup_path apply to whole process and can't be reverted, so they are applied only for commands which run from CLI
+func setCommandResources(c *cli.Context) {
+	if c.Command.Name == "server" {
+		resources.Disable()
+	}
+}
+
+// setCommandShutdown - first SIGTERM or SIGINT cancel command which run from CLI, command shall stop gracefully, second signal or `--shutdown-timeout` exit immediately,
+// `server` handle signals itself and commands started via API are canceled by server
+func setCommandShutdown(c *cli.Context) {
+	if c.Int("command-id") != status.NotFromAPI || c.Command.Name == "server" {
+		return
+	}
+	shutdownTimeout := c.Duration("shutdown-timeout")
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Warnf("`%s` got %s, stopping gracefully, wait up to --shutdown-timeout=%s, send signal again to exit immediately", c.Command.Name, sig, shutdownTimeout)
+		status.Current.CancelCLI()
+		select {
+		case sig = <-signals:
+			log.Fatalf("`%s` got %s again, exit immediately", c.Command.Name, sig)
+		case <-time.After(shutdownTimeout):
+			log.Fatalf("`%s` command doesn't stop after --shutdown-timeout=%s", c.Command.Name, shutdownTimeout)
+		}
+	}()
+}
+
+var stopProgress = func() {}
+
+// setCommandProgress - apply `--progress` for command which run from CLI, commands started via API report progress into GET /backup/actions/{job_id}
+func setCommandProgress(c *cli.Context) error {
+	if c.Int("command-id") != status.NotFromAPI {
+		return nil
+	}
+	mode := c.String("progress")
+	if err := status.ValidateProgressMode(mode); err != nil {
+		return err
+	}
+	progressOutput := os.Stdout
+	// `export` without --output streams tar archive into stdout, progress shall not corrupt it
+	if c.Command.Name == "export" && (c.String("output") == "" || c.String("output") == "-") {
+		progressOutput = os.Stderr
+	}
+	if mode == status.ProgressAuto || mode == "" {
+		mode = status.ProgressNone
+		if stat, err := progressOutput.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+			mode = status.ProgressBar
+		}
+	}
+	if mode == status.ProgressJSON {
+		log.SetHandler(logcli.New(os.Stderr))
+	}
+	status.Current.StartCLI(c.Command.Name)
+	stopProgress = status.Current.StartProgress(mode, progressOutput, time.Second)
+	return nil
+}
+
+// confirmDestructiveAction - ask confirmation only for interactive run from terminal, API calls and `--yes` skip confirmation, `--no-input` fail instead of waiting for input
+func confirmDestructiveAction(c *cli.Context, action string) error {
+	if c.Bool("yes") || c.Int("command-id") != status.NotFromAPI {
+		return nil
+	}
+	if c.Bool("no-input") {
+		return fmt.Errorf("%s require confirmation, use --yes", action)
+	}
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	fmt.Printf("Are you sure you want to %s? [y/N]: ", action)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("can't read confirmation: %v", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		return fmt.Errorf("%s cancelled", action)
+	}
+	return nil
+}
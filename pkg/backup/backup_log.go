@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	BackupLogStatusBackupCreated  = "BACKUP_CREATED"
+	BackupLogStatusBackupFailed   = "BACKUP_FAILED"
+	BackupLogStatusRestored       = "RESTORED"
+	BackupLogStatusRestoreFailed  = "RESTORE_FAILED"
+	backupLogCreateTableStatement = "CREATE TABLE IF NOT EXISTS %s (" +
+		"event_date Date, event_time_microseconds DateTime64(6), id String, name String, status String, error String, " +
+		"start_time DateTime, end_time DateTime, num_files UInt64, total_size UInt64" +
+		") ENGINE=MergeTree() PARTITION BY toYYYYMM(event_date) ORDER BY (event_date, event_time_microseconds)"
+)
+
+// setLogComment - prepare `log_comment` for all SQL queries which will execute during operation, shall call before b.ch.Connect()
+func (b *Backuper) setLogComment(operation, backupName string, commandId int) {
+	logComment, err := json.Marshal(map[string]interface{}{
+		"tool":       "clickhouse-backup",
+		"operation":  operation,
+		"command_id": commandId,
+		"backup":     backupName,
+	})
+	if err != nil {
+		b.log.Warnf("can't prepare log_comment: %v", err)
+		return
+	}
+	b.ch.LogComment = string(logComment)
+}
+
+// writeBackupLog - write summary row compatible with system.backup_log into `clickhouse->backup_log_table`, errors only logged
+func (b *Backuper) writeBackupLog(ctx context.Context, backupName string, commandId int, status string, startTime time.Time, operationErr error) {
+	if b.cfg.ClickHouse.BackupLogTable == "" {
+		return
+	}
+	log := b.log.WithField("logger", "writeBackupLog")
+	if err := b.ch.QueryContext(ctx, fmt.Sprintf(backupLogCreateTableStatement, b.cfg.ClickHouse.BackupLogTable)); err != nil {
+		log.Warnf("can't create %s: %v", b.cfg.ClickHouse.BackupLogTable, err)
+		return
+	}
+	var totalSize, numFiles uint64
+	if backupMetadata, err := b.ReadBackupMetadataLocal(ctx, backupName); err == nil {
+		totalSize = backupMetadata.DataSize + backupMetadata.MetadataSize + backupMetadata.RBACSize + backupMetadata.ConfigSize + backupMetadata.KeeperSize
+	}
+	backupPaths := []string{path.Join(b.DefaultDataPath, "backup", backupName)}
+	if b.EmbeddedBackupDataPath != "" {
+		backupPaths = append(backupPaths, path.Join(b.EmbeddedBackupDataPath, backupName))
+	}
+	for _, backupPath := range backupPaths {
+		if files, err := countBackupFiles(backupPath); err == nil {
+			numFiles = files
+			break
+		}
+	}
+	errorMessage := ""
+	if operationErr != nil {
+		errorMessage = operationErr.Error()
+	}
+	endTime := time.Now()
+	insertSQL := fmt.Sprintf(
+		"INSERT INTO %s (event_date, event_time_microseconds, id, name, status, error, start_time, end_time, num_files, total_size) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		b.cfg.ClickHouse.BackupLogTable,
+	)
+	if err := b.ch.QueryContext(ctx, insertSQL, endTime, endTime, fmt.Sprintf("%d", commandId), backupName, status, errorMessage, startTime, endTime, numFiles, totalSize); err != nil {
+		log.Warnf("can't write to %s: %v", b.cfg.ClickHouse.BackupLogTable, err)
+	}
+}
+
+// countBackupFiles - number of regular files inside local backup directory, like `num_files` in system.backup_log
+func countBackupFiles(backupPath string) (uint64, error) {
+	if _, err := os.Stat(backupPath); err != nil {
+		return 0, err
+	}
+	var numFiles uint64
+	err := filepath.Walk(backupPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			numFiles++
+		}
+		return nil
+	})
+	return numFiles, err
+}
@@ -0,0 +1,24 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountBackupFiles(t *testing.T) {
+	backupPath := t.TempDir()
+	partPath := path.Join(backupPath, "shadow", "db", "t", "default", "all_1_1_0")
+	require.NoError(t, os.MkdirAll(partPath, 0750))
+	for _, filePath := range []string{path.Join(backupPath, "metadata.json"), path.Join(partPath, "checksums.txt"), path.Join(partPath, "data.bin")} {
+		require.NoError(t, os.WriteFile(filePath, []byte("{}"), 0640))
+	}
+	numFiles, err := countBackupFiles(backupPath)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), numFiles)
+	_, err = countBackupFiles(path.Join(backupPath, "absent"))
+	assert.Error(t, err)
+}
@@ -5,17 +5,28 @@ import (
 	"errors"
 	"fmt"
 	"path"
+	"sync"
 
 	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/notify"
+	"github.com/Altinity/clickhouse-backup/pkg/resources"
 	"github.com/Altinity/clickhouse-backup/pkg/resumable"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
 	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/tracing"
 
 	apexLog "github.com/apex/log"
+	"golang.org/x/sync/semaphore"
 )
 
 const DirectoryFormat = "directory"
 
+// ChunksFormat - parts uploaded as content addressed chunks, see general->dedup_store
+const ChunksFormat = "chunks"
+
 var errShardOperationUnsupported = errors.New("sharded operations are not supported")
 
 // versioner is an interface for determining the version of Clickhouse
@@ -36,21 +47,79 @@ type Backuper struct {
 	DefaultDataPath        string
 	EmbeddedBackupDataPath string
 	isEmbedded             bool
+	isEmbeddedRemote       bool
 	resume                 bool
 	resumableState         *resumable.State
+	restoreRollback        *restoreRollbackState
+	// partsSemaphore - global worker pool for upload and download data parts, shared between all tables in one operation
+	partsSemaphore *semaphore.Weighted
+	// objectDiskCopyStates - resume manifests for CopyObject during `create`, by disk name
+	objectDiskCopyStates   map[string]*objectDiskCopyState
+	objectDiskCopyStatesMx sync.Mutex
+	// keeperFallback - keeper is not available and clickhouse->keeper_safe_mode is `fallback`
+	keeperFallback bool
+	// partitionsWhere - SQL expression from --partitions-where, evaluated against `partition_id` of each table in backup during download and restore
+	partitionsWhere string
+	// dedupChunks - sha256 of chunks referenced by current upload, saved into backup_name/chunks.list for garbage collection
+	dedupChunks   map[string]struct{}
+	dedupChunksMx sync.Mutex
+	// restorePipeline - not nil during data download for `restore_remote` with general->restore_remote_pipeline: true
+	restorePipeline *restorePipeline
+	// materializedDatabases - MaterializedMySQL and MaterializedPostgreSQL databases from backup metadata, name -> engine
+	materializedDatabases map[string]string
+	// materializeExternal - restore tables from materializedDatabases as plain tables, instead of recreate database engine
+	materializeExternal bool
+	// dryRun - see WithDryRun
+	dryRun bool
+	// materializedViewsMode - see WithMaterializedViewsMode, materializedViewsForRebuild collected during schema restore for `rebuild`
+	materializedViewsMode       string
+	materializedViewsForRebuild ListOfTables
+	// deferMaterializedViewsRebuild - restore_remote pipeline rebuilds materialized views after download of all tables
+	deferMaterializedViewsRebuild bool
+	// restoreDataMode - see WithRestoreDataMode
+	restoreDataMode string
+	// convertEngines - see WithConvertEngines
+	convertEngines []engineConversionRule
+	// sourceMacros - system.macros of server where backup created, see applyReplicaPathMacros
+	sourceMacros map[string]string
+	// restoreDiskMapping - see WithRestoreDiskMapping
+	restoreDiskMapping map[string]string
+	// projectionsMode - see WithProjectionsMode, projectionsForRebuild collected during schema restore for `rebuild`
+	projectionsMode       string
+	projectionsForRebuild map[metadata.TableTitle][]string
+	// maxRehydrationWait - see WithMaxRehydrationWait
+	maxRehydrationWait string
+	// notifier - send lifecycle events to `notifications` channels, notifying is true while top level operation in progress
+	notifier  *notify.Notifier
+	notifying bool
+	// runningHooks - true while top level operation with `hooks` in progress, see newOperationHooks
+	runningHooks bool
+	// skipUnchangedFrom - see WithSkipUnchangedFrom
+	skipUnchangedFrom string
+	// excludeTables - see WithExcludeTables, kept to forward `--exclude-tables` to `--on-cluster` commands
+	excludeTables string
+	// teeMirrors - not nil during upload with `upload_mirrors_mode: tee`, see initTeeMirrors
+	teeMirrors *teeMirrors
+	// nameTemplateFilter - see WithNameTemplateFilter
+	nameTemplateFilter bool
+	// downloadDatabaseMapping, downloadTableMapping - see WithDownloadMapping
+	downloadDatabaseMapping map[string]string
+	downloadTableMapping    map[string]string
 }
 
 func NewBackuper(cfg *config.Config, opts ...BackuperOpt) *Backuper {
+	tracing.Init(cfg)
 	ch := &clickhouse.ClickHouse{
 		Config: &cfg.ClickHouse,
 		Log:    apexLog.WithField("logger", "clickhouse"),
 	}
 	b := &Backuper{
-		cfg:  cfg,
-		ch:   ch,
-		vers: ch,
-		bs:   nil,
-		log:  apexLog.WithField("logger", "backuper"),
+		cfg:      cfg,
+		ch:       ch,
+		vers:     ch,
+		bs:       nil,
+		log:      apexLog.WithField("logger", "backuper"),
+		notifier: notify.New(cfg),
 	}
 	for _, opt := range opts {
 		opt(b)
@@ -58,6 +127,54 @@ func NewBackuper(cfg *config.Config, opts ...BackuperOpt) *Backuper {
 	return b
 }
 
+// newTableSemaphore - limit how many data parts of one table could process concurrently, perTable=0 means use global concurrency
+func newTableSemaphore(perTable, global uint8) *semaphore.Weighted {
+	if perTable == 0 || perTable > global {
+		perTable = global
+	}
+	return semaphore.NewWeighted(int64(perTable))
+}
+
+// acquirePartSlot - acquire per table slot first, and after it slot in global worker pool, so one huge table can't occupy all workers when other tables wait
+func (b *Backuper) acquirePartSlot(ctx context.Context, tableSemaphore *semaphore.Weighted) error {
+	if err := tableSemaphore.Acquire(ctx, 1); err != nil {
+		return err
+	}
+	if b.partsSemaphore != nil {
+		if err := b.partsSemaphore.Acquire(ctx, 1); err != nil {
+			tableSemaphore.Release(1)
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) releasePartSlot(tableSemaphore *semaphore.Weighted) {
+	if b.partsSemaphore != nil {
+		b.partsSemaphore.Release(1)
+	}
+	tableSemaphore.Release(1)
+}
+
+// setCommandLog - add correlation_id field to backuper and clickhouse logs, and command_id field for commands started via API, allow GET /backup/actions/{job_id}/log to filter records
+func (b *Backuper) setCommandLog(commandId int) {
+	correlationId := status.Current.GetCorrelationId(commandId)
+	b.log = b.log.WithField(common.CorrelationIdField, correlationId)
+	b.ch.Log = b.ch.Log.WithField(common.CorrelationIdField, correlationId)
+	if commandId == status.NotFromAPI {
+		return
+	}
+	b.log = b.log.WithField(status.CommandIdField, commandId)
+	b.ch.Log = b.ch.Log.WithField(status.CommandIdField, commandId)
+}
+
+// applyResourceLimits - compression and copy run with lower CPU and I/O priority, see general->cpu_nice, failure doesn't stop backup
+func (b *Backuper) applyResourceLimits() {
+	if err := resources.Apply(&b.cfg.General); err != nil {
+		b.log.WithField("logger", "applyResourceLimits").Warnf("can't apply resource limits: %v", err)
+	}
+}
+
 func WithVersioner(v versioner) BackuperOpt {
 	return func(b *Backuper) {
 		b.vers = v
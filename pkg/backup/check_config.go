@@ -0,0 +1,204 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+
+	apexLog "github.com/apex/log"
+)
+
+// configChecker - print result of each check, `check-config` fails when at least one check failed
+type configChecker struct {
+	w        io.Writer
+	failures int
+}
+
+func (c *configChecker) report(check string, err error) bool {
+	if err != nil {
+		c.failures++
+		_, _ = fmt.Fprintf(c.w, "FAIL  %s: %v\n", check, err)
+		return false
+	}
+	_, _ = fmt.Fprintf(c.w, "OK    %s\n", check)
+	return true
+}
+
+func (c *configChecker) skip(check, reason string) {
+	_, _ = fmt.Fprintf(c.w, "SKIP  %s: %s\n", check, reason)
+}
+
+// CheckConfig - `check-config`, validate config file against Config structure and ValidateConfig rules,
+// probeClickHouse checks connection, access to system tables and local disk paths, probeRemote writes, reads, lists and deletes probe object on
+// general->remote_storage and each `upload_mirrors` item, return error when any check failed, so could be used in CI pipelines
+func CheckConfig(configPath string, probeClickHouse, probeRemote bool, w io.Writer) error {
+	c := &configChecker{w: w}
+	if _, err := os.Stat(configPath); err != nil {
+		c.report(fmt.Sprintf("config file %s", configPath), err)
+		return fmt.Errorf("check-config found %d problems", c.failures)
+	}
+	unknownKeys, err := config.FindUnknownKeys(configPath)
+	if c.report("yaml syntax", err) {
+		for _, key := range unknownKeys {
+			c.report("unknown key", fmt.Errorf("`%s` is not supported", key))
+		}
+		if len(unknownKeys) == 0 {
+			c.report("unknown keys", nil)
+		}
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if !c.report("config values", err) {
+		return fmt.Errorf("check-config found %d problems", c.failures)
+	}
+	if _, _, err = cfg.GetMirrorConfigs(); len(cfg.Mirrors) > 0 {
+		c.report("upload_mirrors", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if probeClickHouse || probeRemote {
+		ch := &clickhouse.ClickHouse{
+			Config: &cfg.ClickHouse,
+			Log:    apexLog.WithField("logger", "clickhouse"),
+		}
+		if c.report("clickhouse connection", ch.Connect()) {
+			defer ch.Close()
+			if probeClickHouse {
+				c.probeClickHouse(ctx, ch)
+			}
+			if probeRemote {
+				c.probeRemoteStorages(ctx, cfg, ch)
+			}
+		} else if probeRemote {
+			c.skip("remote storage probe", "clickhouse connection is required to apply macros in remote path")
+		}
+	}
+	if c.failures > 0 {
+		return fmt.Errorf("check-config found %d problems", c.failures)
+	}
+	return nil
+}
+
+// probeClickHouse - system.disks and system.tables are required by all commands, local disk paths are read directly during create and restore
+func (c *configChecker) probeClickHouse(ctx context.Context, ch *clickhouse.ClickHouse) {
+	version, err := ch.GetVersion(ctx)
+	if c.report("clickhouse version", err) {
+		_, _ = fmt.Fprintf(c.w, "      version %d\n", version)
+	}
+	disks, err := ch.GetDisks(ctx, false)
+	if !c.report("clickhouse system.disks", err) {
+		return
+	}
+	for _, disk := range disks {
+		if disk.IsBackup {
+			continue
+		}
+		entries, err := os.ReadDir(disk.Path)
+		if err == nil && len(entries) == 0 {
+			err = fmt.Errorf("directory is empty, clickhouse-backup shall run on the same host and have access to clickhouse-server data")
+		}
+		c.report(fmt.Sprintf("disk %s path %s access", disk.Name, disk.Path), err)
+	}
+	tables := make([]struct {
+		Count uint64 `ch:"count"`
+	}, 0)
+	c.report("clickhouse system.tables", ch.SelectContext(ctx, &tables, "SELECT count() AS count FROM system.tables"))
+}
+
+func (c *configChecker) probeRemoteStorages(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse) {
+	mirrorNames, _, _ := cfg.GetMirrorConfigs()
+	for _, name := range append([]string{""}, mirrorNames...) {
+		remoteCfg, err := cfg.GetRemoteConfig(name)
+		if name == "" {
+			name = cfg.General.RemoteStorage
+		}
+		if !c.report(fmt.Sprintf("remote %s config", name), err) {
+			continue
+		}
+		if remoteCfg.General.RemoteStorage == "none" || remoteCfg.General.RemoteStorage == "custom" {
+			c.skip(fmt.Sprintf("remote %s probe", name), fmt.Sprintf("remote_storage: %s", remoteCfg.General.RemoteStorage))
+			continue
+		}
+		c.probeRemoteStorage(ctx, name, remoteCfg, ch)
+	}
+}
+
+// probeRemoteStorage - each operation checked separately to show which permission is missing, probe object is deleted even when read failed
+func (c *configChecker) probeRemoteStorage(ctx context.Context, name string, remoteCfg *config.Config, ch *clickhouse.ClickHouse) {
+	bd, err := storage.NewBackupDestination(ctx, remoteCfg, ch, false, "")
+	if !c.report(fmt.Sprintf("remote %s init", name), err) {
+		return
+	}
+	if !c.report(fmt.Sprintf("remote %s connect", name), bd.Connect(ctx)) {
+		return
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			apexLog.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	probeKey := fmt.Sprintf("clickhouse-backup-probe-%d.txt", time.Now().UnixNano())
+	probeBody := fmt.Sprintf("clickhouse-backup check-config probe %s", time.Now().Format(time.RFC3339))
+	if !c.report(fmt.Sprintf("remote %s write %s", name, probeKey), bd.PutFile(ctx, probeKey, io.NopCloser(strings.NewReader(probeBody)))) {
+		return
+	}
+	defer func() {
+		c.report(fmt.Sprintf("remote %s delete %s", name, probeKey), bd.DeleteFile(ctx, probeKey))
+	}()
+	c.report(fmt.Sprintf("remote %s stat %s", name, probeKey), c.probeStat(ctx, bd, probeKey, int64(len(probeBody))))
+	c.report(fmt.Sprintf("remote %s read %s", name, probeKey), c.probeRead(ctx, bd, probeKey, probeBody))
+	c.report(fmt.Sprintf("remote %s list", name), c.probeList(ctx, bd, probeKey))
+}
+
+func (c *configChecker) probeStat(ctx context.Context, bd *storage.BackupDestination, key string, size int64) error {
+	file, err := bd.StatFile(ctx, key)
+	if err != nil {
+		return err
+	}
+	if file.Size() != size {
+		return fmt.Errorf("unexpected size %d, expected %d", file.Size(), size)
+	}
+	return nil
+}
+
+func (c *configChecker) probeRead(ctx context.Context, bd *storage.BackupDestination, key, body string) error {
+	reader, err := bd.GetFileReader(ctx, key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(reader)
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if string(data) != body {
+		return fmt.Errorf("read content doesn't match written content")
+	}
+	return nil
+}
+
+func (c *configChecker) probeList(ctx context.Context, bd *storage.BackupDestination, key string) error {
+	found := false
+	err := bd.Walk(ctx, "/", false, func(ctx context.Context, f storage.RemoteFile) error {
+		if path.Base(f.Name()) == key {
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s not found in list", key)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConfig(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(configPath, []byte("general:\n  remote_storage: none\ns3:\n  bucket: backup\n"), 0644))
+	out := &bytes.Buffer{}
+	require.NoError(t, CheckConfig(configPath, false, false, out))
+	assert.Contains(t, out.String(), "OK    unknown keys")
+	assert.NotContains(t, out.String(), "FAIL")
+
+	require.NoError(t, os.WriteFile(configPath, []byte("general:\n  remote_storage: none\n  log_levl: debug\ns3:\n  acces_key: key\nupload_mirrors:\n  - name: gcs\n    general:\n      remote_storage: gcs\n    gcs:\n      bukcet: backup\n"), 0644))
+	out.Reset()
+	err := CheckConfig(configPath, false, false, out)
+	assert.ErrorContains(t, err, "check-config found")
+	assert.Contains(t, out.String(), "`general.log_levl` is not supported")
+	assert.Contains(t, out.String(), "`s3.acces_key` is not supported")
+	assert.Contains(t, out.String(), "`upload_mirrors[0].gcs.bukcet` is not supported")
+
+	require.NoError(t, os.WriteFile(configPath, []byte("general:\n  remote_storage: unknown\n"), 0644))
+	out.Reset()
+	assert.Error(t, CheckConfig(configPath, false, false, out))
+	assert.Contains(t, out.String(), "FAIL  config values")
+
+	out.Reset()
+	assert.Error(t, CheckConfig(path.Join(t.TempDir(), "absent.yml"), false, false, out))
+	assert.Contains(t, out.String(), "FAIL  config file")
+}
@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+)
+
+// isClassicBackupTable - db.table matched with clickhouse->classic_backup_tables, such table is backed up with FREEZE instead of BACKUP even when `use_embedded_backup_restore: true`
+func (b *Backuper) isClassicBackupTable(database, table string) bool {
+	tableName := fmt.Sprintf("%s.%s", database, table)
+	for _, pattern := range b.cfg.ClickHouse.ClassicBackupTables {
+		if matched, _ := filepath.Match(strings.Trim(pattern, " \t\r\n"), tableName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isClassicBackup - true when all tables selected for backup matched clickhouse->classic_backup_tables, one backup contains data only in one format,
+// so tables which matched and tables which didn't match shall be split into separate backups with `--tables`
+func (b *Backuper) isClassicBackup(tables []clickhouse.Table) (bool, error) {
+	if !b.cfg.ClickHouse.UseEmbeddedBackupRestore || len(b.cfg.ClickHouse.ClassicBackupTables) == 0 {
+		return false, nil
+	}
+	classicTables := make([]string, 0)
+	embeddedTables := make([]string, 0)
+	for _, table := range tables {
+		if table.Skip {
+			continue
+		}
+		tableName := fmt.Sprintf("%s.%s", table.Database, table.Name)
+		if b.isClassicBackupTable(table.Database, table.Name) {
+			classicTables = append(classicTables, tableName)
+		} else {
+			embeddedTables = append(embeddedTables, tableName)
+		}
+	}
+	if len(classicTables) > 0 && len(embeddedTables) > 0 {
+		return false, fmt.Errorf("clickhouse->classic_backup_tables matched %s, but %s require `use_embedded_backup_restore: true`, one backup can't mix FREEZE and BACKUP, use --tables to create separate backups", strings.Join(classicTables, ","), strings.Join(embeddedTables, ","))
+	}
+	return len(classicTables) > 0, nil
+}
+
+// useClassicBackup - switch current operation to FREEZE based backup, config is copied because it could be shared with other operations of API server
+func (b *Backuper) useClassicBackup() {
+	cfg := *b.cfg
+	cfg.ClickHouse.UseEmbeddedBackupRestore = false
+	b.cfg = &cfg
+	b.ch.Config = &b.cfg.ClickHouse
+}
@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassicBackupTables(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ClickHouse.UseEmbeddedBackupRestore = true
+	cfg.ClickHouse.ClassicBackupTables = []string{"logs.*", " db.events_* "}
+	b := &Backuper{cfg: cfg, ch: &clickhouse.ClickHouse{Config: &cfg.ClickHouse}, log: apexLog.WithField("logger", "test")}
+
+	isClassic, err := b.isClassicBackup([]clickhouse.Table{{Database: "logs", Name: "access"}, {Database: "db", Name: "events_local"}, {Database: "db", Name: "t1", Skip: true}})
+	require.NoError(t, err)
+	assert.True(t, isClassic)
+
+	isClassic, err = b.isClassicBackup([]clickhouse.Table{{Database: "db", Name: "t1"}, {Database: "db", Name: "events_local", Skip: true}})
+	require.NoError(t, err)
+	assert.False(t, isClassic)
+
+	_, err = b.isClassicBackup([]clickhouse.Table{{Database: "logs", Name: "access"}, {Database: "db", Name: "t1"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "logs.access")
+	assert.Contains(t, err.Error(), "db.t1")
+
+	b.useClassicBackup()
+	assert.False(t, b.cfg.ClickHouse.UseEmbeddedBackupRestore)
+	assert.False(t, b.ch.Config.UseEmbeddedBackupRestore)
+	assert.True(t, cfg.ClickHouse.UseEmbeddedBackupRestore, "shared config shall not be changed")
+	isClassic, err = b.isClassicBackup([]clickhouse.Table{{Database: "logs", Name: "access"}})
+	require.NoError(t, err)
+	assert.False(t, isClassic, "without `use_embedded_backup_restore: true` all backups are classic")
+}
@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+
+	apexLog "github.com/apex/log"
+)
+
+// orphanedShadow - item in `shadow` folder of one disk, FREEZE WITH NAME creates `shadow/<name>` on each disk where table has parts
+type orphanedShadow struct {
+	name    string
+	disk    clickhouse.Disk
+	path    string
+	modTime time.Time
+	// reclaimBytes - size of files which are not hardlinked with active parts anymore, removing of other files doesn't free disk space
+	reclaimBytes uint64
+}
+
+var freezeNameRE = regexp.MustCompile(`^[a-zA-Z0-9_.\-]+$`)
+
+// CleanOrphaned - remove items in `shadow` folders left by crashed or killed commands and report reclaimed disk space,
+// freezes on object disks are released via SYSTEM UNFREEZE, removing of local metadata files only would keep frozen objects in object storage forever
+func (b *Backuper) CleanOrphaned(ctx context.Context) error {
+	log := b.log.WithField("logger", "CleanOrphaned")
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		return err
+	}
+	orphans, err := b.findOrphanedShadow(ctx, disks)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		log.Infof("orphaned items in shadow folders not found, items modified during last orphaned_shadow_min_age=%s are skipped", b.cfg.General.OrphanedShadowMinAge)
+		return nil
+	}
+	var totalBytes uint64
+	unfrozen := map[string]struct{}{}
+	for _, orphan := range orphans {
+		orphanLog := log.WithFields(apexLog.Fields{
+			"path": orphan.path,
+			"age":  utils.HumanizeDuration(time.Since(orphan.modTime)),
+			"size": utils.FormatBytes(orphan.reclaimBytes),
+		})
+		if b.dryRun {
+			totalBytes += orphan.reclaimBytes
+			orphanLog.Info("dry-run, will delete orphaned")
+			continue
+		}
+		if orphan.disk.Type == "s3" || orphan.disk.Type == "azure_blob_storage" {
+			if _, isUnfrozen := unfrozen[orphan.name]; !isUnfrozen {
+				if !freezeNameRE.MatchString(orphan.name) {
+					orphanLog.Warnf("can't SYSTEM UNFREEZE, unexpected freeze name, keep it to avoid leak of frozen objects on %s disk", orphan.disk.Type)
+					continue
+				}
+				if err = b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM UNFREEZE WITH NAME '%s'", orphan.name)); err != nil {
+					orphanLog.Warnf("can't SYSTEM UNFREEZE, keep it to avoid leak of frozen objects on %s disk, enable `enable_system_unfreeze` in clickhouse-server config and repeat: %v", orphan.disk.Type, err)
+					continue
+				}
+				unfrozen[orphan.name] = struct{}{}
+			}
+		}
+		// SYSTEM UNFREEZE already removed `shadow/<name>` on all disks
+		if err = os.RemoveAll(orphan.path); err != nil {
+			return fmt.Errorf("can't remove %s: %v", orphan.path, err)
+		}
+		totalBytes += orphan.reclaimBytes
+		orphanLog.Info("orphaned removed")
+	}
+	if b.dryRun {
+		log.WithField("reclaimable", utils.FormatBytes(totalBytes)).Warnf("dry-run, found %d orphaned items in shadow folders, run `clickhouse-backup clean --orphaned` to remove them", len(orphans))
+		return nil
+	}
+	log.WithField("reclaimed", utils.FormatBytes(totalBytes)).Info("done")
+	return nil
+}
+
+// findOrphanedShadow - `create` removes own `shadow/<name>` right after moving frozen parts of each table into backup, so items which were not modified during
+// general->orphaned_shadow_min_age are left by crashed or killed commands, items with the same name as local backup are kept, they could be created by `ALTER TABLE ... FREEZE WITH NAME` for this backup
+func (b *Backuper) findOrphanedShadow(ctx context.Context, disks []clickhouse.Disk) ([]orphanedShadow, error) {
+	localBackups, _, err := b.GetLocalBackups(ctx, disks)
+	if err != nil {
+		return nil, err
+	}
+	knownNames := make(map[string]struct{}, len(localBackups))
+	for _, backup := range localBackups {
+		knownNames[backup.BackupName] = struct{}{}
+	}
+	orphans := make([]orphanedShadow, 0)
+	for _, disk := range disks {
+		if disk.IsBackup {
+			continue
+		}
+		shadowDir := path.Join(disk.Path, "shadow")
+		items, err := os.ReadDir(shadowDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, item := range items {
+			if !item.IsDir() {
+				continue
+			}
+			if _, isKnown := knownNames[item.Name()]; isKnown {
+				continue
+			}
+			info, err := item.Info()
+			if err != nil {
+				return nil, err
+			}
+			if time.Since(info.ModTime()) < b.cfg.General.OrphanedShadowMinAgeDuration {
+				continue
+			}
+			orphan := orphanedShadow{
+				name:    item.Name(),
+				disk:    disk,
+				path:    path.Join(shadowDir, item.Name()),
+				modTime: info.ModTime(),
+			}
+			if err = filepath.Walk(orphan.path, func(_ string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() && filesystemhelper.GetFileLinks(info) <= 1 {
+					orphan.reclaimBytes += uint64(info.Size())
+				}
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+			orphans = append(orphans, orphan)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].modTime.Before(orphans[j].modTime)
+	})
+	return orphans, nil
+}
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindOrphanedShadow(t *testing.T) {
+	diskPath := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	for _, dir := range []string{"shadow/crashed/data/db/table/all_1_1_0", "shadow/running/data/db/table/all_1_1_0", "shadow/my_backup", "backup/my_backup", "store/all_1_1_0"} {
+		require.NoError(t, os.MkdirAll(path.Join(diskPath, dir), 0750))
+	}
+	require.NoError(t, os.WriteFile(path.Join(diskPath, "backup/my_backup/metadata.json"), []byte(`{"backup_name":"my_backup"}`), 0640))
+	crashedPart := path.Join(diskPath, "shadow/crashed/data/db/table/all_1_1_0")
+	require.NoError(t, os.WriteFile(path.Join(crashedPart, "merged.bin"), make([]byte, 100), 0640))
+	// file which is still hardlinked with active part doesn't free space after remove
+	require.NoError(t, os.WriteFile(path.Join(diskPath, "store/all_1_1_0/active.bin"), make([]byte, 1000), 0640))
+	require.NoError(t, os.Link(path.Join(diskPath, "store/all_1_1_0/active.bin"), path.Join(crashedPart, "active.bin")))
+	require.NoError(t, os.WriteFile(path.Join(diskPath, "shadow/increment.txt"), []byte("1"), 0640))
+	for _, name := range []string{"crashed", "my_backup"} {
+		require.NoError(t, os.Chtimes(path.Join(diskPath, "shadow", name), old, old))
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.General.OrphanedShadowMinAgeDuration = 24 * time.Hour
+	b := &Backuper{cfg: cfg, ch: &clickhouse.ClickHouse{IsOpen: true}, log: apexLog.WithField("logger", "test")}
+	orphans, err := b.findOrphanedShadow(context.Background(), []clickhouse.Disk{{Name: "default", Path: diskPath, Type: "local"}})
+	require.NoError(t, err)
+	require.Len(t, orphans, 1, "recent items, files and items with local backup name shall be kept")
+	assert.Equal(t, "crashed", orphans[0].name)
+	assert.Equal(t, path.Join(diskPath, "shadow/crashed"), orphans[0].path)
+	assert.Equal(t, uint64(100), orphans[0].reclaimBytes)
+}
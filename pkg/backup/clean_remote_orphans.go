@@ -0,0 +1,216 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+
+	apexLog "github.com/apex/log"
+)
+
+// remoteOrphan - objects under one `<backup_name>` or `<path_prefix>/<backup_name>` prefix which don't belong to any backup with metadata.json
+type remoteOrphan struct {
+	prefix       string
+	objects      []string
+	size         uint64
+	lastModified time.Time
+}
+
+// CleanRemoteOrphans - delete remote objects which are not referenced by any backup manifest, like data of failed uploads without metadata.json,
+// confirm is called with summary before delete, nil means don't ask
+func (b *Backuper) CleanRemoteOrphans(dryRun bool, confirm func(action string) error, commandId int) error {
+	b.setCommandLog(commandId)
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("clean_remote --orphans doesn't support remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	log := b.log.WithField("logger", "CleanRemoteOrphans")
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	orphans, err := b.findRemoteOrphans(ctx, bd)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		log.Infof("orphaned objects not found, objects modified during last orphaned_remote_min_age=%s are skipped", b.cfg.General.OrphanedRemoteMinAge)
+		return nil
+	}
+	var totalBytes, totalObjects uint64
+	for _, orphan := range orphans {
+		totalBytes += orphan.size
+		totalObjects += uint64(len(orphan.objects))
+		log.WithFields(apexLog.Fields{
+			"prefix":        orphan.prefix,
+			"objects":       len(orphan.objects),
+			"size":          utils.FormatBytes(orphan.size),
+			"last_modified": orphan.lastModified.Format(time.RFC3339),
+		}).Info("orphaned")
+	}
+	if dryRun {
+		log.WithField("size", utils.FormatBytes(totalBytes)).Infof("dry-run, will delete %d orphaned objects", totalObjects)
+		return nil
+	}
+	if confirm != nil {
+		if err = confirm(fmt.Sprintf("delete %d orphaned objects with %s from remote storage", totalObjects, utils.FormatBytes(totalBytes))); err != nil {
+			return err
+		}
+	}
+	for _, orphan := range orphans {
+		if err = b.deleteRemoteOrphan(ctx, bd, orphan); err != nil {
+			return err
+		}
+	}
+	log.WithField("size", utils.FormatBytes(totalBytes)).Infof("done, deleted %d orphaned objects", totalObjects)
+	return nil
+}
+
+// findRemoteOrphans - walk all objects in remote storage path, see remoteOrphanFinder
+func (b *Backuper) findRemoteOrphans(ctx context.Context, bd *storage.BackupDestination) ([]remoteOrphan, error) {
+	backupList, err := bd.BackupList(ctx, true, "")
+	if err != nil {
+		return nil, err
+	}
+	finder := newRemoteOrphanFinder(b.cfg, backupList)
+	err = bd.Walk(ctx, "/", true, func(ctx context.Context, f storage.RemoteFile) error {
+		if bd.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
+			return nil
+		}
+		finder.add(strings.TrimPrefix(f.Name(), "/"), f.Size(), f.LastModified())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return finder.orphans(b.cfg.General.OrphanedRemoteMinAgeDuration, b.log), nil
+}
+
+// remoteOrphanFinder - objects of backups with metadata.json, backups from their incremental chains, dedup chunks and cluster manifests are referenced,
+// other objects are grouped by `<backup_name>` or `<path_prefix>/<backup_name>` prefix
+type remoteOrphanFinder struct {
+	// pathPrefixes - data of tables matched general->table_storage_rules is placed into `<path_prefix>/<backup_name>`
+	pathPrefixes    map[string]struct{}
+	referencedNames map[string]struct{}
+	orphansByPrefix map[string]*remoteOrphan
+}
+
+func newRemoteOrphanFinder(cfg *config.Config, backupList []storage.Backup) *remoteOrphanFinder {
+	finder := &remoteOrphanFinder{
+		pathPrefixes:    make(map[string]struct{}),
+		referencedNames: make(map[string]struct{}),
+		orphansByPrefix: make(map[string]*remoteOrphan),
+	}
+	for _, rule := range cfg.General.TableStorageRules {
+		if rule.PathPrefix != "" {
+			finder.pathPrefixes[strings.Trim(rule.PathPrefix, "/")] = struct{}{}
+		}
+	}
+	for _, backup := range backupList {
+		if backup.Broken != "" {
+			continue
+		}
+		for _, pathPrefix := range backup.RemotePathPrefixes {
+			finder.pathPrefixes[pathPrefix] = struct{}{}
+		}
+		finder.referencedNames[backup.BackupName] = struct{}{}
+		if backup.Legacy {
+			finder.referencedNames[backup.BackupName+"."+backup.FileExtension] = struct{}{}
+		}
+		// parts of incremental backup could be stored in any backup from chain, even when metadata.json of required backup was lost
+		if backup.RequiredBackup != "" {
+			finder.referencedNames[backup.RequiredBackup] = struct{}{}
+		}
+		for _, requiredBackup := range backup.IncrementalChain {
+			finder.referencedNames[requiredBackup] = struct{}{}
+		}
+	}
+	return finder
+}
+
+func (finder *remoteOrphanFinder) add(name string, size int64, lastModified time.Time) {
+	backupName := strings.SplitN(name, "/", 2)[0]
+	prefix := backupName
+	for pathPrefix := range finder.pathPrefixes {
+		if strings.HasPrefix(name, pathPrefix+"/") {
+			backupName = strings.SplitN(strings.TrimPrefix(name, pathPrefix+"/"), "/", 2)[0]
+			prefix = path.Join(pathPrefix, backupName)
+			break
+		}
+	}
+	if backupName == storage.DedupChunksPrefix || backupName == storage.ClusterManifestsPrefix {
+		return
+	}
+	if _, isReferenced := finder.referencedNames[backupName]; isReferenced {
+		return
+	}
+	orphan, exists := finder.orphansByPrefix[prefix]
+	if !exists {
+		orphan = &remoteOrphan{prefix: prefix}
+		finder.orphansByPrefix[prefix] = orphan
+	}
+	orphan.objects = append(orphan.objects, name)
+	orphan.size += uint64(size)
+	if lastModified.After(orphan.lastModified) {
+		orphan.lastModified = lastModified
+	}
+}
+
+// orphans - prefixes modified during minAge are skipped, they could belong to running upload
+func (finder *remoteOrphanFinder) orphans(minAge time.Duration, log *apexLog.Entry) []remoteOrphan {
+	orphans := make([]remoteOrphan, 0, len(finder.orphansByPrefix))
+	for _, orphan := range finder.orphansByPrefix {
+		if time.Since(orphan.lastModified) < minAge {
+			log.WithField("prefix", orphan.prefix).Debugf("skip orphaned, modified at %s, could belong to running upload", orphan.lastModified.Format(time.RFC3339))
+			continue
+		}
+		orphans = append(orphans, *orphan)
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].prefix < orphans[j].prefix
+	})
+	return orphans
+}
+
+// deleteRemoteOrphan - SFTP, FTP and rclone remove prefix recursively, object storages delete each object
+func (b *Backuper) deleteRemoteOrphan(ctx context.Context, bd *storage.BackupDestination, orphan remoteOrphan) error {
+	if bd.Kind() == "SFTP" || bd.Kind() == "FTP" || bd.Kind() == "RCLONE" {
+		if err := bd.DeleteFile(ctx, orphan.prefix); err != nil {
+			return fmt.Errorf("can't delete %s: %v", orphan.prefix, err)
+		}
+		return nil
+	}
+	for _, object := range orphan.objects {
+		if err := bd.DeleteFile(ctx, object); err != nil {
+			return fmt.Errorf("can't delete %s: %v", object, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteOrphanFinder(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.TableStorageRules = []config.TableStorageRule{{Tables: "logs.*", PathPrefix: "/cold/"}}
+	backupList := []storage.Backup{
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "full", RemotePathPrefixes: []string{"cold"}}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "increment", RequiredBackup: "lost_full", IncrementalChain: []string{"lost_full", "lost_base"}}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "failed"}, Broken: "broken (can't stat metadata.json)"},
+	}
+	finder := newRemoteOrphanFinder(cfg, backupList)
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{
+		"full/metadata.json", "full/shadow/db/table/default_all_1_1_0.tar", "cold/full/shadow/logs/t/default_all_1_1_0.tar",
+		"increment/metadata.json", "lost_full/shadow/db/table/default_all_1_1_0.tar", "lost_base/shadow/db/table/default_all_2_2_0.tar",
+		".chunks/ab/abcdef", ".cluster/full.json",
+		"failed/shadow/db/table/default_all_1_1_0.tar", "failed/shadow/db/table/default_all_2_2_0.tar", "cold/failed/shadow/logs/t/default_all_1_1_0.tar",
+	} {
+		finder.add(name, 10, old)
+	}
+	finder.add("running/shadow/db/table/default_all_1_1_0.tar", 10, old)
+	finder.add("running/upload.state", 10, time.Now())
+
+	orphans := finder.orphans(24*time.Hour, apexLog.WithField("logger", "test"))
+	assert.Equal(t, []remoteOrphan{
+		{prefix: "cold/failed", objects: []string{"cold/failed/shadow/logs/t/default_all_1_1_0.tar"}, size: 10, lastModified: old},
+		{prefix: "failed", objects: []string{"failed/shadow/db/table/default_all_1_1_0.tar", "failed/shadow/db/table/default_all_2_2_0.tar"}, size: 20, lastModified: old},
+	}, orphans, "objects of incremental chain, dedup chunks, cluster manifests and recently modified prefixes shall be kept")
+}
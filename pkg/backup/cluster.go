@@ -0,0 +1,294 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	apexLog "github.com/apex/log"
+)
+
+// clusterShardCommand - command for API of clickhouse-backup server on replica, replica of current host runs in-process to avoid lock of own API
+type clusterShardCommand struct {
+	apiCommand func(shardNum uint32) string
+	local      func(ctx context.Context, shardNum uint32) error
+}
+
+// clusterAPIError - response with not 200 status code
+type clusterAPIError struct {
+	statusCode int
+	message    string
+}
+
+func (e *clusterAPIError) Error() string {
+	return e.message
+}
+
+type clusterAPIClient struct {
+	client   *http.Client
+	scheme   string
+	port     int
+	username string
+	password string
+	token    string
+}
+
+// getClusterShards - replicas of each shard from system.clusters, cluster->name supports macros
+func (b *Backuper) getClusterShards(ctx context.Context) (string, map[uint32][]clickhouse.ClusterReplica, error) {
+	cluster, err := b.ch.ApplyMacros(ctx, b.cfg.Cluster.Name)
+	if err != nil {
+		return "", nil, err
+	}
+	if cluster == "" || strings.Contains(cluster, "{") {
+		replica, err := b.ch.GetLocalReplica(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		if replica == nil {
+			return "", nil, fmt.Errorf("can't resolve cluster->name: `%s`, define it explicitly", b.cfg.Cluster.Name)
+		}
+		cluster = replica.Cluster
+	}
+	replicas, err := b.ch.GetClusterReplicas(ctx, cluster)
+	if err != nil {
+		return "", nil, err
+	}
+	shards := make(map[uint32][]clickhouse.ClusterReplica)
+	for _, replica := range replicas {
+		shards[replica.ShardNum] = append(shards[replica.ShardNum], replica)
+	}
+	return cluster, shards, nil
+}
+
+func (b *Backuper) newClusterAPIClient() (*clusterAPIClient, error) {
+	c := &clusterAPIClient{
+		client:   &http.Client{Timeout: 1 * time.Minute},
+		scheme:   "http",
+		port:     b.cfg.Cluster.APIPort,
+		username: b.cfg.API.Username,
+		password: b.cfg.API.Password,
+		token:    b.cfg.Cluster.APIToken,
+	}
+	if c.token == "" {
+		c.token = clusterAPIToken(b.cfg.API.Tokens)
+	}
+	if b.cfg.Cluster.APISecure {
+		c.scheme = "https"
+		tlsConfig := &tls.Config{InsecureSkipVerify: b.cfg.Cluster.InsecureSkipVerify}
+		if b.cfg.Cluster.CACert != "" {
+			caCert, err := os.ReadFile(b.cfg.Cluster.CACert)
+			if err != nil {
+				return nil, fmt.Errorf("can't read cluster->ca_cert: %v", err)
+			}
+			rootCAs, err := x509.SystemCertPool()
+			if err != nil || rootCAs == nil {
+				rootCAs = x509.NewCertPool()
+			}
+			if !rootCAs.AppendCertsFromPEM(caCert) {
+				return nil, fmt.Errorf("can't parse PEM certificates from cluster->ca_cert: %s", b.cfg.Cluster.CACert)
+			}
+			tlsConfig.RootCAs = rootCAs
+		}
+		c.client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+	}
+	return c, nil
+}
+
+// clusterAPIToken - token with the highest role from api->tokens, tokens shall be the same on all hosts like api->username and api->password
+func clusterAPIToken(tokens map[string]string) string {
+	token := ""
+	for candidate, role := range tokens {
+		if token == "" || config.APIRoleLevel(role) > config.APIRoleLevel(tokens[token]) || (config.APIRoleLevel(role) == config.APIRoleLevel(tokens[token]) && candidate < token) {
+			token = candidate
+		}
+	}
+	return token
+}
+
+func (c *clusterAPIClient) do(ctx context.Context, method, host, uri string, body []byte) ([]byte, error) {
+	url := fmt.Sprintf("%s://%s%s", c.scheme, net.JoinHostPort(host, strconv.Itoa(c.port)), uri)
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &clusterAPIError{statusCode: resp.StatusCode, message: fmt.Sprintf("%s %s return %d: %s", method, url, resp.StatusCode, strings.TrimSpace(string(respBody)))}
+	}
+	return respBody, nil
+}
+
+// startCommand - POST /backup/actions, return job_id for async command
+func (c *clusterAPIClient) startCommand(ctx context.Context, host, command string) (int, error) {
+	body, err := json.Marshal(status.ActionRowStatus{Command: command})
+	if err != nil {
+		return 0, err
+	}
+	respBody, err := c.do(ctx, http.MethodPost, host, "/backup/actions", body)
+	if err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(respBody))
+	for scanner.Scan() {
+		row := struct {
+			Status string `json:"status"`
+			JobId  *int   `json:"job_id"`
+		}{}
+		if err = json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return 0, fmt.Errorf("can't parse response %s: %v", scanner.Text(), err)
+		}
+		if row.JobId != nil {
+			return *row.JobId, nil
+		}
+	}
+	return 0, fmt.Errorf("job_id not found in response `%s`, upgrade clickhouse-backup on %s", strings.TrimSpace(string(respBody)), host)
+}
+
+// waitCommand - poll GET /backup/actions/{job_id} until command finished
+func (c *clusterAPIClient) waitCommand(ctx context.Context, host string, jobId int, pollInterval time.Duration) (status.JobStatus, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	var jobStatus status.JobStatus
+	for {
+		select {
+		case <-ctx.Done():
+			return jobStatus, ctx.Err()
+		case <-ticker.C:
+			respBody, err := c.do(ctx, http.MethodGet, host, fmt.Sprintf("/backup/actions/%d", jobId), nil)
+			if err != nil {
+				// 4xx will not change on retry, unknown job_id means server was restarted and command status lost
+				var apiErr *clusterAPIError
+				if errors.As(err, &apiErr) && apiErr.statusCode >= 400 && apiErr.statusCode < 500 {
+					return jobStatus, fmt.Errorf("can't get status of job_id=%d: %v", jobId, err)
+				}
+				// server could be unavailable for a while, timeout limit total duration
+				apexLog.WithField("host", host).Warnf("can't get status of job_id=%d: %v", jobId, err)
+				continue
+			}
+			if err = json.Unmarshal(bytes.TrimSpace(respBody), &jobStatus); err != nil {
+				return jobStatus, fmt.Errorf("can't parse job status %s: %v", string(respBody), err)
+			}
+			if jobStatus.Status != status.InProgressStatus {
+				return jobStatus, nil
+			}
+		}
+	}
+}
+
+// runOnEachShard - run command on one replica of each shard in parallel, next replica tried only when previous can't start command,
+// failed command is not retried on another replica, because it could already create partial backup with the same name
+func (b *Backuper) runOnEachShard(ctx context.Context, shards map[uint32][]clickhouse.ClusterReplica, cmd clusterShardCommand) ([]metadata.ClusterShardBackup, error) {
+	apiClient, err := b.newClusterAPIClient()
+	if err != nil {
+		return nil, err
+	}
+	pollInterval, err := time.ParseDuration(b.cfg.Cluster.PollInterval)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := time.ParseDuration(b.cfg.Cluster.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	shardNums := make([]uint32, 0, len(shards))
+	for shardNum := range shards {
+		shardNums = append(shardNums, shardNum)
+	}
+	sort.Slice(shardNums, func(i, j int) bool { return shardNums[i] < shardNums[j] })
+	results := make([]metadata.ClusterShardBackup, len(shardNums))
+	wg := sync.WaitGroup{}
+	for i, shardNum := range shardNums {
+		wg.Add(1)
+		go func(i int, shardNum uint32) {
+			defer wg.Done()
+			results[i] = b.runOnShard(ctx, apiClient, pollInterval, shardNum, shards[shardNum], cmd)
+		}(i, shardNum)
+	}
+	wg.Wait()
+	failed := make([]string, 0)
+	for _, result := range results {
+		if result.Status != status.SuccessStatus {
+			failed = append(failed, fmt.Sprintf("shard %d on %s: %s", result.ShardNum, result.Host, result.Error))
+		}
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%d of %d shards failed: %s", len(failed), len(results), strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+func (b *Backuper) runOnShard(ctx context.Context, apiClient *clusterAPIClient, pollInterval time.Duration, shardNum uint32, replicas []clickhouse.ClusterReplica, cmd clusterShardCommand) metadata.ClusterShardBackup {
+	result := metadata.ClusterShardBackup{ShardNum: shardNum, Status: status.ErrorStatus}
+	for _, replica := range replicas {
+		log := b.log.WithFields(apexLog.Fields{"shard": shardNum, "host": replica.HostName})
+		result.ReplicaNum = replica.ReplicaNum
+		result.Host = replica.HostName
+		result.Start = time.Now().Format(common.TimeFormat)
+		if replica.IsLocal == 1 {
+			log.Info("run on current host")
+			if err := cmd.local(ctx, shardNum); err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Status = status.SuccessStatus
+			}
+			result.Finish = time.Now().Format(common.TimeFormat)
+			return result
+		}
+		command := cmd.apiCommand(shardNum)
+		jobId, err := apiClient.startCommand(ctx, replica.HostName, command)
+		if err != nil {
+			log.Warnf("can't start `%s`, try next replica: %v", command, err)
+			result.Error = err.Error()
+			continue
+		}
+		log.Infof("`%s` started with job_id=%d", command, jobId)
+		jobStatus, err := apiClient.waitCommand(ctx, replica.HostName, jobId, pollInterval)
+		result.Finish = time.Now().Format(common.TimeFormat)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Status = jobStatus.Status
+		result.Error = jobStatus.Error
+		log.Infof("`%s` finished with status %s", command, jobStatus.Status)
+		return result
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterWaitCommandUnknownJob(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"status":"error","operation":"actions","error":"job_id=1 not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host, port, err := net.SplitHostPort(serverURL.Host)
+	require.NoError(t, err)
+	apiPort, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	c := &clusterAPIClient{client: server.Client(), scheme: "http", port: apiPort}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = c.waitCommand(ctx, host, 1, time.Millisecond)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, context.DeadlineExceeded, "unknown job_id shall fail without waiting timeout")
+	assert.Contains(t, err.Error(), "404")
+}
+
+func TestClusterAPIClientAuthorization(t *testing.T) {
+	authorization := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host, port, err := net.SplitHostPort(serverURL.Host)
+	require.NoError(t, err)
+	apiPort, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	c := &clusterAPIClient{client: server.Client(), scheme: "http", port: apiPort, username: "user", password: "pass"}
+	_, err = c.do(context.Background(), http.MethodGet, host, "/backup/status", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Basic dXNlcjpwYXNz", authorization)
+
+	c.token = clusterAPIToken(map[string]string{"monitoring-token": config.APIRoleReadOnly, "operator-token": config.APIRoleOperator, "admin-token": config.APIRoleAdmin})
+	assert.Equal(t, "admin-token", c.token)
+	_, err = c.do(context.Background(), http.MethodGet, host, "/backup/status", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer admin-token", authorization)
+}
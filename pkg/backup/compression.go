@@ -0,0 +1,76 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	apexLog "github.com/apex/log"
+)
+
+// zstdDictionaryFile - dictionary uploaded together with backup, so download doesn't depend on current general->zstd_dictionary
+const zstdDictionaryFile = "zstd.dict"
+
+// getTableCompressionLevel - general->compression_level_by_table_size allow faster level for big tables, the biggest threshold which less or equal table size wins
+func (b *Backuper) getTableCompressionLevel(tableSize uint64) int {
+	level := b.cfg.GetCompressionLevel()
+	threshold := int64(-1)
+	for size, tierLevel := range b.cfg.General.CompressionLevelTiers {
+		if size <= int64(tableSize) && size > threshold {
+			threshold, level = size, tierLevel
+		}
+	}
+	return level
+}
+
+// setZstdDictionaryForUpload - return empty dictionary when general->zstd_dictionary is not set or compression_format is not zstd
+func (b *Backuper) setZstdDictionaryForUpload(log *apexLog.Entry) ([]byte, error) {
+	if b.cfg.General.ZstdDictionary == "" || b.cfg.GetCompressionFormat() != "zstd" || b.cfg.General.DedupStore {
+		return nil, nil
+	}
+	dictionary, err := os.ReadFile(b.cfg.General.ZstdDictionary)
+	if err != nil {
+		return nil, fmt.Errorf("can't read zstd_dictionary: %v", err)
+	}
+	dictionaryId, err := storage.ValidateZstdDictionary(dictionary)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("use zstd dictionary %s with ID %d for archives smaller than 1MiB", b.cfg.General.ZstdDictionary, dictionaryId)
+	b.dst.SetZstdDictionary(dictionary)
+	return dictionary, nil
+}
+
+// loadZstdDictionaries - download by part read archives of required backups too, so dictionaries of whole incremental chain are loaded
+func (b *Backuper) loadZstdDictionaries(ctx context.Context, backup *metadata.BackupMetadata) error {
+	for backup != nil && backup.DataFormat == "zstd" {
+		if backup.ZstdDictionary != "" {
+			dictionaryFile := path.Join(backup.BackupName, backup.ZstdDictionary)
+			r, err := b.dst.GetFileReader(ctx, dictionaryFile)
+			if err != nil {
+				return fmt.Errorf("can't open %s: %v", dictionaryFile, err)
+			}
+			dictionary, err := io.ReadAll(r)
+			if closeErr := r.Close(); closeErr != nil {
+				b.log.Warnf("can't close %s: %v", dictionaryFile, closeErr)
+			}
+			if err != nil {
+				return fmt.Errorf("can't read %s: %v", dictionaryFile, err)
+			}
+			b.dst.AddZstdDecoderDictionary(dictionary)
+		}
+		if backup.RequiredBackup == "" {
+			return nil
+		}
+		requiredBackup, err := b.ReadBackupMetadataRemote(ctx, backup.RequiredBackup)
+		if err != nil {
+			return fmt.Errorf("can't read required backup %s metadata for zstd dictionary: %v", backup.RequiredBackup, err)
+		}
+		backup = requiredBackup
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+
+	apexLog "github.com/apex/log"
+	"github.com/eapache/go-resiliency/retrier"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// copyRemoteObject - object key relative to dataPath, dataPath is backup root or `<path_prefix>/<backup_name>` for general->table_storage_rules
+type copyRemoteObject struct {
+	dataPath string
+	name     string
+	size     int64
+}
+
+// CopyRemote - copy remote backup from one remote storage to another, general->remote_storage and `upload_mirrors` items addressed by name,
+// objects are copied as is, server side for the same provider and through local host otherwise, objects which already copied with the same size are skipped,
+// so interrupted copy could be restarted, metadata.json copied last, so incomplete backup is shown as broken on destination
+func (b *Backuper) CopyRemote(backupName, from, to string, commandId int) error {
+	b.setCommandLog(commandId)
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	if from == to {
+		return fmt.Errorf("--from and --to shall be different remote storages")
+	}
+	srcCfg, err := b.cfg.GetRemoteConfig(from)
+	if err != nil {
+		return fmt.Errorf("--from: %v", err)
+	}
+	dstCfg, err := b.cfg.GetRemoteConfig(to)
+	if err != nil {
+		return fmt.Errorf("--to: %v", err)
+	}
+	for _, remoteCfg := range []*config.Config{srcCfg, dstCfg} {
+		if remoteCfg.General.RemoteStorage == "none" || remoteCfg.General.RemoteStorage == "custom" {
+			return fmt.Errorf("copy-remote doesn't support remote_storage: %s", remoteCfg.General.RemoteStorage)
+		}
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	log := b.log.WithField("logger", "CopyRemote").WithField("backup", backupName)
+	start := time.Now()
+
+	src, err := b.connectCopyRemoteDestination(ctx, srcCfg, backupName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := src.Close(ctx); err != nil {
+			log.Warnf("can't close source BackupDestination error: %v", err)
+		}
+	}()
+	dst, err := b.connectCopyRemoteDestination(ctx, dstCfg, backupName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := dst.Close(ctx); err != nil {
+			log.Warnf("can't close destination BackupDestination error: %v", err)
+		}
+	}()
+	if _, err = dst.StatFile(ctx, path.Join(backupName, "metadata.json")); err == nil {
+		return fmt.Errorf("'%s' %w on %s", backupName, ErrBackupIsAlreadyExists, to)
+	}
+
+	b.dst = src
+	remoteBackup, err := b.ReadBackupMetadataRemote(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(remoteBackup.Tags, "embedded") {
+		return fmt.Errorf("copy-remote doesn't support embedded backups")
+	}
+	if err = b.checkRemoteObjectDiskParts(ctx, remoteBackup); err != nil {
+		return err
+	}
+	// segments copied as separate objects, metadata.json keeps segment_size of source
+	src.SetSegmentSize(0)
+	dst.SetSegmentSize(0)
+
+	objects := make([]copyRemoteObject, 0)
+	var metadataObject *copyRemoteObject
+	for _, dataPath := range storage.GetRemoteBackupDataPaths(*remoteBackup) {
+		err = src.Walk(ctx, dataPath+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+			if dataPath == backupName && f.Name() == "metadata.json" {
+				metadataObject = &copyRemoteObject{dataPath: dataPath, name: f.Name(), size: f.Size()}
+				return nil
+			}
+			objects = append(objects, copyRemoteObject{dataPath: dataPath, name: f.Name(), size: f.Size()})
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("can't list %s on %s: %v", dataPath, from, err)
+		}
+	}
+	if metadataObject == nil {
+		return fmt.Errorf("%s/metadata.json not found on %s", backupName, from)
+	}
+
+	serverSide := canCopyRemoteServerSide(srcCfg, dstCfg)
+	if serverSide {
+		// CopyObject writes into object_disk_path, backup objects shall be placed into path
+		switch dstCfg.General.RemoteStorage {
+		case "s3":
+			dstCfg.S3.ObjectDiskPath = dstCfg.S3.Path
+		case "gcs":
+			dstCfg.GCS.ObjectDiskPath = dstCfg.GCS.Path
+		}
+	}
+	copier := &remoteCopier{
+		src:        src,
+		dst:        dst,
+		srcCfg:     srcCfg,
+		serverSide: serverSide,
+		retryMax:   dstCfg.General.RetriesOnFailure,
+		retryPause: dstCfg.General.RetriesDuration,
+		log:        log,
+	}
+	concurrency := dstCfg.General.UploadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	copySemaphore := semaphore.NewWeighted(int64(concurrency))
+	copyGroup, copyCtx := errgroup.WithContext(ctx)
+	for _, object := range objects {
+		if err = copySemaphore.Acquire(copyCtx, 1); err != nil {
+			log.Errorf("can't acquire semaphore during copy-remote: %v", err)
+			break
+		}
+		object := object
+		copyGroup.Go(func() error {
+			defer copySemaphore.Release(1)
+			return copier.copy(copyCtx, object)
+		})
+	}
+	if groupErr := copyGroup.Wait(); groupErr != nil {
+		return fmt.Errorf("copy-remote %s from %s to %s failed: %v", backupName, from, to, groupErr)
+	}
+	if err != nil {
+		return err
+	}
+	if err = copier.copy(ctx, *metadataObject); err != nil {
+		return fmt.Errorf("copy-remote %s from %s to %s failed: %v", backupName, from, to, err)
+	}
+	log.WithFields(apexLog.Fields{
+		"from":     from,
+		"to":       to,
+		"objects":  len(objects) + 1,
+		"copied":   utils.FormatBytes(uint64(copier.copiedBytes)),
+		"skipped":  copier.skippedObjects,
+		"duration": utils.HumanizeDuration(time.Since(start)),
+	}).Info("done")
+	return nil
+}
+
+func (b *Backuper) connectCopyRemoteDestination(ctx context.Context, remoteCfg *config.Config, backupName string) (*storage.BackupDestination, error) {
+	bd, err := storage.NewBackupDestination(ctx, remoteCfg, b.ch, false, backupName)
+	if err != nil {
+		return nil, err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("can't connect to %s: %v", bd.Kind(), err)
+	}
+	return bd, nil
+}
+
+// canCopyRemoteServerSide - CopyObject executes by destination client, so source bucket shall be reachable with destination credentials
+func canCopyRemoteServerSide(srcCfg, dstCfg *config.Config) bool {
+	if srcCfg.General.RemoteStorage != dstCfg.General.RemoteStorage {
+		return false
+	}
+	switch srcCfg.General.RemoteStorage {
+	case "s3":
+		return srcCfg.S3.Endpoint == dstCfg.S3.Endpoint && srcCfg.S3.Region == dstCfg.S3.Region && srcCfg.S3.SSECustomerKey == ""
+	case "gcs":
+		return srcCfg.GCS.Endpoint == dstCfg.GCS.Endpoint && srcCfg.GCS.EncryptionKey == ""
+	}
+	return false
+}
+
+type remoteCopier struct {
+	src            *storage.BackupDestination
+	dst            *storage.BackupDestination
+	srcCfg         *config.Config
+	serverSide     bool
+	serverSideMx   sync.Mutex
+	retryMax       int
+	retryPause     time.Duration
+	copiedBytes    int64
+	skippedObjects int64
+	log            *apexLog.Entry
+}
+
+// copy - skip object which already present on destination with the same size, server side copy failure switch copier to copy through local host
+func (c *remoteCopier) copy(ctx context.Context, object copyRemoteObject) error {
+	key := path.Join(object.dataPath, object.name)
+	if dstFile, err := c.dst.StatFile(ctx, key); err == nil && dstFile.Size() == object.size {
+		atomic.AddInt64(&c.skippedObjects, 1)
+		c.log.Debugf("%s already copied, skip", key)
+		return nil
+	} else if err != nil && err != storage.ErrNotFound && !os.IsNotExist(err) {
+		c.log.Debugf("can't stat %s on destination, will copy: %v", key, err)
+	}
+	if c.isServerSide() {
+		srcBucket, srcKey := c.srcBucketAndKey(key)
+		_, err := c.dst.CopyObject(ctx, srcBucket, srcKey, key)
+		if err == nil {
+			atomic.AddInt64(&c.copiedBytes, object.size)
+			return nil
+		}
+		c.log.Warnf("server side copy %s failed, will copy through local host: %v", key, err)
+		c.serverSideMx.Lock()
+		c.serverSide = false
+		c.serverSideMx.Unlock()
+	}
+	retry := retrier.New(retrier.ConstantBackoff(c.retryMax, c.retryPause), nil)
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		reader, err := c.src.GetFileReader(ctx, key)
+		if err != nil {
+			return err
+		}
+		return c.dst.PutFile(ctx, key, &copyRemoteReader{ReadCloser: reader, copiedBytes: &c.copiedBytes})
+	})
+	if err != nil {
+		return fmt.Errorf("can't copy %s: %v", key, err)
+	}
+	return nil
+}
+
+func (c *remoteCopier) isServerSide() bool {
+	c.serverSideMx.Lock()
+	defer c.serverSideMx.Unlock()
+	return c.serverSide
+}
+
+func (c *remoteCopier) srcBucketAndKey(key string) (string, string) {
+	if c.srcCfg.General.RemoteStorage == "gcs" {
+		return c.srcCfg.GCS.Bucket, path.Join(c.srcCfg.GCS.Path, key)
+	}
+	return c.srcCfg.S3.Bucket, path.Join(c.srcCfg.S3.Path, key)
+}
+
+// copyRemoteReader - count bytes which really copied through local host, failed attempts also counted
+type copyRemoteReader struct {
+	io.ReadCloser
+	copiedBytes *int64
+}
+
+func (r *copyRemoteReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.copiedBytes, int64(n))
+	return n, err
+}
@@ -0,0 +1,30 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanCopyRemoteServerSide(t *testing.T) {
+	s3Cfg := config.DefaultConfig()
+	s3Cfg.General.RemoteStorage = "s3"
+	s3Cfg.S3.Region = "us-east-1"
+	otherBucketCfg := config.DefaultConfig()
+	otherBucketCfg.General.RemoteStorage = "s3"
+	otherBucketCfg.S3.Region = "us-east-1"
+	otherBucketCfg.S3.Bucket = "archive"
+	assert.True(t, canCopyRemoteServerSide(s3Cfg, otherBucketCfg))
+
+	otherBucketCfg.S3.Region = "eu-west-1"
+	assert.False(t, canCopyRemoteServerSide(s3Cfg, otherBucketCfg))
+
+	gcsCfg := config.DefaultConfig()
+	gcsCfg.General.RemoteStorage = "gcs"
+	assert.False(t, canCopyRemoteServerSide(s3Cfg, gcsCfg))
+	assert.True(t, canCopyRemoteServerSide(gcsCfg, gcsCfg))
+	gcsCfg.GCS.EncryptionKey = "key"
+	assert.False(t, canCopyRemoteServerSide(gcsCfg, gcsCfg))
+}
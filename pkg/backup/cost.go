@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+const bytesInGB = float64(1 << 30)
+
+// getStoragePrice - prices for current remote_storage and price per GB-month for configured storage class
+func getStoragePrice(cfg *config.Config) (config.PriceConfig, string, float64, error) {
+	price, exists := cfg.Cost.Prices[cfg.General.RemoteStorage]
+	if !exists {
+		return price, "", 0, fmt.Errorf("cost->prices doesn't contain prices for remote_storage: %s", cfg.General.RemoteStorage)
+	}
+	storageClass := "default"
+	switch cfg.General.RemoteStorage {
+	case "s3":
+		storageClass = cfg.S3.StorageClass
+	case "gcs":
+		storageClass = cfg.GCS.StorageClass
+	}
+	for class, gbMonth := range price.GBMonth {
+		if strings.EqualFold(class, storageClass) {
+			return price, class, gbMonth, nil
+		}
+	}
+	gbMonth, exists := price.GBMonth["default"]
+	if !exists {
+		return price, "", 0, fmt.Errorf("cost->prices->%s->gb_month doesn't contain `%s` and `default` storage class", cfg.General.RemoteStorage, storageClass)
+	}
+	return price, "default", gbMonth, nil
+}
+
+// PrintRemoteBackupsCost - estimate monthly storage cost for each remote backup, request cost for upload and download, and monthly storage cost for each retention scenario
+func (b *Backuper) PrintRemoteBackupsCost(ctx context.Context) error {
+	log := b.log.WithField("logger", "PrintRemoteBackupsCost")
+	price, storageClass, gbMonth, err := getStoragePrice(b.cfg)
+	if err != nil {
+		return err
+	}
+	scenarios := make(map[string]storage.BackupRetention)
+	scenarioNames := make([]string, 0, len(b.cfg.Cost.RetentionScenarios)+2)
+	if currentRetention := storage.NewBackupRetention(b.cfg); currentRetention.IsEnabled() {
+		scenarioNames = append(scenarioNames, "current: "+currentRetention.String())
+		scenarios[scenarioNames[len(scenarioNames)-1]] = currentRetention
+	}
+	for _, scenario := range b.cfg.Cost.RetentionScenarios {
+		retention, err := storage.ParseBackupRetention(scenario)
+		if err != nil {
+			return fmt.Errorf("invalid cost->retention_scenarios: %v", err)
+		}
+		scenarioNames = append(scenarioNames, retention.String())
+		scenarios[scenarioNames[len(scenarioNames)-1]] = retention
+	}
+	inventory, err := b.GetRemoteInventory(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	defer func() {
+		if err := w.Flush(); err != nil {
+			log.Errorf("can't flush tabular writer error: %v", err)
+		}
+	}()
+	currency := b.cfg.Cost.Currency
+	storageCost := func(size uint64) string {
+		return fmt.Sprintf("%.2f %s", float64(size)/bytesInGB*gbMonth, currency)
+	}
+	requestCost := func(objects uint64, per1000 float64) string {
+		return fmt.Sprintf("%.2f %s", float64(objects)/1000*per1000, currency)
+	}
+	printCostRow(w, "backup", "size", "objects", "storage/month", "upload requests", "download requests")
+	for _, backup := range inventory.Backups {
+		size, objects := inventory.BackupBytes[backup.BackupName], inventory.BackupObjects[backup.BackupName]
+		printCostRow(w, backup.BackupName, utils.FormatBytes(size), fmt.Sprint(objects), storageCost(size), requestCost(objects, price.PutPer1000), requestCost(objects, price.GetPer1000))
+	}
+	if inventory.ChunksBytes > 0 {
+		printCostRow(w, "dedup chunks", utils.FormatBytes(inventory.ChunksBytes), "", storageCost(inventory.ChunksBytes), "", "")
+	}
+	if inventory.OrphanedBytes > 0 {
+		printCostRow(w, "orphaned objects", utils.FormatBytes(inventory.OrphanedBytes), "", storageCost(inventory.OrphanedBytes), "", "")
+	}
+	printCostRow(w, "total", utils.FormatBytes(inventory.TotalBytes), "", storageCost(inventory.TotalBytes), "", "")
+	printCostRow(w, "", "", "", "", "", "")
+	printCostRow(w, fmt.Sprintf("retention scenario (%s, storage class %s)", b.cfg.General.RemoteStorage, storageClass), "backups", "size", "storage/month", "", "")
+	printCostRow(w, "keep all", fmt.Sprint(len(inventory.Backups)), utils.FormatBytes(inventory.TotalBytes-inventory.OrphanedBytes), storageCost(inventory.TotalBytes-inventory.OrphanedBytes), "", "")
+	now := time.Now()
+	for _, scenarioName := range scenarioNames {
+		// GetBackupsToDeleteByRetention sort backups in place
+		backups := make([]storage.Backup, len(inventory.Backups))
+		copy(backups, inventory.Backups)
+		deleted := make(map[string]struct{})
+		for _, backup := range storage.GetBackupsToDeleteByRetentionWithGroups(backups, inventory.ClusterGroups, scenarios[scenarioName], now) {
+			deleted[backup.BackupName] = struct{}{}
+		}
+		keptBackups, keptSize := 0, uint64(0)
+		for _, backup := range inventory.Backups {
+			if _, isDeleted := deleted[backup.BackupName]; !isDeleted {
+				keptBackups += 1
+				keptSize += inventory.BackupBytes[backup.BackupName]
+			}
+		}
+		printCostRow(w, scenarioName, fmt.Sprint(keptBackups), utils.FormatBytes(keptSize), storageCost(keptSize), "", "")
+	}
+	return nil
+}
+
+func printCostRow(w io.Writer, columns ...string) {
+	if bytes, err := fmt.Fprintln(w, strings.Join(columns, "\t")); err != nil {
+		apexLog.Errorf("fmt.Fprintln write %d bytes return error: %v", bytes, err)
+	}
+}
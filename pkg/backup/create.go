@@ -7,8 +7,9 @@ import (
 	"fmt"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
@@ -18,14 +19,18 @@ import (
 	"github.com/Altinity/clickhouse-backup/pkg/keeper"
 	"github.com/Altinity/clickhouse-backup/pkg/metadata"
 	"github.com/Altinity/clickhouse-backup/pkg/partition"
+	"github.com/Altinity/clickhouse-backup/pkg/resumable"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
 	"github.com/Altinity/clickhouse-backup/pkg/storage"
-	"github.com/Altinity/clickhouse-backup/pkg/storage/object_disk"
+	"github.com/Altinity/clickhouse-backup/pkg/tracing"
 	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
 
 	apexLog "github.com/apex/log"
 	"github.com/google/uuid"
 	recursiveCopy "github.com/otiai10/copy"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 const (
@@ -52,7 +57,10 @@ func NewBackupName() string {
 
 // CreateBackup - create new backup of all tables matched by tablePattern
 // If backupName is empty string will use default backup name
-func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []string, schemaOnly, createRBAC, rbacOnly, createConfigs, configsOnly, skipCheckPartsColumns bool, version string, commandId int) error {
+func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []string, schemaOnly, createRBAC, rbacOnly, createConfigs, configsOnly, skipCheckPartsColumns, waitMutations, resume bool, version string, commandId int) (err error) {
+	b.setCommandLog(commandId)
+	tablePattern = b.tablePatternOrDefault(tablePattern)
+	b.applyResourceLimits()
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -62,18 +70,40 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 
 	startBackup := time.Now()
 	doBackupData := !schemaOnly && !rbacOnly && !configsOnly
-	if backupName == "" {
-		backupName = NewBackupName()
+	b.resume = resume
+	if backupName, err = b.ResolveBackupName(ctx, backupName); err != nil {
+		return err
 	}
-	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
 	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "create",
 	})
+	b.setLogComment("create", backupName, commandId)
+	notifyFinish := b.notifyOperation("create", backupName)
+	hooks := b.newOperationHooks("create", backupName)
+	ctx, span := tracing.Start(ctx, "create", attribute.String("backup", backupName))
+	defer func() {
+		err = hooks.after(ctx, err)
+		span.End(err)
+		notifyFinish(err)
+	}()
+	if err = hooks.before(ctx); err != nil {
+		return err
+	}
 	if err := b.ch.Connect(); err != nil {
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
 	defer b.ch.Close()
+	defer func() {
+		if b.dryRun {
+			return
+		}
+		backupLogStatus := BackupLogStatusBackupCreated
+		if err != nil {
+			backupLogStatus = BackupLogStatusBackupFailed
+		}
+		b.writeBackupLog(context.Background(), backupName, commandId, backupLogStatus, startBackup, err)
+	}()
 
 	if skipCheckPartsColumns && b.cfg.ClickHouse.CheckPartsColumns {
 		b.cfg.ClickHouse.CheckPartsColumns = false
@@ -87,6 +117,18 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 	if err != nil {
 		return fmt.Errorf("can't get tables from clickhouse: %v", err)
 	}
+	isClassicBackup, err := b.isClassicBackup(tables)
+	if err != nil {
+		return err
+	}
+	if isClassicBackup {
+		log.Info("all tables matched clickhouse->classic_backup_tables, create backup with FREEZE")
+		b.useClassicBackup()
+		// inner tables of materialized views are skipped only for BACKUP
+		if tables, err = b.GetTables(ctx, tablePattern); err != nil {
+			return fmt.Errorf("can't get tables from clickhouse: %v", err)
+		}
+	}
 	i := 0
 	for _, table := range tables {
 		if table.Skip {
@@ -97,6 +139,20 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 	if i == 0 && !b.cfg.General.AllowEmptyBackups {
 		return fmt.Errorf("no tables for backup")
 	}
+	if hasReplicatedTables(tables) {
+		if err = b.checkKeeperSafeMode(ctx); err != nil {
+			return err
+		}
+		// SYSTEM SYNC REPLICA will hang without keeper
+		if b.keeperFallback {
+			b.ch.Config.SyncReplicatedTables = false
+		}
+	}
+	if doBackupData {
+		if err = b.checkInProgressMutations(ctx, tables, waitMutations); err != nil {
+			return err
+		}
+	}
 
 	allFunctions, err := b.ch.GetUserDefinedFunctions(ctx)
 	if err != nil {
@@ -115,11 +171,27 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 		diskTypes[disk.Name] = disk.Type
 	}
 	partitionsIdMap, partitionsNameList := partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, tables, nil, partitions)
+	if doBackupData && !b.cfg.ClickHouse.UseEmbeddedBackupRestore {
+		if err = b.applyFreezePartitionsRules(ctx, tables, partitionsIdMap); err != nil {
+			return err
+		}
+	}
+	if b.dryRun {
+		return b.createBackupDryRun(ctx, backupName, tables, partitionsIdMap, doBackupData, disks, log)
+	}
+	if doBackupData {
+		if err = b.checkCreateFreeSpace(ctx, tables, partitionsIdMap, diskTypes); err != nil {
+			return err
+		}
+	}
 	// create
 	if b.cfg.ClickHouse.UseEmbeddedBackupRestore {
+		if b.skipUnchangedFrom != "" {
+			return fmt.Errorf("--skip-unchanged-from is not compatible with `use_embedded_backup_restore: true`")
+		}
 		err = b.createBackupEmbedded(ctx, backupName, tablePattern, partitionsNameList, partitionsIdMap, schemaOnly, createRBAC, createConfigs, tables, allDatabases, allFunctions, disks, diskMap, diskTypes, log, startBackup, version)
 	} else {
-		err = b.createBackupLocal(ctx, backupName, partitionsIdMap, tables, doBackupData, schemaOnly, createRBAC, rbacOnly, createConfigs, configsOnly, version, disks, diskMap, diskTypes, allDatabases, allFunctions, log, startBackup)
+		err = b.createBackupLocal(ctx, backupName, tablePattern, partitions, partitionsIdMap, tables, doBackupData, schemaOnly, createRBAC, rbacOnly, createConfigs, configsOnly, version, disks, diskMap, diskTypes, allDatabases, allFunctions, log, startBackup)
 	}
 	if err != nil {
 		return err
@@ -132,7 +204,7 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 	return nil
 }
 
-func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, partitionsIdMap map[metadata.TableTitle]common.EmptyMap, tables []clickhouse.Table, doBackupData bool, schemaOnly bool, createRBAC, rbacOnly bool, createConfigs, configsOnly bool, version string, disks []clickhouse.Disk, diskMap, diskTypes map[string]string, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, log *apexLog.Entry, startBackup time.Time) error {
+func (b *Backuper) createBackupLocal(ctx context.Context, backupName, tablePattern string, partitions []string, partitionsIdMap map[metadata.TableTitle]common.EmptyMap, tables []clickhouse.Table, doBackupData bool, schemaOnly bool, createRBAC, rbacOnly bool, createConfigs, configsOnly bool, version string, disks []clickhouse.Disk, diskMap, diskTypes map[string]string, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, log *apexLog.Entry, startBackup time.Time) error {
 	// Create backup dir on all clickhouse disks
 	for _, disk := range disks {
 		if err := filesystemhelper.Mkdir(path.Join(disk.Path, "backup"), b.ch, disks); err != nil {
@@ -147,84 +219,261 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, par
 	if _, err := os.Stat(path.Join(backupPath, "metadata.json")); err == nil || !os.IsNotExist(err) {
 		return fmt.Errorf("'%s' medatata.json already exists", backupName)
 	}
+	if b.skipUnchangedFrom != "" && doBackupData {
+		if b.skipUnchangedFrom == backupName {
+			return fmt.Errorf("--skip-unchanged-from shall be different from backup name")
+		}
+		if _, err := os.Stat(path.Join(defaultPath, "backup", b.skipUnchangedFrom, "metadata.json")); err != nil {
+			return fmt.Errorf("local backup %s from --skip-unchanged-from not found: %v", b.skipUnchangedFrom, err)
+		}
+	}
+	// automatic continue of interrupted create sets b.resume too
+	explicitResume := b.resume
+	if b.resumeInterruptedCreate(backupPath) {
+		log.Infof("found state of interrupted create, continue with already created tables")
+	}
 	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
 		if err = filesystemhelper.Mkdir(backupPath, b.ch, disks); err != nil {
 			log.Errorf("can't create directory %s: %v", backupPath, err)
 			return err
 		}
 	}
+	// state saved with `use_resumable_state: true` too, so killed `create` could be continued with --resume
+	var createState *resumable.State
+	if b.resume || b.cfg.General.UseResumableState {
+		if createState, err = b.openCreateResumableState(defaultPath, backupName, map[string]interface{}{
+			"tablePattern": tablePattern,
+			"partitions":   strings.Join(partitions, ","),
+			"schemaOnly":   schemaOnly,
+			"rbacOnly":     rbacOnly,
+			"configsOnly":  configsOnly,
+		}); err != nil {
+			return err
+		}
+		defer createState.Close()
+	}
 	var backupDataSize, backupMetadataSize uint64
 
 	var tableMetas []metadata.TableTitle
+	var backupSizeMx sync.Mutex
+	tablesWithData := 0
 	for _, table := range tables {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+		if !table.Skip && doBackupData && table.BackupType == clickhouse.ShardBackupFull {
+			tablesWithData += 1
+		}
+	}
+	freezeConcurrency := b.cfg.ClickHouse.FreezeConcurrency
+	if freezeConcurrency < 1 {
+		freezeConcurrency = 1
+	}
+	// FREEZE pacing, to avoid ZooKeeper and filesystem load spikes when backup contains thousands of tables
+	var freezeTicker *time.Ticker
+	if b.cfg.ClickHouse.FreezeTablesPerSecond > 0 {
+		freezeTicker = time.NewTicker(time.Duration(float64(time.Second) / b.cfg.ClickHouse.FreezeTablesPerSecond))
+		defer freezeTicker.Stop()
+	}
+	frozenTables := int64(0)
+	backupPartsCount := int64(0)
+	tableMetasByIndex := make([]*metadata.TableTitle, len(tables))
+	skippedTables := make([]metadata.TableTitle, 0)
+	var skippedTablesMx sync.Mutex
+	createSemaphore := semaphore.NewWeighted(int64(freezeConcurrency))
+	createGroup, createCtx := errgroup.WithContext(ctx)
+	for i, table := range tables {
+		if table.Skip {
+			continue
+		}
+		if err := createSemaphore.Acquire(createCtx, 1); err != nil {
+			log.Errorf("can't acquire semaphore during create table backup: %v", err)
+			break
+		}
+		doBackupTableData := doBackupData && table.BackupType == clickhouse.ShardBackupFull
+		tableMetadataFile := path.Join(backupPath, "metadata", common.TablePathEncode(table.Database), fmt.Sprintf("%s.json", common.TablePathEncode(table.Name)))
+		isAlreadyCreated := b.resume && createState.IsAlreadyProcessedBool(tableMetadataFile)
+		if doBackupTableData && freezeTicker != nil && !isAlreadyCreated {
+			select {
+			case <-createCtx.Done():
+			case <-freezeTicker.C:
+			}
+		}
+		idx := i
+		table := table
+		createGroup.Go(func() error {
+			defer createSemaphore.Release(1)
 			log := log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name))
-			if table.Skip {
-				continue
+			if isAlreadyCreated {
+				tableMeta := metadata.TableMetadata{}
+				metadataSize, err := tableMeta.Load(tableMetadataFile)
+				if err != nil {
+					return fmt.Errorf("can't resume %s.%s from %s: %v", table.Database, table.Name, tableMetadataFile, err)
+				}
+				backupSizeMx.Lock()
+				for _, size := range tableMeta.Size {
+					backupDataSize += uint64(size)
+				}
+				backupMetadataSize += metadataSize
+				backupSizeMx.Unlock()
+				for _, parts := range tableMeta.Parts {
+					atomic.AddInt64(&backupPartsCount, int64(len(parts)))
+				}
+				if doBackupTableData {
+					log.Infof("frozen %d/%d tables, already created by previous run", atomic.AddInt64(&frozenTables, 1), tablesWithData)
+				}
+				tableMetasByIndex[idx] = &metadata.TableTitle{
+					Database: table.Database,
+					Table:    table.Name,
+				}
+				return nil
 			}
 			var realSize map[string]int64
 			var disksToPartsMap map[string][]metadata.Part
-			if doBackupData && table.BackupType == clickhouse.ShardBackupFull {
+			var dataFingerprint *metadata.DataFingerprint
+			var replicationLogPointer uint64
+			var freezeTime *time.Time
+			unchangedFrom := ""
+			if doBackupTableData {
 				log.Debug("create data")
-				shadowBackupUUID := strings.ReplaceAll(uuid.New().String(), "-", "")
-				disksToPartsMap, realSize, err = b.AddTableToBackup(ctx, backupName, shadowBackupUUID, disks, &table, partitionsIdMap[metadata.TableTitle{Database: table.Database, Table: table.Name}])
-				if err != nil {
-					log.Error(err.Error())
-					if removeBackupErr := b.RemoveBackupLocal(ctx, backupName, disks); removeBackupErr != nil {
-						log.Error(removeBackupErr.Error())
+				// previous run could fail during move shadow for this table, MoveShadow can't create already existing hard links
+				if createState != nil {
+					if err := b.cleanPartiallyCreatedTable(backupName, table, disks); err != nil {
+						return err
 					}
-					// fix corner cases after https://github.com/Altinity/clickhouse-backup/issues/379
-					if cleanShadowErr := b.Clean(ctx); cleanShadowErr != nil {
-						log.Error(cleanShadowErr.Error())
+				}
+				var err error
+				// rows inserted after this time could be absent in snapshot, `restore --to-timestamp` replays them
+				now := time.Now().UTC()
+				freezeTime = &now
+				if strings.HasPrefix(table.Engine, "Replicated") && !b.keeperFallback {
+					if replicationLogPointer, err = b.ch.GetReplicationLogPointer(createCtx, table.Database, table.Name); err != nil {
+						log.Warnf("%v", err)
+					}
+				}
+				if strings.HasSuffix(table.Engine, "MergeTree") {
+					if dataFingerprint, err = b.ch.GetTableDataFingerprint(createCtx, table.Database, table.Name); err != nil {
+						log.Warnf("%v", err)
 					}
+				}
+				unchangedTable, err := b.getUnchangedTable(defaultPath, table, dataFingerprint, partitionsIdMap[metadata.TableTitle{Database: table.Database, Table: table.Name}], diskTypes)
+				if err != nil {
 					return err
 				}
+				if unchangedTable != nil {
+					if disksToPartsMap, realSize, err = b.linkUnchangedTable(backupName, *unchangedTable, disks); err != nil {
+						log.Warnf("can't link parts from %s, FREEZE table: %v", b.skipUnchangedFrom, err)
+						if err = b.cleanPartiallyCreatedTable(backupName, table, disks); err != nil {
+							return err
+						}
+						unchangedTable = nil
+					} else {
+						unchangedFrom = b.skipUnchangedFrom
+						log.Infof("data didn't change since %s, parts linked without FREEZE", b.skipUnchangedFrom)
+					}
+				}
+				if unchangedTable == nil {
+					shadowBackupUUID := strings.ReplaceAll(uuid.New().String(), "-", "")
+					tableCtx, tableSpan := tracing.Start(createCtx, "create_table", tableAttribute(table.Database, table.Name))
+					disksToPartsMap, realSize, err = b.AddTableToBackup(tableCtx, backupName, shadowBackupUUID, disks, &table, partitionsIdMap[metadata.TableTitle{Database: table.Database, Table: table.Name}])
+					tableSpan.End(err)
+					if errors.Is(err, clickhouse.ErrTableDroppedDuringFreeze) {
+						log.Warn("table dropped during backup, skip it, set `ignore_not_exists_error_during_freeze: false` to fail backup in this case")
+						skippedTablesMx.Lock()
+						skippedTables = append(skippedTables, metadata.TableTitle{Database: table.Database, Table: table.Name})
+						skippedTablesMx.Unlock()
+						return nil
+					}
+					if err != nil {
+						log.Error(err.Error())
+						return err
+					}
+				}
 				// more precise data size calculation
+				backupSizeMx.Lock()
 				for _, size := range realSize {
 					backupDataSize += uint64(size)
 				}
+				backupSizeMx.Unlock()
+				for _, parts := range disksToPartsMap {
+					atomic.AddInt64(&backupPartsCount, int64(len(parts)))
+				}
+				log.Infof("frozen %d/%d tables", atomic.AddInt64(&frozenTables, 1), tablesWithData)
 			}
 			// https://github.com/Altinity/clickhouse-backup/issues/529
 			log.Debug("get in progress mutations list")
 			inProgressMutations := make([]metadata.MutationMetadata, 0)
 			if b.cfg.ClickHouse.BackupMutations && !schemaOnly && !rbacOnly && !configsOnly {
-				inProgressMutations, err = b.ch.GetInProgressMutations(ctx, table.Database, table.Name)
+				var err error
+				inProgressMutations, err = b.ch.GetInProgressMutations(createCtx, table.Database, table.Name)
 				if err != nil {
 					log.Error(err.Error())
-					if removeBackupErr := b.RemoveBackupLocal(ctx, backupName, disks); removeBackupErr != nil {
-						log.Error(removeBackupErr.Error())
-					}
 					return err
 				}
 			}
+			var columnCodecs map[string]string
+			if doBackupTableData && strings.HasSuffix(table.Engine, "MergeTree") {
+				var err error
+				if columnCodecs, err = b.ch.GetColumnCodecs(createCtx, table.Database, table.Name); err != nil {
+					log.Warnf("%v", err)
+				}
+			}
 			log.Debug("create metadata")
 			if schemaOnly || doBackupData {
 				metadataSize, err := b.createTableMetadata(path.Join(backupPath, "metadata"), metadata.TableMetadata{
-					Table:        table.Name,
-					Database:     table.Database,
-					Query:        table.CreateTableQuery,
-					TotalBytes:   table.TotalBytes,
-					Size:         realSize,
-					Parts:        disksToPartsMap,
-					Mutations:    inProgressMutations,
-					MetadataOnly: schemaOnly || table.BackupType == clickhouse.ShardBackupSchema,
+					Table:                 table.Name,
+					Database:              table.Database,
+					Query:                 table.CreateTableQuery,
+					UUID:                  table.UUID,
+					TotalBytes:            table.TotalBytes,
+					Size:                  realSize,
+					Parts:                 disksToPartsMap,
+					Mutations:             inProgressMutations,
+					MetadataOnly:          schemaOnly || table.BackupType == clickhouse.ShardBackupSchema,
+					ReplicationLogPointer: replicationLogPointer,
+					FreezeTime:            freezeTime,
+					ColumnCodecs:          columnCodecs,
+					EngineData:            getEngineDataMetadata(table, disksToPartsMap),
+					PartHashAlgorithm:     b.cfg.General.PartHashAlgorithm,
+					DataFingerprint:       dataFingerprint,
+					UnchangedFrom:         unchangedFrom,
 				}, disks)
 				if err != nil {
-					if removeBackupErr := b.RemoveBackupLocal(ctx, backupName, disks); removeBackupErr != nil {
-						log.Error(removeBackupErr.Error())
-					}
 					return err
 				}
+				if createState != nil {
+					createState.AppendToState(tableMetadataFile, int64(metadataSize))
+				}
+				backupSizeMx.Lock()
 				backupMetadataSize += metadataSize
-				tableMetas = append(tableMetas, metadata.TableTitle{
+				backupSizeMx.Unlock()
+				tableMetasByIndex[idx] = &metadata.TableTitle{
 					Database: table.Database,
 					Table:    table.Name,
-				})
+				}
 			}
 			log.Infof("done")
+			return nil
+		})
+	}
+	if err := createGroup.Wait(); err != nil {
+		// after SIGTERM or cancel via API ctx is canceled, but frozen data shall be released
+		cleanupCtx, cancelCleanup := newCleanupContext(ctx)
+		defer cancelCleanup()
+		if keepFailedCreate(ctx, createState, explicitResume) {
+			log.Warnf("keep already created tables, run `clickhouse-backup create %s` with the same parameters to continue", backupName)
+		} else if removeBackupErr := b.RemoveBackupLocal(cleanupCtx, backupName, disks); removeBackupErr != nil {
+			log.Error(removeBackupErr.Error())
+		}
+		// fix corner cases after https://github.com/Altinity/clickhouse-backup/issues/379
+		if cleanShadowErr := b.Clean(cleanupCtx); cleanShadowErr != nil {
+			log.Error(cleanShadowErr.Error())
+		}
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, tableMeta := range tableMetasByIndex {
+		if tableMeta != nil {
+			tableMetas = append(tableMetas, *tableMeta)
 		}
 	}
 	backupRBACSize, backupConfigSize := uint64(0), uint64(0)
@@ -243,12 +492,29 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, par
 			log.WithField("size", utils.FormatBytes(backupConfigSize)).Info("done createBackupConfigs")
 		}
 	}
+	backupKeeperSize := uint64(0)
+	if !rbacOnly && !configsOnly {
+		if backupKeeperSize, err = b.createBackupKeeper(ctx, backupPath, tableMetas, disks); err != nil {
+			return err
+		}
+	}
 
 	backupMetaFile := path.Join(defaultPath, "backup", backupName, "metadata.json")
-	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, version, "regular", diskMap, diskTypes, disks, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize, tableMetas, allDatabases, allFunctions, log); err != nil {
+	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, version, "regular", diskMap, diskTypes, disks, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize, backupKeeperSize, backupPartsCount, tableMetas, skippedTables, allDatabases, allFunctions, log); err != nil {
 		return err
 	}
-	log.WithField("duration", utils.HumanizeDuration(time.Since(startBackup))).Info("done")
+	b.removeObjectDiskCopyStates(backupName, disks)
+	if createState != nil {
+		b.removeCreateResumableState(createState, defaultPath, backupName)
+	}
+	if len(skippedTables) > 0 {
+		skippedTableNames := make([]string, len(skippedTables))
+		for i, t := range skippedTables {
+			skippedTableNames[i] = fmt.Sprintf("%s.%s", t.Database, t.Table)
+		}
+		log.Warnf("%d tables dropped during backup and skipped: %s", len(skippedTables), strings.Join(skippedTableNames, ", "))
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(startBackup))).WithField("skipped_tables", len(skippedTables)).Info("done")
 	return nil
 }
 
@@ -257,7 +523,7 @@ func (b *Backuper) createBackupEmbedded(ctx context.Context, backupName, tablePa
 	if doesShard(b.cfg.General.ShardedOperationMode) {
 		return fmt.Errorf("cannot perform embedded backup: %w", errShardOperationUnsupported)
 	}
-	if _, isBackupDiskExists := diskMap[b.cfg.ClickHouse.EmbeddedBackupDisk]; !isBackupDiskExists {
+	if _, isBackupDiskExists := diskMap[b.cfg.ClickHouse.EmbeddedBackupDisk]; !isBackupDiskExists && !b.isEmbeddedRemoteTarget() {
 		return fmt.Errorf("backup disk `%s` not exists in system.disks", b.cfg.ClickHouse.EmbeddedBackupDisk)
 	}
 	if createRBAC || createConfigs {
@@ -298,12 +564,19 @@ func (b *Backuper) createBackupEmbedded(ctx context.Context, backupName, tablePa
 			tableSizeSQL += ", "
 		}
 	}
-	backupSQL := fmt.Sprintf("BACKUP %s TO Disk(?,?)", tablesSQL)
+	backupDestination, backupArgs := "Disk(?,?)", []interface{}{b.cfg.ClickHouse.EmbeddedBackupDisk, backupName}
+	if b.isEmbeddedRemoteTarget() {
+		var err error
+		if backupDestination, backupArgs, err = b.embeddedRemoteDestination(ctx, backupName); err != nil {
+			return err
+		}
+	}
+	backupSQL := fmt.Sprintf("BACKUP %s TO %s", tablesSQL, backupDestination)
 	if schemaOnly {
 		backupSQL += " SETTINGS structure_only=1, show_table_uuid_in_table_create_query_if_not_nil=1"
 	}
 	backupResult := make([]clickhouse.SystemBackups, 0)
-	if err := b.ch.SelectContext(ctx, &backupResult, backupSQL, b.cfg.ClickHouse.EmbeddedBackupDisk, backupName); err != nil {
+	if err := b.ch.SelectContext(ctx, &backupResult, backupSQL, backupArgs...); err != nil {
 		return fmt.Errorf("backup error: %v", err)
 	}
 	if len(backupResult) != 1 || (backupResult[0].Status != "BACKUP_COMPLETE" && backupResult[0].Status != "BACKUP_CREATED") {
@@ -336,6 +609,34 @@ func (b *Backuper) createBackupEmbedded(ctx context.Context, backupName, tablePa
 		}{Size: 0})
 	}
 
+	if b.isEmbeddedRemoteTarget() {
+		// parts list is not available without read backup from remote storage, restore --partitions will pass partitions to RESTORE as is
+		tableMetadatas := make([]metadata.TableMetadata, 0, l)
+		for _, table := range tables {
+			if !table.Skip {
+				tableMetadatas = append(tableMetadatas, metadata.TableMetadata{
+					Table:        table.Name,
+					Database:     table.Database,
+					Query:        table.CreateTableQuery,
+					TotalBytes:   table.TotalBytes,
+					Size:         map[string]int64{},
+					Parts:        map[string][]metadata.Part{},
+					MetadataOnly: schemaOnly,
+				})
+			}
+		}
+		if err := b.uploadEmbeddedRemoteMetadata(ctx, backupName, tableMetadatas, disks, func(backupMetaFile string, backupMetadataSize uint64) error {
+			return b.createBackupMetadata(ctx, backupMetaFile, backupName, backupVersion, embeddedRemoteTag, diskMap, diskTypes, disks, backupDataSize[0].Size, backupMetadataSize, 0, 0, 0, 0, tableMetas, nil, allDatabases, allFunctions, log)
+		}); err != nil {
+			return err
+		}
+		log.WithFields(apexLog.Fields{
+			"operation": "create_embedded",
+			"duration":  utils.HumanizeDuration(time.Since(startBackup)),
+		}).Info("done")
+		return nil
+	}
+
 	log.Debug("calculate parts list from embedded backup disk")
 	for _, table := range tables {
 		select {
@@ -371,7 +672,7 @@ func (b *Backuper) createBackupEmbedded(ctx context.Context, backupName, tablePa
 		}
 	}
 	backupMetaFile := path.Join(diskMap[b.cfg.ClickHouse.EmbeddedBackupDisk], backupName, "metadata.json")
-	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, backupVersion, "embedded", diskMap, diskTypes, disks, backupDataSize[0].Size, backupMetadataSize, 0, 0, tableMetas, allDatabases, allFunctions, log); err != nil {
+	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, backupVersion, "embedded", diskMap, diskTypes, disks, backupDataSize[0].Size, backupMetadataSize, 0, 0, 0, 0, tableMetas, nil, allDatabases, allFunctions, log); err != nil {
 		return err
 	}
 
@@ -477,7 +778,11 @@ func (b *Backuper) createBackupRBAC(ctx context.Context, backupPath string, disk
 		if err != nil {
 			return 0, err
 		}
-		return rbacDataSize + replicatedRBACDataSize, nil
+		rbacObjectsDataSize, err := b.createBackupRBACObjects(ctx, rbacBackup, disks)
+		if err != nil {
+			return 0, err
+		}
+		return rbacDataSize + replicatedRBACDataSize + rbacObjectsDataSize, nil
 	}
 }
 
@@ -519,6 +824,9 @@ func (b *Backuper) AddTableToBackup(ctx context.Context, backupName, shadowBacku
 		return nil, nil, fmt.Errorf("backupName is not defined")
 	}
 
+	if isEngineDataTable(table.Engine) {
+		return b.addEngineDataToBackup(ctx, backupName, shadowBackupUUID, diskList, table, log)
+	}
 	if !strings.HasSuffix(table.Engine, "MergeTree") && table.Engine != "MaterializedMySQL" && table.Engine != "MaterializedPostgreSQL" {
 		if table.Engine != "MaterializedView" {
 			log.WithField("engine", table.Engine).Warnf("supports only schema backup")
@@ -531,9 +839,22 @@ func (b *Backuper) AddTableToBackup(ctx context.Context, backupName, shadowBacku
 		}
 	}
 	// backup data
-	if err := b.ch.FreezeTable(ctx, table, shadowBackupUUID); err != nil {
+	isFrozenByHardlinks := false
+	freezePartitionIds, err := b.getFreezePartitionIds(ctx, table, partitionsIdsMap)
+	if err != nil {
 		return nil, nil, err
 	}
+	if err := b.ch.FreezeTable(ctx, table, shadowBackupUUID, freezePartitionIds); err != nil {
+		// code: 48 NOT_IMPLEMENTED
+		if !strings.Contains(err.Error(), "code: 48") || !isMaterializedDatabaseEngine(table.Engine) {
+			return nil, nil, err
+		}
+		log.Warnf("FREEZE is not supported, create hard links for active parts: %v", err)
+		if err = b.freezeMaterializedTable(ctx, table, shadowBackupUUID, diskList, log); err != nil {
+			return nil, nil, err
+		}
+		isFrozenByHardlinks = true
+	}
 	log.Debug("frozen")
 	version, err := b.ch.GetVersion(ctx)
 	if err != nil {
@@ -565,6 +886,17 @@ func (b *Backuper) AddTableToBackup(ctx context.Context, backupName, shadowBacku
 			realSize[disk.Name] = size
 			disksToPartsMap[disk.Name] = parts
 			log.WithField("disk", disk.Name).Debug("shadow moved")
+			if disk.Type != "s3" && disk.Type != "azure_blob_storage" {
+				if err = collectPartsProjections(backupShadowPath, parts); err != nil {
+					return nil, nil, err
+				}
+				if err = collectPartsChecksums(backupShadowPath, parts); err != nil {
+					return nil, nil, err
+				}
+				if err = b.collectPartsHashes(ctx, backupShadowPath, parts); err != nil {
+					return nil, nil, err
+				}
+			}
 			if disk.Type == "s3" || disk.Type == "azure_blob_storage" && len(parts) > 0 {
 				if err = config.ValidateObjectDiskConfig(b.cfg); err != nil {
 					return nil, nil, err
@@ -585,8 +917,13 @@ func (b *Backuper) AddTableToBackup(ctx context.Context, backupName, shadowBacku
 				realSize[disk.Name] += size
 				log.WithField("disk", disk.Name).WithField("duration", utils.HumanizeDuration(time.Since(start))).Info("object_disk data uploaded")
 			}
+			if b.cfg.General.SentinelFiles && len(parts) > 0 {
+				if err = b.writeBackupSentinel(backupShadowPath, parts, diskList); err != nil {
+					return disksToPartsMap, realSize, err
+				}
+			}
 			// Clean all the files under the shadowPath, cause UNFREEZE unavailable
-			if version < 21004000 {
+			if version < 21004000 || isFrozenByHardlinks {
 				if err := os.RemoveAll(shadowPath); err != nil {
 					return disksToPartsMap, realSize, err
 				}
@@ -594,7 +931,7 @@ func (b *Backuper) AddTableToBackup(ctx context.Context, backupName, shadowBacku
 		}
 	}
 	// Unfreeze to unlock data on S3 disks, https://github.com/Altinity/clickhouse-backup/issues/423
-	if version > 21004000 {
+	if version > 21004000 && !isFrozenByHardlinks {
 		if err := b.ch.QueryContext(ctx, fmt.Sprintf("ALTER TABLE `%s`.`%s` UNFREEZE WITH NAME '%s'", table.Database, table.Name, shadowBackupUUID)); err != nil {
 			if (strings.Contains(err.Error(), "code: 60") || strings.Contains(err.Error(), "code: 81") || strings.Contains(err.Error(), "code: 218")) && b.cfg.ClickHouse.IgnoreNotExistsErrorDuringFreeze {
 				b.ch.Log.Warnf("can't unfreeze table: %v", err)
@@ -613,59 +950,13 @@ func (b *Backuper) AddTableToBackup(ctx context.Context, backupName, shadowBacku
 	return disksToPartsMap, realSize, nil
 }
 
-func (b *Backuper) uploadObjectDiskParts(ctx context.Context, backupName, backupShadowPath string, disk clickhouse.Disk) (int64, error) {
-	var size int64
-	var err error
-	if err = object_disk.InitCredentialsAndConnections(ctx, b.ch, b.cfg, disk.Name); err != nil {
-		return 0, err
-	}
-
-	if err := filepath.Walk(backupShadowPath, func(fPath string, fInfo os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if fInfo.IsDir() {
-			return nil
-		}
-		objPartFileMeta, err := object_disk.ReadMetadataFromFile(fPath)
-		if err != nil {
-			return err
-		}
-		var realSize, objSize int64
-		// @TODO think about parallelization here after test pass
-		for _, storageObject := range objPartFileMeta.StorageObjects {
-			srcDiskConnection, exists := object_disk.DisksConnections[disk.Name]
-			if !exists {
-				return fmt.Errorf("uploadObjectDiskParts: %s not present in object_disk.DisksConnections", disk.Name)
-			}
-			if objSize, err = b.dst.CopyObject(
-				ctx,
-				srcDiskConnection.GetRemoteBucket(),
-				path.Join(srcDiskConnection.GetRemotePath(), storageObject.ObjectRelativePath),
-				path.Join(backupName, disk.Name, storageObject.ObjectRelativePath),
-			); err != nil {
-				return err
-			}
-			realSize += objSize
-		}
-		if realSize > objPartFileMeta.TotalSize {
-			size += realSize
-		} else {
-			size += objPartFileMeta.TotalSize
-		}
-		return nil
-	}); err != nil {
-		return 0, err
-	}
-	return size, nil
-}
-
-func (b *Backuper) createBackupMetadata(ctx context.Context, backupMetaFile, backupName, version, tags string, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize uint64, tableMetas []metadata.TableTitle, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, log *apexLog.Entry) error {
+func (b *Backuper) createBackupMetadata(ctx context.Context, backupMetaFile, backupName, version, tags string, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize, backupKeeperSize uint64, partsCount int64, tableMetas, skippedTables []metadata.TableTitle, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, log *apexLog.Entry) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 		backupMetadata := metadata.BackupMetadata{
+			ManifestVersion:         metadata.ManifestVersion,
 			BackupName:              backupName,
 			Disks:                   diskMap,
 			DiskTypes:               diskTypes,
@@ -677,12 +968,24 @@ func (b *Backuper) createBackupMetadata(ctx context.Context, backupMetaFile, bac
 			MetadataSize:            backupMetadataSize,
 			RBACSize:                backupRBACSize,
 			ConfigSize:              backupConfigSize,
+			KeeperSize:              backupKeeperSize,
+			PartsCount:              partsCount,
 			Tables:                  tableMetas,
+			SkippedTables:           skippedTables,
 			Databases:               []metadata.DatabasesMeta{},
 			Functions:               []metadata.FunctionsMeta{},
 		}
+		if macros, err := b.ch.GetMacros(ctx); err != nil {
+			log.Warnf("can't get system.macros, Replicated engine paths will not rewrite during restore: %v", err)
+		} else if len(macros) > 0 {
+			backupMetadata.Macros = macros
+		}
 		for _, database := range allDatabases {
-			backupMetadata.Databases = append(backupMetadata.Databases, metadata.DatabasesMeta(database))
+			databaseMeta := metadata.DatabasesMeta{Name: database.Name, Engine: database.Engine, Query: database.Query}
+			if isMaterializedDatabaseEngine(database.Engine) {
+				databaseMeta.Query = maskMaterializedCredentials(database.Query)
+			}
+			backupMetadata.Databases = append(backupMetadata.Databases, databaseMeta)
 		}
 		for _, function := range allFunctions {
 			backupMetadata.Functions = append(backupMetadata.Functions, metadata.FunctionsMeta(function))
@@ -724,3 +1027,67 @@ func (b *Backuper) createTableMetadata(metadataPath string, table metadata.Table
 	}
 	return uint64(len(metadataBody)), nil
 }
+
+// resumeInterruptedCreate - interrupted `create` leaves backup without metadata.json, continue it the same way as `upload` and `download` do with `use_resumable_state: true`
+func (b *Backuper) resumeInterruptedCreate(backupPath string) bool {
+	if b.resume || !b.cfg.General.UseResumableState {
+		return false
+	}
+	if _, err := os.Stat(path.Join(backupPath, "create.state")); err != nil {
+		return false
+	}
+	b.resume = true
+	return true
+}
+
+// keepFailedCreate - canceled, interrupted by SIGTERM or explicitly resumed create keeps partial backup with create.state, next run with the same backup name will continue it,
+// other failures remove partial backup, because watch, scheduled and auto-named backups never run again with the same name
+func keepFailedCreate(ctx context.Context, createState *resumable.State, resume bool) bool {
+	return createState != nil && (resume || ctx.Err() != nil)
+}
+
+// openCreateResumableState - create.state contains metadata files of tables which already frozen and moved into backup,
+// they can be reused with --resume only when backup created with the same parameters, without --resume state starts from scratch
+func (b *Backuper) openCreateResumableState(defaultPath, backupName string, params map[string]interface{}) (*resumable.State, error) {
+	stateFile := path.Join(defaultPath, "backup", backupName, "create.state")
+	if !b.resume {
+		if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	state := resumable.NewState(defaultPath, backupName, "create", params)
+	currentParams, err := json.Marshal(params)
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
+	stateParams, err := json.Marshal(state.GetParams())
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
+	if string(currentParams) != string(stateParams) {
+		state.Close()
+		return nil, fmt.Errorf("can't resume create %s, previous run used parameters %s, current parameters %s, use `clickhouse-backup delete local %s` to start from scratch", backupName, stateParams, currentParams, backupName)
+	}
+	return state, nil
+}
+
+func (b *Backuper) removeCreateResumableState(state *resumable.State, defaultPath, backupName string) {
+	state.Close()
+	stateFile := path.Join(defaultPath, "backup", backupName, "create.state")
+	if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+		b.log.Warnf("can't remove %s: %v", stateFile, err)
+	}
+}
+
+// cleanPartiallyCreatedTable - remove table data which moved from shadow before previous `create` failed
+func (b *Backuper) cleanPartiallyCreatedTable(backupName string, table clickhouse.Table, disks []clickhouse.Disk) error {
+	for _, disk := range disks {
+		tableBackupPath := path.Join(disk.Path, "backup", backupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Name))
+		if err := os.RemoveAll(tableBackupPath); err != nil {
+			return fmt.Errorf("can't remove partially created %s: %v", tableBackupPath, err)
+		}
+	}
+	return nil
+}
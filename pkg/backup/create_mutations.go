@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+// isLightweightDelete - lightweight `DELETE FROM` present in system.mutations as `UPDATE _row_exists = 0 WHERE ...`
+func isLightweightDelete(command string) bool {
+	return strings.Contains(command, "_row_exists")
+}
+
+// checkInProgressMutations - frozen parts don't contain results of in-progress mutations and lightweight deletes, so restore could resurrect rows which application believes deleted
+// warn about each in-progress mutation, when waitMutations is true, wait until all mutations for backed up tables finish
+func (b *Backuper) checkInProgressMutations(ctx context.Context, tables []clickhouse.Table, waitMutations bool) error {
+	log := b.log.WithField("logger", "checkInProgressMutations")
+	backupTables := make(map[metadata.TableTitle]struct{}, len(tables))
+	for _, table := range tables {
+		if !table.Skip {
+			backupTables[metadata.TableTitle{Database: table.Database, Table: table.Name}] = struct{}{}
+		}
+	}
+	warned := make(map[string]struct{})
+	for {
+		allMutations, err := b.ch.GetAllInProgressMutations(ctx)
+		if err != nil {
+			return err
+		}
+		pending := 0
+		for title, mutations := range allMutations {
+			if _, exists := backupTables[title]; !exists {
+				continue
+			}
+			for _, mutation := range mutations {
+				pending++
+				mutationKey := title.Database + "." + title.Table + "." + mutation.MutationId
+				if _, isWarned := warned[mutationKey]; isWarned {
+					continue
+				}
+				warned[mutationKey] = struct{}{}
+				kind := "mutation"
+				if isLightweightDelete(mutation.Command) {
+					kind = "lightweight delete"
+				}
+				log.Warnf("`%s`.`%s` has in-progress %s %s: %s, backup could contain rows which not mutated yet, use --wait-mutations to wait finish", title.Database, title.Table, kind, mutation.MutationId, mutation.Command)
+			}
+		}
+		if pending == 0 || !waitMutations {
+			return nil
+		}
+		log.Infof("wait %d in-progress mutations", pending)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
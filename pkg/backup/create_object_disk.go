@@ -0,0 +1,264 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/storage/object_disk"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+const objectDiskCopyStateSuffix = ".object_disk_copy.state"
+
+// objectDiskCopyState - resume manifest for CopyObject during `create`, contains `dstKey:size` lines for already copied objects
+// stored outside backup directory, so it stays after failed `create` and next `create` with the same backup name will skip copied objects
+type objectDiskCopyState struct {
+	mx     sync.Mutex
+	copied map[string]int64
+	fp     *os.File
+}
+
+func getObjectDiskCopyStateFile(disk clickhouse.Disk, backupName string) string {
+	return path.Join(disk.Path, "backup", backupName+objectDiskCopyStateSuffix)
+}
+
+func (b *Backuper) openObjectDiskCopyState(disk clickhouse.Disk, backupName string) (*objectDiskCopyState, error) {
+	b.objectDiskCopyStatesMx.Lock()
+	defer b.objectDiskCopyStatesMx.Unlock()
+	if b.objectDiskCopyStates == nil {
+		b.objectDiskCopyStates = make(map[string]*objectDiskCopyState)
+	}
+	if state, exists := b.objectDiskCopyStates[disk.Name]; exists {
+		return state, nil
+	}
+	stateFile := getObjectDiskCopyStateFile(disk, backupName)
+	state := &objectDiskCopyState{copied: make(map[string]int64)}
+	if fp, err := os.Open(stateFile); err == nil {
+		scanner := bufio.NewScanner(fp)
+		for scanner.Scan() {
+			line := scanner.Text()
+			sep := strings.LastIndex(line, ":")
+			if sep <= 0 {
+				continue
+			}
+			if size, parseErr := strconv.ParseInt(line[sep+1:], 10, 64); parseErr == nil {
+				state.copied[line[:sep]] = size
+			}
+		}
+		if err = fp.Close(); err != nil {
+			b.log.Warnf("can't close %s: %v", stateFile, err)
+		}
+		if len(state.copied) > 0 {
+			b.log.WithField("disk", disk.Name).Infof("%s contains %d already copied objects, will skip it", stateFile, len(state.copied))
+		}
+	}
+	fp, err := os.OpenFile(stateFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s: %v", stateFile, err)
+	}
+	state.fp = fp
+	b.objectDiskCopyStates[disk.Name] = state
+	return state, nil
+}
+
+func (s *objectDiskCopyState) isCopied(dstKey string) (bool, int64) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	size, exists := s.copied[dstKey]
+	return exists, size
+}
+
+func (s *objectDiskCopyState) appendCopied(dstKey string, size int64) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.copied[dstKey] = size
+	_, err := s.fp.WriteString(fmt.Sprintf("%s:%d\n", dstKey, size))
+	return err
+}
+
+// removeObjectDiskCopyStates - remove resume manifests after successful `create` or when backup deleted
+func (b *Backuper) removeObjectDiskCopyStates(backupName string, disks []clickhouse.Disk) {
+	b.objectDiskCopyStatesMx.Lock()
+	defer b.objectDiskCopyStatesMx.Unlock()
+	for _, disk := range disks {
+		if state, exists := b.objectDiskCopyStates[disk.Name]; exists {
+			if err := state.fp.Close(); err != nil {
+				b.log.Warnf("can't close %s: %v", state.fp.Name(), err)
+			}
+		}
+		stateFile := getObjectDiskCopyStateFile(disk, backupName)
+		if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+			b.log.Warnf("can't remove %s: %v", stateFile, err)
+		}
+	}
+	b.objectDiskCopyStates = nil
+}
+
+type objectDiskCopyTask struct {
+	srcKey string
+	dstKey string
+	// fileIdx - index of part metadata file, used for size calculation
+	fileIdx int
+}
+
+func (b *Backuper) uploadObjectDiskParts(ctx context.Context, backupName, backupShadowPath string, disk clickhouse.Disk) (int64, error) {
+	var err error
+	if err = object_disk.InitCredentialsAndConnections(ctx, b.ch, b.cfg, disk.Name); err != nil {
+		return 0, err
+	}
+	srcDiskConnection, exists := object_disk.DisksConnections[disk.Name]
+	if !exists {
+		return 0, fmt.Errorf("uploadObjectDiskParts: %s not present in object_disk.DisksConnections", disk.Name)
+	}
+	var copyState *objectDiskCopyState
+	if b.cfg.General.UseResumableState || b.resume {
+		if copyState, err = b.openObjectDiskCopyState(disk, backupName); err != nil {
+			return 0, err
+		}
+	}
+	tasks := make([]objectDiskCopyTask, 0)
+	metadataTotalSize := make([]int64, 0)
+	if err = filepath.Walk(backupShadowPath, func(fPath string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fInfo.IsDir() {
+			return nil
+		}
+		objPartFileMeta, err := object_disk.ReadMetadataFromFile(fPath)
+		if err != nil {
+			return err
+		}
+		for _, storageObject := range objPartFileMeta.StorageObjects {
+			tasks = append(tasks, objectDiskCopyTask{
+				srcKey:  path.Join(srcDiskConnection.GetRemotePath(), storageObject.ObjectRelativePath),
+				dstKey:  path.Join(backupName, disk.Name, storageObject.ObjectRelativePath),
+				fileIdx: len(metadataTotalSize),
+			})
+		}
+		metadataTotalSize = append(metadataTotalSize, objPartFileMeta.TotalSize)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	log := b.log.WithField("logger", "uploadObjectDiskParts").WithField("disk", disk.Name)
+	copyConcurrency := b.cfg.General.ObjectDiskConcurrency
+	copyBatchSize := 1
+	// GCS server-side copy, objects grouped into batches, objects of each batch copied concurrently
+	if srcDiskConnection.IsGCS && b.dst.Kind() == "GCS" {
+		if b.cfg.GCS.ObjectDiskCopyConcurrency > 0 {
+			copyConcurrency = b.cfg.GCS.ObjectDiskCopyConcurrency
+		}
+		if b.cfg.GCS.ObjectDiskCopyBatchSize > 1 {
+			copyBatchSize = b.cfg.GCS.ObjectDiskCopyBatchSize
+		}
+		log.Debugf("use GCS server-side copy with concurrency=%d batch_size=%d", copyConcurrency, copyBatchSize)
+	}
+	if copyConcurrency < 1 {
+		copyConcurrency = 1
+	}
+	var copyTicker *time.Ticker
+	if b.cfg.General.ObjectDiskCopyRate > 0 {
+		copyTicker = time.NewTicker(time.Duration(float64(time.Second) / b.cfg.General.ObjectDiskCopyRate))
+		defer copyTicker.Stop()
+	}
+	realSizes := make([]int64, len(metadataTotalSize))
+	copiedObjects := int64(0)
+	lastProgress := time.Now()
+	var progressMx sync.Mutex
+	batches := make([][]objectDiskCopyTask, 0, len(tasks)/copyBatchSize+1)
+	batch := make([]objectDiskCopyTask, 0, copyBatchSize)
+	for _, task := range tasks {
+		if copyState != nil {
+			if isCopied, copiedSize := copyState.isCopied(task.dstKey); isCopied {
+				atomic.AddInt64(&realSizes[task.fileIdx], copiedSize)
+				atomic.AddInt64(&copiedObjects, 1)
+				continue
+			}
+		}
+		batch = append(batch, task)
+		if len(batch) >= copyBatchSize {
+			batches = append(batches, batch)
+			batch = make([]objectDiskCopyTask, 0, copyBatchSize)
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	// transient errors are retried inside BackupDestination with general->storage_retries and exponential backoff, so each attempt is not multiplied by retries in two layers
+	copyObject := func(ctx context.Context, task objectDiskCopyTask) error {
+		if copyTicker != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-copyTicker.C:
+			}
+		}
+		objSize, err := b.dst.CopyObject(ctx, srcDiskConnection.GetRemoteBucket(), task.srcKey, task.dstKey)
+		if err != nil {
+			return fmt.Errorf("can't copy %s -> %s: %v", task.srcKey, task.dstKey, err)
+		}
+		atomic.AddInt64(&realSizes[task.fileIdx], objSize)
+		if copyState != nil {
+			if err = copyState.appendCopied(task.dstKey, objSize); err != nil {
+				log.Warnf("can't write object disk copy state: %v", err)
+			}
+		}
+		copied := atomic.AddInt64(&copiedObjects, 1)
+		progressMx.Lock()
+		if time.Since(lastProgress) >= 10*time.Second {
+			lastProgress = time.Now()
+			log.Infof("copied %d/%d objects", copied, len(tasks))
+		}
+		progressMx.Unlock()
+		return nil
+	}
+	copySemaphore := semaphore.NewWeighted(int64(copyConcurrency))
+	copyGroup, copyCtx := errgroup.WithContext(ctx)
+	for _, batch := range batches {
+		if err = copySemaphore.Acquire(copyCtx, 1); err != nil {
+			log.Errorf("can't acquire semaphore during CopyObject: %v", err)
+			break
+		}
+		batch := batch
+		copyGroup.Go(func() error {
+			defer copySemaphore.Release(1)
+			batchGroup, batchCtx := errgroup.WithContext(copyCtx)
+			for _, task := range batch {
+				task := task
+				batchGroup.Go(func() error {
+					return copyObject(batchCtx, task)
+				})
+			}
+			return batchGroup.Wait()
+		})
+	}
+	if err = copyGroup.Wait(); err != nil {
+		return 0, err
+	}
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	log.Debugf("copied %d/%d objects", copiedObjects, len(tasks))
+	var size int64
+	for i, totalSize := range metadataTotalSize {
+		if realSizes[i] > totalSize {
+			size += realSizes[i]
+		} else {
+			size += totalSize
+		}
+	}
+	return size, nil
+}
@@ -1,24 +1,41 @@
 package backup
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	apexLog "github.com/apex/log"
 )
 
-func (b *Backuper) CreateToRemote(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, resume bool, version string, commandId int) error {
+func (b *Backuper) CreateToRemote(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, waitMutations, resume bool, version string, commandId int) error {
+	b.setCommandLog(commandId)
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
 	}
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
-	if backupName == "" {
-		backupName = NewBackupName()
+	// resolve here, to upload the same backup when {time:layout} changed during create
+	if backupName, err = b.ResolveBackupName(ctx, backupName); err != nil {
+		return err
 	}
-	if err := b.CreateBackup(backupName, tablePattern, partitions, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, version, commandId); err != nil {
+	if err := b.CreateBackup(backupName, tablePattern, partitions, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, waitMutations, resume, version, commandId); err != nil {
 		return err
 	}
+	// BACKUP TO S3(...) or AzureBlobStorage(...) already wrote backup into remote storage
+	if b.isEmbeddedRemoteTarget() {
+		return nil
+	}
 	if err := b.Upload(backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, commandId); err != nil {
 		return err
 	}
@@ -28,3 +45,134 @@ func (b *Backuper) CreateToRemote(backupName, diffFrom, diffFromRemote, tablePat
 	}
 	return nil
 }
+
+// clusterShardBackupName - the same name pattern for create_remote and restore_remote with --on-cluster
+func clusterShardBackupName(backupName string, shardNum uint32) string {
+	return fmt.Sprintf("%s-shard%d", backupName, shardNum)
+}
+
+// CreateToRemoteOnCluster - run create_remote on one replica of each shard via API of clickhouse-backup server, and upload manifest which links backups of all shards
+func (b *Backuper) CreateToRemoteOnCluster(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, waitMutations, resume bool, version string, commandId int) error {
+	b.setCommandLog(commandId)
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("create_remote --on-cluster doesn't support remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if backupName, err = b.ResolveBackupName(ctx, backupName); err != nil {
+		return err
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	cluster, shards, err := b.getClusterShards(ctx)
+	b.ch.Close()
+	if err != nil {
+		return err
+	}
+	log := b.log.WithFields(apexLog.Fields{"backup": backupName, "operation": "create_remote", "cluster": cluster})
+	log.Infof("start on %d shards", len(shards))
+
+	args := make([]string, 0)
+	if tablePattern != "" {
+		args = append(args, fmt.Sprintf("--tables=%q", tablePattern))
+	}
+	if b.excludeTables != "" {
+		args = append(args, fmt.Sprintf("--exclude-tables=%q", b.excludeTables))
+	}
+	if len(partitions) > 0 {
+		args = append(args, fmt.Sprintf("--partitions=%q", strings.Join(partitions, ",")))
+	}
+	for flag, enabled := range map[string]bool{"--schema": schemaOnly, "--rbac": backupRBAC, "--rbac-only": rbacOnly, "--configs": backupConfigs, "--configs-only": configsOnly, "--skip-check-parts-columns": skipCheckPartsColumns, "--wait-mutations": waitMutations, "--resumable": resume} {
+		if enabled {
+			args = append(args, flag)
+		}
+	}
+	sort.Strings(args)
+	// --diff-from, --diff-from-remote and --skip-unchanged-from refer to backup of the same shard
+	skipUnchangedFrom := b.skipUnchangedFrom
+	shardBackupName := func(name string, shardNum uint32) string {
+		if name == "" {
+			return ""
+		}
+		return clusterShardBackupName(name, shardNum)
+	}
+	// manifest uploaded before shards start, so retention during upload of each shard counts all shard backups as one backup
+	plannedShards := make([]metadata.ClusterShardBackup, 0, len(shards))
+	for shardNum := range shards {
+		plannedShards = append(plannedShards, metadata.ClusterShardBackup{ShardNum: shardNum, BackupName: clusterShardBackupName(backupName, shardNum), Status: status.InProgressStatus})
+	}
+	sort.Slice(plannedShards, func(i, j int) bool { return plannedShards[i].ShardNum < plannedShards[j].ShardNum })
+	creationDate := time.Now().UTC()
+	if err = b.uploadClusterManifest(ctx, metadata.ClusterBackupMetadata{
+		BackupName:   backupName,
+		Cluster:      cluster,
+		CreationDate: creationDate,
+		Shards:       plannedShards,
+	}); err != nil {
+		return err
+	}
+	shardBackups, runErr := b.runOnEachShard(ctx, shards, clusterShardCommand{
+		apiCommand: func(shardNum uint32) string {
+			shardArgs := append([]string{"create_remote"}, args...)
+			for _, flag := range []struct{ name, value string }{{"--diff-from", diffFrom}, {"--diff-from-remote", diffFromRemote}, {"--skip-unchanged-from", skipUnchangedFrom}} {
+				if flag.value != "" {
+					shardArgs = append(shardArgs, fmt.Sprintf("%s=%q", flag.name, shardBackupName(flag.value, shardNum)))
+				}
+			}
+			return strings.Join(append(shardArgs, clusterShardBackupName(backupName, shardNum)), " ")
+		},
+		local: func(ctx context.Context, shardNum uint32) error {
+			b.skipUnchangedFrom = shardBackupName(skipUnchangedFrom, shardNum)
+			defer func() {
+				b.skipUnchangedFrom = skipUnchangedFrom
+			}()
+			return b.CreateToRemote(clusterShardBackupName(backupName, shardNum), shardBackupName(diffFrom, shardNum), shardBackupName(diffFromRemote, shardNum), tablePattern, partitions, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, waitMutations, resume, version, commandId)
+		},
+	})
+	for i := range shardBackups {
+		shardBackups[i].BackupName = clusterShardBackupName(backupName, shardBackups[i].ShardNum)
+	}
+	// manifest uploaded even when some shards failed, to see which shards shall be created again
+	if err = b.uploadClusterManifest(ctx, metadata.ClusterBackupMetadata{
+		BackupName:   backupName,
+		Cluster:      cluster,
+		CreationDate: creationDate,
+		Shards:       shardBackups,
+	}); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return runErr
+	}
+	log.Info("done")
+	return nil
+}
+
+func (b *Backuper) uploadClusterManifest(ctx context.Context, manifest metadata.ClusterBackupMetadata) error {
+	body, err := json.MarshalIndent(&manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, manifest.BackupName)
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to %s: %v", bd.Kind(), err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	manifestKey := path.Join(storage.ClusterManifestsPrefix, manifest.BackupName+".json")
+	if err = bd.PutFile(ctx, manifestKey, io.NopCloser(bytes.NewReader(body))); err != nil {
+		return fmt.Errorf("can't upload %s: %v", manifestKey, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeInterruptedCreate(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.UseResumableState = true
+	defaultPath := t.TempDir()
+	backupPath := path.Join(defaultPath, "backup", "backup1")
+	require.NoError(t, os.MkdirAll(backupPath, 0750))
+	params := map[string]interface{}{"tablePattern": "db.*"}
+	tableMetadataFile := path.Join(backupPath, "metadata", "db", "t1.json")
+
+	b := &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test")}
+	assert.False(t, b.resumeInterruptedCreate(backupPath), "backup without create.state shall be created from scratch")
+	// interrupted run, state is kept with `use_resumable_state: true`
+	state, err := b.openCreateResumableState(defaultPath, "backup1", params)
+	require.NoError(t, err)
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.True(t, keepFailedCreate(canceledCtx, state, false))
+	assert.True(t, keepFailedCreate(context.Background(), state, true), "explicit --resume shall keep partial backup")
+	assert.False(t, keepFailedCreate(context.Background(), state, false), "ordinary failure shall remove partial backup")
+	assert.False(t, keepFailedCreate(canceledCtx, nil, true))
+	state.AppendToState(tableMetadataFile, 100)
+	state.Close()
+
+	b = &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test")}
+	require.True(t, b.resumeInterruptedCreate(backupPath))
+	assert.True(t, b.resume)
+	state, err = b.openCreateResumableState(defaultPath, "backup1", params)
+	require.NoError(t, err)
+	assert.True(t, state.IsAlreadyProcessedBool(tableMetadataFile), "tables created by failed run shall be reused")
+	state.Close()
+	_, err = b.openCreateResumableState(defaultPath, "backup1", map[string]interface{}{"tablePattern": "other.*"})
+	assert.ErrorContains(t, err, "can't resume create backup1")
+
+	cfg.General.UseResumableState = false
+	b = &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test")}
+	assert.False(t, b.resumeInterruptedCreate(backupPath), "without use_resumable_state create shall not continue automatically")
+}
@@ -0,0 +1,390 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/dedup"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	"github.com/eapache/go-resiliency/retrier"
+)
+
+const (
+	// chunksManifestSuffix - `<disk>_<part>.chunks.json` stored in table.Files instead of archive name
+	chunksManifestSuffix = ".chunks.json"
+	// dedupChunksListFile - sorted sha256 of all chunks uploaded or reused by backup, one per line
+	dedupChunksListFile = "chunks.list"
+	// dedupChunksMinAge - chunks could be uploaded by concurrent `upload` which doesn't write chunks.list yet
+	dedupChunksMinAge = 24 * time.Hour
+	// dedupLeasesDir - `.chunks/leases/<backup_name>` written by each running `upload`, garbage collection is skipped while any lease is present,
+	// chunk keys always have two hex chars directory, so markers can't clash with chunks
+	dedupLeasesDir = "leases"
+	// dedupGCLockFile - `.chunks/gc.lock` written during garbage collection, `upload` waits until it removed before reuse any existing chunk
+	dedupGCLockFile = "gc.lock"
+	// dedupMarkerRefreshInterval - leases and gc.lock are rewritten periodically, markers which are not refreshed longer than dedupMarkerMaxAge are left by killed process
+	dedupMarkerRefreshInterval = 10 * time.Minute
+	dedupMarkerMaxAge          = 3 * dedupMarkerRefreshInterval
+	dedupGCLockWaitInterval    = 10 * time.Second
+)
+
+func dedupLeaseKey(backupName string) string {
+	return path.Join(storage.DedupChunksPrefix, dedupLeasesDir, backupName)
+}
+
+// putDedupMarker - write marker and rewrite it each dedupMarkerRefreshInterval until returned release called, release deletes marker
+func (b *Backuper) putDedupMarker(ctx context.Context, bd *storage.BackupDestination, key string) (func(), error) {
+	log := b.log.WithField("logger", "putDedupMarker")
+	put := func(ctx context.Context) error {
+		body := []byte(time.Now().UTC().Format(time.RFC3339))
+		retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+		return retry.RunCtx(ctx, func(ctx context.Context) error {
+			return bd.PutFile(ctx, key, io.NopCloser(bytes.NewReader(body)))
+		})
+	}
+	if err := put(ctx); err != nil {
+		return nil, fmt.Errorf("can't upload %s: %v", key, err)
+	}
+	refreshCtx, stopRefresh := context.WithCancel(ctx)
+	refreshDone := make(chan struct{})
+	go func() {
+		defer close(refreshDone)
+		ticker := time.NewTicker(dedupMarkerRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-refreshCtx.Done():
+				return
+			case <-ticker.C:
+				if err := put(refreshCtx); err != nil && refreshCtx.Err() == nil {
+					log.Warnf("can't refresh %s: %v", key, err)
+				}
+			}
+		}
+	}()
+	released := false
+	return func() {
+		if released {
+			return
+		}
+		released = true
+		stopRefresh()
+		<-refreshDone
+		cleanupCtx, cancel := newCleanupContext(ctx)
+		defer cancel()
+		if err := bd.DeleteFile(cleanupCtx, key); err != nil {
+			log.Warnf("can't delete %s: %v", key, err)
+		}
+	}, nil
+}
+
+// isDedupMarkerActive - marker is present and refreshed recently
+func isDedupMarkerActive(f storage.RemoteFile) bool {
+	return time.Since(f.LastModified()) < dedupMarkerMaxAge
+}
+
+// acquireDedupLease - protect chunks which current upload reuse from removeUnreferencedChunks, lease written before gc.lock check,
+// and garbage collection writes gc.lock before leases check, so at least one of them will see other one
+func (b *Backuper) acquireDedupLease(ctx context.Context, backupName string) (func(), error) {
+	release, err := b.putDedupMarker(ctx, b.dst, dedupLeaseKey(backupName))
+	if err != nil {
+		return nil, err
+	}
+	gcLockKey := path.Join(storage.DedupChunksPrefix, dedupGCLockFile)
+	for {
+		gcLock, err := b.dst.StatFile(ctx, gcLockKey)
+		if err != nil || !isDedupMarkerActive(gcLock) {
+			return release, nil
+		}
+		b.log.WithField("logger", "acquireDedupLease").Infof("%s is present, wait until garbage collection of unreferenced chunks finish", gcLockKey)
+		select {
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		case <-time.After(dedupGCLockWaitInterval):
+		}
+	}
+}
+
+func dedupChunkKey(hash string) string {
+	return path.Join(storage.DedupChunksPrefix, hash[:2], hash)
+}
+
+// registerDedupChunk - return false when chunk already referenced by current upload, so existence on remote storage already checked
+func (b *Backuper) registerDedupChunk(hash string) bool {
+	b.dedupChunksMx.Lock()
+	defer b.dedupChunksMx.Unlock()
+	if _, exists := b.dedupChunks[hash]; exists {
+		return false
+	}
+	b.dedupChunks[hash] = struct{}{}
+	return true
+}
+
+// uploadPartChunks - split each local file into chunks, upload chunks which don't exist on remote storage, and upload part manifest
+func (b *Backuper) uploadPartChunks(ctx context.Context, localBasePath string, localFiles []string, remoteManifest string) (int64, error) {
+	manifest := metadata.PartChunks{Files: make([]metadata.ChunkedFile, 0, len(localFiles))}
+	uploadedBytes := int64(0)
+	for _, localFile := range localFiles {
+		chunkedFile, fileUploadedBytes, err := b.uploadFileChunks(ctx, localBasePath, localFile)
+		if err != nil {
+			return 0, err
+		}
+		manifest.Files = append(manifest.Files, chunkedFile)
+		uploadedBytes += fileUploadedBytes
+	}
+	body, err := json.MarshalIndent(&manifest, "", "\t")
+	if err != nil {
+		return 0, err
+	}
+	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	err = retry.RunCtx(ctx, func(ctx context.Context) error {
+		return b.dst.PutFile(ctx, remoteManifest, io.NopCloser(bytes.NewReader(body)))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("can't upload %s: %v", remoteManifest, err)
+	}
+	return uploadedBytes + int64(len(body)), nil
+}
+
+func (b *Backuper) uploadFileChunks(ctx context.Context, localBasePath, localFile string) (metadata.ChunkedFile, int64, error) {
+	chunkedFile := metadata.ChunkedFile{Name: strings.TrimPrefix(localFile, "/"), Chunks: make([]string, 0)}
+	f, err := os.Open(path.Join(localBasePath, localFile))
+	if err != nil {
+		return chunkedFile, 0, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			b.log.WithField("logger", "uploadFileChunks").Warnf("can't close %s: %v", f.Name(), err)
+		}
+	}()
+	chunker, err := dedup.NewChunker(f, int(b.cfg.General.DedupChunkSize))
+	if err != nil {
+		return chunkedFile, 0, err
+	}
+	uploadedBytes := int64(0)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return chunkedFile, 0, fmt.Errorf("can't read %s: %v", f.Name(), err)
+		}
+		hash := dedup.Hash(chunk)
+		chunkedFile.Size += int64(len(chunk))
+		chunkedFile.Chunks = append(chunkedFile.Chunks, hash)
+		if !b.registerDedupChunk(hash) {
+			continue
+		}
+		chunkKey := dedupChunkKey(hash)
+		// uploaded by previous backup or by another replica with the same remote path
+		if _, err = b.dst.StatFile(ctx, chunkKey); err == nil {
+			continue
+		}
+		retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+		err = retry.RunCtx(ctx, func(ctx context.Context) error {
+			return b.dst.PutFile(ctx, chunkKey, io.NopCloser(bytes.NewReader(chunk)))
+		})
+		if err != nil {
+			return chunkedFile, 0, fmt.Errorf("can't upload %s: %v", chunkKey, err)
+		}
+		uploadedBytes += int64(len(chunk))
+	}
+	return chunkedFile, uploadedBytes, nil
+}
+
+// registerRemotePartChunks - part manifest uploaded during previous `upload --resume` run, chunks shall be present in chunks.list
+func (b *Backuper) registerRemotePartChunks(ctx context.Context, remoteManifest string) error {
+	body, err := b.readRemoteFile(ctx, remoteManifest)
+	if err != nil {
+		return err
+	}
+	manifest := metadata.PartChunks{}
+	if err = json.Unmarshal(body, &manifest); err != nil {
+		return fmt.Errorf("can't parse %s: %v", remoteManifest, err)
+	}
+	for _, chunkedFile := range manifest.Files {
+		for _, hash := range chunkedFile.Chunks {
+			b.registerDedupChunk(hash)
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) uploadDedupChunksList(ctx context.Context, backupName string) (int64, error) {
+	hashes := make([]string, 0, len(b.dedupChunks))
+	for hash := range b.dedupChunks {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	body := []byte(strings.Join(hashes, "\n"))
+	remoteChunksList := path.Join(backupName, dedupChunksListFile)
+	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		return b.dst.PutFile(ctx, remoteChunksList, io.NopCloser(bytes.NewReader(body)))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("can't upload %s: %v", remoteChunksList, err)
+	}
+	return int64(len(body)), nil
+}
+
+// downloadPartChunks - download part manifest and assemble each file from chunks into localDir, chunk content verified by sha256
+func (b *Backuper) downloadPartChunks(ctx context.Context, remoteManifest, localDir string) error {
+	body, err := b.readRemoteFile(ctx, remoteManifest)
+	if err != nil {
+		return err
+	}
+	manifest := metadata.PartChunks{}
+	if err = json.Unmarshal(body, &manifest); err != nil {
+		return fmt.Errorf("can't parse %s: %v", remoteManifest, err)
+	}
+	for _, chunkedFile := range manifest.Files {
+		if err = b.downloadFileChunks(ctx, chunkedFile, path.Join(localDir, chunkedFile.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) downloadFileChunks(ctx context.Context, chunkedFile metadata.ChunkedFile, localFile string) error {
+	if err := os.MkdirAll(path.Dir(localFile), 0750); err != nil {
+		return err
+	}
+	f, err := os.Create(localFile)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	written := int64(0)
+	for _, hash := range chunkedFile.Chunks {
+		chunkKey := dedupChunkKey(hash)
+		reader, err := b.dst.GetFileReader(ctx, chunkKey)
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("can't download %s: %v", chunkKey, err)
+		}
+		hasher := sha256.New()
+		n, err := io.Copy(io.MultiWriter(w, hasher), reader)
+		if closeErr := reader.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("can't download %s: %v", chunkKey, err)
+		}
+		if actualHash := hex.EncodeToString(hasher.Sum(nil)); actualHash != hash {
+			_ = f.Close()
+			return fmt.Errorf("%s is corrupted, actual sha256 %s", chunkKey, actualHash)
+		}
+		written += n
+	}
+	if err = w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if written != chunkedFile.Size {
+		return fmt.Errorf("%s size %d, expected %d", localFile, written, chunkedFile.Size)
+	}
+	return nil
+}
+
+// removeUnreferencedChunks - delete chunks which are not present in chunks.list of any remote backup.
+// `upload` could reuse existing chunk which is not referenced by any finished backup, and write chunks.list only at the end,
+// so garbage collection is skipped while any `upload` holds lease, see acquireDedupLease, chunks younger than dedupChunksMinAge are kept too
+func (b *Backuper) removeUnreferencedChunks(ctx context.Context, bd *storage.BackupDestination) error {
+	log := b.log.WithField("logger", "removeUnreferencedChunks")
+	start := time.Now()
+	releaseGCLock, err := b.putDedupMarker(ctx, bd, path.Join(storage.DedupChunksPrefix, dedupGCLockFile))
+	if err != nil {
+		return err
+	}
+	defer releaseGCLock()
+	activeLeases := make([]string, 0)
+	err = bd.Walk(ctx, path.Join(storage.DedupChunksPrefix, dedupLeasesDir)+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+		if isDedupMarkerActive(f) {
+			activeLeases = append(activeLeases, path.Base(f.Name()))
+		}
+		return nil
+	})
+	// SFTP can't walk on non exists paths and return error
+	if err != nil && !strings.Contains(err.Error(), "not exist") {
+		return err
+	}
+	if len(activeLeases) > 0 {
+		log.Infof("skip garbage collection, upload of %s is in progress", strings.Join(activeLeases, ", "))
+		return nil
+	}
+	backupList, err := bd.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]struct{})
+	for _, backup := range backupList {
+		if backup.Legacy || backup.DataFormat != ChunksFormat {
+			continue
+		}
+		remoteChunksList := path.Join(backup.BackupName, dedupChunksListFile)
+		reader, err := bd.GetFileReader(ctx, remoteChunksList)
+		if err != nil {
+			return fmt.Errorf("can't read %s, skip garbage collection: %v", remoteChunksList, err)
+		}
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			if hash := strings.TrimSpace(scanner.Text()); hash != "" {
+				referenced[hash] = struct{}{}
+			}
+		}
+		closeErr := reader.Close()
+		if err = scanner.Err(); err != nil {
+			return fmt.Errorf("can't read %s, skip garbage collection: %v", remoteChunksList, err)
+		}
+		if closeErr != nil {
+			return fmt.Errorf("can't close %s, skip garbage collection: %v", remoteChunksList, closeErr)
+		}
+	}
+	unreferenced := make([]string, 0)
+	deletedBytes := int64(0)
+	err = bd.Walk(ctx, storage.DedupChunksPrefix+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+		chunkKey, _ := storage.SegmentBaseKey(strings.TrimPrefix(f.Name(), "/"))
+		if chunkKey == dedupGCLockFile || strings.HasPrefix(chunkKey, dedupLeasesDir+"/") {
+			return nil
+		}
+		if _, exists := referenced[path.Base(chunkKey)]; exists || time.Since(f.LastModified()) < dedupChunksMinAge {
+			return nil
+		}
+		unreferenced = append(unreferenced, path.Join(storage.DedupChunksPrefix, strings.TrimPrefix(f.Name(), "/")))
+		deletedBytes += f.Size()
+		return nil
+	})
+	if err != nil {
+		// SFTP can't walk on non exists paths and return error
+		if strings.Contains(err.Error(), "not exist") {
+			return nil
+		}
+		return err
+	}
+	for _, chunkKey := range unreferenced {
+		if err = bd.DeleteFile(ctx, chunkKey); err != nil {
+			return fmt.Errorf("can't delete %s: %v", chunkKey, err)
+		}
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(start))).Infof("deleted %d unreferenced chunks (%s), %d chunks still referenced", len(unreferenced), utils.FormatBytes(uint64(deletedBytes)), len(referenced))
+	return nil
+}
@@ -0,0 +1,113 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryRemoteFile struct {
+	name         string
+	size         int64
+	lastModified time.Time
+}
+
+func (f memoryRemoteFile) Size() int64             { return f.size }
+func (f memoryRemoteFile) Name() string            { return f.name }
+func (f memoryRemoteFile) LastModified() time.Time { return f.lastModified }
+
+// memoryStorage - keys with modification time, enough for dedup markers
+type memoryStorage struct {
+	storage.RemoteStorage
+	mx    sync.Mutex
+	files map[string]time.Time
+}
+
+func (m *memoryStorage) Kind() string {
+	return "memory"
+}
+
+func (m *memoryStorage) StatFile(ctx context.Context, key string) (storage.RemoteFile, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if lastModified, exists := m.files[key]; exists {
+		return memoryRemoteFile{name: key, lastModified: lastModified}, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *memoryStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.files[key] = time.Now()
+	return r.Close()
+}
+
+func (m *memoryStorage) DeleteFile(ctx context.Context, key string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	delete(m.files, key)
+	return nil
+}
+
+func (m *memoryStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(context.Context, storage.RemoteFile) error) error {
+	m.mx.Lock()
+	files := make([]storage.RemoteFile, 0)
+	for key, lastModified := range m.files {
+		if strings.HasPrefix(key, prefix) {
+			files = append(files, memoryRemoteFile{name: strings.TrimPrefix(key, prefix), lastModified: lastModified})
+		}
+	}
+	m.mx.Unlock()
+	for _, f := range files {
+		if err := fn(ctx, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestDedupLease(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RetriesOnFailure = 0
+	remote := &memoryStorage{files: map[string]time.Time{}}
+	b := &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test")}
+	b.dst = &storage.BackupDestination{RemoteStorage: remote, Log: b.log}
+	ctx := context.Background()
+	oldChunk := dedupChunkKey("aa00000000000000000000000000000000000000000000000000000000000000")
+	remote.files[oldChunk] = time.Now().Add(-2 * dedupChunksMinAge)
+
+	release, err := b.acquireDedupLease(ctx, "backup1")
+	require.NoError(t, err)
+	assert.Contains(t, remote.files, dedupLeaseKey("backup1"))
+	require.NoError(t, b.removeUnreferencedChunks(ctx, b.dst))
+	assert.Contains(t, remote.files, oldChunk, "garbage collection shall be skipped while upload is in progress")
+	assert.NotContains(t, remote.files, path.Join(storage.DedupChunksPrefix, dedupGCLockFile))
+	release()
+	release()
+	assert.NotContains(t, remote.files, dedupLeaseKey("backup1"))
+
+	gcLockKey := path.Join(storage.DedupChunksPrefix, dedupGCLockFile)
+	remote.files[gcLockKey] = time.Now()
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = b.acquireDedupLease(timeoutCtx, "backup2")
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "upload shall wait while garbage collection is running")
+	assert.NotContains(t, remote.files, dedupLeaseKey("backup2"))
+
+	remote.files[gcLockKey] = time.Now().Add(-2 * dedupMarkerMaxAge)
+	release, err = b.acquireDedupLease(ctx, "backup2")
+	require.NoError(t, err, "stale gc.lock shall be ignored")
+	release()
+}
@@ -34,6 +34,9 @@ func (b *Backuper) Clean(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if b.dryRun {
+		return b.cleanDryRun(disks, log)
+	}
 	for _, disk := range disks {
 		if disk.IsBackup {
 			continue
@@ -65,12 +68,22 @@ func (b *Backuper) cleanDir(dirName string) error {
 
 // Delete - remove local or remote backup
 func (b *Backuper) Delete(backupType, backupName string, commandId int) error {
+	b.setCommandLog(commandId)
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
 	}
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
+	if backupName == "" {
+		return fmt.Errorf("backup name must be defined")
+	}
+	if backupName, err = b.ResolveExistingBackupName(ctx, backupType, backupName); err != nil {
+		return err
+	}
+	if b.dryRun {
+		return b.deleteDryRun(ctx, backupType, backupName)
+	}
 
 	switch backupType {
 	case "local":
@@ -82,10 +95,11 @@ func (b *Backuper) Delete(backupType, backupName string, commandId int) error {
 	}
 }
 
-func (b *Backuper) RemoveOldBackupsLocal(ctx context.Context, keepLastBackup bool, disks []clickhouse.Disk) error {
+// getBackupsToKeepLocal - how much local backups general->backups_to_keep_local keeps, false means local retention is disabled
+func (b *Backuper) getBackupsToKeepLocal(keepLastBackup bool) (int, bool) {
 	keep := b.cfg.General.BackupsToKeepLocal
 	if keep == 0 {
-		return nil
+		return 0, false
 	}
 	// fix https://github.com/Altinity/clickhouse-backup/issues/698
 	if keep < 0 {
@@ -94,6 +108,14 @@ func (b *Backuper) RemoveOldBackupsLocal(ctx context.Context, keepLastBackup boo
 			keep = 1
 		}
 	}
+	return keep, true
+}
+
+func (b *Backuper) RemoveOldBackupsLocal(ctx context.Context, keepLastBackup bool, disks []clickhouse.Disk) error {
+	keep, isEnabled := b.getBackupsToKeepLocal(keepLastBackup)
+	if !isEnabled {
+		return nil
+	}
 
 	backupList, disks, err := b.GetLocalBackups(ctx, disks)
 	if err != nil {
@@ -104,6 +126,7 @@ func (b *Backuper) RemoveOldBackupsLocal(ctx context.Context, keepLastBackup boo
 		if err := b.RemoveBackupLocal(ctx, backup.BackupName, disks); err != nil {
 			return err
 		}
+		b.notifyRetentionDelete("local", []string{backup.BackupName})
 	}
 	return nil
 }
@@ -174,6 +197,8 @@ func (b *Backuper) RemoveBackupLocal(ctx context.Context, backupName string, dis
 					return err
 				}
 			}
+			// resume manifests of object disk copy are placed outside backupPath
+			b.removeObjectDiskCopyStates(backupName, disks)
 			log.WithField("operation", "delete").
 				WithField("location", "local").
 				WithField("backup", backupName).
@@ -321,7 +346,8 @@ func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) er
 			if skip, err := b.skipIfSameLocalBackupPresent(ctx, backup.BackupName, backup.Tags); err != nil {
 				return err
 			} else if !skip {
-				if strings.Contains(backup.Tags, "embedded") {
+				// objects written by BACKUP TO S3(...) or AzureBlobStorage(...) are under backup prefix and removed with other files
+				if strings.Contains(backup.Tags, "embedded") && !strings.Contains(backup.Tags, embeddedRemoteTag) {
 					if err = b.cleanRemoteEmbedded(ctx, backup, bd); err != nil {
 						log.Warnf("b.cleanRemoteEmbedded return error: %v", err)
 						return err
@@ -336,6 +362,12 @@ func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) er
 				log.Warnf("bd.RemoveBackup return error: %v", err)
 				return err
 			}
+			if backup.DataFormat == ChunksFormat {
+				if err = b.removeUnreferencedChunks(ctx, bd); err != nil {
+					log.Warnf("b.removeUnreferencedChunks return error: %v", err)
+					return err
+				}
+			}
 			log.WithFields(apexLog.Fields{
 				"backup":    backupName,
 				"location":  "remote",
@@ -355,28 +387,59 @@ func (b *Backuper) cleanRemoteBackupObjectDisks(ctx context.Context, backup stor
 	if !backup.Legacy && len(backup.Disks) > 0 && backup.DiskTypes != nil && len(backup.DiskTypes) < len(backup.Disks) {
 		return fmt.Errorf("RemoveRemoteBackupObjectDisks: invalid backup.DiskTypes=%#v, not correlated with backup.Disks=%#v", backup.DiskTypes, backup.Disks)
 	}
-	return b.dst.Walk(ctx, backup.BackupName+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
-		fName := path.Join(backup.BackupName, f.Name())
-		if !strings.HasPrefix(fName, path.Join(backup.BackupName, "/shadow/")) {
-			return nil
-		}
-		for diskName, diskType := range backup.DiskTypes {
-			if diskType == "s3" || diskType == "azure_blob_storage" {
-				compressedRE := regexp.MustCompile(`/shadow/([^/]+/[^/]+)/` + diskName + `_[^/]+$`)
-				if matches := compressedRE.FindStringSubmatch(fName); len(matches) > 0 {
-					// compressed remote object disk part
-					localPath := path.Join(backup.Disks[diskName], "backup", backup.BackupName, "shadow", matches[1], diskName)
-					if err := b.dst.DownloadCompressedStream(ctx, fName, localPath); err != nil {
-						return err
-					}
-					filepath.Walk(localPath, func(fPath string, fInfo fs.FileInfo, err error) error {
-						if err != nil {
+	if err := b.loadZstdDictionaries(ctx, &backup.BackupMetadata); err != nil {
+		return err
+	}
+	// segment size could be different from current config when backup uploaded
+	ctx = storage.WithSegmentSize(ctx, backup.SegmentSize)
+	for _, dataPath := range storage.GetRemoteBackupDataPaths(backup.BackupMetadata) {
+		err := b.dst.Walk(ctx, dataPath+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+			fName := path.Join(dataPath, f.Name())
+			if !strings.HasPrefix(fName, path.Join(dataPath, "/shadow/")) {
+				return nil
+			}
+			// additional segments read by GetFileReader together with first segment
+			if _, isSegment := storage.SegmentBaseKey(fName); isSegment && backup.SegmentSize > 0 {
+				return nil
+			}
+			for diskName, diskType := range backup.DiskTypes {
+				if diskType == "s3" || diskType == "azure_blob_storage" {
+					compressedRE := regexp.MustCompile(`/shadow/([^/]+/[^/]+)/` + diskName + `_[^/]+$`)
+					if matches := compressedRE.FindStringSubmatch(fName); len(matches) > 0 {
+						// compressed remote object disk part
+						localPath := path.Join(backup.Disks[diskName], "backup", backup.BackupName, "shadow", matches[1], diskName)
+						if err := b.dst.DownloadCompressedStream(ctx, fName, localPath); err != nil {
 							return err
 						}
-						if fInfo.IsDir() {
+						filepath.Walk(localPath, func(fPath string, fInfo fs.FileInfo, err error) error {
+							if err != nil {
+								return err
+							}
+							if fInfo.IsDir() {
+								return nil
+							}
+							objMeta, err := object_disk.ReadMetadataFromFile(fPath)
+							if err != nil {
+								return err
+							}
+							for _, storageObject := range objMeta.StorageObjects {
+								err = b.dst.DeleteFileFromObjectDiskBackup(ctx, path.Join(backup.BackupName, diskName, storageObject.ObjectRelativePath))
+								if err != nil {
+									return err
+								}
+							}
 							return nil
+						})
+						if err := os.RemoveAll(localPath); err != nil {
+							return err
+						}
+					} else if regexp.MustCompile(`/shadow/[^/]+/[^/]+/` + diskName + `/.+$`).MatchString(fName) {
+						// non compressed remote object disk part
+						objMetaReader, err := b.dst.GetFileReader(ctx, fName)
+						if err != nil {
+							return err
 						}
-						objMeta, err := object_disk.ReadMetadataFromFile(fPath)
+						objMeta, err := object_disk.ReadMetadataFromReader(objMetaReader, fName)
 						if err != nil {
 							return err
 						}
@@ -386,32 +449,16 @@ func (b *Backuper) cleanRemoteBackupObjectDisks(ctx context.Context, backup stor
 								return err
 							}
 						}
-						return nil
-					})
-					if err := os.RemoveAll(localPath); err != nil {
-						return err
-					}
-				} else if regexp.MustCompile(`/shadow/[^/]+/[^/]+/` + diskName + `/.+$`).MatchString(fName) {
-					// non compressed remote object disk part
-					objMetaReader, err := b.dst.GetFileReader(ctx, fName)
-					if err != nil {
-						return err
-					}
-					objMeta, err := object_disk.ReadMetadataFromReader(objMetaReader, fName)
-					if err != nil {
-						return err
-					}
-					for _, storageObject := range objMeta.StorageObjects {
-						err = b.dst.DeleteFileFromObjectDiskBackup(ctx, path.Join(backup.BackupName, diskName, storageObject.ObjectRelativePath))
-						if err != nil {
-							return err
-						}
 					}
 				}
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
 func (b *Backuper) cleanRemoteEmbedded(ctx context.Context, backup storage.Backup, bd *storage.BackupDestination) error {
@@ -452,7 +499,53 @@ func (b *Backuper) skipIfSameLocalBackupPresent(ctx context.Context, backupName,
 	return false, nil
 }
 
+// CleanRemote - apply `backups_to_keep_remote`, `keep_daily_remote`, `keep_weekly_remote`, `keep_monthly_remote` and `min_age_remote` retention policy to remote backups
+func (b *Backuper) CleanRemote(dryRun bool, commandId int) error {
+	b.setCommandLog(commandId)
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("clean_remote doesn't support remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	retention := storage.NewBackupRetention(b.cfg)
+	if !retention.IsEnabled() {
+		return fmt.Errorf("retention policy is not defined, setup backups_to_keep_remote, keep_daily_remote, keep_weekly_remote or keep_monthly_remote")
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	deletedBackups, err := bd.RemoveOldBackups(ctx, retention, dryRun)
+	if err != nil {
+		return err
+	}
+	b.notifyRetentionDelete("remote", getBackupNames(deletedBackups))
+	if b.cfg.General.DedupStore && !dryRun {
+		return b.removeUnreferencedChunks(ctx, bd)
+	}
+	return nil
+}
+
 func (b *Backuper) CleanRemoteBroken(commandId int) error {
+	b.setCommandLog(commandId)
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+)
+
+const identifierPattern = "(?:`[^`]+`|\"[^\"]+\"|[A-Za-z_][A-Za-z0-9_$]*)"
+
+var (
+	// queryReferenceRE - tables in SELECT of views, materialized views, live and window views, and target table of `TO db.table`
+	queryReferenceRE = regexp.MustCompile(`(?i)\b(?:FROM|JOIN|TO)\s+(` + identifierPattern + `(?:\s*\.\s*` + identifierPattern + `)?)`)
+	// dictionarySourceRE - SOURCE(CLICKHOUSE(... DB 'db' TABLE 'table' ...)) of dictionary with local source table
+	dictionarySourceRE   = regexp.MustCompile(`(?is)SOURCE\s*\(\s*CLICKHOUSE\s*\((.*?)\)\s*\)`)
+	dictionaryDatabaseRE = regexp.MustCompile(`(?i)\bDB\s+'([^']*)'`)
+	dictionaryTableRE    = regexp.MustCompile(`(?i)\bTABLE\s+'([^']*)'`)
+	// distributedEngineRE - Distributed('cluster', 'db', 'table'[, sharding_key])
+	distributedEngineRE = regexp.MustCompile(`ENGINE = Distributed\(\s*'?[^,]+'?\s*,\s*'?([^,']+)'?\s*,\s*'?([^,')]+)'?`)
+	qualifiedNameRE     = regexp.MustCompile(`^(` + identifierPattern + `)\s*\.\s*(` + identifierPattern + `)$`)
+)
+
+func unquoteIdentifier(name string) string {
+	return strings.Trim(strings.TrimSpace(name), "`\"")
+}
+
+// getQueryDependencies - full names of tables which shall exist before CREATE query of table will execute, names without database belong to the same database as table
+func getQueryDependencies(table metadata.TableMetadata) []string {
+	dependencies := make([]string, 0)
+	addDependency := func(database, name string) {
+		database, name = unquoteIdentifier(database), unquoteIdentifier(name)
+		if database == "" {
+			database = table.Database
+		}
+		if database == table.Database && name == table.Table {
+			return
+		}
+		dependencies = append(dependencies, fmt.Sprintf("%s.%s", database, name))
+	}
+	for _, match := range queryReferenceRE.FindAllStringSubmatch(table.Query, -1) {
+		if reference := qualifiedNameRE.FindStringSubmatch(match[1]); reference != nil {
+			addDependency(reference[1], reference[2])
+		} else {
+			addDependency("", match[1])
+		}
+	}
+	if source := dictionarySourceRE.FindStringSubmatch(table.Query); source != nil {
+		if tableName := dictionaryTableRE.FindStringSubmatch(source[1]); tableName != nil {
+			database := ""
+			if databaseName := dictionaryDatabaseRE.FindStringSubmatch(source[1]); databaseName != nil {
+				database = databaseName[1]
+			}
+			addDependency(database, tableName[1])
+		}
+	}
+	if distributed := distributedEngineRE.FindStringSubmatch(table.Query); distributed != nil && !strings.Contains(distributed[1], "(") {
+		addDependency(distributed[1], distributed[2])
+	}
+	return dependencies
+}
+
+// sortTablesByDependencies - tables which referenced by dictionaries, views, materialized views, live and window views and Distributed tables go first,
+// stable for tables without dependencies between them, tables in dependency cycle keep original order and rely on retries in restoreSchemaRegular
+func sortTablesByDependencies(tables ListOfTables, log *apexLog.Entry) ListOfTables {
+	indexByName := make(map[string]int, len(tables))
+	for i, t := range tables {
+		indexByName[fmt.Sprintf("%s.%s", t.Database, t.Table)] = i
+	}
+	dependencies := make([][]int, len(tables))
+	for i, t := range tables {
+		for _, name := range getQueryDependencies(t) {
+			if j, exists := indexByName[name]; exists && j != i {
+				dependencies[i] = append(dependencies[i], j)
+			}
+		}
+	}
+	result := make(ListOfTables, 0, len(tables))
+	placed := make([]bool, len(tables))
+	for len(result) < len(tables) {
+		progress := false
+		for i := range tables {
+			if placed[i] {
+				continue
+			}
+			isReady := true
+			for _, j := range dependencies[i] {
+				if !placed[j] {
+					isReady = false
+					break
+				}
+			}
+			if isReady {
+				placed[i] = true
+				result = append(result, tables[i])
+				progress = true
+			}
+		}
+		if !progress {
+			for i := range tables {
+				if !placed[i] {
+					log.Warnf("`%s`.`%s` is a part of dependency cycle, will restore in original order", tables[i].Database, tables[i].Table)
+					placed[i] = true
+					result = append(result, tables[i])
+				}
+			}
+		}
+	}
+	return result
+}
+
+const (
+	MaterializedViewsRestore = "restore"
+	MaterializedViewsSkip    = "skip"
+	MaterializedViewsRebuild = "rebuild"
+)
+
+var (
+	createMaterializedViewRE = regexp.MustCompile(`^(?:CREATE|ATTACH) MATERIALIZED VIEW `)
+	toInnerUUIDRE            = regexp.MustCompile(`\s+TO INNER UUID '[^']+'`)
+	materializedViewToRE     = regexp.MustCompile(`^CREATE MATERIALIZED VIEW\s+` + identifierPattern + `(?:\s*\.\s*` + identifierPattern + `)?(?:\s+UUID '[^']+')?(?:\s+ON CLUSTER\s+\S+)?\s+TO\s`)
+)
+
+// WithMaterializedViewsMode - `restore` (default) attach materialized views with data of inner tables as is,
+// `skip` doesn't restore materialized views and their inner tables, `rebuild` creates materialized views after data restore, with POPULATE for views without `TO` target
+func WithMaterializedViewsMode(mode string) BackuperOpt {
+	return func(b *Backuper) {
+		b.materializedViewsMode = mode
+	}
+}
+
+func ValidateMaterializedViewsMode(mode string) error {
+	switch mode {
+	case "", MaterializedViewsRestore, MaterializedViewsSkip, MaterializedViewsRebuild:
+		return nil
+	}
+	return fmt.Errorf("unsupported --materialized-views=%s, shall be one of %s, %s, %s", mode, MaterializedViewsRestore, MaterializedViewsSkip, MaterializedViewsRebuild)
+}
+
+func isMaterializedViewInnerTable(table metadata.TableMetadata) bool {
+	return strings.HasPrefix(table.Table, ".inner.") || strings.HasPrefix(table.Table, ".inner_id.")
+}
+
+// filterMaterializedViews - exclude materialized views and their inner tables for `skip` and `rebuild` modes, inner tables will create by CREATE MATERIALIZED VIEW during rebuild
+func (b *Backuper) filterMaterializedViews(tables ListOfTables, withViews bool, log *apexLog.Entry) (filtered ListOfTables, views ListOfTables) {
+	if b.materializedViewsMode != MaterializedViewsSkip && b.materializedViewsMode != MaterializedViewsRebuild {
+		return tables, nil
+	}
+	filtered = make(ListOfTables, 0, len(tables))
+	for _, table := range tables {
+		if isMaterializedViewInnerTable(table) {
+			log.Debugf("skip `%s`.`%s`, --materialized-views=%s", table.Database, table.Table, b.materializedViewsMode)
+			continue
+		}
+		if withViews && createMaterializedViewRE.MatchString(table.Query) {
+			views = append(views, table)
+			continue
+		}
+		filtered = append(filtered, table)
+	}
+	return filtered, views
+}
+
+// rebuildMaterializedViews - create materialized views excluded from schema restore by --materialized-views=rebuild, after data of source and target tables restored
+func (b *Backuper) rebuildMaterializedViews(ctx context.Context, log *apexLog.Entry) error {
+	views := b.materializedViewsForRebuild
+	b.materializedViewsForRebuild = nil
+	if len(views) == 0 {
+		return nil
+	}
+	version, err := b.ch.GetVersion(ctx)
+	if err != nil {
+		return err
+	}
+	for _, view := range views {
+		query := toInnerUUIDRE.ReplaceAllString(strings.Replace(view.Query, "ATTACH MATERIALIZED VIEW", "CREATE MATERIALIZED VIEW", 1), "")
+		if !materializedViewToRE.MatchString(query) {
+			query = strings.Replace(query, " AS SELECT", " POPULATE AS SELECT", 1)
+		}
+		if err = b.ch.CreateTable(clickhouse.Table{Database: view.Database, Name: view.Table}, query, false, false, b.cfg.General.RestoreSchemaOnCluster, version, b.DefaultDataPath); err != nil {
+			return fmt.Errorf("can't rebuild materialized view `%s`.`%s`: %v", view.Database, view.Table, err)
+		}
+		b.trackRestoreCreatedTable(view)
+		log.Infof("materialized view `%s`.`%s` rebuilt", view.Database, view.Table)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortTablesByDependencies(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "dict", Query: "CREATE DICTIONARY db.dict (id UInt64, v String) PRIMARY KEY id SOURCE(CLICKHOUSE(DB 'db' TABLE 'src')) LIFETIME(0) LAYOUT(FLAT())"},
+		{Database: "db", Table: "mv", Query: "CREATE MATERIALIZED VIEW db.mv TO `db`.`dst` (id UInt64) AS SELECT id FROM src"},
+		{Database: "db", Table: "dist", Query: "CREATE TABLE db.dist (id UInt64) ENGINE = Distributed('cluster', 'db', 'src', rand())"},
+		{Database: "db", Table: "dst", Query: "CREATE TABLE db.dst (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "src", Query: "CREATE TABLE db.src (id UInt64, v String) ENGINE = MergeTree ORDER BY id"},
+	}
+	sorted := sortTablesByDependencies(tables, apexLog.WithField("logger", "test"))
+	names := make([]string, 0, len(sorted))
+	for _, table := range sorted {
+		names = append(names, table.Table)
+	}
+	assert.Equal(t, []string{"dst", "src", "dict", "mv", "dist"}, names)
+
+	cycle := ListOfTables{
+		{Database: "db", Table: "a", Query: "CREATE VIEW db.a AS SELECT * FROM db.b"},
+		{Database: "db", Table: "b", Query: "CREATE VIEW db.b AS SELECT * FROM db.a"},
+	}
+	assert.Equal(t, cycle, sortTablesByDependencies(cycle, apexLog.WithField("logger", "test")))
+}
+
+func TestGetQueryDependencies(t *testing.T) {
+	table := metadata.TableMetadata{Database: "db", Table: "v", Query: "CREATE VIEW db.v AS SELECT * FROM `other db`.`t1` AS t1 LEFT JOIN t2 USING id"}
+	assert.Equal(t, []string{"other db.t1", "db.t2"}, getQueryDependencies(table))
+}
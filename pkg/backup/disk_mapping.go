@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+
+	apexLog "github.com/apex/log"
+)
+
+// WithRestoreDiskMapping - `--restore-disk-mapping`, comma separated `source:target` pairs, source disk parts restore to target disk
+// and `storage_policy`, `disk` settings in DDL with source name replaced by target, so source could be a disk or a storage policy name,
+// rules validated by ValidateRestoreDiskMapping
+func WithRestoreDiskMapping(rules []string) BackuperOpt {
+	return func(b *Backuper) {
+		b.restoreDiskMapping, _ = parseRestoreDiskMapping(rules)
+	}
+}
+
+func ValidateRestoreDiskMapping(rules []string) error {
+	_, err := parseRestoreDiskMapping(rules)
+	return err
+}
+
+func parseRestoreDiskMapping(rules []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, rules := range rules {
+		for _, rule := range strings.Split(rules, ",") {
+			rule = strings.TrimSpace(rule)
+			if rule == "" {
+				continue
+			}
+			source, target, found := strings.Cut(rule, ":")
+			source, target = strings.TrimSpace(source), strings.TrimSpace(target)
+			if !found || source == "" || target == "" || source == target {
+				return nil, fmt.Errorf("invalid --restore-disk-mapping rule `%s`, expected `source:target` disk or storage policy names", rule)
+			}
+			if existing, exists := result[source]; exists && existing != target {
+				return nil, fmt.Errorf("invalid --restore-disk-mapping, `%s` mapped to `%s` and `%s`", source, existing, target)
+			}
+			result[source] = target
+		}
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// storageSettingRE - `storage_policy = 'name'` and `disk = 'name'` in SETTINGS clause, `disk = disk(...)` for dynamic disks is not changed
+var storageSettingRE = regexp.MustCompile(`\b(storage_policy|disk)(\s*=\s*)'([^']+)'`)
+
+// applyRestoreDiskMapping - replace `storage_policy` and `disk` settings in CREATE queries, target policy shall exist on destination server
+func (b *Backuper) applyRestoreDiskMapping(tables ListOfTables, log *apexLog.Entry) {
+	if len(b.restoreDiskMapping) == 0 {
+		return
+	}
+	for i := range tables {
+		query := storageSettingRE.ReplaceAllStringFunc(tables[i].Query, func(setting string) string {
+			match := storageSettingRE.FindStringSubmatch(setting)
+			target, isMapped := b.restoreDiskMapping[match[3]]
+			if !isMapped {
+				return setting
+			}
+			return match[1] + match[2] + "'" + target + "'"
+		})
+		if query != tables[i].Query {
+			log.Infof("--restore-disk-mapping change storage settings for `%s`.`%s`", tables[i].Database, tables[i].Table)
+			tables[i].Query = query
+		}
+	}
+}
+
+// mapRestoreDisks - source disks which don't exist on destination server added to disks with path and type of mapped target disk,
+// `download` places parts of mapped disks into target disk, so HardlinkBackupPartsToStorage finds them in backup on target disk
+// and GetDisksByPaths resolves table data path of target disk for both names
+func (b *Backuper) mapRestoreDisks(disks []clickhouse.Disk, diskMap, diskTypes map[string]string, backupDisks map[string]string) ([]clickhouse.Disk, error) {
+	if len(b.restoreDiskMapping) == 0 {
+		return disks, nil
+	}
+	existingDisks := make(map[string]clickhouse.Disk, len(disks))
+	for _, disk := range disks {
+		existingDisks[disk.Name] = disk
+	}
+	for source, target := range b.restoreDiskMapping {
+		if _, isBackupDisk := backupDisks[source]; !isBackupDisk {
+			continue
+		}
+		targetDisk, targetExists := existingDisks[target]
+		if !targetExists {
+			return nil, fmt.Errorf("--restore-disk-mapping %s:%s, disk `%s` not found in system.disks", source, target, target)
+		}
+		if _, sourceExists := existingDisks[source]; sourceExists {
+			return nil, fmt.Errorf("--restore-disk-mapping %s:%s, disk `%s` exists in system.disks, only missing disks could be mapped", source, target, source)
+		}
+		if sourceType, exists := diskTypes[source]; exists && sourceType != targetDisk.Type {
+			return nil, fmt.Errorf("--restore-disk-mapping %s:%s, disk types are different, %s != %s", source, target, sourceType, targetDisk.Type)
+		}
+		disks = append(disks, clickhouse.Disk{
+			Name:     source,
+			Path:     targetDisk.Path,
+			Type:     targetDisk.Type,
+			IsBackup: targetDisk.IsBackup,
+		})
+		diskMap[source] = targetDisk.Path
+		diskTypes[source] = targetDisk.Type
+	}
+	return disks, nil
+}
+
+// getRestoreDiskMappingPath - data of mapped source disk downloads into local backup on target disk
+func (b *Backuper) getRestoreDiskMappingPath(disk string) (string, bool) {
+	target, isMapped := b.restoreDiskMapping[disk]
+	if !isMapped {
+		return "", false
+	}
+	targetPath, targetExists := b.DiskToPathMap[target]
+	return targetPath, targetExists
+}
@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRestoreDiskMapping(t *testing.T) {
+	mapping, err := parseRestoreDiskMapping([]string{"fast_ssd:default, cold:hdd1", "tiered:default"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"fast_ssd": "default", "cold": "hdd1", "tiered": "default"}, mapping)
+
+	mapping, err = parseRestoreDiskMapping(nil)
+	require.NoError(t, err)
+	assert.Nil(t, mapping)
+
+	for _, invalid := range []string{"fast_ssd", "fast_ssd:", ":default", "default:default", "cold:hdd1,cold:hdd2"} {
+		assert.Error(t, ValidateRestoreDiskMapping([]string{invalid}), invalid)
+	}
+}
+
+func TestApplyRestoreDiskMapping(t *testing.T) {
+	b := &Backuper{}
+	WithRestoreDiskMapping([]string{"tiered:default,fast_ssd:hdd1"})(b)
+	tables := ListOfTables{
+		{Database: "db", Table: "t1", Query: "CREATE TABLE db.t1 (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS index_granularity = 8192, storage_policy = 'tiered'"},
+		{Database: "db", Table: "t2", Query: "CREATE TABLE db.t2 (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS disk='fast_ssd'"},
+		{Database: "db", Table: "t3", Query: "CREATE TABLE db.t3 (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS storage_policy = 'other'"},
+	}
+	b.applyRestoreDiskMapping(tables, apexLog.WithField("logger", "test"))
+	assert.Equal(t, "CREATE TABLE db.t1 (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS index_granularity = 8192, storage_policy = 'default'", tables[0].Query)
+	assert.Equal(t, "CREATE TABLE db.t2 (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS disk='hdd1'", tables[1].Query)
+	assert.Equal(t, "CREATE TABLE db.t3 (id UInt64) ENGINE = MergeTree ORDER BY id SETTINGS storage_policy = 'other'", tables[2].Query)
+}
+
+func TestMapRestoreDisks(t *testing.T) {
+	b := &Backuper{}
+	WithRestoreDiskMapping([]string{"fast_ssd:default,tiered:default"})(b)
+	disks := []clickhouse.Disk{{Name: "default", Path: "/var/lib/clickhouse", Type: "local"}}
+	diskMap := map[string]string{"default": "/var/lib/clickhouse", "fast_ssd": "/mnt/ssd"}
+	diskTypes := map[string]string{"default": "local", "fast_ssd": "local"}
+	backupDisks := map[string]string{"default": "/var/lib/clickhouse", "fast_ssd": "/mnt/ssd"}
+
+	mappedDisks, err := b.mapRestoreDisks(disks, diskMap, diskTypes, backupDisks)
+	require.NoError(t, err)
+	require.Len(t, mappedDisks, 2)
+	assert.Equal(t, clickhouse.Disk{Name: "fast_ssd", Path: "/var/lib/clickhouse", Type: "local"}, mappedDisks[1])
+	assert.Equal(t, "/var/lib/clickhouse", diskMap["fast_ssd"])
+
+	dstDataPaths := clickhouse.GetDisksByPaths(mappedDisks, []string{"/var/lib/clickhouse/store/abc/abcdef/"})
+	assert.Equal(t, "/var/lib/clickhouse/store/abc/abcdef/", dstDataPaths["fast_ssd"])
+
+	diskTypes["fast_ssd"] = "s3"
+	_, err = b.mapRestoreDisks(disks, diskMap, diskTypes, backupDisks)
+	assert.ErrorContains(t, err, "disk types are different")
+
+	WithRestoreDiskMapping([]string{"fast_ssd:hdd1"})(b)
+	_, err = b.mapRestoreDisks(disks, diskMap, diskTypes, backupDisks)
+	assert.ErrorContains(t, err, "not found in system.disks")
+}
+
+func TestGetRestoreDiskMappingPath(t *testing.T) {
+	b := &Backuper{DiskToPathMap: map[string]string{"default": "/var/lib/clickhouse", "hdd1": "/mnt/hdd1"}}
+	WithRestoreDiskMapping([]string{"cold:hdd1,fast_ssd:missing"})(b)
+	diskPath, isMapped := b.getRestoreDiskMappingPath("cold")
+	assert.True(t, isMapped)
+	assert.Equal(t, "/mnt/hdd1", diskPath)
+	_, isMapped = b.getRestoreDiskMappingPath("fast_ssd")
+	assert.False(t, isMapped)
+	_, isMapped = b.getRestoreDiskMappingPath("other")
+	assert.False(t, isMapped)
+}
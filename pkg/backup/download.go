@@ -9,10 +9,11 @@ import (
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"github.com/Altinity/clickhouse-backup/pkg/custom"
 	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
-	"github.com/Altinity/clickhouse-backup/pkg/partition"
 	"github.com/Altinity/clickhouse-backup/pkg/resumable"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/tracing"
 	"github.com/eapache/go-resiliency/retrier"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"io/fs"
 	"os"
@@ -67,7 +68,10 @@ func (b *Backuper) legacyDownload(ctx context.Context, backupName string) error
 	return nil
 }
 
-func (b *Backuper) Download(backupName string, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) error {
+func (b *Backuper) Download(backupName string, tablePattern string, partitions []string, partitionsWhere string, schemaOnly, resume bool, commandId int) (err error) {
+	b.setCommandLog(commandId)
+	tablePattern = b.tablePatternOrDefault(tablePattern)
+	b.applyResourceLimits()
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -75,6 +79,18 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	b.setLogComment("download", backupName, commandId)
+	notifyFinish := b.notifyOperation("download", backupName)
+	hooks := b.newOperationHooks("download", backupName)
+	ctx, span := tracing.Start(ctx, "download", attribute.String("backup", backupName))
+	defer func() {
+		err = hooks.after(ctx, err)
+		span.End(err)
+		notifyFinish(err)
+	}()
+	if err = hooks.before(ctx); err != nil {
+		return err
+	}
 	if err := b.ch.Connect(); err != nil {
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
@@ -94,6 +110,7 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		resume = true
 	}
 	b.resume = resume
+	b.partitionsWhere = partitionsWhere
 	if backupName == "" {
 		_ = b.PrintRemoteBackups(ctx, "all")
 		return fmt.Errorf("select backup for download")
@@ -103,7 +120,8 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		return err
 	}
 	for i := range localBackups {
-		if backupName == localBackups[i].BackupName {
+		// restore_remote pipeline download data into backup which contains only metadata
+		if backupName == localBackups[i].BackupName && (b.restorePipeline == nil || b.restorePipeline.backupName != backupName) {
 			if !b.resume {
 				return ErrBackupIsAlreadyExists
 			} else {
@@ -157,17 +175,29 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	if len(remoteBackup.Tables) == 0 && !b.cfg.General.AllowEmptyBackups {
 		return fmt.Errorf("'%s' is empty backup", backupName)
 	}
+	if err = b.loadZstdDictionaries(ctx, &remoteBackup.BackupMetadata); err != nil {
+		return err
+	}
 	tablesForDownload := parseTablePatternForDownload(remoteBackup.Tables, tablePattern)
 
 	if !schemaOnly && !b.cfg.General.DownloadByPart && remoteBackup.RequiredBackup != "" {
-		err := b.Download(remoteBackup.RequiredBackup, tablePattern, partitions, schemaOnly, b.resume, commandId)
+		// required backup keeps original names, parts are copied from it into current backup before mapping
+		databaseMapping, tableMapping := b.downloadDatabaseMapping, b.downloadTableMapping
+		b.downloadDatabaseMapping, b.downloadTableMapping = nil, nil
+		err := b.Download(remoteBackup.RequiredBackup, tablePattern, partitions, partitionsWhere, schemaOnly, b.resume, commandId)
+		b.downloadDatabaseMapping, b.downloadTableMapping = databaseMapping, tableMapping
 		if err != nil && !errors.Is(err, ErrBackupIsAlreadyExists) {
 			return err
 		}
 	}
+	// segment size could be different from current config when backup uploaded, set it after download of required backup which sets own segment size
+	b.dst.SetSegmentSize(remoteBackup.SegmentSize)
 
 	dataSize := uint64(0)
 	metadataSize := uint64(0)
+	if strings.Contains(remoteBackup.Tags, embeddedRemoteTag) {
+		return fmt.Errorf("%s created with `embedded_backup_target: remote` and can't be downloaded, use `restore_remote` with `embedded_backup_target: remote`", backupName)
+	}
 	b.isEmbedded = strings.Contains(remoteBackup.Tags, "embedded")
 	localBackupDir := path.Join(b.DefaultDataPath, "backup", backupName)
 	if b.isEmbedded {
@@ -190,6 +220,7 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	log.Debugf("prepare table METADATA concurrent semaphore with concurrency=%d len(tablesForDownload)=%d", b.cfg.General.DownloadConcurrency, len(tablesForDownload))
 	tableMetadataAfterDownload := make([]metadata.TableMetadata, len(tablesForDownload))
 	downloadSemaphore := semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
+	b.partsSemaphore = semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
 	metadataGroup, metadataCtx := errgroup.WithContext(ctx)
 	for i, t := range tablesForDownload {
 		if err := downloadSemaphore.Acquire(metadataCtx, 1); err != nil {
@@ -220,13 +251,31 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		for _, t := range tableMetadataAfterDownload {
 			for disk := range t.Parts {
 				if _, diskExists := b.DiskToPathMap[disk]; !diskExists && disk != b.cfg.ClickHouse.EmbeddedBackupDisk {
+					if targetPath, isMapped := b.getRestoreDiskMappingPath(disk); isMapped {
+						b.DiskToPathMap[disk] = targetPath
+						log.Infof("table '%s.%s' disk '%s' mapped to '%s' by --restore-disk-mapping, data will download to %s", t.Database, t.Table, disk, b.restoreDiskMapping[disk], targetPath)
+						continue
+					}
 					b.DiskToPathMap[disk] = b.DiskToPathMap["default"]
 					log.Warnf("table '%s.%s' require disk '%s' that not found in clickhouse table system.disks, you can add nonexistent disks to `disk_mapping` in  `clickhouse` config section, data will download to %s", t.Database, t.Table, disk, b.DiskToPathMap["default"])
 				}
 			}
 		}
+		if err = b.checkDownloadFreeSpace(ctx, tableMetadataAfterDownload, disks); err != nil {
+			return err
+		}
+		if err = b.rehydrateBackupData(ctx, remoteBackup.BackupMetadata, tableMetadataAfterDownload); err != nil {
+			return err
+		}
 		log.Debugf("prepare table SHADOW concurrent semaphore with concurrency=%d len(tableMetadataAfterDownload)=%d", b.cfg.General.DownloadConcurrency, len(tableMetadataAfterDownload))
 		dataGroup, dataCtx := errgroup.WithContext(ctx)
+		totalBytes := uint64(0)
+		for _, tableMetadata := range tableMetadataAfterDownload {
+			if !tableMetadata.MetadataOnly {
+				totalBytes += tableMetadata.TotalBytes
+			}
+		}
+		status.Current.SetTotalBytes(commandId, totalBytes)
 
 		for i, tableMetadata := range tableMetadataAfterDownload {
 			if tableMetadata.MetadataOnly {
@@ -241,15 +290,25 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 			dataGroup.Go(func() error {
 				defer downloadSemaphore.Release(1)
 				start := time.Now()
-				if err := b.downloadTableData(dataCtx, remoteBackup.BackupMetadata, tableMetadataAfterDownload[idx]); err != nil {
+				tableCtx, tableSpan := tracing.Start(dataCtx, "download_table", tableAttribute(tableMetadataAfterDownload[idx].Database, tableMetadataAfterDownload[idx].Table))
+				tableCtx, progressDone := withTableProgress(tableCtx, commandId, tableMetadataAfterDownload[idx])
+				err := b.downloadTableData(tableCtx, remoteBackup.BackupMetadata, tableMetadataAfterDownload[idx])
+				progressDone()
+				tableSpan.End(err)
+				if err != nil {
 					return err
 				}
+				status.Current.AddProgress(commandId, tableMetadataAfterDownload[idx].TotalBytes, tableMetadataAfterDownload[idx].TotalBytes)
 				log.
 					WithField("operation", "download_data").
 					WithField("table", fmt.Sprintf("%s.%s", tableMetadataAfterDownload[idx].Database, tableMetadataAfterDownload[idx].Table)).
 					WithField("duration", utils.HumanizeDuration(time.Since(start))).
 					WithField("size", utils.FormatBytes(tableMetadataAfterDownload[idx].TotalBytes)).
+					WithField("bytes", tableMetadataAfterDownload[idx].TotalBytes).
 					Info("done")
+				if b.restorePipeline != nil && b.restorePipeline.backupName == backupName {
+					return b.restorePipeline.restoreTableData(dataCtx, tableMetadataAfterDownload[idx])
+				}
 				return nil
 			})
 		}
@@ -257,7 +316,7 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 			return fmt.Errorf("one of Download go-routine return error: %v", err)
 		}
 	}
-	var rbacSize, configSize uint64
+	var rbacSize, configSize, keeperSize uint64
 	if !b.isEmbedded {
 		rbacSize, err = b.downloadRBACData(ctx, remoteBackup)
 		if err != nil {
@@ -268,6 +327,11 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		if err != nil {
 			return fmt.Errorf("download CONFIGS error: %v", err)
 		}
+
+		keeperSize, err = b.downloadKeeperData(ctx, remoteBackup)
+		if err != nil {
+			return fmt.Errorf("download KEEPER error: %v", err)
+		}
 	}
 
 	backupMetadata := remoteBackup.BackupMetadata
@@ -286,9 +350,18 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	backupMetadata.CompressedSize = 0
 	backupMetadata.DataFormat = ""
 	backupMetadata.RequiredBackup = ""
+	backupMetadata.IncrementalChain = nil
+	backupMetadata.Compression = nil
+	backupMetadata.Encryption = nil
 	backupMetadata.ConfigSize = configSize
 	backupMetadata.RBACSize = rbacSize
+	backupMetadata.KeeperSize = keeperSize
 
+	if !b.isEmbedded {
+		if err = b.applyDownloadMapping(backupName, &backupMetadata); err != nil {
+			return err
+		}
+	}
 	backupMetafileLocalPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata.json")
 	if b.isEmbedded {
 		backupMetafileLocalPath = path.Join(b.EmbeddedBackupDataPath, backupName, "metadata.json")
@@ -332,7 +405,6 @@ func (b *Backuper) downloadTableMetadata(ctx context.Context, backupName string,
 	metadataFiles := map[string]string{}
 	remoteMedataPrefix := path.Join(backupName, "metadata", common.TablePathEncode(tableTitle.Database), common.TablePathEncode(tableTitle.Table))
 	metadataFiles[fmt.Sprintf("%s.json", remoteMedataPrefix)] = path.Join(b.DefaultDataPath, "backup", backupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
-	partitionsIdMap := make(map[metadata.TableTitle]common.EmptyMap)
 	if b.isEmbedded {
 		metadataFiles[fmt.Sprintf("%s.sql", remoteMedataPrefix)] = path.Join(b.EmbeddedBackupDataPath, backupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.sql", common.TablePathEncode(tableTitle.Table)))
 		metadataFiles[fmt.Sprintf("%s.json", remoteMedataPrefix)] = path.Join(b.EmbeddedBackupDataPath, backupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
@@ -349,8 +421,9 @@ func (b *Backuper) downloadTableMetadata(ctx context.Context, backupName string,
 				if err = json.Unmarshal(tmBody, &tableMetadata); err != nil {
 					return nil, 0, err
 				}
-				partitionsIdMap, _ = partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, nil, []metadata.TableMetadata{tableMetadata}, partitions)
-				filterPartsAndFilesByPartitionsFilter(tableMetadata, partitionsIdMap[metadata.TableTitle{Database: tableMetadata.Database, Table: tableMetadata.Table}])
+				if _, err = b.filterPartsAndFilesByPartitions(ctx, tableMetadata, partitions); err != nil {
+					return nil, 0, err
+				}
 			}
 			if isProcessed {
 				size += uint64(processedSize)
@@ -395,8 +468,9 @@ func (b *Backuper) downloadTableMetadata(ctx context.Context, backupName string,
 			if err = json.Unmarshal(tmBody, &tableMetadata); err != nil {
 				return nil, 0, err
 			}
-			partitionsIdMap, _ = partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, nil, []metadata.TableMetadata{tableMetadata}, partitions)
-			filterPartsAndFilesByPartitionsFilter(tableMetadata, partitionsIdMap[metadata.TableTitle{Database: tableMetadata.Database, Table: tableMetadata.Table}])
+			if _, err = b.filterPartsAndFilesByPartitions(ctx, tableMetadata, partitions); err != nil {
+				return nil, 0, err
+			}
 			// save metadata
 			jsonSize := uint64(0)
 			jsonSize, err = tableMetadata.Save(localMetadataFile, schemaOnly)
@@ -425,12 +499,18 @@ func (b *Backuper) downloadConfigData(ctx context.Context, remoteBackup storage.
 	return b.downloadBackupRelatedDir(ctx, remoteBackup, "configs")
 }
 
+func (b *Backuper) downloadKeeperData(ctx context.Context, remoteBackup storage.Backup) (uint64, error) {
+	return b.downloadBackupRelatedDir(ctx, remoteBackup, "keeper")
+}
+
 func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup storage.Backup, prefix string) (uint64, error) {
 	log := b.log.WithField("logger", "downloadBackupRelatedDir")
 
 	localDir := path.Join(b.DefaultDataPath, "backup", remoteBackup.BackupName, prefix)
 
-	if remoteBackup.DataFormat != DirectoryFormat {
+	// with dedup_store related dirs are uploaded as is
+	isDirectory := remoteBackup.DataFormat == DirectoryFormat || remoteBackup.DataFormat == ChunksFormat
+	if !isDirectory {
 		prefix = fmt.Sprintf("%s.%s", prefix, b.cfg.GetArchiveExtension())
 	}
 	remoteSource := path.Join(remoteBackup.BackupName, prefix)
@@ -440,7 +520,7 @@ func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup st
 			return uint64(processedSize), nil
 		}
 	}
-	if remoteBackup.DataFormat == DirectoryFormat {
+	if isDirectory {
 		if err := b.dst.DownloadPath(ctx, 0, remoteSource, localDir, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration); err != nil {
 			//SFTP can't walk on non exists paths and return error
 			if !strings.Contains(err.Error(), "not exist") {
@@ -490,8 +570,10 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 	log := b.log.WithField("logger", "downloadTableData")
 	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 
-	s := semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
+	s := newTableSemaphore(b.cfg.General.DownloadTableConcurrency, b.cfg.General.DownloadConcurrency)
 	g, dataCtx := errgroup.WithContext(ctx)
+	// sentinels downloaded before parts when parts restore during download
+	sentinelsDownloaded := false
 
 	if remoteBackup.DataFormat != DirectoryFormat {
 		capacity := 0
@@ -508,21 +590,25 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 					continue
 				}
 				archiveFile := table.Files[disk][downloadOffset[disk]]
-				if err := s.Acquire(dataCtx, 1); err != nil {
+				if err := b.acquirePartSlot(dataCtx, s); err != nil {
 					log.Errorf("can't acquire semaphore %s archive: %v", archiveFile, err)
 					break breakByErrorArchive
 				}
 				tableLocalDir := b.getLocalBackupDataPathForTable(remoteBackup.BackupName, disk, dbAndTableDir)
 				downloadOffset[disk] += 1
-				tableRemoteFile := path.Join(remoteBackup.BackupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), archiveFile)
-				g.Go(func() error {
-					defer s.Release(1)
+				tableRemoteFile := path.Join(remoteTableDataPath(remoteBackup.BackupName, table), archiveFile)
+				partAttributes := []attribute.KeyValue{tableAttribute(table.Database, table.Table), attribute.String("disk", disk), attribute.String("part", archiveFile)}
+				goWithSpan(dataCtx, g, "download_part", partAttributes, func(dataCtx context.Context) error {
+					defer b.releasePartSlot(s)
 					log.Debugf("start download %s", tableRemoteFile)
 					if b.resume && b.resumableState.IsAlreadyProcessedBool(tableRemoteFile) {
 						return nil
 					}
 					retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
 					err := retry.RunCtx(dataCtx, func(dataCtx context.Context) error {
+						if remoteBackup.DataFormat == ChunksFormat {
+							return b.downloadPartChunks(dataCtx, tableRemoteFile, tableLocalDir)
+						}
 						return b.dst.DownloadCompressedStream(dataCtx, tableRemoteFile, tableLocalDir)
 					})
 					if err != nil {
@@ -542,10 +628,18 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 			capacity += len(table.Parts[disk])
 		}
 		log.Debugf("start %s.%s with concurrency=%d len(table.Parts[...])=%d", table.Database, table.Table, b.cfg.General.DownloadConcurrency, capacity)
+		streamParts := b.restorePipeline != nil && b.restorePipeline.restorePart != nil && b.restorePipeline.backupName == remoteBackup.BackupName && !b.isEmbedded && table.EngineData == nil
+		if streamParts {
+			// sentinels required to verify each part before attach
+			if err := b.downloadBackupSentinels(ctx, remoteBackup.BackupName, table); err != nil {
+				return err
+			}
+			sentinelsDownloaded = true
+		}
 
 	breakByErrorDirectory:
 		for disk, parts := range table.Parts {
-			tableRemotePath := path.Join(remoteBackup.BackupName, "shadow", dbAndTableDir, disk)
+			tableRemotePath := path.Join(remoteTableDataPath(remoteBackup.BackupName, table), disk)
 			diskPath := b.DiskToPathMap[disk]
 			tableLocalPath := path.Join(diskPath, "backup", remoteBackup.BackupName, "shadow", dbAndTableDir, disk)
 			if b.isEmbedded {
@@ -556,25 +650,34 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 					continue
 				}
 				partRemotePath := path.Join(tableRemotePath, part.Name)
-				if err := s.Acquire(dataCtx, 1); err != nil {
+				if err := b.acquirePartSlot(dataCtx, s); err != nil {
 					log.Errorf("can't acquire semaphore %s directory: %v", partRemotePath, err)
 					break breakByErrorDirectory
 				}
 				partLocalPath := path.Join(tableLocalPath, part.Name)
-				g.Go(func() error {
-					defer s.Release(1)
-					log.Debugf("start %s -> %s", partRemotePath, partLocalPath)
-					if b.resume && b.resumableState.IsAlreadyProcessedBool(partRemotePath) {
+				partDisk, streamPart := disk, part
+				partAttributes := []attribute.KeyValue{tableAttribute(table.Database, table.Table), attribute.String("disk", disk), attribute.String("part", part.Name)}
+				goWithSpan(dataCtx, g, "download_part", partAttributes, func(dataCtx context.Context) error {
+					err := func() error {
+						defer b.releasePartSlot(s)
+						log.Debugf("start %s -> %s", partRemotePath, partLocalPath)
+						if b.resume && b.resumableState.IsAlreadyProcessedBool(partRemotePath) {
+							return nil
+						}
+						if err := b.dst.DownloadPath(dataCtx, 0, partRemotePath, partLocalPath, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration); err != nil {
+							return err
+						}
+						if b.resume {
+							b.resumableState.AppendToState(partRemotePath, 0)
+						}
+						log.Debugf("finish %s -> %s", partRemotePath, partLocalPath)
 						return nil
-					}
-					if err := b.dst.DownloadPath(dataCtx, 0, partRemotePath, partLocalPath, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration); err != nil {
+					}()
+					// attach doesn't hold download slot, so download of next parts continues during attach
+					if err != nil || !streamParts {
 						return err
 					}
-					if b.resume {
-						b.resumableState.AppendToState(partRemotePath, 0)
-					}
-					log.Debugf("finish %s -> %s", partRemotePath, partLocalPath)
-					return nil
+					return b.restorePipeline.restorePart(dataCtx, table, partDisk, streamPart)
 				})
 			}
 		}
@@ -582,6 +685,11 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 	if err := g.Wait(); err != nil {
 		return fmt.Errorf("one of downloadTableData go-routine return error: %v", err)
 	}
+	if !b.isEmbedded && !sentinelsDownloaded {
+		if err := b.downloadBackupSentinels(ctx, remoteBackup.BackupName, table); err != nil {
+			return err
+		}
+	}
 
 	if !b.isEmbedded {
 		err := b.downloadDiffParts(ctx, remoteBackup, table, dbAndTableDir)
@@ -628,13 +736,14 @@ breakByError:
 				diskForDownload := disk
 				downloadDiffGroup.Go(func() error {
 					defer s.Release(1)
-					tableRemoteFiles, err := b.findDiffBackupFilesRemote(downloadDiffCtx, remoteBackup, table, diskForDownload, partForDownload, log)
+					tableRemoteFiles, segmentSize, err := b.findDiffBackupFilesRemote(downloadDiffCtx, remoteBackup, table, diskForDownload, partForDownload, log)
 					if err != nil {
 						return err
 					}
-
+					// part owner backup could be uploaded with other segment size
+					segmentCtx := storage.WithSegmentSize(downloadDiffCtx, segmentSize)
 					for tableRemoteFile, tableLocalDir := range tableRemoteFiles {
-						err = b.downloadDiffRemoteFile(downloadDiffCtx, diffRemoteFilesLock, diffRemoteFilesCache, tableRemoteFile, tableLocalDir)
+						err = b.downloadDiffRemoteFile(segmentCtx, diffRemoteFilesLock, diffRemoteFilesCache, tableRemoteFile, tableLocalDir)
 						if err != nil {
 							return err
 						}
@@ -698,6 +807,9 @@ func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLo
 		if path.Ext(tableRemoteFile) != "" {
 			retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
 			err := retry.RunCtx(ctx, func(ctx context.Context) error {
+				if strings.HasSuffix(tableRemoteFile, chunksManifestSuffix) {
+					return b.downloadPartChunks(ctx, tableRemoteFile, tableLocalDir)
+				}
 				return b.dst.DownloadCompressedStream(ctx, tableRemoteFile, tableLocalDir)
 			})
 			if err != nil {
@@ -731,30 +843,38 @@ func (b *Backuper) checkNewPath(newPath string, part metadata.Part) error {
 	return nil
 }
 
-func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadata.BackupMetadata, table metadata.TableMetadata, disk string, part metadata.Part, log *apexLog.Entry) (map[string]string, error) {
+// findDiffBackupFilesRemote - remote files and local directories for required part and segment size of backup which contains these files
+func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadata.BackupMetadata, table metadata.TableMetadata, disk string, part metadata.Part, log *apexLog.Entry) (map[string]string, int64, error) {
 	var requiredTable *metadata.TableMetadata
 	log.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffBackupFilesRemote"}).Debugf("start")
-	requiredBackup, err := b.ReadBackupMetadataRemote(ctx, backup.RequiredBackup)
+	requiredBackupName := backup.RequiredBackup
+	// part owner resolved during upload with --diff-from-remote, allow to skip intermediate backups in chain
+	if part.RequiredBackup != "" {
+		requiredBackupName = part.RequiredBackup
+	}
+	requiredBackup, err := b.ReadBackupMetadataRemote(ctx, requiredBackupName)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	requiredTable, err = b.downloadTableMetadataIfNotExists(ctx, requiredBackup.BackupName, log, metadata.TableTitle{Database: table.Database, Table: table.Table})
 	if err != nil {
 		log.Warnf("downloadTableMetadataIfNotExists %s / %s.%s return error", requiredBackup.BackupName, table.Database, table.Table)
-		return nil, err
+		return nil, 0, err
 	}
+	// required backup could be uploaded with other general->table_storage_rules path_prefix
+	table.RemotePathPrefix = requiredTable.RemotePathPrefix
 
 	// recursive find if part in RequiredBackup also Required
-	tableRemoteFiles, found, err := b.findDiffRecursive(ctx, requiredBackup, log, table, requiredTable, part, disk)
+	tableRemoteFiles, segmentSize, found, err := b.findDiffRecursive(ctx, requiredBackup, log, table, requiredTable, part, disk)
 	if found {
-		return tableRemoteFiles, nil
+		return tableRemoteFiles, segmentSize, nil
 	}
 
 	found = false
 	// try to find part on the same disk
 	tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, disk, disk, part)
 	if found {
-		return tableRemoteFiles, nil
+		return tableRemoteFiles, requiredBackup.SegmentSize, nil
 	}
 
 	// try to find part on other disks
@@ -762,7 +882,7 @@ func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadat
 		if requiredDisk != disk {
 			tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, disk, requiredDisk, part)
 			if found {
-				return tableRemoteFiles, nil
+				return tableRemoteFiles, requiredBackup.SegmentSize, nil
 			}
 		}
 	}
@@ -773,20 +893,20 @@ func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadat
 			if part.Name == requiredPart.Name {
 				localTableDir := path.Join(b.DiskToPathMap[disk], "backup", requiredBackup.BackupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), disk)
 				for _, remoteFile := range requiredTable.Files[requiredDisk] {
-					remoteFile = path.Join(requiredBackup.BackupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), remoteFile)
+					remoteFile = path.Join(remoteTableDataPath(requiredBackup.BackupName, *requiredTable), remoteFile)
 					tableRemoteFiles[remoteFile] = localTableDir
 				}
 			}
 		}
 	}
 	if len(tableRemoteFiles) > 0 {
-		return tableRemoteFiles, nil
+		return tableRemoteFiles, requiredBackup.SegmentSize, nil
 	}
 	// not found
-	return nil, fmt.Errorf("%s.%s %s not found on %s and all required backups sequence", table.Database, table.Table, part.Name, requiredBackup.BackupName)
+	return nil, 0, fmt.Errorf("%s.%s %s not found on %s and all required backups sequence", table.Database, table.Table, part.Name, requiredBackup.BackupName)
 }
 
-func (b *Backuper) findDiffRecursive(ctx context.Context, requiredBackup *metadata.BackupMetadata, log *apexLog.Entry, table metadata.TableMetadata, requiredTable *metadata.TableMetadata, part metadata.Part, disk string) (map[string]string, bool, error) {
+func (b *Backuper) findDiffRecursive(ctx context.Context, requiredBackup *metadata.BackupMetadata, log *apexLog.Entry, table metadata.TableMetadata, requiredTable *metadata.TableMetadata, part metadata.Part, disk string) (map[string]string, int64, bool, error) {
 	log.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffRecursive"}).Debugf("start")
 	found := false
 	for _, requiredParts := range requiredTable.Parts {
@@ -794,12 +914,12 @@ func (b *Backuper) findDiffRecursive(ctx context.Context, requiredBackup *metada
 			if requiredPart.Name == part.Name {
 				found = true
 				if requiredPart.Required {
-					tableRemoteFiles, err := b.findDiffBackupFilesRemote(ctx, *requiredBackup, table, disk, part, log)
+					tableRemoteFiles, segmentSize, err := b.findDiffBackupFilesRemote(ctx, *requiredBackup, table, disk, requiredPart, log)
 					if err != nil {
 						found = false
 						log.Warnf("try find %s.%s %s recursive return err: %v", table.Database, table.Table, part.Name, err)
 					}
-					return tableRemoteFiles, found, err
+					return tableRemoteFiles, segmentSize, found, err
 				}
 				break
 			}
@@ -808,13 +928,20 @@ func (b *Backuper) findDiffRecursive(ctx context.Context, requiredBackup *metada
 			break
 		}
 	}
-	return nil, false, nil
+	return nil, 0, false, nil
 }
 
 func (b *Backuper) findDiffOnePart(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (map[string]string, error, bool) {
-	log := apexLog.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffOnePart"})
+	log := b.log.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffOnePart"})
 	log.Debugf("start")
 	tableRemoteFiles := make(map[string]string)
+	if requiredBackup.DataFormat == ChunksFormat {
+		if tableRemoteFile, tableLocalDir, err := b.findDiffOnePartChunks(ctx, requiredBackup, table, localDisk, remoteDisk, part); err == nil {
+			tableRemoteFiles[tableRemoteFile] = tableLocalDir
+			return tableRemoteFiles, nil, true
+		}
+		return nil, nil, false
+	}
 	// find same disk and part name archive
 	if requiredBackup.DataFormat != DirectoryFormat {
 		if tableRemoteFile, tableLocalDir, err := b.findDiffOnePartArchive(ctx, requiredBackup, table, localDisk, remoteDisk, part); err == nil {
@@ -832,24 +959,32 @@ func (b *Backuper) findDiffOnePart(ctx context.Context, requiredBackup *metadata
 }
 
 func (b *Backuper) findDiffOnePartDirectory(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (string, string, error) {
-	log := apexLog.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffOnePartDirectory"})
+	log := b.log.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffOnePartDirectory"})
 	log.Debugf("start")
 	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
-	tableRemotePath := path.Join(requiredBackup.BackupName, "shadow", dbAndTableDir, remoteDisk, part.Name)
+	tableRemotePath := path.Join(remoteTableDataPath(requiredBackup.BackupName, table), remoteDisk, part.Name)
 	tableRemoteFile := path.Join(tableRemotePath, "checksums.txt")
 	return b.findDiffFileExist(ctx, requiredBackup, tableRemoteFile, tableRemotePath, localDisk, dbAndTableDir, part)
 }
 
 func (b *Backuper) findDiffOnePartArchive(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (string, string, error) {
-	log := apexLog.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffOnePartArchive"})
+	log := b.log.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffOnePartArchive"})
 	log.Debugf("start")
 	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 	remoteExt := config.ArchiveExtensions[requiredBackup.DataFormat]
-	tableRemotePath := path.Join(requiredBackup.BackupName, "shadow", dbAndTableDir, fmt.Sprintf("%s_%s.%s", remoteDisk, common.TablePathEncode(part.Name), remoteExt))
+	tableRemotePath := path.Join(remoteTableDataPath(requiredBackup.BackupName, table), fmt.Sprintf("%s_%s.%s", remoteDisk, common.TablePathEncode(part.Name), remoteExt))
 	tableRemoteFile := tableRemotePath
 	return b.findDiffFileExist(ctx, requiredBackup, tableRemoteFile, tableRemotePath, localDisk, dbAndTableDir, part)
 }
 
+func (b *Backuper) findDiffOnePartChunks(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (string, string, error) {
+	log := b.log.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffOnePartChunks"})
+	log.Debugf("start")
+	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	tableRemoteFile := path.Join(remoteTableDataPath(requiredBackup.BackupName, table), fmt.Sprintf("%s_%s%s", remoteDisk, common.TablePathEncode(part.Name), chunksManifestSuffix))
+	return b.findDiffFileExist(ctx, requiredBackup, tableRemoteFile, tableRemoteFile, localDisk, dbAndTableDir, part)
+}
+
 func (b *Backuper) findDiffFileExist(ctx context.Context, requiredBackup *metadata.BackupMetadata, tableRemoteFile string, tableRemotePath string, localDisk string, dbAndTableDir string, part metadata.Part) (string, string, error) {
 	_, err := b.dst.StatFile(ctx, tableRemoteFile)
 	log := b.log.WithField("logger", "findDiffFileExist")
@@ -884,7 +1019,7 @@ func (b *Backuper) ReadBackupMetadataRemote(ctx context.Context, backupName stri
 }
 
 func (b *Backuper) makePartHardlinks(exists, new string) error {
-	log := apexLog.WithField("logger", "makePartHardlinks")
+	log := b.log.WithField("logger", "makePartHardlinks")
 	ex, err := os.Open(exists)
 	if err != nil {
 		return err
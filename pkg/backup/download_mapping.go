@@ -0,0 +1,146 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+// WithDownloadMapping - `download --restore-database-mapping` and `--restore-table-mapping`, downloaded local backup contains databases and tables with target names,
+// so next `restore` creates them without mapping, general->restore_database_mapping and restore_table_mapping are not applied to download,
+// because `restore_remote` applies them during restore, rules validated by ValidateDownloadMapping
+func WithDownloadMapping(databaseMapping, tableMapping []string) BackuperOpt {
+	return func(b *Backuper) {
+		b.downloadDatabaseMapping, _ = parseDownloadMapping(databaseMapping, "restore-database-mapping")
+		b.downloadTableMapping, _ = parseDownloadMapping(tableMapping, "restore-table-mapping")
+	}
+}
+
+// ValidateDownloadMapping - each rule shall be `source:target`, several rules could be separated by comma
+func ValidateDownloadMapping(databaseMapping, tableMapping []string) error {
+	if _, err := parseDownloadMapping(databaseMapping, "restore-database-mapping"); err != nil {
+		return err
+	}
+	_, err := parseDownloadMapping(tableMapping, "restore-table-mapping")
+	return err
+}
+
+func parseDownloadMapping(rules []string, flag string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, rules := range rules {
+		for _, rule := range strings.Split(rules, ",") {
+			rule = strings.TrimSpace(rule)
+			if rule == "" {
+				continue
+			}
+			source, target, found := strings.Cut(rule, ":")
+			if !found || source == "" || target == "" || strings.Contains(target, ":") {
+				return nil, fmt.Errorf("invalid --%s rule `%s`, expected `source:target`", flag, rule)
+			}
+			result[source] = target
+		}
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// applyDownloadMapping - rename table metadata files and data folders of downloaded backup and rewrite CREATE queries the same way as `restore` does,
+// tables which already mapped by previous resumed download are skipped
+func (b *Backuper) applyDownloadMapping(backupName string, backupMetadata *metadata.BackupMetadata) error {
+	if len(b.downloadDatabaseMapping) == 0 && len(b.downloadTableMapping) == 0 {
+		return nil
+	}
+	backupPath := path.Join(b.DefaultDataPath, "backup", backupName)
+	tables := make(ListOfTables, 0, len(backupMetadata.Tables))
+	tablesIndex := make([]int, 0, len(backupMetadata.Tables))
+	for i, title := range backupMetadata.Tables {
+		tableMetadata := metadata.TableMetadata{}
+		if _, err := tableMetadata.Load(tableMetadataPath(backupPath, title.Database, title.Table)); err != nil {
+			mappedTitle := b.getDownloadMappedTitle(title)
+			if _, mappedErr := os.Stat(tableMetadataPath(backupPath, mappedTitle.Database, mappedTitle.Table)); os.IsNotExist(err) && mappedErr == nil {
+				backupMetadata.Tables[i] = mappedTitle
+				continue
+			}
+			return fmt.Errorf("can't apply mapping to %s.%s: %v", title.Database, title.Table, err)
+		}
+		tables = append(tables, tableMetadata)
+		tablesIndex = append(tablesIndex, i)
+	}
+	mappedTables := make(ListOfTables, len(tables))
+	copy(mappedTables, tables)
+	if len(b.downloadDatabaseMapping) > 0 {
+		if err := changeTableQueryToAdjustDatabaseMapping(&mappedTables, b.downloadDatabaseMapping); err != nil {
+			return err
+		}
+	}
+	if len(b.downloadTableMapping) > 0 {
+		if err := changeTableQueryToAdjustTableMapping(&mappedTables, b.downloadTableMapping); err != nil {
+			return err
+		}
+	}
+	mappedTitles := make(map[metadata.TableTitle]struct{}, len(backupMetadata.Tables))
+	for _, title := range backupMetadata.Tables {
+		title = b.getDownloadMappedTitle(title)
+		if _, exists := mappedTitles[title]; exists {
+			return fmt.Errorf("mapping of %s places several tables into %s.%s", backupName, title.Database, title.Table)
+		}
+		mappedTitles[title] = struct{}{}
+	}
+	for i, table := range tables {
+		mappedTable := mappedTables[i]
+		if table.Database == mappedTable.Database && table.Table == mappedTable.Table {
+			continue
+		}
+		for disk := range table.Parts {
+			diskPath, exists := b.DiskToPathMap[disk]
+			if !exists {
+				continue
+			}
+			shadowPath := path.Join(diskPath, "backup", backupName, "shadow")
+			srcPath := path.Join(shadowPath, common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+			dstPath := path.Join(shadowPath, common.TablePathEncode(mappedTable.Database), common.TablePathEncode(mappedTable.Table))
+			if err := os.MkdirAll(path.Dir(dstPath), 0750); err != nil {
+				return err
+			}
+			if err := os.Rename(srcPath, dstPath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("can't move %s to %s: %v", srcPath, dstPath, err)
+			}
+		}
+		if _, err := mappedTable.Save(tableMetadataPath(backupPath, mappedTable.Database, mappedTable.Table), mappedTable.MetadataOnly); err != nil {
+			return err
+		}
+		if err := os.Remove(tableMetadataPath(backupPath, table.Database, table.Table)); err != nil {
+			return err
+		}
+		backupMetadata.Tables[tablesIndex[i]] = metadata.TableTitle{Database: mappedTable.Database, Table: mappedTable.Table}
+		b.log.Infof("%s.%s downloaded as %s.%s", table.Database, table.Table, mappedTable.Database, mappedTable.Table)
+	}
+	for i, database := range backupMetadata.Databases {
+		if targetDB, isMapped := b.downloadDatabaseMapping[database.Name]; isMapped {
+			backupMetadata.Databases[i].Query = CreateDatabaseRE.ReplaceAllString(database.Query, fmt.Sprintf("CREATE DATABASE ${1}`%s`${3}", targetDB))
+			backupMetadata.Databases[i].Name = targetDB
+		}
+	}
+	return nil
+}
+
+// getDownloadMappedTitle - the same names as changeTableQueryToAdjustDatabaseMapping and changeTableQueryToAdjustTableMapping set
+func (b *Backuper) getDownloadMappedTitle(title metadata.TableTitle) metadata.TableTitle {
+	if targetDB, isMapped := b.downloadDatabaseMapping[title.Database]; isMapped {
+		title.Database = targetDB
+	}
+	if targetTable, isMapped := b.downloadTableMapping[title.Table]; isMapped {
+		title.Table = targetTable
+	}
+	return title
+}
+
+func tableMetadataPath(backupPath, database, table string) string {
+	return path.Join(backupPath, "metadata", common.TablePathEncode(database), fmt.Sprintf("%s.json", common.TablePathEncode(table)))
+}
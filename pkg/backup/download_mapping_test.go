@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyDownloadMapping(t *testing.T) {
+	assert.ErrorContains(t, ValidateDownloadMapping([]string{"db"}, nil), "invalid --restore-database-mapping rule")
+	assert.ErrorContains(t, ValidateDownloadMapping(nil, []string{"t1:t2:t3"}), "invalid --restore-table-mapping rule")
+	require.NoError(t, ValidateDownloadMapping([]string{"db:db2"}, []string{"t1:t2, t3:t4"}))
+
+	dataPath := t.TempDir()
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.WithField("logger", "test"), DefaultDataPath: dataPath, DiskToPathMap: map[string]string{"default": dataPath}}
+	WithDownloadMapping([]string{"db:db2"}, []string{"t1:t2"})(b)
+	backupPath := path.Join(dataPath, "backup", "backup1")
+	table := metadata.TableMetadata{
+		Database: "db",
+		Table:    "t1",
+		Query:    "CREATE TABLE db.t1 (id UInt64) ENGINE = MergeTree ORDER BY id",
+		Parts:    map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}},
+	}
+	_, err := table.Save(tableMetadataPath(backupPath, "db", "t1"), false)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(path.Join(backupPath, "shadow", "db", "t1", "default", "all_1_1_0"), 0750))
+	backupMetadata := metadata.BackupMetadata{
+		BackupName: "backup1",
+		Tables:     []metadata.TableTitle{{Database: "db", Table: "t1"}},
+		Databases:  []metadata.DatabasesMeta{{Name: "db", Engine: "Atomic", Query: "CREATE DATABASE db ENGINE = Atomic"}},
+	}
+
+	require.NoError(t, b.applyDownloadMapping("backup1", &backupMetadata))
+	assert.Equal(t, []metadata.TableTitle{{Database: "db2", Table: "t2"}}, backupMetadata.Tables)
+	assert.Equal(t, "db2", backupMetadata.Databases[0].Name)
+	assert.Contains(t, backupMetadata.Databases[0].Query, "`db2`")
+	assert.NoFileExists(t, tableMetadataPath(backupPath, "db", "t1"))
+	assert.DirExists(t, path.Join(backupPath, "shadow", "db2", "t2", "default", "all_1_1_0"))
+	mappedTable := metadata.TableMetadata{}
+	_, err = mappedTable.Load(tableMetadataPath(backupPath, "db2", "t2"))
+	require.NoError(t, err)
+	assert.Equal(t, "db2", mappedTable.Database)
+	assert.Equal(t, "t2", mappedTable.Table)
+	assert.Contains(t, mappedTable.Query, "t2")
+	assert.NotContains(t, mappedTable.Query, "db.t1")
+
+	// resumed download of already mapped backup
+	backupMetadata.Tables = []metadata.TableTitle{{Database: "db", Table: "t1"}}
+	require.NoError(t, b.applyDownloadMapping("backup1", &backupMetadata))
+	assert.Equal(t, []metadata.TableTitle{{Database: "db2", Table: "t2"}}, backupMetadata.Tables)
+
+	backupMetadata.Tables = []metadata.TableTitle{{Database: "db", Table: "t1"}, {Database: "db2", Table: "t2"}}
+	assert.ErrorContains(t, b.applyDownloadMapping("backup1", &backupMetadata), "places several tables into db2.t2")
+}
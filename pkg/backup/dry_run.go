@@ -0,0 +1,293 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// WithDryRun - `create`, `upload`, `delete` and `clean` only log what will be frozen, uploaded or deleted, without any changes
+func WithDryRun(dryRun bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.dryRun = dryRun
+	}
+}
+
+// backupSize - the same size which shows in `list`
+func backupSize(backup metadata.BackupMetadata) string {
+	if backup.CompressedSize > 0 {
+		return utils.FormatBytes(backup.CompressedSize + backup.MetadataSize)
+	}
+	return utils.FormatBytes(backup.DataSize + backup.MetadataSize)
+}
+
+// createBackupDryRun - log tables and each active part from system.parts which will be frozen, and local backups which backups_to_keep_local will delete after create
+func (b *Backuper) createBackupDryRun(ctx context.Context, backupName string, tables []clickhouse.Table, partitionsIdMap map[metadata.TableTitle]common.EmptyMap, doBackupData bool, disks []clickhouse.Disk, log *apexLog.Entry) error {
+	parts := make([]struct {
+		Database    string `ch:"database"`
+		Table       string `ch:"table"`
+		PartitionID string `ch:"partition_id"`
+		Name        string `ch:"name"`
+		Bytes       uint64 `ch:"bytes_on_disk"`
+	}, 0)
+	if doBackupData {
+		if err := b.ch.SelectContext(ctx, &parts, "SELECT database, table, partition_id, name, bytes_on_disk FROM system.parts WHERE active ORDER BY database, table, name"); err != nil {
+			return fmt.Errorf("can't get parts from system.parts: %v", err)
+		}
+	}
+	var totalTables, totalParts, totalBytes uint64
+	for _, table := range tables {
+		if table.Skip {
+			continue
+		}
+		tableName := fmt.Sprintf("%s.%s", table.Database, table.Name)
+		partitionIds := partitionsIdMap[metadata.TableTitle{Database: table.Database, Table: table.Name}]
+		var tableParts, tableBytes uint64
+		for _, p := range parts {
+			if p.Database != table.Database || p.Table != table.Name {
+				continue
+			}
+			if len(partitionIds) > 0 && !filesystemhelper.IsPartitionIdMatched(p.PartitionID, partitionIds) {
+				continue
+			}
+			log.WithFields(apexLog.Fields{
+				"table": tableName,
+				"part":  p.Name,
+				"size":  utils.FormatBytes(p.Bytes),
+			}).Info("dry-run, will freeze part")
+			tableParts++
+			tableBytes += p.Bytes
+		}
+		totalTables++
+		totalParts += tableParts
+		totalBytes += tableBytes
+		action := "dry-run, will freeze"
+		if !doBackupData {
+			action = "dry-run, will backup schema"
+		}
+		log.WithFields(apexLog.Fields{
+			"table":  tableName,
+			"engine": table.Engine,
+			"parts":  tableParts,
+			"size":   utils.FormatBytes(tableBytes),
+		}).Info(action)
+	}
+	log.WithFields(apexLog.Fields{
+		"tables": totalTables,
+		"parts":  totalParts,
+		"size":   utils.FormatBytes(totalBytes),
+	}).Info("dry-run, done")
+	return b.removeOldBackupsLocalDryRun(ctx, backupName, disks, log)
+}
+
+// removeOldBackupsLocalDryRun - log local backups which RemoveOldBackupsLocal will delete after the new backup created
+func (b *Backuper) removeOldBackupsLocalDryRun(ctx context.Context, backupName string, disks []clickhouse.Disk, log *apexLog.Entry) error {
+	keep, isEnabled := b.getBackupsToKeepLocal(true)
+	if !isEnabled {
+		return nil
+	}
+	backupList, _, err := b.GetLocalBackups(ctx, disks)
+	if err != nil {
+		return err
+	}
+	backupList = append(backupList, LocalBackup{BackupMetadata: metadata.BackupMetadata{BackupName: backupName, CreationDate: time.Now().UTC()}})
+	for _, backup := range GetBackupsToDelete(backupList, keep) {
+		if backup.BackupName == backupName {
+			continue
+		}
+		log.WithFields(apexLog.Fields{
+			"location": "local",
+			"backup":   backup.BackupName,
+			"size":     backupSize(backup.BackupMetadata),
+		}).Info("dry-run, will delete")
+	}
+	return nil
+}
+
+// uploadDryRun - log parts of each table which will be uploaded, parts which already exist in --diff-from or --diff-from-remote backup are skipped,
+// and remote backups which retention policy will delete after the new backup uploaded
+func (b *Backuper) uploadDryRun(ctx context.Context, backupMetadata *metadata.BackupMetadata, tablesForUpload ListOfTables, tablesForUploadFromDiff map[metadata.TableTitle]metadata.TableMetadata, schemaOnly, checkLocalPart bool, log *apexLog.Entry) error {
+	var totalParts, totalBytes uint64
+	for i := range tablesForUpload {
+		table := &tablesForUpload[i]
+		if diffTable, diffExists := tablesForUploadFromDiff[metadata.TableTitle{Database: table.Database, Table: table.Table}]; diffExists && !schemaOnly {
+			b.markDuplicatedParts(backupMetadata, &diffTable, table, checkLocalPart)
+		}
+		var tableParts, requiredParts, tableBytes uint64
+		if !schemaOnly {
+			for disk := range table.Parts {
+				for _, part := range table.Parts[disk] {
+					if part.Required {
+						requiredParts++
+						continue
+					}
+					log.WithFields(apexLog.Fields{
+						"table": fmt.Sprintf("%s.%s", table.Database, table.Table),
+						"disk":  disk,
+						"part":  part.Name,
+						"size":  utils.FormatBytes(uint64(part.Size)),
+					}).Info("dry-run, will upload part")
+					tableParts++
+					tableBytes += uint64(part.Size)
+				}
+			}
+			// old backups don't contain size of each part
+			if tableBytes == 0 && requiredParts == 0 {
+				for _, size := range table.Size {
+					tableBytes += uint64(size)
+				}
+			}
+		}
+		totalParts += tableParts
+		totalBytes += tableBytes
+		log.WithFields(apexLog.Fields{
+			"table":          fmt.Sprintf("%s.%s", table.Database, table.Table),
+			"parts":          tableParts,
+			"required_parts": requiredParts,
+			"size":           utils.FormatBytes(tableBytes),
+		}).Info("dry-run, will upload")
+	}
+	if backupMetadata.RBACSize > 0 {
+		log.WithField("size", utils.FormatBytes(backupMetadata.RBACSize)).Info("dry-run, will upload RBAC")
+	}
+	if backupMetadata.ConfigSize > 0 {
+		log.WithField("size", utils.FormatBytes(backupMetadata.ConfigSize)).Info("dry-run, will upload configs")
+	}
+	log.WithFields(apexLog.Fields{
+		"tables": len(tablesForUpload),
+		"parts":  totalParts,
+		"size":   utils.FormatBytes(totalBytes),
+	}).Info("dry-run, done")
+	return b.removeOldBackupsRemoteDryRun(ctx, backupMetadata, log)
+}
+
+// removeOldBackupsRemoteDryRun - log remote backups which retention policy will delete, the new backup is counted as already uploaded
+func (b *Backuper) removeOldBackupsRemoteDryRun(ctx context.Context, backupMetadata *metadata.BackupMetadata, log *apexLog.Entry) error {
+	retention := storage.NewBackupRetention(b.cfg)
+	if !retention.IsEnabled() {
+		return nil
+	}
+	backupList, err := b.dst.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	clusterManifests, err := b.dst.GetClusterManifests(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	backupList = append(backupList, storage.Backup{BackupMetadata: *backupMetadata, UploadDate: now})
+	for _, backup := range storage.GetBackupsToDeleteByRetentionWithGroups(backupList, storage.GetClusterBackupGroups(clusterManifests), retention, now) {
+		if backup.BackupName == backupMetadata.BackupName {
+			continue
+		}
+		log.WithFields(apexLog.Fields{
+			"location": "remote",
+			"backup":   backup.BackupName,
+			"size":     backupSize(backup.BackupMetadata),
+		}).Info("dry-run, will delete")
+	}
+	return nil
+}
+
+// deleteDryRun - log backup which will be deleted, and backups which depend on it in incremental chain
+func (b *Backuper) deleteDryRun(ctx context.Context, backupType, backupName string) error {
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "delete",
+		"location":  backupType,
+	})
+	var backups []metadata.BackupMetadata
+	switch backupType {
+	case "local":
+		if err := b.ch.Connect(); err != nil {
+			return fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		defer b.ch.Close()
+		localBackups, _, err := b.GetLocalBackups(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, backup := range localBackups {
+			backups = append(backups, backup.BackupMetadata)
+		}
+	case "remote":
+		remoteBackups, err := b.GetRemoteBackups(ctx, true)
+		if err != nil {
+			return err
+		}
+		for _, backup := range remoteBackups {
+			backups = append(backups, backup.BackupMetadata)
+		}
+	default:
+		return fmt.Errorf("unknown backup type")
+	}
+	found := false
+	for _, backup := range backups {
+		if backup.BackupName == backupName {
+			found = true
+			log.WithFields(apexLog.Fields{
+				"tables":        len(backup.Tables),
+				"size":          backupSize(backup),
+				"creation_date": backup.CreationDate,
+			}).Info("dry-run, will delete")
+		} else if backup.RequiredBackup == backupName {
+			log.Warnf("dry-run, %s requires %s as base of incremental backup and will be broken", backup.BackupName, backupName)
+		}
+	}
+	if !found {
+		return fmt.Errorf("'%s' is not found on %s storage", backupName, backupType)
+	}
+	return nil
+}
+
+// cleanDryRun - log content of shadow folders which will be removed
+func (b *Backuper) cleanDryRun(disks []clickhouse.Disk, log *apexLog.Entry) error {
+	var totalBytes uint64
+	for _, disk := range disks {
+		if disk.IsBackup {
+			continue
+		}
+		shadowDir := path.Join(disk.Path, "shadow")
+		items, err := os.ReadDir(shadowDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, item := range items {
+			itemPath := path.Join(shadowDir, item.Name())
+			var itemBytes uint64
+			if err = filepath.Walk(itemPath, func(_ string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() {
+					itemBytes += uint64(info.Size())
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			totalBytes += itemBytes
+			log.WithFields(apexLog.Fields{
+				"path": itemPath,
+				"size": utils.FormatBytes(itemBytes),
+			}).Info("dry-run, will delete")
+		}
+	}
+	log.WithField("size", utils.FormatBytes(totalBytes)).Info("dry-run, done")
+	return nil
+}
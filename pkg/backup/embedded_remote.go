@@ -0,0 +1,252 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/partition"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// embeddedRemoteTag - backup created by BACKUP TO S3(...) or AzureBlobStorage(...) with clickhouse->embedded_backup_target: remote,
+// contains "embedded" to keep all embedded related checks
+const embeddedRemoteTag = "embedded_remote"
+
+// errClassicRemoteBackup - backup in remote storage was created with FREEZE, due clickhouse->classic_backup_tables, it shall be downloaded and restored as usual
+var errClassicRemoteBackup = errors.New("backup was not created with BACKUP SQL command")
+
+func (b *Backuper) isEmbeddedRemoteTarget() bool {
+	return b.cfg.ClickHouse.UseEmbeddedBackupRestore && b.cfg.ClickHouse.EmbeddedBackupTarget == "remote"
+}
+
+// embeddedRemoteDestination - `BACKUP ... TO` and `RESTORE ... FROM` clause with arguments, which point clickhouse-server to `<path>/<backup_name>/` in general->remote_storage
+func (b *Backuper) embeddedRemoteDestination(ctx context.Context, backupName string) (string, []interface{}, error) {
+	remotePath := func(p string) (string, error) {
+		p, err := b.ch.ApplyMacros(ctx, p)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimPrefix(path.Join(p, backupName), "/") + "/", nil
+	}
+	switch b.cfg.General.RemoteStorage {
+	case "s3":
+		key, err := remotePath(b.cfg.S3.Path)
+		if err != nil {
+			return "", nil, err
+		}
+		scheme := "https"
+		if b.cfg.S3.DisableSSL {
+			scheme = "http"
+		}
+		var endpoint string
+		if b.cfg.S3.Endpoint == "" {
+			endpoint = fmt.Sprintf("%s://%s.s3.%s.amazonaws.com/%s", scheme, b.cfg.S3.Bucket, b.cfg.S3.Region, key)
+		} else {
+			u, err := url.Parse(b.cfg.S3.Endpoint)
+			if err != nil || u.Host == "" {
+				if u, err = url.Parse(scheme + "://" + b.cfg.S3.Endpoint); err != nil {
+					return "", nil, fmt.Errorf("can't parse s3->endpoint %s: %v", b.cfg.S3.Endpoint, err)
+				}
+			}
+			if b.cfg.S3.UsePathStyle(b.cfg.S3.Bucket, b.cfg.S3.ForcePathStyle) {
+				u.Path = path.Join(u.Path, b.cfg.S3.Bucket, key) + "/"
+			} else {
+				u.Host = b.cfg.S3.Bucket + "." + u.Host
+				u.Path = path.Join(u.Path, key) + "/"
+			}
+			endpoint = u.String()
+		}
+		if b.cfg.S3.AccessKey == "" {
+			// credentials from clickhouse-server config, or IAM role of clickhouse-server host
+			return "S3(?)", []interface{}{endpoint}, nil
+		}
+		return "S3(?, ?, ?)", []interface{}{endpoint, b.cfg.S3.AccessKey, b.cfg.S3.SecretKey}, nil
+	case "gcs":
+		key, err := remotePath(b.cfg.GCS.Path)
+		if err != nil {
+			return "", nil, err
+		}
+		endpoint := "https://storage.googleapis.com"
+		if b.cfg.GCS.Endpoint != "" {
+			endpoint = strings.TrimSuffix(b.cfg.GCS.Endpoint, "/")
+		}
+		endpoint = fmt.Sprintf("%s/%s/%s", endpoint, b.cfg.GCS.Bucket, key)
+		if b.cfg.GCS.EmbeddedAccessKey == "" {
+			return "S3(?)", []interface{}{endpoint}, nil
+		}
+		return "S3(?, ?, ?)", []interface{}{endpoint, b.cfg.GCS.EmbeddedAccessKey, b.cfg.GCS.EmbeddedSecretKey}, nil
+	case "cos":
+		key, err := remotePath(b.cfg.COS.Path)
+		if err != nil {
+			return "", nil, err
+		}
+		endpoint := fmt.Sprintf("%s/%s", strings.TrimSuffix(b.cfg.COS.RowURL, "/"), key)
+		return "S3(?, ?, ?)", []interface{}{endpoint, b.cfg.COS.SecretID, b.cfg.COS.SecretKey}, nil
+	case "azblob":
+		key, err := remotePath(b.cfg.AzureBlob.Path)
+		if err != nil {
+			return "", nil, err
+		}
+		var connection string
+		switch {
+		case b.cfg.AzureBlob.AccountKey != "":
+			connection = fmt.Sprintf("DefaultEndpointsProtocol=%s;AccountName=%s;AccountKey=%s;EndpointSuffix=%s", b.cfg.AzureBlob.EndpointSchema, b.cfg.AzureBlob.AccountName, b.cfg.AzureBlob.AccountKey, b.cfg.AzureBlob.EndpointSuffix)
+		case b.cfg.AzureBlob.SharedAccessSignature != "":
+			connection = fmt.Sprintf("BlobEndpoint=%s://%s.blob.%s;SharedAccessSignature=%s", b.cfg.AzureBlob.EndpointSchema, b.cfg.AzureBlob.AccountName, b.cfg.AzureBlob.EndpointSuffix, strings.TrimPrefix(b.cfg.AzureBlob.SharedAccessSignature, "?"))
+		default:
+			// storage account url without credentials, clickhouse-server will use managed identity
+			connection = fmt.Sprintf("%s://%s.blob.%s", b.cfg.AzureBlob.EndpointSchema, b.cfg.AzureBlob.AccountName, b.cfg.AzureBlob.EndpointSuffix)
+		}
+		return "AzureBlobStorage(?, ?, ?)", []interface{}{connection, b.cfg.AzureBlob.Container, key}, nil
+	}
+	return "", nil, fmt.Errorf("`embedded_backup_target: remote` doesn't support `remote_storage: %s`", b.cfg.General.RemoteStorage)
+}
+
+// uploadEmbeddedRemoteMetadata - BACKUP TO S3(...) or AzureBlobStorage(...) writes only own format, table metadata and metadata.json are prepared in temporary directory and uploaded to make backup visible in `list remote`,
+// remote retention policy is applied after upload
+func (b *Backuper) uploadEmbeddedRemoteMetadata(ctx context.Context, backupName string, tables []metadata.TableMetadata, disks []clickhouse.Disk, createBackupMetadata func(backupMetaFile string, backupMetadataSize uint64) error) error {
+	tmpDir, err := os.MkdirTemp("", "clickhouse-backup-"+backupName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			b.log.Warnf("can't remove %s: %v", tmpDir, err)
+		}
+	}()
+	backupMetadataSize := uint64(0)
+	for _, table := range tables {
+		metadataSize, err := b.createTableMetadata(path.Join(tmpDir, "metadata"), table, disks)
+		if err != nil {
+			return err
+		}
+		backupMetadataSize += metadataSize
+	}
+	if err = createBackupMetadata(path.Join(tmpDir, "metadata.json"), backupMetadataSize); err != nil {
+		return err
+	}
+	if err = b.init(ctx, disks, backupName); err != nil {
+		return err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	// metadata.json shall be uploaded last, backup without it will show as broken
+	files := make([]string, 0)
+	if err = filepath.Walk(path.Join(tmpDir, "metadata"), func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		files = append(files, filePath)
+		return nil
+	}); err != nil {
+		return err
+	}
+	files = append(files, path.Join(tmpDir, "metadata.json"))
+	for _, filePath := range files {
+		relativePath, err := filepath.Rel(tmpDir, filePath)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		err = b.dst.PutFile(ctx, path.Join(backupName, filepath.ToSlash(relativePath)), f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("can't upload %s: %v", relativePath, err)
+		}
+	}
+	// backup is already in remote storage, `upload` which applies remote retention will not run
+	deletedBackups, err := b.dst.RemoveOldBackups(ctx, storage.NewBackupRetention(b.cfg), false)
+	if err != nil {
+		return fmt.Errorf("can't remove old backups on remote storage: %v", err)
+	}
+	b.notifyRetentionDelete("remote", getBackupNames(deletedBackups))
+	return nil
+}
+
+// restoreFromRemoteEmbedded - RESTORE ... FROM S3(...) or AzureBlobStorage(...) without download, for backups created with clickhouse->embedded_backup_target: remote
+func (b *Backuper) restoreFromRemoteEmbedded(backupName, tablePattern string, partitions []string, schemaOnly, dataOnly, dropTable, ignoreDependencies, hasUnsupportedArgs bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	startRestore := time.Now()
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "restore_remote",
+	})
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if err = b.init(ctx, nil, backupName); err != nil {
+		return err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	backupMetadata, err := b.ReadBackupMetadataRemote(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(backupMetadata.Tags, "embedded") {
+		return errClassicRemoteBackup
+	}
+	if !strings.Contains(backupMetadata.Tags, embeddedRemoteTag) {
+		return fmt.Errorf("%s was not created with `embedded_backup_target: remote`, use `embedded_backup_target: disk` to restore it", backupName)
+	}
+	if hasUnsupportedArgs {
+		return fmt.Errorf("`embedded_backup_target: remote` doesn't support --restore-database-mapping, --restore-table-mapping, --partitions-where, --to-timestamp, --rbac and --configs parameters")
+	}
+	tablesForRestore, err := getTableListByPatternRemote(ctx, b, backupMetadata, tablePattern, dropTable)
+	if err != nil {
+		return err
+	}
+	if len(tablesForRestore) == 0 {
+		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
+	}
+	b.isEmbedded, b.isEmbeddedRemote = true, true
+	version, err := b.ch.GetVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if !dataOnly {
+		if dropTable {
+			if err = b.dropExistsTables(tablesForRestore, ignoreDependencies, version, log); err != nil {
+				return err
+			}
+		}
+		if err = b.restoreEmbedded(ctx, backupName, true, tablesForRestore, nil); err != nil {
+			return err
+		}
+	}
+	if !schemaOnly {
+		_, partitionsNameList := partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, nil, tablesForRestore, partitions)
+		if err = b.restoreEmbedded(ctx, backupName, false, tablesForRestore, partitionsNameList); err != nil {
+			return err
+		}
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(startRestore))).Info("done")
+	return nil
+}
@@ -0,0 +1,195 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	apexLog "github.com/apex/log"
+)
+
+// WithConvertEngines - `--convert-engines`, comma separated `From=To` rules which change table engine in DDL during schema restore,
+// allow restore backup from replicated cluster to single node and vice versa, rules validated by ValidateConvertEngines
+func WithConvertEngines(rules []string) BackuperOpt {
+	return func(b *Backuper) {
+		b.convertEngines, _ = parseEngineConversionRules(rules)
+	}
+}
+
+func ValidateConvertEngines(rules []string) error {
+	_, err := parseEngineConversionRules(rules)
+	return err
+}
+
+// engineConversionRule - `from` is engine name pattern where `*` matches any part of engine name, `*` in `to` replaced by matched part,
+// `Replicated*MergeTree=*MergeTree` keeps engine family, `Replicated*MergeTree=MergeTree` converts all replicated engines to plain MergeTree and drops engine parameters
+type engineConversionRule struct {
+	from *regexp.Regexp
+	to   string
+}
+
+var engineConversionPatternRE = regexp.MustCompile(`^[A-Za-z]*\*?[A-Za-z]*$`)
+
+func parseEngineConversionRules(rules []string) ([]engineConversionRule, error) {
+	var result []engineConversionRule
+	for _, rules := range rules {
+		for _, rule := range strings.Split(rules, ",") {
+			rule = strings.TrimSpace(rule)
+			if rule == "" {
+				continue
+			}
+			from, to, found := strings.Cut(rule, "=")
+			from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+			if !found || from == "" || to == "" || from == "*" || !engineConversionPatternRE.MatchString(from) || !engineConversionPatternRE.MatchString(to) {
+				return nil, fmt.Errorf("invalid --convert-engines rule `%s`, expected `From=To` engine names with optional `*`, for example `Replicated*MergeTree=*MergeTree`", rule)
+			}
+			if strings.Contains(to, "*") && !strings.Contains(from, "*") {
+				return nil, fmt.Errorf("invalid --convert-engines rule `%s`, `*` in target engine requires `*` in source engine", rule)
+			}
+			result = append(result, engineConversionRule{
+				from: regexp.MustCompile("^" + strings.Replace(regexp.QuoteMeta(from), `\*`, `([A-Za-z]*)`, 1) + "$"),
+				to:   to,
+			})
+		}
+	}
+	return result, nil
+}
+
+// tableEngineRE - first `ENGINE = Name` in DDL, for materialized views it is engine of inner table
+var tableEngineRE = regexp.MustCompile(`\bENGINE\s*=\s*(\w+)`)
+
+// convertTableEngine - apply first matched rule to table engine, Replicated engines lose two leading ZooKeeper path and replica name parameters,
+// converted to Replicated engines get `replicaPath` and `replicaName` as leading parameters
+func convertTableEngine(query string, rules []engineConversionRule, replicaPath, replicaName string) (string, bool) {
+	match := tableEngineRE.FindStringSubmatchIndex(query)
+	if match == nil {
+		return query, false
+	}
+	engine := query[match[2]:match[3]]
+	engineEnd := match[1]
+	args := make([]string, 0)
+	if argsStart := engineEnd + len(query[engineEnd:]) - len(strings.TrimLeft(query[engineEnd:], " \t\r\n")); argsStart < len(query) && query[argsStart] == '(' {
+		argsEnd := findClosingParenthesis(query, argsStart)
+		if argsEnd < 0 {
+			return query, false
+		}
+		args = splitEngineArgs(query[argsStart+1 : argsEnd])
+		engineEnd = argsEnd + 1
+	}
+	for _, rule := range rules {
+		wildcard := rule.from.FindStringSubmatch(engine)
+		if wildcard == nil {
+			continue
+		}
+		newEngine := rule.to
+		if len(wildcard) > 1 {
+			newEngine = strings.Replace(rule.to, "*", wildcard[1], 1)
+		}
+		// `*MergeTree=Replicated*MergeTree` shall not touch already replicated tables
+		if strings.HasPrefix(engine, "Replicated") && strings.HasPrefix(newEngine, "Replicated") {
+			continue
+		}
+		if strings.HasPrefix(engine, "Replicated") && len(args) >= 2 && isQuotedString(args[0]) && isQuotedString(args[1]) {
+			args = args[2:]
+		}
+		if !strings.Contains(rule.to, "*") {
+			args = args[:0]
+		}
+		if strings.HasPrefix(newEngine, "Replicated") {
+			args = append([]string{quoteEngineArg(replicaPath), quoteEngineArg(replicaName)}, args...)
+		}
+		converted := fmt.Sprintf("ENGINE = %s(%s)", newEngine, strings.Join(args, ", "))
+		return query[:match[0]] + converted + query[engineEnd:], true
+	}
+	return query, false
+}
+
+// findClosingParenthesis - position of parenthesis which close parenthesis at `start`, parentheses inside quotes ignored, -1 when not found
+func findClosingParenthesis(s string, start int) int {
+	depth := 0
+	inQuote := false
+	for i := start; i < len(s); i++ {
+		switch c := s[i]; {
+		case inQuote && c == '\\':
+			i++
+		case c == '\'':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitEngineArgs - split by top level commas, commas inside quotes and parentheses ignored
+func splitEngineArgs(args string) []string {
+	result := make([]string, 0)
+	depth := 0
+	inQuote := false
+	start := 0
+	for i := 0; i < len(args); i++ {
+		switch c := args[i]; {
+		case inQuote && c == '\\':
+			i++
+		case c == '\'':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			result = append(result, strings.TrimSpace(args[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(args[start:]); last != "" || len(result) > 0 {
+		result = append(result, last)
+	}
+	return result
+}
+
+func isQuotedString(arg string) bool {
+	return len(arg) >= 2 && strings.HasPrefix(arg, "'") && strings.HasSuffix(arg, "'")
+}
+
+func quoteEngineArg(arg string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(arg) + "'"
+}
+
+// applyConvertEngines - change engine in DDL for tables which match `--convert-engines`, converted to Replicated tables use
+// `default_replica_path` and `default_replica_name` from clickhouse-server config, the same as `ReplicatedMergeTree()` without parameters
+func (b *Backuper) applyConvertEngines(ctx context.Context, tables ListOfTables, log *apexLog.Entry) {
+	if len(b.convertEngines) == 0 {
+		return
+	}
+	replicaPath, replicaName := "/clickhouse/tables/{uuid}/{shard}", "{replica}"
+	for _, rule := range b.convertEngines {
+		if strings.HasPrefix(rule.to, "Replicated") {
+			settings, err := b.ch.GetPreprocessedXMLSettings(ctx, map[string]string{"default_replica_path": "//default_replica_path", "default_replica_name": "//default_replica_name"}, "config.xml")
+			if err != nil {
+				log.Warnf("can't read default_replica_path and default_replica_name from config.xml, will use %s and %s: %v", replicaPath, replicaName, err)
+			}
+			if settings["default_replica_path"] != "" {
+				replicaPath = settings["default_replica_path"]
+			}
+			if settings["default_replica_name"] != "" {
+				replicaName = settings["default_replica_name"]
+			}
+			break
+		}
+	}
+	for i := range tables {
+		if query, converted := convertTableEngine(tables[i].Query, b.convertEngines, replicaPath, replicaName); converted {
+			log.Infof("--convert-engines change `%s`.`%s` engine: %s", tables[i].Database, tables[i].Table, tableEngineRE.FindString(query))
+			tables[i].Query = query
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertTableEngine(t *testing.T) {
+	testCases := []struct {
+		rules     []string
+		query     string
+		expected  string
+		converted bool
+	}{
+		{
+			rules:     []string{"Replicated*MergeTree=*MergeTree"},
+			query:     "CREATE TABLE db.t (id UInt64, ver UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}', ver) ORDER BY id",
+			expected:  "CREATE TABLE db.t (id UInt64, ver UInt64) ENGINE = ReplacingMergeTree(ver) ORDER BY id",
+			converted: true,
+		},
+		{
+			rules:     []string{"Replicated*MergeTree=MergeTree"},
+			query:     "CREATE TABLE db.t (id UInt64, sign Int8) ENGINE = ReplicatedCollapsingMergeTree('/path', '{replica}', sign) ORDER BY id",
+			expected:  "CREATE TABLE db.t (id UInt64, sign Int8) ENGINE = MergeTree() ORDER BY id",
+			converted: true,
+		},
+		{
+			rules:     []string{"Replicated*MergeTree=*MergeTree"},
+			query:     "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id",
+			expected:  "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree() ORDER BY id",
+			converted: true,
+		},
+		{
+			rules:     []string{"*MergeTree=Replicated*MergeTree"},
+			query:     "CREATE TABLE db.t (id UInt64, s String) ENGINE = SummingMergeTree((id, toString(s))) ORDER BY id",
+			expected:  "CREATE TABLE db.t (id UInt64, s String) ENGINE = ReplicatedSummingMergeTree('/clickhouse/tables/{uuid}/{shard}', '{replica}', (id, toString(s))) ORDER BY id",
+			converted: true,
+		},
+		{
+			rules:     []string{"*MergeTree=Replicated*MergeTree"},
+			query:     "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/path', '{replica}') ORDER BY id",
+			expected:  "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/path', '{replica}') ORDER BY id",
+			converted: false,
+		},
+		{
+			rules:     []string{"Replicated*MergeTree=*MergeTree"},
+			query:     "CREATE MATERIALIZED VIEW db.mv ENGINE = ReplicatedAggregatingMergeTree('/path, with comma', '{replica}') ORDER BY id AS SELECT id FROM db.t",
+			expected:  "CREATE MATERIALIZED VIEW db.mv ENGINE = AggregatingMergeTree() ORDER BY id AS SELECT id FROM db.t",
+			converted: true,
+		},
+		{
+			rules:     []string{"Replicated*MergeTree=*MergeTree"},
+			query:     "CREATE TABLE db.d (id UInt64) ENGINE = Distributed('cluster', 'db', 't', rand())",
+			expected:  "CREATE TABLE db.d (id UInt64) ENGINE = Distributed('cluster', 'db', 't', rand())",
+			converted: false,
+		},
+	}
+	for _, tc := range testCases {
+		rules, err := parseEngineConversionRules(tc.rules)
+		require.NoError(t, err)
+		query, converted := convertTableEngine(tc.query, rules, "/clickhouse/tables/{uuid}/{shard}", "{replica}")
+		assert.Equal(t, tc.expected, query)
+		assert.Equal(t, tc.converted, converted)
+	}
+}
+
+func TestValidateConvertEngines(t *testing.T) {
+	assert.NoError(t, ValidateConvertEngines([]string{"Replicated*MergeTree=*MergeTree,ReplicatedMergeTree=MergeTree"}))
+	assert.Error(t, ValidateConvertEngines([]string{"ReplicatedMergeTree"}))
+	assert.Error(t, ValidateConvertEngines([]string{"MergeTree=Replicated*MergeTree"}))
+	assert.Error(t, ValidateConvertEngines([]string{"*=MergeTree"}))
+	assert.Error(t, ValidateConvertEngines([]string{"Merge(Tree=MergeTree"}))
+}
@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+	recursiveCopy "github.com/otiai10/copy"
+)
+
+// engineDataEngines - table engines which store data in table data path without parts and FREEZE support
+var engineDataEngines = map[string]struct{}{
+	"Log":             {},
+	"TinyLog":         {},
+	"StripeLog":       {},
+	"File":            {},
+	"EmbeddedRocksDB": {},
+}
+
+func isEngineDataTable(engine string) bool {
+	_, exists := engineDataEngines[engine]
+	return exists
+}
+
+// engineDataCopyAttempts - live table data path copied again when files changed during copy
+const engineDataCopyAttempts = 3
+
+// addEngineDataToBackup - copy table data path into one pseudo part for each disk, hard links are not safe here, Log family and File engines append into the same files after backup,
+// FREEZE is not supported for these engines, by default table stays available and copy repeats when inserts or EmbeddedRocksDB compaction changed files during copy,
+// with `clickhouse->engine_data_detach: true` table is detached and unavailable for queries until copy finished
+func (b *Backuper) addEngineDataToBackup(ctx context.Context, backupName, shadowBackupUUID string, diskList []clickhouse.Disk, table *clickhouse.Table, log *apexLog.Entry) (disksToPartsMap map[string][]metadata.Part, realSize map[string]int64, err error) {
+	partName := metadata.EngineDataPartPrefix + shadowBackupUUID
+	disksToPartsMap = map[string][]metadata.Part{}
+	realSize = map[string]int64{}
+	encodedTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Name))
+	dataPaths := map[string]string{}
+	copiedPaths := map[string]struct{}{}
+	for diskName, dataPath := range clickhouse.GetDisksByPaths(diskList, table.DataPaths) {
+		if _, isCopied := copiedPaths[dataPath]; isCopied {
+			continue
+		}
+		if info, err := os.Stat(dataPath); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, err
+		} else if !info.IsDir() {
+			continue
+		}
+		copiedPaths[dataPath] = struct{}{}
+		dataPaths[diskName] = dataPath
+	}
+	if len(dataPaths) == 0 {
+		log.WithField("engine", table.Engine).Warnf("data paths %v are outside of clickhouse disks, supports only schema backup", table.DataPaths)
+		return disksToPartsMap, realSize, nil
+	}
+	if b.cfg.ClickHouse.EngineDataDetach {
+		if err = b.ch.QueryContext(ctx, fmt.Sprintf("DETACH TABLE `%s`.`%s`", table.Database, table.Name)); err != nil {
+			return nil, nil, fmt.Errorf("can't detach %s table `%s`.`%s`: %v", table.Engine, table.Database, table.Name, err)
+		}
+		defer func() {
+			attachCtx, cancel := newCleanupContext(ctx)
+			defer cancel()
+			if attachErr := b.ch.QueryContext(attachCtx, fmt.Sprintf("ATTACH TABLE `%s`.`%s`", table.Database, table.Name)); attachErr != nil && err == nil {
+				err = fmt.Errorf("can't attach %s table `%s`.`%s`: %v", table.Engine, table.Database, table.Name, attachErr)
+			}
+		}()
+	}
+	for diskName, dataPath := range dataPaths {
+		if err = ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		var disk clickhouse.Disk
+		for _, d := range diskList {
+			if d.Name == diskName {
+				disk = d
+				break
+			}
+		}
+		backupPartPath := path.Join(disk.Path, "backup", backupName, "shadow", encodedTablePath, disk.Name, partName)
+		if err = filesystemhelper.MkdirAll(backupPartPath, b.ch, diskList); err != nil && !os.IsExist(err) {
+			return nil, nil, err
+		}
+		size, err := copyEngineData(dataPath, backupPartPath, engineDataCopyAttempts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s table `%s`.`%s`: %v", table.Engine, table.Database, table.Name, err)
+		}
+		if err = filesystemhelper.Chown(backupPartPath, b.ch, diskList, true); err != nil {
+			log.Warnf("can't chown %s: %v", backupPartPath, err)
+			err = nil
+		}
+		disksToPartsMap[disk.Name] = []metadata.Part{{Name: partName, Size: size}}
+		realSize[disk.Name] = size
+		log.WithField("disk", disk.Name).WithField("engine", table.Engine).Debugf("%s copied", dataPath)
+	}
+	return disksToPartsMap, realSize, nil
+}
+
+// copyEngineData - copy dataPath and compare size and modification time of each file before and after copy, changed files mean inconsistent copy which is repeated
+func copyEngineData(dataPath, backupPartPath string, attempts int) (int64, error) {
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err := clearDirectory(backupPartPath); err != nil {
+			return 0, err
+		}
+		before, err := getEngineDataFiles(dataPath)
+		if err != nil {
+			return 0, err
+		}
+		size := int64(0)
+		for _, file := range before {
+			size += file.size
+		}
+		if err = recursiveCopy.Copy(dataPath, backupPartPath); err != nil {
+			// EmbeddedRocksDB compaction removes SST files during copy
+			lastErr = fmt.Errorf("can't copy %s -> %s: %v", dataPath, backupPartPath, err)
+			continue
+		}
+		after, err := getEngineDataFiles(dataPath)
+		if err != nil {
+			return 0, err
+		}
+		if reflect.DeepEqual(before, after) {
+			return size, nil
+		}
+		lastErr = fmt.Errorf("%s changed during copy", dataPath)
+	}
+	return 0, fmt.Errorf("%v, after %d attempts, use `clickhouse->engine_data_detach: true` to detach table during copy", lastErr, attempts)
+}
+
+type engineDataFile struct {
+	size    int64
+	modTime time.Time
+}
+
+func getEngineDataFiles(dataPath string) (map[string]engineDataFile, error) {
+	files := map[string]engineDataFile{}
+	err := filepath.Walk(dataPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files[filePath] = engineDataFile{size: info.Size(), modTime: info.ModTime()}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// getEngineDataMetadata - nil for tables which data restored as parts
+func getEngineDataMetadata(table clickhouse.Table, disksToPartsMap map[string][]metadata.Part) *metadata.EngineDataMetadata {
+	if !isEngineDataTable(table.Engine) {
+		return nil
+	}
+	for _, parts := range disksToPartsMap {
+		if len(parts) > 0 {
+			return &metadata.EngineDataMetadata{Engine: table.Engine, Part: parts[0].Name}
+		}
+	}
+	return nil
+}
+
+// restoreEngineData - DETACH TABLE, replace content of table data path with files from pseudo part on the same disk and ATTACH TABLE back, engine reads copied files during ATTACH,
+// table is attached back on failure too
+func (b *Backuper) restoreEngineData(ctx context.Context, backupName string, table metadata.TableMetadata, disks []clickhouse.Disk, dstTable clickhouse.Table, dstTableMeta metadata.TableMetadata, log *apexLog.Entry) (err error) {
+	dstDataPaths := clickhouse.GetDisksByPaths(disks, dstTable.DataPaths)
+	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	if err = b.ch.QueryContext(ctx, fmt.Sprintf("DETACH TABLE `%s`.`%s`", dstTableMeta.Database, dstTableMeta.Table)); err != nil {
+		return fmt.Errorf("can't detach %s table `%s`.`%s`: %v", table.EngineData.Engine, dstTableMeta.Database, dstTableMeta.Table, err)
+	}
+	defer func() {
+		attachCtx, cancel := newCleanupContext(ctx)
+		defer cancel()
+		if attachErr := b.ch.QueryContext(attachCtx, fmt.Sprintf("ATTACH TABLE `%s`.`%s`", dstTableMeta.Database, dstTableMeta.Table)); attachErr != nil && err == nil {
+			err = fmt.Errorf("can't attach %s table `%s`.`%s`: %v", table.EngineData.Engine, dstTableMeta.Database, dstTableMeta.Table, attachErr)
+		}
+	}()
+	for _, disk := range disks {
+		for _, part := range table.Parts[disk.Name] {
+			dstDataPath, exists := dstDataPaths[disk.Name]
+			if !exists {
+				return fmt.Errorf("dstDataPaths=%#v, not contains %s", dstDataPaths, disk.Name)
+			}
+			// files which are absent in backup, like newer RocksDB SST files or Log marks, shall not mix with restored ones
+			if err = clearDirectory(dstDataPath); err != nil {
+				return err
+			}
+			partPath := path.Join(disk.Path, "backup", backupName, "shadow", dbAndTableDir, disk.Name, part.Name)
+			if err = recursiveCopy.Copy(partPath, dstDataPath); err != nil {
+				return fmt.Errorf("can't copy %s -> %s: %v", partPath, dstDataPath, err)
+			}
+			if err = filesystemhelper.Chown(dstDataPath, b.ch, disks, true); err != nil {
+				return err
+			}
+			log.WithField("disk", disk.Name).WithField("engine", table.EngineData.Engine).Debugf("%s copied", dstDataPath)
+		}
+	}
+	return nil
+}
+
+// clearDirectory - remove directory content, directory itself stay with the same owner and permissions
+func clearDirectory(dirPath string) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err = os.RemoveAll(path.Join(dirPath, entry.Name())); err != nil {
+			return fmt.Errorf("can't remove %s: %v", path.Join(dirPath, entry.Name()), err)
+		}
+	}
+	return nil
+}
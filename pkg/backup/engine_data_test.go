@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineDataPartitionsFilter(t *testing.T) {
+	engineDataPart := metadata.EngineDataPartPrefix + "00000000-0000-0000-0000-000000000001"
+	tableMetadata := metadata.TableMetadata{
+		Database:   "db",
+		Table:      "log_table",
+		Parts:      map[string][]metadata.Part{"default": {{Name: engineDataPart}}},
+		Files:      map[string][]string{"default": {"default_" + engineDataPart + ".tar"}},
+		EngineData: &metadata.EngineDataMetadata{Engine: "Log", Part: engineDataPart},
+	}
+	filterPartsAndFilesByPartitionIds(tableMetadata, common.EmptyMap{"202401": {}})
+	assert.Equal(t, []metadata.Part{{Name: engineDataPart}}, tableMetadata.Parts["default"], "--partitions shall keep engine_data pseudo part")
+	assert.Equal(t, []string{"default_" + engineDataPart + ".tar"}, tableMetadata.Files["default"])
+	assert.Empty(t, getPartitionIdsFromParts(tableMetadata))
+
+	tableMetadata.EngineData = nil
+	filterPartsAndFilesByPartitionIds(tableMetadata, common.EmptyMap{"202401": {}})
+	assert.Empty(t, tableMetadata.Parts["default"])
+}
+
+func TestClearDirectory(t *testing.T) {
+	dataPath := path.Join(t.TempDir(), "data")
+	require.NoError(t, os.MkdirAll(path.Join(dataPath, "rocksdb"), 0750))
+	require.NoError(t, os.WriteFile(path.Join(dataPath, "rocksdb", "000010.sst"), []byte("sst"), 0640))
+	require.NoError(t, os.WriteFile(path.Join(dataPath, "sizes.json"), []byte("{}"), 0640))
+	require.NoError(t, clearDirectory(dataPath))
+	entries, err := os.ReadDir(dataPath)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	require.NoError(t, clearDirectory(path.Join(dataPath, "not_exists")))
+}
+
+func TestCopyEngineData(t *testing.T) {
+	dataPath := path.Join(t.TempDir(), "data")
+	backupPartPath := path.Join(t.TempDir(), "engine_data_1")
+	require.NoError(t, os.MkdirAll(path.Join(dataPath, "rocksdb"), 0750))
+	require.NoError(t, os.WriteFile(path.Join(dataPath, "rocksdb", "000010.sst"), []byte("sst"), 0640))
+	require.NoError(t, os.WriteFile(path.Join(dataPath, "sizes.json"), []byte("{}"), 0640))
+	require.NoError(t, os.MkdirAll(backupPartPath, 0750))
+	require.NoError(t, os.WriteFile(path.Join(backupPartPath, "stale.bin"), []byte("stale"), 0640))
+
+	size, err := copyEngineData(dataPath, backupPartPath, engineDataCopyAttempts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+	body, err := os.ReadFile(path.Join(backupPartPath, "rocksdb", "000010.sst"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("sst"), body)
+	assert.NoFileExists(t, path.Join(backupPartPath, "stale.bin"), "files of failed attempt shall not mix with copy")
+
+	before, err := getEngineDataFiles(dataPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path.Join(dataPath, "sizes.json"), []byte(`{"files":{}}`), 0640))
+	after, err := getEngineDataFiles(dataPath)
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after, "insert during copy shall be detected")
+
+	_, err = copyEngineData(path.Join(dataPath, "not_exists"), backupPartPath, 2)
+	assert.Error(t, err)
+}
@@ -0,0 +1,397 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/partition"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+)
+
+// activePart - row from system.parts, used by `estimate` and by free space check before `create`
+type activePart struct {
+	Database          string `ch:"database"`
+	Table             string `ch:"table"`
+	Name              string `ch:"name"`
+	PartitionID       string `ch:"partition_id"`
+	Disk              string `ch:"disk_name"`
+	Path              string `ch:"path"`
+	BytesOnDisk       uint64 `ch:"bytes_on_disk"`
+	UncompressedBytes uint64 `ch:"data_uncompressed_bytes"`
+}
+
+// shadowPartOverhead - FREEZE makes hardlinks, so each part requires only own directory in shadow and item in table metadata
+const shadowPartOverhead = 4096
+
+// tableMetadataOverhead - table metadata json contains create query and list of parts, approximate size without them
+const tableMetadataOverhead = 4096
+
+// estimateSampleSize - how many bytes of files from the biggest part of each table are compressed to measure compression ratio
+const estimateSampleSize = 4 * 1024 * 1024
+
+// getActiveParts - active parts of tables which will be backed up, filtered by --partitions
+func (b *Backuper) getActiveParts(ctx context.Context, tables []clickhouse.Table, partitionsIdMap map[metadata.TableTitle]common.EmptyMap) (map[metadata.TableTitle][]activePart, error) {
+	result := make(map[metadata.TableTitle][]activePart, len(tables))
+	tableNames := make([]string, 0, len(tables))
+	for _, table := range tables {
+		if table.Skip {
+			continue
+		}
+		result[metadata.TableTitle{Database: table.Database, Table: table.Name}] = make([]activePart, 0)
+		tableNames = append(tableNames, "'"+escapeSQLString(table.Database+"."+table.Name)+"'")
+	}
+	if len(tableNames) == 0 {
+		return result, nil
+	}
+	parts := make([]activePart, 0)
+	partsSQL := fmt.Sprintf("SELECT database, table, name, partition_id, disk_name, path, bytes_on_disk, data_uncompressed_bytes FROM system.parts WHERE active AND concat(database,'.',table) IN (%s)", strings.Join(tableNames, ","))
+	if err := b.ch.SelectContext(ctx, &parts, partsSQL); err != nil {
+		return nil, fmt.Errorf("can't get parts from system.parts: %v", err)
+	}
+	for _, part := range parts {
+		tableTitle := metadata.TableTitle{Database: part.Database, Table: part.Table}
+		tableParts, exists := result[tableTitle]
+		if !exists {
+			continue
+		}
+		if partitionIds := partitionsIdMap[tableTitle]; len(partitionIds) > 0 && !filesystemhelper.IsPartitionIdMatched(part.PartitionID, partitionIds) {
+			continue
+		}
+		result[tableTitle] = append(tableParts, part)
+	}
+	return result, nil
+}
+
+func escapeSQLString(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(s)
+}
+
+// getCompressionRatio - compress sample of files from the biggest part on local disk with compression_format and compression_level the same way as upload does,
+// column files are already compressed by ClickHouse codecs, so ratio is usually close to 1, 1 when part files are not readable on clickhouse-backup host
+func (b *Backuper) getCompressionRatio(parts []activePart, diskTypes map[string]string, compressionFormat string, compressionLevel int) float64 {
+	if compressionFormat == "none" || compressionFormat == "tar" {
+		return 1
+	}
+	var biggestPart *activePart
+	for i := range parts {
+		if diskTypes[parts[i].Disk] == "s3" || diskTypes[parts[i].Disk] == "azure_blob_storage" {
+			continue
+		}
+		if biggestPart == nil || parts[i].BytesOnDisk > biggestPart.BytesOnDisk {
+			biggestPart = &parts[i]
+		}
+	}
+	if biggestPart == nil || biggestPart.Path == "" {
+		return 1
+	}
+	files := make([]string, 0)
+	if err := filepath.Walk(biggestPart.Path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, filePath)
+		}
+		return nil
+	}); err != nil || len(files) == 0 {
+		b.log.Debugf("can't read files of %s for compression ratio: %v", biggestPart.Path, err)
+		return 1
+	}
+	// the same share from each file, to avoid sample which contains only small text files
+	sample := make([]byte, 0, estimateSampleSize)
+	fileSampleSize := int64(estimateSampleSize / len(files))
+	if fileSampleSize < 4096 {
+		fileSampleSize = 4096
+	}
+	for _, filePath := range files {
+		if len(sample) >= estimateSampleSize {
+			break
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			b.log.Debugf("can't open %s for compression ratio: %v", filePath, err)
+			return 1
+		}
+		data, err := io.ReadAll(io.LimitReader(f, fileSampleSize))
+		_ = f.Close()
+		if err != nil {
+			b.log.Debugf("can't read %s for compression ratio: %v", filePath, err)
+			return 1
+		}
+		sample = append(sample, data...)
+	}
+	if len(sample) == 0 {
+		return 1
+	}
+	compressedSize, err := storage.CompressedSize(compressionFormat, compressionLevel, bytes.NewReader(sample))
+	if err != nil {
+		b.log.Debugf("can't compress sample of %s: %v", biggestPart.Path, err)
+		return 1
+	}
+	return float64(compressedSize) / float64(len(sample))
+}
+
+// getCreateRequiredBytes - FREEZE makes hardlinks, so parts require only directories in shadow on the same disk and table metadata on `default` disk,
+// BACKUP TO Disk(...) writes copy of parts to clickhouse->embedded_backup_disk, parts on object disks contain only metadata locally and are skipped,
+// upload doesn't require additional space, it streams archives directly from backup files to remote storage
+func (b *Backuper) getCreateRequiredBytes(tables []clickhouse.Table, tableParts map[metadata.TableTitle][]activePart, diskTypes map[string]string) map[string]uint64 {
+	metadataDisk := "default"
+	if b.cfg.ClickHouse.UseEmbeddedBackupRestore {
+		metadataDisk = b.cfg.ClickHouse.EmbeddedBackupDisk
+	}
+	requiredByDisk := make(map[string]uint64)
+	for _, table := range tables {
+		if table.Skip {
+			continue
+		}
+		requiredByDisk[metadataDisk] += uint64(len(table.CreateTableQuery)) + tableMetadataOverhead
+		for _, part := range tableParts[metadata.TableTitle{Database: table.Database, Table: table.Name}] {
+			if diskTypes[part.Disk] == "s3" || diskTypes[part.Disk] == "azure_blob_storage" {
+				continue
+			}
+			if b.cfg.ClickHouse.UseEmbeddedBackupRestore {
+				requiredByDisk[b.cfg.ClickHouse.EmbeddedBackupDisk] += part.BytesOnDisk
+			} else {
+				requiredByDisk[part.Disk] += shadowPartOverhead
+			}
+		}
+	}
+	return requiredByDisk
+}
+
+// Estimate - print uncompressed, on disk and expected compressed size of each table which matched with tablePattern, size of parts which not present in diffFromRemote backup, and free space on each disk
+func (b *Backuper) Estimate(tablePattern string, partitions []string, diffFromRemote string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	log := b.log.WithField("logger", "Estimate")
+	tablePattern = b.tablePatternOrDefault(tablePattern)
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	tables, err := b.GetTables(ctx, tablePattern)
+	if err != nil {
+		return fmt.Errorf("can't get tables from clickhouse: %v", err)
+	}
+	partitionsIdMap, _ := partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, tables, nil, partitions)
+	tableParts, err := b.getActiveParts(ctx, tables, partitionsIdMap)
+	if err != nil {
+		return err
+	}
+	var remoteParts map[metadata.TableTitle]common.EmptyMap
+	if diffFromRemote != "" {
+		if remoteParts, err = b.getRemotePartNames(ctx, diffFromRemote, tablePattern); err != nil {
+			return err
+		}
+	}
+	freeSpace, err := b.ch.GetDisksFreeSpace(ctx)
+	if err != nil {
+		return fmt.Errorf("can't get free space from system.disks: %v", err)
+	}
+	disks, err := b.ch.GetDisks(ctx, false)
+	if err != nil {
+		return err
+	}
+	diskTypes := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		diskTypes[disk.Name] = disk.Type
+	}
+	compressionFormat := b.cfg.GetCompressionFormat()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	defer func() {
+		if err := w.Flush(); err != nil {
+			log.Errorf("can't flush tabular writer error: %v", err)
+		}
+	}()
+	deltaHeader := ""
+	if diffFromRemote != "" {
+		deltaHeader = "delta from " + diffFromRemote
+	}
+	printCostRow(w, "table", "parts", "uncompressed", "on disk", fmt.Sprintf("compressed with %s", compressionFormat), deltaHeader)
+	var totalParts, totalUncompressed, totalOnDisk, totalCompressed, totalDelta uint64
+	for _, table := range tables {
+		tableTitle := metadata.TableTitle{Database: table.Database, Table: table.Name}
+		parts, exists := tableParts[tableTitle]
+		if !exists {
+			continue
+		}
+		var uncompressed, onDisk, delta uint64
+		for _, part := range parts {
+			uncompressed += part.UncompressedBytes
+			onDisk += part.BytesOnDisk
+			if _, isRemotePart := remoteParts[tableTitle][part.Name]; !isRemotePart {
+				delta += part.BytesOnDisk
+			}
+		}
+		compressionRatio := b.getCompressionRatio(parts, diskTypes, compressionFormat, b.getTableCompressionLevel(table.TotalBytes))
+		compressed := uint64(float64(onDisk) * compressionRatio)
+		delta = uint64(float64(delta) * compressionRatio)
+		totalParts += uint64(len(parts))
+		totalUncompressed += uncompressed
+		totalOnDisk += onDisk
+		totalCompressed += compressed
+		totalDelta += delta
+		deltaColumn := ""
+		if diffFromRemote != "" {
+			deltaColumn = utils.FormatBytes(delta)
+		}
+		printCostRow(w, fmt.Sprintf("%s.%s", table.Database, table.Name), fmt.Sprint(len(parts)), utils.FormatBytes(uncompressed), utils.FormatBytes(onDisk), utils.FormatBytes(compressed), deltaColumn)
+	}
+	totalDeltaColumn := ""
+	if diffFromRemote != "" {
+		totalDeltaColumn = utils.FormatBytes(totalDelta)
+	}
+	printCostRow(w, "total", fmt.Sprint(totalParts), utils.FormatBytes(totalUncompressed), utils.FormatBytes(totalOnDisk), utils.FormatBytes(totalCompressed), totalDeltaColumn)
+	printCostRow(w, "", "", "", "", "", "")
+	printCostRow(w, "disk", "free", "required for create", fmt.Sprintf("required with %v%% margin", b.cfg.General.FreeSpaceMarginPercent), "", "")
+	requiredByDisk := b.getCreateRequiredBytes(tables, tableParts, diskTypes)
+	for _, disk := range sortedKeys(requiredByDisk) {
+		required := requiredByDisk[disk]
+		printCostRow(w, disk, utils.FormatBytes(freeSpace[disk]), utils.FormatBytes(required), utils.FormatBytes(b.withFreeSpaceMargin(required)), "", "")
+	}
+	return nil
+}
+
+// getRemotePartNames - names of parts for each table in remote backup, used to calculate incremental delta
+func (b *Backuper) getRemotePartNames(ctx context.Context, backupName, tablePattern string) (map[metadata.TableTitle]common.EmptyMap, error) {
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return nil, fmt.Errorf("--diff-from-remote doesn't support remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if err := b.init(ctx, nil, ""); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	backupMetadata, err := b.ReadBackupMetadataRemote(ctx, backupName)
+	if err != nil {
+		return nil, err
+	}
+	remoteTables, err := getTableListByPatternRemote(ctx, b, backupMetadata, tablePattern, false)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[metadata.TableTitle]common.EmptyMap, len(remoteTables))
+	for _, table := range remoteTables {
+		partNames := common.EmptyMap{}
+		for _, parts := range table.Parts {
+			for _, part := range parts {
+				partNames[part.Name] = struct{}{}
+			}
+		}
+		result[metadata.TableTitle{Database: table.Database, Table: table.Table}] = partNames
+	}
+	return result, nil
+}
+
+// checkCreateFreeSpace - compare space required by getCreateRequiredBytes with free space on each disk
+func (b *Backuper) checkCreateFreeSpace(ctx context.Context, tables []clickhouse.Table, partitionsIdMap map[metadata.TableTitle]common.EmptyMap, diskTypes map[string]string) error {
+	if b.cfg.General.CheckFreeSpace == "none" || b.isEmbeddedRemoteTarget() {
+		return nil
+	}
+	tableParts, err := b.getActiveParts(ctx, tables, partitionsIdMap)
+	if err != nil {
+		return err
+	}
+	return b.checkFreeSpace(ctx, "create", b.getCreateRequiredBytes(tables, tableParts, diskTypes))
+}
+
+// checkDownloadFreeSpace - size of parts on each disk from table metadata, disks which not present in system.disks are downloaded to `default` disk,
+// object disks contain only metadata locally and are skipped
+func (b *Backuper) checkDownloadFreeSpace(ctx context.Context, tables []metadata.TableMetadata, disks []clickhouse.Disk) error {
+	if b.cfg.General.CheckFreeSpace == "none" {
+		return nil
+	}
+	diskTypes := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		diskTypes[disk.Name] = disk.Type
+	}
+	requiredByDisk := make(map[string]uint64)
+	for _, table := range tables {
+		if table.MetadataOnly {
+			continue
+		}
+		for disk, size := range table.Size {
+			// downloadTableMetadata keeps only parts which match --partitions, old backups don't contain size of each part
+			partsSize := int64(0)
+			for _, part := range table.Parts[disk] {
+				partsSize += part.Size
+			}
+			if partsSize > 0 {
+				size = partsSize
+			}
+			diskType, exists := diskTypes[disk]
+			if !exists {
+				disk = "default"
+			} else if diskType == "s3" || diskType == "azure_blob_storage" {
+				continue
+			}
+			requiredByDisk[disk] += uint64(size)
+		}
+	}
+	return b.checkFreeSpace(ctx, "download", requiredByDisk)
+}
+
+func (b *Backuper) withFreeSpaceMargin(required uint64) uint64 {
+	return uint64(float64(required) * (1 + b.cfg.General.FreeSpaceMarginPercent/100))
+}
+
+// checkFreeSpace - compare required bytes on each disk plus general->free_space_margin_percent with free_space from system.disks,
+// general->check_free_space: warn only log warning, error refuse to start operation
+func (b *Backuper) checkFreeSpace(ctx context.Context, operation string, requiredByDisk map[string]uint64) error {
+	if b.cfg.General.CheckFreeSpace == "none" || len(requiredByDisk) == 0 {
+		return nil
+	}
+	freeSpace, err := b.ch.GetDisksFreeSpace(ctx)
+	if err != nil {
+		return fmt.Errorf("can't get free space from system.disks: %v", err)
+	}
+	problems := make([]string, 0)
+	for _, disk := range sortedKeys(requiredByDisk) {
+		free, exists := freeSpace[disk]
+		if !exists {
+			continue
+		}
+		if required := b.withFreeSpaceMargin(requiredByDisk[disk]); free < required {
+			problems = append(problems, fmt.Sprintf("disk `%s` has %s free, but `%s` requires %s including %v%% margin", disk, utils.FormatBytes(free), operation, utils.FormatBytes(required), b.cfg.General.FreeSpaceMarginPercent))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if b.cfg.General.CheckFreeSpace == "error" {
+		return fmt.Errorf("not enough free space: %s, change `check_free_space` or `free_space_margin_percent` to ignore", strings.Join(problems, "; "))
+	}
+	for _, problem := range problems {
+		b.log.Warnf("not enough free space: %s", problem)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateRequiredBytes(t *testing.T) {
+	cfg := config.DefaultConfig()
+	b := &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test")}
+	tables := []clickhouse.Table{
+		{Database: "db", Name: "t1", CreateTableQuery: "CREATE TABLE db.t1"},
+		{Database: "db", Name: "skipped", Skip: true},
+	}
+	tableParts := map[metadata.TableTitle][]activePart{
+		{Database: "db", Table: "t1"}: {
+			{Name: "all_1_1_0", Disk: "default", BytesOnDisk: 1 << 30},
+			{Name: "all_2_2_0", Disk: "hdd", BytesOnDisk: 1 << 30},
+			{Name: "all_3_3_0", Disk: "s3", BytesOnDisk: 1 << 30},
+		},
+	}
+	diskTypes := map[string]string{"default": "local", "hdd": "local", "s3": "s3"}
+	assert.Equal(t, map[string]uint64{
+		"default": uint64(len("CREATE TABLE db.t1")) + tableMetadataOverhead + shadowPartOverhead,
+		"hdd":     shadowPartOverhead,
+	}, b.getCreateRequiredBytes(tables, tableParts, diskTypes), "FREEZE shall require only shadow and metadata overhead")
+
+	cfg.ClickHouse.UseEmbeddedBackupRestore = true
+	cfg.ClickHouse.EmbeddedBackupDisk = "backups"
+	assert.Equal(t, map[string]uint64{
+		"backups": uint64(len("CREATE TABLE db.t1")) + tableMetadataOverhead + 2<<30,
+	}, b.getCreateRequiredBytes(tables, tableParts, diskTypes), "BACKUP TO Disk(...) shall require copy of local parts")
+}
+
+func TestCompressionRatio(t *testing.T) {
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.WithField("logger", "test")}
+	partPath := path.Join(t.TempDir(), "all_1_1_0")
+	require.NoError(t, os.MkdirAll(partPath, 0750))
+	require.NoError(t, os.WriteFile(path.Join(partPath, "data.bin"), bytes.Repeat([]byte("0123456789"), 100000), 0640))
+	parts := []activePart{{Name: "all_1_1_0", Disk: "default", Path: partPath, BytesOnDisk: 1000000}}
+	diskTypes := map[string]string{"default": "local"}
+
+	ratio := b.getCompressionRatio(parts, diskTypes, "gzip", 1)
+	assert.Greater(t, ratio, 0.0)
+	assert.Less(t, ratio, 0.1)
+	assert.Equal(t, 1.0, b.getCompressionRatio(parts, diskTypes, "tar", 1))
+	assert.Equal(t, 1.0, b.getCompressionRatio(parts, map[string]string{"default": "s3"}, "gzip", 1), "object disk parts are not readable locally")
+	parts[0].Path = path.Join(partPath, "absent")
+	assert.Equal(t, 1.0, b.getCompressionRatio(parts, diskTypes, "gzip", 1))
+}
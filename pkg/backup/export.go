@@ -0,0 +1,464 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+)
+
+// exportManifestFile - first entry of export stream, `import` use it to choose where to put other entries
+const exportManifestFile = "clickhouse-backup-export.json"
+
+const (
+	exportSourceLocal  = "local"
+	exportSourceRemote = "remote"
+)
+
+// exportRemotePathPrefix - remote backup data uploaded under general->table_storage_rules path_prefix exported as `remote/.path_prefix/<path_prefix>/shadow/...`
+const exportRemotePathPrefix = ".path_prefix"
+
+// exportManifest - local backup entries named `local/<disk>/<path inside disk backup directory>`, remote backup entries named `remote/<path inside backup>`
+// and contain objects as is, compressed archives are not unpacked, so the same compression_format is not required on import side
+type exportManifest struct {
+	BackupName   string    `json:"backup_name"`
+	Source       string    `json:"source"`
+	Version      string    `json:"version"`
+	CreationDate time.Time `json:"creation_date"`
+}
+
+// Export - write local or remote backup as single tar stream, metadata.json is written last, so interrupted stream will never produce backup which looks complete after import
+func (b *Backuper) Export(backupName string, remote bool, w io.Writer, version string, commandId int) error {
+	b.setCommandLog(commandId)
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	log := b.log.WithField("logger", "Export").WithField("backup", backupName)
+	start := time.Now()
+	tw := tar.NewWriter(w)
+	manifest := exportManifest{BackupName: backupName, Source: exportSourceLocal, Version: version, CreationDate: time.Now().UTC()}
+	if remote {
+		manifest.Source = exportSourceRemote
+	}
+	if err = writeExportManifest(tw, manifest); err != nil {
+		return err
+	}
+	var exportedSize int64
+	if remote {
+		exportedSize, err = b.exportRemote(ctx, tw, backupName)
+	} else {
+		exportedSize, err = b.exportLocal(ctx, tw, backupName)
+	}
+	if err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return fmt.Errorf("can't finish export stream: %v", err)
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(start))).WithField("size", utils.FormatBytes(uint64(exportedSize))).Info("done")
+	return nil
+}
+
+func writeExportManifest(tw *tar.Writer, manifest exportManifest) error {
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err = tw.WriteHeader(&tar.Header{Name: exportManifestFile, Mode: 0640, Size: int64(len(body)), ModTime: manifest.CreationDate, Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err = tw.Write(body)
+	return err
+}
+
+func (b *Backuper) exportLocal(ctx context.Context, tw *tar.Writer, backupName string) (int64, error) {
+	backup, disks, err := b.getLocalBackup(ctx, backupName, nil)
+	if err != nil {
+		return 0, err
+	}
+	if strings.Contains(backup.Tags, "embedded") {
+		return 0, fmt.Errorf("export doesn't support embedded backups")
+	}
+	if b.hasObjectDisks([]LocalBackup{*backup}, backupName, disks) {
+		return 0, fmt.Errorf("%s contains object disk parts, data of these parts is stored in object_disk_path of remote storage and can't be exported", backupName)
+	}
+	return exportLocalFiles(ctx, tw, backupName, disks)
+}
+
+// exportLocalFiles - metadata.json of default disk written after all other files
+func exportLocalFiles(ctx context.Context, tw *tar.Writer, backupName string, disks []clickhouse.Disk) (int64, error) {
+	var exportedSize int64
+	var metadataFile, metadataEntry string
+	for _, disk := range disks {
+		if disk.IsBackup {
+			continue
+		}
+		backupPath := path.Join(disk.Path, "backup", backupName)
+		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.Walk(backupPath, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			entryName := path.Join(exportSourceLocal, disk.Name, filesystemhelper.RelativePath(backupPath, filePath))
+			if filepath.Dir(filePath) == backupPath && info.Name() == "metadata.json" {
+				metadataFile, metadataEntry = filePath, entryName
+				return nil
+			}
+			exportedSize += info.Size()
+			return writeExportFile(tw, entryName, filePath, info)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("can't export %s: %v", backupPath, err)
+		}
+	}
+	if metadataFile == "" {
+		return 0, fmt.Errorf("%s/metadata.json not found", backupName)
+	}
+	info, err := os.Stat(metadataFile)
+	if err != nil {
+		return 0, err
+	}
+	return exportedSize + info.Size(), writeExportFile(tw, metadataEntry, metadataFile, info)
+}
+
+func writeExportFile(tw *tar.Writer, entryName, filePath string, info os.FileInfo) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if err = tw.WriteHeader(&tar.Header{Name: entryName, Mode: 0640, Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func (b *Backuper) exportRemote(ctx context.Context, tw *tar.Writer, backupName string) (int64, error) {
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return 0, fmt.Errorf("export --remote doesn't support remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if err := b.init(ctx, nil, backupName); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	remoteBackup, err := b.ReadBackupMetadataRemote(ctx, backupName)
+	if err != nil {
+		return 0, err
+	}
+	if strings.Contains(remoteBackup.Tags, "embedded") {
+		return 0, fmt.Errorf("export doesn't support embedded backups")
+	}
+	if err = b.checkRemoteObjectDiskParts(ctx, remoteBackup); err != nil {
+		return 0, err
+	}
+	// segments of big objects exported as separate objects, as they stored on remote storage
+	b.dst.SetSegmentSize(0)
+	var exportedSize int64
+	for _, pathPrefix := range remoteBackup.RemotePathPrefixes {
+		dataPath := path.Join(pathPrefix, backupName)
+		err = b.dst.Walk(ctx, dataPath+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+			exportedSize += f.Size()
+			return b.writeExportRemoteFile(ctx, tw, dataPath, f.Name(), path.Join(exportRemotePathPrefix, pathPrefix, f.Name()), f.Size(), f.LastModified())
+		})
+		if err != nil {
+			return 0, fmt.Errorf("can't export remote %s: %v", dataPath, err)
+		}
+	}
+	err = b.dst.Walk(ctx, backupName+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+		if f.Name() == "metadata.json" {
+			return nil
+		}
+		exportedSize += f.Size()
+		return b.writeExportRemoteFile(ctx, tw, backupName, f.Name(), f.Name(), f.Size(), f.LastModified())
+	})
+	if err != nil {
+		return 0, fmt.Errorf("can't export remote %s: %v", backupName, err)
+	}
+	metadataFile, err := b.dst.StatFile(ctx, path.Join(backupName, "metadata.json"))
+	if err != nil {
+		return 0, err
+	}
+	return exportedSize + metadataFile.Size(), b.writeExportRemoteFile(ctx, tw, backupName, "metadata.json", "metadata.json", metadataFile.Size(), metadataFile.LastModified())
+}
+
+func (b *Backuper) writeExportRemoteFile(ctx context.Context, tw *tar.Writer, dataPath, name, entryName string, size int64, modTime time.Time) error {
+	reader, err := b.dst.GetFileReader(ctx, path.Join(dataPath, name))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := reader.Close(); closeErr != nil {
+			b.log.Warnf("can't close %s reader: %v", name, closeErr)
+		}
+	}()
+	if err = tw.WriteHeader(&tar.Header{Name: path.Join(exportSourceRemote, entryName), Mode: 0640, Size: size, ModTime: modTime, Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, reader)
+	return err
+}
+
+// checkRemoteObjectDiskParts - object disk parts are copied to object_disk_path outside backup and can't be exported
+func (b *Backuper) checkRemoteObjectDiskParts(ctx context.Context, remoteBackup *metadata.BackupMetadata) error {
+	objectDisks := make(map[string]struct{})
+	for disk, diskType := range remoteBackup.DiskTypes {
+		if diskType == "s3" || diskType == "azure_blob_storage" {
+			objectDisks[disk] = struct{}{}
+		}
+	}
+	if len(objectDisks) == 0 {
+		return nil
+	}
+	for _, tableTitle := range remoteBackup.Tables {
+		remoteTableMetadataFile := path.Join(remoteBackup.BackupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
+		body, err := b.readRemoteFile(ctx, remoteTableMetadataFile)
+		if err != nil {
+			return err
+		}
+		table := metadata.TableMetadata{}
+		if err = json.Unmarshal(body, &table); err != nil {
+			return fmt.Errorf("can't unmarshal %s: %v", remoteTableMetadataFile, err)
+		}
+		for disk, parts := range table.Parts {
+			if _, isObjectDisk := objectDisks[disk]; isObjectDisk && len(parts) > 0 {
+				return fmt.Errorf("%s contains object disk parts in `%s`.`%s`, data of these parts is stored in object_disk_path of remote storage and can't be exported", remoteBackup.BackupName, table.Database, table.Table)
+			}
+		}
+	}
+	return nil
+}
+
+// Import - read stream produced by Export and put backup to the same kind of storage, `local` backup to disks with the same names, `remote` backup to general->remote_storage
+func (b *Backuper) Import(r io.Reader, commandId int) error {
+	b.setCommandLog(commandId)
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	start := time.Now()
+	tr := tar.NewReader(r)
+	manifest, err := readExportManifest(tr)
+	if err != nil {
+		return err
+	}
+	log := b.log.WithField("logger", "Import").WithField("backup", manifest.BackupName)
+	log.Infof("import %s backup exported by clickhouse-backup %s at %s", manifest.Source, manifest.Version, manifest.CreationDate.Format(time.RFC3339))
+	var importedSize int64
+	switch manifest.Source {
+	case exportSourceLocal:
+		disks, err := b.ch.GetDisks(ctx, true)
+		if err != nil {
+			return err
+		}
+		defaultDataPath, err := b.ch.GetDefaultPath(disks)
+		if err != nil {
+			return ErrUnknownClickhouseDataPath
+		}
+		if _, err = os.Stat(path.Join(defaultDataPath, "backup", manifest.BackupName)); err == nil {
+			return fmt.Errorf("'%s' %w", manifest.BackupName, ErrBackupIsAlreadyExists)
+		}
+		if importedSize, err = importLocalFiles(ctx, tr, manifest.BackupName, disks); err != nil {
+			return err
+		}
+		for _, disk := range disks {
+			backupPath := path.Join(disk.Path, "backup", manifest.BackupName)
+			if _, statErr := os.Stat(backupPath); statErr == nil {
+				if err = filesystemhelper.Chown(backupPath, b.ch, disks, true); err != nil {
+					log.Warnf("can't chown %s: %v", backupPath, err)
+				}
+			}
+		}
+	case exportSourceRemote:
+		if importedSize, err = b.importRemote(ctx, tr, manifest.BackupName); err != nil {
+			return err
+		}
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(start))).WithField("size", utils.FormatBytes(uint64(importedSize))).Info("done")
+	return nil
+}
+
+func readExportManifest(tr *tar.Reader) (exportManifest, error) {
+	manifest := exportManifest{}
+	header, err := tr.Next()
+	if err != nil {
+		return manifest, fmt.Errorf("can't read export stream: %v", err)
+	}
+	if header.Name != exportManifestFile {
+		return manifest, fmt.Errorf("export stream shall start with %s, got %s", exportManifestFile, header.Name)
+	}
+	body, err := io.ReadAll(tr)
+	if err != nil {
+		return manifest, err
+	}
+	if err = json.Unmarshal(body, &manifest); err != nil {
+		return manifest, fmt.Errorf("can't parse %s: %v", exportManifestFile, err)
+	}
+	if manifest.Source != exportSourceLocal && manifest.Source != exportSourceRemote {
+		return manifest, fmt.Errorf("unknown export source %s", manifest.Source)
+	}
+	if manifest.BackupName == "" || manifest.BackupName != utils.CleanBackupNameRE.ReplaceAllString(manifest.BackupName, "") {
+		return manifest, fmt.Errorf("invalid backup name %s in %s", manifest.BackupName, exportManifestFile)
+	}
+	return manifest, nil
+}
+
+// exportEntryPath - path inside backup for tar entry, reject entries which could be written outside backup directory
+func exportEntryPath(name, prefix string) (string, error) {
+	if !strings.HasPrefix(name, prefix+"/") {
+		return "", fmt.Errorf("unexpected entry %s in export stream, expected %s/ prefix", name, prefix)
+	}
+	relativePath := path.Clean(strings.TrimPrefix(name, prefix+"/"))
+	if relativePath == "." || path.IsAbs(relativePath) || relativePath == ".." || strings.HasPrefix(relativePath, "../") {
+		return "", fmt.Errorf("invalid entry %s in export stream", name)
+	}
+	return relativePath, nil
+}
+
+func importLocalFiles(ctx context.Context, tr *tar.Reader, backupName string, disks []clickhouse.Disk) (int64, error) {
+	diskPaths := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		if !disk.IsBackup {
+			diskPaths[disk.Name] = disk.Path
+		}
+	}
+	var importedSize int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return importedSize, err
+		}
+		header, err := tr.Next()
+		if err == io.EOF {
+			return importedSize, nil
+		}
+		if err != nil {
+			return importedSize, fmt.Errorf("can't read export stream: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		relativePath, err := exportEntryPath(header.Name, exportSourceLocal)
+		if err != nil {
+			return importedSize, err
+		}
+		diskName, filePath, _ := strings.Cut(relativePath, "/")
+		diskPath, exists := diskPaths[diskName]
+		if !exists || filePath == "" {
+			return importedSize, fmt.Errorf("disk %s from %s not found in system.disks", diskName, header.Name)
+		}
+		localFile := path.Join(diskPath, "backup", backupName, filePath)
+		if err = os.MkdirAll(path.Dir(localFile), 0750); err != nil {
+			return importedSize, err
+		}
+		f, err := os.OpenFile(localFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+		if err != nil {
+			return importedSize, err
+		}
+		written, err := io.Copy(f, tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return importedSize, fmt.Errorf("can't write %s: %v", localFile, err)
+		}
+		importedSize += written
+	}
+}
+
+func (b *Backuper) importRemote(ctx context.Context, tr *tar.Reader, backupName string) (int64, error) {
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return 0, fmt.Errorf("import of remote backup doesn't support remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if err := b.init(ctx, nil, backupName); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	b.dst.SetSegmentSize(0)
+	if _, err := b.dst.StatFile(ctx, path.Join(backupName, "metadata.json")); err == nil {
+		return 0, fmt.Errorf("'%s' %w on remote storage", backupName, ErrBackupIsAlreadyExists)
+	}
+	var importedSize int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return importedSize, err
+		}
+		header, err := tr.Next()
+		if err == io.EOF {
+			return importedSize, nil
+		}
+		if err != nil {
+			return importedSize, fmt.Errorf("can't read export stream: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		relativePath, err := exportEntryPath(header.Name, exportSourceRemote)
+		if err != nil {
+			return importedSize, err
+		}
+		remoteKey := path.Join(backupName, relativePath)
+		if strings.HasPrefix(relativePath, exportRemotePathPrefix+"/") {
+			pathPrefix, dataPath, found := strings.Cut(strings.TrimPrefix(relativePath, exportRemotePathPrefix+"/"), "/shadow/")
+			if !found {
+				return importedSize, fmt.Errorf("invalid entry %s in export stream", header.Name)
+			}
+			remoteKey = path.Join(pathPrefix, backupName, "shadow", dataPath)
+		}
+		// tar reader can't be re-read, so PutFile is not retried, interrupted import shall be restarted after `delete remote`
+		if err = b.dst.PutFile(ctx, remoteKey, io.NopCloser(tr)); err != nil {
+			return importedSize, fmt.Errorf("can't upload %s: %v", relativePath, err)
+		}
+		importedSize += header.Size
+	}
+}
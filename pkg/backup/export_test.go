@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImportLocalFiles(t *testing.T) {
+	ctx := context.Background()
+	srcDisks := []clickhouse.Disk{{Name: "default", Path: t.TempDir()}, {Name: "hdd", Path: t.TempDir()}}
+	files := map[string]string{
+		path.Join(srcDisks[0].Path, "backup", "full", "metadata.json"):                                         `{"backup_name":"full"}`,
+		path.Join(srcDisks[0].Path, "backup", "full", "metadata", "db", "t.json"):                              `{"table":"t"}`,
+		path.Join(srcDisks[0].Path, "backup", "full", "shadow", "db", "t", "default", "all_1_1_0", "data.bin"): "default disk data",
+		path.Join(srcDisks[1].Path, "backup", "full", "shadow", "db", "t", "hdd", "all_2_2_0", "data.bin"):     "hdd disk data",
+	}
+	for file, content := range files {
+		require.NoError(t, os.MkdirAll(path.Dir(file), 0750))
+		require.NoError(t, os.WriteFile(file, []byte(content), 0640))
+	}
+
+	stream := &bytes.Buffer{}
+	tw := tar.NewWriter(stream)
+	require.NoError(t, writeExportManifest(tw, exportManifest{BackupName: "full", Source: exportSourceLocal, CreationDate: time.Now()}))
+	size, err := exportLocalFiles(ctx, tw, "full", srcDisks)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	assert.Equal(t, int64(len(`{"backup_name":"full"}`)+len(`{"table":"t"}`)+len("default disk data")+len("hdd disk data")), size)
+
+	tr := tar.NewReader(bytes.NewReader(stream.Bytes()))
+	manifest, err := readExportManifest(tr)
+	require.NoError(t, err)
+	assert.Equal(t, "full", manifest.BackupName)
+	dstDisks := []clickhouse.Disk{{Name: "default", Path: t.TempDir()}, {Name: "hdd", Path: t.TempDir()}}
+	_, err = importLocalFiles(ctx, tr, manifest.BackupName, dstDisks)
+	require.NoError(t, err)
+	for file, content := range files {
+		for i := range srcDisks {
+			if rel, found := strings.CutPrefix(file, srcDisks[i].Path); found {
+				actual, err := os.ReadFile(dstDisks[i].Path + rel)
+				require.NoError(t, err)
+				assert.Equal(t, content, string(actual))
+			}
+		}
+	}
+
+	// metadata.json shall be last entry
+	tr = tar.NewReader(bytes.NewReader(stream.Bytes()))
+	var lastEntry string
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		lastEntry = header.Name
+	}
+	assert.Equal(t, "local/default/metadata.json", lastEntry)
+}
+
+func TestExportEntryPath(t *testing.T) {
+	relativePath, err := exportEntryPath("local/default/shadow/db/t/default/all_1_1_0/data.bin", exportSourceLocal)
+	require.NoError(t, err)
+	assert.Equal(t, "default/shadow/db/t/default/all_1_1_0/data.bin", relativePath)
+	_, err = exportEntryPath("local/../../etc/passwd", exportSourceLocal)
+	assert.Error(t, err)
+	_, err = exportEntryPath("remote/metadata.json", exportSourceLocal)
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+// getFreezePartitionsRule - first clickhouse->freeze_partitions_rules item which `tables` patterns match db.table, nil when no rule matched
+func (b *Backuper) getFreezePartitionsRule(database, table string) *config.FreezePartitionsRule {
+	tableName := fmt.Sprintf("%s.%s", database, table)
+	for i, rule := range b.cfg.ClickHouse.FreezePartitionsRules {
+		for _, pattern := range strings.Split(rule.Tables, ",") {
+			if matched, _ := filepath.Match(strings.Trim(pattern, " \t\r\n"), tableName); matched {
+				return &b.cfg.ClickHouse.FreezePartitionsRules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// applyFreezePartitionsRules - fill partitionsIdMap for MergeTree tables which matched clickhouse->freeze_partitions_rules, tables with partitions from `--partitions` keep them
+func (b *Backuper) applyFreezePartitionsRules(ctx context.Context, tables []clickhouse.Table, partitionsIdMap map[metadata.TableTitle]common.EmptyMap) error {
+	if len(b.cfg.ClickHouse.FreezePartitionsRules) == 0 {
+		return nil
+	}
+	for _, table := range tables {
+		tableTitle := metadata.TableTitle{Database: table.Database, Table: table.Name}
+		if table.Skip || !strings.HasSuffix(table.Engine, "MergeTree") || len(partitionsIdMap[tableTitle]) > 0 {
+			continue
+		}
+		rule := b.getFreezePartitionsRule(table.Database, table.Name)
+		if rule == nil {
+			continue
+		}
+		var partitionIds []string
+		if rule.LastPartitions > 0 {
+			var err error
+			if partitionIds, err = b.ch.GetActivePartitionIds(ctx, table.Database, table.Name, rule.LastPartitions); err != nil {
+				return err
+			}
+		} else {
+			for _, partitionId := range strings.Split(rule.Partitions, ",") {
+				if partitionId = strings.Trim(partitionId, " \t\r\n"); partitionId != "" {
+					partitionIds = append(partitionIds, partitionId)
+				}
+			}
+		}
+		// table without active parts, FREEZE of whole table is no-op
+		if len(partitionIds) == 0 {
+			continue
+		}
+		partitionsIdMap[tableTitle] = make(common.EmptyMap, len(partitionIds))
+		for _, partitionId := range partitionIds {
+			partitionsIdMap[tableTitle][partitionId] = struct{}{}
+		}
+		b.log.WithFields(apexLog.Fields{
+			"table":      fmt.Sprintf("%s.%s", table.Database, table.Name),
+			"partitions": strings.Join(partitionIds, ","),
+		}).Info("freeze_partitions_rules matched")
+	}
+	return nil
+}
+
+// getFreezePartitionIds - nil means FREEZE whole table, otherwise partition_id of active parts matched with partitionsIdsMap, which could contain glob patterns,
+// so shadow contains only selected partitions instead of hardlinks for all parts of table
+func (b *Backuper) getFreezePartitionIds(ctx context.Context, table *clickhouse.Table, partitionsIdsMap common.EmptyMap) ([]string, error) {
+	if len(partitionsIdsMap) == 0 {
+		return nil, nil
+	}
+	activePartitionIds, err := b.ch.GetActivePartitionIds(ctx, table.Database, table.Name, 0)
+	if err != nil {
+		return nil, err
+	}
+	partitionIds := make([]string, 0, len(partitionsIdsMap))
+	for _, partitionId := range activePartitionIds {
+		if filesystemhelper.IsPartitionIdMatched(partitionId, partitionsIdsMap) {
+			partitionIds = append(partitionIds, partitionId)
+		}
+	}
+	return partitionIds, nil
+}
@@ -0,0 +1,42 @@
+package backup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyFreezePartitionsRules(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ClickHouse.FreezePartitionsRules = []config.FreezePartitionsRule{
+		{Tables: "db.events_*, logs.*", Partitions: "202401*, 20231231"},
+		{Tables: "db.*", Partitions: "all"},
+	}
+	b := &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test")}
+	tables := []clickhouse.Table{
+		{Database: "db", Name: "events_local", Engine: "ReplicatedMergeTree"},
+		{Database: "db", Name: "events_with_partitions", Engine: "MergeTree"},
+		{Database: "db", Name: "dictionary", Engine: "MergeTree"},
+		{Database: "db", Name: "events_skipped", Engine: "MergeTree", Skip: true},
+		{Database: "logs", Name: "access", Engine: "Log"},
+		{Database: "other", Name: "t1", Engine: "MergeTree"},
+	}
+	partitionsIdMap := map[metadata.TableTitle]common.EmptyMap{
+		{Database: "db", Table: "events_with_partitions"}: {"202312": {}},
+	}
+	require.NoError(t, b.applyFreezePartitionsRules(context.Background(), tables, partitionsIdMap))
+	assert.Equal(t, common.EmptyMap{"202401*": {}, "20231231": {}}, partitionsIdMap[metadata.TableTitle{Database: "db", Table: "events_local"}])
+	assert.Equal(t, common.EmptyMap{"202312": {}}, partitionsIdMap[metadata.TableTitle{Database: "db", Table: "events_with_partitions"}], "partitions from --partitions shall be kept")
+	assert.Equal(t, common.EmptyMap{"all": {}}, partitionsIdMap[metadata.TableTitle{Database: "db", Table: "dictionary"}])
+	for _, tableTitle := range []metadata.TableTitle{{Database: "db", Table: "events_skipped"}, {Database: "logs", Table: "access"}, {Database: "other", Table: "t1"}} {
+		assert.Empty(t, partitionsIdMap[tableTitle], "%s.%s shall be frozen as is", tableTitle.Database, tableTitle.Table)
+	}
+}
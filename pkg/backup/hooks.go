@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+
+	apexLog "github.com/apex/log"
+	"github.com/mattn/go-shellwords"
+)
+
+// operationHooks - hooks->before_<operation>, hooks->after_<operation> and hooks->on_failure for one top level operation, nil when hooks are skipped
+type operationHooks struct {
+	b          *Backuper
+	operation  string
+	backupName string
+	start      time.Time
+}
+
+// newOperationHooks - dry run and nested operations, like download of required backup in incremental chain, don't run own hooks
+func (b *Backuper) newOperationHooks(operation, backupName string) *operationHooks {
+	if b.dryRun || b.runningHooks {
+		return nil
+	}
+	b.runningHooks = true
+	return &operationHooks{b: b, operation: operation, backupName: backupName, start: time.Now()}
+}
+
+// before - failure of hooks->before_<operation> aborts operation
+func (h *operationHooks) before(ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	return h.run(ctx, "before_"+h.operation, "start", nil)
+}
+
+// after - shall be deferred with named error result, run hooks->after_<operation> when err is nil and hooks->on_failure otherwise,
+// failure of hooks->after_<operation> fails operation, failure of hooks->on_failure is only logged, hooks run even when operation context is canceled
+func (h *operationHooks) after(ctx context.Context, err error) error {
+	if h == nil {
+		return err
+	}
+	h.b.runningHooks = false
+	ctx = context.WithoutCancel(ctx)
+	if err == nil {
+		return h.run(ctx, "after_"+h.operation, "success", nil)
+	}
+	if hookErr := h.run(ctx, "on_failure", "failure", err); hookErr != nil {
+		h.b.log.WithField("operation", h.operation).Warn(hookErr.Error())
+	}
+	return err
+}
+
+func (h *operationHooks) command(hook string) string {
+	hooks := h.b.cfg.Hooks
+	return map[string]string{
+		"before_create":   hooks.BeforeCreate,
+		"after_create":    hooks.AfterCreate,
+		"before_upload":   hooks.BeforeUpload,
+		"after_upload":    hooks.AfterUpload,
+		"before_download": hooks.BeforeDownload,
+		"after_download":  hooks.AfterDownload,
+		"before_restore":  hooks.BeforeRestore,
+		"after_restore":   hooks.AfterRestore,
+		"on_failure":      hooks.OnFailure,
+	}[hook]
+}
+
+// env - environment variables which hook command receives in addition to clickhouse-backup environment
+func (h *operationHooks) env(hook, status string, operationErr error) []string {
+	errorMessage := ""
+	if operationErr != nil {
+		errorMessage = operationErr.Error()
+	}
+	return []string{
+		"CLICKHOUSE_BACKUP_HOOK=" + hook,
+		"CLICKHOUSE_BACKUP_OPERATION=" + h.operation,
+		"CLICKHOUSE_BACKUP_NAME=" + h.backupName,
+		"CLICKHOUSE_BACKUP_STATUS=" + status,
+		"CLICKHOUSE_BACKUP_ERROR=" + errorMessage,
+		"CLICKHOUSE_BACKUP_DURATION=" + fmt.Sprintf("%.0f", time.Since(h.start).Seconds()),
+	}
+}
+
+// run - execute hook command with hooks->timeout, stdout and stderr are logged, empty command is skipped
+func (h *operationHooks) run(ctx context.Context, hook, status string, operationErr error) error {
+	command := h.command(hook)
+	if command == "" {
+		return nil
+	}
+	args, err := shellwords.Parse(command)
+	if err != nil {
+		return fmt.Errorf("can't parse hooks->%s `%s`: %v", hook, command, err)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	log := h.b.log.WithFields(apexLog.Fields{
+		"hook":      hook,
+		"operation": h.operation,
+		"backup":    h.backupName,
+	})
+	startHook := time.Now()
+	stdout, stderr, err := utils.ExecCmdWithInput(ctx, h.b.cfg.Hooks.TimeoutDuration, h.env(hook, status, operationErr), nil, args[0], args[1:]...)
+	if stdout = strings.TrimSpace(stdout); stdout != "" {
+		log.Info(stdout)
+	}
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		log.Warn(stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("hooks->%s `%s` failed: %v", hook, command, err)
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(startHook))).Info("done")
+	return nil
+}
@@ -0,0 +1,49 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperationHooks(t *testing.T) {
+	hooksLog := path.Join(t.TempDir(), "hooks.log")
+	hookCommand := fmt.Sprintf("sh -c 'echo $CLICKHOUSE_BACKUP_HOOK $CLICKHOUSE_BACKUP_OPERATION $CLICKHOUSE_BACKUP_NAME $CLICKHOUSE_BACKUP_STATUS $CLICKHOUSE_BACKUP_ERROR >> %s'", hooksLog)
+	cfg := config.DefaultConfig()
+	cfg.Hooks.BeforeCreate = hookCommand
+	cfg.Hooks.AfterCreate = hookCommand
+	cfg.Hooks.BeforeDownload = "sh -c 'exit 1'"
+	cfg.Hooks.OnFailure = hookCommand
+	cfg.Hooks.TimeoutDuration = time.Minute
+	b := &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test")}
+	ctx := context.Background()
+
+	hooks := b.newOperationHooks("create", "my_backup")
+	require.NoError(t, hooks.before(ctx))
+	assert.Nil(t, b.newOperationHooks("download", "required_backup"), "nested operation shall not run hooks")
+	require.NoError(t, hooks.after(ctx, nil))
+
+	hooks = b.newOperationHooks("download", "my_backup")
+	err := hooks.before(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "hooks->before_download")
+	assert.Equal(t, err, hooks.after(ctx, err))
+
+	b.dryRun = true
+	hooks = b.newOperationHooks("create", "dry_run_backup")
+	assert.Nil(t, hooks)
+	require.NoError(t, hooks.before(ctx))
+	require.NoError(t, hooks.after(ctx, nil))
+
+	out, err := os.ReadFile(hooksLog)
+	require.NoError(t, err)
+	assert.Equal(t, "before_create create my_backup start\nafter_create create my_backup success\non_failure download my_backup failure hooks->before_download `sh -c 'exit 1'` failed: exit status 1\n", string(out))
+}
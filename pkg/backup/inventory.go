@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+)
+
+// RemoteInventory - result of full remote storage scan, used for prometheus metrics in `server` mode
+type RemoteInventory struct {
+	TotalBytes    uint64
+	OrphanedBytes uint64
+	ChunksBytes   uint64 // shared chunks for `dedup_store: true`, not included into BackupBytes
+	BackupBytes   map[string]uint64
+	BackupObjects map[string]uint64
+	Backups       []storage.Backup  // only not broken backups
+	ClusterGroups map[string]string // cluster backup name for each shard backup of `create_remote --on-cluster`, retention counts them as one backup
+	OldestBackup  time.Time
+	NewestBackup  time.Time
+}
+
+// GetRemoteInventory - walk all objects in remote storage path, calculate real size for each backup,
+// objects which don't belong to any valid backup are counted as orphaned
+func (b *Backuper) GetRemoteInventory(ctx context.Context) (*RemoteInventory, error) {
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return nil, err
+		}
+		defer b.ch.Close()
+	}
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return nil, fmt.Errorf("remote_storage: %s doesn't support inventory scan", b.cfg.General.RemoteStorage)
+	}
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+	if err != nil {
+		return nil, err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	backupList, err := bd.BackupList(ctx, true, "")
+	if err != nil {
+		return nil, err
+	}
+	clusterManifests, err := bd.GetClusterManifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inventory := &RemoteInventory{
+		ClusterGroups: storage.GetClusterBackupGroups(clusterManifests),
+		BackupBytes:   make(map[string]uint64),
+		BackupObjects: make(map[string]uint64),
+		Backups:       make([]storage.Backup, 0, len(backupList)),
+	}
+	backupByPrefix := make(map[string]string)
+	// data of tables matched general->table_storage_rules is placed into `<path_prefix>/<backup_name>`
+	pathPrefixes := make(map[string]struct{})
+	for _, backup := range backupList {
+		if backup.Broken != "" {
+			continue
+		}
+		prefix := backup.BackupName
+		if backup.Legacy {
+			prefix = backup.BackupName + "." + backup.FileExtension
+		}
+		backupByPrefix[prefix] = backup.BackupName
+		for _, dataPath := range storage.GetRemoteBackupDataPaths(backup.BackupMetadata)[1:] {
+			backupByPrefix[dataPath] = backup.BackupName
+			pathPrefixes[path.Dir(dataPath)] = struct{}{}
+		}
+		inventory.BackupBytes[backup.BackupName] = 0
+		inventory.BackupObjects[backup.BackupName] = 0
+		inventory.Backups = append(inventory.Backups, backup)
+		if inventory.OldestBackup.IsZero() || backup.CreationDate.Before(inventory.OldestBackup) {
+			inventory.OldestBackup = backup.CreationDate
+		}
+		if inventory.NewestBackup.IsZero() || backup.CreationDate.After(inventory.NewestBackup) {
+			inventory.NewestBackup = backup.CreationDate
+		}
+	}
+	err = bd.Walk(ctx, "/", true, func(ctx context.Context, f storage.RemoteFile) error {
+		if bd.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
+			return nil
+		}
+		size := uint64(f.Size())
+		inventory.TotalBytes += size
+		name := strings.TrimPrefix(f.Name(), "/")
+		prefix := strings.SplitN(name, "/", 2)[0]
+		for pathPrefix := range pathPrefixes {
+			if strings.HasPrefix(name, pathPrefix+"/") {
+				prefix = path.Join(pathPrefix, strings.SplitN(strings.TrimPrefix(name, pathPrefix+"/"), "/", 2)[0])
+				break
+			}
+		}
+		if backupName, exists := backupByPrefix[prefix]; exists {
+			inventory.BackupBytes[backupName] += size
+			inventory.BackupObjects[backupName] += 1
+		} else if prefix == storage.DedupChunksPrefix {
+			inventory.ChunksBytes += size
+		} else if prefix == storage.ClusterManifestsPrefix {
+			return nil
+		} else {
+			inventory.OrphanedBytes += size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inventory, nil
+}
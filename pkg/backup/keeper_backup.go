@@ -0,0 +1,174 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/keeper"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	"github.com/yargevad/filepathx"
+)
+
+// createBackupKeeper - dump `keeper_backup_paths` and zookeeper_path of backed up Replicated tables into backup_name/keeper/<encoded_path>.jsonl
+func (b *Backuper) createBackupKeeper(ctx context.Context, backupPath string, tableMetas []metadata.TableTitle, disks []clickhouse.Disk) (uint64, error) {
+	log := b.log.WithField("logger", "createBackupKeeper")
+	keeperPaths := make([]string, 0, len(b.cfg.ClickHouse.KeeperBackupPaths))
+	keeperPaths = append(keeperPaths, b.cfg.ClickHouse.KeeperBackupPaths...)
+	if b.cfg.ClickHouse.KeeperBackupReplicated && len(tableMetas) > 0 {
+		replicas, err := b.ch.GetReplicasZookeeperPaths(ctx)
+		if err != nil {
+			return 0, err
+		}
+		backupTables := make(map[metadata.TableTitle]struct{}, len(tableMetas))
+		for _, t := range tableMetas {
+			backupTables[t] = struct{}{}
+		}
+		for _, replica := range replicas {
+			if _, exists := backupTables[metadata.TableTitle{Database: replica.Database, Table: replica.Table}]; exists {
+				// zookeeper_path is relative to <zookeeper><root>
+				keeperPaths = append(keeperPaths, strings.TrimPrefix(replica.ZookeeperPath, "/"))
+			}
+		}
+	}
+	if len(keeperPaths) == 0 {
+		return 0, nil
+	}
+	if b.keeperFallback {
+		log.Warnf("keeper is not available, skip backup %d keeper paths", len(keeperPaths))
+		return 0, nil
+	}
+	keeperBackup := path.Join(backupPath, "keeper")
+	if err := filesystemhelper.Mkdir(keeperBackup, b.ch, disks); err != nil {
+		return 0, err
+	}
+	k := keeper.Keeper{Log: b.log.WithField("logger", "keeper")}
+	if err := k.Connect(ctx, b.ch, b.cfg); err != nil {
+		return 0, err
+	}
+	defer k.Close()
+	keeperDataSize := uint64(0)
+	dumpedPaths := make(map[string]struct{}, len(keeperPaths))
+	for _, keeperPath := range keeperPaths {
+		if _, exists := dumpedPaths[keeperPath]; exists {
+			continue
+		}
+		dumpedPaths[keeperPath] = struct{}{}
+		dumpFile := path.Join(keeperBackup, common.TablePathEncode(keeperPath)+".jsonl")
+		log.Infof("keeper.Dump %s -> %s", keeperPath, dumpFile)
+		dumpSize, err := k.Dump(keeperPath, dumpFile)
+		if err != nil {
+			return 0, err
+		}
+		if err = filesystemhelper.Chown(dumpFile, b.ch, disks, false); err != nil {
+			return 0, err
+		}
+		keeperDataSize += uint64(dumpSize)
+	}
+	log.WithField("size", utils.FormatBytes(keeperDataSize)).Infof("done dump %d keeper paths", len(dumpedPaths))
+	return keeperDataSize, nil
+}
+
+// restoreKeeper - executed after schema restore, create missing znodes from backup_name/keeper, zookeeper_path of Replicated tables restored only for tables matched tablePattern
+// and without `replicas/<replica>` subtree which CREATE TABLE already registered, `keeper_backup_paths` restored as is, then restart read-only replicas which zookeeper_path was restored
+func (b *Backuper) restoreKeeper(ctx context.Context, backupName, tablePattern string) error {
+	log := b.log.WithField("logger", "restoreKeeper")
+	jsonLFiles, err := filepathx.Glob(path.Join(b.DefaultDataPath, "backup", backupName, "keeper", "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	if len(jsonLFiles) == 0 {
+		log.Warnf("%s doesn't contain keeper directory, enable `keeper_backup_paths` or `keeper_backup_replicated_tables` during create", backupName)
+		return nil
+	}
+	replicas, err := b.ch.GetReplicasZookeeperPaths(ctx)
+	if err != nil {
+		return err
+	}
+	restoredTables, err := b.getRestoredTableTitles(ctx, backupName, tablePattern)
+	if err != nil {
+		return err
+	}
+	restoredZookeeperPaths := make(map[string]struct{})
+	for _, replica := range replicas {
+		if _, exists := restoredTables[metadata.TableTitle{Database: replica.Database, Table: replica.Table}]; exists {
+			restoredZookeeperPaths[strings.TrimPrefix(replica.ZookeeperPath, "/")] = struct{}{}
+		}
+	}
+	keeperBackupPaths := make(map[string]struct{}, len(b.cfg.ClickHouse.KeeperBackupPaths))
+	for _, keeperPath := range b.cfg.ClickHouse.KeeperBackupPaths {
+		keeperBackupPaths[strings.TrimPrefix(keeperPath, "/")] = struct{}{}
+	}
+	k := keeper.Keeper{Log: b.log.WithField("logger", "keeper")}
+	if err = k.Connect(ctx, b.ch, b.cfg); err != nil {
+		return err
+	}
+	defer k.Close()
+	restoredPaths := make([]string, 0, len(jsonLFiles))
+	for _, jsonLFile := range jsonLFiles {
+		keeperPath, err := url.PathUnescape(strings.TrimSuffix(path.Base(jsonLFile), ".jsonl"))
+		if err != nil {
+			return fmt.Errorf("can't decode keeper path from %s: %v", jsonLFile, err)
+		}
+		var created int
+		if _, isTablePath := restoredZookeeperPaths[strings.TrimPrefix(keeperPath, "/")]; isTablePath {
+			created, err = k.RestoreMissingExceptReplicas(jsonLFile, keeperPath)
+		} else if _, isBackupPath := keeperBackupPaths[strings.TrimPrefix(keeperPath, "/")]; isBackupPath {
+			created, err = k.RestoreMissing(jsonLFile, keeperPath)
+		} else {
+			log.Infof("skip %s, zookeeper_path doesn't belong to restored Replicated tables", keeperPath)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		log.Infof("keeper.RestoreMissing(%s) -> %s, created %d znodes", jsonLFile, keeperPath, created)
+		restoredPaths = append(restoredPaths, keeperPath)
+	}
+	for _, replica := range replicas {
+		if replica.IsReadonly == 0 {
+			continue
+		}
+		for _, restoredPath := range restoredPaths {
+			if replica.ZookeeperPath == restoredPath || replica.ZookeeperPath == "/"+restoredPath {
+				log.Infof("SYSTEM RESTART REPLICA `%s`.`%s`", replica.Database, replica.Table)
+				if err = b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM RESTART REPLICA `%s`.`%s`", replica.Database, replica.Table)); err != nil {
+					return fmt.Errorf("can't restart replica `%s`.`%s`: %v", replica.Database, replica.Table, err)
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// getRestoredTableTitles - tables from backup matched tablePattern, with applied --restore-database-mapping and --restore-table-mapping
+func (b *Backuper) getRestoredTableTitles(ctx context.Context, backupName, tablePattern string) (map[metadata.TableTitle]struct{}, error) {
+	restoredTables := make(map[metadata.TableTitle]struct{})
+	metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
+	if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
+		return restoredTables, nil
+	}
+	tablesForRestore, _, err := b.getTableListByPatternLocal(ctx, metadataPath, tablePattern, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tablesForRestore {
+		tableTitle := metadata.TableTitle{Database: table.Database, Table: table.Table}
+		if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[table.Database]; isMapped {
+			tableTitle.Database = targetDB
+		}
+		if targetTable, isMapped := b.cfg.General.RestoreTableMapping[table.Table]; isMapped {
+			tableTitle.Table = targetTable
+		}
+		restoredTables[tableTitle] = struct{}{}
+	}
+	return restoredTables, nil
+}
@@ -0,0 +1,44 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRestoredTableTitles(t *testing.T) {
+	cfg := config.DefaultConfig()
+	b := &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test"), DefaultDataPath: t.TempDir()}
+	restoredTables, err := b.getRestoredTableTitles(context.Background(), "backup", "*")
+	require.NoError(t, err)
+	assert.Empty(t, restoredTables, "backup without metadata")
+
+	for _, table := range []metadata.TableMetadata{
+		{Database: "db1", Table: "t1", Query: "CREATE TABLE db1.t1 (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id"},
+		{Database: "db1", Table: "t2", Query: "CREATE TABLE db1.t2 (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id"},
+		{Database: "db2", Table: "t3", Query: "CREATE TABLE db2.t3 (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id"},
+	} {
+		metadataFile := path.Join(b.DefaultDataPath, "backup", "backup", "metadata", table.Database, table.Table+".json")
+		require.NoError(t, os.MkdirAll(path.Dir(metadataFile), 0750))
+		body, err := json.Marshal(table)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(metadataFile, body, 0640))
+	}
+	cfg.General.RestoreDatabaseMapping = map[string]string{"db1": "db1_copy"}
+	cfg.General.RestoreTableMapping = map[string]string{"t2": "t2_copy"}
+	restoredTables, err = b.getRestoredTableTitles(context.Background(), "backup", "db1.*")
+	require.NoError(t, err)
+	assert.Equal(t, map[metadata.TableTitle]struct{}{
+		{Database: "db1_copy", Table: "t1"}:      {},
+		{Database: "db1_copy", Table: "t2_copy"}: {},
+	}, restoredTables)
+}
@@ -0,0 +1,99 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+var replicatedEngineRE = regexp.MustCompile(`ENGINE\s*=\s*Replicated\w*MergeTree`)
+
+// keeperFallbackConversionRules - the same conversion as `--convert-engines=Replicated*MergeTree=*MergeTree`
+var keeperFallbackConversionRules, _ = parseEngineConversionRules([]string{"Replicated*MergeTree=*MergeTree"})
+
+// convertReplicatedToNonReplicatedEngine - `ReplicatedReplacingMergeTree('/path', '{replica}', ver)` -> `ReplacingMergeTree(ver)`
+func convertReplicatedToNonReplicatedEngine(query string) string {
+	query, _ = convertTableEngine(query, keeperFallbackConversionRules, "", "")
+	return query
+}
+
+func hasReplicatedTables(tables []clickhouse.Table) bool {
+	for _, table := range tables {
+		if !table.Skip && strings.HasPrefix(table.Engine, "Replicated") {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreHasReplicatedTables - restore of MergeTree tables shall work on server without keeper, so keeper checked only when Replicated databases or tables matched tablePattern
+func (b *Backuper) restoreHasReplicatedTables(ctx context.Context, backupName, tablePattern string, databases []metadata.DatabasesMeta) (bool, error) {
+	for _, database := range databases {
+		if database.Engine == "Replicated" {
+			return true, nil
+		}
+	}
+	metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
+	if b.isEmbedded {
+		metadataPath = path.Join(b.EmbeddedBackupDataPath, backupName, "metadata")
+	}
+	// rbac and configs only backups don't contain metadata
+	if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
+		return false, nil
+	}
+	tablesForRestore, _, err := b.getTableListByPatternLocal(ctx, metadataPath, tablePattern, false, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, table := range tablesForRestore {
+		if replicatedEngineRE.MatchString(table.Query) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkKeeperSafeMode - check keeper session before touch Replicated tables, according to clickhouse->keeper_safe_mode
+// `wait` - wait until keeper available during keeper_wait_timeout, `fallback` - handle Replicated tables as non-replicated, `abort` - return error
+func (b *Backuper) checkKeeperSafeMode(ctx context.Context) error {
+	b.keeperFallback = false
+	if b.cfg.ClickHouse.KeeperSafeMode == "" {
+		return nil
+	}
+	log := b.log.WithField("logger", "checkKeeperSafeMode")
+	keeperErr := b.ch.CheckKeeperConnection(ctx)
+	if keeperErr == nil {
+		return nil
+	}
+	switch b.cfg.ClickHouse.KeeperSafeMode {
+	case "fallback":
+		log.Warnf("%v, Replicated tables will handle as non-replicated", keeperErr)
+		b.keeperFallback = true
+		return nil
+	case "wait":
+		waitTimeout, _ := time.ParseDuration(b.cfg.ClickHouse.KeeperWaitTimeout)
+		log.Warnf("%v, will wait %s", keeperErr, waitTimeout)
+		deadline := time.Now().Add(waitTimeout)
+		for time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+			if keeperErr = b.ch.CheckKeeperConnection(ctx); keeperErr == nil {
+				log.Info("keeper available")
+				return nil
+			}
+			log.Debugf("still waiting: %v", keeperErr)
+		}
+		return fmt.Errorf("keeper_wait_timeout=%s exceeded: %v", waitTimeout, keeperErr)
+	}
+	return fmt.Errorf("keeper_safe_mode=abort: %v", keeperErr)
+}
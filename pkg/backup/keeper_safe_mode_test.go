@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertReplicatedToNonReplicatedEngine(t *testing.T) {
+	testCases := map[string]string{
+		"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id": "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree() ORDER BY id",
+		"CREATE TABLE db.t (id UInt64, ver UInt64) ENGINE = ReplicatedReplacingMergeTree('/p', '{replica}', ver) ORDER BY id":    "CREATE TABLE db.t (id UInt64, ver UInt64) ENGINE = ReplacingMergeTree(ver) ORDER BY id",
+		"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree() ORDER BY id":                                               "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree() ORDER BY id",
+		"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id":                                                           "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id",
+		"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id":                                                 "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree() ORDER BY id",
+	}
+	for query, expected := range testCases {
+		assert.Equal(t, expected, convertReplicatedToNonReplicatedEngine(query))
+	}
+}
+
+func TestRestoreHasReplicatedTables(t *testing.T) {
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.WithField("logger", "test"), DefaultDataPath: t.TempDir()}
+	hasReplicated, err := b.restoreHasReplicatedTables(context.Background(), "backup", "*", nil)
+	require.NoError(t, err)
+	assert.False(t, hasReplicated, "backup without metadata")
+	metadataPath := path.Join(b.DefaultDataPath, "backup", "backup", "metadata", "db")
+	require.NoError(t, os.MkdirAll(metadataPath, 0750))
+	for table, query := range map[string]string{
+		"t1": "CREATE TABLE db.t1 (id UInt64) ENGINE = MergeTree ORDER BY id",
+		"t2": "CREATE TABLE db.t2 (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t2', '{replica}') ORDER BY id",
+	} {
+		body, err := json.Marshal(metadata.TableMetadata{Database: "db", Table: table, Query: query})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path.Join(metadataPath, table+".json"), body, 0640))
+	}
+	hasReplicated, err = b.restoreHasReplicatedTables(context.Background(), "backup", "db.t1", nil)
+	require.NoError(t, err)
+	assert.False(t, hasReplicated)
+	hasReplicated, err = b.restoreHasReplicatedTables(context.Background(), "backup", "db.*", nil)
+	require.NoError(t, err)
+	assert.True(t, hasReplicated)
+	hasReplicated, err = b.restoreHasReplicatedTables(context.Background(), "backup", "db.t1", []metadata.DatabasesMeta{{Name: "db", Engine: "Replicated"}})
+	require.NoError(t, err)
+	assert.True(t, hasReplicated)
+}
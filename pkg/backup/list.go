@@ -2,11 +2,11 @@ package backup
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 	"text/tabwriter"
@@ -21,14 +21,23 @@ import (
 	apexLog "github.com/apex/log"
 )
 
-// List - list backups to stdout from command line
-func (b *Backuper) List(what, format string) error {
+// List - list backups to stdout from command line, `cost` adds estimation of object storage cost for remote backups, `outputFormat` and `newerThan` see PrintBackupsFormatted
+func (b *Backuper) List(what, format string, allShards, cost bool, outputFormat, newerThan string) error {
 	ctx, cancel, _ := status.Current.GetContextWithCancel(status.NotFromAPI)
 	defer cancel()
+	if (outputFormat != "" || newerThan != "") && !allShards && !cost {
+		return b.PrintBackupsFormatted(ctx, what, format, outputFormat, newerThan)
+	}
 	switch what {
 	case "local":
 		return b.PrintLocalBackups(ctx, format)
 	case "remote":
+		if allShards {
+			return b.PrintRemoteBackupsAllShards(ctx)
+		}
+		if cost {
+			return b.PrintRemoteBackupsCost(ctx)
+		}
 		return b.PrintRemoteBackups(ctx, format)
 	case "all", "":
 		return b.PrintAllBackups(ctx, format)
@@ -152,6 +161,9 @@ func (b *Backuper) PrintLocalBackups(ctx context.Context, format string) error {
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if backupList, err = b.filterLocalBackupsByNameTemplate(ctx, backupList); err != nil {
+		return err
+	}
 	return printBackupsLocal(ctx, w, backupList, format)
 }
 
@@ -235,14 +247,18 @@ func (b *Backuper) GetLocalBackups(ctx context.Context, disks []clickhouse.Disk)
 					})
 					continue
 				}
-				var backupMetadata metadata.BackupMetadata
-				if err := json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
+				backupMetadata, err := metadata.ParseBackupMetadata(backupMetadataBody)
+				if err != nil {
 					return nil, disks, err
 				}
-				result = append(result, LocalBackup{
-					BackupMetadata: backupMetadata,
+				localBackup := LocalBackup{
+					BackupMetadata: *backupMetadata,
 					Legacy:         false,
-				})
+				}
+				if backupMetadata.DataMoved {
+					localBackup.Broken = "broken (data moved by restore --restore-data-mode=move)"
+				}
+				result = append(result, localBackup)
 			}
 			if closeErr := d.Close(); closeErr != nil {
 				log.Errorf("can't close %s openError: %v", backupPath, closeErr)
@@ -255,6 +271,49 @@ func (b *Backuper) GetLocalBackups(ctx context.Context, disks []clickhouse.Disk)
 	return result, disks, nil
 }
 
+// GetLocalBackupTablesMetadata - read metadata of all tables in local backup without table pattern filtering, used for per table metrics,
+// embedded backups don't contain table metadata .json files, so empty list returned for them
+func (b *Backuper) GetLocalBackupTablesMetadata(ctx context.Context, backupName string) ([]metadata.TableMetadata, error) {
+	var err error
+	if !b.ch.IsOpen {
+		if err = b.ch.Connect(); err != nil {
+			return nil, err
+		}
+		defer b.ch.Close()
+	}
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	defaultDataPath, err := b.ch.GetDefaultPath(disks)
+	if err != nil {
+		return nil, err
+	}
+	metadataPath := path.Join(defaultDataPath, "backup", backupName, "metadata")
+	var tables []metadata.TableMetadata
+	err = filepath.Walk(metadataPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !info.Mode().IsRegular() || !strings.HasSuffix(filePath, ".json") {
+			return nil
+		}
+		tm := metadata.TableMetadata{}
+		if _, err := tm.Load(filePath); err != nil {
+			return err
+		}
+		tables = append(tables, tm)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return tables, err
+}
+
 func (b *Backuper) PrintAllBackups(ctx context.Context, format string) error {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
 	if !b.ch.IsOpen {
@@ -273,6 +332,9 @@ func (b *Backuper) PrintAllBackups(ctx context.Context, format string) error {
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if localBackups, err = b.filterLocalBackupsByNameTemplate(ctx, localBackups); err != nil {
+		return err
+	}
 	if err = printBackupsLocal(ctx, w, localBackups, format); err != nil {
 		log.Warnf("printBackupsLocal return error: %v", err)
 	}
@@ -282,6 +344,9 @@ func (b *Backuper) PrintAllBackups(ctx context.Context, format string) error {
 		if err != nil {
 			return err
 		}
+		if remoteBackups, err = b.filterRemoteBackupsByNameTemplate(ctx, remoteBackups); err != nil {
+			return err
+		}
 		if err = printBackupsRemote(w, remoteBackups, format); err != nil {
 			log.Warnf("printBackupsRemote return error: %v", err)
 		}
@@ -308,6 +373,9 @@ func (b *Backuper) PrintRemoteBackups(ctx context.Context, format string) error
 	if err != nil {
 		return err
 	}
+	if backupList, err = b.filterRemoteBackupsByNameTemplate(ctx, backupList); err != nil {
+		return err
+	}
 	return printBackupsRemote(w, backupList, format)
 }
 
@@ -371,6 +439,7 @@ func (b *Backuper) GetRemoteBackups(ctx context.Context, parseMetadata bool) ([]
 
 // GetTables - get all tables for use by CreateBackup, PrintTables, and API
 func (b *Backuper) GetTables(ctx context.Context, tablePattern string) ([]clickhouse.Table, error) {
+	tablePattern = b.tablePatternOrDefault(tablePattern)
 	if !b.ch.IsOpen {
 		if err := b.ch.Connect(); err != nil {
 			return []clickhouse.Table{}, fmt.Errorf("can't connect to clickhouse: %v", err)
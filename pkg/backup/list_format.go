@@ -0,0 +1,223 @@
+package backup
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// ListRow - one backup in `list --format=table|json|yaml|csv` output, fields are stable for external automation
+type ListRow struct {
+	Name                  string    `json:"name" yaml:"name"`
+	Location              string    `json:"location" yaml:"location"`
+	Storage               string    `json:"storage" yaml:"storage"`
+	Created               time.Time `json:"created" yaml:"created"`
+	UploadDate            time.Time `json:"upload_date,omitempty" yaml:"upload_date,omitempty"`
+	Size                  uint64    `json:"size" yaml:"size"`
+	DataSize              uint64    `json:"data_size" yaml:"data_size"`
+	CompressedSize        uint64    `json:"compressed_size" yaml:"compressed_size"`
+	MetadataSize          uint64    `json:"metadata_size" yaml:"metadata_size"`
+	PartsCount            int64     `json:"parts_count" yaml:"parts_count"`
+	Required              string    `json:"required" yaml:"required"`
+	UploadDurationSeconds float64   `json:"upload_duration_seconds" yaml:"upload_duration_seconds"`
+	DataFormat            string    `json:"data_format" yaml:"data_format"`
+	Description           string    `json:"description" yaml:"description"`
+}
+
+var listRowColumns = []string{"name", "location", "storage", "created", "size", "compressed_size", "parts_count", "required", "upload_duration", "description"}
+
+// ParseNewerThan - time.ParseDuration with additional `d` and `w` suffixes, like `7d` or `2w`
+func ParseNewerThan(newerThan string) (time.Duration, error) {
+	if newerThan == "" {
+		return 0, nil
+	}
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(newerThan, suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(newerThan, suffix), 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid --newer-than=%s", newerThan)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(newerThan)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid --newer-than=%s", newerThan)
+	}
+	return d, nil
+}
+
+func newListRow(backup metadata.BackupMetadata, location, storageKind string, legacy bool, broken string, uploadDate time.Time) ListRow {
+	row := ListRow{
+		Name:                  backup.BackupName,
+		Location:              location,
+		Storage:               storageKind,
+		Created:               backup.CreationDate,
+		UploadDate:            uploadDate,
+		Size:                  backup.DataSize + backup.MetadataSize,
+		DataSize:              backup.DataSize,
+		CompressedSize:        backup.CompressedSize,
+		MetadataSize:          backup.MetadataSize,
+		PartsCount:            backup.PartsCount,
+		Required:              backup.RequiredBackup,
+		UploadDurationSeconds: backup.UploadDurationSeconds,
+		DataFormat:            backup.DataFormat,
+		Description:           backup.DataFormat,
+	}
+	if legacy {
+		row.Description += ", old-format"
+	}
+	if backup.Tags != "" {
+		row.Description += ", " + backup.Tags
+	}
+	if broken != "" {
+		row.Description = broken
+	}
+	row.Description = strings.TrimPrefix(row.Description, ", ")
+	return row
+}
+
+// selectListRows - apply --newer-than, then `latest` and `previous` for each location separately, the same as plain `list`
+func selectListRows(rows []ListRow, latest string, newerThan time.Duration, now time.Time) ([]ListRow, error) {
+	if newerThan > 0 {
+		filtered := make([]ListRow, 0, len(rows))
+		for _, row := range rows {
+			if !row.Created.Before(now.Add(-newerThan)) {
+				filtered = append(filtered, row)
+			}
+		}
+		rows = filtered
+	}
+	switch latest {
+	case "all", "":
+		return rows, nil
+	case "latest", "last", "l":
+		if len(rows) < 1 {
+			return nil, fmt.Errorf("no backups found")
+		}
+		return rows[len(rows)-1:], nil
+	case "penult", "prev", "previous", "p":
+		if len(rows) < 2 {
+			return nil, fmt.Errorf("no previous backup is found")
+		}
+		return rows[len(rows)-2 : len(rows)-1], nil
+	}
+	return nil, fmt.Errorf("'%s' undefined", latest)
+}
+
+// PrintBackupsFormatted - `list --format` and `list --newer-than`, local backups go first, then remote, both sorted by creation date
+func (b *Backuper) PrintBackupsFormatted(ctx context.Context, what, latest, outputFormat, newerThan string) error {
+	newerThanDuration, err := ParseNewerThan(newerThan)
+	if err != nil {
+		return err
+	}
+	if outputFormat == "" {
+		outputFormat = "table"
+	}
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		defer b.ch.Close()
+	}
+	now := time.Now()
+	rows := make([]ListRow, 0)
+	if what == "local" || what == "all" || what == "" {
+		localBackups, _, err := b.GetLocalBackups(ctx, nil)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if localBackups, err = b.filterLocalBackupsByNameTemplate(ctx, localBackups); err != nil {
+			return err
+		}
+		localRows := make([]ListRow, 0, len(localBackups))
+		for _, backup := range localBackups {
+			localRows = append(localRows, newListRow(backup.BackupMetadata, "local", "local", backup.Legacy, backup.Broken, time.Time{}))
+		}
+		if localRows, err = selectListRows(localRows, latest, newerThanDuration, now); err != nil && what == "local" {
+			return err
+		}
+		rows = append(rows, localRows...)
+	}
+	if (what == "remote" || what == "all" || what == "") && b.cfg.General.RemoteStorage != "none" {
+		remoteBackups, err := b.GetRemoteBackups(ctx, true)
+		if err != nil {
+			return err
+		}
+		if remoteBackups, err = b.filterRemoteBackupsByNameTemplate(ctx, remoteBackups); err != nil {
+			return err
+		}
+		remoteRows := make([]ListRow, 0, len(remoteBackups))
+		for _, backup := range remoteBackups {
+			remoteRows = append(remoteRows, newListRow(backup.BackupMetadata, "remote", b.cfg.General.RemoteStorage, backup.Legacy, backup.Broken, backup.UploadDate))
+		}
+		if remoteRows, err = selectListRows(remoteRows, latest, newerThanDuration, now); err != nil && what == "remote" {
+			return err
+		}
+		rows = append(rows, remoteRows...)
+	}
+	return writeListRows(os.Stdout, rows, outputFormat)
+}
+
+func writeListRows(w io.Writer, rows []ListRow, outputFormat string) error {
+	switch outputFormat {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	case "yaml":
+		encoder := yaml.NewEncoder(w)
+		if err := encoder.Encode(rows); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "csv":
+		csvWriter := csv.NewWriter(w)
+		if err := csvWriter.Write(listRowColumns); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			record := []string{
+				row.Name, row.Location, row.Storage, row.Created.UTC().Format(time.RFC3339),
+				strconv.FormatUint(row.Size, 10), strconv.FormatUint(row.CompressedSize, 10), strconv.FormatInt(row.PartsCount, 10),
+				row.Required, strconv.FormatFloat(row.UploadDurationSeconds, 'f', 3, 64), row.Description,
+			}
+			if err := csvWriter.Write(record); err != nil {
+				return err
+			}
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		if _, err := fmt.Fprintln(tw, strings.ToUpper(strings.Join(listRowColumns, "\t"))); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			uploadDuration := ""
+			if row.UploadDurationSeconds > 0 {
+				uploadDuration = utils.HumanizeDuration(time.Duration(row.UploadDurationSeconds * float64(time.Second)))
+			}
+			compressedSize := ""
+			if row.CompressedSize > 0 {
+				compressedSize = utils.FormatBytes(row.CompressedSize)
+			}
+			if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", row.Name, row.Location, row.Storage, row.Created.Format("02/01/2006 15:04:05"), utils.FormatBytes(row.Size), compressedSize, row.PartsCount, row.Required, uploadDuration, row.Description); err != nil {
+				return err
+			}
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unsupported --format=%s, shall be one of table, json, yaml, csv", outputFormat)
+}
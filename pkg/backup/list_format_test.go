@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNewerThan(t *testing.T) {
+	d, err := ParseNewerThan("7d")
+	assert.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, d)
+	d, err = ParseNewerThan("12h")
+	assert.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, d)
+	_, err = ParseNewerThan("xd")
+	assert.Error(t, err)
+}
+
+func TestSelectAndWriteListRows(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	rows := []ListRow{
+		{Name: "old", Location: "remote", Storage: "s3", Created: now.Add(-10 * 24 * time.Hour)},
+		{Name: "base", Location: "remote", Storage: "s3", Created: now.Add(-2 * 24 * time.Hour), Size: 100, PartsCount: 3},
+		{Name: "increment", Location: "remote", Storage: "s3", Created: now.Add(-time.Hour), Required: "base", UploadDurationSeconds: 1.5},
+	}
+	selected, err := selectListRows(rows, "", 7*24*time.Hour, now)
+	assert.NoError(t, err)
+	assert.Equal(t, rows[1:], selected)
+	selected, err = selectListRows(rows, "latest", 7*24*time.Hour, now)
+	assert.NoError(t, err)
+	assert.Equal(t, rows[2:], selected)
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, writeListRows(out, selected, "csv"))
+	assert.Equal(t, "name,location,storage,created,size,compressed_size,parts_count,required,upload_duration,description\nincrement,remote,s3,2024-01-09T23:00:00Z,0,0,0,base,1.500,\n", out.String())
+	assert.Error(t, writeListRows(out, selected, "xml"))
+}
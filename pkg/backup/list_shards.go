@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+)
+
+const shardMacro = "{shard}"
+
+// shardRemoteBackup - backup which stored in remote storage under path prefix for one shard
+type shardRemoteBackup struct {
+	Shard  string
+	Backup storage.Backup
+}
+
+func getRemoteStoragePath(cfg *config.Config) (*string, error) {
+	switch cfg.General.RemoteStorage {
+	case "s3":
+		return &cfg.S3.Path, nil
+	case "gcs":
+		return &cfg.GCS.Path, nil
+	case "azblob":
+		return &cfg.AzureBlob.Path, nil
+	case "cos":
+		return &cfg.COS.Path, nil
+	case "ftp":
+		return &cfg.FTP.Path, nil
+	case "sftp":
+		return &cfg.SFTP.Path, nil
+	case "rclone":
+		return &cfg.Rclone.Path, nil
+	case "swift":
+		return &cfg.Swift.Path, nil
+	case "oss":
+		return &cfg.OSS.Path, nil
+	case "obs":
+		return &cfg.OBS.Path, nil
+	}
+	return nil, fmt.Errorf("remote_storage: %s doesn't support --all-shards", cfg.General.RemoteStorage)
+}
+
+// listRemoteShards - find all shard prefixes which match `{shard}` macro in remote storage path and return path for each shard
+func (b *Backuper) listRemoteShards(ctx context.Context) (map[string]string, error) {
+	remotePath, err := getRemoteStoragePath(b.cfg)
+	if err != nil {
+		return nil, err
+	}
+	segments := strings.Split(*remotePath, "/")
+	shardSegmentIdx := -1
+	for i, segment := range segments {
+		if strings.Contains(segment, shardMacro) {
+			shardSegmentIdx = i
+			break
+		}
+	}
+	if shardSegmentIdx == -1 {
+		return nil, fmt.Errorf("--all-shards require %s macro in remote storage path, current path: %s", shardMacro, *remotePath)
+	}
+	shardSegmentRE := regexp.MustCompile("^" + strings.Replace(regexp.QuoteMeta(segments[shardSegmentIdx]), regexp.QuoteMeta(shardMacro), "(.+)", 1) + "$")
+	parentCfg := *b.cfg
+	parentPath, _ := getRemoteStoragePath(&parentCfg)
+	*parentPath = strings.Join(segments[:shardSegmentIdx], "/")
+	bd, err := storage.NewBackupDestination(ctx, &parentCfg, b.ch, false, "")
+	if err != nil {
+		return nil, err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	shards := make(map[string]string)
+	err = bd.Walk(ctx, "/", false, func(ctx context.Context, f storage.RemoteFile) error {
+		dirName := strings.Trim(f.Name(), "/")
+		if matches := shardSegmentRE.FindStringSubmatch(dirName); len(matches) == 2 {
+			shards[matches[1]] = path.Join(append([]string{*parentPath, dirName}, segments[shardSegmentIdx+1:]...)...)
+		}
+		return nil
+	})
+	return shards, err
+}
+
+// GetRemoteBackupsAllShards - get backups from all shards which share the same bucket with `{shard}` macro in remote storage path
+func (b *Backuper) GetRemoteBackupsAllShards(ctx context.Context) ([]shardRemoteBackup, error) {
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return nil, err
+		}
+		defer b.ch.Close()
+	}
+	shards, err := b.listRemoteShards(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]shardRemoteBackup, 0)
+	for shard, shardPath := range shards {
+		shardCfg := *b.cfg
+		remotePath, _ := getRemoteStoragePath(&shardCfg)
+		*remotePath = shardPath
+		bd, err := storage.NewBackupDestination(ctx, &shardCfg, b.ch, false, "")
+		if err != nil {
+			return nil, err
+		}
+		if err = bd.Connect(ctx); err != nil {
+			return nil, err
+		}
+		backupList, err := bd.BackupList(ctx, true, "")
+		if closeErr := bd.Close(ctx); closeErr != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", closeErr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't list backups for shard %s: %v", shard, err)
+		}
+		for _, backup := range backupList {
+			result = append(result, shardRemoteBackup{Shard: shard, Backup: backup})
+		}
+	}
+	return result, nil
+}
+
+// getLogicalBackupName - replace shard value in backup name to `{shard}`, to group backups created with names like `shard{shard}-full-{time}`
+func getLogicalBackupName(backupName, shard string) string {
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	for offset := 0; shard != ""; {
+		idx := strings.Index(backupName[offset:], shard)
+		if idx < 0 {
+			break
+		}
+		start, end := offset+idx, offset+idx+len(shard)
+		// shard `1` shall not match inside `10` or `2021`
+		if (start == 0 || !isDigit(backupName[start-1])) && (end == len(backupName) || !isDigit(backupName[end])) {
+			return backupName[:start] + shardMacro + backupName[end:]
+		}
+		offset = start + 1
+	}
+	return backupName
+}
+
+// PrintRemoteBackupsAllShards - print consolidated view of backups for all shards, show shards where backup is missing
+func (b *Backuper) PrintRemoteBackupsAllShards(ctx context.Context) error {
+	backups, err := b.GetRemoteBackupsAllShards(ctx)
+	if err != nil {
+		return err
+	}
+	log := b.log.WithField("logger", "PrintRemoteBackupsAllShards")
+	shards := make([]string, 0)
+	knownShards := make(map[string]struct{})
+	logicalNames := make([]string, 0)
+	backupsByName := make(map[string]map[string]storage.Backup)
+	for _, shardBackup := range backups {
+		logicalName := getLogicalBackupName(shardBackup.Backup.BackupName, shardBackup.Shard)
+		if _, exists := backupsByName[logicalName]; !exists {
+			backupsByName[logicalName] = make(map[string]storage.Backup)
+			logicalNames = append(logicalNames, logicalName)
+		}
+		backupsByName[logicalName][shardBackup.Shard] = shardBackup.Backup
+		if _, exists := knownShards[shardBackup.Shard]; !exists {
+			knownShards[shardBackup.Shard] = struct{}{}
+			shards = append(shards, shardBackup.Shard)
+		}
+	}
+	sort.Strings(shards)
+	sort.Strings(logicalNames)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	defer func() {
+		if err := w.Flush(); err != nil {
+			log.Errorf("can't flush tabular writer error: %v", err)
+		}
+	}()
+	for _, logicalName := range logicalNames {
+		for _, shard := range shards {
+			name, size, uploadDate, status := "", "???", "", "missing"
+			if backup, exists := backupsByName[logicalName][shard]; exists {
+				name = backup.BackupName
+				size = utils.FormatBytes(backup.DataSize + backup.MetadataSize)
+				if backup.CompressedSize > 0 {
+					size = utils.FormatBytes(backup.CompressedSize + backup.MetadataSize)
+				}
+				uploadDate = backup.UploadDate.Format("02/01/2006 15:04:05")
+				status = "ok"
+				if backup.Broken != "" {
+					status = backup.Broken
+					size = "???"
+				}
+			}
+			if bytes, err := fmt.Fprintf(w, "%s\tshard=%s\t%s\t%s\t%s\t%s\n", logicalName, shard, name, size, uploadDate, status); err != nil {
+				log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLogicalBackupName(t *testing.T) {
+	assert.Equal(t, "shard{shard}-full-2024", getLogicalBackupName("shard1-full-2024", "1"))
+	assert.Equal(t, "shard10-full-{shard}", getLogicalBackupName("shard10-full-1", "1"))
+	assert.Equal(t, "{shard}-full", getLogicalBackupName("s1-full", "s1"))
+	assert.Equal(t, "full-2021", getLogicalBackupName("full-2021", "2"))
+}
@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+// collectPartsChecksums - fill Checksum with CRC64 of checksums.txt for each part in `shadow/db/table/disk`, checksums.txt is written by clickhouse-server and contains checksums of all part files
+func collectPartsChecksums(tableDiskPath string, parts []metadata.Part) error {
+	for i := range parts {
+		checksum, err := calculateFileCRC64(path.Join(tableDiskPath, parts[i].Name, "checksums.txt"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("can't calculate checksum for %s: %v", path.Join(tableDiskPath, parts[i].Name), err)
+		}
+		parts[i].Checksum = checksum
+	}
+	return nil
+}
+
+// validatePartsChecksums - compare checksums.txt of local backup parts with Checksum from manifest before attach, parts from manifest v1 don't have Checksum and skipped
+func (b *Backuper) validatePartsChecksums(backupName string, table metadata.TableMetadata, disks []clickhouse.Disk, diskTypes map[string]string) error {
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	for disk, parts := range table.Parts {
+		if diskTypes[disk] == "s3" || diskTypes[disk] == "azure_blob_storage" {
+			continue
+		}
+		for _, part := range parts {
+			if part.Checksum == "" {
+				continue
+			}
+			partPath, found := localBackupPartPath(disks, backupName, disk, dbAndTablePath, part.Name)
+			if !found {
+				continue
+			}
+			checksum, err := calculateFileCRC64(path.Join(partPath, "checksums.txt"))
+			if err != nil {
+				return fmt.Errorf("can't read checksums.txt of part %s in `%s`.`%s`: %v", part.Name, table.Database, table.Table, err)
+			}
+			if checksum != part.Checksum {
+				return fmt.Errorf("part %s in `%s`.`%s` checksum mismatch, expected %s, actual %s", part.Name, table.Database, table.Table, part.Checksum, checksum)
+			}
+		}
+	}
+	return nil
+}
+
+// getCompressionMetadata - nil for `directory` and `chunks` data formats, archives of each table could use level from general->compression_level_by_table_size
+func (b *Backuper) getCompressionMetadata(dataFormat string) *metadata.CompressionMetadata {
+	if dataFormat == DirectoryFormat || dataFormat == ChunksFormat {
+		return nil
+	}
+	compression := &metadata.CompressionMetadata{Format: dataFormat, Level: b.dst.CompressionLevel()}
+	if dataFormat == "zstd" {
+		compression.ZstdWindowLog = b.cfg.General.ZstdWindowLog
+	}
+	return compression
+}
+
+// getEncryptionMetadata - server side encryption configured for general->remote_storage, nil when objects are not encrypted
+func (b *Backuper) getEncryptionMetadata() *metadata.EncryptionMetadata {
+	switch b.cfg.General.RemoteStorage {
+	case "s3":
+		if b.cfg.S3.SSECustomerKey != "" {
+			return &metadata.EncryptionMetadata{Type: "sse-c"}
+		}
+		if b.cfg.S3.SSE == "aws:kms" {
+			return &metadata.EncryptionMetadata{Type: "sse-kms", KeyID: b.cfg.S3.SSEKMSKeyId}
+		}
+		if b.cfg.S3.SSE != "" {
+			return &metadata.EncryptionMetadata{Type: "sse-s3"}
+		}
+	case "gcs":
+		if b.cfg.GCS.KMSKeyName != "" {
+			return &metadata.EncryptionMetadata{Type: "gcs-cmek", KeyID: b.cfg.GCS.KMSKeyName}
+		}
+		if b.cfg.GCS.EncryptionKey != "" {
+			return &metadata.EncryptionMetadata{Type: "gcs-csek"}
+		}
+	case "azblob":
+		if b.cfg.AzureBlob.SSEKey != "" {
+			return &metadata.EncryptionMetadata{Type: "sse-c"}
+		}
+	case "oss", "obs":
+		sse, keyID := b.cfg.OSS.SSE, b.cfg.OSS.SSEKMSKeyID
+		if b.cfg.General.RemoteStorage == "obs" {
+			sse, keyID = b.cfg.OBS.SSE, b.cfg.OBS.SSEKMSKeyID
+		}
+		if strings.Contains(strings.ToLower(sse), "kms") {
+			return &metadata.EncryptionMetadata{Type: "sse-kms", KeyID: keyID}
+		}
+		if sse != "" {
+			return &metadata.EncryptionMetadata{Type: "sse-s3"}
+		}
+	}
+	return nil
+}
+
+// getIncrementalChain - requiredBackup and its own chain, manifest v1 parents contain only required_backup, so chain could be shorter than real one
+func getIncrementalChain(requiredBackup string, requiredMetadata *metadata.BackupMetadata) []string {
+	chain := []string{requiredBackup}
+	for _, parent := range requiredMetadata.IncrementalChain {
+		if parent == requiredBackup {
+			continue
+		}
+		chain = append(chain, parent)
+	}
+	return chain
+}
@@ -0,0 +1,28 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectPartsChecksums(t *testing.T) {
+	tableDiskPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(tableDiskPath, "all_1_1_0"), 0750))
+	require.NoError(t, os.WriteFile(path.Join(tableDiskPath, "all_1_1_0", "checksums.txt"), []byte("checksums format version: 4"), 0640))
+	require.NoError(t, os.MkdirAll(path.Join(tableDiskPath, "all_2_2_0"), 0750))
+	parts := []metadata.Part{{Name: "all_1_1_0"}, {Name: "all_2_2_0"}}
+	require.NoError(t, collectPartsChecksums(tableDiskPath, parts))
+	assert.NotEmpty(t, parts[0].Checksum)
+	assert.Empty(t, parts[1].Checksum)
+}
+
+func TestGetIncrementalChain(t *testing.T) {
+	assert.Equal(t, []string{"full"}, getIncrementalChain("full", &metadata.BackupMetadata{}))
+	assert.Equal(t, []string{"increment2", "increment1", "full"}, getIncrementalChain("increment2", &metadata.BackupMetadata{IncrementalChain: []string{"increment1", "full"}}))
+}
@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	apexLog "github.com/apex/log"
+)
+
+// materializedPasswordPlaceholder - replaces password in engine arguments of MaterializedMySQL and MaterializedPostgreSQL database DDL, to avoid keep credentials in backup metadata
+const materializedPasswordPlaceholder = "{materialized_password}"
+
+// materializedCredentialsRE - Materialized*('host:port', 'database', 'user', 'password' ...), password is 4th argument, clickhouse-server 23.x+ returns '[HIDDEN]' in SHOW CREATE DATABASE
+var materializedCredentialsRE = regexp.MustCompile(`(Materiali[sz]ed?(?:MySQL|PostgreSQL)\s*\(\s*(?:'(?:[^'\\]|\\.)*'\s*,\s*){3})'(?:[^'\\]|\\.)*'`)
+
+// maskMaterializedCredentials - named collections don't contain password in DDL and leave query as is
+func maskMaterializedCredentials(query string) string {
+	return materializedCredentialsRE.ReplaceAllString(query, "${1}'"+materializedPasswordPlaceholder+"'")
+}
+
+// restoreMaterializedCredentials - replace placeholder with password from clickhouse->materialized_database_passwords for source database name
+func (b *Backuper) restoreMaterializedCredentials(databaseName, query string) (string, error) {
+	if !strings.Contains(query, materializedPasswordPlaceholder) {
+		return query, nil
+	}
+	password, exists := b.cfg.ClickHouse.MaterializedDatabasePasswords[databaseName]
+	if !exists {
+		return "", fmt.Errorf("database %s was created with password which is not stored in backup, add it to `clickhouse->materialized_database_passwords` or use --materialize-external", databaseName)
+	}
+	password = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(password)
+	return strings.Replace(query, materializedPasswordPlaceholder, password, 1), nil
+}
+
+// isMaterializedDatabaseEngine - databases which replicate data from external MySQL or PostgreSQL and manage tables themselves, MaterializeMySQL is name before 21.9
+func isMaterializedDatabaseEngine(engine string) bool {
+	return engine == "MaterializedMySQL" || engine == "MaterializeMySQL" || engine == "MaterializedPostgreSQL"
+}
+
+// freezeMaterializedTable - tables inside MaterializedPostgreSQL don't support FREEZE, https://github.com/ClickHouse/ClickHouse/issues/32902,
+// so hard links for active parts are created in the same shadow layout like FREEZE does, merges are stopped to avoid parts disappear during linking,
+// parts removed by replication after select are skipped
+func (b *Backuper) freezeMaterializedTable(ctx context.Context, table *clickhouse.Table, shadowBackupUUID string, disks []clickhouse.Disk, log *apexLog.Entry) error {
+	tableName := fmt.Sprintf("`%s`.`%s`", table.Database, table.Name)
+	if err := b.ch.QueryContext(ctx, "SYSTEM STOP MERGES "+tableName); err != nil {
+		log.Warnf("can't stop merges: %v", err)
+	} else {
+		defer func() {
+			if err := b.ch.QueryContext(ctx, "SYSTEM START MERGES "+tableName); err != nil {
+				log.Warnf("can't start merges: %v", err)
+			}
+		}()
+	}
+	parts := make([]struct {
+		Name     string `ch:"name"`
+		Path     string `ch:"path"`
+		DiskName string `ch:"disk_name"`
+	}, 0)
+	if err := b.ch.SelectContext(ctx, &parts, "SELECT name, path, disk_name FROM system.parts WHERE active AND database=? AND table=?", table.Database, table.Name); err != nil {
+		return fmt.Errorf("can't get parts for %s: %v", tableName, err)
+	}
+	diskPaths := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		diskPaths[disk.Name] = disk.Path
+	}
+	linkedParts := 0
+	for _, part := range parts {
+		diskPath, exists := diskPaths[part.DiskName]
+		if !exists {
+			return fmt.Errorf("disk %s for part %s of %s not found", part.DiskName, part.Name, tableName)
+		}
+		shadowPartPath := path.Join(diskPath, "shadow", shadowBackupUUID, "data", common.TablePathEncode(table.Database), common.TablePathEncode(table.Name), part.Name)
+		partPath := strings.TrimSuffix(part.Path, "/")
+		if err := filepath.Walk(partPath, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			dstPath := path.Join(shadowPartPath, filesystemhelper.RelativePath(partPath, filePath))
+			if info.IsDir() {
+				return filesystemhelper.MkdirAll(dstPath, b.ch, disks)
+			}
+			return os.Link(filePath, dstPath)
+		}); err != nil {
+			// replication doesn't stop during backup, replicated DDL like TRUNCATE or DROP PARTITION could remove part after it was selected, its data is not present in table anymore
+			if errors.Is(err, os.ErrNotExist) {
+				log.Warnf("part %s of %s removed by replication during backup, skip it", part.Name, tableName)
+				if err = os.RemoveAll(shadowPartPath); err != nil {
+					return fmt.Errorf("can't remove %s: %v", shadowPartPath, err)
+				}
+				continue
+			}
+			return fmt.Errorf("can't create hard links for part %s of %s: %v", part.Name, tableName, err)
+		}
+		linkedParts += 1
+	}
+	log.Debugf("%d parts linked without FREEZE", linkedParts)
+	return nil
+}
+
+// filterMaterializedTables - tables inside MaterializedMySQL and MaterializedPostgreSQL databases are created by database engine itself after restore and replication fill data from source,
+// with --materialize-external databases restore with default engine and tables restore as plain ReplacingMergeTree with data from backup
+// returns how much tables skipped, to avoid "no have found schemas" error when backup contains only materialized databases
+func (b *Backuper) filterMaterializedTables(tablesForRestore ListOfTables, log *apexLog.Entry) (ListOfTables, int, error) {
+	if len(b.materializedDatabases) == 0 {
+		return tablesForRestore, 0, nil
+	}
+	filteredTables := make(ListOfTables, 0, len(tablesForRestore))
+	skippedTables := 0
+	for _, table := range tablesForRestore {
+		engine, isMaterialized := b.materializedDatabases[table.Database]
+		if !isMaterialized {
+			filteredTables = append(filteredTables, table)
+			continue
+		}
+		if !b.materializeExternal {
+			log.Infof("skip %s.%s, %s database engine will create it and replicate data from source", table.Database, table.Table, engine)
+			skippedTables += 1
+			continue
+		}
+		if !strings.Contains(table.Query, "MergeTree") {
+			return nil, skippedTables, fmt.Errorf("can't materialize %s.%s from %s database, table query doesn't contain MergeTree engine: %s", table.Database, table.Table, engine, table.Query)
+		}
+		filteredTables = append(filteredTables, table)
+	}
+	return filteredTables, skippedTables, nil
+}
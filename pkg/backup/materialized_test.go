@@ -0,0 +1,26 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaterializedCredentials(t *testing.T) {
+	testCases := map[string]string{
+		"CREATE DATABASE mysql_db ENGINE = MaterializedMySQL('mysql:3306', 'db', 'root', 'se\\'cret')":                  "CREATE DATABASE mysql_db ENGINE = MaterializedMySQL('mysql:3306', 'db', 'root', '{materialized_password}')",
+		"CREATE DATABASE pg_db ENGINE = MaterializedPostgreSQL('pg:5432', 'db', 'postgres', '[HIDDEN]') SETTINGS a = 1": "CREATE DATABASE pg_db ENGINE = MaterializedPostgreSQL('pg:5432', 'db', 'postgres', '{materialized_password}') SETTINGS a = 1",
+		"CREATE DATABASE named_db ENGINE = MaterializedMySQL(mysql_collection)":                                         "CREATE DATABASE named_db ENGINE = MaterializedMySQL(mysql_collection)",
+	}
+	for query, expected := range testCases {
+		assert.Equal(t, expected, maskMaterializedCredentials(query))
+	}
+
+	b := &Backuper{cfg: &config.Config{ClickHouse: config.ClickHouseConfig{MaterializedDatabasePasswords: map[string]string{"mysql_db": "new'pass"}}}}
+	query, err := b.restoreMaterializedCredentials("mysql_db", maskMaterializedCredentials("CREATE DATABASE mysql_db ENGINE = MaterializedMySQL('mysql:3306', 'db', 'root', 'old')"))
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE DATABASE mysql_db ENGINE = MaterializedMySQL('mysql:3306', 'db', 'root', 'new\\'pass')", query)
+	_, err = b.restoreMaterializedCredentials("pg_db", "CREATE DATABASE pg_db ENGINE = MaterializedPostgreSQL('pg:5432', 'db', 'postgres', '{materialized_password}')")
+	assert.Error(t, err)
+}
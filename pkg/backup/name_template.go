@@ -0,0 +1,227 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+)
+
+var backupNameTemplateTimeRE = regexp.MustCompile(`{(?:date)?time:([^}]+)}`)
+
+// applyBackupNameTemplate - {macro_name} from system.macros, {cluster}, {shard}, {replica} from system.clusters when not defined in macros,
+// {time:layout} and {datetime:layout} replaced to current UTC time in Go layout format
+func (b *Backuper) applyBackupNameTemplate(ctx context.Context, template string, now time.Time) (string, error) {
+	backupName, err := b.ch.ApplyMacros(ctx, template)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(backupName, "{cluster}") || strings.Contains(backupName, "{shard}") || strings.Contains(backupName, "{replica}") {
+		replica, err := b.ch.GetLocalReplica(ctx)
+		if err != nil {
+			return "", err
+		}
+		// keep as is when current server is not present in system.clusters, watch_backup_name_template allow it
+		if replica != nil {
+			backupName = strings.NewReplacer(
+				"{cluster}", replica.Cluster,
+				"{shard}", strconv.FormatUint(uint64(replica.ShardNum), 10),
+				"{replica}", strconv.FormatUint(uint64(replica.ReplicaNum), 10),
+			).Replace(backupName)
+		}
+	}
+	backupName = backupNameTemplateTimeRE.ReplaceAllStringFunc(backupName, func(templateItem string) string {
+		return now.UTC().Format(backupNameTemplateTimeRE.FindStringSubmatch(templateItem)[1])
+	})
+	return backupName, nil
+}
+
+// ResolveBackupName - name for new backup, empty name replaced to general->backup_name_template or to current time, name with {...} resolved the same way
+// as backup_name_template, already created backups shall be resolved with ResolveExistingBackupName
+func (b *Backuper) ResolveBackupName(ctx context.Context, backupName string) (string, error) {
+	if backupName == "" {
+		if b.cfg.General.BackupNameTemplate == "" {
+			return NewBackupName(), nil
+		}
+		backupName = b.cfg.General.BackupNameTemplate
+	}
+	if !strings.Contains(backupName, "{") {
+		return utils.CleanBackupNameRE.ReplaceAllString(backupName, ""), nil
+	}
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return "", fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		defer b.ch.Close()
+	}
+	resolvedName, err := b.applyBackupNameTemplate(ctx, backupName, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("can't resolve backup name `%s`: %v", backupName, err)
+	}
+	resolvedName = utils.CleanBackupNameRE.ReplaceAllString(resolvedName, "")
+	if strings.ContainsAny(resolvedName, "{}") {
+		return "", fmt.Errorf("backup name `%s` contains unresolved macros after apply template `%s`", resolvedName, backupName)
+	}
+	return resolvedName, nil
+}
+
+// WithNameTemplateFilter - `list --name-template`, show only backups which names matched general->backup_name_template on current server
+func WithNameTemplateFilter(enabled bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.nameTemplateFilter = enabled
+	}
+}
+
+// ResolveExistingBackupName - resolve name of already created `local` or `remote` backup, or of `cluster` backup created by `create_remote --on-cluster`,
+// {time:layout} and {datetime:layout} can't be resolved from current time, so the latest backup which name matched template is used
+func (b *Backuper) ResolveExistingBackupName(ctx context.Context, location, backupName string) (string, error) {
+	if !backupNameTemplateTimeRE.MatchString(backupName) {
+		return b.ResolveBackupName(ctx, backupName)
+	}
+	creationDates := make(map[string]time.Time)
+	switch location {
+	case "local":
+		backupList, _, err := b.GetLocalBackups(ctx, nil)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		for _, backup := range backupList {
+			creationDates[backup.BackupName] = backup.CreationDate
+		}
+	case "remote":
+		backupList, err := b.GetRemoteBackups(ctx, true)
+		if err != nil {
+			return "", err
+		}
+		for _, backup := range backupList {
+			creationDates[backup.BackupName] = backup.CreationDate
+		}
+	case "cluster":
+		manifests, err := b.getClusterManifests(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, manifest := range manifests {
+			creationDates[manifest.BackupName] = manifest.CreationDate
+		}
+	default:
+		return "", fmt.Errorf("unknown backup location `%s`", location)
+	}
+	filterRE, err := b.backupNameTemplateFilter(ctx, backupName)
+	if err != nil {
+		return "", fmt.Errorf("can't resolve backup name `%s`: %v", backupName, err)
+	}
+	resolvedName := getLatestMatchedBackupName(filterRE, creationDates)
+	if resolvedName == "" {
+		return "", fmt.Errorf("%s backup which matched `%s` not found", location, backupName)
+	}
+	return resolvedName, nil
+}
+
+// getLatestMatchedBackupName - name with the latest creation date, backups created at the same time ordered by name, empty string when nothing matched
+func getLatestMatchedBackupName(filterRE *regexp.Regexp, creationDates map[string]time.Time) string {
+	resolvedName := ""
+	for name, creationDate := range creationDates {
+		if !filterRE.MatchString(name) {
+			continue
+		}
+		if resolvedName == "" || creationDate.After(creationDates[resolvedName]) || (creationDate.Equal(creationDates[resolvedName]) && name > resolvedName) {
+			resolvedName = name
+		}
+	}
+	return resolvedName
+}
+
+// getClusterManifests - manifests uploaded by `create_remote --on-cluster`
+func (b *Backuper) getClusterManifests(ctx context.Context) ([]metadata.ClusterBackupMetadata, error) {
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		defer b.ch.Close()
+	}
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+	if err != nil {
+		return nil, err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("can't connect to %s: %v", bd.Kind(), err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	return bd.GetClusterManifests(ctx)
+}
+
+// backupNameTemplateFilter - regexp which matched all backups created with template on current server, {time:layout} and {datetime:layout} matched any value
+func (b *Backuper) backupNameTemplateFilter(ctx context.Context, template string) (*regexp.Regexp, error) {
+	const timePlaceholder = "\x00"
+	template = backupNameTemplateTimeRE.ReplaceAllString(template, timePlaceholder)
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		defer b.ch.Close()
+	}
+	resolved, err := b.applyBackupNameTemplate(ctx, template, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	resolved = utils.CleanBackupNameRE.ReplaceAllString(resolved, "")
+	parts := strings.Split(resolved, timePlaceholder)
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".+") + "$")
+}
+
+// filterLocalBackupsByNameTemplate - `list --name-template` shows only backups created by general->backup_name_template on current server,
+// so `list local latest --name-template` is safe for sharded cluster with shared remote storage path, without flag all backups are shown
+func (b *Backuper) filterLocalBackupsByNameTemplate(ctx context.Context, backupList []LocalBackup) ([]LocalBackup, error) {
+	if !b.nameTemplateFilter {
+		return backupList, nil
+	}
+	if b.cfg.General.BackupNameTemplate == "" {
+		return nil, fmt.Errorf("--name-template requires general->backup_name_template")
+	}
+	filterRE, err := b.backupNameTemplateFilter(ctx, b.cfg.General.BackupNameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]LocalBackup, 0, len(backupList))
+	for _, backup := range backupList {
+		if filterRE.MatchString(backup.BackupName) {
+			filtered = append(filtered, backup)
+		}
+	}
+	return filtered, nil
+}
+
+func (b *Backuper) filterRemoteBackupsByNameTemplate(ctx context.Context, backupList []storage.Backup) ([]storage.Backup, error) {
+	if !b.nameTemplateFilter {
+		return backupList, nil
+	}
+	if b.cfg.General.BackupNameTemplate == "" {
+		return nil, fmt.Errorf("--name-template requires general->backup_name_template")
+	}
+	filterRE, err := b.backupNameTemplateFilter(ctx, b.cfg.General.BackupNameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]storage.Backup, 0, len(backupList))
+	for _, backup := range backupList {
+		if filterRE.MatchString(backup.BackupName) {
+			filtered = append(filtered, backup)
+		}
+	}
+	return filtered, nil
+}
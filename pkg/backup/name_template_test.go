@@ -0,0 +1,42 @@
+package backup
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLatestMatchedBackupName(t *testing.T) {
+	filterRE := regexp.MustCompile("^shard1-.+$")
+	day := time.Date(2024, 1, 2, 23, 59, 0, 0, time.UTC)
+	creationDates := map[string]time.Time{
+		"shard1-2024-01-01": day.Add(-24 * time.Hour),
+		"shard1-2024-01-02": day,
+		"shard2-2024-01-03": day.Add(24 * time.Hour),
+		"manual":            day.Add(48 * time.Hour),
+	}
+	assert.Equal(t, "shard1-2024-01-02", getLatestMatchedBackupName(filterRE, creationDates), "creation date shall be used instead of current time")
+	assert.Empty(t, getLatestMatchedBackupName(regexp.MustCompile("^shard3-.+$"), creationDates))
+}
+
+func TestFilterBackupsByNameTemplate(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.BackupNameTemplate = "{shard}-{time:20060102}"
+	b := &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test")}
+	backupList := []LocalBackup{{}, {}}
+	filtered, err := b.filterLocalBackupsByNameTemplate(context.Background(), backupList)
+	require.NoError(t, err)
+	assert.Len(t, filtered, 2, "list shall not filter backups without --name-template")
+
+	b.nameTemplateFilter = true
+	b.cfg.General.BackupNameTemplate = ""
+	_, err = b.filterRemoteBackupsByNameTemplate(context.Background(), nil)
+	assert.ErrorContains(t, err, "backup_name_template")
+}
@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/notify"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+)
+
+// notifyOperation - send `start` notification, returned function shall be deferred with named error result to send `success` or `failure`,
+// nested operations, like download of required backup in incremental chain, don't send own notifications
+func (b *Backuper) notifyOperation(operation, backupName string) func(err error) {
+	if b.dryRun || b.notifying {
+		return func(error) {}
+	}
+	b.notifying = true
+	start := time.Now()
+	b.notifier.Send(context.Background(), notify.Event{Event: notify.EventStart, Operation: operation, Backup: backupName})
+	return func(err error) {
+		b.notifying = false
+		event := notify.Event{
+			Event:     notify.EventSuccess,
+			Operation: operation,
+			Backup:    backupName,
+			Duration:  utils.HumanizeDuration(time.Since(start)),
+		}
+		if err != nil {
+			event.Event = notify.EventFailure
+			event.Error = err.Error()
+		}
+		b.notifier.Send(context.Background(), event)
+	}
+}
+
+// notifyRetentionDelete - send `retention_delete` notification for each backup deleted by backups_to_keep_local or remote retention policy
+func (b *Backuper) notifyRetentionDelete(location string, backupNames []string) {
+	for _, backupName := range backupNames {
+		b.notifier.Send(context.Background(), notify.Event{Event: notify.EventRetentionDelete, Operation: "retention", Backup: backupName, Location: location})
+	}
+}
+
+func getBackupNames(backups []storage.Backup) []string {
+	names := make([]string, len(backups))
+	for i := range backups {
+		names[i] = backups[i].BackupName
+	}
+	return names
+}
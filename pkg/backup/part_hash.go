@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/sync/errgroup"
+	"lukechampine.com/blake3"
+)
+
+// newPartHash - hash for general->part_hash_algorithm, empty algorithm means part hashes are disabled
+func newPartHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "xxhash64":
+		return xxhash.New(), nil
+	case "blake3":
+		return blake3.New(32, nil), nil
+	case "sha256":
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unknown part hash algorithm: '%s'", algorithm)
+}
+
+// calculatePartHash - hash of relative path and content of each file inside part directory in lexical order, projections included
+func calculatePartHash(algorithm, partPath string) (string, error) {
+	h, err := newPartHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	err = filepath.WalkDir(partPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(partPath, filePath)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(h, filepath.ToSlash(relativePath)+"\x00"); err != nil {
+			return err
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(h, f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// collectPartsHashes - fill Hash for each part in parts with general->part_hash_concurrency workers, parts are read from local disk only once after FREEZE
+func (b *Backuper) collectPartsHashes(ctx context.Context, tableDiskPath string, parts []metadata.Part) error {
+	if b.cfg.General.PartHashAlgorithm == "" {
+		return nil
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(b.cfg.General.PartHashConcurrency)
+	for i := range parts {
+		idx := i
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			partHash, err := calculatePartHash(b.cfg.General.PartHashAlgorithm, path.Join(tableDiskPath, parts[idx].Name))
+			if err != nil {
+				return fmt.Errorf("can't calculate %s hash for %s: %v", b.cfg.General.PartHashAlgorithm, path.Join(tableDiskPath, parts[idx].Name), err)
+			}
+			parts[idx].Hash = partHash
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// verifyPartsHashes - detect local corruption of backup before upload, parts without Hash, like parts from `--diff-from` or object disks, are skipped,
+// each part is read one more time, so upload of backup created with general->part_hash_algorithm reads local data twice
+func (b *Backuper) verifyPartsHashes(ctx context.Context, backupName string, table metadata.TableMetadata) error {
+	algorithm := table.PartHashAlgorithm
+	if algorithm == "" {
+		return nil
+	}
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(b.cfg.General.PartHashConcurrency)
+	for disk, parts := range table.Parts {
+		backupPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
+		for _, part := range parts {
+			if part.Hash == "" || part.Required {
+				continue
+			}
+			partName := part.Name
+			expectedHash := part.Hash
+			g.Go(func() error {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				actualHash, err := calculatePartHash(algorithm, path.Join(backupPath, partName))
+				if err != nil {
+					return fmt.Errorf("can't calculate %s hash for part %s in `%s`.`%s`: %v", algorithm, partName, table.Database, table.Table, err)
+				}
+				if actualHash != expectedHash {
+					return fmt.Errorf("part %s in `%s`.`%s` is corrupted in local backup %s, %s hash mismatch, expected %s, actual %s", partName, table.Database, table.Table, backupName, algorithm, expectedHash, actualHash)
+				}
+				return nil
+			})
+		}
+	}
+	return g.Wait()
+}
@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartsHashes(t *testing.T) {
+	diskPath := t.TempDir()
+	tablePath := path.Join(diskPath, "backup/my_backup/shadow/db/table/default")
+	for _, partName := range []string{"all_1_1_0", "all_2_2_0"} {
+		require.NoError(t, os.MkdirAll(path.Join(tablePath, partName, "p1.proj"), 0750))
+		require.NoError(t, os.WriteFile(path.Join(tablePath, partName, "data.bin"), []byte(partName), 0640))
+		require.NoError(t, os.WriteFile(path.Join(tablePath, partName, "p1.proj", "data.bin"), []byte("projection"), 0640))
+	}
+
+	for _, algorithm := range []string{"xxhash64", "blake3", "sha256"} {
+		t.Run(algorithm, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.General.PartHashAlgorithm = algorithm
+			b := &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test"), DiskToPathMap: map[string]string{"default": diskPath}}
+			parts := []metadata.Part{{Name: "all_1_1_0"}, {Name: "all_2_2_0"}}
+			require.NoError(t, b.collectPartsHashes(context.Background(), tablePath, parts))
+			require.NotEmpty(t, parts[0].Hash)
+			assert.NotEqual(t, parts[0].Hash, parts[1].Hash)
+
+			table := metadata.TableMetadata{Database: "db", Table: "table", PartHashAlgorithm: algorithm, Parts: map[string][]metadata.Part{"default": parts}}
+			require.NoError(t, b.verifyPartsHashes(context.Background(), "my_backup", table))
+
+			corruptedFile := path.Join(tablePath, "all_2_2_0", "p1.proj", "data.bin")
+			require.NoError(t, os.WriteFile(corruptedFile, []byte("corrupted"), 0640))
+			defer func() {
+				require.NoError(t, os.WriteFile(corruptedFile, []byte("projection"), 0640))
+			}()
+			err := b.verifyPartsHashes(context.Background(), "my_backup", table)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "part all_2_2_0 in `db`.`table` is corrupted")
+		})
+	}
+}
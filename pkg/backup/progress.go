@@ -0,0 +1,36 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+)
+
+// withTableProgress - storage reports bytes streamed for table into command progress with current table and part,
+// returned func removes streamed bytes, call it before status.Current.AddProgress with table TotalBytes when table finished
+func withTableProgress(ctx context.Context, commandId int, table metadata.TableMetadata) (context.Context, func()) {
+	tableName := fmt.Sprintf("%s.%s", table.Database, table.Table)
+	var streamed int64
+	ctx = storage.WithProgress(ctx, func(remotePath string, bytes int64) {
+		atomic.AddInt64(&streamed, bytes)
+		status.Current.AddStreamedBytes(commandId, tableName, progressPartName(remotePath), bytes)
+	})
+	return ctx, func() {
+		status.Current.AddStreamedBytes(commandId, "", "", -atomic.LoadInt64(&streamed))
+	}
+}
+
+// progressPartName - `<disk>_<part>.tar.zstd` archive or part directory from remote path of file
+func progressPartName(remotePath string) string {
+	name := path.Base(remotePath)
+	if i := strings.Index(name, ".tar"); i > 0 {
+		return name[:i]
+	}
+	return name
+}
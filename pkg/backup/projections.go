@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+
+	apexLog "github.com/apex/log"
+)
+
+const (
+	ProjectionsRestore = "restore"
+	ProjectionsDrop    = "drop"
+	ProjectionsRebuild = "rebuild"
+)
+
+// WithProjectionsMode - `restore` (default) create tables with projections from backup, `drop` remove PROJECTION clauses from DDL for target servers
+// which don't support projections, `rebuild` remove PROJECTION clauses during schema restore, then ADD and MATERIALIZE them after data restore
+func WithProjectionsMode(mode string) BackuperOpt {
+	return func(b *Backuper) {
+		b.projectionsMode = mode
+	}
+}
+
+func ValidateProjectionsMode(mode string) error {
+	switch mode {
+	case "", ProjectionsRestore, ProjectionsDrop, ProjectionsRebuild:
+		return nil
+	}
+	return fmt.Errorf("unsupported --projections=%s, shall be one of %s, %s, %s", mode, ProjectionsRestore, ProjectionsDrop, ProjectionsRebuild)
+}
+
+// lightweightDeleteMaskFiles - `_row_exists` column of wide parts created by `DELETE FROM`, `deleted_rows_mask.bin` created by experimental lightweight delete in 22.x
+var lightweightDeleteMaskFiles = []string{"_row_exists.bin", "deleted_rows_mask.bin"}
+
+// hasLightweightDeleteMask - compact parts store `_row_exists` inside data.bin, so columns.txt checked also
+func hasLightweightDeleteMask(partPath string) bool {
+	for _, maskFile := range lightweightDeleteMaskFiles {
+		if _, err := os.Stat(path.Join(partPath, maskFile)); err == nil {
+			return true
+		}
+	}
+	columns, err := os.ReadFile(path.Join(partPath, "columns.txt"))
+	return err == nil && strings.Contains(string(columns), "`_row_exists`")
+}
+
+// collectPartsProjections - fill Projections with checksum of `<projection>.proj/checksums.txt` and LightweightDelete for each part in `shadow/db/table/disk`,
+// projection checksums.txt contains checksums of all projection files, so it is enough to detect lost or replaced projection during restore
+func collectPartsProjections(tableDiskPath string, parts []metadata.Part) error {
+	for i := range parts {
+		partPath := path.Join(tableDiskPath, parts[i].Name)
+		entries, err := os.ReadDir(partPath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".proj") {
+				continue
+			}
+			checksum, err := calculateFileCRC64(path.Join(partPath, entry.Name(), "checksums.txt"))
+			if err != nil {
+				return fmt.Errorf("can't calculate projection checksum for %s: %v", path.Join(partPath, entry.Name()), err)
+			}
+			if parts[i].Projections == nil {
+				parts[i].Projections = make(map[string]string)
+			}
+			parts[i].Projections[strings.TrimSuffix(entry.Name(), ".proj")] = checksum
+		}
+		parts[i].LightweightDelete = hasLightweightDeleteMask(partPath)
+	}
+	return nil
+}
+
+// localBackupPartPath - the same lookup as filesystemhelper.HardlinkBackupPartsToStorage, DiskToPathMap is not filled during `restore`
+func localBackupPartPath(disks []clickhouse.Disk, backupName, disk, dbAndTablePath, partName string) (string, bool) {
+	for _, d := range disks {
+		if d.Name != disk {
+			continue
+		}
+		partPath := path.Join(d.Path, "backup", backupName, "shadow", dbAndTablePath, disk, partName)
+		// Legacy backup support
+		if _, err := os.Stat(partPath); os.IsNotExist(err) {
+			partPath = path.Join(d.Path, "backup", backupName, "shadow", dbAndTablePath, partName)
+		}
+		return partPath, true
+	}
+	return "", false
+}
+
+// validatePartsProjections - check projections and lightweight delete masks of local backup parts before attach, object disk parts contain only object references and skipped
+func (b *Backuper) validatePartsProjections(backupName string, table metadata.TableMetadata, disks []clickhouse.Disk, diskTypes map[string]string) error {
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	for disk, parts := range table.Parts {
+		if diskTypes[disk] == "s3" || diskTypes[disk] == "azure_blob_storage" {
+			continue
+		}
+		for _, part := range parts {
+			partPath, found := localBackupPartPath(disks, backupName, disk, dbAndTablePath, part.Name)
+			if !found {
+				continue
+			}
+			for projection, expectedChecksum := range part.Projections {
+				checksum, err := calculateFileCRC64(path.Join(partPath, projection+".proj", "checksums.txt"))
+				if err != nil {
+					return fmt.Errorf("projection %s of part %s in `%s`.`%s` is lost: %v", projection, part.Name, table.Database, table.Table, err)
+				}
+				if checksum != expectedChecksum {
+					return fmt.Errorf("projection %s of part %s in `%s`.`%s` checksum mismatch, expected %s, actual %s", projection, part.Name, table.Database, table.Table, expectedChecksum, checksum)
+				}
+			}
+			if part.LightweightDelete && !hasLightweightDeleteMask(partPath) {
+				return fmt.Errorf("lightweight delete mask of part %s in `%s`.`%s` is lost, deleted rows will become visible after restore", part.Name, table.Database, table.Table)
+			}
+		}
+	}
+	return nil
+}
+
+var projectionDefinitionRE = regexp.MustCompile(`,\s*PROJECTION\s+(` + identifierPattern + `)\s*\(`)
+
+// removeProjectionsFromQuery - cut `, PROJECTION name (SELECT ...)` clauses from CREATE query, return removed definitions without leading comma
+func removeProjectionsFromQuery(query string) (string, []string) {
+	var projections []string
+	for {
+		match := projectionDefinitionRE.FindStringIndex(query)
+		if match == nil {
+			return query, projections
+		}
+		end := findClosingParenthesis(query, match[1]-1)
+		if end < 0 {
+			return query, projections
+		}
+		projections = append(projections, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(query[match[0]:end+1]), ",")))
+		query = query[:match[0]] + query[end+1:]
+	}
+}
+
+// applyProjectionsMode - `--projections=drop|rebuild`, tables which lose projections saved for rebuildProjections
+func (b *Backuper) applyProjectionsMode(tables ListOfTables, log *apexLog.Entry) {
+	if b.projectionsMode != ProjectionsDrop && b.projectionsMode != ProjectionsRebuild {
+		return
+	}
+	for i := range tables {
+		query, projections := removeProjectionsFromQuery(tables[i].Query)
+		if len(projections) == 0 {
+			continue
+		}
+		log.Infof("--projections=%s remove %d projections from `%s`.`%s`", b.projectionsMode, len(projections), tables[i].Database, tables[i].Table)
+		tables[i].Query = query
+		if b.projectionsMode == ProjectionsRebuild {
+			if b.projectionsForRebuild == nil {
+				b.projectionsForRebuild = make(map[metadata.TableTitle][]string)
+			}
+			b.projectionsForRebuild[metadata.TableTitle{Database: tables[i].Database, Table: tables[i].Table}] = projections
+		}
+	}
+}
+
+// rebuildProjections - ADD PROJECTION and MATERIALIZE PROJECTION for restored tables, MATERIALIZE executes as mutation in background
+func (b *Backuper) rebuildProjections(ctx context.Context, log *apexLog.Entry) error {
+	projectionsForRebuild := b.projectionsForRebuild
+	b.projectionsForRebuild = nil
+	for table, projections := range projectionsForRebuild {
+		for _, projection := range projections {
+			name := projectionDefinitionRE.FindStringSubmatch(", " + projection)[1]
+			if err := b.ch.QueryContext(ctx, fmt.Sprintf("ALTER TABLE `%s`.`%s` ADD %s", table.Database, table.Table, projection)); err != nil {
+				return fmt.Errorf("can't add projection %s to `%s`.`%s`: %v", name, table.Database, table.Table, err)
+			}
+			if err := b.ch.QueryContext(ctx, fmt.Sprintf("ALTER TABLE `%s`.`%s` MATERIALIZE PROJECTION %s", table.Database, table.Table, name)); err != nil {
+				return fmt.Errorf("can't materialize projection %s in `%s`.`%s`: %v", name, table.Database, table.Table, err)
+			}
+			log.Infof("projection %s in `%s`.`%s` rebuilt", name, table.Database, table.Table)
+		}
+	}
+	return nil
+}
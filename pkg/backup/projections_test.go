@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveProjectionsFromQuery(t *testing.T) {
+	query, projections := removeProjectionsFromQuery("CREATE TABLE db.t (id UInt64, s String, PROJECTION p_sum (SELECT s, sum(id) GROUP BY s), PROJECTION `p order` (SELECT * ORDER BY (s, id))) ENGINE = MergeTree ORDER BY id")
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64, s String) ENGINE = MergeTree ORDER BY id", query)
+	assert.Equal(t, []string{"PROJECTION p_sum (SELECT s, sum(id) GROUP BY s)", "PROJECTION `p order` (SELECT * ORDER BY (s, id))"}, projections)
+
+	original := "CREATE TABLE db.t (id UInt64, s String DEFAULT 'PROJECTION p (x)') ENGINE = MergeTree ORDER BY id"
+	query, projections = removeProjectionsFromQuery(original)
+	assert.Equal(t, original, query)
+	assert.Empty(t, projections)
+}
+
+func TestCollectAndValidatePartsProjections(t *testing.T) {
+	tableDiskPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(tableDiskPath, "all_1_1_0", "p_sum.proj"), 0750))
+	require.NoError(t, os.WriteFile(path.Join(tableDiskPath, "all_1_1_0", "p_sum.proj", "checksums.txt"), []byte("projection checksums"), 0640))
+	require.NoError(t, os.WriteFile(path.Join(tableDiskPath, "all_1_1_0", "_row_exists.bin"), []byte("mask"), 0640))
+	require.NoError(t, os.MkdirAll(path.Join(tableDiskPath, "all_2_2_0"), 0750))
+	require.NoError(t, os.WriteFile(path.Join(tableDiskPath, "all_2_2_0", "columns.txt"), []byte("columns format version: 1\n1 columns:\n`id` UInt64\n"), 0640))
+
+	parts := []metadata.Part{{Name: "all_1_1_0"}, {Name: "all_2_2_0"}}
+	require.NoError(t, collectPartsProjections(tableDiskPath, parts))
+	assert.Contains(t, parts[0].Projections, "p_sum")
+	assert.True(t, parts[0].LightweightDelete)
+	assert.Empty(t, parts[1].Projections)
+	assert.False(t, parts[1].LightweightDelete)
+
+	require.NoError(t, os.WriteFile(path.Join(tableDiskPath, "all_1_1_0", "p_sum.proj", "checksums.txt"), []byte("changed checksums"), 0640))
+	checksum, err := calculateFileCRC64(path.Join(tableDiskPath, "all_1_1_0", "p_sum.proj", "checksums.txt"))
+	require.NoError(t, err)
+	assert.NotEqual(t, parts[0].Projections["p_sum"], checksum)
+	require.NoError(t, os.Remove(path.Join(tableDiskPath, "all_1_1_0", "_row_exists.bin")))
+	assert.False(t, hasLightweightDeleteMask(path.Join(tableDiskPath, "all_1_1_0")))
+}
+
+func TestValidateProjectionsMode(t *testing.T) {
+	assert.NoError(t, ValidateProjectionsMode(""))
+	assert.NoError(t, ValidateProjectionsMode(ProjectionsRebuild))
+	assert.Error(t, ValidateProjectionsMode("materialize"))
+}
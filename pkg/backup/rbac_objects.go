@@ -0,0 +1,228 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+)
+
+// rbacObjectsFile - stored inside backup_name/access, so upload and download works the same way as for access files
+const rbacObjectsFile = "rbac_objects.json"
+
+// rbacObject - access entity or named collection with CREATE and GRANT statements, allow restore via SQL without restart clickhouse-server
+type rbacObject struct {
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	Database string   `json:"database,omitempty"`
+	Table    string   `json:"table,omitempty"`
+	Create   string   `json:"create"`
+	Grants   []string `json:"grants,omitempty"`
+}
+
+// rbacObjectKinds - ordered by dependencies, roles and profiles shall exist before users, quotas and row policies apply to users and roles
+var rbacObjectKinds = []struct {
+	Type        string
+	SystemTable string
+	HasGrants   bool
+}{
+	{Type: "SETTINGS PROFILE", SystemTable: "system.settings_profiles"},
+	{Type: "ROLE", SystemTable: "system.roles", HasGrants: true},
+	{Type: "USER", SystemTable: "system.users", HasGrants: true},
+	{Type: "QUOTA", SystemTable: "system.quotas"},
+	{Type: "ROW POLICY", SystemTable: "system.row_policies"},
+}
+
+var rbacCreateRE = regexp.MustCompile(`^CREATE (SETTINGS PROFILE|ROLE|USER|QUOTA|ROW POLICY) `)
+
+func quoteRBACName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// sqlName - name in SHOW CREATE, CREATE and DROP statements, row policy name contains table
+func (o rbacObject) sqlName() string {
+	if o.Type == "ROW POLICY" {
+		return fmt.Sprintf("%s ON %s.%s", quoteRBACName(o.Name), quoteRBACName(o.Database), quoteRBACName(o.Table))
+	}
+	return quoteRBACName(o.Name)
+}
+
+// showStatements - SHOW CREATE and SHOW GRANTS return one statement per row with column name which depends on object name
+func (b *Backuper) showStatements(ctx context.Context, query string) ([]string, error) {
+	rows, err := b.ch.GetConn().Query(ctx, b.ch.LogQuery(query))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			b.log.WithField("logger", "showStatements").Warnf("can't close rows for %s: %v", query, closeErr)
+		}
+	}()
+	statements := make([]string, 0)
+	for rows.Next() {
+		var statement string
+		if err = rows.Scan(&statement); err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+	return statements, rows.Err()
+}
+
+// createBackupRBACObjects - dump SQL defined access entities and named collections into backup_name/access/rbac_objects.json, entities from users.xml are skipped
+func (b *Backuper) createBackupRBACObjects(ctx context.Context, rbacBackup string, disks []clickhouse.Disk) (uint64, error) {
+	log := b.log.WithField("logger", "createBackupRBACObjects")
+	objects := make([]rbacObject, 0)
+	for _, kind := range rbacObjectKinds {
+		entities := make([]struct {
+			Name     string `ch:"name"`
+			Database string `ch:"database"`
+			Table    string `ch:"table"`
+		}, 0)
+		query := fmt.Sprintf("SELECT name, '' AS database, '' AS table FROM %s WHERE storage != 'users_xml'", kind.SystemTable)
+		if kind.Type == "ROW POLICY" {
+			query = fmt.Sprintf("SELECT short_name AS name, database, table FROM %s WHERE storage != 'users_xml'", kind.SystemTable)
+		}
+		if err := b.ch.SelectContext(ctx, &entities, query); err != nil {
+			log.Warnf("can't get %s list, skip: %v", kind.Type, err)
+			continue
+		}
+		for _, entity := range entities {
+			object := rbacObject{Type: kind.Type, Name: entity.Name, Database: entity.Database, Table: entity.Table}
+			createStatements, err := b.showStatements(ctx, fmt.Sprintf("SHOW CREATE %s %s", kind.Type, object.sqlName()))
+			if err != nil {
+				return 0, fmt.Errorf("can't SHOW CREATE %s %s: %v", kind.Type, object.sqlName(), err)
+			}
+			if len(createStatements) != 1 {
+				return 0, fmt.Errorf("SHOW CREATE %s %s return %d rows, expected 1", kind.Type, object.sqlName(), len(createStatements))
+			}
+			object.Create = createStatements[0]
+			if kind.HasGrants {
+				if object.Grants, err = b.showStatements(ctx, fmt.Sprintf("SHOW GRANTS FOR %s", object.sqlName())); err != nil {
+					return 0, fmt.Errorf("can't SHOW GRANTS FOR %s: %v", object.sqlName(), err)
+				}
+			}
+			objects = append(objects, object)
+		}
+	}
+	namedCollections := make([]struct {
+		Name       string            `ch:"name"`
+		Collection map[string]string `ch:"collection"`
+	}, 0)
+	// named collections from config.xml can't be changed via SQL, source column available since 23.5
+	if err := b.ch.SelectContext(ctx, &namedCollections, "SELECT name, collection FROM system.named_collections WHERE source = 'SQL'"); err != nil {
+		log.Warnf("can't get named collections list, skip: %v", err)
+	}
+	for _, namedCollection := range namedCollections {
+		keys := make([]string, 0, len(namedCollection.Collection))
+		for key := range namedCollection.Collection {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			if namedCollection.Collection[key] == "[HIDDEN]" {
+				log.Warnf("named collection %s key %s is hidden, set `display_secrets_in_show_and_select` in config.xml and `format_display_secrets_in_show_and_select` in users.xml for backup user", namedCollection.Name, key)
+			}
+			values[i] = fmt.Sprintf("%s = '%s'", key, strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(namedCollection.Collection[key]))
+		}
+		objects = append(objects, rbacObject{
+			Type:   "NAMED COLLECTION",
+			Name:   namedCollection.Name,
+			Create: fmt.Sprintf("CREATE NAMED COLLECTION %s AS %s", quoteRBACName(namedCollection.Name), strings.Join(values, ", ")),
+		})
+	}
+	body, err := json.MarshalIndent(objects, "", "\t")
+	if err != nil {
+		return 0, err
+	}
+	objectsFile := path.Join(rbacBackup, rbacObjectsFile)
+	if err = os.WriteFile(objectsFile, body, 0640); err != nil {
+		return 0, err
+	}
+	if err = filesystemhelper.Chown(objectsFile, b.ch, disks, false); err != nil {
+		return 0, err
+	}
+	log.Infof("dump %d access entities and named collections -> %s", len(objects), objectsFile)
+	return uint64(len(body)), nil
+}
+
+func (b *Backuper) isRBACObjectExists(ctx context.Context, object rbacObject) (bool, error) {
+	var count uint64
+	var err error
+	switch object.Type {
+	case "NAMED COLLECTION":
+		err = b.ch.SelectSingleRow(ctx, &count, "SELECT count() FROM system.named_collections WHERE name = ?", object.Name)
+	case "ROW POLICY":
+		err = b.ch.SelectSingleRow(ctx, &count, "SELECT count() FROM system.row_policies WHERE short_name = ? AND database = ? AND table = ?", object.Name, object.Database, object.Table)
+	default:
+		for _, kind := range rbacObjectKinds {
+			if kind.Type == object.Type {
+				err = b.ch.SelectSingleRow(ctx, &count, fmt.Sprintf("SELECT count() FROM %s WHERE name = ?", kind.SystemTable), object.Name)
+				return count > 0, err
+			}
+		}
+		return false, fmt.Errorf("unknown access entity type %s", object.Type)
+	}
+	return count > 0, err
+}
+
+// restoreRBACObjects - restore access entities and named collections via SQL according to clickhouse->rbac_conflict_resolution, returns false when backup doesn't contain rbac_objects.json
+func (b *Backuper) restoreRBACObjects(ctx context.Context, backupName string) (bool, error) {
+	log := b.log.WithField("logger", "restoreRBACObjects")
+	objectsFile := path.Join(b.DefaultDataPath, "backup", backupName, "access", rbacObjectsFile)
+	body, err := os.ReadFile(objectsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	objects := make([]rbacObject, 0)
+	if err = json.Unmarshal(body, &objects); err != nil {
+		return false, fmt.Errorf("can't parse %s: %v", objectsFile, err)
+	}
+	conflictResolution := b.cfg.ClickHouse.RBACConflictResolution
+	failedObjects := make([]string, 0)
+	for _, object := range objects {
+		objectLog := log.WithField("object", object.Type+" "+object.sqlName())
+		exists, err := b.isRBACObjectExists(ctx, object)
+		if err != nil {
+			return true, err
+		}
+		queries := make([]string, 0, len(object.Grants)+2)
+		switch {
+		case !exists:
+			queries = append(queries, object.Create)
+		case conflictResolution == "skip":
+			objectLog.Info("already exists, skip")
+			continue
+		case conflictResolution == "replace" && object.Type == "NAMED COLLECTION":
+			queries = append(queries, "DROP NAMED COLLECTION "+object.sqlName(), object.Create)
+		case conflictResolution == "replace":
+			queries = append(queries, rbacCreateRE.ReplaceAllString(object.Create, "CREATE $1 OR REPLACE "))
+		default:
+			objectLog.Info("already exists, merge grants")
+		}
+		queries = append(queries, object.Grants...)
+		for _, query := range queries {
+			if err = b.ch.QueryContext(ctx, query); err != nil {
+				objectLog.Warnf("can't restore: %v", err)
+				failedObjects = append(failedObjects, object.Type+" "+object.sqlName())
+				break
+			}
+		}
+	}
+	if len(failedObjects) > 0 {
+		return true, fmt.Errorf("can't restore %d access entities: %s", len(failedObjects), strings.Join(failedObjects, ", "))
+	}
+	log.Infof("restore %d access entities and named collections with rbac_conflict_resolution: %s", len(objects), conflictResolution)
+	return true, nil
+}
@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+// WithMaxRehydrationWait - how long download waits until objects in archival storage class become readable, empty means general->max_rehydration_wait
+func WithMaxRehydrationWait(maxWait string) BackuperOpt {
+	return func(b *Backuper) {
+		b.maxRehydrationWait = maxWait
+	}
+}
+
+func ValidateMaxRehydrationWait(maxWait string) error {
+	if maxWait == "" {
+		return nil
+	}
+	if duration, err := time.ParseDuration(maxWait); err != nil || duration < 0 {
+		return fmt.Errorf("invalid --max-rehydration-wait=%s, shall be positive duration like 12h", maxWait)
+	}
+	return nil
+}
+
+func (b *Backuper) getMaxRehydrationWait() time.Duration {
+	if b.maxRehydrationWait != "" {
+		if duration, err := time.ParseDuration(b.maxRehydrationWait); err == nil {
+			return duration
+		}
+	}
+	return b.cfg.General.MaxRehydrationWaitDuration
+}
+
+// rehydrateBackupData - request restore for all table data objects in archival storage class before download starts,
+// otherwise each object will wait own restore sequentially during download, with download_by_part parts could be placed in any backup from incremental chain
+func (b *Backuper) rehydrateBackupData(ctx context.Context, backup metadata.BackupMetadata, tables []metadata.TableMetadata) error {
+	remotePaths := make([]string, 0)
+	if b.isEmbedded {
+		remotePaths = append(remotePaths, backup.BackupName)
+	} else {
+		backupNames := []string{backup.BackupName}
+		if b.cfg.General.DownloadByPart && backup.RequiredBackup != "" {
+			if len(backup.IncrementalChain) > 0 {
+				backupNames = append(backupNames, backup.IncrementalChain...)
+			} else {
+				backupNames = append(backupNames, backup.RequiredBackup)
+			}
+		}
+		for _, table := range tables {
+			if table.MetadataOnly || table.Table == "" {
+				continue
+			}
+			for _, backupName := range backupNames {
+				remotePaths = append(remotePaths, remoteTableDataPath(backupName, table))
+				// required backup could be uploaded without path_prefix
+				if backupName != backup.BackupName && table.RemotePathPrefix != "" {
+					withoutPrefix := table
+					withoutPrefix.RemotePathPrefix = ""
+					remotePaths = append(remotePaths, remoteTableDataPath(backupName, withoutPrefix))
+				}
+			}
+		}
+	}
+	return b.dst.RehydrateObjects(ctx, remotePaths, b.getMaxRehydrationWait())
+}
@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apexLog "github.com/apex/log"
+)
+
+// rewriteReplicaPathMacros - when DDL contains values of source server macros instead of `{macro}` in ZooKeeper path segments or replica name of Replicated engine,
+// replace them to `{macro}`, so restored table registers in ZooKeeper according to target server macros, macros which have the same value on both servers
+// or ambiguous values on source server are not touched
+func rewriteReplicaPathMacros(query string, sourceMacros, targetMacros map[string]string) (string, bool) {
+	match := tableEngineRE.FindStringSubmatchIndex(query)
+	if match == nil || !strings.HasPrefix(query[match[2]:match[3]], "Replicated") {
+		return query, false
+	}
+	argsStart := match[1] + len(query[match[1]:]) - len(strings.TrimLeft(query[match[1]:], " \t\r\n"))
+	if argsStart >= len(query) || query[argsStart] != '(' {
+		return query, false
+	}
+	argsEnd := findClosingParenthesis(query, argsStart)
+	if argsEnd < 0 {
+		return query, false
+	}
+	args := splitEngineArgs(query[argsStart+1 : argsEnd])
+	if len(args) < 2 || !isQuotedString(args[0]) || !isQuotedString(args[1]) {
+		return query, false
+	}
+	valueToMacro := make(map[string]string)
+	ambiguousValues := make(map[string]bool)
+	for macro, sourceValue := range sourceMacros {
+		targetValue, exists := targetMacros[macro]
+		if sourceValue == "" || !exists || targetValue == sourceValue {
+			continue
+		}
+		if _, duplicated := valueToMacro[sourceValue]; duplicated {
+			ambiguousValues[sourceValue] = true
+		}
+		valueToMacro[sourceValue] = macro
+	}
+	for value := range ambiguousValues {
+		delete(valueToMacro, value)
+	}
+	if len(valueToMacro) == 0 {
+		return query, false
+	}
+	zkPathSegments := strings.Split(strings.Trim(args[0], "'"), "/")
+	for i, segment := range zkPathSegments {
+		if macro, exists := valueToMacro[segment]; exists {
+			zkPathSegments[i] = "{" + macro + "}"
+		}
+	}
+	replicaName := strings.Trim(args[1], "'")
+	if macro, exists := valueToMacro[replicaName]; exists {
+		replicaName = "{" + macro + "}"
+	}
+	zkPath := quoteEngineArg(strings.Join(zkPathSegments, "/"))
+	replicaName = quoteEngineArg(replicaName)
+	if zkPath == args[0] && replicaName == args[1] {
+		return query, false
+	}
+	args[0], args[1] = zkPath, replicaName
+	return query[:argsStart] + "(" + strings.Join(args, ", ") + ")" + query[argsEnd+1:], true
+}
+
+// applyReplicaPathMacros - clickhouse->rewrite_replica_path_macros, source macros saved in backup metadata.json during `create`
+func (b *Backuper) applyReplicaPathMacros(ctx context.Context, tables ListOfTables, log *apexLog.Entry) error {
+	if !b.cfg.ClickHouse.RewriteReplicaPathMacros || len(b.sourceMacros) == 0 {
+		return nil
+	}
+	targetMacros, err := b.ch.GetMacros(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range tables {
+		if query, rewritten := rewriteReplicaPathMacros(tables[i].Query, b.sourceMacros, targetMacros); rewritten {
+			log.Infof("rewrite_replica_path_macros change `%s`.`%s` engine: %s", tables[i].Database, tables[i].Table, query[tableEngineRE.FindStringIndex(query)[0]:])
+			tables[i].Query = query
+		}
+	}
+	return nil
+}
+
+// restoreReadonlyReplicas - clickhouse->restore_readonly_replicas, execute SYSTEM RESTORE REPLICA for restored Replicated tables which are read-only after restore,
+// it happens when ZooKeeper metadata for table was lost, for example during restore on fresh Keeper
+func (b *Backuper) restoreReadonlyReplicas(ctx context.Context, tables ListOfTables, log *apexLog.Entry) error {
+	if !b.cfg.ClickHouse.RestoreReadonlyReplicas || b.keeperFallback {
+		return nil
+	}
+	restoredTables := make(map[string]struct{}, len(tables))
+	for _, t := range tables {
+		restoredTables[t.Database+"."+t.Table] = struct{}{}
+	}
+	replicas, err := b.ch.GetReplicasZookeeperPaths(ctx)
+	if err != nil {
+		return err
+	}
+	for _, replica := range replicas {
+		if _, restored := restoredTables[replica.Database+"."+replica.Table]; !restored || replica.IsReadonly == 0 {
+			continue
+		}
+		log.Infof("SYSTEM RESTORE REPLICA `%s`.`%s`", replica.Database, replica.Table)
+		if err = b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM RESTORE REPLICA `%s`.`%s`", replica.Database, replica.Table)); err != nil {
+			return fmt.Errorf("can't restore replica `%s`.`%s`: %v", replica.Database, replica.Table, err)
+		}
+	}
+	return nil
+}
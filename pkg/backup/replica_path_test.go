@@ -0,0 +1,44 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteReplicaPathMacros(t *testing.T) {
+	sourceMacros := map[string]string{"shard": "1", "replica": "chi-src-0-0", "cluster": "main", "layer": "1"}
+	targetMacros := map[string]string{"shard": "2", "replica": "chi-dst-0-1", "cluster": "main", "layer": "1"}
+
+	query, rewritten := rewriteReplicaPathMacros(
+		"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/main/tables/chi-src-0-0/db/t', 'chi-src-0-0') ORDER BY id",
+		sourceMacros, targetMacros,
+	)
+	assert.True(t, rewritten)
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/main/tables/{replica}/db/t', '{replica}') ORDER BY id", query)
+
+	// `1` is value of `shard` and `layer` on source server, can't choose macro
+	query, rewritten = rewriteReplicaPathMacros(
+		"CREATE TABLE db.t (id UInt64, v UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/1/db/t', 'chi-src-0-0', v) ORDER BY id",
+		sourceMacros, map[string]string{"shard": "2", "replica": "chi-dst-0-1", "layer": "3"},
+	)
+	assert.True(t, rewritten)
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64, v UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/1/db/t', '{replica}', v) ORDER BY id", query)
+
+	// macros already used
+	original := "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id"
+	query, rewritten = rewriteReplicaPathMacros(original, sourceMacros, targetMacros)
+	assert.False(t, rewritten)
+	assert.Equal(t, original, query)
+
+	// the same macros on target server
+	original = "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/1/db/t', 'chi-src-0-0') ORDER BY id"
+	query, rewritten = rewriteReplicaPathMacros(original, sourceMacros, sourceMacros)
+	assert.False(t, rewritten)
+	assert.Equal(t, original, query)
+
+	original = "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"
+	query, rewritten = rewriteReplicaPathMacros(original, sourceMacros, targetMacros)
+	assert.False(t, rewritten)
+	assert.Equal(t, original, query)
+}
@@ -2,13 +2,14 @@ package backup
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"github.com/Altinity/clickhouse-backup/pkg/keeper"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
 	"github.com/Altinity/clickhouse-backup/pkg/storage"
 	"github.com/Altinity/clickhouse-backup/pkg/storage/object_disk"
+	"github.com/Altinity/clickhouse-backup/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"io/fs"
 	"net/url"
 	"os"
@@ -35,7 +36,10 @@ import (
 var CreateDatabaseRE = regexp.MustCompile(`(?m)^CREATE DATABASE (\s*)(\S+)(\s*)`)
 
 // Restore - restore tables matched by tablePattern from backupName
-func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, partitions []string, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly bool, commandId int) error {
+func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, tableMapping, partitions []string, partitionsWhere, toTimestamp string, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly, preserveUUID, materializeExternal bool, commandId int) (err error) {
+	b.setCommandLog(commandId)
+	tablePattern = b.tablePatternOrDefault(tablePattern)
+	b.applyResourceLimits()
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -43,20 +47,49 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	b.partitionsWhere = partitionsWhere
+	b.materializeExternal = materializeExternal
 	if err := b.prepareRestoreDatabaseMapping(databaseMapping); err != nil {
 		return err
 	}
+	if err := b.prepareRestoreTableMapping(tableMapping); err != nil {
+		return err
+	}
+	if err := b.validatePITR(toTimestamp); err != nil {
+		return err
+	}
 
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore",
 	})
 	doRestoreData := !schemaOnly || dataOnly
 
+	startRestore := time.Now()
+	b.setLogComment("restore", backupName, commandId)
+	notifyFinish := b.notifyOperation("restore", backupName)
+	hooks := b.newOperationHooks("restore", backupName)
+	ctx, span := tracing.Start(ctx, "restore", attribute.String("backup", backupName))
+	defer func() {
+		err = hooks.after(ctx, err)
+		span.End(err)
+		notifyFinish(err)
+	}()
+	if err = hooks.before(ctx); err != nil {
+		return err
+	}
 	if err := b.ch.Connect(); err != nil {
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
 	defer b.ch.Close()
+	defer func() {
+		backupLogStatus := BackupLogStatusRestored
+		if err != nil {
+			backupLogStatus = BackupLogStatusRestoreFailed
+			b.rollbackRestore(context.Background())
+		}
+		b.writeBackupLog(context.Background(), backupName, commandId, backupLogStatus, startRestore, err)
+	}()
 
 	if backupName == "" {
 		_ = b.PrintLocalBackups(ctx, "all")
@@ -91,11 +124,23 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 	if b.cfg.General.RestoreSchemaOnCluster != "" {
 		b.cfg.General.RestoreSchemaOnCluster, err = b.ch.ApplyMacros(ctx, b.cfg.General.RestoreSchemaOnCluster)
 	}
+	if rollbackErr := b.prepareRestoreRollback(ctx, ""); rollbackErr != nil {
+		return rollbackErr
+	}
+	backupMetadata := metadata.BackupMetadata{}
 	if err == nil {
-		backupMetadata := metadata.BackupMetadata{}
-		if err := json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
+		parsedMetadata, err := metadata.ParseBackupMetadata(backupMetadataBody)
+		if err != nil {
 			return err
 		}
+		backupMetadata = *parsedMetadata
+		b.sourceMacros = backupMetadata.Macros
+		b.materializedDatabases = make(map[string]string)
+		for _, database := range backupMetadata.Databases {
+			if isMaterializedDatabaseEngine(database.Engine) {
+				b.materializedDatabases[database.Name] = database.Engine
+			}
+		}
 
 		if schemaOnly || doRestoreData {
 			for _, database := range backupMetadata.Databases {
@@ -124,12 +169,32 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 	} else if !os.IsNotExist(err) { // Legacy backups don't contain metadata.json
 		return err
 	}
+	hasReplicatedTables, err := b.restoreHasReplicatedTables(ctx, backupName, tablePattern, backupMetadata.Databases)
+	if err != nil {
+		return err
+	}
+	if hasReplicatedTables {
+		if err = b.checkKeeperSafeMode(ctx); err != nil {
+			return err
+		}
+	}
 	needRestart := false
 	if (rbacOnly || restoreRBAC) && !b.isEmbedded {
-		if err := b.restoreRBAC(ctx, backupName, disks); err != nil {
-			return err
+		isRestoredViaSQL := false
+		if b.cfg.ClickHouse.RBACConflictResolution != "" {
+			if isRestoredViaSQL, err = b.restoreRBACObjects(ctx, backupName); err != nil {
+				return err
+			}
+			if !isRestoredViaSQL {
+				log.Warnf("%s doesn't contain access/%s, rbac_conflict_resolution: %s will ignore, restore access files", backupName, rbacObjectsFile, b.cfg.ClickHouse.RBACConflictResolution)
+			}
+		}
+		if !isRestoredViaSQL {
+			if err := b.restoreRBAC(ctx, backupName, disks); err != nil {
+				return err
+			}
+			needRestart = true
 		}
-		needRestart = true
 	}
 	if (configsOnly || restoreConfigs) && !b.isEmbedded {
 		if err := b.restoreConfigs(backupName, disks); err != nil {
@@ -146,14 +211,30 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 			return nil
 		}
 	}
-
 	if schemaOnly || (schemaOnly == dataOnly) {
-		if err := b.RestoreSchema(ctx, backupName, tablePattern, dropTable, ignoreDependencies); err != nil {
+		if err := b.RestoreSchema(ctx, backupName, tablePattern, dropTable, ignoreDependencies, preserveUUID); err != nil {
+			return err
+		}
+	}
+	// CREATE TABLE registers replicas in Keeper, so znodes restored after it
+	if b.cfg.ClickHouse.KeeperRestore && !b.isEmbedded && !b.keeperFallback {
+		if err = b.restoreKeeper(ctx, backupName, tablePattern); err != nil {
 			return err
 		}
 	}
 	if dataOnly || (schemaOnly == dataOnly) {
-		if err := b.RestoreData(ctx, backupName, tablePattern, partitions, disks); err != nil {
+		if err := b.RestoreData(ctx, backupName, tablePattern, partitions, disks, commandId); err != nil {
+			return err
+		}
+		if err := b.replayToTimestamp(ctx, backupName, tablePattern, partitions, backupMetadata.CreationDate, toTimestamp); err != nil {
+			return err
+		}
+	}
+	if !b.deferMaterializedViewsRebuild {
+		if err := b.rebuildProjections(ctx, log); err != nil {
+			return err
+		}
+		if err := b.rebuildMaterializedViews(ctx, log); err != nil {
 			return err
 		}
 	}
@@ -254,8 +335,18 @@ func (b *Backuper) restoreEmptyDatabase(ctx context.Context, targetDB, tablePatt
 		}
 
 	}
+	if _, isMaterialized := b.materializedDatabases[database.Name]; isMaterialized && b.materializeExternal {
+		return b.ch.CreateDatabase(targetDB, b.cfg.General.RestoreSchemaOnCluster)
+	}
+	query := database.Query
+	if _, isMaterialized := b.materializedDatabases[database.Name]; isMaterialized {
+		var err error
+		if query, err = b.restoreMaterializedCredentials(database.Name, query); err != nil {
+			return err
+		}
+	}
 	substitution := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS ${1}`%s`${3}", targetDB)
-	if err := b.ch.CreateDatabaseFromQuery(ctx, CreateDatabaseRE.ReplaceAllString(database.Query, substitution), b.cfg.General.RestoreSchemaOnCluster); err != nil {
+	if err := b.ch.CreateDatabaseFromQuery(ctx, CreateDatabaseRE.ReplaceAllString(query, substitution), b.cfg.General.RestoreSchemaOnCluster); err != nil {
 		return err
 	}
 	return nil
@@ -275,6 +366,20 @@ func (b *Backuper) prepareRestoreDatabaseMapping(databaseMapping []string) error
 	return nil
 }
 
+func (b *Backuper) prepareRestoreTableMapping(tableMapping []string) error {
+	for i := 0; i < len(tableMapping); i++ {
+		splitByCommas := strings.Split(tableMapping[i], ",")
+		for _, m := range splitByCommas {
+			splitByColon := strings.Split(m, ":")
+			if len(splitByColon) != 2 {
+				return fmt.Errorf("restore-table-mapping %s should only have srcTable:destinationTable format for each map rule", m)
+			}
+			b.cfg.General.RestoreTableMapping[splitByColon[0]] = splitByColon[1]
+		}
+	}
+	return nil
+}
+
 // restoreRBAC - copy backup_name>/rbac folder to access_data_path
 func (b *Backuper) restoreRBAC(ctx context.Context, backupName string, disks []clickhouse.Disk) error {
 	log := b.log.WithField("logger", "restoreRBAC")
@@ -282,7 +387,7 @@ func (b *Backuper) restoreRBAC(ctx context.Context, backupName string, disks []c
 	if err != nil {
 		return err
 	}
-	if err = b.restoreBackupRelatedDir(backupName, "access", accessPath, disks, []string{"*.jsonl"}); err == nil {
+	if err = b.restoreBackupRelatedDir(backupName, "access", accessPath, disks, []string{"*.jsonl", rbacObjectsFile}); err == nil {
 		markFile := path.Join(accessPath, "need_rebuild_lists.mark")
 		log.Infof("create %s for properly rebuild RBAC after restart clickhouse-server", markFile)
 		file, err := os.Create(markFile)
@@ -416,8 +521,8 @@ func (b *Backuper) restoreBackupRelatedDir(backupName, backupPrefixDir, destinat
 }
 
 // RestoreSchema - restore schemas matched by tablePattern from backupName
-func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern string, dropTable, ignoreDependencies bool) error {
-	log := apexLog.WithFields(apexLog.Fields{
+func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern string, dropTable, ignoreDependencies, preserveUUID bool) error {
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore",
 	})
@@ -444,6 +549,20 @@ func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern s
 	if err != nil {
 		return err
 	}
+	skippedTables := 0
+	if tablesForRestore, skippedTables, err = b.filterMaterializedTables(tablesForRestore, log); err != nil {
+		return err
+	} else if skippedTables > 0 && len(tablesForRestore) == 0 {
+		return nil
+	}
+	// `skip` keeps existing materialized views untouched, `rebuild` drops them below
+	tablesForRestore, _ = b.filterMaterializedViews(tablesForRestore, b.materializedViewsMode == MaterializedViewsSkip, log)
+	// UUID shall be added before mapping, mapped tables are skipped, cause mapping generates new UUID for them
+	if preserveUUID && !b.isEmbedded {
+		if err = b.addTableUUIDToQuery(tablesForRestore, log); err != nil {
+			return err
+		}
+	}
 	// if restore-database-mapping specified, create database in mapping rules instead of in backup files.
 	if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
 		err = changeTableQueryToAdjustDatabaseMapping(&tablesForRestore, b.cfg.General.RestoreDatabaseMapping)
@@ -451,17 +570,47 @@ func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern s
 			return err
 		}
 	}
+	// if restore-table-mapping specified, create table in mapping rules instead of in backup files.
+	if len(b.cfg.General.RestoreTableMapping) > 0 {
+		err = changeTableQueryToAdjustTableMapping(&tablesForRestore, b.cfg.General.RestoreTableMapping)
+		if err != nil {
+			return err
+		}
+	}
 	if len(tablesForRestore) == 0 {
 		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
 	}
+	if b.isEmbedded && len(b.convertEngines) > 0 {
+		log.Warnf("--convert-engines is not supported for use_embedded_backup_restore: true, table engines will restore as is")
+	} else {
+		b.applyConvertEngines(ctx, tablesForRestore, log)
+	}
+	if !b.isEmbedded {
+		if err = b.applyReplicaPathMacros(ctx, tablesForRestore, log); err != nil {
+			return err
+		}
+	}
+	if b.isEmbedded && len(b.restoreDiskMapping) > 0 {
+		log.Warnf("--restore-disk-mapping is not supported for use_embedded_backup_restore: true, storage settings will restore as is")
+	} else {
+		b.applyRestoreDiskMapping(tablesForRestore, log)
+	}
+	if b.isEmbedded && (b.projectionsMode == ProjectionsDrop || b.projectionsMode == ProjectionsRebuild) {
+		log.Warnf("--projections=%s is not supported for use_embedded_backup_restore: true, projections will restore as is", b.projectionsMode)
+	} else {
+		b.applyProjectionsMode(tablesForRestore, log)
+	}
 	if dropErr := b.dropExistsTables(tablesForRestore, ignoreDependencies, version, log); dropErr != nil {
 		return dropErr
 	}
+	if b.materializedViewsMode == MaterializedViewsRebuild {
+		tablesForRestore, b.materializedViewsForRebuild = b.filterMaterializedViews(tablesForRestore, true, log)
+	}
 	var restoreErr error
 	if b.isEmbedded {
 		restoreErr = b.restoreSchemaEmbedded(ctx, backupName, tablesForRestore)
 	} else {
-		restoreErr = b.restoreSchemaRegular(tablesForRestore, version, log)
+		restoreErr = b.restoreSchemaRegular(sortTablesByDependencies(tablesForRestore, log), version, log)
 	}
 	if restoreErr != nil {
 		return restoreErr
@@ -469,6 +618,50 @@ func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern s
 	return nil
 }
 
+var createTableNameRE = regexp.MustCompile(`^((?:CREATE|ATTACH) (?:TABLE|VIEW|LIVE VIEW|WINDOW VIEW|MATERIALIZED VIEW|DICTIONARY) (?:\x60[^\x60]+\x60|[^\s\x60.]+)\.(?:\x60[^\x60]+\x60|[^\s\x60.(]+))`)
+
+// addTableUUIDToQuery - add UUID from backup to CREATE query, so object disk paths and `{uuid}` macros will the same as in source table, works only for Atomic and Replicated databases
+func (b *Backuper) addTableUUIDToQuery(tablesForRestore ListOfTables, log *apexLog.Entry) error {
+	databaseEngines := make(map[string]string)
+	for i := range tablesForRestore {
+		schema := &tablesForRestore[i]
+		if schema.UUID == "" || schema.UUID == "00000000-0000-0000-0000-000000000000" {
+			log.Warnf("backup doesn't contain UUID for `%s`.`%s`, UUID will generated by clickhouse-server", schema.Database, schema.Table)
+			continue
+		}
+		if uuidRE.MatchString(schema.Query) {
+			continue
+		}
+		// mapping replaces UUID for renamed tables, restored table will get new UUID anyway
+		if _, isMapped := b.cfg.General.RestoreDatabaseMapping[schema.Database]; isMapped {
+			log.Warnf("`%s` database is mapped, can't preserve UUID for `%s`.`%s`", schema.Database, schema.Database, schema.Table)
+			continue
+		}
+		if _, isMapped := b.cfg.General.RestoreTableMapping[schema.Table]; isMapped {
+			log.Warnf("`%s` table is mapped, can't preserve UUID for `%s`.`%s`", schema.Table, schema.Database, schema.Table)
+			continue
+		}
+		engine, exists := databaseEngines[schema.Database]
+		if !exists {
+			if err := b.ch.SelectSingleRowNoCtx(&engine, "SELECT engine FROM system.databases WHERE name = ?", schema.Database); err != nil {
+				return err
+			}
+			databaseEngines[schema.Database] = engine
+		}
+		// empty engine means database will create during restore with default Atomic engine
+		if engine != "" && engine != "Atomic" && engine != "Replicated" {
+			log.Warnf("`%s` database engine is %s, can't preserve UUID for `%s`.`%s`", schema.Database, engine, schema.Database, schema.Table)
+			continue
+		}
+		if !createTableNameRE.MatchString(schema.Query) {
+			log.Warnf("can't find table name in `%s`.`%s` query, UUID will generated by clickhouse-server", schema.Database, schema.Table)
+			continue
+		}
+		schema.Query = createTableNameRE.ReplaceAllString(schema.Query, fmt.Sprintf("$1 UUID '%s'", schema.UUID))
+	}
+	return nil
+}
+
 var UUIDWithMergeTreeRE = regexp.MustCompile(`^(.+)(UUID)(\s+)'([^']+)'(.+)({uuid})(.*)`)
 
 var emptyReplicatedMergeTreeRE = regexp.MustCompile(`(?m)Replicated(MergeTree|ReplacingMergeTree|SummingMergeTree|AggregatingMergeTree|CollapsingMergeTree|VersionedCollapsingMergeTree|GraphiteMergeTree)\s*\(([^']*)\)(.*)`)
@@ -566,6 +759,9 @@ func (b *Backuper) restoreSchemaRegular(tablesForRestore ListOfTables, version i
 			schema.Query = strings.Replace(
 				schema.Query, "CREATE LIVE VIEW", "ATTACH LIVE VIEW", 1,
 			)
+			if b.keeperFallback {
+				schema.Query = convertReplicatedToNonReplicatedEngine(schema.Query)
+			}
 			// https://github.com/Altinity/clickhouse-backup/issues/466
 			if b.cfg.General.RestoreSchemaOnCluster == "" && strings.Contains(schema.Query, "{uuid}") && strings.Contains(schema.Query, "Replicated") {
 				if !strings.Contains(schema.Query, "UUID") {
@@ -579,6 +775,9 @@ func (b *Backuper) restoreSchemaRegular(tablesForRestore ListOfTables, version i
 				Name:     schema.Table,
 			}, schema.Query, false, false, b.cfg.General.RestoreSchemaOnCluster, version, b.DefaultDataPath)
 
+			if restoreErr == nil {
+				b.trackRestoreCreatedTable(schema)
+			}
 			if restoreErr != nil {
 				restoreRetries++
 				if restoreRetries >= totalRetries {
@@ -659,9 +858,14 @@ func (b *Backuper) dropExistsTables(tablesForDrop ListOfTables, ignoreDependenci
 }
 
 // RestoreData - restore data for tables matched by tablePattern from backupName
-func (b *Backuper) RestoreData(ctx context.Context, backupName string, tablePattern string, partitions []string, disks []clickhouse.Disk) error {
+func (b *Backuper) RestoreData(ctx context.Context, backupName string, tablePattern string, partitions []string, disks []clickhouse.Disk, commandId int) error {
+	return b.restoreData(ctx, backupName, tablePattern, partitions, disks, commandId, nil)
+}
+
+// restoreData - partsFilter allows `restore_remote` streaming to restore only some parts of tables
+func (b *Backuper) restoreData(ctx context.Context, backupName string, tablePattern string, partitions []string, disks []clickhouse.Disk, commandId int, partsFilter func(tables ListOfTables) ListOfTables) error {
 	startRestore := time.Now()
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore",
 	})
@@ -689,6 +893,9 @@ func (b *Backuper) RestoreData(ctx context.Context, backupName string, tablePatt
 			diskTypes[diskName] = backup.DiskTypes[diskName]
 		}
 	}
+	if disks, err = b.mapRestoreDisks(disks, diskMap, diskTypes, backup.Disks); err != nil {
+		return err
+	}
 	var tablesForRestore ListOfTables
 	var partitionsNameList map[metadata.TableTitle][]string
 	metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
@@ -703,18 +910,42 @@ func (b *Backuper) RestoreData(ctx context.Context, backupName string, tablePatt
 	if err != nil {
 		return err
 	}
+	skippedTables := 0
+	if tablesForRestore, skippedTables, err = b.filterMaterializedTables(tablesForRestore, log); err != nil {
+		return err
+	} else if skippedTables > 0 && len(tablesForRestore) == 0 {
+		return nil
+	}
+	tablesForRestore, _ = b.filterMaterializedViews(tablesForRestore, false, log)
 	if len(tablesForRestore) == 0 {
 		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
 	}
+	if partsFilter != nil {
+		tablesForRestore = partsFilter(tablesForRestore)
+	}
 	log.Debugf("found %d tables with data in backup", len(tablesForRestore))
+	// restore_remote pipeline marks backup after first batch, and downloads the rest of parts into the same backup
+	if !b.isEmbedded && b.restorePipeline == nil {
+		if err = checkLocalBackupDataMoved(&backup.BackupMetadata); err != nil {
+			return err
+		}
+	}
+	if !b.isEmbedded && b.restoreDataMode == filesystemhelper.RestoreDataModeMove {
+		if err = b.markLocalBackupDataMoved(backupName); err != nil {
+			return fmt.Errorf("can't mark %s as moved: %v", backupName, err)
+		}
+	}
 	if b.isEmbedded {
 		err = b.restoreDataEmbedded(ctx, backupName, tablesForRestore, partitionsNameList)
 	} else {
-		err = b.restoreDataRegular(ctx, backupName, tablePattern, tablesForRestore, diskMap, diskTypes, disks, log)
+		err = b.restoreDataRegular(ctx, backupName, tablePattern, tablesForRestore, diskMap, diskTypes, disks, commandId, log)
 	}
 	if err != nil {
 		return err
 	}
+	if !b.isEmbedded && b.restoreDataMode == filesystemhelper.RestoreDataModeMove && b.restorePipeline == nil {
+		log.Warnf("data parts moved from local backup %s, it can't be uploaded or restored again, delete it or download it again", backupName)
+	}
 	log.WithField("duration", utils.HumanizeDuration(time.Since(startRestore))).Info("done")
 	return nil
 }
@@ -723,10 +954,13 @@ func (b *Backuper) restoreDataEmbedded(ctx context.Context, backupName string, t
 	return b.restoreEmbedded(ctx, backupName, false, tablesForRestore, partitionsNameList)
 }
 
-func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, tablePattern string, tablesForRestore ListOfTables, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, log *apexLog.Entry) error {
+func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, tablePattern string, tablesForRestore ListOfTables, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, commandId int, log *apexLog.Entry) error {
 	if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
 		tablePattern = b.changeTablePatternFromRestoreDatabaseMapping(tablePattern)
 	}
+	if len(b.cfg.General.RestoreTableMapping) > 0 {
+		tablePattern = b.changeTablePatternFromRestoreTableMapping(tablePattern)
+	}
 	chTables, err := b.ch.GetTables(ctx, tablePattern)
 	if err != nil {
 		return err
@@ -738,6 +972,15 @@ func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, ta
 	if len(missingTables) > 0 {
 		return fmt.Errorf("%s is not created. Restore schema first or create missing tables manually", strings.Join(missingTables, ", "))
 	}
+	// most critical tables shall attach first, to allow application partially work while long tail continues
+	if len(b.cfg.General.RestoreTablePriority) > 0 || b.cfg.General.RestoreTableOrderBySize != "" {
+		tablesForRestore.SortByRestorePriority(b.cfg.General.RestoreTablePriority, b.cfg.General.RestoreTableOrderBySize)
+	}
+	totalBytes := uint64(0)
+	for _, table := range tablesForRestore {
+		totalBytes += table.TotalBytes
+	}
+	status.Current.SetTotalBytes(commandId, totalBytes)
 
 	for i, table := range tablesForRestore {
 		// need mapped database path and original table.Database for HardlinkBackupPartsToStorage
@@ -748,36 +991,70 @@ func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, ta
 				tablesForRestore[i].Database = targetDB
 			}
 		}
-		log := log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, table.Table))
+		dstTableName := table.Table
+		if len(b.cfg.General.RestoreTableMapping) > 0 {
+			if targetTable, isMapped := b.cfg.General.RestoreTableMapping[table.Table]; isMapped {
+				dstTableName = targetTable
+				tablesForRestore[i].Table = targetTable
+			}
+		}
+		log := log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, dstTableName))
 		dstTable, ok := dstTablesMap[metadata.TableTitle{
 			Database: dstDatabase,
-			Table:    table.Table}]
+			Table:    dstTableName}]
 		if !ok {
-			return fmt.Errorf("can't find '%s.%s' in current system.tables", dstDatabase, table.Table)
+			return fmt.Errorf("can't find '%s.%s' in current system.tables", dstDatabase, dstTableName)
 		}
-		// https://github.com/Altinity/clickhouse-backup/issues/529
-		if b.cfg.ClickHouse.RestoreAsAttach {
-			if err = b.restoreDataRegularByAttach(ctx, backupName, table, diskMap, diskTypes, disks, dstTable, log, tablesForRestore, i); err != nil {
+		if b.keeperFallback {
+			tablesForRestore[i].Query = convertReplicatedToNonReplicatedEngine(tablesForRestore[i].Query)
+		}
+		if len(b.convertEngines) > 0 {
+			tablesForRestore[i].Query, _ = convertTableEngine(tablesForRestore[i].Query, b.convertEngines, "", "")
+		}
+		if b.projectionsMode == ProjectionsDrop || b.projectionsMode == ProjectionsRebuild {
+			tablesForRestore[i].Query, _ = removeProjectionsFromQuery(tablesForRestore[i].Query)
+		}
+		if table.EngineData == nil {
+			if err = b.validatePartsChecksums(backupName, table, disks, diskTypes); err != nil {
 				return err
 			}
-		} else {
-			if err = b.restoreDataRegularByParts(ctx, backupName, table, diskMap, diskTypes, disks, dstTable, log, tablesForRestore, i); err != nil {
+			if err = b.validatePartsProjections(backupName, table, disks, diskTypes); err != nil {
 				return err
 			}
 		}
+		verifyState := b.getRestoreVerifyState(ctx, tablesForRestore[i], log)
+		// https://github.com/Altinity/clickhouse-backup/issues/529
+		tableCtx, tableSpan := tracing.Start(ctx, "restore_table", tableAttribute(dstDatabase, dstTableName))
+		if table.EngineData != nil {
+			err = b.restoreEngineData(tableCtx, backupName, table, disks, dstTable, tablesForRestore[i], log)
+		} else {
+			partsBefore := b.getRestoreRollbackParts(ctx, tablesForRestore[i])
+			if b.cfg.ClickHouse.RestoreAsAttach {
+				err = b.restoreDataRegularByAttach(tableCtx, backupName, table, diskMap, diskTypes, disks, dstTable, log, tablesForRestore, i)
+			} else {
+				err = b.restoreDataRegularByParts(tableCtx, backupName, table, diskMap, diskTypes, disks, dstTable, log, tablesForRestore, i)
+			}
+			b.trackRestoreAttachedParts(ctx, tablesForRestore[i], partsBefore)
+		}
+		tableSpan.End(err)
+		if err != nil {
+			return err
+		}
+		b.verifyRestoredTable(ctx, backupName, tablesForRestore[i], verifyState, diskTypes, log)
 		// https://github.com/Altinity/clickhouse-backup/issues/529
 		for _, mutation := range table.Mutations {
 			if err := b.ch.ApplyMutation(ctx, tablesForRestore[i], mutation); err != nil {
 				log.Warnf("can't apply mutation %s for table `%s`.`%s`	: %v", mutation.Command, tablesForRestore[i].Database, tablesForRestore[i].Table, err)
 			}
 		}
+		status.Current.AddProgress(commandId, table.TotalBytes, 0)
 		log.Info("done")
 	}
-	return nil
+	return b.restoreReadonlyReplicas(ctx, tablesForRestore, log)
 }
 
 func (b *Backuper) restoreDataRegularByAttach(ctx context.Context, backupName string, table metadata.TableMetadata, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, dstTable clickhouse.Table, log *apexLog.Entry, tablesForRestore ListOfTables, i int) error {
-	if err := filesystemhelper.HardlinkBackupPartsToStorage(backupName, table, disks, dstTable.DataPaths, b.ch, false); err != nil {
+	if err := filesystemhelper.HardlinkBackupPartsToStorage(backupName, table, disks, dstTable.DataPaths, b.ch, false, b.restoreDataMode); err != nil {
 		return fmt.Errorf("can't copy data to storage '%s.%s': %v", table.Database, table.Table, err)
 	}
 	log.Debug("data to 'storage' copied")
@@ -792,7 +1069,7 @@ func (b *Backuper) restoreDataRegularByAttach(ctx context.Context, backupName st
 }
 
 func (b *Backuper) restoreDataRegularByParts(ctx context.Context, backupName string, table metadata.TableMetadata, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, dstTable clickhouse.Table, log *apexLog.Entry, tablesForRestore ListOfTables, i int) error {
-	if err := filesystemhelper.HardlinkBackupPartsToStorage(backupName, table, disks, dstTable.DataPaths, b.ch, true); err != nil {
+	if err := filesystemhelper.HardlinkBackupPartsToStorage(backupName, table, disks, dstTable.DataPaths, b.ch, true, b.restoreDataMode); err != nil {
 		return fmt.Errorf("can't copy data to datached '%s.%s': %v", table.Database, table.Table, err)
 	}
 	log.Debug("data to 'detached' copied")
@@ -806,7 +1083,7 @@ func (b *Backuper) restoreDataRegularByParts(ctx context.Context, backupName str
 }
 
 func (b *Backuper) downloadObjectDiskParts(ctx context.Context, backupName string, backupTable metadata.TableMetadata, diskMap, diskTypes map[string]string) error {
-	log := apexLog.WithFields(apexLog.Fields{"operation": "downloadObjectDiskParts"})
+	log := b.log.WithFields(apexLog.Fields{"operation": "downloadObjectDiskParts"})
 	start := time.Now()
 	dbAndTableDir := path.Join(common.TablePathEncode(backupTable.Database), common.TablePathEncode(backupTable.Table))
 	var err error
@@ -824,15 +1101,16 @@ func (b *Backuper) downloadObjectDiskParts(ctx context.Context, backupName strin
 	if !needToDownloadObjectDisk {
 		return nil
 	}
-	b.dst, err = storage.NewBackupDestination(ctx, b.cfg, b.ch, false, backupName)
+	// restore_remote_streaming calls it while `download` still uses b.dst, so connection shall be own
+	dst, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, backupName)
 	if err != nil {
 		return err
 	}
-	if err = b.dst.Connect(ctx); err != nil {
-		return fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
+	if err = dst.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to %s: %v", dst.Kind(), err)
 	}
 	defer func() {
-		if err := b.dst.Close(ctx); err != nil {
+		if err := dst.Close(ctx); err != nil {
 			b.log.Warnf("downloadObjectDiskParts: can't close BackupDestination error: %v", err)
 		}
 	}()
@@ -903,15 +1181,21 @@ func (b *Backuper) checkMissingTables(tablesForRestore ListOfTables, chTables []
 				dstDatabase = targetDB
 			}
 		}
+		dstTableName := table.Table
+		if len(b.cfg.General.RestoreTableMapping) > 0 {
+			if targetTable, isMapped := b.cfg.General.RestoreTableMapping[table.Table]; isMapped {
+				dstTableName = targetTable
+			}
+		}
 		found := false
 		for _, chTable := range chTables {
-			if (dstDatabase == chTable.Database) && (table.Table == chTable.Name) {
+			if (dstDatabase == chTable.Database) && (dstTableName == chTable.Name) {
 				found = true
 				break
 			}
 		}
 		if !found {
-			missingTables = append(missingTables, fmt.Sprintf("'%s.%s'", dstDatabase, table.Table))
+			missingTables = append(missingTables, fmt.Sprintf("'%s.%s'", dstDatabase, dstTableName))
 		}
 	}
 	return missingTables
@@ -978,8 +1262,25 @@ func (b *Backuper) changeTablePatternFromRestoreDatabaseMapping(tablePattern str
 	return tablePattern
 }
 
+func (b *Backuper) changeTablePatternFromRestoreTableMapping(tablePattern string) string {
+	for _, targetTable := range b.cfg.General.RestoreTableMapping {
+		if tablePattern != "" {
+			tablePattern += ",*." + targetTable
+		} else {
+			tablePattern += "*." + targetTable
+		}
+	}
+	return tablePattern
+}
+
 func (b *Backuper) restoreEmbedded(ctx context.Context, backupName string, restoreOnlySchema bool, tablesForRestore ListOfTables, partitionsNameList map[metadata.TableTitle][]string) error {
-	restoreSQL := "Disk(?,?)"
+	restoreSQL, restoreArgs := "Disk(?,?)", []interface{}{b.cfg.ClickHouse.EmbeddedBackupDisk, backupName}
+	if b.isEmbeddedRemote {
+		var err error
+		if restoreSQL, restoreArgs, err = b.embeddedRemoteDestination(ctx, backupName); err != nil {
+			return err
+		}
+	}
 	tablesSQL := ""
 	l := len(tablesForRestore)
 	for i, t := range tablesForRestore {
@@ -988,8 +1289,16 @@ func (b *Backuper) restoreEmbedded(ctx context.Context, backupName string, resto
 			if strings.Contains(t.Query, " DICTIONARY ") {
 				kind = "DICTIONARY"
 			}
-			if newDb, isMapped := b.cfg.General.RestoreDatabaseMapping[t.Database]; isMapped {
-				tablesSQL += fmt.Sprintf("%s `%s`.`%s` AS `%s`.`%s`", kind, t.Database, t.Table, newDb, t.Table)
+			newDb, isDbMapped := b.cfg.General.RestoreDatabaseMapping[t.Database]
+			if !isDbMapped {
+				newDb = t.Database
+			}
+			newTable, isTableMapped := b.cfg.General.RestoreTableMapping[t.Table]
+			if !isTableMapped {
+				newTable = t.Table
+			}
+			if isDbMapped || isTableMapped {
+				tablesSQL += fmt.Sprintf("%s `%s`.`%s` AS `%s`.`%s`", kind, t.Database, t.Table, newDb, newTable)
 			} else {
 				tablesSQL += fmt.Sprintf("%s `%s`.`%s`", kind, t.Database, t.Table)
 			}
@@ -1018,7 +1327,7 @@ func (b *Backuper) restoreEmbedded(ctx context.Context, backupName string, resto
 	}
 	restoreSQL = fmt.Sprintf("RESTORE %s FROM %s %s", tablesSQL, restoreSQL, settings)
 	restoreResults := make([]clickhouse.SystemBackups, 0)
-	if err := b.ch.SelectContext(ctx, &restoreResults, restoreSQL, b.cfg.ClickHouse.EmbeddedBackupDisk, backupName); err != nil {
+	if err := b.ch.SelectContext(ctx, &restoreResults, restoreSQL, restoreArgs...); err != nil {
 		return fmt.Errorf("restore error: %v", err)
 	}
 	if len(restoreResults) == 0 || restoreResults[0].Status != "RESTORED" {
@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+// WithRestoreDataMode - `hardlink` (default) link local backup files into table data, `move` rename files, so restored local backup will not contain data anymore,
+// `copy` allows backup and clickhouse data on different filesystems, but requires double disk space
+func WithRestoreDataMode(mode string) BackuperOpt {
+	return func(b *Backuper) {
+		b.restoreDataMode = mode
+	}
+}
+
+func ValidateRestoreDataMode(mode string) error {
+	switch mode {
+	case "", filesystemhelper.RestoreDataModeHardlink, filesystemhelper.RestoreDataModeMove, filesystemhelper.RestoreDataModeCopy:
+		return nil
+	}
+	return fmt.Errorf("unsupported --restore-data-mode=%s, shall be one of %s, %s, %s", mode, filesystemhelper.RestoreDataModeHardlink, filesystemhelper.RestoreDataModeMove, filesystemhelper.RestoreDataModeCopy)
+}
+
+// checkLocalBackupDataMoved - local backup restored with `--restore-data-mode=move` contains only part of data or no data at all
+func checkLocalBackupDataMoved(backupMetadata *metadata.BackupMetadata) error {
+	if backupMetadata.DataMoved {
+		return fmt.Errorf("data parts of local backup %s moved into tables by restore --restore-data-mode=%s, delete it and download it again", backupMetadata.BackupName, filesystemhelper.RestoreDataModeMove)
+	}
+	return nil
+}
+
+// markLocalBackupDataMoved - save `data_moved: true` into local metadata.json before first part moved, so backup is marked even when restore failed in the middle,
+// backup without metadata.json, like legacy backup, is not marked
+func (b *Backuper) markLocalBackupDataMoved(backupName string) error {
+	metadataFile := path.Join(b.DefaultDataPath, "backup", backupName, "metadata.json")
+	metadataBody, err := os.ReadFile(metadataFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	backupMetadata, err := metadata.ParseBackupMetadata(metadataBody)
+	if err != nil {
+		return fmt.Errorf("can't parse %s: %v", metadataFile, err)
+	}
+	if backupMetadata.DataMoved {
+		return nil
+	}
+	backupMetadata.DataMoved = true
+	return backupMetadata.Save(metadataFile)
+}
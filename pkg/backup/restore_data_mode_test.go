@@ -0,0 +1,35 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkLocalBackupDataMoved(t *testing.T) {
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.WithField("logger", "test"), DefaultDataPath: t.TempDir()}
+	backupMetadata := metadata.BackupMetadata{BackupName: "backup1", DataFormat: "directory", Tables: []metadata.TableTitle{{Database: "db", Table: "t1"}}}
+	require.NoError(t, os.MkdirAll(path.Join(b.DefaultDataPath, "backup", "backup1"), 0750))
+	require.NoError(t, backupMetadata.Save(path.Join(b.DefaultDataPath, "backup", "backup1", "metadata.json")))
+
+	localMetadata, err := b.ReadBackupMetadataLocal(context.Background(), "backup1")
+	require.NoError(t, err)
+	assert.NoError(t, checkLocalBackupDataMoved(localMetadata))
+
+	require.NoError(t, b.markLocalBackupDataMoved("backup1"))
+	require.NoError(t, b.markLocalBackupDataMoved("backup1"), "already marked backup shall be kept as is")
+	localMetadata, err = b.ReadBackupMetadataLocal(context.Background(), "backup1")
+	require.NoError(t, err)
+	assert.True(t, localMetadata.DataMoved)
+	assert.ErrorContains(t, checkLocalBackupDataMoved(localMetadata), "delete it and download it again")
+
+	assert.NoError(t, b.markLocalBackupDataMoved("legacy"), "backup without metadata.json can't be marked")
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+var pitrTimestampLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+
+// parsePITRTimestamp - parse `--to-timestamp` value, timestamp without time zone means UTC
+func parsePITRTimestamp(toTimestamp string) (time.Time, error) {
+	for _, layout := range pitrTimestampLayouts {
+		if t, err := time.Parse(layout, toTimestamp); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse --to-timestamp=%s, expected format is RFC3339 or `YYYY-MM-DD hh:mm:ss`", toTimestamp)
+}
+
+func (b *Backuper) validatePITR(toTimestamp string) error {
+	if toTimestamp == "" {
+		return nil
+	}
+	if b.cfg.ClickHouse.PITRSource == "" || b.cfg.ClickHouse.PITRTimestampColumn == "" {
+		return fmt.Errorf("--to-timestamp require clickhouse->pitr_source and clickhouse->pitr_timestamp_column")
+	}
+	if b.cfg.ClickHouse.UseEmbeddedBackupRestore {
+		return fmt.Errorf("--to-timestamp doesn't support `use_embedded_backup_restore: true`")
+	}
+	_, err := parsePITRTimestamp(toTimestamp)
+	return err
+}
+
+// replayToTimestamp - point-in-time restore, after restore snapshot insert rows which arrived after FREEZE of each table from clickhouse->pitr_source
+func (b *Backuper) replayToTimestamp(ctx context.Context, backupName, tablePattern string, partitions []string, backupCreationDate time.Time, toTimestamp string) error {
+	if toTimestamp == "" {
+		return nil
+	}
+	log := b.log.WithField("logger", "replayToTimestamp")
+	replayTo, err := parsePITRTimestamp(toTimestamp)
+	if err != nil {
+		return err
+	}
+	if !replayTo.After(backupCreationDate.UTC()) {
+		return fmt.Errorf("--to-timestamp=%s shall be after backup %s creation date %s", toTimestamp, backupName, backupCreationDate.UTC().Format(time.RFC3339))
+	}
+	metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
+	tablesForReplay, _, err := b.getTableListByPatternLocal(ctx, metadataPath, tablePattern, false, partitions)
+	if err != nil {
+		return err
+	}
+	for _, table := range tablesForReplay {
+		if table.MetadataOnly || !strings.Contains(table.Query, "MergeTree") {
+			continue
+		}
+		if err = b.replayTable(ctx, table, getReplayFrom(table, backupCreationDate), replayTo); err != nil {
+			return err
+		}
+	}
+	log.Infof("replay to %s done", replayTo.Format(time.RFC3339))
+	return nil
+}
+
+// getReplayFrom - each table frozen before backup creation date, backups created before `freeze_time` was added don't contain it, so replay from creation date
+func getReplayFrom(table metadata.TableMetadata, backupCreationDate time.Time) time.Time {
+	if table.FreezeTime != nil && !table.FreezeTime.IsZero() {
+		return table.FreezeTime.UTC()
+	}
+	return backupCreationDate.UTC()
+}
+
+func (b *Backuper) replayTable(ctx context.Context, table metadata.TableMetadata, replayFrom, replayTo time.Time) error {
+	dstDatabase := table.Database
+	if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[table.Database]; isMapped {
+		dstDatabase = targetDB
+	}
+	dstTable := table.Table
+	if targetTable, isMapped := b.cfg.General.RestoreTableMapping[table.Table]; isMapped {
+		dstTable = targetTable
+	}
+	log := b.log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, dstTable))
+	var columns []struct {
+		Name string `ch:"name"`
+	}
+	// MATERIALIZED and ALIAS columns can't be inserted
+	if err := b.ch.SelectContext(ctx, &columns, "SELECT name FROM system.columns WHERE database=? AND table=? AND default_kind NOT IN ('MATERIALIZED','ALIAS') ORDER BY position", dstDatabase, dstTable); err != nil {
+		return fmt.Errorf("can't get columns of `%s`.`%s`: %v", dstDatabase, dstTable, err)
+	}
+	columnList := make([]string, 0, len(columns))
+	timestampColumnExists := false
+	for _, column := range columns {
+		if column.Name == b.cfg.ClickHouse.PITRTimestampColumn {
+			timestampColumnExists = true
+		}
+		columnList = append(columnList, fmt.Sprintf("`%s`", strings.ReplaceAll(column.Name, "`", "\\`")))
+	}
+	if !timestampColumnExists {
+		log.Warnf("column %s not found, skip replay", b.cfg.ClickHouse.PITRTimestampColumn)
+		return nil
+	}
+	source := strings.NewReplacer("{database}", table.Database, "{table}", table.Table).Replace(b.cfg.ClickHouse.PITRSource)
+	if table.ReplicationLogPointer > 0 {
+		log.Debugf("snapshot was taken at replication log_pointer=%d", table.ReplicationLogPointer)
+	}
+	replayQuery := fmt.Sprintf(
+		"INSERT INTO `%s`.`%s` (%s) SELECT %s FROM %s WHERE `%s` > toDateTime64(?, 6, 'UTC') AND `%s` <= toDateTime64(?, 6, 'UTC')",
+		dstDatabase, dstTable, strings.Join(columnList, ","), strings.Join(columnList, ","), source, b.cfg.ClickHouse.PITRTimestampColumn, b.cfg.ClickHouse.PITRTimestampColumn,
+	)
+	if err := b.ch.QueryContext(ctx, replayQuery, replayFrom.Format("2006-01-02 15:04:05.000000"), replayTo.Format("2006-01-02 15:04:05.000000")); err != nil {
+		return fmt.Errorf("can't replay `%s`.`%s` from %s: %v", dstDatabase, dstTable, source, err)
+	}
+	log.Infof("replay from %s done", replayFrom.Format(time.RFC3339))
+	return nil
+}
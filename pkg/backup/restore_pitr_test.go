@@ -0,0 +1,16 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetReplayFrom(t *testing.T) {
+	backupCreationDate := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	freezeTime := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	assert.Equal(t, freezeTime, getReplayFrom(metadata.TableMetadata{FreezeTime: &freezeTime}, backupCreationDate), "replay shall start from FREEZE of table")
+	assert.Equal(t, backupCreationDate, getReplayFrom(metadata.TableMetadata{}, backupCreationDate), "backup without freeze_time shall replay from creation date")
+}
@@ -1,11 +1,472 @@
 package backup
 
-func (b *Backuper) RestoreFromRemote(backupName, tablePattern string, databaseMapping, partitions []string, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly, resume bool, commandId int) error {
-	if err := b.Download(backupName, tablePattern, partitions, schemaOnly, resume, commandId); err != nil {
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/partition"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	apexLog "github.com/apex/log"
+)
+
+// restorePipeline - `restore_remote` with general->restore_remote_pipeline: true, Download calls restoreTableData for each table of backupName right after table data downloaded
+type restorePipeline struct {
+	backupName       string
+	restoreTableData func(ctx context.Context, table metadata.TableMetadata) error
+	// restorePart - not nil for general->restore_remote_streaming: true, Download calls it for each part of `directory` data format right after part downloaded
+	restorePart func(ctx context.Context, table metadata.TableMetadata, disk string, part metadata.Part) error
+	// streamedParts - disk/part of each table which already attached by restorePart
+	streamedParts map[metadata.TableTitle]map[string]struct{}
+	// pendingParts - downloaded and verified parts of each table which wait for attach, restorePart attach all pending parts of table in one batch
+	pendingParts map[metadata.TableTitle]map[string][]metadata.Part
+	// unverifiedTables - tables without sentinel files, warning logged once for each table
+	unverifiedTables map[metadata.TableTitle]struct{}
+	streamedPartsMx  sync.Mutex
+}
+
+func (p *restorePipeline) addPendingPart(table metadata.TableMetadata, disk string, part metadata.Part) {
+	p.streamedPartsMx.Lock()
+	defer p.streamedPartsMx.Unlock()
+	title := metadata.TableTitle{Database: table.Database, Table: table.Table}
+	if _, exists := p.pendingParts[title]; !exists {
+		p.pendingParts[title] = make(map[string][]metadata.Part)
+	}
+	p.pendingParts[title][disk] = append(p.pendingParts[title][disk], part)
+}
+
+// takePendingParts - empty result means parts already attached by batch of other download goroutine
+func (p *restorePipeline) takePendingParts(table metadata.TableMetadata) map[string][]metadata.Part {
+	p.streamedPartsMx.Lock()
+	defer p.streamedPartsMx.Unlock()
+	title := metadata.TableTitle{Database: table.Database, Table: table.Table}
+	parts := p.pendingParts[title]
+	delete(p.pendingParts, title)
+	return parts
+}
+
+// isFirstUnverified - true only for first part of table without sentinel files
+func (p *restorePipeline) isFirstUnverified(table metadata.TableMetadata) bool {
+	p.streamedPartsMx.Lock()
+	defer p.streamedPartsMx.Unlock()
+	title := metadata.TableTitle{Database: table.Database, Table: table.Table}
+	if _, exists := p.unverifiedTables[title]; exists {
+		return false
+	}
+	p.unverifiedTables[title] = struct{}{}
+	return true
+}
+
+func (p *restorePipeline) addStreamedPart(table metadata.TableMetadata, disk string, part metadata.Part) {
+	p.streamedPartsMx.Lock()
+	defer p.streamedPartsMx.Unlock()
+	title := metadata.TableTitle{Database: table.Database, Table: table.Table}
+	if _, exists := p.streamedParts[title]; !exists {
+		p.streamedParts[title] = make(map[string]struct{})
+	}
+	p.streamedParts[title][path.Join(disk, part.Name)] = struct{}{}
+}
+
+// excludeStreamedParts - table data restore after download shall attach only parts which was not streamed, like required parts of incremental backup
+func (p *restorePipeline) excludeStreamedParts(tables ListOfTables) ListOfTables {
+	p.streamedPartsMx.Lock()
+	defer p.streamedPartsMx.Unlock()
+	for i, table := range tables {
+		streamed, exists := p.streamedParts[metadata.TableTitle{Database: table.Database, Table: table.Table}]
+		if !exists {
+			continue
+		}
+		parts := make(map[string][]metadata.Part, len(table.Parts))
+		for disk := range table.Parts {
+			for _, part := range table.Parts[disk] {
+				if _, isStreamed := streamed[path.Join(disk, part.Name)]; !isStreamed {
+					parts[disk] = append(parts[disk], part)
+				}
+			}
+		}
+		tables[i].Parts = parts
+	}
+	return tables
+}
+
+// onlyStreamedParts - restore batch of streamed parts, mutations will apply after all table parts restored
+func onlyStreamedParts(table metadata.TableMetadata, parts map[string][]metadata.Part) func(tables ListOfTables) ListOfTables {
+	return func(tables ListOfTables) ListOfTables {
+		for i := range tables {
+			if tables[i].Database != table.Database || tables[i].Table != table.Table {
+				continue
+			}
+			tables[i].Parts = parts
+			tables[i].Mutations = nil
+			tables[i].TotalBytes = 0
+			for _, diskParts := range parts {
+				for _, part := range diskParts {
+					if part.Size > 0 {
+						tables[i].TotalBytes += uint64(part.Size)
+					}
+				}
+			}
+			return tables[i : i+1]
+		}
+		return ListOfTables{}
+	}
+}
+
+func (b *Backuper) RestoreFromRemote(backupName, tablePattern string, databaseMapping, tableMapping, partitions []string, partitionsWhere, toTimestamp string, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly, resume, preserveUUID, materializeExternal bool, commandId int) error {
+	b.setCommandLog(commandId)
+	if b.isEmbeddedRemoteTarget() {
+		hasUnsupportedArgs := len(databaseMapping) > 0 || len(tableMapping) > 0 || partitionsWhere != "" || toTimestamp != "" || restoreRBAC || rbacOnly || restoreConfigs || configsOnly
+		err := b.restoreFromRemoteEmbedded(backupName, tablePattern, partitions, schemaOnly, dataOnly, dropTable, ignoreDependencies, hasUnsupportedArgs, commandId)
+		if !errors.Is(err, errClassicRemoteBackup) {
+			return err
+		}
+		b.useClassicBackup()
+	}
+	if (b.cfg.General.RestoreRemotePipeline || b.cfg.General.RestoreRemoteStreaming) && !schemaOnly && !dataOnly && !rbacOnly && !configsOnly && !b.cfg.ClickHouse.UseEmbeddedBackupRestore {
+		return b.restoreFromRemotePipeline(backupName, tablePattern, databaseMapping, tableMapping, partitions, partitionsWhere, toTimestamp, dropTable, ignoreDependencies, restoreRBAC, restoreConfigs, resume, preserveUUID, materializeExternal, commandId)
+	}
+	isDownloaded := true
+	if err := b.Download(backupName, tablePattern, partitions, partitionsWhere, schemaOnly, resume, commandId); err != nil {
 		// https://github.com/Altinity/clickhouse-backup/issues/625
 		if err != ErrBackupIsAlreadyExists {
+			b.removeDownloadedBackupOnRollback(backupName, resume)
+			return err
+		}
+		isDownloaded = false
+	}
+	err := b.Restore(backupName, tablePattern, databaseMapping, tableMapping, partitions, partitionsWhere, toTimestamp, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly, preserveUUID, materializeExternal, commandId)
+	if err != nil && isDownloaded {
+		b.removeDownloadedBackupOnRollback(backupName, resume)
+	}
+	return err
+}
+
+// removeDownloadedBackupOnRollback - remove partially downloaded data when general->restore_rollback_on_failure: true, keep it for --resumable to allow continue download
+func (b *Backuper) removeDownloadedBackupOnRollback(backupName string, resume bool) {
+	if !b.cfg.General.RestoreRollbackOnFailure || resume {
+		return
+	}
+	if err := b.RemoveBackupLocal(context.Background(), backupName, nil); err != nil {
+		b.log.Warnf("rollback: can't remove downloaded %s: %v", backupName, err)
+		return
+	}
+	b.log.Infof("rollback: downloaded %s removed", backupName)
+}
+
+// restoreFromRemotePipeline - download metadata and restore schema first, then download data and attach each table as soon as its data downloaded, while download of other tables continues,
+// attach for different tables executes one by one to keep restore order and rollback state consistent
+func (b *Backuper) restoreFromRemotePipeline(backupName, tablePattern string, databaseMapping, tableMapping, partitions []string, partitionsWhere, toTimestamp string, dropTable, ignoreDependencies, restoreRBAC, restoreConfigs, resume, preserveUUID, materializeExternal bool, commandId int) error {
+	log := b.log.WithField("logger", "restoreFromRemotePipeline")
+	if err := b.Download(backupName, tablePattern, partitions, partitionsWhere, true, resume, commandId); err != nil {
+		if err != ErrBackupIsAlreadyExists {
+			b.removeDownloadedBackupOnRollback(backupName, resume)
+			return err
+		}
+		// local backup already contains data, nothing to overlap with download
+		log.Infof("%s already exists locally, restore without pipeline", backupName)
+		return b.Restore(backupName, tablePattern, databaseMapping, tableMapping, partitions, partitionsWhere, toTimestamp, false, false, dropTable, ignoreDependencies, restoreRBAC, false, restoreConfigs, false, preserveUUID, materializeExternal, commandId)
+	}
+	b.deferMaterializedViewsRebuild = true
+	err := b.Restore(backupName, tablePattern, databaseMapping, tableMapping, partitions, partitionsWhere, toTimestamp, true, false, dropTable, ignoreDependencies, restoreRBAC, false, restoreConfigs, false, preserveUUID, materializeExternal, commandId)
+	b.deferMaterializedViewsRebuild = false
+	if err != nil {
+		b.removeDownloadedBackupOnRollback(backupName, resume)
+		return err
+	}
+	attachMx := sync.Mutex{}
+	b.restorePipeline = &restorePipeline{
+		backupName: backupName,
+		restoreTableData: func(ctx context.Context, table metadata.TableMetadata) error {
+			attachMx.Lock()
+			defer attachMx.Unlock()
+			disks, err := b.ch.GetDisks(ctx, true)
+			if err != nil {
+				return err
+			}
+			// progress already tracked by download
+			return b.restoreData(ctx, backupName, fmt.Sprintf("%s.%s", table.Database, table.Table), partitions, disks, status.NotFromAPI, b.restorePipeline.excludeStreamedParts)
+		},
+	}
+	if b.cfg.General.RestoreRemoteStreaming {
+		if resume || b.cfg.ClickHouse.RestoreAsAttach {
+			log.Warnf("restore_remote_streaming: true doesn't support --resumable and restore_as_attach: true, will attach data after download of each table")
+		} else {
+			// downloaded part files not required after attach, so staging space will release during download, `copy` keeps local backup as is
+			if b.restoreDataMode == "" || b.restoreDataMode == filesystemhelper.RestoreDataModeHardlink {
+				b.restoreDataMode = filesystemhelper.RestoreDataModeMove
+			}
+			b.restorePipeline.streamedParts = make(map[metadata.TableTitle]map[string]struct{})
+			b.restorePipeline.pendingParts = make(map[metadata.TableTitle]map[string][]metadata.Part)
+			b.restorePipeline.unverifiedTables = make(map[metadata.TableTitle]struct{})
+			b.restorePipeline.restorePart = func(ctx context.Context, table metadata.TableMetadata, disk string, part metadata.Part) error {
+				if err := b.verifyStreamedPart(backupName, table, disk, part); err != nil {
+					return err
+				}
+				b.restorePipeline.addPendingPart(table, disk, part)
+				attachMx.Lock()
+				defer attachMx.Unlock()
+				// parts downloaded while previous batch attached, will attach together
+				parts := b.restorePipeline.takePendingParts(table)
+				if len(parts) == 0 {
+					return nil
+				}
+				disks, err := b.ch.GetDisks(ctx, true)
+				if err != nil {
+					return err
+				}
+				if err = b.restoreData(ctx, backupName, fmt.Sprintf("%s.%s", table.Database, table.Table), partitions, disks, status.NotFromAPI, onlyStreamedParts(table, parts)); err != nil {
+					return err
+				}
+				dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+				for partDisk, diskParts := range parts {
+					for _, attachedPart := range diskParts {
+						b.restorePipeline.addStreamedPart(table, partDisk, attachedPart)
+						if b.restoreDataMode != filesystemhelper.RestoreDataModeMove {
+							continue
+						}
+						// release staging space, files which can't be moved, like object disk metadata, are not required after attach
+						if err = os.RemoveAll(path.Join(b.getLocalBackupDataPathForTable(backupName, partDisk, dbAndTablePath), attachedPart.Name)); err != nil {
+							log.Warnf("can't remove %s after attach: %v", attachedPart.Name, err)
+						}
+					}
+				}
+				return nil
+			}
+		}
+	}
+	defer func() {
+		b.restorePipeline = nil
+	}()
+	err = b.Download(backupName, tablePattern, partitions, partitionsWhere, false, resume, commandId)
+	// Download saves metadata.json from remote storage after data
+	if b.restoreDataMode == filesystemhelper.RestoreDataModeMove && !b.isEmbedded {
+		if markErr := b.markLocalBackupDataMoved(backupName); markErr != nil {
+			log.Warnf("can't mark %s as moved: %v", backupName, markErr)
+		}
+	}
+	if err == nil {
+		err = b.replayPipelineToTimestamp(backupName, tablePattern, partitions, toTimestamp)
+	}
+	if err == nil {
+		err = b.rebuildPipelineMaterializedViews()
+	}
+	if err != nil {
+		if connectErr := b.ch.Connect(); connectErr == nil {
+			b.rollbackRestore(context.Background())
+			b.ch.Close()
+		} else {
+			log.Warnf("can't connect to clickhouse for rollback: %v", connectErr)
+		}
+		b.removeDownloadedBackupOnRollback(backupName, resume)
+		return err
+	}
+	if b.restorePipeline.restorePart != nil && b.restoreDataMode == filesystemhelper.RestoreDataModeMove {
+		log.Infof("data parts moved from local backup %s during streaming restore, it can't be restored again", backupName)
+	}
+	log.Info("done")
+	return nil
+}
+
+// verifyStreamedPart - check downloaded part with sentinel before attach, backup created without `sentinel_files: true` can't be verified,
+// only checksums.txt of part is checked by restoreData when backup contains part checksums
+func (b *Backuper) verifyStreamedPart(backupName string, table metadata.TableMetadata, disk string, part metadata.Part) error {
+	tableDiskPath := b.getLocalBackupDataPathForTable(backupName, disk, path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table)))
+	sentinel := metadata.BackupSentinel{}
+	if err := sentinel.Load(getBackupSentinelFile(tableDiskPath)); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if b.restorePipeline.isFirstUnverified(table) {
+			b.log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Table)).Warnf("%s created without `sentinel_files: true`, files of streamed parts will attach without verification", backupName)
+		}
+		return nil
+	}
+	if problems := checkSentinelFiles(tableDiskPath, sentinel, map[string]struct{}{part.Name: {}}); len(problems) > 0 {
+		return fmt.Errorf("part %s of %s.%s verification failed: %s", part.Name, table.Database, table.Table, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+func (b *Backuper) rebuildPipelineMaterializedViews() error {
+	if len(b.materializedViewsForRebuild) == 0 && len(b.projectionsForRebuild) == 0 {
+		return nil
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if err := b.rebuildProjections(context.Background(), b.log.WithField("logger", "restoreFromRemotePipeline")); err != nil {
+		return err
+	}
+	return b.rebuildMaterializedViews(context.Background(), b.log.WithField("logger", "restoreFromRemotePipeline"))
+}
+
+func (b *Backuper) replayPipelineToTimestamp(backupName, tablePattern string, partitions []string, toTimestamp string) error {
+	if toTimestamp == "" {
+		return nil
+	}
+	ctx := context.Background()
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	backupMetadata, err := b.ReadBackupMetadataLocal(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	return b.replayToTimestamp(ctx, backupName, tablePattern, partitions, backupMetadata.CreationDate, toTimestamp)
+}
+
+// RestoreFromRemoteOnCluster - restore schema once with ON CLUSTER DDL, then restore data of `<backup_name>-shard<N>` on one replica of each shard via API of clickhouse-backup server,
+// other replicas fetch restored parts through replication, SYSTEM SYNC REPLICA waits for it
+func (b *Backuper) RestoreFromRemoteOnCluster(backupName, tablePattern string, partitions []string, partitionsWhere string, schemaOnly, dataOnly, dropTable, ignoreDependencies, resume bool, commandId int) error {
+	b.setCommandLog(commandId)
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("restore_remote --on-cluster doesn't support remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if b.cfg.ClickHouse.UseEmbeddedBackupRestore {
+		return fmt.Errorf("restore_remote --on-cluster doesn't support use_embedded_backup_restore: true")
+	}
+	// the same expression is forwarded to each shard
+	if partitionsWhere != "" {
+		if err = partition.ValidatePartitionsWhere(partitionsWhere); err != nil {
+			return err
+		}
+	}
+	if backupName, err = b.ResolveExistingBackupName(ctx, "cluster", backupName); err != nil {
+		return err
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	cluster, shards, err := b.getClusterShards(ctx)
+	b.ch.Close()
+	if err != nil {
+		return err
+	}
+	if len(shards) == 0 {
+		return fmt.Errorf("cluster `%s` doesn't contain any shards", cluster)
+	}
+	log := b.log.WithFields(apexLog.Fields{"backup": backupName, "operation": "restore_remote", "cluster": cluster})
+	log.Infof("start on %d shards", len(shards))
+
+	replicatedTables := make([]metadata.TableTitle, 0)
+	if !dataOnly {
+		if replicatedTables, err = b.restoreSchemaOnCluster(ctx, backupName, cluster, shards, tablePattern, dropTable, ignoreDependencies, commandId); err != nil {
 			return err
 		}
 	}
-	return b.Restore(backupName, tablePattern, databaseMapping, partitions, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly, commandId)
+	if schemaOnly {
+		log.Info("done")
+		return nil
+	}
+
+	args := []string{"restore_remote", "--data"}
+	if tablePattern != "" {
+		args = append(args, fmt.Sprintf("--tables=%q", tablePattern))
+	}
+	if len(partitions) > 0 {
+		args = append(args, fmt.Sprintf("--partitions=%q", strings.Join(partitions, ",")))
+	}
+	if partitionsWhere != "" {
+		args = append(args, fmt.Sprintf("--partitions-where=%q", partitionsWhere))
+	}
+	if resume {
+		args = append(args, "--resumable")
+	}
+	if _, err = b.runOnEachShard(ctx, shards, clusterShardCommand{
+		apiCommand: func(shardNum uint32) string {
+			return strings.Join(append(args, clusterShardBackupName(backupName, shardNum)), " ")
+		},
+		local: func(ctx context.Context, shardNum uint32) error {
+			return b.RestoreFromRemote(clusterShardBackupName(backupName, shardNum), tablePattern, nil, nil, partitions, partitionsWhere, "", false, true, false, false, false, false, false, false, resume, false, false, commandId)
+		},
+	}); err != nil {
+		return err
+	}
+	if dataOnly {
+		log.Warn("--data with --on-cluster, data restored only on one replica of each shard, run SYSTEM SYNC REPLICA manually to wait replication")
+	} else if err = b.syncReplicasOnCluster(ctx, cluster, replicatedTables); err != nil {
+		return err
+	}
+	log.Info("done")
+	return nil
+}
+
+// restoreSchemaOnCluster - restore schema from backup of current shard or first shard, with ON CLUSTER DDL, return replicated tables which data will restore on one replica
+func (b *Backuper) restoreSchemaOnCluster(ctx context.Context, backupName, cluster string, shards map[uint32][]clickhouse.ClusterReplica, tablePattern string, dropTable, ignoreDependencies bool, commandId int) ([]metadata.TableTitle, error) {
+	schemaShard, isLocalShard := uint32(0), false
+	for shardNum, replicas := range shards {
+		for _, replica := range replicas {
+			if replica.IsLocal == 1 {
+				schemaShard, isLocalShard = shardNum, true
+			}
+		}
+		if !isLocalShard && (schemaShard == 0 || shardNum < schemaShard) {
+			schemaShard = shardNum
+		}
+	}
+	shardBackupName := clusterShardBackupName(backupName, schemaShard)
+	restoreSchemaOnCluster := b.cfg.General.RestoreSchemaOnCluster
+	b.cfg.General.RestoreSchemaOnCluster = cluster
+	defer func() {
+		b.cfg.General.RestoreSchemaOnCluster = restoreSchemaOnCluster
+	}()
+	b.log.WithField("backup", shardBackupName).Infof("restore schema ON CLUSTER '%s'", cluster)
+	if err := b.RestoreFromRemote(shardBackupName, tablePattern, nil, nil, nil, "", "", true, false, dropTable, ignoreDependencies, false, false, false, false, false, false, false, commandId); err != nil {
+		return nil, err
+	}
+	tables, _, err := b.getTableListByPatternLocal(ctx, path.Join(b.DefaultDataPath, "backup", shardBackupName, "metadata"), tablePattern, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	replicatedTables := make([]metadata.TableTitle, 0)
+	for _, table := range tables {
+		if table.MetadataOnly {
+			continue
+		}
+		if strings.Contains(table.Query, "ENGINE = Replicated") {
+			replicatedTables = append(replicatedTables, metadata.TableTitle{Database: table.Database, Table: table.Table})
+		} else if strings.Contains(table.Query, "MergeTree") {
+			b.log.Warnf("%s.%s is not Replicated*MergeTree, data will restore only on one replica of each shard", table.Database, table.Table)
+		}
+	}
+	// schema only local backup shall be removed, otherwise data restore on the same host will skip download
+	if err = b.RemoveBackupLocal(ctx, shardBackupName, nil); err != nil {
+		return nil, err
+	}
+	return replicatedTables, nil
+}
+
+// syncReplicasOnCluster - wait until all replicas fetch parts attached on one replica of each shard
+func (b *Backuper) syncReplicasOnCluster(ctx context.Context, cluster string, tables []metadata.TableTitle) error {
+	if len(tables) == 0 {
+		return nil
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	for _, table := range tables {
+		query := fmt.Sprintf("SYSTEM SYNC REPLICA ON CLUSTER '%s' `%s`.`%s`", cluster, table.Database, table.Table)
+		if err := b.ch.QueryContext(ctx, query); err != nil {
+			// replication continues in background, even when distributed_ddl_task_timeout exceeded
+			b.log.Warnf("%s return error: %v, check system.replication_queue", query, err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestorePipelineStreamedParts(t *testing.T) {
+	newTables := func() ListOfTables {
+		return ListOfTables{
+			{Database: "db", Table: "t1", Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0", Size: 10}, {Name: "all_2_2_0", Required: true}}}, Mutations: []metadata.MutationMetadata{{MutationId: "1"}}},
+			{Database: "db", Table: "t2", Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}},
+		}
+	}
+	streamed := onlyStreamedParts(newTables()[0], map[string][]metadata.Part{"default": {{Name: "all_1_1_0", Size: 10}}})(newTables())
+	assert.Len(t, streamed, 1)
+	assert.Equal(t, "t1", streamed[0].Table)
+	assert.Equal(t, map[string][]metadata.Part{"default": {{Name: "all_1_1_0", Size: 10}}}, streamed[0].Parts)
+	assert.Empty(t, streamed[0].Mutations)
+	assert.Equal(t, uint64(10), streamed[0].TotalBytes)
+
+	p := &restorePipeline{
+		streamedParts:    make(map[metadata.TableTitle]map[string]struct{}),
+		pendingParts:     make(map[metadata.TableTitle]map[string][]metadata.Part),
+		unverifiedTables: make(map[metadata.TableTitle]struct{}),
+	}
+	p.addPendingPart(newTables()[0], "default", metadata.Part{Name: "all_1_1_0"})
+	p.addPendingPart(newTables()[0], "default", metadata.Part{Name: "all_3_3_0"})
+	assert.Equal(t, map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}, {Name: "all_3_3_0"}}}, p.takePendingParts(newTables()[0]), "parts downloaded during attach shall attach in one batch")
+	assert.Empty(t, p.takePendingParts(newTables()[0]))
+	assert.True(t, p.isFirstUnverified(newTables()[0]))
+	assert.False(t, p.isFirstUnverified(newTables()[0]))
+	p.addStreamedPart(newTables()[0], "default", metadata.Part{Name: "all_1_1_0"})
+	remaining := p.excludeStreamedParts(newTables())
+	assert.Equal(t, []metadata.Part{{Name: "all_2_2_0", Required: true}}, remaining[0].Parts["default"])
+	assert.Len(t, remaining[0].Mutations, 1)
+	assert.Equal(t, []metadata.Part{{Name: "all_1_1_0"}}, remaining[1].Parts["default"])
+}
@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+// restoreRollbackState - track what restore changed, to allow return node to pre-restore state when general->restore_rollback_on_failure: true
+type restoreRollbackState struct {
+	mx            sync.Mutex
+	existsTables  common.EmptyMap
+	createdTables []metadata.TableMetadata
+	// attachedParts - names of parts from system.parts which appeared in already exists tables during restore, ATTACH PART assigns new block numbers, so names differ from backup
+	attachedParts map[metadata.TableTitle][]string
+}
+
+// prepareRestoreRollback - remember tables which exist before restore, these tables will not drop during rollback
+func (b *Backuper) prepareRestoreRollback(ctx context.Context, tablePattern string) error {
+	if !b.cfg.General.RestoreRollbackOnFailure {
+		return nil
+	}
+	if b.isEmbedded {
+		b.log.Warnf("general->restore_rollback_on_failure doesn't support `use_embedded_backup_restore: true`, rollback will skip")
+		return nil
+	}
+	chTables, err := b.ch.GetTables(ctx, tablePattern)
+	if err != nil {
+		return fmt.Errorf("can't get tables for restore rollback: %v", err)
+	}
+	b.restoreRollback = &restoreRollbackState{
+		existsTables:  make(common.EmptyMap, len(chTables)),
+		attachedParts: make(map[metadata.TableTitle][]string),
+	}
+	for _, t := range chTables {
+		b.restoreRollback.existsTables[fmt.Sprintf("%s.%s", t.Database, t.Name)] = struct{}{}
+	}
+	return nil
+}
+
+func (b *Backuper) trackRestoreCreatedTable(table metadata.TableMetadata) {
+	if b.restoreRollback == nil {
+		return
+	}
+	b.restoreRollback.mx.Lock()
+	defer b.restoreRollback.mx.Unlock()
+	if _, exists := b.restoreRollback.existsTables[fmt.Sprintf("%s.%s", table.Database, table.Table)]; !exists {
+		b.restoreRollback.createdTables = append(b.restoreRollback.createdTables, table)
+	}
+}
+
+// getRestoreRollbackParts - active parts of already exists table before restore of its data, nil when table is not tracked
+func (b *Backuper) getRestoreRollbackParts(ctx context.Context, table metadata.TableMetadata) common.EmptyMap {
+	if b.restoreRollback == nil {
+		return nil
+	}
+	b.restoreRollback.mx.Lock()
+	_, exists := b.restoreRollback.existsTables[fmt.Sprintf("%s.%s", table.Database, table.Table)]
+	b.restoreRollback.mx.Unlock()
+	if !exists {
+		return nil
+	}
+	partNames, err := b.ch.GetActivePartNames(ctx, table.Database, table.Table)
+	if err != nil {
+		b.log.Warnf("restore rollback: %v", err)
+		return nil
+	}
+	partsBefore := make(common.EmptyMap, len(partNames))
+	for _, name := range partNames {
+		partsBefore[name] = struct{}{}
+	}
+	return partsBefore
+}
+
+// trackRestoreAttachedParts - remember active parts which appeared after restore of table data, shall be called even when attach failed in the middle
+func (b *Backuper) trackRestoreAttachedParts(ctx context.Context, table metadata.TableMetadata, partsBefore common.EmptyMap) {
+	if b.restoreRollback == nil || partsBefore == nil {
+		return
+	}
+	partNames, err := b.ch.GetActivePartNames(ctx, table.Database, table.Table)
+	if err != nil {
+		b.log.Warnf("restore rollback: %v", err)
+		return
+	}
+	tableTitle := metadata.TableTitle{Database: table.Database, Table: table.Table}
+	b.restoreRollback.mx.Lock()
+	defer b.restoreRollback.mx.Unlock()
+	for _, name := range partNames {
+		if _, existsBefore := partsBefore[name]; !existsBefore {
+			b.restoreRollback.attachedParts[tableTitle] = append(b.restoreRollback.attachedParts[tableTitle], name)
+		}
+	}
+}
+
+// rollbackRestore - detach parts attached to pre-existing tables and drop tables created during restore, all errors only logged,
+// parts which were merged with pre-existing parts after attach can't be detached and only logged
+func (b *Backuper) rollbackRestore(ctx context.Context) {
+	if b.restoreRollback == nil {
+		return
+	}
+	log := b.log.WithField("logger", "rollbackRestore")
+	b.restoreRollback.mx.Lock()
+	defer b.restoreRollback.mx.Unlock()
+	createdTables := make(common.EmptyMap, len(b.restoreRollback.createdTables))
+	for _, t := range b.restoreRollback.createdTables {
+		createdTables[fmt.Sprintf("%s.%s", t.Database, t.Table)] = struct{}{}
+	}
+	for t, partNames := range b.restoreRollback.attachedParts {
+		if _, isCreated := createdTables[fmt.Sprintf("%s.%s", t.Database, t.Table)]; isCreated {
+			continue
+		}
+		for _, partName := range partNames {
+			query := fmt.Sprintf("ALTER TABLE `%s`.`%s` DETACH PART '%s'", t.Database, t.Table, partName)
+			if err := b.ch.QueryContext(ctx, query); err != nil {
+				log.Warnf("can't detach part %s from `%s`.`%s`: %v", partName, t.Database, t.Table, err)
+			}
+		}
+		log.Infof("rollback: %d parts detached from `%s`.`%s`", len(partNames), t.Database, t.Table)
+	}
+	version, err := b.ch.GetVersion(ctx)
+	if err != nil {
+		log.Warnf("can't get clickhouse version, skip drop created tables: %v", err)
+		return
+	}
+	// drop in reverse order, to drop dependent objects first
+	for i := len(b.restoreRollback.createdTables) - 1; i >= 0; i-- {
+		t := b.restoreRollback.createdTables[i]
+		if err = b.ch.DropTable(clickhouse.Table{Database: t.Database, Name: t.Table}, t.Query, b.cfg.General.RestoreSchemaOnCluster, true, version, b.DefaultDataPath); err != nil {
+			log.Warnf("can't drop `%s`.`%s`: %v", t.Database, t.Table, err)
+			continue
+		}
+		log.Infof("rollback: `%s`.`%s` dropped", t.Database, t.Table)
+	}
+	b.restoreRollback = nil
+}
@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/notify"
+	apexLog "github.com/apex/log"
+)
+
+// restoreVerifyState - destination table state before attach, restore could attach parts into non-empty table which was created with other schema
+type restoreVerifyState struct {
+	bytesBefore uint64
+	codecs      map[string]string
+}
+
+// getRestoreVerifyState - remember active parts size and column codecs of destination table before attach
+func (b *Backuper) getRestoreVerifyState(ctx context.Context, dstTable metadata.TableMetadata, log *apexLog.Entry) *restoreVerifyState {
+	if !b.cfg.General.RestoreVerifyCodecs {
+		return nil
+	}
+	state := &restoreVerifyState{}
+	var err error
+	if state.bytesBefore, err = b.ch.GetActivePartsBytes(ctx, dstTable.Database, dstTable.Table); err != nil {
+		log.Warnf("%v", err)
+	}
+	if len(dstTable.ColumnCodecs) > 0 {
+		if state.codecs, err = b.ch.GetColumnCodecs(ctx, dstTable.Database, dstTable.Table); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+	return state
+}
+
+// getCodecsMismatches - columns which will be compressed with other codec than data in backup after merges, empty codec means server default compression,
+// happens when data restored into already exists table or `--restore-table-mapping` points to table with different schema
+func getCodecsMismatches(backupCodecs, restoredCodecs map[string]string) []string {
+	mismatches := make([]string, 0)
+	for column, backupCodec := range backupCodecs {
+		if restoredCodec, exists := restoredCodecs[column]; exists && restoredCodec != backupCodec {
+			mismatches = append(mismatches, fmt.Sprintf("column %s has codec `%s` in backup, but `%s` after restore", column, backupCodec, restoredCodec))
+		}
+	}
+	return mismatches
+}
+
+// getExpectedPartsBytes - size of backup parts, 0 when size can't be verified, object disk parts contain only metadata of remote objects
+func getExpectedPartsBytes(table metadata.TableMetadata, diskTypes map[string]string) uint64 {
+	expectedBytes := uint64(0)
+	for disk, parts := range table.Parts {
+		if diskTypes[disk] == "s3" || diskTypes[disk] == "azure_blob_storage" {
+			return 0
+		}
+		for _, part := range parts {
+			// backup created by old version doesn't contain part size
+			if part.Size <= 0 {
+				return 0
+			}
+			expectedBytes += uint64(part.Size)
+		}
+	}
+	return expectedBytes
+}
+
+// verifyRestoredTable - compare column codecs with backup metadata and size of attached parts with size of backup parts,
+// different result means the target server recompressed data differently than expected, `restore_verify_mismatch` notification sends for each table with problems
+func (b *Backuper) verifyRestoredTable(ctx context.Context, backupName string, dstTable metadata.TableMetadata, state *restoreVerifyState, diskTypes map[string]string, log *apexLog.Entry) {
+	if state == nil {
+		return
+	}
+	mismatches := getCodecsMismatches(dstTable.ColumnCodecs, state.codecs)
+	if expectedBytes := getExpectedPartsBytes(dstTable, diskTypes); expectedBytes > 0 {
+		bytesAfter, err := b.ch.GetActivePartsBytes(ctx, dstTable.Database, dstTable.Table)
+		if err != nil {
+			log.Warnf("%v", err)
+		} else {
+			attachedBytes := float64(bytesAfter) - float64(state.bytesBefore)
+			if diff := math.Abs(attachedBytes-float64(expectedBytes)) / float64(expectedBytes); diff > b.cfg.General.RestoreSizeTolerance {
+				mismatches = append(mismatches, fmt.Sprintf("attached parts size %.0f bytes differs from backup parts size %d bytes by %.2f%%, more than restore_verify_size_tolerance=%.2f%%, data could be recompressed differently or merged during restore", attachedBytes, expectedBytes, diff*100, b.cfg.General.RestoreSizeTolerance*100))
+			}
+		}
+	}
+	if len(mismatches) == 0 {
+		return
+	}
+	for _, mismatch := range mismatches {
+		log.Warn(mismatch)
+	}
+	b.notifier.Send(ctx, notify.Event{
+		Event:     notify.EventRestoreVerifyMismatch,
+		Operation: "restore",
+		Backup:    backupName,
+		Error:     fmt.Sprintf("`%s`.`%s`: %s", dstTable.Database, dstTable.Table, strings.Join(mismatches, "; ")),
+	})
+}
@@ -0,0 +1,20 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreVerify(t *testing.T) {
+	backupCodecs := map[string]string{"id": "", "payload": "CODEC(ZSTD(3))"}
+	assert.Empty(t, getCodecsMismatches(backupCodecs, map[string]string{"id": "", "payload": "CODEC(ZSTD(3))", "new_column": "CODEC(LZ4)"}))
+	assert.Equal(t, []string{"column payload has codec `CODEC(ZSTD(3))` in backup, but `` after restore"}, getCodecsMismatches(backupCodecs, map[string]string{"id": "", "payload": ""}))
+
+	table := metadata.TableMetadata{Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0", Size: 100}, {Name: "all_2_2_0", Size: 50}}}}
+	assert.Equal(t, uint64(150), getExpectedPartsBytes(table, map[string]string{"default": "local"}))
+	assert.Equal(t, uint64(0), getExpectedPartsBytes(table, map[string]string{"default": "s3"}), "object disk parts size can't be verified")
+	table.Parts["default"][1].Size = 0
+	assert.Equal(t, uint64(0), getExpectedPartsBytes(table, map[string]string{"default": "local"}), "backup without part size can't be verified")
+}
@@ -0,0 +1,35 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+)
+
+// cleanupTimeout - how long cleanup after canceled command could run, SIGTERM and POST /backup/actions/{job_id}/cancel cancel command context
+var cleanupTimeout = 5 * time.Minute
+
+// newCleanupContext - canceled ctx can't be used to unfreeze tables or remove partial backup, returned context keeps ctx values, like correlation_id, but not cancellation
+func newCleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+}
+
+// cleanupCanceledUpload - with resumable state append upload.state to remote storage, next upload with the same backup name continue from other host too,
+// otherwise remove partially uploaded backup, it doesn't contain metadata.json and can't be restored
+func (b *Backuper) cleanupCanceledUpload(ctx context.Context, backupMetadata *metadata.BackupMetadata, remoteState *remoteUploadState) {
+	cleanupCtx, cancel := newCleanupContext(ctx)
+	defer cancel()
+	if b.resume {
+		b.resumableState.Close()
+		b.uploadUploadState(cleanupCtx, backupMetadata.BackupName, remoteState)
+		b.log.Warnf("upload canceled, run `clickhouse-backup upload %s` with the same parameters to continue", backupMetadata.BackupName)
+		return
+	}
+	if err := b.dst.RemoveBackup(cleanupCtx, storage.Backup{BackupMetadata: *backupMetadata}); err != nil {
+		b.log.Warnf("upload canceled, can't remove partially uploaded %s: %v", backupMetadata.BackupName, err)
+		return
+	}
+	b.log.Warnf("upload canceled, partially uploaded %s removed from remote storage", backupMetadata.BackupName)
+}
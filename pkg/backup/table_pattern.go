@@ -25,6 +25,34 @@ import (
 
 type ListOfTables []metadata.TableMetadata
 
+// WithExcludeTables - `--exclude-tables`, comma separated patterns which are added to clickhouse->skip_tables only for this Backuper,
+// so exclude patterns always have precedence over `--tables` and clickhouse->include_tables
+func WithExcludeTables(excludeTables string) BackuperOpt {
+	return func(b *Backuper) {
+		if strings.Trim(excludeTables, " \t\r\n") == "" {
+			return
+		}
+		cfg := *b.cfg
+		cfg.ClickHouse.SkipTables = append([]string{}, b.cfg.ClickHouse.SkipTables...)
+		for _, pattern := range strings.Split(excludeTables, ",") {
+			if pattern = strings.Trim(pattern, " \t\r\n"); pattern != "" {
+				cfg.ClickHouse.SkipTables = append(cfg.ClickHouse.SkipTables, pattern)
+			}
+		}
+		b.cfg = &cfg
+		b.ch.Config = &b.cfg.ClickHouse
+		b.excludeTables = excludeTables
+	}
+}
+
+// tablePatternOrDefault - `--tables` has precedence, clickhouse->include_tables is used only when `--tables` is empty
+func (b *Backuper) tablePatternOrDefault(tablePattern string) string {
+	if tablePattern == "" && len(b.cfg.ClickHouse.IncludeTables) > 0 {
+		return strings.Join(b.cfg.ClickHouse.IncludeTables, ",")
+	}
+	return tablePattern
+}
+
 // Sort - sorting ListOfTables slice orderly by engine priority
 func (lt ListOfTables) Sort(dropTable bool) {
 	sort.Slice(lt, func(i, j int) bool {
@@ -32,6 +60,33 @@ func (lt ListOfTables) Sort(dropTable bool) {
 	})
 }
 
+// SortByRestorePriority - stable sorting ListOfTables slice, tables which matched with earlier priority patterns go first, tables without match go last
+// orderBySize could be `asc` or `desc`, then tables inside the same priority group will sort by TotalBytes
+func (lt ListOfTables) SortByRestorePriority(priorityPatterns []string, orderBySize string) {
+	getPriority := func(t metadata.TableMetadata) int {
+		tableName := fmt.Sprintf("%s.%s", t.Database, t.Table)
+		for i, pattern := range priorityPatterns {
+			if matched, _ := filepath.Match(strings.Trim(pattern, " \t\r\n"), tableName); matched {
+				return i
+			}
+		}
+		return len(priorityPatterns)
+	}
+	sort.SliceStable(lt, func(i, j int) bool {
+		iPriority, jPriority := getPriority(lt[i]), getPriority(lt[j])
+		if iPriority != jPriority {
+			return iPriority < jPriority
+		}
+		switch orderBySize {
+		case "asc":
+			return lt[i].TotalBytes < lt[j].TotalBytes
+		case "desc":
+			return lt[i].TotalBytes > lt[j].TotalBytes
+		}
+		return false
+	})
+}
+
 func addTableToListIfNotExistsOrEnrichQueryAndParts(tables ListOfTables, table metadata.TableMetadata) ListOfTables {
 	for i, t := range tables {
 		if (t.Database == table.Database) && (t.Table == table.Table) {
@@ -98,8 +153,9 @@ func (b *Backuper) getTableListByPatternLocal(ctx context.Context, metadataPath
 					return err
 				}
 				// .sql file will enrich Query
-				partitionsIdMap, _ := partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, nil, []metadata.TableMetadata{t}, partitions)
-				filterPartsAndFilesByPartitionsFilter(t, partitionsIdMap[metadata.TableTitle{Database: t.Database, Table: t.Table}])
+				if _, err = b.filterPartsAndFilesByPartitions(ctx, t, partitions); err != nil {
+					return err
+				}
 				result = addTableToListIfNotExistsOrEnrichQueryAndParts(result, t)
 				return nil
 			}
@@ -107,8 +163,10 @@ func (b *Backuper) getTableListByPatternLocal(ctx context.Context, metadataPath
 			if err := json.Unmarshal(data, &t); err != nil {
 				return err
 			}
-			partitionsIdMap, partitionsNameList := partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, nil, []metadata.TableMetadata{t}, partitions)
-			filterPartsAndFilesByPartitionsFilter(t, partitionsIdMap[metadata.TableTitle{Database: t.Database, Table: t.Table}])
+			partitionsNameList, err := b.filterPartsAndFilesByPartitions(ctx, t, partitions)
+			if err != nil {
+				return err
+			}
 			result = addTableToListIfNotExistsOrEnrichQueryAndParts(result, t)
 			for tt := range partitionsNameList {
 				if _, exists := resultPartitionNames[tt]; !exists {
@@ -279,6 +337,45 @@ var uuidRE = regexp.MustCompile(`UUID '([a-f\d\-]+)'`)
 var replicatedRE = regexp.MustCompile(`(Replicated[a-zA-Z]*MergeTree)\('([^']+)'([^)]+)\)`)
 var distributedRE = regexp.MustCompile(`(Distributed)\(([^,]+),([^,]+),([^)]+)\)`)
 
+func changeTableQueryToAdjustTableMapping(originTables *ListOfTables, tableMapRule map[string]string) error {
+	for i := 0; i < len(*originTables); i++ {
+		originTable := (*originTables)[i]
+		if targetTable, isMapped := tableMapRule[originTable.Table]; isMapped {
+			// substitute table name in the table create query
+			if !createOrAttachRE.MatchString(originTable.Query) {
+				if originTable.Query == "" {
+					continue
+				}
+				return fmt.Errorf("error when try to replace table `%s` to `%s` in query: %s", originTable.Table, targetTable, originTable.Query)
+			}
+			matches := queryRE.FindAllStringSubmatch(originTable.Query, -1)
+			if matches[0][6] != originTable.Table {
+				return fmt.Errorf("invalid SQL: %s for restore-table-mapping[%s]=%s", originTable.Query, originTable.Table, targetTable)
+			}
+			substitution := fmt.Sprintf("${1} ${2} ${3}${4}${5}.%v${7}${8}${9}${10}${11}${12}${13}${14}${15}${16}${17}", targetTable)
+			originTable.Query = queryRE.ReplaceAllString(originTable.Query, substitution)
+			if len(uuidRE.FindAllString(originTable.Query, -1)) > 0 {
+				newUUID, _ := uuid.NewUUID()
+				substitution = fmt.Sprintf("UUID '%s'", newUUID.String())
+				originTable.Query = uuidRE.ReplaceAllString(originTable.Query, substitution)
+			}
+			// avoid share the same replication path with origin table
+			if replicatedRE.MatchString(originTable.Query) {
+				matches := replicatedRE.FindAllStringSubmatch(originTable.Query, -1)
+				originPath := matches[0][2]
+				tableReplicatedPattern := "/" + originTable.Table
+				if strings.Contains(originPath, tableReplicatedPattern) {
+					substitution = fmt.Sprintf("${1}('%s'${3})", strings.Replace(originPath, tableReplicatedPattern, "/"+targetTable, 1))
+					originTable.Query = replicatedRE.ReplaceAllString(originTable.Query, substitution)
+				}
+			}
+			originTable.Table = targetTable
+			(*originTables)[i] = originTable
+		}
+	}
+	return nil
+}
+
 func changeTableQueryToAdjustDatabaseMapping(originTables *ListOfTables, dbMapRule map[string]string) error {
 	for i := 0; i < len(*originTables); i++ {
 		originTable := (*originTables)[i]
@@ -342,25 +439,71 @@ func changeTableQueryToAdjustDatabaseMapping(originTables *ListOfTables, dbMapRu
 
 func filterPartsAndFilesByPartitionsFilter(tableMetadata metadata.TableMetadata, partitionsFilter common.EmptyMap) {
 	if len(partitionsFilter) > 0 {
-		for disk, parts := range tableMetadata.Parts {
-			filteredParts := make([]metadata.Part, 0)
-			for _, part := range parts {
-				if filesystemhelper.IsPartInPartition(part.Name, partitionsFilter) {
-					filteredParts = append(filteredParts, part)
-				}
+		filterPartsAndFilesByPartitionIds(tableMetadata, partitionsFilter)
+	}
+}
+
+// filterPartsAndFilesByPartitionIds - keep only parts and files from partitionsFilter, empty partitionsFilter means remove all parts and files,
+// EngineData tables are not partitioned, so engine_data_* pseudo part is always kept
+func filterPartsAndFilesByPartitionIds(tableMetadata metadata.TableMetadata, partitionsFilter common.EmptyMap) {
+	if tableMetadata.EngineData != nil {
+		return
+	}
+	for disk, parts := range tableMetadata.Parts {
+		filteredParts := make([]metadata.Part, 0)
+		for _, part := range parts {
+			if filesystemhelper.IsPartInPartition(part.Name, partitionsFilter) {
+				filteredParts = append(filteredParts, part)
 			}
-			tableMetadata.Parts[disk] = filteredParts
 		}
-		for disk, files := range tableMetadata.Files {
-			filteredFiles := make([]string, 0)
-			for _, file := range files {
-				if filesystemhelper.IsFileInPartition(disk, file, partitionsFilter) {
-					filteredFiles = append(filteredFiles, file)
-				}
+		tableMetadata.Parts[disk] = filteredParts
+	}
+	for disk, files := range tableMetadata.Files {
+		filteredFiles := make([]string, 0)
+		for _, file := range files {
+			if filesystemhelper.IsFileInPartition(disk, file, partitionsFilter) {
+				filteredFiles = append(filteredFiles, file)
 			}
-			tableMetadata.Files[disk] = filteredFiles
+		}
+		tableMetadata.Files[disk] = filteredFiles
+	}
+}
+
+// filterPartsAndFilesByPartitions - apply --partitions and --partitions-where filters to table metadata, return partition names for embedded restore
+func (b *Backuper) filterPartsAndFilesByPartitions(ctx context.Context, tableMetadata metadata.TableMetadata, partitions []string) (map[metadata.TableTitle][]string, error) {
+	tableTitle := metadata.TableTitle{Database: tableMetadata.Database, Table: tableMetadata.Table}
+	partitionsIdMap, partitionsNameList := partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, nil, []metadata.TableMetadata{tableMetadata}, partitions)
+	filterPartsAndFilesByPartitionsFilter(tableMetadata, partitionsIdMap[tableTitle])
+	if b.partitionsWhere == "" && !partition.HasGlobPattern(partitions) {
+		return partitionsNameList, nil
+	}
+	partitionIds := getPartitionIdsFromParts(tableMetadata)
+	if b.partitionsWhere != "" {
+		matchedIds, err := partition.FilterPartitionIdsByExpression(ctx, b.ch, partitionIds, b.partitionsWhere)
+		if err != nil {
+			return nil, err
+		}
+		filterPartsAndFilesByPartitionIds(tableMetadata, matchedIds)
+		partitionIds = getPartitionIdsFromParts(tableMetadata)
+	}
+	// glob patterns and expression can't pass to RESTORE ... PARTITIONS, so use partition_id of matched parts, which the same as partition name for numeric partitions
+	partitionsNameList[tableTitle] = partitionIds
+	return partitionsNameList, nil
+}
+
+// getPartitionIdsFromParts - sorted unique partition_id for all parts in table metadata
+func getPartitionIdsFromParts(tableMetadata metadata.TableMetadata) []string {
+	partitionIds := make([]string, 0)
+	if tableMetadata.EngineData != nil {
+		return partitionIds
+	}
+	for _, parts := range tableMetadata.Parts {
+		for _, part := range parts {
+			partitionIds = common.AddStringToSliceIfNotExists(partitionIds, strings.Split(part.Name, "_")[0])
 		}
 	}
+	sort.Strings(partitionIds)
+	return partitionIds
 }
 
 func getTableListByPatternRemote(ctx context.Context, b *Backuper, remoteBackupMetadata *metadata.BackupMetadata, tablePattern string, dropTable bool) (ListOfTables, error) {
@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortByRestorePriority(t *testing.T) {
+	tables := func() ListOfTables {
+		return ListOfTables{
+			{Database: "db1", Table: "small", TotalBytes: 10},
+			{Database: "db1", Table: "big", TotalBytes: 1000},
+			{Database: "db2", Table: "critical", TotalBytes: 500},
+			{Database: "db3", Table: "medium", TotalBytes: 100},
+		}
+	}
+	names := func(lt ListOfTables) []string {
+		result := make([]string, len(lt))
+		for i, t := range lt {
+			result[i] = t.Database + "." + t.Table
+		}
+		return result
+	}
+	testCases := []struct {
+		name        string
+		priority    []string
+		orderBySize string
+		expected    []string
+	}{
+		{
+			name:     "without priority keep original order",
+			expected: []string{"db1.small", "db1.big", "db2.critical", "db3.medium"},
+		},
+		{
+			name:     "priority patterns go first",
+			priority: []string{"db2.critical", "db3.*"},
+			expected: []string{"db2.critical", "db3.medium", "db1.small", "db1.big"},
+		},
+		{
+			name:        "order by size asc inside the same priority group",
+			priority:    []string{"db2.*"},
+			orderBySize: "asc",
+			expected:    []string{"db2.critical", "db1.small", "db3.medium", "db1.big"},
+		},
+		{
+			name:        "order by size desc without priority",
+			orderBySize: "desc",
+			expected:    []string{"db1.big", "db2.critical", "db3.medium", "db1.small"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lt := tables()
+			lt.SortByRestorePriority(tc.priority, tc.orderBySize)
+			assert.Equal(t, tc.expected, names(lt))
+		})
+	}
+}
+
+func TestFilterPartsByPartitionGlob(t *testing.T) {
+	tm := metadata.TableMetadata{
+		Database: "db1",
+		Table:    "t1",
+		Parts: map[string][]metadata.Part{
+			"default": {{Name: "2023-01-15_1_1_0"}, {Name: "2023-06-01_2_2_0"}, {Name: "2023-07-01_3_3_0"}, {Name: "2024-01-01_4_4_0"}},
+		},
+		Files: map[string][]string{
+			"default": {"default_2023-01-15_1_1_0.tar", "default_2023-07-01_3_3_0.tar"},
+		},
+	}
+	filterPartsAndFilesByPartitionsFilter(tm, common.EmptyMap{"2023-0[1-6]*": {}})
+	assert.Equal(t, []string{"2023-01-15", "2023-06-01"}, getPartitionIdsFromParts(tm))
+	assert.Equal(t, []string{"default_2023-01-15_1_1_0.tar"}, tm.Files["default"])
+
+	filterPartsAndFilesByPartitionIds(tm, common.EmptyMap{})
+	assert.Empty(t, getPartitionIdsFromParts(tm))
+}
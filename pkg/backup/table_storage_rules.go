@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+// getTableStorageRule - first general->table_storage_rules item which `tables` patterns match db.table, nil when no rule matched
+func (b *Backuper) getTableStorageRule(database, table string) *config.TableStorageRule {
+	tableName := fmt.Sprintf("%s.%s", database, table)
+	for i, rule := range b.cfg.General.TableStorageRules {
+		for _, pattern := range strings.Split(rule.Tables, ",") {
+			if matched, _ := filepath.Match(strings.Trim(pattern, " \t\r\n"), tableName); matched {
+				return &b.cfg.General.TableStorageRules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// getTableStorageClass - empty string means storage_class from remote storage config section
+func (b *Backuper) getTableStorageClass(table metadata.TableMetadata) string {
+	if rule := b.getTableStorageRule(table.Database, table.Table); rule != nil {
+		return rule.StorageClass
+	}
+	return ""
+}
+
+// applyTableStoragePathPrefixes - fill RemotePathPrefix for tables which data will upload, return sorted distinct prefixes for metadata.json
+// embedded backups data uploaded by clickhouse-server as is, so path_prefix is not applicable
+func (b *Backuper) applyTableStoragePathPrefixes(tables ListOfTables) []string {
+	if b.isEmbedded {
+		return nil
+	}
+	prefixes := make(map[string]struct{})
+	for i := range tables {
+		tables[i].RemotePathPrefix = ""
+		if tables[i].MetadataOnly {
+			continue
+		}
+		if rule := b.getTableStorageRule(tables[i].Database, tables[i].Table); rule != nil && rule.PathPrefix != "" {
+			tables[i].RemotePathPrefix = strings.Trim(rule.PathPrefix, "/")
+			prefixes[tables[i].RemotePathPrefix] = struct{}{}
+		}
+	}
+	if len(prefixes) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		result = append(result, prefix)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// remoteTableDataPath - `<backup_name>/shadow/<db>/<table>`, prepended with RemotePathPrefix when table uploaded with general->table_storage_rules path_prefix
+func remoteTableDataPath(backupName string, table metadata.TableMetadata) string {
+	return path.Join(table.RemotePathPrefix, backupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+}
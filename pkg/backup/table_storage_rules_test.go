@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyTableStoragePathPrefixes(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.TableStorageRules = []config.TableStorageRule{
+		{Tables: "logs.*, db.history_*", StorageClass: "GLACIER", PathPrefix: "archive/"},
+		{Tables: "db.*", StorageClass: "STANDARD_IA"},
+	}
+	b := &Backuper{cfg: cfg}
+	tables := ListOfTables{
+		{Database: "db", Table: "history_2023"},
+		{Database: "db", Table: "events"},
+		{Database: "logs", Table: "access", MetadataOnly: true},
+		{Database: "other", Table: "t1", RemotePathPrefix: "downloaded"},
+	}
+	assert.Equal(t, []string{"archive"}, b.applyTableStoragePathPrefixes(tables))
+	assert.Equal(t, "archive", tables[0].RemotePathPrefix)
+	assert.Equal(t, "", tables[1].RemotePathPrefix)
+	assert.Equal(t, "", tables[2].RemotePathPrefix)
+	assert.Equal(t, "", tables[3].RemotePathPrefix, "prefix from downloaded backup shall be recalculated with current rules")
+
+	assert.Equal(t, "GLACIER", b.getTableStorageClass(tables[0]))
+	assert.Equal(t, "STANDARD_IA", b.getTableStorageClass(tables[1]))
+	assert.Equal(t, "", b.getTableStorageClass(tables[3]))
+
+	assert.Equal(t, "archive/backup1/shadow/db/history_2023", remoteTableDataPath("backup1", tables[0]))
+	assert.Equal(t, "backup1/shadow/db/events", remoteTableDataPath("backup1", metadata.TableMetadata{Database: "db", Table: "events"}))
+
+	b.isEmbedded = true
+	assert.Nil(t, b.applyTableStoragePathPrefixes(tables))
+}
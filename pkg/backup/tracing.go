@@ -0,0 +1,24 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Altinity/clickhouse-backup/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+func tableAttribute(database, table string) attribute.KeyValue {
+	return attribute.String("table", fmt.Sprintf("%s.%s", database, table))
+}
+
+// goWithSpan - run fn in errgroup inside child span, part slot is already acquired when span starts, so span measures only processing time
+func goWithSpan(ctx context.Context, g *errgroup.Group, name string, attributes []attribute.KeyValue, fn func(ctx context.Context) error) {
+	spanCtx, span := tracing.Start(ctx, name, attributes...)
+	g.Go(func() error {
+		err := fn(spanCtx)
+		span.End(err)
+		return err
+	})
+}
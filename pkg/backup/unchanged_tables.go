@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+// WithSkipUnchangedFrom - `--skip-unchanged-from`, local backup which parts are hardlinked into new backup without FREEZE for tables which data didn't change,
+// `upload --diff-from` with the same backup will skip upload of these parts
+func WithSkipUnchangedFrom(backupName string) BackuperOpt {
+	return func(b *Backuper) {
+		b.skipUnchangedFrom = strings.Trim(backupName, " \t\r\n")
+	}
+}
+
+// getUnchangedTable - metadata of table from b.skipUnchangedFrom backup when its DataFingerprint is the same as current, nil means table shall be frozen,
+// tables with parts on object disks are always frozen, cause their objects are copied under backup name in object_disk_path
+func (b *Backuper) getUnchangedTable(defaultPath string, table clickhouse.Table, fingerprint *metadata.DataFingerprint, partitionsIdsMap common.EmptyMap, diskTypes map[string]string) (*metadata.TableMetadata, error) {
+	if b.skipUnchangedFrom == "" || fingerprint == nil || len(partitionsIdsMap) > 0 {
+		return nil, nil
+	}
+	referenceMetadataFile := path.Join(defaultPath, "backup", b.skipUnchangedFrom, "metadata", common.TablePathEncode(table.Database), fmt.Sprintf("%s.json", common.TablePathEncode(table.Name)))
+	if _, err := os.Stat(referenceMetadataFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+	referenceTable := metadata.TableMetadata{}
+	if _, err := referenceTable.Load(referenceMetadataFile); err != nil {
+		return nil, fmt.Errorf("can't read %s: %v", referenceMetadataFile, err)
+	}
+	if referenceTable.MetadataOnly || referenceTable.DataFingerprint == nil || !referenceTable.DataFingerprint.Equal(*fingerprint) {
+		return nil, nil
+	}
+	if referenceTable.UUID != table.UUID || referenceTable.PartHashAlgorithm != b.cfg.General.PartHashAlgorithm {
+		return nil, nil
+	}
+	for disk := range referenceTable.Parts {
+		if diskType, exists := diskTypes[disk]; !exists || diskType == "s3" || diskType == "azure_blob_storage" {
+			return nil, nil
+		}
+	}
+	return &referenceTable, nil
+}
+
+// linkUnchangedTable - hardlink parts of referenceTable from b.skipUnchangedFrom backup instead of FREEZE, files inside backup are never modified, so they could be shared
+func (b *Backuper) linkUnchangedTable(backupName string, referenceTable metadata.TableMetadata, disks []clickhouse.Disk) (map[string][]metadata.Part, map[string]int64, error) {
+	dbAndTablePath := path.Join(common.TablePathEncode(referenceTable.Database), common.TablePathEncode(referenceTable.Table))
+	realSize := map[string]int64{}
+	disksToPartsMap := map[string][]metadata.Part{}
+	for _, disk := range disks {
+		parts, exists := referenceTable.Parts[disk.Name]
+		if !exists {
+			continue
+		}
+		referencePath := path.Join(disk.Path, "backup", b.skipUnchangedFrom, "shadow", dbAndTablePath, disk.Name)
+		backupShadowPath := path.Join(disk.Path, "backup", backupName, "shadow", dbAndTablePath, disk.Name)
+		linkedParts := make([]metadata.Part, len(parts))
+		for i, part := range parts {
+			err := filepath.Walk(path.Join(referencePath, part.Name), func(filePath string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				dstFilePath := path.Join(backupShadowPath, filesystemhelper.RelativePath(referencePath, filePath))
+				if info.IsDir() {
+					return os.MkdirAll(dstFilePath, 0750)
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				realSize[disk.Name] += info.Size()
+				return os.Link(filePath, dstFilePath)
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			linkedParts[i] = part
+			// parts of downloaded incremental backup are present locally
+			linkedParts[i].Required = false
+			linkedParts[i].RequiredBackup = ""
+		}
+		disksToPartsMap[disk.Name] = linkedParts
+	}
+	return disksToPartsMap, realSize, nil
+}
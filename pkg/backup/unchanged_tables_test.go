@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipUnchangedTables(t *testing.T) {
+	defaultPath := t.TempDir()
+	disks := []clickhouse.Disk{{Name: "default", Path: defaultPath, Type: "local"}}
+	diskTypes := map[string]string{"default": "local"}
+	fingerprint := metadata.DataFingerprint{Parts: 1, Rows: 10, Bytes: 100, MaxModificationTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), MaxDataVersion: 1}
+	referenceTable := metadata.TableMetadata{
+		Database:        "db",
+		Table:           "t1",
+		UUID:            "00000000-0000-0000-0000-000000000001",
+		Parts:           map[string][]metadata.Part{"default": {{Name: "all_1_1_0", Required: true, RequiredBackup: "full"}}},
+		DataFingerprint: &fingerprint,
+	}
+	metadataFile := path.Join(defaultPath, "backup", "reference", "metadata", "db", "t1.json")
+	require.NoError(t, os.MkdirAll(path.Dir(metadataFile), 0750))
+	body, err := json.Marshal(referenceTable)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(metadataFile, body, 0640))
+	partPath := path.Join(defaultPath, "backup", "reference", "shadow", "db", "t1", "default", "all_1_1_0")
+	require.NoError(t, os.MkdirAll(path.Join(partPath, "p1.proj"), 0750))
+	require.NoError(t, os.WriteFile(path.Join(partPath, "data.bin"), []byte("data"), 0640))
+	require.NoError(t, os.WriteFile(path.Join(partPath, "p1.proj", "data.bin"), []byte("projection"), 0640))
+
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.WithField("logger", "test")}
+	b.skipUnchangedFrom = "reference"
+	table := clickhouse.Table{Database: "db", Name: "t1", UUID: referenceTable.UUID}
+
+	unchangedTable, err := b.getUnchangedTable(defaultPath, table, &fingerprint, nil, diskTypes)
+	require.NoError(t, err)
+	require.NotNil(t, unchangedTable)
+
+	changed := fingerprint
+	changed.Rows++
+	unchangedTable, err = b.getUnchangedTable(defaultPath, table, &changed, nil, diskTypes)
+	require.NoError(t, err)
+	assert.Nil(t, unchangedTable, "changed rows shall FREEZE table")
+	unchangedTable, err = b.getUnchangedTable(defaultPath, table, &fingerprint, common.EmptyMap{"all": {}}, diskTypes)
+	require.NoError(t, err)
+	assert.Nil(t, unchangedTable, "--partitions shall FREEZE table")
+	unchangedTable, err = b.getUnchangedTable(defaultPath, table, &fingerprint, nil, map[string]string{"default": "s3"})
+	require.NoError(t, err)
+	assert.Nil(t, unchangedTable, "object disk parts shall be copied")
+	unchangedTable, err = b.getUnchangedTable(defaultPath, clickhouse.Table{Database: "db", Name: "t2"}, &fingerprint, nil, diskTypes)
+	require.NoError(t, err)
+	assert.Nil(t, unchangedTable, "table absent in reference backup shall FREEZE")
+
+	unchangedTable, err = b.getUnchangedTable(defaultPath, table, &fingerprint, nil, diskTypes)
+	require.NoError(t, err)
+	parts, realSize, err := b.linkUnchangedTable("new", *unchangedTable, disks)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}, parts)
+	assert.Equal(t, map[string]int64{"default": int64(len("data") + len("projection"))}, realSize)
+	linkedPath := path.Join(defaultPath, "backup", "new", "shadow", "db", "t1", "default", "all_1_1_0")
+	for _, file := range []string{"data.bin", path.Join("p1.proj", "data.bin")} {
+		referenceInfo, err := os.Stat(path.Join(partPath, file))
+		require.NoError(t, err)
+		linkedInfo, err := os.Stat(path.Join(linkedPath, file))
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(referenceInfo, linkedInfo), "%s shall be hardlinked", file)
+	}
+}
@@ -10,16 +10,22 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"github.com/Altinity/clickhouse-backup/pkg/custom"
 	"github.com/Altinity/clickhouse-backup/pkg/resumable"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/tracing"
 	"github.com/eapache/go-resiliency/retrier"
+	"go.opentelemetry.io/otel/attribute"
 
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
@@ -32,7 +38,11 @@ import (
 	"github.com/yargevad/filepathx"
 )
 
-func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) error {
+const uploadStateFile = "upload.state"
+
+func (b *Backuper) uploadToRemote(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) (err error) {
+	b.setCommandLog(commandId)
+	b.applyResourceLimits()
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -41,12 +51,21 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 	defer cancel()
 
 	startUpload := time.Now()
-	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName != "" {
+		if backupName, err = b.ResolveExistingBackupName(ctx, "local", backupName); err != nil {
+			return err
+		}
+	}
 	var disks []clickhouse.Disk
 	if !resume && b.cfg.General.UseResumableState {
 		resume = true
 	}
 	b.resume = resume
+	b.setLogComment("upload", backupName, commandId)
+	ctx, span := tracing.Start(ctx, "upload", attribute.String("backup", backupName), attribute.String("remote_storage", b.cfg.General.RemoteStorage))
+	defer func() {
+		span.End(err)
+	}()
 	if err = b.ch.Connect(); err != nil {
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
@@ -55,6 +74,9 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		return err
 	}
 	if b.cfg.General.RemoteStorage == "custom" {
+		if b.dryRun {
+			return fmt.Errorf("--dry-run is not supported for remote_storage: custom")
+		}
 		return custom.Upload(ctx, b.cfg, backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly)
 	}
 	log := apexLog.WithFields(apexLog.Fields{
@@ -72,6 +94,17 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 			b.log.Warnf("can't close BackupDestination error: %v", err)
 		}
 	}()
+	if b.teeMirrors != nil {
+		b.initTeeMirrors(ctx, backupName)
+		defer b.closeTeeMirrors(ctx)
+	}
+	zstdDictionary, err := b.setZstdDictionaryForUpload(log)
+	if err != nil {
+		return err
+	}
+	if zstdMemory := storage.ZstdEncoderMemory(b.cfg.General.ZstdWindowLog, int(b.cfg.General.UploadConcurrency)); zstdMemory > 0 && b.cfg.GetCompressionFormat() == "zstd" {
+		log.Infof("zstd_window_log=%d compress each archive in single thread, requires about %s memory for upload_concurrency=%d", b.cfg.General.ZstdWindowLog, utils.FormatBytes(zstdMemory), b.cfg.General.UploadConcurrency)
+	}
 
 	remoteBackups, err := b.dst.BackupList(ctx, false, "")
 	if err != nil {
@@ -81,6 +114,8 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		if backupName == remoteBackups[i].BackupName {
 			if !b.resume {
 				return fmt.Errorf("'%s' already exists on remote storage", backupName)
+			} else if b.teeMirrors != nil {
+				return errTeeResume
 			} else {
 				log.Warnf("'%s' already exists on remote, will try to resume upload", backupName)
 			}
@@ -90,6 +125,9 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 	if err != nil {
 		return fmt.Errorf("b.ReadBackupMetadataLocal return error: %v", err)
 	}
+	if err = checkLocalBackupDataMoved(backupMetadata); err != nil {
+		return err
+	}
 	var tablesForUpload ListOfTables
 	b.isEmbedded = strings.Contains(backupMetadata.Tags, "embedded")
 	// will ignore partitions cause can't manipulate .backup
@@ -116,7 +154,11 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 			return fmt.Errorf("b.getTablesForUploadDiffRemote return error: %v", err)
 		}
 	}
+	if b.dryRun {
+		return b.uploadDryRun(ctx, backupMetadata, tablesForUpload, tablesForUploadFromDiff, schemaOnly, diffFrom != "" && diffFromRemote == "", log)
+	}
 	if b.resume {
+		b.downloadUploadStateIfNotExists(ctx, backupName)
 		b.resumableState = resumable.NewState(b.DefaultDataPath, backupName, "upload", map[string]interface{}{
 			"diffFrom":       diffFrom,
 			"diffFromRemote": diffFromRemote,
@@ -125,13 +167,38 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 			"schemaOnly":     schemaOnly,
 		})
 	}
+	b.dedupChunks = make(map[string]struct{})
+	releaseDedupLease := func() {}
+	if b.cfg.General.DedupStore && !b.isEmbedded && !schemaOnly {
+		if releaseDedupLease, err = b.acquireDedupLease(ctx, backupName); err != nil {
+			return fmt.Errorf("b.acquireDedupLease return error: %v", err)
+		}
+		defer releaseDedupLease()
+	}
+	backupMetadata.RemotePathPrefixes = nil
+	if !schemaOnly {
+		backupMetadata.RemotePathPrefixes = b.applyTableStoragePathPrefixes(tablesForUpload)
+	}
 
 	compressedDataSize := int64(0)
 	metadataSize := int64(0)
 
+	remoteState := &remoteUploadState{}
+	uploadDone := false
+	defer func() {
+		if !uploadDone && ctx.Err() != nil {
+			b.cleanupCanceledUpload(ctx, backupMetadata, remoteState)
+		}
+	}()
 	log.Debugf("prepare table concurrent semaphore with concurrency=%d len(tablesForUpload)=%d", b.cfg.General.UploadConcurrency, len(tablesForUpload))
 	uploadSemaphore := semaphore.NewWeighted(int64(b.cfg.General.UploadConcurrency))
+	b.partsSemaphore = semaphore.NewWeighted(int64(b.cfg.General.UploadConcurrency))
 	uploadGroup, uploadCtx := errgroup.WithContext(ctx)
+	totalBytes := uint64(0)
+	for _, table := range tablesForUpload {
+		totalBytes += table.TotalBytes
+	}
+	status.Current.SetTotalBytes(commandId, totalBytes)
 
 	for i, table := range tablesForUpload {
 		if err := uploadSemaphore.Acquire(uploadCtx, 1); err != nil {
@@ -149,13 +216,19 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 			}
 		}
 		idx := i
-		uploadGroup.Go(func() error {
+		uploadGroup.Go(func() (err error) {
 			defer uploadSemaphore.Release(1)
+			uploadCtx, tableSpan := tracing.Start(uploadCtx, "upload_table", tableAttribute(tablesForUpload[idx].Database, tablesForUpload[idx].Table))
+			defer func() {
+				tableSpan.End(err)
+			}()
 			var uploadedBytes int64
 			if !schemaOnly {
 				var files map[string][]string
 				var err error
-				files, uploadedBytes, err = b.uploadTableData(uploadCtx, backupName, tablesForUpload[idx])
+				progressCtx, progressDone := withTableProgress(uploadCtx, commandId, tablesForUpload[idx])
+				files, uploadedBytes, err = b.uploadTableData(progressCtx, backupName, tablesForUpload[idx])
+				progressDone()
 				if err != nil {
 					return err
 				}
@@ -167,10 +240,15 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 				return err
 			}
 			atomic.AddInt64(&metadataSize, tableMetadataSize)
+			if b.resume {
+				b.uploadUploadState(uploadCtx, backupName, remoteState)
+			}
+			status.Current.AddProgress(commandId, tablesForUpload[idx].TotalBytes, uint64(uploadedBytes+tableMetadataSize))
 			log.
 				WithField("table", fmt.Sprintf("%s.%s", tablesForUpload[idx].Database, tablesForUpload[idx].Table)).
 				WithField("duration", utils.HumanizeDuration(time.Since(start))).
 				WithField("size", utils.FormatBytes(uint64(uploadedBytes+tableMetadataSize))).
+				WithField("bytes", uploadedBytes+tableMetadataSize).
 				Info("done")
 			return nil
 		})
@@ -189,6 +267,20 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		if backupMetadata.ConfigSize, err = b.uploadConfigData(ctx, backupName); err != nil {
 			return fmt.Errorf("b.uploadConfigData return error: %v", err)
 		}
+
+		// upload keeper dumps for backup
+		if backupMetadata.KeeperSize, err = b.uploadKeeperData(ctx, backupName); err != nil {
+			return fmt.Errorf("b.uploadKeeperData return error: %v", err)
+		}
+
+		// chunks list shall be uploaded before metadata.json, garbage collection skips backups without metadata.json
+		if b.cfg.General.DedupStore {
+			chunksListSize, err := b.uploadDedupChunksList(ctx, backupName)
+			if err != nil {
+				return fmt.Errorf("b.uploadDedupChunksList return error: %v", err)
+			}
+			metadataSize += chunksListSize
+		}
 	}
 
 	// upload metadata for backup
@@ -202,11 +294,32 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		}
 	}
 	backupMetadata.Tables = tt
-	if b.cfg.GetCompressionFormat() != "none" {
+	// upload status for each destination make sense only locally
+	backupMetadata.Destinations = nil
+	if b.cfg.General.DedupStore && !b.isEmbedded {
+		backupMetadata.DataFormat = ChunksFormat
+	} else if b.cfg.GetCompressionFormat() != "none" {
 		backupMetadata.DataFormat = b.cfg.GetCompressionFormat()
 	} else {
 		backupMetadata.DataFormat = DirectoryFormat
 	}
+	backupMetadata.ManifestVersion = metadata.ManifestVersion
+	backupMetadata.Compression = b.getCompressionMetadata(backupMetadata.DataFormat)
+	backupMetadata.Encryption = b.getEncryptionMetadata()
+	backupMetadata.SegmentSize = b.dst.SegmentSize()
+	backupMetadata.UploadDurationSeconds = time.Since(startUpload).Seconds()
+	backupMetadata.ZstdDictionary = ""
+	if len(zstdDictionary) > 0 {
+		remoteDictionaryFile := path.Join(backupName, zstdDictionaryFile)
+		retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+		err = retry.RunCtx(ctx, func(ctx context.Context) error {
+			return b.dst.PutFile(ctx, remoteDictionaryFile, io.NopCloser(bytes.NewReader(zstdDictionary)))
+		})
+		if err != nil {
+			return fmt.Errorf("can't upload %s: %v", remoteDictionaryFile, err)
+		}
+		backupMetadata.ZstdDictionary = zstdDictionaryFile
+	}
 	newBackupMetadataBody, err := json.MarshalIndent(backupMetadata, "", "\t")
 	if err != nil {
 		return err
@@ -228,21 +341,156 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 			return fmt.Errorf("b.uploadSingleBackupFile return error: %v", err)
 		}
 	}
+	uploadDone = true
+	// chunks are referenced by chunks.list of uploaded backup now, lease shall not block garbage collection below
+	releaseDedupLease()
 	if b.resume {
 		b.resumableState.Close()
+		b.deleteRemoteUploadState(ctx, backupName)
 	}
 	log.
 		WithField("duration", utils.HumanizeDuration(time.Since(startUpload))).
-		WithField("size", utils.FormatBytes(uint64(compressedDataSize)+uint64(metadataSize)+uint64(len(newBackupMetadataBody))+backupMetadata.RBACSize+backupMetadata.ConfigSize)).
+		WithField("size", utils.FormatBytes(uint64(compressedDataSize)+uint64(metadataSize)+uint64(len(newBackupMetadataBody))+backupMetadata.RBACSize+backupMetadata.ConfigSize+backupMetadata.KeeperSize)).
 		Info("done")
 
 	// Clean
-	if err = b.dst.RemoveOldBackups(ctx, b.cfg.General.BackupsToKeepRemote); err != nil {
+	if b.teeMirrors != nil {
+		b.removeOldBackupsTeeMirrors(ctx)
+	}
+	deletedBackups, err := b.dst.RemoveOldBackups(ctx, storage.NewBackupRetention(b.cfg), false)
+	if err != nil {
 		return fmt.Errorf("can't remove old backups on remote storage: %v", err)
 	}
+	b.notifyRetentionDelete("remote", getBackupNames(deletedBackups))
+	if b.cfg.General.DedupStore && storage.NewBackupRetention(b.cfg).IsEnabled() {
+		if err = b.removeUnreferencedChunks(ctx, b.dst); err != nil {
+			return fmt.Errorf("can't remove unreferenced chunks on remote storage: %v", err)
+		}
+	}
 	return nil
 }
 
+// isAlreadyUploaded - check resumable state and compare saved size with remote object size, to avoid skip broken or deleted objects
+func (b *Backuper) isAlreadyUploaded(ctx context.Context, remoteFile string) (bool, int64) {
+	isProcessed, processedSize := b.resumableState.IsAlreadyProcessed(remoteFile)
+	if !isProcessed {
+		return false, 0
+	}
+	remoteObject, err := b.dst.StatFile(ctx, remoteFile)
+	if err != nil {
+		b.log.Warnf("%s present in upload state, but can't stat on remote storage, will upload again: %v", remoteFile, err)
+		return false, 0
+	}
+	if remoteObject.Size() != processedSize {
+		b.log.Warnf("%s present in upload state with size=%d, but remote size=%d, will upload again", remoteFile, processedSize, remoteObject.Size())
+		return false, 0
+	}
+	return true, processedSize
+}
+
+// remoteUploadState - remote storage can't append to object, so each uploadUploadState call put only new lines of local upload.state
+// as separate `upload.state.d/<offset>` object, uploadedSize is offset in local upload.state which already present on remote storage
+type remoteUploadState struct {
+	mx           sync.Mutex
+	uploadedSize int
+}
+
+// downloadUploadStateIfNotExists - restore local upload state from remote storage, allow resume upload after local state lost
+func (b *Backuper) downloadUploadStateIfNotExists(ctx context.Context, backupName string) {
+	localStateFile := path.Join(b.DefaultDataPath, "backup", backupName, uploadStateFile)
+	if _, err := os.Stat(localStateFile); err == nil || !os.IsNotExist(err) {
+		return
+	}
+	remoteStateChunks, err := b.getRemoteUploadStateChunks(ctx, backupName)
+	if err != nil || len(remoteStateChunks) == 0 {
+		return
+	}
+	var state []byte
+	for _, remoteStateChunk := range remoteStateChunks {
+		reader, err := b.dst.GetFileReader(ctx, remoteStateChunk)
+		if err != nil {
+			b.log.Warnf("can't read remote %s: %v", remoteStateChunk, err)
+			return
+		}
+		chunk, err := io.ReadAll(reader)
+		if closeErr := reader.Close(); closeErr != nil {
+			b.log.Warnf("can't close %s: %v", remoteStateChunk, closeErr)
+		}
+		if err != nil {
+			b.log.Warnf("can't read remote %s: %v", remoteStateChunk, err)
+			return
+		}
+		state = append(state, chunk...)
+	}
+	if err = os.WriteFile(localStateFile, state, 0644); err != nil {
+		b.log.Warnf("can't write %s: %v", localStateFile, err)
+		return
+	}
+	b.log.Infof("%s restored from remote storage", localStateFile)
+}
+
+// getRemoteUploadStateChunks - `upload.state.d` objects sorted by offset
+func (b *Backuper) getRemoteUploadStateChunks(ctx context.Context, backupName string) ([]string, error) {
+	remoteStateDir := path.Join(backupName, uploadStateFile+".d")
+	remoteStateChunks := make([]string, 0)
+	err := b.dst.Walk(ctx, remoteStateDir+"/", false, func(ctx context.Context, f storage.RemoteFile) error {
+		remoteStateChunks = append(remoteStateChunks, path.Join(remoteStateDir, path.Base(f.Name())))
+		return nil
+	})
+	sort.Strings(remoteStateChunks)
+	return remoteStateChunks, err
+}
+
+// uploadUploadState - append new lines of local upload state to remote storage, allow resume upload from other host or after local state lost
+func (b *Backuper) uploadUploadState(ctx context.Context, backupName string, remoteState *remoteUploadState) {
+	remoteState.mx.Lock()
+	defer remoteState.mx.Unlock()
+	localStateFile := path.Join(b.DefaultDataPath, "backup", backupName, uploadStateFile)
+	state, err := os.ReadFile(localStateFile)
+	if err != nil {
+		b.log.Warnf("can't read %s: %v", localStateFile, err)
+		return
+	}
+	// upload.state restored from remote storage already present there
+	if remoteState.uploadedSize == 0 {
+		if remoteStateChunks, err := b.getRemoteUploadStateChunks(ctx, backupName); err == nil && len(remoteStateChunks) > 0 {
+			offset, _ := strconv.Atoi(path.Base(remoteStateChunks[len(remoteStateChunks)-1]))
+			remoteState.uploadedSize = offset
+		}
+	}
+	// local upload.state exists, so it was not restored from remote storage, and remote chunks were uploaded from other host or previous local state
+	if remoteState.uploadedSize > len(state) {
+		b.log.Warnf("local %s size=%d is shorter than remote offset=%d, will replace remote upload state", localStateFile, len(state), remoteState.uploadedSize)
+		b.deleteRemoteUploadState(ctx, backupName)
+		remoteState.uploadedSize = 0
+	}
+	// last line could be written partially, it will be uploaded with next call
+	newLines := state[remoteState.uploadedSize:]
+	newLines = newLines[:bytes.LastIndexByte(newLines, '\n')+1]
+	if len(newLines) == 0 {
+		return
+	}
+	remoteStateChunk := path.Join(backupName, uploadStateFile+".d", fmt.Sprintf("%020d", remoteState.uploadedSize))
+	if err = b.dst.PutFile(ctx, remoteStateChunk, io.NopCloser(bytes.NewReader(newLines))); err != nil {
+		b.log.Warnf("can't upload %s: %v", remoteStateChunk, err)
+		return
+	}
+	remoteState.uploadedSize += len(newLines)
+}
+
+// deleteRemoteUploadState - after successful upload `upload.state.d` is not required anymore
+func (b *Backuper) deleteRemoteUploadState(ctx context.Context, backupName string) {
+	remoteStateChunks, err := b.getRemoteUploadStateChunks(ctx, backupName)
+	if err != nil {
+		b.log.Warnf("can't list remote %s.d: %v", path.Join(backupName, uploadStateFile), err)
+	}
+	for _, remoteStateChunk := range remoteStateChunks {
+		if err = b.dst.DeleteFile(ctx, remoteStateChunk); err != nil {
+			b.log.Warnf("can't delete remote %s: %v", remoteStateChunk, err)
+		}
+	}
+}
+
 func (b *Backuper) uploadSingleBackupFile(ctx context.Context, localFile, remoteFile string) error {
 	if b.resume && b.resumableState.IsAlreadyProcessedBool(remoteFile) {
 		return nil
@@ -294,6 +542,7 @@ func (b *Backuper) getTablesForUploadDiffLocal(ctx context.Context, diffFrom str
 	}
 	if len(diffFromBackup.Tables) != 0 {
 		backupMetadata.RequiredBackup = diffFrom
+		backupMetadata.IncrementalChain = getIncrementalChain(diffFrom, diffFromBackup)
 		metadataPath := path.Join(b.DefaultDataPath, "backup", diffFrom, "metadata")
 		// empty partitions, because we don't want filter
 		diffTablesList, _, err := b.getTableListByPatternLocal(ctx, metadataPath, tablePattern, false, []string{})
@@ -332,6 +581,7 @@ func (b *Backuper) getTablesForUploadDiffRemote(ctx context.Context, diffFromRem
 
 	if len(diffRemoteMetadata.Tables) != 0 {
 		backupMetadata.RequiredBackup = diffFromRemote
+		backupMetadata.IncrementalChain = getIncrementalChain(diffFromRemote, diffRemoteMetadata)
 		diffTablesList, err := getTableListByPatternRemote(ctx, b, diffRemoteMetadata, tablePattern, false)
 		if err != nil {
 			return nil, err
@@ -342,10 +592,93 @@ func (b *Backuper) getTablesForUploadDiffRemote(ctx context.Context, diffFromRem
 				Table:    t.Table,
 			}] = t
 		}
+		if err = b.resolveDiffRemoteChain(ctx, diffRemoteMetadata, tablePattern, tablesForUploadFromDiff); err != nil {
+			return nil, err
+		}
 	}
 	return tablesForUploadFromDiff, nil
 }
 
+// resolveDiffRemoteChain walk through whole chain of incremental backups which started from diffRemoteMetadata,
+// merge parts from all backups in chain and set RequiredBackup for each part to backup name which owns part data
+func (b *Backuper) resolveDiffRemoteChain(ctx context.Context, diffRemoteMetadata *metadata.BackupMetadata, tablePattern string, tablesForUploadFromDiff map[metadata.TableTitle]metadata.TableMetadata) error {
+	log := b.log.WithField("logger", "resolveDiffRemoteChain")
+	// part key is disk + part name, value is backup name which owns part data, empty value means owner not found yet
+	partOwners := make(map[metadata.TableTitle]map[string]string, len(tablesForUploadFromDiff))
+	chainBackup := diffRemoteMetadata
+	visitedBackups := common.EmptyMap{}
+	for chainBackup != nil {
+		if _, visited := visitedBackups[chainBackup.BackupName]; visited {
+			return fmt.Errorf("incremental backups chain contains cycle on %s", chainBackup.BackupName)
+		}
+		visitedBackups[chainBackup.BackupName] = struct{}{}
+		var chainTables ListOfTables
+		if chainBackup == diffRemoteMetadata {
+			chainTables = make(ListOfTables, 0, len(tablesForUploadFromDiff))
+			for _, t := range tablesForUploadFromDiff {
+				chainTables = append(chainTables, t)
+			}
+		} else {
+			var err error
+			if chainTables, err = getTableListByPatternRemote(ctx, b, chainBackup, tablePattern, false); err != nil {
+				return err
+			}
+		}
+		for _, t := range chainTables {
+			title := metadata.TableTitle{Database: t.Database, Table: t.Table}
+			diffTable, exists := tablesForUploadFromDiff[title]
+			if !exists {
+				continue
+			}
+			if _, exists = partOwners[title]; !exists {
+				partOwners[title] = make(map[string]string)
+			}
+			if diffTable.Parts == nil {
+				diffTable.Parts = make(map[string][]metadata.Part)
+			}
+			for disk, parts := range t.Parts {
+				for _, p := range parts {
+					partKey := disk + "/" + p.Name
+					owner, known := partOwners[title][partKey]
+					if known && owner != "" {
+						continue
+					}
+					if !p.Required {
+						owner = chainBackup.BackupName
+					} else if p.RequiredBackup != "" {
+						owner = p.RequiredBackup
+					}
+					if !known && chainBackup != diffRemoteMetadata {
+						diffTable.Parts[disk] = append(diffTable.Parts[disk], p)
+					}
+					partOwners[title][partKey] = owner
+				}
+			}
+			tablesForUploadFromDiff[title] = diffTable
+		}
+		if chainBackup.RequiredBackup == "" {
+			break
+		}
+		nextBackup, err := b.ReadBackupMetadataRemote(ctx, chainBackup.RequiredBackup)
+		if err != nil {
+			log.Warnf("can't read %s required by %s, incremental chain is broken: %v", chainBackup.RequiredBackup, chainBackup.BackupName, err)
+			break
+		}
+		chainBackup = nextBackup
+	}
+	for title, diffTable := range tablesForUploadFromDiff {
+		for disk := range diffTable.Parts {
+			for i := range diffTable.Parts[disk] {
+				if owner := partOwners[title][disk+"/"+diffTable.Parts[disk][i].Name]; owner != "" {
+					diffTable.Parts[disk][i].RequiredBackup = owner
+				}
+			}
+		}
+	}
+	log.Debugf("resolved %d backups in incremental chain started from %s", len(visitedBackups), diffRemoteMetadata.BackupName)
+	return nil
+}
+
 func (b *Backuper) validateUploadParams(ctx context.Context, backupName string, diffFrom string, diffFromRemote string) error {
 	log := b.log.WithField("logger", "validateUploadParams")
 	if b.cfg.General.RemoteStorage == "none" {
@@ -394,33 +727,50 @@ func (b *Backuper) validateUploadParams(ctx context.Context, backupName string,
 		if b.cfg.General.RemoteStorage == "cos" && b.cfg.COS.CompressionFormat != "none" {
 			log.Fatalf(fatalMsg, b.cfg.COS.CompressionFormat)
 		}
+		if b.cfg.General.RemoteStorage == "rclone" && b.cfg.Rclone.CompressionFormat != "none" {
+			log.Fatalf(fatalMsg, b.cfg.Rclone.CompressionFormat)
+		}
+		if b.cfg.General.RemoteStorage == "swift" && b.cfg.Swift.CompressionFormat != "none" {
+			log.Fatalf(fatalMsg, b.cfg.Swift.CompressionFormat)
+		}
+		if b.cfg.General.RemoteStorage == "oss" && b.cfg.OSS.CompressionFormat != "none" {
+			log.Fatalf(fatalMsg, b.cfg.OSS.CompressionFormat)
+		}
+		if b.cfg.General.RemoteStorage == "obs" && b.cfg.OBS.CompressionFormat != "none" {
+			log.Fatalf(fatalMsg, b.cfg.OBS.CompressionFormat)
+		}
 	}
 	if b.cfg.General.RemoteStorage == "custom" && b.resume {
 		return fmt.Errorf("can't resume for `remote_storage: custom`")
 	}
-	if b.cfg.General.RemoteStorage == "s3" && len(b.cfg.S3.CustomStorageClassMap) > 0 {
-		for pattern, storageClass := range b.cfg.S3.CustomStorageClassMap {
+	applyCustomStorageClassMap(b.cfg, backupName)
+	return nil
+}
+
+// applyCustomStorageClassMap - s3->custom_storage_class_map and gcs->custom_storage_class_map override storage_class when backup name matched
+func applyCustomStorageClassMap(cfg *config.Config, backupName string) {
+	if cfg.General.RemoteStorage == "s3" && len(cfg.S3.CustomStorageClassMap) > 0 {
+		for pattern, storageClass := range cfg.S3.CustomStorageClassMap {
 			re := regexp.MustCompile(pattern)
 			if re.MatchString(backupName) {
-				b.cfg.S3.StorageClass = storageClass
+				cfg.S3.StorageClass = storageClass
 			}
 		}
 	}
-	if b.cfg.General.RemoteStorage == "gcs" && len(b.cfg.GCS.CustomStorageClassMap) > 0 {
-		for pattern, storageClass := range b.cfg.GCS.CustomStorageClassMap {
+	if cfg.General.RemoteStorage == "gcs" && len(cfg.GCS.CustomStorageClassMap) > 0 {
+		for pattern, storageClass := range cfg.GCS.CustomStorageClassMap {
 			re := regexp.MustCompile(pattern)
 			if re.MatchString(backupName) {
-				b.cfg.GCS.StorageClass = storageClass
+				cfg.GCS.StorageClass = storageClass
 			}
 		}
 	}
-	return nil
 }
 
 func (b *Backuper) uploadConfigData(ctx context.Context, backupName string) (uint64, error) {
 	configBackupPath := path.Join(b.DefaultDataPath, "backup", backupName, "configs")
 	configFilesGlobPattern := path.Join(configBackupPath, "**/*.*")
-	if b.cfg.GetCompressionFormat() == "none" {
+	if b.cfg.GetCompressionFormat() == "none" || b.cfg.General.DedupStore {
 		remoteConfigsDir := path.Join(backupName, "configs")
 		return b.uploadBackupRelatedDir(ctx, configBackupPath, configFilesGlobPattern, remoteConfigsDir)
 	}
@@ -431,7 +781,7 @@ func (b *Backuper) uploadConfigData(ctx context.Context, backupName string) (uin
 func (b *Backuper) uploadRBACData(ctx context.Context, backupName string) (uint64, error) {
 	rbacBackupPath := path.Join(b.DefaultDataPath, "backup", backupName, "access")
 	accessFilesGlobPattern := path.Join(rbacBackupPath, "*.*")
-	if b.cfg.GetCompressionFormat() == "none" {
+	if b.cfg.GetCompressionFormat() == "none" || b.cfg.General.DedupStore {
 		remoteRBACDir := path.Join(backupName, "access")
 		return b.uploadBackupRelatedDir(ctx, rbacBackupPath, accessFilesGlobPattern, remoteRBACDir)
 	}
@@ -439,6 +789,17 @@ func (b *Backuper) uploadRBACData(ctx context.Context, backupName string) (uint6
 	return b.uploadBackupRelatedDir(ctx, rbacBackupPath, accessFilesGlobPattern, remoteRBACArchive)
 }
 
+func (b *Backuper) uploadKeeperData(ctx context.Context, backupName string) (uint64, error) {
+	keeperBackupPath := path.Join(b.DefaultDataPath, "backup", backupName, "keeper")
+	keeperFilesGlobPattern := path.Join(keeperBackupPath, "*.jsonl")
+	if b.cfg.GetCompressionFormat() == "none" || b.cfg.General.DedupStore {
+		remoteKeeperDir := path.Join(backupName, "keeper")
+		return b.uploadBackupRelatedDir(ctx, keeperBackupPath, keeperFilesGlobPattern, remoteKeeperDir)
+	}
+	remoteKeeperArchive := path.Join(backupName, fmt.Sprintf("keeper.%s", b.cfg.GetArchiveExtension()))
+	return b.uploadBackupRelatedDir(ctx, keeperBackupPath, keeperFilesGlobPattern, remoteKeeperArchive)
+}
+
 func (b *Backuper) uploadBackupRelatedDir(ctx context.Context, localBackupRelatedDir, localFilesGlobPattern, destinationRemote string) (uint64, error) {
 	if _, err := os.Stat(localBackupRelatedDir); os.IsNotExist(err) {
 		return 0, nil
@@ -462,7 +823,7 @@ func (b *Backuper) uploadBackupRelatedDir(ctx context.Context, localBackupRelate
 			localFiles[i] = strings.Replace(localFiles[i], localBackupRelatedDir, "", 1)
 		}
 	}
-	if b.cfg.GetCompressionFormat() == "none" {
+	if b.cfg.GetCompressionFormat() == "none" || b.cfg.General.DedupStore {
 		remoteUploadedBytes := int64(0)
 		if remoteUploadedBytes, err = b.dst.UploadPath(ctx, 0, localBackupRelatedDir, localFiles, destinationRemote, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration); err != nil {
 			return 0, fmt.Errorf("can't RBAC or config upload %s: %v", destinationRemote, err)
@@ -497,11 +858,28 @@ func (b *Backuper) uploadTableData(ctx context.Context, backupName string, table
 		capacity += len(table.Parts[disk])
 	}
 	log := b.log.WithField("logger", "uploadTableData")
-	log.Debugf("start %s.%s with concurrency=%d len(table.Parts[...])=%d", table.Database, table.Table, b.cfg.General.UploadConcurrency, capacity)
-	s := semaphore.NewWeighted(int64(b.cfg.General.UploadConcurrency))
+	// metadata stays in default storage class, only data objects use storage_class from general->table_storage_rules
+	ctx = storage.WithStorageClass(ctx, b.getTableStorageClass(table))
+	log.Debugf("start %s.%s with concurrency=%d concurrency_per_table=%d len(table.Parts[...])=%d", table.Database, table.Table, b.cfg.General.UploadConcurrency, b.cfg.General.UploadTableConcurrency, capacity)
+	s := newTableSemaphore(b.cfg.General.UploadTableConcurrency, b.cfg.General.UploadConcurrency)
 	g, ctx := errgroup.WithContext(ctx)
 	var uploadedBytes int64
+	compressionLevel := b.getTableCompressionLevel(table.TotalBytes)
+	// remote archive sizes for sentinel, allow `verify --remote` detect truncated archives
+	archiveSizes := make(map[string]map[string]int64, len(table.Parts))
+	archiveSizesMx := sync.Mutex{}
+	setArchiveSize := func(disk, fileName string, size int64) {
+		archiveSizesMx.Lock()
+		defer archiveSizesMx.Unlock()
+		if archiveSizes[disk] == nil {
+			archiveSizes[disk] = make(map[string]int64)
+		}
+		archiveSizes[disk][fileName] = size
+	}
 
+	if err := b.verifyPartsHashes(ctx, backupName, table); err != nil {
+		return nil, 0, err
+	}
 	splitParts := make(map[string][]metadata.SplitPartFiles, 0)
 	splitPartsOffset := make(map[string]int, 0)
 	splitPartsCapacity := 0
@@ -521,7 +899,7 @@ breakByError:
 			if splitPartsOffset[disk] >= len(splitParts[disk]) {
 				continue
 			}
-			if err := s.Acquire(ctx, 1); err != nil {
+			if err := b.acquirePartSlot(ctx, s); err != nil {
 				log.Errorf("can't acquire semaphore during Upload data parts: %v", err)
 				break breakByError
 			}
@@ -530,12 +908,39 @@ breakByError:
 			partSuffix := splitPart.Prefix
 			partFiles := splitPart.Files
 			splitPartsOffset[disk] += 1
-			baseRemoteDataPath := path.Join(backupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
-			if b.cfg.GetCompressionFormat() == "none" {
+			baseRemoteDataPath := remoteTableDataPath(backupName, table)
+			partAttributes := []attribute.KeyValue{tableAttribute(table.Database, table.Table), attribute.String("disk", disk), attribute.String("part", partSuffix)}
+			if b.cfg.General.DedupStore {
+				fileName := fmt.Sprintf("%s_%s%s", disk, common.TablePathEncode(partSuffix), chunksManifestSuffix)
+				uploadedFiles[disk] = append(uploadedFiles[disk], fileName)
+				remoteManifest := path.Join(baseRemoteDataPath, fileName)
+				localFiles := partFiles
+				goWithSpan(ctx, g, "upload_part", partAttributes, func(ctx context.Context) error {
+					defer b.releasePartSlot(s)
+					if b.resume {
+						if isProcessed, processedSize := b.isAlreadyUploaded(ctx, remoteManifest); isProcessed {
+							atomic.AddInt64(&uploadedBytes, processedSize)
+							return b.registerRemotePartChunks(ctx, remoteManifest)
+						}
+					}
+					log.Debugf("start upload %d files chunks to %s", len(localFiles), remoteManifest)
+					partUploadedBytes, err := b.uploadPartChunks(ctx, backupPath, localFiles, remoteManifest)
+					if err != nil {
+						log.Errorf("uploadPartChunks return error: %v", err)
+						return fmt.Errorf("can't upload: %v", err)
+					}
+					atomic.AddInt64(&uploadedBytes, partUploadedBytes)
+					if b.resume {
+						b.resumableState.AppendToState(remoteManifest, partUploadedBytes)
+					}
+					log.Debugf("finish upload to %s", remoteManifest)
+					return nil
+				})
+			} else if b.cfg.GetCompressionFormat() == "none" {
 				remotePath := path.Join(baseRemoteDataPath, disk)
 				remotePathFull := path.Join(remotePath, partSuffix)
-				g.Go(func() error {
-					defer s.Release(1)
+				goWithSpan(ctx, g, "upload_part", partAttributes, func(ctx context.Context) error {
+					defer b.releasePartSlot(s)
 					if b.resume {
 						if isProcessed, processedSize := b.resumableState.IsAlreadyProcessed(remotePathFull); isProcessed {
 							atomic.AddInt64(&uploadedBytes, processedSize)
@@ -560,18 +965,20 @@ breakByError:
 				uploadedFiles[disk] = append(uploadedFiles[disk], fileName)
 				remoteDataFile := path.Join(baseRemoteDataPath, fileName)
 				localFiles := partFiles
-				g.Go(func() error {
-					defer s.Release(1)
+				archiveDisk := disk
+				goWithSpan(ctx, g, "upload_part", partAttributes, func(ctx context.Context) error {
+					defer b.releasePartSlot(s)
 					if b.resume {
-						if isProcessed, processedSize := b.resumableState.IsAlreadyProcessed(remoteDataFile); isProcessed {
+						if isProcessed, processedSize := b.isAlreadyUploaded(ctx, remoteDataFile); isProcessed {
 							atomic.AddInt64(&uploadedBytes, processedSize)
+							setArchiveSize(archiveDisk, fileName, processedSize)
 							return nil
 						}
 					}
 					log.Debugf("start upload %d files to %s", len(localFiles), remoteDataFile)
 					retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
 					err := retry.RunCtx(ctx, func(ctx context.Context) error {
-						return b.dst.UploadCompressedStream(ctx, backupPath, localFiles, remoteDataFile)
+						return b.dst.UploadCompressedStreamWithLevel(ctx, backupPath, localFiles, remoteDataFile, compressionLevel)
 					})
 					if err != nil {
 						log.Errorf("UploadCompressedStream return error: %v", err)
@@ -582,6 +989,7 @@ breakByError:
 						return fmt.Errorf("can't check uploaded remoteDataFile: %s, error: %v", remoteDataFile, err)
 					}
 					atomic.AddInt64(&uploadedBytes, remoteFile.Size())
+					setArchiveSize(archiveDisk, fileName, remoteFile.Size())
 					if b.resume {
 						b.resumableState.AppendToState(remoteDataFile, remoteFile.Size())
 					}
@@ -594,6 +1002,9 @@ breakByError:
 	if err := g.Wait(); err != nil {
 		return nil, 0, fmt.Errorf("one of uploadTableData go-routine return error: %v", err)
 	}
+	if err := b.uploadBackupSentinels(ctx, backupName, table, archiveSizes); err != nil {
+		return nil, 0, err
+	}
 	log.Debugf("finish %s.%s with concurrency=%d len(table.Parts[...])=%d uploadedFiles=%v, uploadedBytes=%v", table.Database, table.Table, b.cfg.General.UploadConcurrency, capacity, uploadedFiles, uploadedBytes)
 	return uploadedFiles, uploadedBytes, nil
 }
@@ -676,12 +1087,14 @@ func (b *Backuper) markDuplicatedParts(backup *metadata.BackupMetadata, existsTa
 			if len(existsTable.Parts[disk]) == 0 {
 				continue
 			}
-			existsPartsMap := common.EmptyMap{}
+			// value is backup name which owns part data in incremental chain, could be empty
+			existsPartsMap := make(map[string]string, len(existsTable.Parts[disk]))
 			for _, p := range existsTable.Parts[disk] {
-				existsPartsMap[p.Name] = struct{}{}
+				existsPartsMap[p.Name] = p.RequiredBackup
 			}
 			for i := range newParts {
-				if _, partExists := existsPartsMap[newParts[i].Name]; !partExists {
+				owner, partExists := existsPartsMap[newParts[i].Name]
+				if !partExists {
 					continue
 				}
 				if checkLocal {
@@ -695,6 +1108,7 @@ func (b *Backuper) markDuplicatedParts(backup *metadata.BackupMetadata, existsTa
 					}
 				}
 				newParts[i].Required = true
+				newParts[i].RequiredBackup = owner
 			}
 		}
 	}
@@ -730,14 +1144,14 @@ bodyRead:
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
-		backupMetadata := metadata.BackupMetadata{}
-		if err := json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
+		backupMetadata, err := metadata.ParseBackupMetadata(backupMetadataBody)
+		if err != nil {
 			return nil, err
 		}
 		if len(backupMetadata.Tables) == 0 && !b.cfg.General.AllowEmptyBackups {
 			return nil, fmt.Errorf("'%s' is empty backup", backupName)
 		}
-		return &backupMetadata, nil
+		return backupMetadata, nil
 	}
 }
 
@@ -765,8 +1179,8 @@ func (b *Backuper) splitFilesByName(basePath string, parts []metadata.Part) ([]m
 			if !info.Mode().IsRegular() {
 				return nil
 			}
-			relativePath := strings.TrimPrefix(filePath, basePath)
-			files = append(files, relativePath)
+			// leading slash keeps archive entries the same as before
+			files = append(files, "/"+filesystemhelper.RelativePath(basePath, filePath))
 			return nil
 		})
 		if err != nil {
@@ -808,8 +1222,7 @@ func (b *Backuper) splitFilesBySize(basePath string, parts []metadata.Part) ([]m
 				size = 0
 				partSuffix += 1
 			}
-			relativePath := strings.TrimPrefix(filePath, basePath)
-			files = append(files, relativePath)
+			files = append(files, "/"+filesystemhelper.RelativePath(basePath, filePath))
 			size += info.Size()
 			return nil
 		})
@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	apexLog "github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var chainTestTable = metadata.TableTitle{Database: "db", Table: "t"}
+
+// putChainBackup - upload metadata.json and table metadata of one backup in incremental chain
+func putChainBackup(t *testing.T, remote *objectsStorage, backupName, requiredBackup string, parts []metadata.Part) {
+	backupBody, err := json.Marshal(metadata.BackupMetadata{BackupName: backupName, RequiredBackup: requiredBackup, Tables: []metadata.TableTitle{chainTestTable}})
+	require.NoError(t, err)
+	tableBody, err := json.Marshal(metadata.TableMetadata{Database: chainTestTable.Database, Table: chainTestTable.Table, Parts: map[string][]metadata.Part{"default": parts}})
+	require.NoError(t, err)
+	remote.objects[path.Join(backupName, "metadata.json")] = backupBody
+	remote.objects[path.Join(backupName, "metadata", chainTestTable.Database, chainTestTable.Table+".json")] = tableBody
+}
+
+func newChainTestBackuper(t *testing.T, remote *objectsStorage) (*Backuper, *memory.Handler) {
+	// BackupList keeps metadata cache in os.TempDir()
+	t.Setenv("TMPDIR", t.TempDir())
+	handler := memory.New()
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.NewEntry(&apexLog.Logger{Handler: handler, Level: apexLog.DebugLevel})}
+	b.dst = &storage.BackupDestination{RemoteStorage: remote, Log: b.log}
+	return b, handler
+}
+
+// resolveChainTestOwners - resolve chain started from diffFromRemote and return part name -> RequiredBackup
+func resolveChainTestOwners(t *testing.T, b *Backuper, diffFromRemote string) (map[string]string, error) {
+	ctx := context.Background()
+	diffRemoteMetadata, err := b.ReadBackupMetadataRemote(ctx, diffFromRemote)
+	require.NoError(t, err)
+	diffTables, err := getTableListByPatternRemote(ctx, b, diffRemoteMetadata, "", false)
+	require.NoError(t, err)
+	tablesForUploadFromDiff := map[metadata.TableTitle]metadata.TableMetadata{}
+	for _, table := range diffTables {
+		tablesForUploadFromDiff[metadata.TableTitle{Database: table.Database, Table: table.Table}] = table
+	}
+	if err = b.resolveDiffRemoteChain(ctx, diffRemoteMetadata, "", tablesForUploadFromDiff); err != nil {
+		return nil, err
+	}
+	owners := map[string]string{}
+	for _, p := range tablesForUploadFromDiff[chainTestTable].Parts["default"] {
+		owners[p.Name] = p.RequiredBackup
+	}
+	return owners, nil
+}
+
+func TestResolveDiffRemoteChain(t *testing.T) {
+	remote := &objectsStorage{objects: map[string][]byte{}}
+	putChainBackup(t, remote, "full", "", []metadata.Part{{Name: "all_1_1_0"}, {Name: "all_2_2_0"}, {Name: "all_5_5_0"}})
+	// all_2_2_0 merged away in inc1, all_5_5_0 re-added in inc2 with own data
+	putChainBackup(t, remote, "inc1", "full", []metadata.Part{{Name: "all_1_1_0", Required: true, RequiredBackup: "full"}, {Name: "all_3_3_0"}})
+	// parts from older backups are required without owner
+	putChainBackup(t, remote, "inc2", "inc1", []metadata.Part{{Name: "all_1_1_0", Required: true}, {Name: "all_3_3_0", Required: true}, {Name: "all_4_4_0"}, {Name: "all_5_5_0"}})
+	b, _ := newChainTestBackuper(t, remote)
+
+	owners, err := resolveChainTestOwners(t, b, "inc2")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"all_1_1_0": "full",
+		"all_2_2_0": "full",
+		"all_3_3_0": "inc1",
+		"all_4_4_0": "inc2",
+		"all_5_5_0": "inc2",
+	}, owners)
+}
+
+func TestResolveDiffRemoteChainCycle(t *testing.T) {
+	remote := &objectsStorage{objects: map[string][]byte{}}
+	putChainBackup(t, remote, "inc1", "inc2", []metadata.Part{{Name: "all_1_1_0"}})
+	putChainBackup(t, remote, "inc2", "inc1", []metadata.Part{{Name: "all_1_1_0", Required: true}})
+	b, _ := newChainTestBackuper(t, remote)
+
+	_, err := resolveChainTestOwners(t, b, "inc2")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cycle on inc2")
+}
+
+func TestResolveDiffRemoteChainBroken(t *testing.T) {
+	remote := &objectsStorage{objects: map[string][]byte{}}
+	putChainBackup(t, remote, "inc1", "full", []metadata.Part{{Name: "all_1_1_0", Required: true, RequiredBackup: "full"}, {Name: "all_2_2_0"}})
+	putChainBackup(t, remote, "inc2", "inc1", []metadata.Part{{Name: "all_1_1_0", Required: true}, {Name: "all_2_2_0", Required: true}})
+	b, handler := newChainTestBackuper(t, remote)
+
+	owners, err := resolveChainTestOwners(t, b, "inc2")
+	require.NoError(t, err, "broken chain shall not fail upload")
+	assert.Equal(t, map[string]string{"all_1_1_0": "full", "all_2_2_0": "inc1"}, owners)
+	brokenWarnings := 0
+	for _, entry := range handler.Entries {
+		if entry.Level == apexLog.WarnLevel && strings.Contains(entry.Message, "can't read full required by inc1, incremental chain is broken") {
+			brokenWarnings++
+		}
+	}
+	assert.Equal(t, 1, brokenWarnings)
+}
+
+func TestMarkDuplicatedPartsOwner(t *testing.T) {
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.WithField("logger", "test")}
+	backup := &metadata.BackupMetadata{BackupName: "inc3", RequiredBackup: "inc2"}
+	existsTable := &metadata.TableMetadata{Database: "db", Table: "t", Parts: map[string][]metadata.Part{"default": {
+		{Name: "all_1_1_0", Required: true, RequiredBackup: "full"},
+		{Name: "all_4_4_0", RequiredBackup: "inc2"},
+		{Name: "all_5_5_0", RequiredBackup: "inc2"},
+	}}}
+	newTable := &metadata.TableMetadata{Database: "db", Table: "t", Parts: map[string][]metadata.Part{"default": {
+		{Name: "all_1_1_0"}, {Name: "all_5_5_0"}, {Name: "all_6_6_0"},
+	}}}
+	b.markDuplicatedParts(backup, existsTable, newTable, false)
+	assert.Equal(t, []metadata.Part{
+		{Name: "all_1_1_0", Required: true, RequiredBackup: "full"},
+		{Name: "all_5_5_0", Required: true, RequiredBackup: "inc2"},
+		{Name: "all_6_6_0"},
+	}, newTable.Parts["default"])
+}
@@ -0,0 +1,310 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+)
+
+// Upload - upload local backup to general->remote_storage and to each `upload_mirrors` item, with one compressed stream for all destinations, one after another or in parallel depends on general->upload_mirrors_mode
+// when `upload_mirrors` defined, upload status for each destination saved into local metadata.json
+func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) (err error) {
+	b.setCommandLog(commandId)
+	tablePattern = b.tablePatternOrDefault(tablePattern)
+	notifyFinish := b.notifyOperation("upload", backupName)
+	hooks := b.newOperationHooks("upload", backupName)
+	defer func() {
+		err = hooks.after(context.Background(), err)
+		notifyFinish(err)
+	}()
+	if err = hooks.before(context.Background()); err != nil {
+		return err
+	}
+	if len(b.cfg.Mirrors) == 0 {
+		return b.uploadToRemote(backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, commandId)
+	}
+	log := b.log.WithField("logger", "uploadMirrors")
+	mirrorNames, mirrorConfigs, err := b.cfg.GetMirrorConfigs()
+	if err != nil {
+		return err
+	}
+	destinations := make([]metadata.UploadStatus, len(mirrorConfigs)+1)
+	uploaders := make([]func() error, len(mirrorConfigs)+1)
+	destinations[0].Name = b.cfg.General.RemoteStorage
+	destinations[0].RemoteStorage = b.cfg.General.RemoteStorage
+	uploaders[0] = func() error {
+		return b.uploadToRemote(backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, commandId)
+	}
+	mirrorBackupers := make([]*Backuper, len(mirrorConfigs))
+	for i := range mirrorConfigs {
+		// resumable state file is shared for all destinations, so resume works only for general->remote_storage
+		mirrorConfigs[i].General.UseResumableState = false
+		mirrorBackupers[i] = NewBackuper(mirrorConfigs[i])
+		mirrorBackupers[i].bs = b.bs
+		destinations[i+1].Name = mirrorNames[i]
+		destinations[i+1].RemoteStorage = mirrorConfigs[i].General.RemoteStorage
+		mb := mirrorBackupers[i]
+		uploaders[i+1] = func() error {
+			return mb.uploadToRemote(backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, false, commandId)
+		}
+	}
+	setStatus := func(i int, uploadErr error) {
+		if uploadErr != nil {
+			log.Errorf("upload %s to %s return error: %v", backupName, destinations[i].Name, uploadErr)
+			destinations[i].Status = "error"
+			destinations[i].Error = uploadErr.Error()
+		} else {
+			destinations[i].Status = "success"
+		}
+		destinations[i].UploadDate = time.Now().UTC()
+	}
+	runUploader := func(i int) {
+		log.Infof("upload %s to %s (%s)", backupName, destinations[i].Name, destinations[i].RemoteStorage)
+		setStatus(i, uploaders[i]())
+	}
+	mode := b.cfg.General.UploadMirrorsMode
+	if mode == "tee" {
+		if reason := b.getTeeIncompatibility(mirrorNames, mirrorConfigs); reason != "" {
+			log.Infof("upload_mirrors_mode: tee is not applicable, %s, upload to each destination one after another", reason)
+			mode = "sequential"
+		}
+	}
+	if mode == "tee" {
+		log.Infof("upload %s to %s (%s) and %d mirrors, each file is read and compressed once", backupName, destinations[0].Name, destinations[0].RemoteStorage, len(mirrorConfigs))
+		teeMirrors := newTeeMirrors(mirrorNames, mirrorConfigs)
+		b.teeMirrors = teeMirrors
+		uploadErr := uploaders[0]()
+		b.teeMirrors = nil
+		if errors.Is(uploadErr, errTeeResume) {
+			log.Infof("%v, upload to each destination one after another", uploadErr)
+			mode = "sequential"
+		} else {
+			for i := range destinations {
+				if i > 0 && uploadErr == nil {
+					setStatus(i, teeMirrors.errors[i-1])
+				} else {
+					setStatus(i, uploadErr)
+				}
+			}
+		}
+	}
+	switch mode {
+	case "tee":
+		// already uploaded to all destinations
+	case "parallel":
+		// all destinations read the same local files, so the second read usually hits page cache
+		wg := sync.WaitGroup{}
+		for i := range uploaders {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				runUploader(i)
+			}(i)
+		}
+		wg.Wait()
+	default:
+		for i := range uploaders {
+			runUploader(i)
+		}
+	}
+
+	defaultDataPath := b.DefaultDataPath
+	for i := 0; defaultDataPath == "" && i < len(mirrorBackupers); i++ {
+		defaultDataPath = mirrorBackupers[i].DefaultDataPath
+	}
+	if defaultDataPath != "" {
+		if err = b.saveUploadDestinations(backupName, defaultDataPath, destinations); err != nil {
+			log.Warnf("can't save upload destinations status: %v", err)
+		}
+	}
+	failed := make([]string, 0)
+	for _, destination := range destinations {
+		if destination.Status != "success" {
+			failed = append(failed, fmt.Sprintf("%s: %s", destination.Name, destination.Error))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("upload %s failed for %d of %d destinations, %s", backupName, len(failed), len(destinations), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// saveUploadDestinations - merge upload status with previous statuses from local metadata.json, to keep result of previous upload for each destination
+func (b *Backuper) saveUploadDestinations(backupName, defaultDataPath string, destinations []metadata.UploadStatus) error {
+	metadataFile := path.Join(defaultDataPath, "backup", backupName, "metadata.json")
+	metadataBody, err := os.ReadFile(metadataFile)
+	if err != nil {
+		return err
+	}
+	backupMetadata, err := metadata.ParseBackupMetadata(metadataBody)
+	if err != nil {
+		return fmt.Errorf("can't parse %s: %v", metadataFile, err)
+	}
+	for _, destination := range destinations {
+		updated := false
+		for i := range backupMetadata.Destinations {
+			if backupMetadata.Destinations[i].Name == destination.Name {
+				backupMetadata.Destinations[i] = destination
+				updated = true
+				break
+			}
+		}
+		if !updated {
+			backupMetadata.Destinations = append(backupMetadata.Destinations, destination)
+		}
+	}
+	return backupMetadata.Save(metadataFile)
+}
+
+// errTeeResume - upload state of interrupted upload contains files uploaded only to general->remote_storage or mirrors of previous upload
+var errTeeResume = errors.New("backup already exists on remote storage, upload_mirrors_mode: tee can't resume upload for mirrors")
+
+// teeMirrors - `upload_mirrors_mode: tee`, mirrors receive the same compressed stream as general->remote_storage through storage.TeeStorage,
+// errors[i] is set when mirror can't be prepared before upload, one of writes failed or retention failed
+type teeMirrors struct {
+	names   []string
+	configs []*config.Config
+	dsts    []*storage.BackupDestination
+	errors  []error
+	tee     *storage.TeeStorage
+	// teeIndexes - index of mirror inside tee, -1 when mirror failed before upload
+	teeIndexes []int
+}
+
+func newTeeMirrors(names []string, configs []*config.Config) *teeMirrors {
+	return &teeMirrors{
+		names:      names,
+		configs:    configs,
+		dsts:       make([]*storage.BackupDestination, len(configs)),
+		errors:     make([]error, len(configs)),
+		teeIndexes: make([]int, len(configs)),
+	}
+}
+
+// getTeeIncompatibility - reason why mirrors can't receive the same bytes as general->remote_storage, empty string when `upload_mirrors_mode: tee` is applicable,
+// compressed data, metadata.json and object keys shall be the same for all destinations
+func (b *Backuper) getTeeIncompatibility(mirrorNames []string, mirrorConfigs []*config.Config) string {
+	switch {
+	case b.dryRun:
+		return "--dry-run shows each destination separately"
+	case b.cfg.General.RemoteStorage == "custom":
+		return "remote_storage: custom uploads via external command"
+	case b.cfg.General.DedupStore:
+		return "dedup_store: true checks existing chunks on each destination"
+	}
+	mainGeneral := b.cfg.General
+	for i, mirrorCfg := range mirrorConfigs {
+		mirrorGeneral := mirrorCfg.General
+		mb := &Backuper{cfg: mirrorCfg}
+		switch {
+		case mirrorCfg.GetCompressionFormat() != b.cfg.GetCompressionFormat() || mirrorCfg.GetCompressionLevel() != b.cfg.GetCompressionLevel():
+			return fmt.Sprintf("%s uses other compression_format or compression_level", mirrorNames[i])
+		case mirrorGeneral.DedupStore:
+			return fmt.Sprintf("%s uses dedup_store: true", mirrorNames[i])
+		case mirrorGeneral.ZstdWindowLog != mainGeneral.ZstdWindowLog || mirrorGeneral.ZstdDictionary != mainGeneral.ZstdDictionary || !reflect.DeepEqual(mirrorGeneral.CompressionLevelTiers, mainGeneral.CompressionLevelTiers):
+			return fmt.Sprintf("%s uses other zstd_window_log, zstd_dictionary or compression_level_by_table_size", mirrorNames[i])
+		case !reflect.DeepEqual(mirrorGeneral.TableStorageRules, mainGeneral.TableStorageRules) || (len(mainGeneral.TableStorageRules) > 0 && mirrorGeneral.RemoteStorage != mainGeneral.RemoteStorage):
+			return fmt.Sprintf("%s uses other table_storage_rules", mirrorNames[i])
+		case !reflect.DeepEqual(mb.getEncryptionMetadata(), b.getEncryptionMetadata()):
+			return fmt.Sprintf("%s uses other server side encryption, it is saved in metadata.json", mirrorNames[i])
+		}
+	}
+	return ""
+}
+
+// initTeeMirrors - connect to each mirror and replace remote storage of b.dst with storage.TeeStorage, mirror which can't connect or already contains backup is skipped,
+// each object is split into segments which fit into all destinations
+func (b *Backuper) initTeeMirrors(ctx context.Context, backupName string) {
+	m := b.teeMirrors
+	mirrorStorages := make([]storage.RemoteStorage, 0, len(m.configs))
+	segmentSize := b.dst.SegmentSize()
+	for i, mirrorCfg := range m.configs {
+		m.teeIndexes[i] = -1
+		applyCustomStorageClassMap(mirrorCfg, backupName)
+		dst, err := storage.NewBackupDestination(ctx, mirrorCfg, b.ch, true, backupName)
+		if err != nil {
+			m.errors[i] = err
+			continue
+		}
+		if err = dst.Connect(ctx); err != nil {
+			m.errors[i] = fmt.Errorf("can't connect to %s: %v", dst.Kind(), err)
+			continue
+		}
+		m.dsts[i] = dst
+		remoteBackups, err := dst.BackupList(ctx, false, backupName)
+		if err != nil {
+			m.errors[i] = fmt.Errorf("BackupList return error: %v", err)
+			continue
+		}
+		for _, remoteBackup := range remoteBackups {
+			if remoteBackup.BackupName == backupName {
+				m.errors[i] = fmt.Errorf("'%s' already exists on remote storage", backupName)
+			}
+		}
+		if m.errors[i] != nil {
+			continue
+		}
+		if mirrorSegmentSize := dst.SegmentSize(); mirrorSegmentSize > 0 && (segmentSize <= 0 || mirrorSegmentSize < segmentSize) {
+			segmentSize = mirrorSegmentSize
+		}
+		m.teeIndexes[i] = len(mirrorStorages)
+		mirrorStorages = append(mirrorStorages, dst.RemoteStorage)
+	}
+	m.tee = storage.NewTeeStorage(b.dst.RemoteStorage, mirrorStorages...)
+	b.dst.RemoteStorage = m.tee
+	b.dst.SetSegmentSize(segmentSize)
+}
+
+// stopTeeMirrors - restore remote storage of b.dst and collect errors of writes into mirrors, could be called twice
+func (b *Backuper) stopTeeMirrors() {
+	m := b.teeMirrors
+	if m.tee == nil {
+		return
+	}
+	b.dst.RemoteStorage = m.tee.Primary()
+	for i, teeIndex := range m.teeIndexes {
+		if teeIndex >= 0 && m.errors[i] == nil {
+			m.errors[i] = m.tee.MirrorError(teeIndex)
+		}
+	}
+	m.tee = nil
+}
+
+// removeOldBackupsTeeMirrors - retention settings of each mirror applied after successful upload, like for general->remote_storage
+func (b *Backuper) removeOldBackupsTeeMirrors(ctx context.Context) {
+	b.stopTeeMirrors()
+	m := b.teeMirrors
+	for i, dst := range m.dsts {
+		if dst == nil || m.errors[i] != nil {
+			continue
+		}
+		deletedBackups, err := dst.RemoveOldBackups(ctx, storage.NewBackupRetention(m.configs[i]), false)
+		if err != nil {
+			m.errors[i] = fmt.Errorf("can't remove old backups on remote storage: %v", err)
+			continue
+		}
+		b.notifyRetentionDelete("remote", getBackupNames(deletedBackups))
+	}
+}
+
+func (b *Backuper) closeTeeMirrors(ctx context.Context) {
+	b.stopTeeMirrors()
+	for i, dst := range b.teeMirrors.dsts {
+		if dst == nil {
+			continue
+		}
+		if err := dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination for %s error: %v", b.teeMirrors.names[i], err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeeIncompatibility(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RemoteStorage = "gcs"
+	cfg.GCS.CompressionFormat = "zstd"
+	cfg.GCS.CompressionLevel = 3
+	b := &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test")}
+	mirrorCfg := config.DefaultConfig()
+	mirrorCfg.General.RemoteStorage = "s3"
+	mirrorCfg.S3.CompressionFormat = "zstd"
+	mirrorCfg.S3.CompressionLevel = 3
+	names := []string{"dr"}
+	mirrors := []*config.Config{mirrorCfg}
+	assert.Empty(t, b.getTeeIncompatibility(names, mirrors))
+
+	b.dryRun = true
+	assert.Contains(t, b.getTeeIncompatibility(names, mirrors), "--dry-run")
+	b.dryRun = false
+	mirrorCfg.S3.CompressionLevel = 1
+	assert.Contains(t, b.getTeeIncompatibility(names, mirrors), "dr uses other compression")
+	mirrorCfg.S3.CompressionLevel = 3
+	mirrorCfg.S3.SSE = "AES256"
+	assert.Contains(t, b.getTeeIncompatibility(names, mirrors), "dr uses other server side encryption")
+	mirrorCfg.S3.SSE = ""
+	cfg.General.TableStorageRules = []config.TableStorageRule{{Tables: "db.*", PathPrefix: "archive"}}
+	assert.Contains(t, b.getTeeIncompatibility(names, mirrors), "table_storage_rules")
+	mirrorCfg.General.TableStorageRules = cfg.General.TableStorageRules
+	mirrorCfg.General.RemoteStorage = "gcs"
+	mirrorCfg.GCS.CompressionFormat = "zstd"
+	mirrorCfg.GCS.CompressionLevel = 3
+	assert.Empty(t, b.getTeeIncompatibility(names, mirrors))
+	cfg.General.DedupStore = true
+	assert.Contains(t, b.getTeeIncompatibility(names, mirrors), "dedup_store")
+}
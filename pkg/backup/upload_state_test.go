@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/resumable"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (m *objectsStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if body, exists := m.objects[key]; exists {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *objectsStorage) DeleteFile(ctx context.Context, key string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// Walk - not recursive walk returns only first level of names after prefix, like directories in backups list
+func (m *objectsStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(context.Context, storage.RemoteFile) error) error {
+	prefix = strings.TrimPrefix(prefix, "/")
+	m.mx.Lock()
+	names := make(map[string]struct{})
+	for key := range m.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, prefix)
+		if !recursive {
+			name = strings.SplitN(name, "/", 2)[0]
+		}
+		names[name] = struct{}{}
+	}
+	m.mx.Unlock()
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+	for _, name := range sortedNames {
+		if err := fn(ctx, memoryRemoteFile{name: name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newUploadStateTestBackuper(t *testing.T, remote *objectsStorage) *Backuper {
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.WithField("logger", "test"), DefaultDataPath: t.TempDir()}
+	b.dst = &storage.BackupDestination{RemoteStorage: remote, Log: b.log}
+	require.NoError(t, os.MkdirAll(path.Join(b.DefaultDataPath, "backup", "backup1"), 0750))
+	return b
+}
+
+func TestUploadStatePersistAndRestore(t *testing.T) {
+	ctx := context.Background()
+	remote := &objectsStorage{objects: map[string][]byte{}}
+	b := newUploadStateTestBackuper(t, remote)
+	localStateFile := path.Join(b.DefaultDataPath, "backup", "backup1", uploadStateFile)
+
+	b.resumableState = resumable.NewState(b.DefaultDataPath, "backup1", "upload", map[string]interface{}{"diffFrom": ""})
+	b.resumableState.AppendToState("backup1/shadow/db/t1/default_all_1_1_0.tar", 10)
+	remoteState := &remoteUploadState{}
+	b.uploadUploadState(ctx, "backup1", remoteState)
+	b.resumableState.AppendToState("backup1/shadow/db/t2/default_all_1_1_0.tar", 20)
+	b.uploadUploadState(ctx, "backup1", remoteState)
+	b.resumableState.Close()
+	chunks, err := b.getRemoteUploadStateChunks(ctx, "backup1")
+	require.NoError(t, err)
+	assert.Len(t, chunks, 2, "each call shall append only new lines")
+	localState, err := os.ReadFile(localStateFile)
+	require.NoError(t, err)
+	assert.Equal(t, len(localState), remoteState.uploadedSize)
+
+	// other host without local state
+	other := newUploadStateTestBackuper(t, remote)
+	other.downloadUploadStateIfNotExists(ctx, "backup1")
+	restoredState, err := os.ReadFile(path.Join(other.DefaultDataPath, "backup", "backup1", uploadStateFile))
+	require.NoError(t, err)
+	assert.Equal(t, localState, restoredState)
+
+	// restored state is already present on remote storage, so next call uploads only new lines
+	other.resumableState = resumable.NewState(other.DefaultDataPath, "backup1", "upload", nil)
+	other.resumableState.AppendToState("backup1/shadow/db/t3/default_all_1_1_0.tar", 30)
+	other.resumableState.Close()
+	otherRemoteState := &remoteUploadState{}
+	other.uploadUploadState(ctx, "backup1", otherRemoteState)
+	chunks, err = other.getRemoteUploadStateChunks(ctx, "backup1")
+	require.NoError(t, err)
+	assert.Len(t, chunks, 2, "last chunk shall be replaced with the same offset")
+	otherState, err := os.ReadFile(path.Join(other.DefaultDataPath, "backup", "backup1", uploadStateFile))
+	require.NoError(t, err)
+	assert.Equal(t, len(otherState), otherRemoteState.uploadedSize)
+
+	b.deleteRemoteUploadState(ctx, "backup1")
+	chunks, err = b.getRemoteUploadStateChunks(ctx, "backup1")
+	require.NoError(t, err)
+	assert.Empty(t, chunks)
+}
+
+func TestUploadStateShorterLocalState(t *testing.T) {
+	ctx := context.Background()
+	remote := &objectsStorage{objects: map[string][]byte{
+		path.Join("backup1", uploadStateFile+".d", "00000000000000000000"): bytes.Repeat([]byte("x\n"), 100),
+		path.Join("backup1", uploadStateFile+".d", "00000000000000000200"): []byte("y\n"),
+	}}
+	b := newUploadStateTestBackuper(t, remote)
+	b.resumableState = resumable.NewState(b.DefaultDataPath, "backup1", "upload", nil)
+	b.resumableState.AppendToState("backup1/shadow/db/t1/default_all_1_1_0.tar", 10)
+	b.resumableState.Close()
+	localState, err := os.ReadFile(path.Join(b.DefaultDataPath, "backup", "backup1", uploadStateFile))
+	require.NoError(t, err)
+	require.Less(t, len(localState), 200)
+
+	remoteState := &remoteUploadState{}
+	require.NotPanics(t, func() {
+		b.uploadUploadState(ctx, "backup1", remoteState)
+	})
+	assert.Equal(t, map[string][]byte{path.Join("backup1", uploadStateFile+".d", "00000000000000000000"): localState}, remote.objects, "remote state shall be replaced with local one")
+	assert.Equal(t, len(localState), remoteState.uploadedSize)
+}
+
+func TestIsAlreadyUploaded(t *testing.T) {
+	ctx := context.Background()
+	remote := &objectsStorage{objects: map[string][]byte{"backup1/shadow/db/t1/default_all_1_1_0.tar": []byte("0123456789")}}
+	b := newUploadStateTestBackuper(t, remote)
+	b.resumableState = resumable.NewState(b.DefaultDataPath, "backup1", "upload", nil)
+	defer b.resumableState.Close()
+	b.resumableState.AppendToState("backup1/shadow/db/t1/default_all_1_1_0.tar", 10)
+	b.resumableState.AppendToState("backup1/shadow/db/t2/default_all_1_1_0.tar", 10)
+
+	isUploaded, size := b.isAlreadyUploaded(ctx, "backup1/shadow/db/t1/default_all_1_1_0.tar")
+	assert.True(t, isUploaded)
+	assert.Equal(t, int64(10), size)
+	isUploaded, _ = b.isAlreadyUploaded(ctx, "backup1/shadow/db/t2/default_all_1_1_0.tar")
+	assert.False(t, isUploaded, "object absent on remote storage shall be uploaded again")
+	remote.objects["backup1/shadow/db/t1/default_all_1_1_0.tar"] = []byte("01234")
+	isUploaded, _ = b.isAlreadyUploaded(ctx, "backup1/shadow/db/t1/default_all_1_1_0.tar")
+	assert.False(t, isUploaded, "truncated object shall be uploaded again")
+	isUploaded, _ = b.isAlreadyUploaded(ctx, "backup1/shadow/db/t3/default_all_1_1_0.tar")
+	assert.False(t, isUploaded)
+}
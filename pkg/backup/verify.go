@@ -0,0 +1,443 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	"github.com/eapache/go-resiliency/retrier"
+)
+
+// backupSentinelSuffix - sentinel stored near `shadow/db/table/disk` directory, so it doesn't affect parts upload and restore
+const backupSentinelSuffix = ".sentinel.json"
+
+var sentinelCRC64Table = crc64.MakeTable(crc64.ECMA)
+
+func getBackupSentinelFile(tableDiskPath string) string {
+	return tableDiskPath + backupSentinelSuffix
+}
+
+func calculateFileCRC64(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := crc64.New(sentinelCRC64Table)
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%016x", h.Sum64()), nil
+}
+
+// writeBackupSentinel - save file list, sizes and checksums for all parts in `shadow/db/table/disk` directory
+func (b *Backuper) writeBackupSentinel(tableDiskPath string, parts []metadata.Part, disks []clickhouse.Disk) error {
+	sentinel := metadata.BackupSentinel{
+		CreationDate: time.Now(),
+		Files:        make(map[string]metadata.SentinelFile),
+	}
+	for _, part := range parts {
+		err := filepath.Walk(path.Join(tableDiskPath, part.Name), func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			checksum, err := calculateFileCRC64(filePath)
+			if err != nil {
+				return err
+			}
+			sentinel.Files[filesystemhelper.RelativePath(tableDiskPath, filePath)] = metadata.SentinelFile{
+				Size:  info.Size(),
+				CRC64: checksum,
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("can't calculate sentinel for %s: %v", path.Join(tableDiskPath, part.Name), err)
+		}
+	}
+	sentinelFile := getBackupSentinelFile(tableDiskPath)
+	if err := sentinel.Save(sentinelFile); err != nil {
+		return err
+	}
+	return filesystemhelper.Chown(sentinelFile, b.ch, disks, false)
+}
+
+// uploadBackupSentinels - sentinels upload as separate small objects, so `verify --remote` doesn't need to download archives,
+// archiveSizes contains remote sizes of uploaded archives for each disk, which allow detect truncated archives
+func (b *Backuper) uploadBackupSentinels(ctx context.Context, backupName string, table metadata.TableMetadata, archiveSizes map[string]map[string]int64) error {
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	for disk := range table.Parts {
+		sentinel := metadata.BackupSentinel{}
+		if err := sentinel.Load(getBackupSentinelFile(b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath))); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		sentinel.Archives = archiveSizes[disk]
+		body, err := sentinel.Marshal()
+		if err != nil {
+			return err
+		}
+		remoteSentinelFile := path.Join(remoteTableDataPath(backupName, table), disk+backupSentinelSuffix)
+		retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+		err = retry.RunCtx(ctx, func(ctx context.Context) error {
+			return b.dst.PutFile(ctx, remoteSentinelFile, io.NopCloser(bytes.NewReader(body)))
+		})
+		if err != nil {
+			return fmt.Errorf("can't upload %s: %v", remoteSentinelFile, err)
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) downloadBackupSentinels(ctx context.Context, backupName string, table metadata.TableMetadata) error {
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	for disk := range table.Parts {
+		remoteSentinelFile := path.Join(remoteTableDataPath(backupName, table), disk+backupSentinelSuffix)
+		if _, err := b.dst.StatFile(ctx, remoteSentinelFile); err != nil {
+			b.log.WithField("logger", "downloadBackupSentinels").Debugf("%s not exists on remote storage, skip download", remoteSentinelFile)
+			continue
+		}
+		body, err := b.readRemoteFile(ctx, remoteSentinelFile)
+		if err != nil {
+			return err
+		}
+		localSentinelFile := getBackupSentinelFile(b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath))
+		if err = os.MkdirAll(path.Dir(localSentinelFile), 0750); err != nil {
+			return err
+		}
+		if err = os.WriteFile(localSentinelFile, body, 0640); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) readRemoteFile(ctx context.Context, remotePath string) ([]byte, error) {
+	var body []byte
+	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		reader, err := b.dst.GetFileReader(ctx, remotePath)
+		if err != nil {
+			return err
+		}
+		if body, err = io.ReadAll(reader); err != nil {
+			return err
+		}
+		return reader.Close()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s: %v", remotePath, err)
+	}
+	return body, nil
+}
+
+// sentinelPartsFilter - files in sentinel which shall present, Required parts stored in another backup, parts could be filtered by --partitions during download
+func sentinelPartsFilter(parts []metadata.Part, skipRequired bool) map[string]struct{} {
+	result := make(map[string]struct{}, len(parts))
+	for _, part := range parts {
+		if skipRequired && part.Required {
+			continue
+		}
+		result[part.Name] = struct{}{}
+	}
+	return result
+}
+
+func isSentinelFileInParts(fileName string, parts map[string]struct{}) bool {
+	_, exists := parts[strings.SplitN(fileName, "/", 2)[0]]
+	return exists
+}
+
+// Verify - check backup integrity with sentinel files written during `create`,
+// local backup checks file list, sizes and checksums, remote backup checks objects presence and sizes without downloading archives
+func (b *Backuper) Verify(backupName string, remote bool, commandId int) error {
+	b.setCommandLog(commandId)
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+
+	var problems []string
+	var checkedDirs int
+	if remote {
+		problems, checkedDirs, err = b.verifyRemote(ctx, backupName)
+	} else {
+		problems, checkedDirs, err = b.verifyLocal(ctx, backupName)
+	}
+	if err != nil {
+		return err
+	}
+	log := b.log.WithField("logger", "Verify").WithField("backup", backupName)
+	for _, problem := range problems {
+		log.Error(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("backup %s verification failed, %d problems found", backupName, len(problems))
+	}
+	log.Infof("verified %d directories, no problems found", checkedDirs)
+	return nil
+}
+
+func (b *Backuper) verifyLocal(ctx context.Context, backupName string) ([]string, int, error) {
+	_, disks, err := b.getLocalBackup(ctx, backupName, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("can't find local backup: %v", err)
+	}
+	if b.DefaultDataPath, err = b.ch.GetDefaultPath(disks); err != nil {
+		return nil, 0, ErrUnknownClickhouseDataPath
+	}
+	b.DiskToPathMap = make(map[string]string, len(disks))
+	for _, disk := range disks {
+		b.DiskToPathMap[disk.Name] = disk.Path
+	}
+	backupMetadata, err := b.ReadBackupMetadataLocal(ctx, backupName)
+	if err != nil {
+		return nil, 0, err
+	}
+	if strings.Contains(backupMetadata.Tags, "embedded") {
+		return nil, 0, fmt.Errorf("verify doesn't support embedded backups")
+	}
+	tables, _, err := b.getTableListByPatternLocal(ctx, path.Join(b.DefaultDataPath, "backup", backupName, "metadata"), "", false, []string{})
+	if err != nil {
+		return nil, 0, err
+	}
+	log := b.log.WithField("logger", "verifyLocal")
+	problems := make([]string, 0)
+	checkedDirs := 0
+	for _, table := range tables {
+		if table.MetadataOnly {
+			continue
+		}
+		dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+		for disk, parts := range table.Parts {
+			if err = ctx.Err(); err != nil {
+				return nil, 0, err
+			}
+			tableDiskPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
+			sentinel := metadata.BackupSentinel{}
+			if err = sentinel.Load(getBackupSentinelFile(tableDiskPath)); err != nil {
+				if os.IsNotExist(err) {
+					log.Warnf("%s not found, backup created without `sentinel_files: true`?", getBackupSentinelFile(tableDiskPath))
+					continue
+				}
+				problems = append(problems, err.Error())
+				continue
+			}
+			checkedDirs += 1
+			problems = append(problems, checkSentinelFiles(tableDiskPath, sentinel, sentinelPartsFilter(parts, false))...)
+			for _, part := range parts {
+				err = filepath.Walk(path.Join(tableDiskPath, part.Name), func(filePath string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					if !info.Mode().IsRegular() {
+						return nil
+					}
+					if _, exists := sentinel.Files[filesystemhelper.RelativePath(tableDiskPath, filePath)]; !exists {
+						problems = append(problems, fmt.Sprintf("%s: unexpected file, not present in sentinel", filePath))
+					}
+					return nil
+				})
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %v", path.Join(tableDiskPath, part.Name), err))
+				}
+			}
+		}
+	}
+	return problems, checkedDirs, nil
+}
+
+// checkSentinelFiles - compare size and checksum of local files which belong to parts with sentinel
+func checkSentinelFiles(tableDiskPath string, sentinel metadata.BackupSentinel, parts map[string]struct{}) []string {
+	problems := make([]string, 0)
+	for fileName, expected := range sentinel.Files {
+		if !isSentinelFileInParts(fileName, parts) {
+			continue
+		}
+		filePath := path.Join(tableDiskPath, fileName)
+		info, err := os.Stat(filePath)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", filePath, err))
+			continue
+		}
+		if info.Size() != expected.Size {
+			problems = append(problems, fmt.Sprintf("%s: size %d, expected %d", filePath, info.Size(), expected.Size))
+			continue
+		}
+		checksum, err := calculateFileCRC64(filePath)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", filePath, err))
+			continue
+		}
+		if checksum != expected.CRC64 {
+			problems = append(problems, fmt.Sprintf("%s: crc64 %s, expected %s", filePath, checksum, expected.CRC64))
+		}
+	}
+	return problems
+}
+
+func (b *Backuper) verifyRemote(ctx context.Context, backupName string) ([]string, int, error) {
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return nil, 0, fmt.Errorf("verify --remote doesn't support remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if err := b.init(ctx, nil, backupName); err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	remoteBackup, err := b.ReadBackupMetadataRemote(ctx, backupName)
+	if err != nil {
+		return nil, 0, err
+	}
+	b.dst.SetSegmentSize(remoteBackup.SegmentSize)
+	if err = b.loadZstdDictionaries(ctx, remoteBackup); err != nil {
+		return nil, 0, err
+	}
+	if strings.Contains(remoteBackup.Tags, "embedded") {
+		return nil, 0, fmt.Errorf("verify doesn't support embedded backups")
+	}
+	log := b.log.WithField("logger", "verifyRemote")
+	problems := make([]string, 0)
+	checkedDirs := 0
+	for _, tableTitle := range remoteBackup.Tables {
+		remoteTableMetadataFile := path.Join(backupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
+		body, err := b.readRemoteFile(ctx, remoteTableMetadataFile)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		table := metadata.TableMetadata{}
+		if err = json.Unmarshal(body, &table); err != nil {
+			problems = append(problems, fmt.Sprintf("can't unmarshal %s: %v", remoteTableMetadataFile, err))
+			continue
+		}
+		if table.MetadataOnly {
+			continue
+		}
+		remoteTablePath := remoteTableDataPath(backupName, table)
+		for disk, parts := range table.Parts {
+			if err = ctx.Err(); err != nil {
+				return nil, 0, err
+			}
+			remoteSentinelFile := path.Join(remoteTablePath, disk+backupSentinelSuffix)
+			if _, err = b.dst.StatFile(ctx, remoteSentinelFile); err != nil {
+				log.Warnf("%s not found, backup created without `sentinel_files: true`?", remoteSentinelFile)
+				problems = append(problems, b.checkRemoteArchives(ctx, remoteTablePath, table.Files[disk], nil, remoteBackup.DataFormat)...)
+				continue
+			}
+			body, err = b.readRemoteFile(ctx, remoteSentinelFile)
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			sentinel := metadata.BackupSentinel{}
+			if err = sentinel.Unmarshal(body, remoteSentinelFile); err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			checkedDirs += 1
+			problems = append(problems, b.checkRemoteArchives(ctx, remoteTablePath, table.Files[disk], sentinel.Archives, remoteBackup.DataFormat)...)
+			partsFilter := sentinelPartsFilter(parts, true)
+			for partName := range partsFilter {
+				partExists := false
+				for fileName := range sentinel.Files {
+					if strings.HasPrefix(fileName, partName+"/") {
+						partExists = true
+						break
+					}
+				}
+				if !partExists {
+					problems = append(problems, fmt.Sprintf("%s: part %s not present in sentinel", remoteSentinelFile, partName))
+				}
+			}
+			// archives can't be checked without download, but for `compression_format: none` each file is separate object
+			if remoteBackup.DataFormat != DirectoryFormat {
+				continue
+			}
+			remoteFiles := make(map[string]int64)
+			remoteDiskPath := path.Join(remoteTablePath, disk)
+			err = b.dst.Walk(ctx, remoteDiskPath+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+				// file bigger than segment size uploaded as several objects
+				fileName, _ := storage.SegmentBaseKey(strings.TrimPrefix(f.Name(), "/"))
+				remoteFiles[fileName] += f.Size()
+				return nil
+			})
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", remoteDiskPath, err))
+				continue
+			}
+			for fileName, expected := range sentinel.Files {
+				if !isSentinelFileInParts(fileName, partsFilter) {
+					continue
+				}
+				if size, exists := remoteFiles[fileName]; !exists {
+					problems = append(problems, fmt.Sprintf("%s: not found", path.Join(remoteDiskPath, fileName)))
+				} else if size != expected.Size {
+					problems = append(problems, fmt.Sprintf("%s: size %d, expected %d", path.Join(remoteDiskPath, fileName), size, expected.Size))
+				}
+			}
+		}
+	}
+	return problems, checkedDirs, nil
+}
+
+// checkRemoteArchives - archives can't be checked without download, so compare remote object sizes with sizes saved into sentinel during `upload`,
+// sentinel without archive sizes allow detect only missing and empty archives
+func (b *Backuper) checkRemoteArchives(ctx context.Context, remoteTablePath string, archiveFiles []string, archiveSizes map[string]int64, dataFormat string) []string {
+	problems := make([]string, 0)
+	if dataFormat == DirectoryFormat {
+		return problems
+	}
+	for _, archiveFile := range archiveFiles {
+		remoteArchive := path.Join(remoteTablePath, archiveFile)
+		remoteFile, err := b.dst.StatFile(ctx, remoteArchive)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", remoteArchive, err))
+			continue
+		}
+		if remoteFile.Size() == 0 {
+			problems = append(problems, fmt.Sprintf("%s: empty archive", remoteArchive))
+			continue
+		}
+		if expectedSize, exists := archiveSizes[archiveFile]; exists && remoteFile.Size() != expectedSize {
+			problems = append(problems, fmt.Sprintf("%s: size %d, expected %d, archive truncated or changed", remoteArchive, remoteFile.Size(), expectedSize))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// objectsStorage - keys with content, enough for sentinels and archive sizes
+type objectsStorage struct {
+	storage.RemoteStorage
+	mx      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *objectsStorage) Kind() string {
+	return "memory"
+}
+
+func (m *objectsStorage) StatFile(ctx context.Context, key string) (storage.RemoteFile, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if body, exists := m.objects[key]; exists {
+		return memoryRemoteFile{name: key, size: int64(len(body))}, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *objectsStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.objects[key] = body
+	return r.Close()
+}
+
+func TestVerifyRemoteArchives(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RetriesOnFailure = 0
+	dataPath := t.TempDir()
+	remote := &objectsStorage{objects: map[string][]byte{}}
+	b := &Backuper{cfg: cfg, log: apexLog.WithField("logger", "test"), DiskToPathMap: map[string]string{"default": dataPath}}
+	b.dst = &storage.BackupDestination{RemoteStorage: remote, Log: b.log}
+	ctx := context.Background()
+	table := metadata.TableMetadata{
+		Database: "db",
+		Table:    "t1",
+		Parts:    map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}},
+		Files:    map[string][]string{"default": {"default_all_1_1_0.tar.zstd"}},
+	}
+	tableDiskPath := b.getLocalBackupDataPathForTable("backup1", "default", path.Join("db", "t1"))
+	require.NoError(t, os.MkdirAll(tableDiskPath, 0750))
+	sentinel := metadata.BackupSentinel{Files: map[string]metadata.SentinelFile{"all_1_1_0/data.bin": {Size: 10, CRC64: "0000000000000001"}}}
+	require.NoError(t, sentinel.Save(getBackupSentinelFile(tableDiskPath)))
+
+	remoteTablePath := remoteTableDataPath("backup1", table)
+	remoteArchive := path.Join(remoteTablePath, "default_all_1_1_0.tar.zstd")
+	remote.objects[remoteArchive] = []byte("archive content")
+	require.NoError(t, b.uploadBackupSentinels(ctx, "backup1", table, map[string]map[string]int64{"default": {"default_all_1_1_0.tar.zstd": 15}}))
+
+	remoteSentinel := metadata.BackupSentinel{}
+	require.NoError(t, remoteSentinel.Unmarshal(remote.objects[path.Join(remoteTablePath, "default"+backupSentinelSuffix)], "remote sentinel"))
+	assert.Equal(t, map[string]int64{"default_all_1_1_0.tar.zstd": 15}, remoteSentinel.Archives)
+	assert.Empty(t, b.checkRemoteArchives(ctx, remoteTablePath, table.Files["default"], remoteSentinel.Archives, "zstd"))
+
+	remote.objects[remoteArchive] = []byte("archive")
+	assert.Equal(t, []string{remoteArchive + ": size 7, expected 15, archive truncated or changed"}, b.checkRemoteArchives(ctx, remoteTablePath, table.Files["default"], remoteSentinel.Archives, "zstd"))
+	assert.Empty(t, b.checkRemoteArchives(ctx, remoteTablePath, table.Files["default"], nil, "zstd"), "sentinel without archive sizes can't detect truncation")
+	delete(remote.objects, remoteArchive)
+	assert.Len(t, b.checkRemoteArchives(ctx, remoteTablePath, table.Files["default"], remoteSentinel.Archives, "zstd"), 1)
+	assert.Empty(t, b.checkRemoteArchives(ctx, remoteTablePath, table.Files["default"], remoteSentinel.Archives, DirectoryFormat))
+}
@@ -3,34 +3,22 @@ package backup
 import (
 	"context"
 	"fmt"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"github.com/Altinity/clickhouse-backup/pkg/server/metrics"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
 	apexLog "github.com/apex/log"
 	"github.com/urfave/cli"
-	"regexp"
 	"strings"
 	"time"
 )
 
-var watchBackupTemplateTimeRE = regexp.MustCompile(`{time:([^}]+)}`)
-
 func (b *Backuper) NewBackupWatchName(ctx context.Context, backupType string) (string, error) {
-	backupName, err := b.ch.ApplyMacros(ctx, b.cfg.General.WatchBackupNameTemplate)
-	if err != nil {
-		return "", err
-	}
-	backupName = strings.Replace(backupName, "{type}", backupType, -1)
-	if watchBackupTemplateTimeRE.MatchString(backupName) {
-		for _, group := range watchBackupTemplateTimeRE.FindAllStringSubmatch(backupName, -1) {
-			templateItem := group[0]
-			layout := group[1]
-			backupName = strings.ReplaceAll(backupName, templateItem, time.Now().UTC().Format(layout))
-		}
-	} else {
+	if !backupNameTemplateTimeRE.MatchString(b.cfg.General.WatchBackupNameTemplate) {
 		return "", fmt.Errorf("watch_backup_name_template doesn't contain {time:layout}, backup name will non unique")
 	}
-	return backupName, nil
+	backupName := strings.Replace(b.cfg.General.WatchBackupNameTemplate, "{type}", backupType, -1)
+	return b.applyBackupNameTemplate(ctx, backupName, time.Now())
 }
 
 func (b *Backuper) ValidateWatchParams(watchInterval, fullInterval, watchBackupNameTemplate string) error {
@@ -59,6 +47,38 @@ func (b *Backuper) ValidateWatchParams(watchInterval, fullInterval, watchBackupN
 	return nil
 }
 
+// detectNewDatabases - compare current databases with knownDatabases and apply general->watch_new_databases_policy to databases which created after first watch iteration,
+// config could be reloaded on each watch iteration, so skip_tables for excludedDatabases applied again
+func (b *Backuper) detectNewDatabases(ctx context.Context, knownDatabases common.EmptyMap, includedDatabases *[]string, excludedDatabases common.EmptyMap) (common.EmptyMap, error) {
+	databases, err := b.ch.GetDatabases(ctx, b.cfg, "")
+	if err != nil {
+		return nil, fmt.Errorf("can't get databases for detect new databases: %v", err)
+	}
+	firstIteration := knownDatabases == nil
+	if firstIteration {
+		knownDatabases = make(common.EmptyMap, len(databases))
+	}
+	for _, db := range databases {
+		if _, exists := knownDatabases[db.Name]; !exists && !firstIteration {
+			switch b.cfg.General.WatchNewDatabasesPolicy {
+			case "exclude":
+				b.log.Warnf("new database `%s` detected, will exclude from watch backups, watch_new_databases_policy=exclude", db.Name)
+				excludedDatabases[db.Name] = struct{}{}
+			case "alert":
+				b.log.Warnf("new database `%s` detected, check `--tables` and `skip_tables` to make sure it protected, watch_new_databases_policy=alert", db.Name)
+			default:
+				b.log.Infof("new database `%s` detected, will include to watch backups, watch_new_databases_policy=include", db.Name)
+				*includedDatabases = common.AddStringToSliceIfNotExists(*includedDatabases, db.Name)
+			}
+		}
+		knownDatabases[db.Name] = struct{}{}
+		if _, excluded := excludedDatabases[db.Name]; excluded {
+			b.cfg.ClickHouse.SkipTables = common.AddStringToSliceIfNotExists(b.cfg.ClickHouse.SkipTables, db.Name+".*")
+		}
+	}
+	return knownDatabases, nil
+}
+
 // Watch
 // - run create_remote full + delete local full, even when upload failed
 //   - if success save backup type full, next will increment, until reach full interval
@@ -67,6 +87,8 @@ func (b *Backuper) ValidateWatchParams(watchInterval, fullInterval, watchBackupN
 // - each watch-interval, run create_remote increment --diff-from=prev-name + delete local increment, even when upload failed
 //   - save previous backup type incremental, next try will also incremental, until reach full interval
 func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, tablePattern string, partitions []string, schemaOnly, backupRBAC, backupConfigs, skipCheckPartsColumns bool, version string, commandId int, metrics metrics.APIMetricsInterface, cliCtx *cli.Context) error {
+	b.setCommandLog(commandId)
+	tablePattern = b.tablePatternOrDefault(tablePattern)
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -86,6 +108,10 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 	deleteLocalErrCount := 0
 	var createRemoteErr error
 	var deleteLocalErr error
+	// databases which exists on first watch iteration, and databases which detected later with applied watch_new_databases_policy
+	var knownDatabases common.EmptyMap
+	includedDatabases := make([]string, 0)
+	excludedDatabases := common.EmptyMap{}
 	for {
 		if !b.ch.IsOpen {
 			if err = b.ch.Connect(); err != nil {
@@ -106,6 +132,13 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 					return err
 				}
 			}
+			if knownDatabases, err = b.detectNewDatabases(ctx, knownDatabases, &includedDatabases, excludedDatabases); err != nil {
+				return err
+			}
+			watchTablePattern := tablePattern
+			if tablePattern != "" && len(includedDatabases) > 0 {
+				watchTablePattern = tablePattern + "," + strings.Join(includedDatabases, ".*,") + ".*"
+			}
 			backupName, err := b.NewBackupWatchName(ctx, backupType)
 			log := b.log.WithFields(apexLog.Fields{
 				"backup":    backupName,
@@ -120,14 +153,14 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 			}
 			if metrics != nil {
 				createRemoteErr, createRemoteErrCount = metrics.ExecuteWithMetrics("create_remote", createRemoteErrCount, func() error {
-					return b.CreateToRemote(backupName, "", diffFromRemote, tablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, version, commandId)
+					return b.CreateToRemote(backupName, "", diffFromRemote, watchTablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, false, version, commandId)
 				})
 				deleteLocalErr, deleteLocalErrCount = metrics.ExecuteWithMetrics("delete", deleteLocalErrCount, func() error {
 					return b.RemoveBackupLocal(ctx, backupName, nil)
 				})
 
 			} else {
-				createRemoteErr = b.CreateToRemote(backupName, "", diffFromRemote, tablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, version, commandId)
+				createRemoteErr = b.CreateToRemote(backupName, "", diffFromRemote, watchTablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, false, version, commandId)
 				if createRemoteErr != nil {
 					log.Errorf("create_remote %s return error: %v", backupName, createRemoteErr)
 					createRemoteErrCount += 1
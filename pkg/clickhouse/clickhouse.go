@@ -24,6 +24,9 @@ import (
 	apexLog "github.com/apex/log"
 )
 
+// ErrTableDroppedDuringFreeze - table or database was dropped between get tables list and FREEZE, returned only when `ignore_not_exists_error_during_freeze: true`
+var ErrTableDroppedDuringFreeze = errors.New("table dropped during freeze")
+
 // ClickHouse - provide
 type ClickHouse struct {
 	Config               *config.ClickHouseConfig
@@ -33,6 +36,8 @@ type ClickHouse struct {
 	version              int
 	isPartsColumnPresent int8
 	IsOpen               bool
+	// LogComment - applied as `log_comment` setting for all queries when `clickhouse->log_comment` enabled
+	LogComment string
 }
 
 // Connect - establish connection to ClickHouse
@@ -103,6 +108,13 @@ func (ch *ClickHouse) Connect() error {
 	if !ch.Config.LogSQLQueries {
 		opt.Settings["log_queries"] = 0
 	}
+	// FREEZE for multiple tables executes concurrently, each table require own connection
+	if ch.Config.FreezeConcurrency > 1 {
+		opt.MaxOpenConns = ch.Config.FreezeConcurrency
+	}
+	if ch.Config.LogComment && ch.LogComment != "" {
+		opt.Settings["log_comment"] = ch.LogComment
+	}
 
 	if ch.conn, err = clickhouse.Open(opt); err != nil {
 		ch.Log.Errorf("clickhouse connection: %s, sql.Open return error: %v", fmt.Sprintf("tcp://%v:%v", ch.Config.Host, ch.Config.Port), err)
@@ -268,6 +280,22 @@ func (ch *ClickHouse) getDisksFromSystemDisks(ctx context.Context) ([]Disk, erro
 	}
 }
 
+// GetDisksFreeSpace - return free_space for each disk from system.disks
+func (ch *ClickHouse) GetDisksFreeSpace(ctx context.Context) (map[string]uint64, error) {
+	disks := make([]struct {
+		Name      string `ch:"name"`
+		FreeSpace uint64 `ch:"free_space"`
+	}, 0)
+	if err := ch.SelectContext(ctx, &disks, "SELECT name, free_space FROM system.disks"); err != nil {
+		return nil, err
+	}
+	freeSpace := make(map[string]uint64, len(disks))
+	for _, disk := range disks {
+		freeSpace[disk.Name] = disk.FreeSpace
+	}
+	return freeSpace, nil
+}
+
 // Close - closing connection to ClickHouse
 func (ch *ClickHouse) Close() {
 	if ch.IsOpen {
@@ -283,7 +311,7 @@ func (ch *ClickHouse) Close() {
 	ch.IsOpen = false
 }
 
-// GetTables - return slice of all tables suitable for backup, MySQL and PostgresSQL database engine shall be skipped
+// GetTables - return slice of all tables suitable for backup, MySQL and PostgresSQL database engine shall be skipped, they don't store data locally
 func (ch *ClickHouse) GetTables(ctx context.Context, tablePattern string) ([]Table, error) {
 	var err error
 	settings := map[string]bool{
@@ -296,8 +324,8 @@ func (ch *ClickHouse) GetTables(ctx context.Context, tablePattern string) ([]Tab
 	skipDatabases := make([]struct {
 		Name string `ch:"name"`
 	}, 0)
-	// MaterializedPostgreSQL doesn't support FREEZE look https://github.com/Altinity/clickhouse-backup/issues/550
-	if err = ch.SelectContext(ctx, &skipDatabases, "SELECT name FROM system.databases WHERE engine IN ('MySQL','PostgreSQL','MaterializedPostgreSQL')"); err != nil {
+	// MaterializedPostgreSQL doesn't support FREEZE look https://github.com/Altinity/clickhouse-backup/issues/550, backup.freezeMaterializedTable handle it
+	if err = ch.SelectContext(ctx, &skipDatabases, "SELECT name FROM system.databases WHERE engine IN ('MySQL','PostgreSQL')"); err != nil {
 		return nil, err
 	}
 	skipDatabaseNames := make([]string, len(skipDatabases))
@@ -637,20 +665,29 @@ func (ch *ClickHouse) FreezeTableOldWay(ctx context.Context, table *Table, name
 	if err := ch.SelectContext(ctx, &partitions, q); err != nil {
 		return fmt.Errorf("can't get partitions for '%s.%s': %w", table.Database, table.Name, err)
 	}
+	partitionIds := make([]string, len(partitions))
+	for i := range partitions {
+		partitionIds[i] = partitions[i].PartitionID
+	}
+	return ch.freezePartitions(ctx, table, name, partitionIds)
+}
+
+// freezePartitions - ALTER TABLE ... FREEZE PARTITION ID for each partition, shadow contains only parts of these partitions
+func (ch *ClickHouse) freezePartitions(ctx context.Context, table *Table, name string, partitionIds []string) error {
 	withNameQuery := ""
 	if name != "" {
 		withNameQuery = fmt.Sprintf("WITH NAME '%s'", name)
 	}
-	for _, item := range partitions {
-		ch.Log.Debugf("  partition '%v'", item.PartitionID)
+	for _, partitionId := range partitionIds {
+		ch.Log.Debugf("  partition '%v'", partitionId)
 		query := fmt.Sprintf(
 			"ALTER TABLE `%v`.`%v` FREEZE PARTITION ID '%v' %s;",
 			table.Database,
 			table.Name,
-			item.PartitionID,
+			partitionId,
 			withNameQuery,
 		)
-		if item.PartitionID == "all" {
+		if partitionId == "all" {
 			query = fmt.Sprintf(
 				"ALTER TABLE `%v`.`%v` FREEZE PARTITION tuple() %s;",
 				table.Database,
@@ -661,17 +698,18 @@ func (ch *ClickHouse) FreezeTableOldWay(ctx context.Context, table *Table, name
 		if err := ch.QueryContext(ctx, query); err != nil {
 			if (strings.Contains(err.Error(), "code: 60") || strings.Contains(err.Error(), "code: 81")) && ch.Config.IgnoreNotExistsErrorDuringFreeze {
 				ch.Log.Warnf("can't freeze partition: %v", err)
+				return ErrTableDroppedDuringFreeze
 			} else {
-				return fmt.Errorf("can't freeze partition '%s': %w", item.PartitionID, err)
+				return fmt.Errorf("can't freeze partition '%s': %w", partitionId, err)
 			}
 		}
 	}
 	return nil
 }
 
-// FreezeTable - freeze all partitions for table
+// FreezeTable - freeze all partitions for table, or only partitionIds when not nil
 // This way available for ClickHouse since v19.1
-func (ch *ClickHouse) FreezeTable(ctx context.Context, table *Table, name string) error {
+func (ch *ClickHouse) FreezeTable(ctx context.Context, table *Table, name string, partitionIds []string) error {
 	version, err := ch.GetVersion(ctx)
 	if err != nil {
 		return err
@@ -684,6 +722,9 @@ func (ch *ClickHouse) FreezeTable(ctx context.Context, table *Table, name string
 			ch.Log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name)).Debugf("replica synced")
 		}
 	}
+	if partitionIds != nil {
+		return ch.freezePartitions(ctx, table, name, partitionIds)
+	}
 	if version < 19001005 || ch.Config.FreezeByPart {
 		return ch.FreezeTableOldWay(ctx, table, name)
 	}
@@ -695,7 +736,7 @@ func (ch *ClickHouse) FreezeTable(ctx context.Context, table *Table, name string
 	if err := ch.QueryContext(ctx, query); err != nil {
 		if (strings.Contains(err.Error(), "code: 60") || strings.Contains(err.Error(), "code: 81") || strings.Contains(err.Error(), "code: 218")) && ch.Config.IgnoreNotExistsErrorDuringFreeze {
 			ch.Log.Warnf("can't freeze table: %v", err)
-			return nil
+			return ErrTableDroppedDuringFreeze
 		}
 		return fmt.Errorf("can't freeze table: %v", err)
 	}
@@ -1093,35 +1134,191 @@ func (ch *ClickHouse) CalculateMaxFileSize(ctx context.Context, cfg *config.Conf
 
 func (ch *ClickHouse) GetInProgressMutations(ctx context.Context, database string, table string) ([]metadata.MutationMetadata, error) {
 	inProgressMutations := make([]metadata.MutationMetadata, 0)
-	getInProgressMutationsQuery := "SELECT mutation_id, command FROM system.mutations WHERE is_done=0 AND database=? AND table=?"
+	getInProgressMutationsQuery := "SELECT mutation_id, command, block_numbers.partition_id AS partition_ids, block_numbers.number AS block_numbers FROM system.mutations WHERE is_done=0 AND database=? AND table=?"
 	if err := ch.SelectContext(ctx, &inProgressMutations, getInProgressMutationsQuery, database, table); err != nil {
 		return nil, fmt.Errorf("can't get in progress mutations: %v", err)
 	}
 	return inProgressMutations, nil
 }
 
-func (ch *ClickHouse) ApplyMacros(ctx context.Context, s string) (string, error) {
+// GetReplicationLogPointer - return position in replication log which replica already fetched, 0 for non replicated tables
+func (ch *ClickHouse) GetReplicationLogPointer(ctx context.Context, database string, table string) (uint64, error) {
+	replicas := make([]struct {
+		LogPointer uint64 `ch:"log_pointer"`
+	}, 0)
+	if err := ch.SelectContext(ctx, &replicas, "SELECT log_pointer FROM system.replicas WHERE database=? AND table=?", database, table); err != nil {
+		return 0, fmt.Errorf("can't get replication log pointer: %v", err)
+	}
+	if len(replicas) == 0 {
+		return 0, nil
+	}
+	return replicas[0].LogPointer, nil
+}
+
+// ReplicaZookeeperPath - system.replicas row, used for Keeper metadata backup and restore
+type ReplicaZookeeperPath struct {
+	Database      string `ch:"database"`
+	Table         string `ch:"table"`
+	ZookeeperPath string `ch:"zookeeper_path"`
+	IsReadonly    uint8  `ch:"is_readonly"`
+}
+
+// GetReplicasZookeeperPaths - return zookeeper_path for all Replicated tables
+func (ch *ClickHouse) GetReplicasZookeeperPaths(ctx context.Context) ([]ReplicaZookeeperPath, error) {
+	replicas := make([]ReplicaZookeeperPath, 0)
+	if err := ch.SelectContext(ctx, &replicas, "SELECT database, table, zookeeper_path, toUInt8(is_readonly) AS is_readonly FROM system.replicas"); err != nil {
+		return nil, fmt.Errorf("can't get zookeeper_path from system.replicas: %v", err)
+	}
+	return replicas, nil
+}
+
+// GetColumnCodecs - get compression codec for each table column, empty value means default codec from server settings
+func (ch *ClickHouse) GetColumnCodecs(ctx context.Context, database string, table string) (map[string]string, error) {
+	columns := make([]struct {
+		Name             string `ch:"name"`
+		CompressionCodec string `ch:"compression_codec"`
+	}, 0)
+	if err := ch.SelectContext(ctx, &columns, "SELECT name, compression_codec FROM system.columns WHERE database=? AND table=?", database, table); err != nil {
+		return nil, fmt.Errorf("can't get column codecs: %v", err)
+	}
+	codecs := make(map[string]string, len(columns))
+	for _, column := range columns {
+		codecs[column.Name] = column.CompressionCodec
+	}
+	return codecs, nil
+}
+
+// GetActivePartitionIds - partition_id of active parts, ordered by newest data first, partitions without Date or DateTime in partition key ordered by partition_id descending,
+// lastPartitions > 0 limits result
+func (ch *ClickHouse) GetActivePartitionIds(ctx context.Context, database, table string, lastPartitions int) ([]string, error) {
+	var partitions []struct {
+		PartitionID string `ch:"partition_id"`
+	}
+	query := "SELECT partition_id FROM system.parts WHERE active AND database=? AND table=? GROUP BY partition_id ORDER BY max(max_time) DESC, partition_id DESC"
+	if lastPartitions > 0 {
+		query += fmt.Sprintf(" LIMIT %d", lastPartitions)
+	}
+	if err := ch.SelectContext(ctx, &partitions, query, database, table); err != nil {
+		return nil, fmt.Errorf("can't get active partitions for `%s`.`%s`: %v", database, table, err)
+	}
+	partitionIds := make([]string, len(partitions))
+	for i := range partitions {
+		partitionIds[i] = partitions[i].PartitionID
+	}
+	return partitionIds, nil
+}
+
+// GetActivePartNames - names of active parts of table from system.parts
+func (ch *ClickHouse) GetActivePartNames(ctx context.Context, database, table string) ([]string, error) {
+	var parts []struct {
+		Name string `ch:"name"`
+	}
+	if err := ch.SelectContext(ctx, &parts, "SELECT name FROM system.parts WHERE active AND database=? AND table=?", database, table); err != nil {
+		return nil, fmt.Errorf("can't get active parts for `%s`.`%s`: %v", database, table, err)
+	}
+	partNames := make([]string, len(parts))
+	for i := range parts {
+		partNames[i] = parts[i].Name
+	}
+	return partNames, nil
+}
+
+// GetTableDataFingerprint - aggregate active parts of table, see metadata.DataFingerprint
+func (ch *ClickHouse) GetTableDataFingerprint(ctx context.Context, database, table string) (*metadata.DataFingerprint, error) {
+	fingerprint := make([]metadata.DataFingerprint, 0)
+	query := "SELECT count() AS parts, sum(rows) AS rows, sum(bytes_on_disk) AS bytes, max(modification_time) AS max_modification_time, max(data_version) AS max_data_version FROM system.parts WHERE active AND database=? AND table=?"
+	if err := ch.SelectContext(ctx, &fingerprint, query, database, table); err != nil {
+		return nil, fmt.Errorf("can't get data fingerprint for `%s`.`%s`: %v", database, table, err)
+	}
+	if len(fingerprint) == 0 || fingerprint[0].Parts == 0 {
+		return nil, nil
+	}
+	return &fingerprint[0], nil
+}
+
+// GetActivePartsBytes - get sum of bytes_on_disk for active parts
+func (ch *ClickHouse) GetActivePartsBytes(ctx context.Context, database string, table string) (uint64, error) {
+	var bytesOnDisk uint64
+	if err := ch.SelectSingleRow(ctx, &bytesOnDisk, "SELECT sum(bytes_on_disk) FROM system.parts WHERE active AND database=? AND table=?", database, table); err != nil {
+		return 0, fmt.Errorf("can't get active parts bytes: %v", err)
+	}
+	return bytesOnDisk, nil
+}
+
+// GetAllInProgressMutations - get not finished mutations for all tables with one query
+func (ch *ClickHouse) GetAllInProgressMutations(ctx context.Context) (map[metadata.TableTitle][]metadata.MutationMetadata, error) {
+	inProgressMutations := make([]struct {
+		Database   string `ch:"database"`
+		Table      string `ch:"table"`
+		MutationId string `ch:"mutation_id"`
+		Command    string `ch:"command"`
+	}, 0)
+	if err := ch.SelectContext(ctx, &inProgressMutations, "SELECT database, table, mutation_id, command FROM system.mutations WHERE is_done=0"); err != nil {
+		return nil, fmt.Errorf("can't get in progress mutations: %v", err)
+	}
+	result := make(map[metadata.TableTitle][]metadata.MutationMetadata)
+	for _, m := range inProgressMutations {
+		title := metadata.TableTitle{Database: m.Database, Table: m.Table}
+		result[title] = append(result[title], metadata.MutationMetadata{MutationId: m.MutationId, Command: m.Command})
+	}
+	return result, nil
+}
+
+// GetMacros - content of system.macros, empty map when system.macros doesn't exist
+func (ch *ClickHouse) GetMacros(ctx context.Context) (map[string]string, error) {
+	result := make(map[string]string)
 	var macrosExists uint64
 	err := ch.SelectSingleRow(ctx, &macrosExists, "SELECT count() AS is_macros_exists FROM system.tables WHERE database='system' AND name='macros'  SETTINGS empty_result_for_aggregation_by_empty_set=0")
 	if err != nil || macrosExists == 0 {
-		return s, err
+		return result, err
 	}
-
 	macros := make([]Macro, 0)
-	err = ch.SelectContext(ctx, &macros, "SELECT macro, substitution FROM system.macros")
+	if err = ch.SelectContext(ctx, &macros, "SELECT macro, substitution FROM system.macros"); err != nil {
+		return result, err
+	}
+	for _, macro := range macros {
+		result[macro.Macro] = macro.Substitution
+	}
+	return result, nil
+}
+
+func (ch *ClickHouse) ApplyMacros(ctx context.Context, s string) (string, error) {
+	macros, err := ch.GetMacros(ctx)
 	if err != nil || len(macros) == 0 {
 		return s, err
 	}
-
-	replaces := make([]string, len(macros)*2)
-	for i, macro := range macros {
-		replaces[i*2] = fmt.Sprintf("{%s}", macro.Macro)
-		replaces[i*2+1] = fmt.Sprintf("%s", macro.Substitution)
+	replaces := make([]string, 0, len(macros)*2)
+	for macro, substitution := range macros {
+		replaces = append(replaces, fmt.Sprintf("{%s}", macro), substitution)
 	}
 	s = strings.NewReplacer(replaces...).Replace(s)
 	return s, nil
 }
 
+// GetLocalReplica - first cluster in alphabetical order which contains current server, the same server could be in many clusters
+func (ch *ClickHouse) GetLocalReplica(ctx context.Context) (*LocalReplica, error) {
+	replicas := make([]LocalReplica, 0)
+	if err := ch.SelectContext(ctx, &replicas, "SELECT cluster, shard_num, replica_num FROM system.clusters WHERE is_local ORDER BY cluster, shard_num, replica_num LIMIT 1"); err != nil {
+		return nil, err
+	}
+	if len(replicas) == 0 {
+		return nil, nil
+	}
+	return &replicas[0], nil
+}
+
+// GetClusterReplicas - local replica first in each shard, other replicas ordered by replica_num
+func (ch *ClickHouse) GetClusterReplicas(ctx context.Context, cluster string) ([]ClusterReplica, error) {
+	replicas := make([]ClusterReplica, 0)
+	if err := ch.SelectContext(ctx, &replicas, "SELECT host_name, shard_num, replica_num, is_local FROM system.clusters WHERE cluster=? ORDER BY shard_num, is_local DESC, replica_num", cluster); err != nil {
+		return nil, err
+	}
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("cluster '%s' not found in system.clusters", cluster)
+	}
+	return replicas, nil
+}
+
 func (ch *ClickHouse) ApplyMutation(ctx context.Context, tableMetadata metadata.TableMetadata, mutation metadata.MutationMetadata) error {
 	applyMutatoinSQL := fmt.Sprintf("ALTER TABLE `%s`.`%s` %s", tableMetadata.Database, tableMetadata.Table, mutation.Command)
 	if err := ch.QueryContext(ctx, applyMutatoinSQL); err != nil {
@@ -1152,6 +1349,38 @@ func (ch *ClickHouse) CheckReplicationInProgress(table metadata.TableMetadata) (
 	return true, nil
 }
 
+// CheckKeeperConnection - check ClickHouse Keeper / ZooKeeper session health via system.zookeeper_connection, or via system.zookeeper for old versions
+func (ch *ClickHouse) CheckKeeperConnection(ctx context.Context) error {
+	var isConnectionTablePresent uint64
+	if err := ch.SelectSingleRow(ctx, &isConnectionTablePresent, "SELECT count() FROM system.tables WHERE database='system' AND name='zookeeper_connection'"); err != nil {
+		return err
+	}
+	if isConnectionTablePresent == 0 {
+		var nodes uint64
+		if err := ch.SelectSingleRow(ctx, &nodes, "SELECT count() FROM system.zookeeper WHERE path='/'"); err != nil {
+			return fmt.Errorf("keeper is not available: %v", err)
+		}
+		return nil
+	}
+	connections := make([]struct {
+		Name      string `ch:"name"`
+		Host      string `ch:"host"`
+		IsExpired uint8  `ch:"is_expired"`
+	}, 0)
+	if err := ch.SelectContext(ctx, &connections, "SELECT name, host, is_expired FROM system.zookeeper_connection"); err != nil {
+		return fmt.Errorf("can't get system.zookeeper_connection: %v", err)
+	}
+	if len(connections) == 0 {
+		return fmt.Errorf("keeper is not connected, system.zookeeper_connection is empty")
+	}
+	for _, connection := range connections {
+		if connection.IsExpired > 0 {
+			return fmt.Errorf("keeper session for %s (%s) is expired", connection.Name, connection.Host)
+		}
+	}
+	return nil
+}
+
 // CheckSystemPartsColumns check data parts types consistency https://github.com/Altinity/clickhouse-backup/issues/529#issuecomment-1554460504
 func (ch *ClickHouse) CheckSystemPartsColumns(ctx context.Context, table *Table) error {
 	if ch.isPartsColumnPresent == -1 {
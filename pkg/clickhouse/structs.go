@@ -63,6 +63,21 @@ type Macro struct {
 	Substitution string `ch:"substitution"`
 }
 
+// ClusterReplica - row from system.clusters, used by `--on-cluster` commands
+type ClusterReplica struct {
+	HostName   string `ch:"host_name"`
+	ShardNum   uint32 `ch:"shard_num"`
+	ReplicaNum uint32 `ch:"replica_num"`
+	IsLocal    uint8  `ch:"is_local"`
+}
+
+// LocalReplica - row from system.clusters for current server
+type LocalReplica struct {
+	Cluster    string `ch:"cluster"`
+	ShardNum   uint32 `ch:"shard_num"`
+	ReplicaNum uint32 `ch:"replica_num"`
+}
+
 // SystemBackups - info from system.backups
 type SystemBackups struct {
 	Id                string    `ch:"id"`
@@ -0,0 +1,247 @@
+// Package client - Go client for `clickhouse-backup server` REST API, methods follow operations from GET /openapi.json
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	BaseURL    string
+	Username   string
+	Password   string
+	HTTPClient *http.Client
+}
+
+// Acknowledged - response of async commands, poll Job with JobId until status is not `in progress`
+type Acknowledged struct {
+	Status     string `json:"status"`
+	Operation  string `json:"operation"`
+	BackupName string `json:"backup_name"`
+	JobId      int    `json:"job_id"`
+}
+
+type Backup struct {
+	Name     string `json:"name"`
+	Created  string `json:"created"`
+	Size     uint64 `json:"size,omitempty"`
+	Location string `json:"location"`
+	Required string `json:"required"`
+	Desc     string `json:"desc"`
+}
+
+type ActionStatus struct {
+	Command       string `json:"command"`
+	Status        string `json:"status"`
+	Start         string `json:"start,omitempty"`
+	Finish        string `json:"finish,omitempty"`
+	Error         string `json:"error,omitempty"`
+	CorrelationId string `json:"correlation_id,omitempty"`
+}
+
+type JobStatus struct {
+	JobId int `json:"job_id"`
+	ActionStatus
+	ProgressPercent  float64 `json:"progress_percent"`
+	TotalBytes       uint64  `json:"total_bytes"`
+	ProcessedBytes   uint64  `json:"processed_bytes"`
+	BytesTransferred uint64  `json:"bytes_transferred"`
+}
+
+// Error - API error response, returned for non 2xx status codes
+type Error struct {
+	StatusCode int    `json:"-"`
+	Status     string `json:"status"`
+	Operation  string `json:"operation,omitempty"`
+	Message    string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("clickhouse-backup API %s error, status code %d: %s", e.Operation, e.StatusCode, e.Message)
+}
+
+const (
+	InProgressStatus = "in progress"
+	SuccessStatus    = "success"
+	ErrorStatus      = "error"
+	CancelStatus     = "cancel"
+)
+
+// New - client for API listened on baseURL, like http://127.0.0.1:7171
+func New(baseURL, username, password string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Username:   username,
+		Password:   password,
+		HTTPClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Do - execute request and decode JSONEachRow response, each line into new element of result, result shall be pointer to slice
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, body io.Reader, result interface{}) error {
+	requestURL := c.BaseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, body)
+	if err != nil {
+		return err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(respBody, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(respBody))
+		}
+		return apiErr
+	}
+	if result == nil {
+		return nil
+	}
+	lines := make([]json.RawMessage, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(respBody))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append(json.RawMessage{}, line...))
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	content, err := json.Marshal(lines)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, result)
+}
+
+func (c *Client) acknowledged(ctx context.Context, path string, query url.Values) (*Acknowledged, error) {
+	var result []Acknowledged
+	if err := c.Do(ctx, http.MethodPost, path, query, nil, &result); err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("empty response for %s", path)
+	}
+	return &result[0], nil
+}
+
+// List - where is empty for local and remote backups, `local` or `remote`
+func (c *Client) List(ctx context.Context, where string) ([]Backup, error) {
+	path := "/backup/list"
+	if where != "" {
+		path += "/" + url.PathEscape(where)
+	}
+	var result []Backup
+	err := c.Do(ctx, http.MethodGet, path, nil, nil, &result)
+	return result, err
+}
+
+// Create - query contains optional arguments from OpenAPI `create` operation, like `table` or `rbac`
+func (c *Client) Create(ctx context.Context, name string, query url.Values) (*Acknowledged, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if name != "" {
+		query.Set("name", name)
+	}
+	return c.acknowledged(ctx, "/backup/create", query)
+}
+
+func (c *Client) Upload(ctx context.Context, name string, query url.Values) (*Acknowledged, error) {
+	return c.acknowledged(ctx, "/backup/upload/"+url.PathEscape(name), query)
+}
+
+func (c *Client) Download(ctx context.Context, name string, query url.Values) (*Acknowledged, error) {
+	return c.acknowledged(ctx, "/backup/download/"+url.PathEscape(name), query)
+}
+
+func (c *Client) Restore(ctx context.Context, name string, query url.Values) (*Acknowledged, error) {
+	return c.acknowledged(ctx, "/backup/restore/"+url.PathEscape(name), query)
+}
+
+// Delete - where is `local` or `remote`
+func (c *Client) Delete(ctx context.Context, where, name string) error {
+	return c.Do(ctx, http.MethodPost, "/backup/delete/"+url.PathEscape(where)+"/"+url.PathEscape(name), nil, nil, nil)
+}
+
+func (c *Client) Status(ctx context.Context) ([]ActionStatus, error) {
+	var result []ActionStatus
+	err := c.Do(ctx, http.MethodGet, "/backup/status", nil, nil, &result)
+	return result, err
+}
+
+func (c *Client) Actions(ctx context.Context) ([]ActionStatus, error) {
+	var result []ActionStatus
+	err := c.Do(ctx, http.MethodGet, "/backup/actions", nil, nil, &result)
+	return result, err
+}
+
+func (c *Client) Job(ctx context.Context, jobId int) (*JobStatus, error) {
+	var result []JobStatus
+	if err := c.Do(ctx, http.MethodGet, "/backup/actions/"+strconv.Itoa(jobId), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("empty response for job %d", jobId)
+	}
+	return &result[0], nil
+}
+
+// WaitJob - poll Job every pollInterval until command finished, return error when command finished with error or canceled
+func (c *Client) WaitJob(ctx context.Context, jobId int, pollInterval time.Duration) (*JobStatus, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		job, err := c.Job(ctx, jobId)
+		if err != nil {
+			return nil, err
+		}
+		switch job.Status {
+		case SuccessStatus:
+			return job, nil
+		case ErrorStatus, CancelStatus:
+			return job, fmt.Errorf("%s finished with status %s: %s", job.Command, job.Status, job.Error)
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// OpenAPI - raw OpenAPI 3 document which describes all endpoints
+func (c *Client) OpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var result []json.RawMessage
+	if err := c.Do(ctx, http.MethodGet, "/openapi.json", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("empty response for /openapi.json")
+	}
+	return result[0], nil
+}
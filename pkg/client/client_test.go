@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	jobPolls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "pass", pass)
+		switch r.URL.Path {
+		case "/backup/list/remote":
+			_, _ = w.Write([]byte("{\"name\":\"b1\",\"location\":\"remote\"}\n{\"name\":\"b2\",\"location\":\"remote\",\"required\":\"b1\"}\n"))
+		case "/backup/create":
+			assert.Equal(t, "b3", r.URL.Query().Get("name"))
+			_, _ = w.Write([]byte("{\"status\":\"acknowledged\",\"operation\":\"create\",\"backup_name\":\"b3\",\"job_id\":7}\n"))
+		case "/backup/actions/7":
+			jobPolls++
+			status := InProgressStatus
+			if jobPolls > 1 {
+				status = SuccessStatus
+			}
+			_, _ = w.Write([]byte("{\"job_id\":7,\"command\":\"create b3\",\"status\":\"" + status + "\"}\n"))
+		default:
+			w.WriteHeader(http.StatusLocked)
+			_, _ = w.Write([]byte("{\"status\":\"error\",\"operation\":\"delete\",\"error\":\"another operation is currently running\"}\n"))
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	c := New(srv.URL, "user", "pass")
+
+	backups, err := c.List(ctx, "remote")
+	assert.NoError(t, err)
+	assert.Equal(t, []Backup{{Name: "b1", Location: "remote"}, {Name: "b2", Location: "remote", Required: "b1"}}, backups)
+
+	ack, err := c.Create(ctx, "b3", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, ack.JobId)
+	job, err := c.WaitJob(ctx, ack.JobId, time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, SuccessStatus, job.Status)
+	assert.Equal(t, 2, jobPolls)
+
+	err = c.Delete(ctx, "local", "b1")
+	apiErr, isAPIError := err.(*Error)
+	assert.True(t, isAPIError)
+	assert.Equal(t, http.StatusLocked, apiErr.StatusCode)
+	assert.Equal(t, "another operation is currently running", apiErr.Message)
+}
@@ -0,0 +1,129 @@
+"""Python client for `clickhouse-backup server` REST API.
+
+Operations are generated at runtime from `GET /openapi.json`, so each operationId
+from the document is available as method with the same name, path and query
+parameters are passed as keyword arguments:
+
+    client = ClickHouseBackupClient("http://127.0.0.1:7171", "user", "password")
+    job = client.create(name="backup1", table="db.*")[0]
+    client.wait_job(job["job_id"])
+    client.upload(name="backup1", **{"diff-from-remote": "backup0"})
+
+Only python standard library is required.
+"""
+import base64
+import json
+import time
+import urllib.error
+import urllib.parse
+import urllib.request
+
+IN_PROGRESS_STATUS = "in progress"
+QUEUED_STATUS = "queued"
+SUCCESS_STATUS = "success"
+ERROR_STATUS = "error"
+CANCEL_STATUS = "cancel"
+
+
+class ClickHouseBackupError(Exception):
+    """API error response, raised for non 2xx status codes"""
+
+    def __init__(self, status_code, operation, message):
+        super().__init__(f"clickhouse-backup API {operation} error, status code {status_code}: {message}")
+        self.status_code = status_code
+        self.operation = operation
+        self.message = message
+
+
+class ClickHouseBackupClient:
+    def __init__(self, base_url, username=None, password=None, token=None, timeout=300):
+        """token is bearer token from api->tokens, username and password used for basic auth when token is empty"""
+        self.base_url = base_url.rstrip("/")
+        self.username = username
+        self.password = password
+        self.token = token
+        self.timeout = timeout
+        self._operations = None
+
+    def request(self, method, path, query=None, body=None):
+        """execute request, return list of JSON objects for JSONEachRow responses or text for plain text responses"""
+        url = self.base_url + path
+        if query:
+            url += "?" + urllib.parse.urlencode(query)
+        req = urllib.request.Request(url, data=body.encode() if isinstance(body, str) else body, method=method)
+        if self.token:
+            req.add_header("Authorization", "Bearer " + self.token)
+        elif self.username:
+            credentials = base64.b64encode(f"{self.username}:{self.password or ''}".encode()).decode()
+            req.add_header("Authorization", "Basic " + credentials)
+        try:
+            with urllib.request.urlopen(req, timeout=self.timeout) as resp:
+                content_type = resp.headers.get("Content-Type", "")
+                content = resp.read().decode()
+        except urllib.error.HTTPError as e:
+            content = e.read().decode()
+            try:
+                error = json.loads(content)
+                raise ClickHouseBackupError(e.code, error.get("operation", path), error.get("error", content)) from None
+            except ValueError:
+                raise ClickHouseBackupError(e.code, path, content.strip()) from None
+        if not content_type.startswith("application/json"):
+            return content
+        return [json.loads(line) for line in content.splitlines() if line.strip()]
+
+    def operations(self):
+        """operationId -> (method, path, parameters) from GET /openapi.json, loaded once"""
+        if self._operations is None:
+            spec = self.request("GET", "/openapi.json")[0]
+            operations = {}
+            for path, path_item in spec["paths"].items():
+                for method, operation in path_item.items():
+                    operations[operation["operationId"]] = (method.upper(), path, operation.get("parameters", []))
+            self._operations = operations
+        return self._operations
+
+    def call(self, operation_id, body=None, **arguments):
+        """execute operation from OpenAPI document, flags like `schema` are sent when argument is True"""
+        if operation_id not in self.operations():
+            raise AttributeError(f"unknown clickhouse-backup API operation `{operation_id}`")
+        method, path, parameters = self.operations()[operation_id]
+        query = {}
+        for parameter in parameters:
+            name = parameter["name"]
+            # query parameters like `diff-from` could be passed as `diff_from`
+            value = arguments.pop(name, None)
+            if value is None:
+                value = arguments.pop(name.replace("-", "_"), None)
+            if value is None or value is False:
+                if parameter.get("required"):
+                    raise TypeError(f"{operation_id}() missing required argument `{name}`")
+                continue
+            if value is True:
+                value = "true"
+            if parameter["in"] == "path":
+                path = path.replace("{" + name + "}", urllib.parse.quote(str(value), safe=""))
+            else:
+                query[name] = str(value)
+        if arguments:
+            raise TypeError(f"{operation_id}() got unexpected arguments {sorted(arguments)}")
+        return self.request(method, path, query, body)
+
+    def __getattr__(self, operation_id):
+        if operation_id.startswith("_"):
+            raise AttributeError(operation_id)
+        if operation_id not in self.operations():
+            raise AttributeError(f"unknown clickhouse-backup API operation `{operation_id}`")
+        return lambda body=None, **arguments: self.call(operation_id, body, **arguments)
+
+    def wait_job(self, job_id, poll_interval=1.0, timeout=None):
+        """poll GET /backup/actions/{job_id} until command finished, raise ClickHouseBackupError when command failed or canceled"""
+        deadline = time.monotonic() + timeout if timeout else None
+        while True:
+            job = self.call("getJob", job_id=job_id)[0]
+            if job["status"] == SUCCESS_STATUS:
+                return job
+            if job["status"] in (ERROR_STATUS, CANCEL_STATUS):
+                raise ClickHouseBackupError(200, job["command"], f"finished with status {job['status']}: {job.get('error', '')}")
+            if deadline and time.monotonic() > deadline:
+                raise TimeoutError(f"job {job_id} `{job['command']}` still {job['status']} after {timeout} seconds")
+            time.sleep(poll_interval)
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// CorrelationIdField - log field which bind all log records of one operation, including records from storage and clickhouse
+const CorrelationIdField = "correlation_id"
+
+// CommandIdField - log field which bind log record to command started via API
+const CommandIdField = "command_id"
+
+type correlationIdKey struct{}
+
+type commandIdKey struct{}
+
+// NewCorrelationId - random 16 hex chars, enough to distinguish operations in log aggregator
+func NewCorrelationId() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func WithCorrelationId(ctx context.Context, correlationId string) context.Context {
+	return context.WithValue(ctx, correlationIdKey{}, correlationId)
+}
+
+// GetCorrelationId - empty string when ctx was not created via status.Current
+func GetCorrelationId(ctx context.Context) string {
+	if correlationId, ok := ctx.Value(correlationIdKey{}).(string); ok {
+		return correlationId
+	}
+	return ""
+}
+
+// WithCommandId - storage and other packages without access to Backuper add command_id field to own logs from ctx, see GetCommandId
+func WithCommandId(ctx context.Context, commandId int) context.Context {
+	return context.WithValue(ctx, commandIdKey{}, commandId)
+}
+
+// GetCommandId - false when ctx was not created for command started via API
+func GetCommandId(ctx context.Context) (int, bool) {
+	commandId, ok := ctx.Value(commandIdKey{}).(int)
+	return commandId, ok
+}
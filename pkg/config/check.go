@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var mirrorConfigType = reflect.TypeOf(MirrorConfig{})
+
+// FindUnknownKeys - keys from config file which don't match any `yaml` tag of Config, LoadConfig silently ignores them,
+// so typo like `acces_key` leads to empty credentials, `upload_mirrors` items checked as Config sections with additional `name` key
+func FindUnknownKeys(configLocation string) ([]string, error) {
+	configYaml, err := os.ReadFile(configLocation)
+	if err != nil {
+		return nil, fmt.Errorf("can't open config file: %v", err)
+	}
+	tree := make(map[string]interface{})
+	if err = yaml.Unmarshal(configYaml, &tree); err != nil {
+		return nil, fmt.Errorf("can't parse config file: %v", err)
+	}
+	unknownKeys := findUnknownKeys(tree, reflect.TypeOf(Config{}), "")
+	sort.Strings(unknownKeys)
+	return unknownKeys, nil
+}
+
+func findUnknownKeys(node interface{}, t reflect.Type, prefix string) []string {
+	var unknownKeys []string
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		items, isMap := node.(map[string]interface{})
+		if !isMap {
+			return nil
+		}
+		fields := yamlFields(t)
+		for key, item := range items {
+			fieldType, exists := fields[key]
+			if !exists {
+				unknownKeys = append(unknownKeys, prefix+key)
+				continue
+			}
+			unknownKeys = append(unknownKeys, findUnknownKeys(item, fieldType, prefix+key+".")...)
+		}
+	case reflect.Slice:
+		items, isSlice := node.([]interface{})
+		if !isSlice {
+			return nil
+		}
+		for i, item := range items {
+			unknownKeys = append(unknownKeys, findUnknownKeys(item, t.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(prefix, "."), i))...)
+		}
+	case reflect.Map:
+		items, isMap := node.(map[string]interface{})
+		if !isMap || t != mirrorConfigType {
+			return nil
+		}
+		sections := yamlFields(reflect.TypeOf(Config{}))
+		for key, item := range items {
+			if key == "name" {
+				continue
+			}
+			sectionType, exists := sections[key]
+			if !exists {
+				unknownKeys = append(unknownKeys, prefix+key)
+				continue
+			}
+			unknownKeys = append(unknownKeys, findUnknownKeys(item, sectionType, prefix+key+".")...)
+		}
+	}
+	return unknownKeys
+}
+
+// yamlFields - the same key names which yaml.v3 uses, lowercased field name when tag is absent
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
@@ -1,14 +1,19 @@
 package config
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"math"
 	"os"
+	"path"
 	"runtime"
 	"strings"
+	"text/template"
 	"time"
 
+	"github.com/Altinity/clickhouse-backup/pkg/logcli"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 
 	"github.com/apex/log"
@@ -23,43 +28,120 @@ const (
 
 // Config - config file format
 type Config struct {
-	General    GeneralConfig    `yaml:"general" envconfig:"_"`
-	ClickHouse ClickHouseConfig `yaml:"clickhouse" envconfig:"_"`
-	S3         S3Config         `yaml:"s3" envconfig:"_"`
-	GCS        GCSConfig        `yaml:"gcs" envconfig:"_"`
-	COS        COSConfig        `yaml:"cos" envconfig:"_"`
-	API        APIConfig        `yaml:"api" envconfig:"_"`
-	FTP        FTPConfig        `yaml:"ftp" envconfig:"_"`
-	SFTP       SFTPConfig       `yaml:"sftp" envconfig:"_"`
-	AzureBlob  AzureBlobConfig  `yaml:"azblob" envconfig:"_"`
-	Custom     CustomConfig     `yaml:"custom" envconfig:"_"`
+	General       GeneralConfig       `yaml:"general" envconfig:"_"`
+	ClickHouse    ClickHouseConfig    `yaml:"clickhouse" envconfig:"_"`
+	S3            S3Config            `yaml:"s3" envconfig:"_"`
+	GCS           GCSConfig           `yaml:"gcs" envconfig:"_"`
+	COS           COSConfig           `yaml:"cos" envconfig:"_"`
+	API           APIConfig           `yaml:"api" envconfig:"_"`
+	FTP           FTPConfig           `yaml:"ftp" envconfig:"_"`
+	SFTP          SFTPConfig          `yaml:"sftp" envconfig:"_"`
+	Rclone        RcloneConfig        `yaml:"rclone" envconfig:"_"`
+	Swift         SwiftConfig         `yaml:"swift" envconfig:"_"`
+	OSS           OSSConfig           `yaml:"oss" envconfig:"_"`
+	OBS           OBSConfig           `yaml:"obs" envconfig:"_"`
+	AzureBlob     AzureBlobConfig     `yaml:"azblob" envconfig:"_"`
+	Custom        CustomConfig        `yaml:"custom" envconfig:"_"`
+	Schedule      ScheduleConfig      `yaml:"schedule" envconfig:"_"`
+	Mirrors       []MirrorConfig      `yaml:"upload_mirrors" ignored:"true"`
+	Cost          CostConfig          `yaml:"cost" envconfig:"_"`
+	Plugin        PluginConfig        `yaml:"plugin" envconfig:"_"`
+	Cluster       ClusterConfig       `yaml:"cluster" envconfig:"_"`
+	Notifications NotificationsConfig `yaml:"notifications" envconfig:"_"`
+	Tracing       TracingConfig       `yaml:"tracing" envconfig:"_"`
+	Hooks         HooksConfig         `yaml:"hooks" envconfig:"_"`
 }
 
+// MirrorConfig - additional remote storage for `upload`, contains `name` and config sections which override main config, like `general: {remote_storage: s3}` and `s3: {...}`
+type MirrorConfig map[string]interface{}
+
 // GeneralConfig - general setting section
 type GeneralConfig struct {
-	RemoteStorage           string            `yaml:"remote_storage" envconfig:"REMOTE_STORAGE"`
-	MaxFileSize             int64             `yaml:"max_file_size" envconfig:"MAX_FILE_SIZE"`
-	DisableProgressBar      bool              `yaml:"disable_progress_bar" envconfig:"DISABLE_PROGRESS_BAR"`
-	BackupsToKeepLocal      int               `yaml:"backups_to_keep_local" envconfig:"BACKUPS_TO_KEEP_LOCAL"`
-	BackupsToKeepRemote     int               `yaml:"backups_to_keep_remote" envconfig:"BACKUPS_TO_KEEP_REMOTE"`
-	LogLevel                string            `yaml:"log_level" envconfig:"LOG_LEVEL"`
-	AllowEmptyBackups       bool              `yaml:"allow_empty_backups" envconfig:"ALLOW_EMPTY_BACKUPS"`
-	DownloadConcurrency     uint8             `yaml:"download_concurrency" envconfig:"DOWNLOAD_CONCURRENCY"`
-	UploadConcurrency       uint8             `yaml:"upload_concurrency" envconfig:"UPLOAD_CONCURRENCY"`
-	UseResumableState       bool              `yaml:"use_resumable_state" envconfig:"USE_RESUMABLE_STATE"`
-	RestoreSchemaOnCluster  string            `yaml:"restore_schema_on_cluster" envconfig:"RESTORE_SCHEMA_ON_CLUSTER"`
-	UploadByPart            bool              `yaml:"upload_by_part" envconfig:"UPLOAD_BY_PART"`
-	DownloadByPart          bool              `yaml:"download_by_part" envconfig:"DOWNLOAD_BY_PART"`
-	RestoreDatabaseMapping  map[string]string `yaml:"restore_database_mapping" envconfig:"RESTORE_DATABASE_MAPPING"`
-	RetriesOnFailure        int               `yaml:"retries_on_failure" envconfig:"RETRIES_ON_FAILURE"`
-	RetriesPause            string            `yaml:"retries_pause" envconfig:"RETRIES_PAUSE"`
-	WatchInterval           string            `yaml:"watch_interval" envconfig:"WATCH_INTERVAL"`
-	FullInterval            string            `yaml:"full_interval" envconfig:"FULL_INTERVAL"`
-	WatchBackupNameTemplate string            `yaml:"watch_backup_name_template" envconfig:"WATCH_BACKUP_NAME_TEMPLATE"`
-	ShardedOperationMode    string            `yaml:"sharded_operation_mode" envconfig:"SHARDED_OPERATION_MODE"`
-	RetriesDuration         time.Duration
-	WatchDuration           time.Duration
-	FullDuration            time.Duration
+	RemoteStorage            string            `yaml:"remote_storage" envconfig:"REMOTE_STORAGE"`
+	MaxFileSize              int64             `yaml:"max_file_size" envconfig:"MAX_FILE_SIZE"`
+	MaxObjectSize            int64             `yaml:"max_object_size" envconfig:"MAX_OBJECT_SIZE"`
+	DisableProgressBar       bool              `yaml:"disable_progress_bar" envconfig:"DISABLE_PROGRESS_BAR"`
+	BackupsToKeepLocal       int               `yaml:"backups_to_keep_local" envconfig:"BACKUPS_TO_KEEP_LOCAL"`
+	BackupsToKeepRemote      int               `yaml:"backups_to_keep_remote" envconfig:"BACKUPS_TO_KEEP_REMOTE"`
+	KeepDailyRemote          int               `yaml:"keep_daily_remote" envconfig:"KEEP_DAILY_REMOTE"`
+	KeepWeeklyRemote         int               `yaml:"keep_weekly_remote" envconfig:"KEEP_WEEKLY_REMOTE"`
+	KeepMonthlyRemote        int               `yaml:"keep_monthly_remote" envconfig:"KEEP_MONTHLY_REMOTE"`
+	MinAgeRemote             string            `yaml:"min_age_remote" envconfig:"MIN_AGE_REMOTE"`
+	LogLevel                 string            `yaml:"log_level" envconfig:"LOG_LEVEL"`
+	LogFormat                string            `yaml:"log_format" envconfig:"LOG_FORMAT"`
+	AllowEmptyBackups        bool              `yaml:"allow_empty_backups" envconfig:"ALLOW_EMPTY_BACKUPS"`
+	DownloadConcurrency      uint8             `yaml:"download_concurrency" envconfig:"DOWNLOAD_CONCURRENCY"`
+	UploadConcurrency        uint8             `yaml:"upload_concurrency" envconfig:"UPLOAD_CONCURRENCY"`
+	UploadMaxBytesPerSec     int64             `yaml:"upload_max_bytes_per_second" envconfig:"UPLOAD_MAX_BYTES_PER_SECOND"`
+	DownloadMaxBytesPerSec   int64             `yaml:"download_max_bytes_per_second" envconfig:"DOWNLOAD_MAX_BYTES_PER_SECOND"`
+	DownloadTableConcurrency uint8             `yaml:"download_concurrency_per_table" envconfig:"DOWNLOAD_CONCURRENCY_PER_TABLE"`
+	UploadTableConcurrency   uint8             `yaml:"upload_concurrency_per_table" envconfig:"UPLOAD_CONCURRENCY_PER_TABLE"`
+	ObjectDiskConcurrency    int               `yaml:"object_disk_copy_concurrency" envconfig:"OBJECT_DISK_COPY_CONCURRENCY"`
+	ObjectDiskCopyRate       float64           `yaml:"object_disk_copy_objects_per_second" envconfig:"OBJECT_DISK_COPY_OBJECTS_PER_SECOND"`
+	SentinelFiles            bool              `yaml:"sentinel_files" envconfig:"SENTINEL_FILES"`
+	UseResumableState        bool              `yaml:"use_resumable_state" envconfig:"USE_RESUMABLE_STATE"`
+	RestoreSchemaOnCluster   string            `yaml:"restore_schema_on_cluster" envconfig:"RESTORE_SCHEMA_ON_CLUSTER"`
+	UploadByPart             bool              `yaml:"upload_by_part" envconfig:"UPLOAD_BY_PART"`
+	DownloadByPart           bool              `yaml:"download_by_part" envconfig:"DOWNLOAD_BY_PART"`
+	RestoreDatabaseMapping   map[string]string `yaml:"restore_database_mapping" envconfig:"RESTORE_DATABASE_MAPPING"`
+	RestoreTableMapping      map[string]string `yaml:"restore_table_mapping" envconfig:"RESTORE_TABLE_MAPPING"`
+	RetriesOnFailure         int               `yaml:"retries_on_failure" envconfig:"RETRIES_ON_FAILURE"`
+	RetriesPause             string            `yaml:"retries_pause" envconfig:"RETRIES_PAUSE"`
+	StorageRetries           int               `yaml:"storage_retries" envconfig:"STORAGE_RETRIES"`
+	StorageRetriesPause      string            `yaml:"storage_retries_pause" envconfig:"STORAGE_RETRIES_PAUSE"`
+	StorageRetriesMaxPause   string            `yaml:"storage_retries_max_pause" envconfig:"STORAGE_RETRIES_MAX_PAUSE"`
+	WatchInterval            string            `yaml:"watch_interval" envconfig:"WATCH_INTERVAL"`
+	FullInterval             string            `yaml:"full_interval" envconfig:"FULL_INTERVAL"`
+	WatchBackupNameTemplate  string            `yaml:"watch_backup_name_template" envconfig:"WATCH_BACKUP_NAME_TEMPLATE"`
+	BackupNameTemplate       string            `yaml:"backup_name_template" envconfig:"BACKUP_NAME_TEMPLATE"`
+	WatchNewDatabasesPolicy  string            `yaml:"watch_new_databases_policy" envconfig:"WATCH_NEW_DATABASES_POLICY"`
+	ShardedOperationMode     string            `yaml:"sharded_operation_mode" envconfig:"SHARDED_OPERATION_MODE"`
+	RestoreTablePriority     []string          `yaml:"restore_table_priority" envconfig:"RESTORE_TABLE_PRIORITY"`
+	RestoreTableOrderBySize  string            `yaml:"restore_table_order_by_size" envconfig:"RESTORE_TABLE_ORDER_BY_SIZE"`
+	RestoreRollbackOnFailure bool              `yaml:"restore_rollback_on_failure" envconfig:"RESTORE_ROLLBACK_ON_FAILURE"`
+	RestoreRemotePipeline    bool              `yaml:"restore_remote_pipeline" envconfig:"RESTORE_REMOTE_PIPELINE"`
+	RestoreRemoteStreaming   bool              `yaml:"restore_remote_streaming" envconfig:"RESTORE_REMOTE_STREAMING"`
+	RestoreVerifyCodecs      bool              `yaml:"restore_verify_codecs" envconfig:"RESTORE_VERIFY_CODECS"`
+	RestoreSizeTolerance     float64           `yaml:"restore_verify_size_tolerance" envconfig:"RESTORE_VERIFY_SIZE_TOLERANCE"`
+	UploadMirrorsMode        string            `yaml:"upload_mirrors_mode" envconfig:"UPLOAD_MIRRORS_MODE"`
+	DedupStore               bool              `yaml:"dedup_store" envconfig:"DEDUP_STORE"`
+	DedupChunkSize           int64             `yaml:"dedup_chunk_size" envconfig:"DEDUP_CHUNK_SIZE"`
+	CPUNice                  int               `yaml:"cpu_nice" envconfig:"CPU_NICE"`
+	IONiceClass              string            `yaml:"io_nice_class" envconfig:"IO_NICE_CLASS"`
+	IONiceLevel              int               `yaml:"io_nice_level" envconfig:"IO_NICE_LEVEL"`
+	CgroupPath               string            `yaml:"cgroup_path" envconfig:"CGROUP_PATH"`
+	ZstdWindowLog            int               `yaml:"zstd_window_log" envconfig:"ZSTD_WINDOW_LOG"`
+	ZstdDictionary           string            `yaml:"zstd_dictionary" envconfig:"ZSTD_DICTIONARY"`
+	CompressionLevelTiers    map[int64]int     `yaml:"compression_level_by_table_size" envconfig:"COMPRESSION_LEVEL_BY_TABLE_SIZE"`
+	CompressionConcurrency   int               `yaml:"compression_concurrency" envconfig:"COMPRESSION_CONCURRENCY"`
+	CheckFreeSpace           string            `yaml:"check_free_space" envconfig:"CHECK_FREE_SPACE"`
+	FreeSpaceMarginPercent   float64           `yaml:"free_space_margin_percent" envconfig:"FREE_SPACE_MARGIN_PERCENT"`
+	MaxRehydrationWait       string            `yaml:"max_rehydration_wait" envconfig:"MAX_REHYDRATION_WAIT"`
+	OrphanedShadowMinAge     string            `yaml:"orphaned_shadow_min_age" envconfig:"ORPHANED_SHADOW_MIN_AGE"`
+	OrphanedRemoteMinAge     string            `yaml:"orphaned_remote_min_age" envconfig:"ORPHANED_REMOTE_MIN_AGE"`
+	PartHashAlgorithm        string            `yaml:"part_hash_algorithm" envconfig:"PART_HASH_ALGORITHM"`
+	PartHashConcurrency      int               `yaml:"part_hash_concurrency" envconfig:"PART_HASH_CONCURRENCY"`
+	RetriesDuration          time.Duration
+	WatchDuration            time.Duration
+	FullDuration             time.Duration
+	MinAgeRemoteDuration     time.Duration
+
+	StorageRetriesPauseDuration    time.Duration
+	StorageRetriesMaxPauseDuration time.Duration
+	MaxRehydrationWaitDuration     time.Duration
+	OrphanedShadowMinAgeDuration   time.Duration
+	OrphanedRemoteMinAgeDuration   time.Duration
+
+	// TableStorageRules - first rule which `tables` pattern matches table uploads table data with own storage class and under own path prefix
+	TableStorageRules []TableStorageRule `yaml:"table_storage_rules" ignored:"true"`
+}
+
+// TableStorageRule - `tables` is comma separated list of db.table patterns, like `--tables` CLI argument,
+// storage_class overrides storage_class of s3, gcs, oss and obs sections, path_prefix is prepended to `<backup_name>/shadow/<db>/<table>` data objects
+type TableStorageRule struct {
+	Tables       string `yaml:"tables"`
+	StorageClass string `yaml:"storage_class"`
+	PathPrefix   string `yaml:"path_prefix"`
 }
 
 // GCSConfig - GCS settings section
@@ -77,9 +159,29 @@ type GCSConfig struct {
 	StorageClass           string            `yaml:"storage_class" envconfig:"GCS_STORAGE_CLASS"`
 	ObjectLabels           map[string]string `yaml:"object_labels" envconfig:"GCS_OBJECT_LABELS"`
 	CustomStorageClassMap  map[string]string `yaml:"custom_storage_class_map" envconfig:"GCS_CUSTOM_STORAGE_CLASS_MAP"`
+	// ObjectDiskCopyConcurrency - 0 means use general->object_disk_copy_concurrency
+	ObjectDiskCopyConcurrency int `yaml:"object_disk_copy_concurrency" envconfig:"GCS_OBJECT_DISK_COPY_CONCURRENCY"`
+	ObjectDiskCopyBatchSize   int `yaml:"object_disk_copy_batch_size" envconfig:"GCS_OBJECT_DISK_COPY_BATCH_SIZE"`
 	// NOTE: ClientPoolSize should be at least 2 times bigger than
 	// 			UploadConcurrency or DownloadConcurrency in each upload and download case
-	ClientPoolSize int `yaml:"client_pool_size" envconfig:"GCS_CLIENT_POOL_SIZE"`
+	ClientPoolSize     int    `yaml:"client_pool_size" envconfig:"GCS_CLIENT_POOL_SIZE"`
+	CACert             string `yaml:"ca_cert" envconfig:"GCS_CA_CERT"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" envconfig:"GCS_INSECURE_SKIP_VERIFY"`
+	Proxy              string `yaml:"proxy" envconfig:"GCS_PROXY"`
+	// KMSKeyName - CMEK, customer-managed key in Cloud KMS, EncryptionKey - CSEK, base64 encoded AES-256 key which required for read too, both can't be used together
+	KMSKeyName    string `yaml:"kms_key_name" envconfig:"GCS_KMS_KEY_NAME"`
+	EncryptionKey string `yaml:"encryption_key" envconfig:"GCS_ENCRYPTION_KEY"`
+	// RetryPolicy - `always` allow retry upload without preconditions, it is safe, because each backup object written only once by full content
+	RetryPolicy         string  `yaml:"retry_policy" envconfig:"GCS_RETRY_POLICY"`
+	RetryInitialBackoff string  `yaml:"retry_initial_backoff" envconfig:"GCS_RETRY_INITIAL_BACKOFF"`
+	RetryMaxBackoff     string  `yaml:"retry_max_backoff" envconfig:"GCS_RETRY_MAX_BACKOFF"`
+	RetryMultiplier     float64 `yaml:"retry_multiplier" envconfig:"GCS_RETRY_MULTIPLIER"`
+	RetryMaxAttempts    int     `yaml:"retry_max_attempts" envconfig:"GCS_RETRY_MAX_ATTEMPTS"`
+	ChunkRetryDeadline  string  `yaml:"chunk_retry_deadline" envconfig:"GCS_CHUNK_RETRY_DEADLINE"`
+	Timeout             string  `yaml:"timeout" envconfig:"GCS_TIMEOUT"`
+	// EmbeddedAccessKey, EmbeddedSecretKey - HMAC keys for S3 interoperability, used by clickhouse-server for `embedded_backup_target: remote`
+	EmbeddedAccessKey string `yaml:"embedded_access_key" envconfig:"GCS_EMBEDDED_ACCESS_KEY"`
+	EmbeddedSecretKey string `yaml:"embedded_secret_key" envconfig:"GCS_EMBEDDED_SECRET_KEY"`
 }
 
 // AzureBlobConfig - Azure Blob settings section
@@ -90,6 +192,8 @@ type AzureBlobConfig struct {
 	AccountKey            string `yaml:"account_key" envconfig:"AZBLOB_ACCOUNT_KEY"`
 	SharedAccessSignature string `yaml:"sas" envconfig:"AZBLOB_SAS"`
 	UseManagedIdentity    bool   `yaml:"use_managed_identity" envconfig:"AZBLOB_USE_MANAGED_IDENTITY"`
+	ManagedIdentityID     string `yaml:"managed_identity_client_id" envconfig:"AZBLOB_MANAGED_IDENTITY_CLIENT_ID"`
+	UseWorkloadIdentity   bool   `yaml:"use_workload_identity" envconfig:"AZBLOB_USE_WORKLOAD_IDENTITY"`
 	Container             string `yaml:"container" envconfig:"AZBLOB_CONTAINER"`
 	Path                  string `yaml:"path" envconfig:"AZBLOB_PATH"`
 	ObjectDiskPath        string `yaml:"object_disk_path" envconfig:"AZBLOB_OBJECT_DISK_PATH"`
@@ -100,39 +204,52 @@ type AzureBlobConfig struct {
 	MaxBuffers            int    `yaml:"buffer_count" envconfig:"AZBLOB_MAX_BUFFERS"`
 	MaxPartsCount         int    `yaml:"max_parts_count" envconfig:"AZBLOB_MAX_PARTS_COUNT"`
 	Timeout               string `yaml:"timeout" envconfig:"AZBLOB_TIMEOUT"`
+	CACert                string `yaml:"ca_cert" envconfig:"AZBLOB_CA_CERT"`
+	InsecureSkipVerify    bool   `yaml:"insecure_skip_verify" envconfig:"AZBLOB_INSECURE_SKIP_VERIFY"`
+	Proxy                 string `yaml:"proxy" envconfig:"AZBLOB_PROXY"`
+	RehydrateTier         string `yaml:"rehydrate_tier" envconfig:"AZBLOB_REHYDRATE_TIER"`
+	RehydratePriority     string `yaml:"rehydrate_priority" envconfig:"AZBLOB_REHYDRATE_PRIORITY"`
 }
 
 // S3Config - s3 settings section
 type S3Config struct {
-	AccessKey               string            `yaml:"access_key" envconfig:"S3_ACCESS_KEY"`
-	SecretKey               string            `yaml:"secret_key" envconfig:"S3_SECRET_KEY"`
-	Bucket                  string            `yaml:"bucket" envconfig:"S3_BUCKET"`
-	Endpoint                string            `yaml:"endpoint" envconfig:"S3_ENDPOINT"`
-	Region                  string            `yaml:"region" envconfig:"S3_REGION"`
-	ACL                     string            `yaml:"acl" envconfig:"S3_ACL"`
-	AssumeRoleARN           string            `yaml:"assume_role_arn" envconfig:"S3_ASSUME_ROLE_ARN"`
-	ForcePathStyle          bool              `yaml:"force_path_style" envconfig:"S3_FORCE_PATH_STYLE"`
-	Path                    string            `yaml:"path" envconfig:"S3_PATH"`
-	ObjectDiskPath          string            `yaml:"object_disk_path" envconfig:"S3_OBJECT_DISK_PATH"`
-	DisableSSL              bool              `yaml:"disable_ssl" envconfig:"S3_DISABLE_SSL"`
-	CompressionLevel        int               `yaml:"compression_level" envconfig:"S3_COMPRESSION_LEVEL"`
-	CompressionFormat       string            `yaml:"compression_format" envconfig:"S3_COMPRESSION_FORMAT"`
-	SSE                     string            `yaml:"sse" envconfig:"S3_SSE"`
-	SSEKMSKeyId             string            `yaml:"sse_kms_key_id" envconfig:"S3_SSE_KMS_KEY_ID"`
-	SSECustomerAlgorithm    string            `yaml:"sse_customer_algorithm" envconfig:"S3_SSE_CUSTOMER_ALGORITHM"`
-	SSECustomerKey          string            `yaml:"sse_customer_key" envconfig:"S3_SSE_CUSTOMER_KEY"`
-	SSECustomerKeyMD5       string            `yaml:"sse_customer_key_md5" envconfig:"S3_SSE_CUSTOMER_KEY_MD5"`
-	SSEKMSEncryptionContext string            `yaml:"sse_kms_encryption_context" envconfig:"S3_SSE_KMS_ENCRYPTION_CONTEXT"`
-	DisableCertVerification bool              `yaml:"disable_cert_verification" envconfig:"S3_DISABLE_CERT_VERIFICATION"`
-	UseCustomStorageClass   bool              `yaml:"use_custom_storage_class" envconfig:"S3_USE_CUSTOM_STORAGE_CLASS"`
-	StorageClass            string            `yaml:"storage_class" envconfig:"S3_STORAGE_CLASS"`
-	CustomStorageClassMap   map[string]string `yaml:"custom_storage_class_map" envconfig:"S3_CUSTOM_STORAGE_CLASS_MAP"`
-	Concurrency             int               `yaml:"concurrency" envconfig:"S3_CONCURRENCY"`
-	PartSize                int64             `yaml:"part_size" envconfig:"S3_PART_SIZE"`
-	MaxPartsCount           int64             `yaml:"max_parts_count" envconfig:"S3_MAX_PARTS_COUNT"`
-	AllowMultipartDownload  bool              `yaml:"allow_multipart_download" envconfig:"S3_ALLOW_MULTIPART_DOWNLOAD"`
-	ObjectLabels            map[string]string `yaml:"object_labels" envconfig:"S3_OBJECT_LABELS"`
-	Debug                   bool              `yaml:"debug" envconfig:"S3_DEBUG"`
+	AccessKey                string            `yaml:"access_key" envconfig:"S3_ACCESS_KEY"`
+	SecretKey                string            `yaml:"secret_key" envconfig:"S3_SECRET_KEY"`
+	Bucket                   string            `yaml:"bucket" envconfig:"S3_BUCKET"`
+	Endpoint                 string            `yaml:"endpoint" envconfig:"S3_ENDPOINT"`
+	Region                   string            `yaml:"region" envconfig:"S3_REGION"`
+	ACL                      string            `yaml:"acl" envconfig:"S3_ACL"`
+	AssumeRoleARN            string            `yaml:"assume_role_arn" envconfig:"S3_ASSUME_ROLE_ARN"`
+	AssumeRoleExternalID     string            `yaml:"assume_role_external_id" envconfig:"S3_ASSUME_ROLE_EXTERNAL_ID"`
+	ForcePathStyle           bool              `yaml:"force_path_style" envconfig:"S3_FORCE_PATH_STYLE"`
+	AddressingStylePerBucket map[string]string `yaml:"addressing_style_per_bucket" envconfig:"S3_ADDRESSING_STYLE_PER_BUCKET"`
+	RequestPayer             string            `yaml:"request_payer" envconfig:"S3_REQUEST_PAYER"`
+	Path                     string            `yaml:"path" envconfig:"S3_PATH"`
+	ObjectDiskPath           string            `yaml:"object_disk_path" envconfig:"S3_OBJECT_DISK_PATH"`
+	DisableSSL               bool              `yaml:"disable_ssl" envconfig:"S3_DISABLE_SSL"`
+	CompressionLevel         int               `yaml:"compression_level" envconfig:"S3_COMPRESSION_LEVEL"`
+	CompressionFormat        string            `yaml:"compression_format" envconfig:"S3_COMPRESSION_FORMAT"`
+	SSE                      string            `yaml:"sse" envconfig:"S3_SSE"`
+	SSEKMSKeyId              string            `yaml:"sse_kms_key_id" envconfig:"S3_SSE_KMS_KEY_ID"`
+	SSECustomerAlgorithm     string            `yaml:"sse_customer_algorithm" envconfig:"S3_SSE_CUSTOMER_ALGORITHM"`
+	SSECustomerKey           string            `yaml:"sse_customer_key" envconfig:"S3_SSE_CUSTOMER_KEY"`
+	SSECustomerKeyMD5        string            `yaml:"sse_customer_key_md5" envconfig:"S3_SSE_CUSTOMER_KEY_MD5"`
+	SSEKMSEncryptionContext  string            `yaml:"sse_kms_encryption_context" envconfig:"S3_SSE_KMS_ENCRYPTION_CONTEXT"`
+	DisableCertVerification  bool              `yaml:"disable_cert_verification" envconfig:"S3_DISABLE_CERT_VERIFICATION"`
+	CACert                   string            `yaml:"ca_cert" envconfig:"S3_CA_CERT"`
+	Proxy                    string            `yaml:"proxy" envconfig:"S3_PROXY"`
+	UseCustomStorageClass    bool              `yaml:"use_custom_storage_class" envconfig:"S3_USE_CUSTOM_STORAGE_CLASS"`
+	StorageClass             string            `yaml:"storage_class" envconfig:"S3_STORAGE_CLASS"`
+	CustomStorageClassMap    map[string]string `yaml:"custom_storage_class_map" envconfig:"S3_CUSTOM_STORAGE_CLASS_MAP"`
+	Concurrency              int               `yaml:"concurrency" envconfig:"S3_CONCURRENCY"`
+	PartSize                 int64             `yaml:"part_size" envconfig:"S3_PART_SIZE"`
+	MaxPartsCount            int64             `yaml:"max_parts_count" envconfig:"S3_MAX_PARTS_COUNT"`
+	AllowMultipartDownload   bool              `yaml:"allow_multipart_download" envconfig:"S3_ALLOW_MULTIPART_DOWNLOAD"`
+	ChecksumAlgorithm        string            `yaml:"checksum_algorithm" envconfig:"S3_CHECKSUM_ALGORITHM"`
+	RestoreTier              string            `yaml:"restore_tier" envconfig:"S3_RESTORE_TIER"`
+	RestoreDays              int32             `yaml:"restore_days" envconfig:"S3_RESTORE_DAYS"`
+	ObjectLabels             map[string]string `yaml:"object_labels" envconfig:"S3_OBJECT_LABELS"`
+	Debug                    bool              `yaml:"debug" envconfig:"S3_DEBUG"`
 }
 
 // COSConfig - cos settings section
@@ -155,6 +272,9 @@ type FTPConfig struct {
 	Password          string `yaml:"password" envconfig:"FTP_PASSWORD"`
 	TLS               bool   `yaml:"tls" envconfig:"FTP_TLS"`
 	SkipTLSVerify     bool   `yaml:"skip_tls_verify" envconfig:"FTP_SKIP_TLS_VERIFY"`
+	TLSExplicit       bool   `yaml:"tls_explicit" envconfig:"FTP_TLS_EXPLICIT"`
+	CACert            string `yaml:"ca_cert" envconfig:"FTP_CA_CERT"`
+	DisableMLSD       bool   `yaml:"disable_mlsd" envconfig:"FTP_DISABLE_MLSD"`
 	Path              string `yaml:"path" envconfig:"FTP_PATH"`
 	ObjectDiskPath    string `yaml:"object_disk_path" envconfig:"FTP_OBJECT_DISK_PATH"`
 	CompressionFormat string `yaml:"compression_format" envconfig:"FTP_COMPRESSION_FORMAT"`
@@ -175,9 +295,96 @@ type SFTPConfig struct {
 	CompressionFormat string `yaml:"compression_format" envconfig:"SFTP_COMPRESSION_FORMAT"`
 	CompressionLevel  int    `yaml:"compression_level" envconfig:"SFTP_COMPRESSION_LEVEL"`
 	Concurrency       int    `yaml:"concurrency" envconfig:"SFTP_CONCURRENCY"`
+	ClientPoolSize    int    `yaml:"client_pool_size" envconfig:"SFTP_CLIENT_POOL_SIZE"`
 	Debug             bool   `yaml:"debug" envconfig:"SFTP_DEBUG"`
 }
 
+// RcloneConfig - rclone CLI storage settings section, `remote` shall be defined in rclone config file
+type RcloneConfig struct {
+	Binary            string   `yaml:"binary" envconfig:"RCLONE_BINARY"`
+	ConfigFile        string   `yaml:"config_file" envconfig:"RCLONE_CONFIG"`
+	Remote            string   `yaml:"remote" envconfig:"RCLONE_REMOTE"`
+	Path              string   `yaml:"path" envconfig:"RCLONE_PATH"`
+	ObjectDiskPath    string   `yaml:"object_disk_path" envconfig:"RCLONE_OBJECT_DISK_PATH"`
+	CompressionFormat string   `yaml:"compression_format" envconfig:"RCLONE_COMPRESSION_FORMAT"`
+	CompressionLevel  int      `yaml:"compression_level" envconfig:"RCLONE_COMPRESSION_LEVEL"`
+	ExtraArgs         []string `yaml:"extra_args" envconfig:"RCLONE_EXTRA_ARGS"`
+	Debug             bool     `yaml:"debug" envconfig:"RCLONE_DEBUG"`
+}
+
+// SwiftConfig - OpenStack Swift settings section, auth via Keystone v3 with password or application credential
+type SwiftConfig struct {
+	AuthURL                     string `yaml:"auth_url" envconfig:"SWIFT_AUTH_URL"`
+	Region                      string `yaml:"region" envconfig:"SWIFT_REGION"`
+	EndpointType                string `yaml:"endpoint_type" envconfig:"SWIFT_ENDPOINT_TYPE"`
+	StorageURL                  string `yaml:"storage_url" envconfig:"SWIFT_STORAGE_URL"`
+	Username                    string `yaml:"username" envconfig:"SWIFT_USERNAME"`
+	Password                    string `yaml:"password" envconfig:"SWIFT_PASSWORD"`
+	UserDomainName              string `yaml:"user_domain_name" envconfig:"SWIFT_USER_DOMAIN_NAME"`
+	ApplicationCredentialID     string `yaml:"application_credential_id" envconfig:"SWIFT_APPLICATION_CREDENTIAL_ID"`
+	ApplicationCredentialName   string `yaml:"application_credential_name" envconfig:"SWIFT_APPLICATION_CREDENTIAL_NAME"`
+	ApplicationCredentialSecret string `yaml:"application_credential_secret" envconfig:"SWIFT_APPLICATION_CREDENTIAL_SECRET"`
+	ProjectID                   string `yaml:"project_id" envconfig:"SWIFT_PROJECT_ID"`
+	ProjectName                 string `yaml:"project_name" envconfig:"SWIFT_PROJECT_NAME"`
+	ProjectDomainName           string `yaml:"project_domain_name" envconfig:"SWIFT_PROJECT_DOMAIN_NAME"`
+	Container                   string `yaml:"container" envconfig:"SWIFT_CONTAINER"`
+	SegmentContainer            string `yaml:"segment_container" envconfig:"SWIFT_SEGMENT_CONTAINER"`
+	CreateContainer             bool   `yaml:"create_container" envconfig:"SWIFT_CREATE_CONTAINER"`
+	StoragePolicy               string `yaml:"storage_policy" envconfig:"SWIFT_STORAGE_POLICY"`
+	LargeObjectType             string `yaml:"large_object_type" envconfig:"SWIFT_LARGE_OBJECT_TYPE"`
+	SegmentSize                 int64  `yaml:"segment_size" envconfig:"SWIFT_SEGMENT_SIZE"`
+	DeleteAfter                 string `yaml:"delete_after" envconfig:"SWIFT_DELETE_AFTER"`
+	Path                        string `yaml:"path" envconfig:"SWIFT_PATH"`
+	Timeout                     string `yaml:"timeout" envconfig:"SWIFT_TIMEOUT"`
+	CACert                      string `yaml:"ca_cert" envconfig:"SWIFT_CA_CERT"`
+	SkipTLSVerify               bool   `yaml:"skip_tls_verify" envconfig:"SWIFT_SKIP_TLS_VERIFY"`
+	CompressionFormat           string `yaml:"compression_format" envconfig:"SWIFT_COMPRESSION_FORMAT"`
+	CompressionLevel            int    `yaml:"compression_level" envconfig:"SWIFT_COMPRESSION_LEVEL"`
+	Debug                       bool   `yaml:"debug" envconfig:"SWIFT_DEBUG"`
+}
+
+// OSSConfig - Alibaba Cloud OSS settings section
+type OSSConfig struct {
+	Endpoint          string `yaml:"endpoint" envconfig:"OSS_ENDPOINT"`
+	Bucket            string `yaml:"bucket" envconfig:"OSS_BUCKET"`
+	AccessKeyID       string `yaml:"access_key_id" envconfig:"OSS_ACCESS_KEY_ID"`
+	AccessKeySecret   string `yaml:"access_key_secret" envconfig:"OSS_ACCESS_KEY_SECRET"`
+	SecurityToken     string `yaml:"security_token" envconfig:"OSS_SECURITY_TOKEN"`
+	RAMRole           string `yaml:"ram_role" envconfig:"OSS_RAM_ROLE"`
+	Path              string `yaml:"path" envconfig:"OSS_PATH"`
+	PartSize          int64  `yaml:"part_size" envconfig:"OSS_PART_SIZE"`
+	StorageClass      string `yaml:"storage_class" envconfig:"OSS_STORAGE_CLASS"`
+	SSE               string `yaml:"sse" envconfig:"OSS_SSE"`
+	SSEKMSKeyID       string `yaml:"sse_kms_key_id" envconfig:"OSS_SSE_KMS_KEY_ID"`
+	Timeout           string `yaml:"timeout" envconfig:"OSS_TIMEOUT"`
+	CACert            string `yaml:"ca_cert" envconfig:"OSS_CA_CERT"`
+	SkipTLSVerify     bool   `yaml:"skip_tls_verify" envconfig:"OSS_SKIP_TLS_VERIFY"`
+	CompressionFormat string `yaml:"compression_format" envconfig:"OSS_COMPRESSION_FORMAT"`
+	CompressionLevel  int    `yaml:"compression_level" envconfig:"OSS_COMPRESSION_LEVEL"`
+	Debug             bool   `yaml:"debug" envconfig:"OSS_DEBUG"`
+}
+
+// OBSConfig - Huawei Cloud OBS settings section
+type OBSConfig struct {
+	Endpoint          string `yaml:"endpoint" envconfig:"OBS_ENDPOINT"`
+	Bucket            string `yaml:"bucket" envconfig:"OBS_BUCKET"`
+	AccessKey         string `yaml:"access_key" envconfig:"OBS_ACCESS_KEY"`
+	SecretKey         string `yaml:"secret_key" envconfig:"OBS_SECRET_KEY"`
+	SecurityToken     string `yaml:"security_token" envconfig:"OBS_SECURITY_TOKEN"`
+	UseECSMetadata    bool   `yaml:"use_ecs_metadata" envconfig:"OBS_USE_ECS_METADATA"`
+	Path              string `yaml:"path" envconfig:"OBS_PATH"`
+	PartSize          int64  `yaml:"part_size" envconfig:"OBS_PART_SIZE"`
+	StorageClass      string `yaml:"storage_class" envconfig:"OBS_STORAGE_CLASS"`
+	SSE               string `yaml:"sse" envconfig:"OBS_SSE"`
+	SSEKMSKeyID       string `yaml:"sse_kms_key_id" envconfig:"OBS_SSE_KMS_KEY_ID"`
+	Timeout           string `yaml:"timeout" envconfig:"OBS_TIMEOUT"`
+	CACert            string `yaml:"ca_cert" envconfig:"OBS_CA_CERT"`
+	SkipTLSVerify     bool   `yaml:"skip_tls_verify" envconfig:"OBS_SKIP_TLS_VERIFY"`
+	CompressionFormat string `yaml:"compression_format" envconfig:"OBS_COMPRESSION_FORMAT"`
+	CompressionLevel  int    `yaml:"compression_level" envconfig:"OBS_COMPRESSION_LEVEL"`
+	Debug             bool   `yaml:"debug" envconfig:"OBS_DEBUG"`
+}
+
 // CustomConfig - custom CLI storage settings section
 type CustomConfig struct {
 	UploadCommand          string `yaml:"upload_command" envconfig:"CUSTOM_UPLOAD_COMMAND"`
@@ -195,28 +402,57 @@ type ClickHouseConfig struct {
 	Host                             string            `yaml:"host" envconfig:"CLICKHOUSE_HOST"`
 	Port                             uint              `yaml:"port" envconfig:"CLICKHOUSE_PORT"`
 	DiskMapping                      map[string]string `yaml:"disk_mapping" envconfig:"CLICKHOUSE_DISK_MAPPING"`
+	MaterializedDatabasePasswords    map[string]string `yaml:"materialized_database_passwords" envconfig:"CLICKHOUSE_MATERIALIZED_DATABASE_PASSWORDS"`
+	IncludeTables                    []string          `yaml:"include_tables" envconfig:"CLICKHOUSE_INCLUDE_TABLES"`
 	SkipTables                       []string          `yaml:"skip_tables" envconfig:"CLICKHOUSE_SKIP_TABLES"`
 	SkipTableEngines                 []string          `yaml:"skip_table_engines" envconfig:"CLICKHOUSE_SKIP_TABLE_ENGINES"`
 	Timeout                          string            `yaml:"timeout" envconfig:"CLICKHOUSE_TIMEOUT"`
 	FreezeByPart                     bool              `yaml:"freeze_by_part" envconfig:"CLICKHOUSE_FREEZE_BY_PART"`
 	FreezeByPartWhere                string            `yaml:"freeze_by_part_where" envconfig:"CLICKHOUSE_FREEZE_BY_PART_WHERE"`
+	FreezeConcurrency                int               `yaml:"freeze_concurrency" envconfig:"CLICKHOUSE_FREEZE_CONCURRENCY"`
+	FreezeTablesPerSecond            float64           `yaml:"freeze_tables_per_second" envconfig:"CLICKHOUSE_FREEZE_TABLES_PER_SECOND"`
 	UseEmbeddedBackupRestore         bool              `yaml:"use_embedded_backup_restore" envconfig:"CLICKHOUSE_USE_EMBEDDED_BACKUP_RESTORE"`
 	EmbeddedBackupDisk               string            `yaml:"embedded_backup_disk" envconfig:"CLICKHOUSE_EMBEDDED_BACKUP_DISK"`
+	EmbeddedBackupTarget             string            `yaml:"embedded_backup_target" envconfig:"CLICKHOUSE_EMBEDDED_BACKUP_TARGET"`
+	ClassicBackupTables              []string          `yaml:"classic_backup_tables" envconfig:"CLICKHOUSE_CLASSIC_BACKUP_TABLES"`
 	BackupMutations                  bool              `yaml:"backup_mutations" envconfig:"CLICKHOUSE_BACKUP_MUTATIONS"`
 	RestoreAsAttach                  bool              `yaml:"restore_as_attach" envconfig:"CLICKHOUSE_RESTORE_AS_ATTACH"`
+	EngineDataDetach                 bool              `yaml:"engine_data_detach" envconfig:"CLICKHOUSE_ENGINE_DATA_DETACH"`
 	CheckPartsColumns                bool              `yaml:"check_parts_columns" envconfig:"CLICKHOUSE_CHECK_PARTS_COLUMNS"`
 	Secure                           bool              `yaml:"secure" envconfig:"CLICKHOUSE_SECURE"`
 	SkipVerify                       bool              `yaml:"skip_verify" envconfig:"CLICKHOUSE_SKIP_VERIFY"`
 	SyncReplicatedTables             bool              `yaml:"sync_replicated_tables" envconfig:"CLICKHOUSE_SYNC_REPLICATED_TABLES"`
 	LogSQLQueries                    bool              `yaml:"log_sql_queries" envconfig:"CLICKHOUSE_LOG_SQL_QUERIES"`
+	LogComment                       bool              `yaml:"log_comment" envconfig:"CLICKHOUSE_LOG_COMMENT"`
+	BackupLogTable                   string            `yaml:"backup_log_table" envconfig:"CLICKHOUSE_BACKUP_LOG_TABLE"`
+	PITRSource                       string            `yaml:"pitr_source" envconfig:"CLICKHOUSE_PITR_SOURCE"`
+	PITRTimestampColumn              string            `yaml:"pitr_timestamp_column" envconfig:"CLICKHOUSE_PITR_TIMESTAMP_COLUMN"`
 	ConfigDir                        string            `yaml:"config_dir" envconfig:"CLICKHOUSE_CONFIG_DIR"`
 	RestartCommand                   string            `yaml:"restart_command" envconfig:"CLICKHOUSE_RESTART_COMMAND"`
+	RBACConflictResolution           string            `yaml:"rbac_conflict_resolution" envconfig:"CLICKHOUSE_RBAC_CONFLICT_RESOLUTION"`
 	IgnoreNotExistsErrorDuringFreeze bool              `yaml:"ignore_not_exists_error_during_freeze" envconfig:"CLICKHOUSE_IGNORE_NOT_EXISTS_ERROR_DURING_FREEZE"`
 	CheckReplicasBeforeAttach        bool              `yaml:"check_replicas_before_attach" envconfig:"CLICKHOUSE_CHECK_REPLICAS_BEFORE_ATTACH"`
+	KeeperSafeMode                   string            `yaml:"keeper_safe_mode" envconfig:"CLICKHOUSE_KEEPER_SAFE_MODE"`
+	KeeperWaitTimeout                string            `yaml:"keeper_wait_timeout" envconfig:"CLICKHOUSE_KEEPER_WAIT_TIMEOUT"`
+	KeeperBackupPaths                []string          `yaml:"keeper_backup_paths" envconfig:"CLICKHOUSE_KEEPER_BACKUP_PATHS"`
+	KeeperBackupReplicated           bool              `yaml:"keeper_backup_replicated_tables" envconfig:"CLICKHOUSE_KEEPER_BACKUP_REPLICATED_TABLES"`
+	KeeperRestore                    bool              `yaml:"keeper_restore" envconfig:"CLICKHOUSE_KEEPER_RESTORE"`
+	RewriteReplicaPathMacros         bool              `yaml:"rewrite_replica_path_macros" envconfig:"CLICKHOUSE_REWRITE_REPLICA_PATH_MACROS"`
+	RestoreReadonlyReplicas          bool              `yaml:"restore_readonly_replicas" envconfig:"CLICKHOUSE_RESTORE_READONLY_REPLICAS"`
 	TLSKey                           string            `yaml:"tls_key" envconfig:"CLICKHOUSE_TLS_KEY"`
 	TLSCert                          string            `yaml:"tls_cert" envconfig:"CLICKHOUSE_TLS_CERT"`
 	TLSCa                            string            `yaml:"tls_ca" envconfig:"CLICKHOUSE_TLS_CA"`
 	Debug                            bool              `yaml:"debug" envconfig:"CLICKHOUSE_DEBUG"`
+	// FreezePartitionsRules - first rule which `tables` pattern matches table freezes only selected partitions during `create` without `--partitions`
+	FreezePartitionsRules []FreezePartitionsRule `yaml:"freeze_partitions_rules" ignored:"true"`
+}
+
+// FreezePartitionsRule - `tables` is comma separated list of db.table patterns, like `--tables` CLI argument,
+// `partitions` is comma separated list of partition_id values or glob patterns, like `--partitions` CLI argument, last_partitions selects partitions with newest data
+type FreezePartitionsRule struct {
+	Tables         string `yaml:"tables"`
+	Partitions     string `yaml:"partitions"`
+	LastPartitions int    `yaml:"last_partitions"`
 }
 
 type APIConfig struct {
@@ -233,7 +469,126 @@ type APIConfig struct {
 	CreateIntegrationTables       bool   `yaml:"create_integration_tables" envconfig:"API_CREATE_INTEGRATION_TABLES"`
 	IntegrationTablesHost         string `yaml:"integration_tables_host" envconfig:"API_INTEGRATION_TABLES_HOST"`
 	AllowParallel                 bool   `yaml:"allow_parallel" envconfig:"API_ALLOW_PARALLEL"`
+	MaxQueueSize                  int    `yaml:"max_queue_size" envconfig:"API_MAX_QUEUE_SIZE"`
 	CompleteResumableAfterRestart bool   `yaml:"complete_resumable_after_restart" envconfig:"API_COMPLETE_RESUMABLE_AFTER_RESTART"`
+	CleanOrphanedAfterRestart     bool   `yaml:"clean_orphaned_after_restart" envconfig:"API_CLEAN_ORPHANED_AFTER_RESTART"`
+	InventoryScanInterval         string `yaml:"inventory_scan_interval" envconfig:"API_INVENTORY_SCAN_INTERVAL"`
+	InventoryScanDuration         time.Duration
+	ReadOnly                      bool `yaml:"read_only" envconfig:"API_READ_ONLY"`
+	// UserRole - role of `username` and `password` basic auth, Tokens and ClientCertificateRoles - bearer token or client certificate common name -> role
+	UserRole               string            `yaml:"user_role" envconfig:"API_USER_ROLE"`
+	Tokens                 map[string]string `yaml:"tokens" envconfig:"API_TOKENS"`
+	ClientCertificateRoles map[string]string `yaml:"client_certificate_roles" envconfig:"API_CLIENT_CERTIFICATE_ROLES"`
+	// QueuePolicy - command name -> `queue`, `reject` or `reject_duplicate`, applied when another operation in progress, `queue` by default
+	QueuePolicy map[string]string `yaml:"queue_policy" envconfig:"API_QUEUE_POLICY"`
+	// ConfigReloadInterval - how often `server` checks config file modification time, changed config reloads without interrupting running commands
+	ConfigReloadInterval string `yaml:"config_reload_interval" envconfig:"API_CONFIG_RELOAD_INTERVAL"`
+	ConfigReloadDuration time.Duration
+}
+
+// API roles, each next role allows everything from previous one
+const (
+	APIRoleReadOnly = "read_only"
+	APIRoleOperator = "operator"
+	APIRoleAdmin    = "admin"
+)
+
+// APIRoleLevel - 0 for unknown role
+func APIRoleLevel(role string) int {
+	switch role {
+	case APIRoleReadOnly:
+		return 1
+	case APIRoleOperator:
+		return 2
+	case APIRoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// ClusterConfig - `--on-cluster` commands run on each shard via API of `clickhouse-backup server` on hosts from system.clusters, api->username and api->password shall be the same on all hosts
+type ClusterConfig struct {
+	Name      string `yaml:"name" envconfig:"CLUSTER_NAME"`
+	APIPort   int    `yaml:"api_port" envconfig:"CLUSTER_API_PORT"`
+	APISecure bool   `yaml:"api_secure" envconfig:"CLUSTER_API_SECURE"`
+	// APIToken - bearer token for API of each host, when empty the token with the highest role from api->tokens is used, basic auth with api->username and api->password when api->tokens is empty too
+	APIToken           string `yaml:"api_token" envconfig:"CLUSTER_API_TOKEN"`
+	CACert             string `yaml:"ca_cert" envconfig:"CLUSTER_CA_CERT"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" envconfig:"CLUSTER_INSECURE_SKIP_VERIFY"`
+	PollInterval       string `yaml:"poll_interval" envconfig:"CLUSTER_POLL_INTERVAL"`
+	Timeout            string `yaml:"timeout" envconfig:"CLUSTER_TIMEOUT"`
+}
+
+// NotificationsConfig - send message about `create`, `upload`, `download`, `restore` start, success, failure, and backups deleted by retention, to webhook, Slack, SMTP and PagerDuty
+type NotificationsConfig struct {
+	Events              []string          `yaml:"events" envconfig:"NOTIFICATIONS_EVENTS"`
+	MessageTemplate     string            `yaml:"message_template" envconfig:"NOTIFICATIONS_MESSAGE_TEMPLATE"`
+	Retries             int               `yaml:"retries" envconfig:"NOTIFICATIONS_RETRIES"`
+	RetriesPause        string            `yaml:"retries_pause" envconfig:"NOTIFICATIONS_RETRIES_PAUSE"`
+	Timeout             string            `yaml:"timeout" envconfig:"NOTIFICATIONS_TIMEOUT"`
+	WebhookURL          string            `yaml:"webhook_url" envconfig:"NOTIFICATIONS_WEBHOOK_URL"`
+	WebhookHeaders      map[string]string `yaml:"webhook_headers" envconfig:"NOTIFICATIONS_WEBHOOK_HEADERS"`
+	WebhookTemplate     string            `yaml:"webhook_template" envconfig:"NOTIFICATIONS_WEBHOOK_TEMPLATE"`
+	SlackWebhookURL     string            `yaml:"slack_webhook_url" envconfig:"NOTIFICATIONS_SLACK_WEBHOOK_URL"`
+	SMTPHost            string            `yaml:"smtp_host" envconfig:"NOTIFICATIONS_SMTP_HOST"`
+	SMTPPort            int               `yaml:"smtp_port" envconfig:"NOTIFICATIONS_SMTP_PORT"`
+	SMTPUsername        string            `yaml:"smtp_username" envconfig:"NOTIFICATIONS_SMTP_USERNAME"`
+	SMTPPassword        string            `yaml:"smtp_password" envconfig:"NOTIFICATIONS_SMTP_PASSWORD"`
+	SMTPFrom            string            `yaml:"smtp_from" envconfig:"NOTIFICATIONS_SMTP_FROM"`
+	SMTPTo              []string          `yaml:"smtp_to" envconfig:"NOTIFICATIONS_SMTP_TO"`
+	PagerDutyRoutingKey string            `yaml:"pagerduty_routing_key" envconfig:"NOTIFICATIONS_PAGERDUTY_ROUTING_KEY"`
+}
+
+// TracingConfig - OpenTelemetry spans for `create`, `upload`, `download`, `restore`, each table, data part and remote storage call, exported with OpenTelemetry SDK to OTLP/HTTP endpoint, applied again after config reload
+type TracingConfig struct {
+	OTLPEndpoint   string            `yaml:"otlp_endpoint" envconfig:"TRACING_OTLP_ENDPOINT"`
+	OTLPHeaders    map[string]string `yaml:"otlp_headers" envconfig:"TRACING_OTLP_HEADERS"`
+	ServiceName    string            `yaml:"service_name" envconfig:"TRACING_SERVICE_NAME"`
+	ExportInterval string            `yaml:"export_interval" envconfig:"TRACING_EXPORT_INTERVAL"`
+	Timeout        string            `yaml:"timeout" envconfig:"TRACING_TIMEOUT"`
+}
+
+// HooksConfig - commands which run before and after `create`, `upload`, `download`, `restore` and after failure of any of them,
+// backup name, operation and status passed via CLICKHOUSE_BACKUP_* environment variables
+type HooksConfig struct {
+	BeforeCreate    string `yaml:"before_create" envconfig:"HOOKS_BEFORE_CREATE"`
+	AfterCreate     string `yaml:"after_create" envconfig:"HOOKS_AFTER_CREATE"`
+	BeforeUpload    string `yaml:"before_upload" envconfig:"HOOKS_BEFORE_UPLOAD"`
+	AfterUpload     string `yaml:"after_upload" envconfig:"HOOKS_AFTER_UPLOAD"`
+	BeforeDownload  string `yaml:"before_download" envconfig:"HOOKS_BEFORE_DOWNLOAD"`
+	AfterDownload   string `yaml:"after_download" envconfig:"HOOKS_AFTER_DOWNLOAD"`
+	BeforeRestore   string `yaml:"before_restore" envconfig:"HOOKS_BEFORE_RESTORE"`
+	AfterRestore    string `yaml:"after_restore" envconfig:"HOOKS_AFTER_RESTORE"`
+	OnFailure       string `yaml:"on_failure" envconfig:"HOOKS_ON_FAILURE"`
+	Timeout         string `yaml:"timeout" envconfig:"HOOKS_TIMEOUT"`
+	TimeoutDuration time.Duration
+}
+
+// ScheduleConfig - cron jobs which `server` runs internally
+type ScheduleConfig struct {
+	Jobs []ScheduleJobConfig `yaml:"jobs" ignored:"true"`
+}
+
+// ScheduleJobConfig - one scheduled command, `command` runs the same way as command in POST /backup/actions
+type ScheduleJobConfig struct {
+	Name    string `yaml:"name"`
+	Cron    string `yaml:"cron"`
+	Command string `yaml:"command"`
+	Jitter  string `yaml:"jitter"`
+}
+
+// CostConfig - object storage prices for `list remote --cost`, used only for estimation, default prices are approximate public list prices in USD
+type CostConfig struct {
+	Currency           string                 `yaml:"currency" envconfig:"COST_CURRENCY"`
+	RetentionScenarios []string               `yaml:"retention_scenarios" envconfig:"COST_RETENTION_SCENARIOS"`
+	Prices             map[string]PriceConfig `yaml:"prices" ignored:"true"`
+}
+
+// PriceConfig - prices for one remote_storage type, `gb_month` key is storage class, `default` used when storage class not found
+type PriceConfig struct {
+	GBMonth    map[string]float64 `yaml:"gb_month"`
+	PutPer1000 float64            `yaml:"put_per_1000"`
+	GetPer1000 float64            `yaml:"get_per_1000"`
 }
 
 // ArchiveExtensions - list of available compression formats and associated file extensions
@@ -261,9 +616,20 @@ func (cfg *Config) GetArchiveExtension() string {
 		return ArchiveExtensions[cfg.FTP.CompressionFormat]
 	case "sftp":
 		return ArchiveExtensions[cfg.SFTP.CompressionFormat]
+	case "rclone":
+		return ArchiveExtensions[cfg.Rclone.CompressionFormat]
+	case "swift":
+		return ArchiveExtensions[cfg.Swift.CompressionFormat]
+	case "oss":
+		return ArchiveExtensions[cfg.OSS.CompressionFormat]
+	case "obs":
+		return ArchiveExtensions[cfg.OBS.CompressionFormat]
 	case "azblob":
 		return ArchiveExtensions[cfg.AzureBlob.CompressionFormat]
 	default:
+		if IsRegisteredRemoteStorage(cfg.General.RemoteStorage) {
+			return ArchiveExtensions[cfg.Plugin.CompressionFormat]
+		}
 		return ""
 	}
 }
@@ -280,15 +646,85 @@ func (cfg *Config) GetCompressionFormat() string {
 		return cfg.FTP.CompressionFormat
 	case "sftp":
 		return cfg.SFTP.CompressionFormat
+	case "rclone":
+		return cfg.Rclone.CompressionFormat
+	case "swift":
+		return cfg.Swift.CompressionFormat
+	case "oss":
+		return cfg.OSS.CompressionFormat
+	case "obs":
+		return cfg.OBS.CompressionFormat
 	case "azblob":
 		return cfg.AzureBlob.CompressionFormat
 	case "none", "custom":
 		return "tar"
 	default:
+		if IsRegisteredRemoteStorage(cfg.General.RemoteStorage) {
+			return cfg.Plugin.CompressionFormat
+		}
 		return "unknown"
 	}
 }
 
+// GetCompressionLevel - compression_level from config section of general->remote_storage
+func (cfg *Config) GetCompressionLevel() int {
+	switch cfg.General.RemoteStorage {
+	case "s3":
+		return cfg.S3.CompressionLevel
+	case "gcs":
+		return cfg.GCS.CompressionLevel
+	case "cos":
+		return cfg.COS.CompressionLevel
+	case "ftp":
+		return cfg.FTP.CompressionLevel
+	case "sftp":
+		return cfg.SFTP.CompressionLevel
+	case "rclone":
+		return cfg.Rclone.CompressionLevel
+	case "swift":
+		return cfg.Swift.CompressionLevel
+	case "oss":
+		return cfg.OSS.CompressionLevel
+	case "obs":
+		return cfg.OBS.CompressionLevel
+	case "azblob":
+		return cfg.AzureBlob.CompressionLevel
+	default:
+		if IsRegisteredRemoteStorage(cfg.General.RemoteStorage) {
+			return cfg.Plugin.CompressionLevel
+		}
+		return 0
+	}
+}
+
+// UsePathStyle - s3->addressing_style_per_bucket for bucket when present, otherwise defaultPathStyle
+func (cfg *S3Config) UsePathStyle(bucket string, defaultPathStyle bool) bool {
+	if addressingStyle, exists := cfg.AddressingStylePerBucket[bucket]; exists {
+		return addressingStyle == "path"
+	}
+	return defaultPathStyle
+}
+
+// GetMaxObjectSize - general->max_object_size or max object size for remote storage provider, 0 means unlimited
+func (cfg *Config) GetMaxObjectSize() int64 {
+	if cfg.General.MaxObjectSize > 0 {
+		return cfg.General.MaxObjectSize
+	}
+	switch cfg.General.RemoteStorage {
+	case "s3", "gcs", "cos":
+		return 5 * 1024 * 1024 * 1024 * 1024
+	case "azblob":
+		// 50000 blocks * 4000MiB
+		return 50000 * 4000 * 1024 * 1024
+	case "oss":
+		return 10000 * cfg.OSS.PartSize
+	case "obs":
+		return 10000 * cfg.OBS.PartSize
+	default:
+		return 0
+	}
+}
+
 // LoadConfig - load config from file + environment variables
 func LoadConfig(configLocation string) (*Config, error) {
 	cfg := DefaultConfig()
@@ -302,6 +738,12 @@ func LoadConfig(configLocation string) (*Config, error) {
 	if err := envconfig.Process("", cfg); err != nil {
 		return nil, err
 	}
+	if err := applySecretFiles(cfg); err != nil {
+		return nil, err
+	}
+	if err := resolveSecretReferences(context.Background(), cfg); err != nil {
+		return nil, err
+	}
 
 	//auto tuning upload_concurrency for storage types which not have SDK level concurrency, https://github.com/Altinity/clickhouse-backup/issues/658
 	cfgWithoutDefault := &Config{}
@@ -318,6 +760,12 @@ func LoadConfig(configLocation string) (*Config, error) {
 	cfg.S3.Path = strings.TrimPrefix(cfg.S3.Path, "/")
 	cfg.GCS.Path = strings.TrimPrefix(cfg.GCS.Path, "/")
 	log.SetLevelFromString(cfg.General.LogLevel)
+	logcli.SetFormat(cfg.General.LogFormat)
+	if cfg.Plugin.Path != "" {
+		if err := loadStoragePlugin(cfg.Plugin.Path); err != nil {
+			return nil, err
+		}
+	}
 	return cfg, ValidateConfig(cfg)
 }
 
@@ -331,6 +779,39 @@ func ValidateConfig(cfg *Config) error {
 			cfg.FTP.Concurrency, cfg.General.DownloadConcurrency, cfg.General.UploadConcurrency,
 		)
 	}
+	if cfg.General.RemoteStorage == "ftp" && cfg.FTP.TLS && cfg.FTP.TLSExplicit {
+		return fmt.Errorf("ftp->tls and ftp->tls_explicit can't be enabled both, use `tls` for implicit FTPS on port 990 or `tls_explicit` for AUTH TLS on port 21")
+	}
+	if cfg.General.RemoteStorage == "rclone" && cfg.Rclone.Remote == "" {
+		return fmt.Errorf("`remote_storage: rclone` require not empty rclone->remote")
+	}
+	if cfg.General.RemoteStorage == "oss" {
+		if cfg.OSS.Endpoint == "" || cfg.OSS.Bucket == "" {
+			return fmt.Errorf("`remote_storage: oss` require not empty oss->endpoint and oss->bucket")
+		}
+		if cfg.OSS.PartSize < 100*1024 || cfg.OSS.PartSize > 5*1024*1024*1024 {
+			return fmt.Errorf("oss->part_size shall be between 100KiB and 5GiB, got %d", cfg.OSS.PartSize)
+		}
+	}
+	if cfg.General.RemoteStorage == "obs" {
+		if cfg.OBS.Endpoint == "" || cfg.OBS.Bucket == "" {
+			return fmt.Errorf("`remote_storage: obs` require not empty obs->endpoint and obs->bucket")
+		}
+		if cfg.OBS.PartSize < 100*1024 || cfg.OBS.PartSize > 5*1024*1024*1024 {
+			return fmt.Errorf("obs->part_size shall be between 100KiB and 5GiB, got %d", cfg.OBS.PartSize)
+		}
+	}
+	if cfg.General.RemoteStorage == "swift" {
+		if cfg.Swift.AuthURL == "" || cfg.Swift.Container == "" {
+			return fmt.Errorf("`remote_storage: swift` require not empty swift->auth_url and swift->container")
+		}
+		if cfg.Swift.LargeObjectType != "slo" && cfg.Swift.LargeObjectType != "dlo" {
+			return fmt.Errorf("swift->large_object_type shall be `slo` or `dlo`, got `%s`", cfg.Swift.LargeObjectType)
+		}
+		if cfg.Swift.SegmentSize <= 0 || cfg.Swift.SegmentSize > 5*1024*1024*1024 {
+			return fmt.Errorf("swift->segment_size shall be between 1 byte and 5GiB, got %d", cfg.Swift.SegmentSize)
+		}
+	}
 	if cfg.GetCompressionFormat() == "lz4" {
 		return fmt.Errorf("clickhouse already compressed data by lz4")
 	}
@@ -347,6 +828,16 @@ func ValidateConfig(cfg *Config) error {
 	if cfg.ClickHouse.FreezeByPart && cfg.ClickHouse.UseEmbeddedBackupRestore {
 		return fmt.Errorf("`freeze_by_part: %v` is not compatible with `use_embedded_backup_restore: %v`", cfg.ClickHouse.FreezeByPart, cfg.ClickHouse.UseEmbeddedBackupRestore)
 	}
+	if cfg.ClickHouse.EmbeddedBackupTarget != "disk" && cfg.ClickHouse.EmbeddedBackupTarget != "remote" {
+		return fmt.Errorf("invalid clickhouse->embedded_backup_target: `%s`, shall be `disk` or `remote`", cfg.ClickHouse.EmbeddedBackupTarget)
+	}
+	if cfg.ClickHouse.UseEmbeddedBackupRestore && cfg.ClickHouse.EmbeddedBackupTarget == "remote" {
+		switch cfg.General.RemoteStorage {
+		case "s3", "gcs", "azblob", "cos":
+		default:
+			return fmt.Errorf("`embedded_backup_target: remote` doesn't support `remote_storage: %s`, use `embedded_backup_target: disk`", cfg.General.RemoteStorage)
+		}
+	}
 	if _, err := time.ParseDuration(cfg.COS.Timeout); err != nil {
 		return fmt.Errorf("invalid cos timeout: %v", err)
 	}
@@ -359,6 +850,44 @@ func ValidateConfig(cfg *Config) error {
 	if _, err := time.ParseDuration(cfg.AzureBlob.Timeout); err != nil {
 		return fmt.Errorf("invalid azblob timeout: %v", err)
 	}
+	if _, err := time.ParseDuration(cfg.Cluster.PollInterval); err != nil {
+		return fmt.Errorf("invalid cluster poll_interval: %v", err)
+	}
+	if _, err := time.ParseDuration(cfg.Cluster.Timeout); err != nil {
+		return fmt.Errorf("invalid cluster timeout: %v", err)
+	}
+	if err := validateNotificationsConfig(cfg.Notifications); err != nil {
+		return err
+	}
+	if duration, err := time.ParseDuration(cfg.Hooks.Timeout); err != nil || duration <= 0 {
+		return fmt.Errorf("invalid hooks timeout: '%s', shall be positive duration", cfg.Hooks.Timeout)
+	} else {
+		cfg.Hooks.TimeoutDuration = duration
+	}
+	if exportInterval, err := time.ParseDuration(cfg.Tracing.ExportInterval); err != nil || exportInterval <= 0 {
+		return fmt.Errorf("invalid tracing export_interval: '%s', shall be positive duration", cfg.Tracing.ExportInterval)
+	}
+	if _, err := time.ParseDuration(cfg.Tracing.Timeout); err != nil {
+		return fmt.Errorf("invalid tracing timeout: %v", err)
+	}
+	if _, err := time.ParseDuration(cfg.GCS.Timeout); err != nil {
+		return fmt.Errorf("invalid gcs timeout: %v", err)
+	}
+	if _, err := time.ParseDuration(cfg.GCS.RetryInitialBackoff); err != nil {
+		return fmt.Errorf("invalid gcs retry_initial_backoff: %v", err)
+	}
+	if _, err := time.ParseDuration(cfg.GCS.RetryMaxBackoff); err != nil {
+		return fmt.Errorf("invalid gcs retry_max_backoff: %v", err)
+	}
+	if _, err := time.ParseDuration(cfg.GCS.ChunkRetryDeadline); err != nil {
+		return fmt.Errorf("invalid gcs chunk_retry_deadline: %v", err)
+	}
+	if cfg.GCS.RetryPolicy != "idempotent" && cfg.GCS.RetryPolicy != "always" && cfg.GCS.RetryPolicy != "never" {
+		return fmt.Errorf("invalid gcs retry_policy: '%s', allowed values are `idempotent`, `always` or `never`", cfg.GCS.RetryPolicy)
+	}
+	if cfg.GCS.RetryMultiplier < 1 || cfg.GCS.RetryMaxAttempts < 0 {
+		return fmt.Errorf("invalid gcs retry_multiplier: %v shall be 1 or greater, retry_max_attempts: %d shall be 0 or greater", cfg.GCS.RetryMultiplier, cfg.GCS.RetryMaxAttempts)
+	}
 	storageClassOk := false
 	var allStorageClasses s3types.StorageClass
 	if cfg.S3.UseCustomStorageClass {
@@ -375,6 +904,17 @@ func ValidateConfig(cfg *Config) error {
 		return fmt.Errorf("'%s' is bad S3_STORAGE_CLASS, select one of: %#v",
 			cfg.S3.StorageClass, allStorageClasses.Values())
 	}
+	if cfg.S3.ChecksumAlgorithm != "" && strings.ToUpper(cfg.S3.ChecksumAlgorithm) != "CRC32C" && strings.ToUpper(cfg.S3.ChecksumAlgorithm) != "SHA256" {
+		return fmt.Errorf("invalid s3->checksum_algorithm: %s, allowed values are CRC32C or SHA256", cfg.S3.ChecksumAlgorithm)
+	}
+	if cfg.S3.RequestPayer != "" && s3types.RequestPayer(cfg.S3.RequestPayer) != s3types.RequestPayerRequester {
+		return fmt.Errorf("invalid s3->request_payer: %s, allowed values are empty or %s", cfg.S3.RequestPayer, s3types.RequestPayerRequester)
+	}
+	for bucket, addressingStyle := range cfg.S3.AddressingStylePerBucket {
+		if addressingStyle != "path" && addressingStyle != "virtual" {
+			return fmt.Errorf("invalid s3->addressing_style_per_bucket for %s: %s, allowed values are path or virtual", bucket, addressingStyle)
+		}
+	}
 	if cfg.S3.AllowMultipartDownload && cfg.S3.Concurrency == 1 {
 		return fmt.Errorf(
 			"`allow_multipart_download` require `concurrency` in `s3` section more than 1 (3-4 recommends) current value: %d",
@@ -411,6 +951,18 @@ func ValidateConfig(cfg *Config) error {
 	} else {
 		return fmt.Errorf("empty retries pause")
 	}
+	if cfg.General.StorageRetries > 0 {
+		if duration, err := time.ParseDuration(cfg.General.StorageRetriesPause); err != nil {
+			return fmt.Errorf("invalid storage_retries_pause: %v", err)
+		} else {
+			cfg.General.StorageRetriesPauseDuration = duration
+		}
+		if duration, err := time.ParseDuration(cfg.General.StorageRetriesMaxPause); err != nil {
+			return fmt.Errorf("invalid storage_retries_max_pause: %v", err)
+		} else {
+			cfg.General.StorageRetriesMaxPauseDuration = duration
+		}
+	}
 	if cfg.General.WatchInterval != "" {
 		if duration, err := time.ParseDuration(cfg.General.WatchInterval); err != nil {
 			return fmt.Errorf("invalid watch interval: %v", err)
@@ -425,6 +977,332 @@ func ValidateConfig(cfg *Config) error {
 			cfg.General.FullDuration = duration
 		}
 	}
+	if cfg.General.MinAgeRemote != "" {
+		if duration, err := time.ParseDuration(cfg.General.MinAgeRemote); err != nil {
+			return fmt.Errorf("invalid min_age_remote: %v", err)
+		} else {
+			cfg.General.MinAgeRemoteDuration = duration
+		}
+	}
+	if duration, err := time.ParseDuration(cfg.General.OrphanedShadowMinAge); err != nil || duration < 0 {
+		return fmt.Errorf("invalid orphaned_shadow_min_age: '%s', shall be positive duration", cfg.General.OrphanedShadowMinAge)
+	} else {
+		cfg.General.OrphanedShadowMinAgeDuration = duration
+	}
+	if duration, err := time.ParseDuration(cfg.General.OrphanedRemoteMinAge); err != nil || duration < 0 {
+		return fmt.Errorf("invalid orphaned_remote_min_age: '%s', shall be positive duration", cfg.General.OrphanedRemoteMinAge)
+	} else {
+		cfg.General.OrphanedRemoteMinAgeDuration = duration
+	}
+	switch cfg.General.PartHashAlgorithm {
+	case "", "xxhash64", "blake3", "sha256":
+	default:
+		return fmt.Errorf("invalid part_hash_algorithm: '%s', allowed values are empty, `xxhash64`, `blake3` or `sha256`", cfg.General.PartHashAlgorithm)
+	}
+	if cfg.General.PartHashConcurrency <= 0 {
+		return fmt.Errorf("invalid part_hash_concurrency: %d, shall be more than zero", cfg.General.PartHashConcurrency)
+	}
+	if cfg.API.InventoryScanInterval != "" {
+		if duration, err := time.ParseDuration(cfg.API.InventoryScanInterval); err != nil {
+			return fmt.Errorf("invalid api inventory scan interval: %v", err)
+		} else {
+			cfg.API.InventoryScanDuration = duration
+		}
+	}
+	if cfg.API.ConfigReloadInterval != "" {
+		if duration, err := time.ParseDuration(cfg.API.ConfigReloadInterval); err != nil {
+			return fmt.Errorf("invalid api->config_reload_interval: %v", err)
+		} else {
+			cfg.API.ConfigReloadDuration = duration
+		}
+	}
+	if cfg.API.MaxQueueSize < 0 {
+		return fmt.Errorf("invalid api->max_queue_size: %d, shall be 0 or positive", cfg.API.MaxQueueSize)
+	}
+	for command, policy := range cfg.API.QueuePolicy {
+		if policy != "queue" && policy != "reject" && policy != "reject_duplicate" {
+			return fmt.Errorf("invalid api->queue_policy for `%s`: '%s', allowed values are `queue`, `reject` or `reject_duplicate`", command, policy)
+		}
+	}
+	if APIRoleLevel(cfg.API.UserRole) == 0 {
+		return fmt.Errorf("invalid api->user_role: '%s', allowed values are `read_only`, `operator` or `admin`", cfg.API.UserRole)
+	}
+	for _, roles := range []map[string]string{cfg.API.Tokens, cfg.API.ClientCertificateRoles} {
+		for name, role := range roles {
+			if APIRoleLevel(role) == 0 {
+				return fmt.Errorf("invalid api role '%s' for '%s', allowed values are `read_only`, `operator` or `admin`", role, name)
+			}
+		}
+	}
+	if cfg.ClickHouse.RBACConflictResolution != "" && cfg.ClickHouse.RBACConflictResolution != "skip" && cfg.ClickHouse.RBACConflictResolution != "replace" && cfg.ClickHouse.RBACConflictResolution != "merge" {
+		return fmt.Errorf("invalid rbac_conflict_resolution: '%s', allowed values are empty, `skip`, `replace` or `merge`", cfg.ClickHouse.RBACConflictResolution)
+	}
+	if cfg.ClickHouse.KeeperSafeMode != "" && cfg.ClickHouse.KeeperSafeMode != "wait" && cfg.ClickHouse.KeeperSafeMode != "fallback" && cfg.ClickHouse.KeeperSafeMode != "abort" {
+		return fmt.Errorf("invalid keeper_safe_mode: '%s', allowed values are empty, `wait`, `fallback` or `abort`", cfg.ClickHouse.KeeperSafeMode)
+	}
+	if _, err := time.ParseDuration(cfg.ClickHouse.KeeperWaitTimeout); cfg.ClickHouse.KeeperSafeMode == "wait" && err != nil {
+		return fmt.Errorf("invalid keeper_wait_timeout: %v", err)
+	}
+	if cfg.General.RestoreTableOrderBySize != "" && cfg.General.RestoreTableOrderBySize != "asc" && cfg.General.RestoreTableOrderBySize != "desc" {
+		return fmt.Errorf("invalid restore_table_order_by_size: '%s', allowed values are empty, `asc` or `desc`", cfg.General.RestoreTableOrderBySize)
+	}
+	if cfg.General.WatchNewDatabasesPolicy != "" && cfg.General.WatchNewDatabasesPolicy != "include" && cfg.General.WatchNewDatabasesPolicy != "exclude" && cfg.General.WatchNewDatabasesPolicy != "alert" {
+		return fmt.Errorf("invalid watch_new_databases_policy: '%s', allowed values are `include`, `exclude` or `alert`", cfg.General.WatchNewDatabasesPolicy)
+	}
+	if cfg.General.UploadMirrorsMode != "" && cfg.General.UploadMirrorsMode != "tee" && cfg.General.UploadMirrorsMode != "sequential" && cfg.General.UploadMirrorsMode != "parallel" {
+		return fmt.Errorf("invalid upload_mirrors_mode: '%s', allowed values are `tee`, `sequential` or `parallel`", cfg.General.UploadMirrorsMode)
+	}
+	if cfg.General.DedupStore {
+		if cfg.General.DedupChunkSize < 64*1024 || cfg.General.DedupChunkSize&(cfg.General.DedupChunkSize-1) != 0 {
+			return fmt.Errorf("invalid dedup_chunk_size: %d, shall be power of two and not less than 65536", cfg.General.DedupChunkSize)
+		}
+		if cfg.General.RemoteStorage == "custom" || cfg.ClickHouse.UseEmbeddedBackupRestore {
+			return fmt.Errorf("dedup_store: true not compatible with remote_storage: custom and use_embedded_backup_restore: true")
+		}
+	}
+	if cfg.GCS.KMSKeyName != "" && cfg.GCS.EncryptionKey != "" {
+		return fmt.Errorf("gcs->kms_key_name and gcs->encryption_key can't be used together")
+	}
+	if cfg.GCS.EncryptionKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(cfg.GCS.EncryptionKey); err != nil || len(key) != 32 {
+			return fmt.Errorf("invalid gcs->encryption_key, shall be base64 encoded 32 bytes AES-256 key")
+		}
+	}
+	if cfg.General.CPUNice < 0 || cfg.General.CPUNice > 19 {
+		return fmt.Errorf("invalid cpu_nice: %d, allowed values between 0 and 19", cfg.General.CPUNice)
+	}
+	if cfg.General.IONiceClass != "" && cfg.General.IONiceClass != "best-effort" && cfg.General.IONiceClass != "idle" {
+		return fmt.Errorf("invalid io_nice_class: '%s', allowed values are `best-effort` or `idle`", cfg.General.IONiceClass)
+	}
+	if cfg.General.IONiceLevel < 0 || cfg.General.IONiceLevel > 7 {
+		return fmt.Errorf("invalid io_nice_level: %d, allowed values between 0 and 7", cfg.General.IONiceLevel)
+	}
+	// klauspost/compress supports window up to 512MiB
+	if cfg.General.ZstdWindowLog != 0 && (cfg.General.ZstdWindowLog < 10 || cfg.General.ZstdWindowLog > 29) {
+		return fmt.Errorf("invalid zstd_window_log: %d, allowed values 0 or between 10 and 29", cfg.General.ZstdWindowLog)
+	}
+	if cfg.General.CompressionConcurrency < 0 {
+		return fmt.Errorf("invalid compression_concurrency: %d, shall be 0 or greater", cfg.General.CompressionConcurrency)
+	}
+	if cfg.General.LogFormat != "text" && cfg.General.LogFormat != "json" {
+		return fmt.Errorf("invalid log_format: '%s', allowed values are `text` or `json`", cfg.General.LogFormat)
+	}
+	if cfg.General.CheckFreeSpace != "none" && cfg.General.CheckFreeSpace != "warn" && cfg.General.CheckFreeSpace != "error" {
+		return fmt.Errorf("invalid check_free_space: '%s', allowed values are `none`, `warn` or `error`", cfg.General.CheckFreeSpace)
+	}
+	if cfg.General.FreeSpaceMarginPercent < 0 {
+		return fmt.Errorf("invalid free_space_margin_percent: %v, shall be 0 or greater", cfg.General.FreeSpaceMarginPercent)
+	}
+	for size := range cfg.General.CompressionLevelTiers {
+		if size < 0 {
+			return fmt.Errorf("invalid compression_level_by_table_size: %d, table size threshold shall be positive", size)
+		}
+	}
+	if err := cfg.validateFreezePartitionsRules(); err != nil {
+		return err
+	}
+	if err := cfg.validateTableStorageRules(); err != nil {
+		return err
+	}
+	if err := cfg.validateRehydration(); err != nil {
+		return err
+	}
+	if len(cfg.Mirrors) > 0 {
+		if _, _, err := cfg.GetMirrorConfigs(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateFreezePartitionsRules - embedded BACKUP doesn't use FREEZE, so rules are not applicable
+func (cfg *Config) validateFreezePartitionsRules() error {
+	for i, rule := range cfg.ClickHouse.FreezePartitionsRules {
+		if strings.Trim(rule.Tables, " \t\r\n,") == "" {
+			return fmt.Errorf("invalid clickhouse freeze_partitions_rules[%d]: `tables` is empty", i)
+		}
+		if strings.Trim(rule.Partitions, " \t\r\n,") == "" && rule.LastPartitions <= 0 {
+			return fmt.Errorf("invalid clickhouse freeze_partitions_rules[%d]: `partitions` or positive `last_partitions` shall be defined", i)
+		}
+		if rule.Partitions != "" && rule.LastPartitions > 0 {
+			return fmt.Errorf("invalid clickhouse freeze_partitions_rules[%d]: `partitions` and `last_partitions` can't be defined together", i)
+		}
+	}
+	if len(cfg.ClickHouse.FreezePartitionsRules) > 0 && cfg.ClickHouse.UseEmbeddedBackupRestore {
+		return fmt.Errorf("clickhouse freeze_partitions_rules is not compatible with `use_embedded_backup_restore: true`")
+	}
+	return nil
+}
+
+// validateTableStorageRules - path_prefix is first level directory near backup names, so it can't start with `.` to avoid conflict with `.chunks` and `.cluster`
+func (cfg *Config) validateTableStorageRules() error {
+	for i, rule := range cfg.General.TableStorageRules {
+		if strings.Trim(rule.Tables, " \t\r\n,") == "" {
+			return fmt.Errorf("invalid table_storage_rules[%d]: `tables` is empty", i)
+		}
+		if rule.StorageClass == "" && rule.PathPrefix == "" {
+			return fmt.Errorf("invalid table_storage_rules[%d]: `storage_class` or `path_prefix` shall be defined", i)
+		}
+		if rule.PathPrefix != "" {
+			pathPrefix := path.Clean(rule.PathPrefix)
+			if pathPrefix != strings.Trim(rule.PathPrefix, "/") || strings.HasPrefix(pathPrefix, ".") || strings.Contains(pathPrefix, "..") {
+				return fmt.Errorf("invalid table_storage_rules[%d] path_prefix: '%s', shall be relative path without `.` and `..` elements", i, rule.PathPrefix)
+			}
+		}
+		if rule.StorageClass == "" {
+			continue
+		}
+		switch cfg.General.RemoteStorage {
+		case "s3":
+			if cfg.S3.UseCustomStorageClass {
+				continue
+			}
+			var allStorageClasses s3types.StorageClass
+			storageClassOk := false
+			for _, storageClass := range allStorageClasses.Values() {
+				if s3types.StorageClass(strings.ToUpper(rule.StorageClass)) == storageClass {
+					storageClassOk = true
+					break
+				}
+			}
+			if !storageClassOk {
+				return fmt.Errorf("invalid table_storage_rules[%d] storage_class: '%s', select one of: %#v", i, rule.StorageClass, allStorageClasses.Values())
+			}
+		case "gcs", "oss", "obs":
+		default:
+			return fmt.Errorf("invalid table_storage_rules[%d]: storage_class is not supported for remote_storage: %s", i, cfg.General.RemoteStorage)
+		}
+	}
+	return nil
+}
+
+// validateRehydration - max_rehydration_wait=0s means only request rehydration for objects in archival storage class and fail download
+func (cfg *Config) validateRehydration() error {
+	if duration, err := time.ParseDuration(cfg.General.MaxRehydrationWait); err != nil || duration < 0 {
+		return fmt.Errorf("invalid max_rehydration_wait: '%s', shall be positive duration", cfg.General.MaxRehydrationWait)
+	} else {
+		cfg.General.MaxRehydrationWaitDuration = duration
+	}
+	var allTiers s3types.Tier
+	tierOk := false
+	for _, tier := range allTiers.Values() {
+		if s3types.Tier(cfg.S3.RestoreTier) == tier {
+			tierOk = true
+			break
+		}
+	}
+	if !tierOk {
+		return fmt.Errorf("invalid s3->restore_tier: '%s', select one of: %#v", cfg.S3.RestoreTier, allTiers.Values())
+	}
+	if cfg.S3.RestoreDays < 1 {
+		return fmt.Errorf("invalid s3->restore_days: %d, shall be 1 or greater", cfg.S3.RestoreDays)
+	}
+	if cfg.AzureBlob.RehydrateTier != "Hot" && cfg.AzureBlob.RehydrateTier != "Cool" {
+		return fmt.Errorf("invalid azblob->rehydrate_tier: '%s', allowed values are `Hot` or `Cool`", cfg.AzureBlob.RehydrateTier)
+	}
+	if cfg.AzureBlob.RehydratePriority != "Standard" && cfg.AzureBlob.RehydratePriority != "High" {
+		return fmt.Errorf("invalid azblob->rehydrate_priority: '%s', allowed values are `Standard` or `High`", cfg.AzureBlob.RehydratePriority)
+	}
+	return nil
+}
+
+// GetMirrorConfigs - build full config for each `upload_mirrors` item, main config copied and sections from item override it, return mirror names and configs
+func (cfg *Config) GetMirrorConfigs() ([]string, []*Config, error) {
+	mainYaml, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't marshal config: %v", err)
+	}
+	names := make([]string, len(cfg.Mirrors))
+	mirrorConfigs := make([]*Config, len(cfg.Mirrors))
+	for i, mirror := range cfg.Mirrors {
+		names[i] = fmt.Sprintf("mirror%d", i+1)
+		overrides := make(map[string]interface{}, len(mirror))
+		for section, value := range mirror {
+			if section == "name" {
+				names[i] = fmt.Sprint(value)
+				continue
+			}
+			overrides[section] = value
+		}
+		mirrorCfg := &Config{}
+		if err = yaml.Unmarshal(mainYaml, mirrorCfg); err != nil {
+			return nil, nil, fmt.Errorf("can't copy config for upload_mirrors %s: %v", names[i], err)
+		}
+		overridesYaml, err := yaml.Marshal(overrides)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't marshal upload_mirrors %s: %v", names[i], err)
+		}
+		if err = yaml.Unmarshal(overridesYaml, mirrorCfg); err != nil {
+			return nil, nil, fmt.Errorf("can't parse upload_mirrors %s: %v", names[i], err)
+		}
+		mirrorCfg.Mirrors = nil
+		mirrorCfg.AzureBlob.Path = strings.TrimPrefix(mirrorCfg.AzureBlob.Path, "/")
+		mirrorCfg.S3.Path = strings.TrimPrefix(mirrorCfg.S3.Path, "/")
+		mirrorCfg.GCS.Path = strings.TrimPrefix(mirrorCfg.GCS.Path, "/")
+		if err = resolveSecretReferences(context.Background(), mirrorCfg); err != nil {
+			return nil, nil, fmt.Errorf("upload_mirrors %s: %v", names[i], err)
+		}
+		if mirrorCfg.General.RemoteStorage == "none" || mirrorCfg.General.RemoteStorage == "custom" {
+			return nil, nil, fmt.Errorf("upload_mirrors %s: remote_storage: %s is not supported", names[i], mirrorCfg.General.RemoteStorage)
+		}
+		if err = ValidateConfig(mirrorCfg); err != nil {
+			return nil, nil, fmt.Errorf("upload_mirrors %s: %v", names[i], err)
+		}
+		mirrorConfigs[i] = mirrorCfg
+	}
+	return names, mirrorConfigs, nil
+}
+
+// GetRemoteConfig - copy of config for general->remote_storage when name is empty or equal remote_storage, otherwise config of `upload_mirrors` item with the same name
+func (cfg *Config) GetRemoteConfig(name string) (*Config, error) {
+	if name == "" || name == cfg.General.RemoteStorage {
+		mainYaml, err := yaml.Marshal(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("can't marshal config: %v", err)
+		}
+		remoteCfg := &Config{}
+		if err = yaml.Unmarshal(mainYaml, remoteCfg); err != nil {
+			return nil, fmt.Errorf("can't copy config: %v", err)
+		}
+		remoteCfg.Mirrors = nil
+		return remoteCfg, ValidateConfig(remoteCfg)
+	}
+	names, mirrorConfigs, err := cfg.GetMirrorConfigs()
+	if err != nil {
+		return nil, err
+	}
+	for i := range names {
+		if names[i] == name {
+			return mirrorConfigs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("remote storage `%s` not found, shall be general->remote_storage `%s` or name of upload_mirrors item %v", name, cfg.General.RemoteStorage, names)
+}
+
+func validateNotificationsConfig(cfg NotificationsConfig) error {
+	for _, event := range cfg.Events {
+		if event != "start" && event != "success" && event != "failure" && event != "retention_delete" && event != "restore_verify_mismatch" {
+			return fmt.Errorf("invalid notifications events: '%s', allowed values are `start`, `success`, `failure`, `retention_delete` or `restore_verify_mismatch`", event)
+		}
+	}
+	if cfg.Retries < 0 {
+		return fmt.Errorf("invalid notifications retries: %d, shall be 0 or greater", cfg.Retries)
+	}
+	if _, err := time.ParseDuration(cfg.RetriesPause); err != nil {
+		return fmt.Errorf("invalid notifications retries_pause: %v", err)
+	}
+	if _, err := time.ParseDuration(cfg.Timeout); err != nil {
+		return fmt.Errorf("invalid notifications timeout: %v", err)
+	}
+	if _, err := template.New("message_template").Parse(cfg.MessageTemplate); err != nil {
+		return fmt.Errorf("invalid notifications message_template: %v", err)
+	}
+	if _, err := template.New("webhook_template").Parse(cfg.WebhookTemplate); err != nil {
+		return fmt.Errorf("invalid notifications webhook_template: %v", err)
+	}
+	if cfg.SMTPHost != "" && (cfg.SMTPFrom == "" || len(cfg.SMTPTo) == 0) {
+		return fmt.Errorf("notifications smtp_from and smtp_to shall be set when smtp_host is set")
+	}
 	return nil
 }
 
@@ -461,6 +1339,61 @@ func PrintConfig(ctx *cli.Context) error {
 	return nil
 }
 
+// secretConfigKeys - yaml keys of credentials in all config sections and `upload_mirrors` items, map values like `api->tokens` redacted entirely
+var secretConfigKeys = map[string]bool{
+	"password": true, "smtp_password": true, "materialized_database_passwords": true,
+	"access_key": true, "secret_key": true, "access_key_id": true, "access_key_secret": true, "security_token": true,
+	"embedded_access_key": true, "embedded_secret_key": true, "account_key": true, "sas": true,
+	"sse_key": true, "sse_customer_key": true, "encryption_key": true, "key": true,
+	"credentials_json": true, "credentials_json_encoded": true, "application_credential_secret": true,
+	"tokens": true, "webhook_headers": true, "otlp_headers": true, "slack_webhook_url": true, "pagerduty_routing_key": true,
+}
+
+const RedactedValue = "******"
+
+// Redacted - config as yaml tree with non-empty credentials replaced by RedactedValue, for `GET /config`
+func (cfg *Config) Redacted() (map[string]interface{}, error) {
+	body, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{})
+	if err = yaml.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	redactConfigTree(result)
+	return result, nil
+}
+
+func redactConfigTree(node interface{}) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if secretConfigKeys[key] && !isEmptyConfigValue(item) {
+				value[key] = RedactedValue
+				continue
+			}
+			redactConfigTree(item)
+		}
+	case []interface{}:
+		for _, item := range value {
+			redactConfigTree(item)
+		}
+	}
+}
+
+func isEmptyConfigValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
 func DefaultConfig() *Config {
 	uploadConcurrency := uint8(1)
 	downloadConcurrency := uint8(1)
@@ -480,7 +1413,9 @@ func DefaultConfig() *Config {
 			MaxFileSize:             0,
 			BackupsToKeepLocal:      0,
 			BackupsToKeepRemote:     0,
+			MinAgeRemote:            "0s",
 			LogLevel:                "info",
+			LogFormat:               "text",
 			DisableProgressBar:      true,
 			UploadConcurrency:       uploadConcurrency,
 			DownloadConcurrency:     downloadConcurrency,
@@ -489,14 +1424,32 @@ func DefaultConfig() *Config {
 			DownloadByPart:          true,
 			UseResumableState:       true,
 			RetriesOnFailure:        3,
+			RestoreSizeTolerance:    0.1,
 			RetriesPause:            "30s",
+			StorageRetries:          3,
+			StorageRetriesPause:     "1s",
+			StorageRetriesMaxPause:  "30s",
 			RetriesDuration:         100 * time.Millisecond,
 			WatchInterval:           "1h",
 			WatchDuration:           1 * time.Hour,
 			FullInterval:            "24h",
 			FullDuration:            24 * time.Hour,
 			WatchBackupNameTemplate: "shard{shard}-{type}-{time:20060102150405}",
+			WatchNewDatabasesPolicy: "include",
 			RestoreDatabaseMapping:  make(map[string]string, 0),
+			RestoreTableMapping:     make(map[string]string, 0),
+			ObjectDiskConcurrency:   8,
+			CompressionConcurrency:  runtime.NumCPU(),
+			RestoreTablePriority:    make([]string, 0),
+			UploadMirrorsMode:       "tee",
+			DedupChunkSize:          4 * 1024 * 1024,
+			IONiceLevel:             4,
+			CheckFreeSpace:          "warn",
+			FreeSpaceMarginPercent:  10,
+			MaxRehydrationWait:      "0s",
+			OrphanedShadowMinAge:    "24h",
+			OrphanedRemoteMinAge:    "24h",
+			PartHashConcurrency:     runtime.NumCPU(),
 		},
 		ClickHouse: ClickHouseConfig{
 			Username: "default",
@@ -516,10 +1469,14 @@ func DefaultConfig() *Config {
 			RestartCommand:                   "exec:systemctl restart clickhouse-server",
 			IgnoreNotExistsErrorDuringFreeze: true,
 			CheckReplicasBeforeAttach:        true,
+			KeeperWaitTimeout:                "5m",
+			RewriteReplicaPathMacros:         true,
 			UseEmbeddedBackupRestore:         false,
+			EmbeddedBackupTarget:             "disk",
 			BackupMutations:                  true,
 			RestoreAsAttach:                  false,
 			CheckPartsColumns:                true,
+			FreezeConcurrency:                1,
 		},
 		AzureBlob: AzureBlobConfig{
 			EndpointSchema:    "https",
@@ -530,6 +1487,8 @@ func DefaultConfig() *Config {
 			MaxBuffers:        3,
 			MaxPartsCount:     5000,
 			Timeout:           "15m",
+			RehydrateTier:     "Hot",
+			RehydratePriority: "Standard",
 		},
 		S3: S3Config{
 			Region:                  "us-east-1",
@@ -544,12 +1503,22 @@ func DefaultConfig() *Config {
 			Concurrency:             int(downloadConcurrency + 1),
 			PartSize:                0,
 			MaxPartsCount:           5000,
+			RestoreTier:             string(s3types.TierExpedited),
+			RestoreDays:             1,
 		},
 		GCS: GCSConfig{
 			CompressionLevel:  1,
 			CompressionFormat: "tar",
 			StorageClass:      "STANDARD",
 			ClientPoolSize:    500,
+
+			ObjectDiskCopyBatchSize: 10,
+			RetryPolicy:             "always",
+			RetryInitialBackoff:     "1s",
+			RetryMaxBackoff:         "30s",
+			RetryMultiplier:         2,
+			ChunkRetryDeadline:      "5m",
+			Timeout:                 "15m",
 		},
 		COS: COSConfig{
 			RowURL:            "",
@@ -564,6 +1533,9 @@ func DefaultConfig() *Config {
 			ListenAddr:                    "localhost:7171",
 			EnableMetrics:                 true,
 			CompleteResumableAfterRestart: true,
+			InventoryScanInterval:         "0s",
+			ConfigReloadInterval:          "10s",
+			UserRole:                      APIRoleAdmin,
 		},
 		FTP: FTPConfig{
 			Timeout:           "2m",
@@ -577,10 +1549,93 @@ func DefaultConfig() *Config {
 			CompressionLevel:  1,
 			Concurrency:       int(downloadConcurrency + 1),
 		},
+		Rclone: RcloneConfig{
+			Binary:            "rclone",
+			CompressionFormat: "tar",
+			CompressionLevel:  1,
+			ExtraArgs:         make([]string, 0),
+		},
+		Swift: SwiftConfig{
+			EndpointType:      "public",
+			UserDomainName:    "Default",
+			ProjectDomainName: "Default",
+			LargeObjectType:   "slo",
+			SegmentSize:       256 * 1024 * 1024,
+			Timeout:           "5m",
+			CompressionFormat: "tar",
+			CompressionLevel:  1,
+		},
+		OSS: OSSConfig{
+			PartSize:          64 * 1024 * 1024,
+			Timeout:           "5m",
+			CompressionFormat: "tar",
+			CompressionLevel:  1,
+		},
+		OBS: OBSConfig{
+			PartSize:          64 * 1024 * 1024,
+			Timeout:           "5m",
+			CompressionFormat: "tar",
+			CompressionLevel:  1,
+		},
 		Custom: CustomConfig{
 			CommandTimeout:         "4h",
 			CommandTimeoutDuration: 4 * time.Hour,
 		},
+		Cluster: ClusterConfig{
+			Name:         "{cluster}",
+			APIPort:      7171,
+			PollInterval: "5s",
+			Timeout:      "24h",
+		},
+		Notifications: NotificationsConfig{
+			Events:          []string{"start", "success", "failure", "retention_delete", "restore_verify_mismatch"},
+			MessageTemplate: "{{.Operation}} {{.Backup}} {{.Event}} on {{.Hostname}}{{if .Duration}} after {{.Duration}}{{end}}{{if .Error}}: {{.Error}}{{end}}",
+			Retries:         3,
+			RetriesPause:    "5s",
+			Timeout:         "30s",
+			WebhookHeaders:  make(map[string]string),
+			SMTPPort:        587,
+			SMTPTo:          make([]string, 0),
+		},
+		Tracing: TracingConfig{
+			OTLPHeaders:    make(map[string]string),
+			ServiceName:    "clickhouse-backup",
+			ExportInterval: "5s",
+			Timeout:        "10s",
+		},
+		Hooks: HooksConfig{
+			Timeout: "5m",
+		},
+		Cost: CostConfig{
+			Currency:           "USD",
+			RetentionScenarios: make([]string, 0),
+			Prices: map[string]PriceConfig{
+				"s3": {
+					GBMonth:    map[string]float64{"default": 0.023, "STANDARD": 0.023, "INTELLIGENT_TIERING": 0.023, "STANDARD_IA": 0.0125, "ONEZONE_IA": 0.01, "GLACIER_IR": 0.004, "GLACIER": 0.0036, "DEEP_ARCHIVE": 0.00099},
+					PutPer1000: 0.005,
+					GetPer1000: 0.0004,
+				},
+				"gcs": {
+					GBMonth:    map[string]float64{"default": 0.02, "STANDARD": 0.02, "NEARLINE": 0.01, "COLDLINE": 0.004, "ARCHIVE": 0.0012},
+					PutPer1000: 0.005,
+					GetPer1000: 0.0004,
+				},
+				"azblob": {
+					GBMonth:    map[string]float64{"default": 0.0184},
+					PutPer1000: 0.0065,
+					GetPer1000: 0.0005,
+				},
+				"cos": {
+					GBMonth:    map[string]float64{"default": 0.018},
+					PutPer1000: 0.0005,
+					GetPer1000: 0.0005,
+				},
+			},
+		},
+		Plugin: PluginConfig{
+			CompressionFormat: "tar",
+			CompressionLevel:  1,
+		},
 	}
 }
 
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTableStorageRules(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.General.RemoteStorage = "s3"
+	cfg.General.TableStorageRules = []TableStorageRule{
+		{Tables: "db.history_*", StorageClass: "glacier_ir", PathPrefix: "archive/history"},
+		{Tables: "db.hot", StorageClass: "STANDARD"},
+	}
+	assert.NoError(t, cfg.validateTableStorageRules())
+
+	for _, rule := range []TableStorageRule{
+		{Tables: "", StorageClass: "GLACIER"},
+		{Tables: "db.*"},
+		{Tables: "db.*", StorageClass: "FROZEN"},
+		{Tables: "db.*", PathPrefix: "/archive"},
+		{Tables: "db.*", PathPrefix: ".chunks"},
+		{Tables: "db.*", PathPrefix: "archive/../other"},
+	} {
+		cfg.General.TableStorageRules = []TableStorageRule{rule}
+		assert.Error(t, cfg.validateTableStorageRules(), "%#v", rule)
+	}
+
+	cfg.General.RemoteStorage = "azblob"
+	cfg.General.TableStorageRules = []TableStorageRule{{Tables: "db.*", StorageClass: "Archive"}}
+	assert.ErrorContains(t, cfg.validateTableStorageRules(), "not supported")
+	cfg.General.TableStorageRules = []TableStorageRule{{Tables: "db.*", PathPrefix: "archive"}}
+	assert.NoError(t, cfg.validateTableStorageRules())
+}
+
+func TestValidateRehydration(t *testing.T) {
+	cfg := DefaultConfig()
+	assert.NoError(t, cfg.validateRehydration())
+	assert.Equal(t, time.Duration(0), cfg.General.MaxRehydrationWaitDuration)
+	cfg.General.MaxRehydrationWait = "12h"
+	assert.NoError(t, cfg.validateRehydration())
+	assert.Equal(t, 12*time.Hour, cfg.General.MaxRehydrationWaitDuration)
+
+	for _, invalid := range []func(cfg *Config){
+		func(cfg *Config) { cfg.General.MaxRehydrationWait = "-1h" },
+		func(cfg *Config) { cfg.S3.RestoreTier = "Instant" },
+		func(cfg *Config) { cfg.S3.RestoreDays = 0 },
+		func(cfg *Config) { cfg.AzureBlob.RehydrateTier = "Archive" },
+		func(cfg *Config) { cfg.AzureBlob.RehydratePriority = "Low" },
+	} {
+		cfg = DefaultConfig()
+		invalid(cfg)
+		assert.Error(t, cfg.validateRehydration())
+	}
+}
+
+func TestS3UsePathStyle(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.S3.ForcePathStyle = true
+	cfg.S3.AddressingStylePerBucket = map[string]string{"dataset": "virtual", "minio": "path"}
+	assert.False(t, cfg.S3.UsePathStyle("dataset", cfg.S3.ForcePathStyle))
+	assert.True(t, cfg.S3.UsePathStyle("minio", false))
+	assert.True(t, cfg.S3.UsePathStyle("backup", cfg.S3.ForcePathStyle))
+	assert.False(t, cfg.S3.UsePathStyle("backup", false))
+}
+
+func TestLoadStoragePlugin(t *testing.T) {
+	err := loadStoragePlugin("/nonexistent/storage.so")
+	assert.ErrorContains(t, err, "/nonexistent/storage.so")
+	assert.ErrorContains(t, err, "CGO_ENABLED")
+}
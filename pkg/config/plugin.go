@@ -0,0 +1,30 @@
+package config
+
+import (
+	"sync"
+)
+
+// PluginConfig - settings for `remote_storage` registered via storage.RegisterRemoteStorage, in custom build or in Go plugin loaded from `path`
+type PluginConfig struct {
+	Path              string            `yaml:"path" envconfig:"PLUGIN_PATH"`
+	Settings          map[string]string `yaml:"settings" envconfig:"PLUGIN_SETTINGS"`
+	CompressionFormat string            `yaml:"compression_format" envconfig:"PLUGIN_COMPRESSION_FORMAT"`
+	CompressionLevel  int               `yaml:"compression_level" envconfig:"PLUGIN_COMPRESSION_LEVEL"`
+}
+
+var registeredRemoteStorages = map[string]struct{}{}
+var registeredRemoteStoragesMx sync.RWMutex
+
+// RegisterRemoteStorageKind - config package can't import storage, so storage.RegisterRemoteStorage register kind here to pass ValidateConfig
+func RegisterRemoteStorageKind(kind string) {
+	registeredRemoteStoragesMx.Lock()
+	defer registeredRemoteStoragesMx.Unlock()
+	registeredRemoteStorages[kind] = struct{}{}
+}
+
+func IsRegisteredRemoteStorage(kind string) bool {
+	registeredRemoteStoragesMx.RLock()
+	defer registeredRemoteStoragesMx.RUnlock()
+	_, exists := registeredRemoteStorages[kind]
+	return exists
+}
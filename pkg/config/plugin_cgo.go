@@ -0,0 +1,17 @@
+//go:build cgo && (linux || darwin || freebsd)
+
+package config
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// loadStoragePlugin - init() of plugin shall call storage.RegisterRemoteStorage, plugin shall be built with the same Go version and clickhouse-backup module version,
+// plugin.Open returns the same plugin when called twice, so config reload is safe
+func loadStoragePlugin(path string) error {
+	if _, err := plugin.Open(path); err != nil {
+		return fmt.Errorf("can't load plugin->path %s, clickhouse-backup shall be dynamically linked with CGO_ENABLED=1 to load Go plugins: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !cgo || !(linux || darwin || freebsd)
+
+package config
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// loadStoragePlugin - Go plugins require dynamically linked binary built with CGO_ENABLED=1, official release binaries are static and built with CGO_ENABLED=0
+func loadStoragePlugin(path string) error {
+	return fmt.Errorf("plugin->path %s is not supported, this clickhouse-backup binary built with CGO_ENABLED=0 for %s, use own build with CGO_ENABLED=1 which imports plugin or calls storage.RegisterRemoteStorage, or use `remote_storage: custom` with external commands", path, runtime.GOOS)
+}
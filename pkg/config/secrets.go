@@ -0,0 +1,466 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// credential values could be a reference to external secret instead of plaintext, `#key` selects field from JSON secret or from Vault secret data
+const (
+	secretSchemeFile  = "file://"
+	secretSchemeVault = "vault://"
+	secretSchemeAWS   = "aws-sm://"
+	secretSchemeGCP   = "gcp-sm://"
+)
+
+// secretsCacheTTL - secrets from external managers are cached, `server` reloads config for each command, so rotated secrets apply after TTL
+var secretsCacheTTL = 5 * time.Minute
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+var (
+	secretsCache   = make(map[string]cachedSecret)
+	secretsCacheMx sync.Mutex
+)
+
+func isSecretReference(value string) bool {
+	for _, scheme := range []string{secretSchemeFile, secretSchemeVault, secretSchemeAWS, secretSchemeGCP} {
+		if strings.HasPrefix(value, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// applySecretFiles - `<ENV_NAME>_FILE` environment variable for each credential setting contains path to file with value, like docker and kubernetes secrets,
+// trailing new line is removed, value from file has priority over config file and `<ENV_NAME>` variable
+func applySecretFiles(cfg *Config) error {
+	return walkSecretFields(reflect.ValueOf(cfg).Elem(), "", func(field reflect.Value, yamlKey, envName string) error {
+		if envName == "" || field.Kind() != reflect.String {
+			return nil
+		}
+		secretFile := os.Getenv(envName + "_FILE")
+		if secretFile == "" {
+			return nil
+		}
+		value, err := readSecretFile(secretFile)
+		if err != nil {
+			return fmt.Errorf("%s_FILE: %v", envName, err)
+		}
+		field.SetString(value)
+		return nil
+	})
+}
+
+// resolveSecretReferences - replace `file://`, `vault://`, `aws-sm://`, `gcp-sm://` references in credential settings and in values of credential maps
+func resolveSecretReferences(ctx context.Context, cfg *Config) error {
+	return walkSecretFields(reflect.ValueOf(cfg).Elem(), "", func(field reflect.Value, yamlKey, envName string) error {
+		switch field.Kind() {
+		case reflect.String:
+			if !isSecretReference(field.String()) {
+				return nil
+			}
+			value, err := resolveSecret(ctx, field.String())
+			if err != nil {
+				return fmt.Errorf("can't resolve %s: %v", yamlKey, err)
+			}
+			field.SetString(value)
+		case reflect.Map:
+			if field.Type().Elem().Kind() != reflect.String {
+				return nil
+			}
+			for _, key := range field.MapKeys() {
+				reference := field.MapIndex(key).String()
+				if !isSecretReference(reference) {
+					continue
+				}
+				value, err := resolveSecret(ctx, reference)
+				if err != nil {
+					return fmt.Errorf("can't resolve %s.%s: %v", yamlKey, key.String(), err)
+				}
+				field.SetMapIndex(key, reflect.ValueOf(value))
+			}
+		}
+		return nil
+	})
+}
+
+// walkSecretFields - call fn for each field of config sections which yaml key is in secretConfigKeys
+func walkSecretFields(section reflect.Value, prefix string, fn func(field reflect.Value, yamlKey, envName string) error) error {
+	for i := 0; i < section.NumField(); i++ {
+		fieldType := section.Type().Field(i)
+		if !fieldType.IsExported() {
+			continue
+		}
+		field := section.Field(i)
+		yamlKey := strings.Split(fieldType.Tag.Get("yaml"), ",")[0]
+		if field.Kind() == reflect.Struct {
+			if err := walkSecretFields(field, prefix+yamlKey+".", fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if !secretConfigKeys[yamlKey] {
+			continue
+		}
+		if err := fn(field, prefix+yamlKey, fieldType.Tag.Get("envconfig")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSecretFile(secretFile string) (string, error) {
+	value, err := os.ReadFile(filepath.Clean(secretFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(value), "\r\n"), nil
+}
+
+// resolveSecret - `scheme://name#key`, file secrets are not cached, so changed file applies for next command
+func resolveSecret(ctx context.Context, reference string) (string, error) {
+	if strings.HasPrefix(reference, secretSchemeFile) {
+		return readSecretFile(strings.TrimPrefix(reference, secretSchemeFile))
+	}
+	secretsCacheMx.Lock()
+	cached, exists := secretsCache[reference]
+	secretsCacheMx.Unlock()
+	if exists && time.Now().Before(cached.expires) {
+		return cached.value, nil
+	}
+	name, key, _ := strings.Cut(reference, "#")
+	var value string
+	var err error
+	switch {
+	case strings.HasPrefix(name, secretSchemeVault):
+		value, err = vaultSecrets.get(ctx, strings.TrimPrefix(name, secretSchemeVault), key)
+	case strings.HasPrefix(name, secretSchemeAWS):
+		value, err = getAWSSecret(ctx, strings.TrimPrefix(name, secretSchemeAWS), key)
+	case strings.HasPrefix(name, secretSchemeGCP):
+		value, err = getGCPSecret(ctx, strings.TrimPrefix(name, secretSchemeGCP), key)
+	default:
+		err = fmt.Errorf("unsupported secret reference scheme")
+	}
+	if err != nil {
+		return "", err
+	}
+	secretsCacheMx.Lock()
+	secretsCache[reference] = cachedSecret{value: value, expires: time.Now().Add(secretsCacheTTL)}
+	secretsCacheMx.Unlock()
+	return value, nil
+}
+
+// selectSecretKey - whole secret when key is empty, otherwise field of JSON object
+func selectSecretKey(secret, key string) (string, error) {
+	if key == "" {
+		return secret, nil
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret is not JSON object, can't select #%s: %v", key, err)
+	}
+	return secretField(fields, key)
+}
+
+func secretField(fields map[string]interface{}, key string) (string, error) {
+	value, exists := fields[key]
+	if !exists {
+		return "", fmt.Errorf("#%s not found in secret", key)
+	}
+	if s, isString := value.(string); isString {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func doSecretRequest(req *http.Request, result interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s return %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, result)
+}
+
+// vaultClient - token from VAULT_TOKEN, VAULT_TOKEN_FILE or ~/.vault-token, file re-read for each request to support vault agent sidecar,
+// renewable token renewed with `renew-self` when less than third of TTL left
+type vaultClient struct {
+	mx           sync.Mutex
+	token        string
+	tokenTTL     time.Duration
+	tokenExpires time.Time
+	renewable    bool
+}
+
+var vaultSecrets = &vaultClient{}
+
+func (v *vaultClient) getToken(ctx context.Context, addr string) (string, error) {
+	token := os.Getenv("VAULT_TOKEN")
+	if tokenFile := os.Getenv("VAULT_TOKEN_FILE"); token == "" && tokenFile != "" {
+		var err error
+		if token, err = readSecretFile(tokenFile); err != nil {
+			return "", fmt.Errorf("VAULT_TOKEN_FILE: %v", err)
+		}
+	}
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			token, _ = readSecretFile(filepath.Join(home, ".vault-token"))
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("VAULT_TOKEN, VAULT_TOKEN_FILE or ~/.vault-token is required")
+	}
+	v.mx.Lock()
+	defer v.mx.Unlock()
+	if token != v.token {
+		v.token = token
+		if err := v.lookupToken(ctx, addr); err != nil {
+			return "", err
+		}
+	}
+	if v.renewable && v.tokenTTL > 0 && time.Until(v.tokenExpires) < v.tokenTTL/3 {
+		if err := v.renewToken(ctx, addr); err != nil {
+			return "", err
+		}
+	}
+	return v.token, nil
+}
+
+type vaultAuthResponse struct {
+	Data struct {
+		TTL         int64 `json:"ttl"`
+		CreationTTL int64 `json:"creation_ttl"`
+		Renewable   bool  `json:"renewable"`
+	} `json:"data"`
+	Auth struct {
+		LeaseDuration int64 `json:"lease_duration"`
+		Renewable     bool  `json:"renewable"`
+	} `json:"auth"`
+}
+
+func (v *vaultClient) newRequest(ctx context.Context, method, addr, apiPath string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(apiPath, "/"), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	return req, nil
+}
+
+func (v *vaultClient) lookupToken(ctx context.Context, addr string) error {
+	req, err := v.newRequest(ctx, http.MethodGet, addr, "auth/token/lookup-self", nil)
+	if err != nil {
+		return err
+	}
+	result := vaultAuthResponse{}
+	if err = doSecretRequest(req, &result); err != nil {
+		return fmt.Errorf("vault token lookup failed: %v", err)
+	}
+	v.renewable = result.Data.Renewable
+	v.tokenTTL = time.Duration(result.Data.CreationTTL) * time.Second
+	v.tokenExpires = time.Now().Add(time.Duration(result.Data.TTL) * time.Second)
+	return nil
+}
+
+func (v *vaultClient) renewToken(ctx context.Context, addr string) error {
+	req, err := v.newRequest(ctx, http.MethodPost, addr, "auth/token/renew-self", bytes.NewReader([]byte("{}")))
+	if err != nil {
+		return err
+	}
+	result := vaultAuthResponse{}
+	if err = doSecretRequest(req, &result); err != nil {
+		return fmt.Errorf("vault token renew failed: %v", err)
+	}
+	v.renewable = result.Auth.Renewable
+	v.tokenTTL = time.Duration(result.Auth.LeaseDuration) * time.Second
+	v.tokenExpires = time.Now().Add(v.tokenTTL)
+	return nil
+}
+
+// get - `vault://secret/data/clickhouse-backup#s3_secret_key` for KV v2, `vault://secret/clickhouse-backup#s3_secret_key` for KV v1, VAULT_ADDR is required
+func (v *vaultClient) get(ctx context.Context, secretPath, key string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is required")
+	}
+	if _, err := v.getToken(ctx, addr); err != nil {
+		return "", err
+	}
+	v.mx.Lock()
+	req, err := v.newRequest(ctx, http.MethodGet, addr, secretPath, nil)
+	v.mx.Unlock()
+	if err != nil {
+		return "", err
+	}
+	result := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err = doSecretRequest(req, &result); err != nil {
+		return "", err
+	}
+	data := result.Data
+	if kv2Data, isKV2 := data["data"].(map[string]interface{}); isKV2 {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = kv2Data
+		}
+	}
+	if key == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("vault secret %s contains %d fields, use #key to select one", secretPath, len(data))
+		}
+		for field := range data {
+			key = field
+		}
+	}
+	return secretField(data, key)
+}
+
+// awsSecretsManagerEndpoint - AWS_ENDPOINT_URL_SECRETS_MANAGER allows VPC endpoints and tests
+func awsSecretsManagerEndpoint(region string) string {
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER"); endpoint != "" {
+		return endpoint
+	}
+	return fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", region)
+}
+
+var (
+	awsSecretsConfig   *aws.Config
+	awsSecretsConfigMx sync.Mutex
+)
+
+// getAWSSecret - `aws-sm://<name or ARN>#key`, credentials from default AWS chain, refreshed by SDK credentials cache before expiry, region from ARN or AWS_REGION
+func getAWSSecret(ctx context.Context, secretId, key string) (string, error) {
+	awsSecretsConfigMx.Lock()
+	if awsSecretsConfig == nil {
+		loadedConfig, err := awsConfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			awsSecretsConfigMx.Unlock()
+			return "", err
+		}
+		awsSecretsConfig = &loadedConfig
+	}
+	cfg := *awsSecretsConfig
+	awsSecretsConfigMx.Unlock()
+	region := cfg.Region
+	if arnParts := strings.Split(secretId, ":"); len(arnParts) > 3 && arnParts[0] == "arn" {
+		region = arnParts[3]
+	}
+	if region == "" {
+		return "", fmt.Errorf("AWS_REGION is required for secret %s", secretId)
+	}
+	credentials, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string]string{"SecretId": secretId})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, awsSecretsManagerEndpoint(region), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	payloadHash := sha256.Sum256(body)
+	if err = v4.NewSigner().SignHTTP(ctx, credentials, req, hex.EncodeToString(payloadHash[:]), "secretsmanager", region, time.Now()); err != nil {
+		return "", err
+	}
+	result := struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary string `json:"SecretBinary"`
+	}{}
+	if err = doSecretRequest(req, &result); err != nil {
+		return "", err
+	}
+	secret := result.SecretString
+	if secret == "" && result.SecretBinary != "" {
+		decoded, err := base64.StdEncoding.DecodeString(result.SecretBinary)
+		if err != nil {
+			return "", err
+		}
+		secret = string(decoded)
+	}
+	return selectSecretKey(secret, key)
+}
+
+var (
+	gcpSecretManagerEndpoint = "https://secretmanager.googleapis.com/v1/"
+	gcpTokenSource           oauth2.TokenSource
+	gcpTokenSourceMx         sync.Mutex
+)
+
+// getGCPSecret - `gcp-sm://projects/<project>/secrets/<name>[/versions/<version>]#key`, application default credentials, token refreshed by oauth2 before expiry
+func getGCPSecret(ctx context.Context, name, key string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	gcpTokenSourceMx.Lock()
+	if gcpTokenSource == nil {
+		tokenSource, err := google.DefaultTokenSource(context.Background(), "https://www.googleapis.com/auth/cloud-platform")
+		if err != nil {
+			gcpTokenSourceMx.Unlock()
+			return "", err
+		}
+		gcpTokenSource = oauth2.ReuseTokenSource(nil, tokenSource)
+	}
+	tokenSource := gcpTokenSource
+	gcpTokenSourceMx.Unlock()
+	token, err := tokenSource.Token()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpSecretManagerEndpoint+strings.TrimPrefix(name, "/")+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	token.SetAuthHeader(req)
+	result := struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}{}
+	if err = doSecretRequest(req, &result); err != nil {
+		return "", err
+	}
+	secret, err := base64.StdEncoding.DecodeString(result.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return selectSecretKey(string(secret), key)
+}
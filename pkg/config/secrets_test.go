@@ -0,0 +1,127 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestLoadConfigSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := path.Join(dir, "s3_secret_key")
+	require.NoError(t, os.WriteFile(secretFile, []byte("secret-from-env-file\n"), 0600))
+	passwordFile := path.Join(dir, "clickhouse_password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("password-from-reference"), 0600))
+	configPath := path.Join(dir, "config.yml")
+	require.NoError(t, os.WriteFile(configPath, []byte("general:\n  remote_storage: none\nclickhouse:\n  password: file://"+passwordFile+"\ns3:\n  secret_key: plaintext\n"), 0600))
+	t.Setenv("S3_SECRET_KEY_FILE", secretFile)
+
+	cfg, err := LoadConfig(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "secret-from-env-file", cfg.S3.SecretKey)
+	assert.Equal(t, "password-from-reference", cfg.ClickHouse.Password)
+
+	t.Setenv("S3_SECRET_KEY_FILE", path.Join(dir, "absent"))
+	_, err = LoadConfig(configPath)
+	assert.ErrorContains(t, err, "S3_SECRET_KEY_FILE")
+}
+
+func TestResolveVaultSecret(t *testing.T) {
+	renewed := 0
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			_, _ = w.Write([]byte(`{"data":{"ttl":30,"creation_ttl":3600,"renewable":true}}`))
+		case "/v1/auth/token/renew-self":
+			renewed++
+			_, _ = w.Write([]byte(`{"auth":{"lease_duration":3600,"renewable":true}}`))
+		case "/v1/secret/data/clickhouse-backup":
+			_, _ = w.Write([]byte(`{"data":{"data":{"access_key":"vault-access","secret_key":"vault-secret"},"metadata":{"version":1}}}`))
+		case "/v1/kv1/clickhouse-backup":
+			_, _ = w.Write([]byte(`{"data":{"password":"kv1-password"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer vault.Close()
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+	vaultSecrets = &vaultClient{}
+
+	cfg := DefaultConfig()
+	cfg.S3.AccessKey = "vault://secret/data/clickhouse-backup#access_key"
+	cfg.S3.SecretKey = "vault://secret/data/clickhouse-backup#secret_key"
+	cfg.ClickHouse.Password = "vault://kv1/clickhouse-backup"
+	require.NoError(t, resolveSecretReferences(context.Background(), cfg))
+	assert.Equal(t, "vault-access", cfg.S3.AccessKey)
+	assert.Equal(t, "vault-secret", cfg.S3.SecretKey)
+	assert.Equal(t, "kv1-password", cfg.ClickHouse.Password)
+	assert.Equal(t, 1, renewed, "token with ttl less than third of initial ttl shall be renewed once")
+
+	cfg.S3.SecretKey = "vault://secret/data/absent#secret_key"
+	assert.Error(t, resolveSecretReferences(context.Background(), cfg))
+}
+
+func TestResolveAWSSecret(t *testing.T) {
+	aws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" || !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIDTEST/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		request := make(map[string]string)
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		if request["SecretId"] != "prod/clickhouse-backup" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"SecretString":"{\"secret_key\":\"aws-secret\"}"}`))
+	}))
+	defer aws.Close()
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", aws.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	awsSecretsConfig = nil
+
+	value, err := resolveSecret(context.Background(), "aws-sm://prod/clickhouse-backup#secret_key")
+	require.NoError(t, err)
+	assert.Equal(t, "aws-secret", value)
+}
+
+func TestResolveGCPSecret(t *testing.T) {
+	gcp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gcp-token" || r.URL.Path != "/projects/p/secrets/clickhouse-backup/versions/latest:access" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"payload":{"data":"` + base64.StdEncoding.EncodeToString([]byte("gcp-secret")) + `"}}`))
+	}))
+	defer gcp.Close()
+	gcpSecretManagerEndpoint = gcp.URL + "/"
+	gcpTokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "gcp-token"})
+	defer func() {
+		gcpSecretManagerEndpoint = "https://secretmanager.googleapis.com/v1/"
+		gcpTokenSource = nil
+	}()
+
+	cfg := DefaultConfig()
+	cfg.API.Tokens = map[string]string{"monitoring": APIRoleReadOnly}
+	cfg.Notifications.WebhookHeaders = map[string]string{"Authorization": "gcp-sm://projects/p/secrets/clickhouse-backup"}
+	require.NoError(t, resolveSecretReferences(context.Background(), cfg))
+	assert.Equal(t, "gcp-secret", cfg.Notifications.WebhookHeaders["Authorization"])
+	assert.Equal(t, APIRoleReadOnly, cfg.API.Tokens["monitoring"])
+}
@@ -23,7 +23,8 @@ func DeleteRemote(ctx context.Context, cfg *config.Config, backupName string) er
 		"cfg":         cfg,
 	}
 	args := ApplyCommandTemplate(cfg.Custom.DeleteCommand, templateData)
-	err := utils.ExecCmd(ctx, cfg.Custom.CommandTimeoutDuration, args[0], args[1:]...)
+	out, err := RunCommand(ctx, cfg, args, newCommandRequest("delete", backupName))
+	log.Info(out)
 	if err == nil {
 		log.WithFields(log.Fields{
 			"backup":    backupName,
@@ -33,10 +33,16 @@ func Download(ctx context.Context, cfg *config.Config, backupName string, tableP
 		"s":             schemaOnly,
 		"schema":        schemaOnly,
 	}
+	request := newCommandRequest("download", backupName)
+	request.TablePattern = tablePattern
+	request.Partitions = partitions
+	request.SchemaOnly = schemaOnly
 	args := ApplyCommandTemplate(cfg.Custom.DownloadCommand, templateData)
 	retry := retrier.New(retrier.ConstantBackoff(cfg.General.RetriesOnFailure, cfg.General.RetriesDuration), nil)
 	err := retry.RunCtx(ctx, func(ctx context.Context) error {
-		return utils.ExecCmd(ctx, cfg.Custom.CommandTimeoutDuration, args[0], args[1:]...)
+		out, err := RunCommand(ctx, cfg, args, request)
+		log.Info(out)
+		return err
 	})
 	if err == nil {
 		log.
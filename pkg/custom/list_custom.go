@@ -21,7 +21,7 @@ func List(ctx context.Context, cfg *config.Config) ([]storage.Backup, error) {
 		"cfg": cfg,
 	}
 	args := ApplyCommandTemplate(cfg.Custom.ListCommand, templateData)
-	out, err := utils.ExecCmdOut(ctx, cfg.Custom.CommandTimeoutDuration, args[0], args[1:]...)
+	out, err := RunCommand(ctx, cfg, args, newCommandRequest("list", ""))
 	if err == nil {
 		outLines := strings.Split(strings.TrimRight(out, "\n"), "\n")
 		backupList := make([]storage.Backup, len(outLines))
@@ -39,10 +39,18 @@ func Upload(ctx context.Context, cfg *config.Config, backupName, diffFrom, diffF
 		"s":                schemaOnly,
 		"schema":           schemaOnly,
 	}
+	request := newCommandRequest("upload", backupName)
+	request.DiffFrom = diffFrom
+	request.DiffFromRemote = diffFromRemote
+	request.TablePattern = tablePattern
+	request.Partitions = partitions
+	request.SchemaOnly = schemaOnly
 	args := ApplyCommandTemplate(cfg.Custom.UploadCommand, templateData)
 	retry := retrier.New(retrier.ConstantBackoff(cfg.General.RetriesOnFailure, cfg.General.RetriesDuration), nil)
 	err := retry.RunCtx(ctx, func(ctx context.Context) error {
-		return utils.ExecCmd(ctx, cfg.Custom.CommandTimeoutDuration, args[0], args[1:]...)
+		out, err := RunCommand(ctx, cfg, args, request)
+		log.Info(out)
+		return err
 	})
 	if err == nil {
 		log.
@@ -2,11 +2,74 @@ package custom
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
 	"github.com/apex/log"
 	"github.com/google/shlex"
+	"strconv"
+	"strings"
 	"text/template"
 )
 
+// ProtocolVersion - version of JSON request which custom commands receive on stdin
+const ProtocolVersion = 1
+
+// CommandRequest - describes one custom command call, passed as single JSON line to stdin and as CLICKHOUSE_BACKUP_* environment variables
+type CommandRequest struct {
+	Version        int      `json:"version"`
+	Operation      string   `json:"operation"`
+	BackupName     string   `json:"backup_name,omitempty"`
+	DiffFrom       string   `json:"diff_from,omitempty"`
+	DiffFromRemote string   `json:"diff_from_remote,omitempty"`
+	TablePattern   string   `json:"table_pattern,omitempty"`
+	Partitions     []string `json:"partitions,omitempty"`
+	SchemaOnly     bool     `json:"schema_only,omitempty"`
+}
+
+func newCommandRequest(operation, backupName string) CommandRequest {
+	return CommandRequest{
+		Version:    ProtocolVersion,
+		Operation:  operation,
+		BackupName: backupName,
+	}
+}
+
+// Env - environment variables which custom command receives in addition to clickhouse-backup environment
+func (r CommandRequest) Env() []string {
+	return []string{
+		"CLICKHOUSE_BACKUP_PROTOCOL_VERSION=" + strconv.Itoa(r.Version),
+		"CLICKHOUSE_BACKUP_OPERATION=" + r.Operation,
+		"CLICKHOUSE_BACKUP_NAME=" + r.BackupName,
+		"CLICKHOUSE_BACKUP_DIFF_FROM=" + r.DiffFrom,
+		"CLICKHOUSE_BACKUP_DIFF_FROM_REMOTE=" + r.DiffFromRemote,
+		"CLICKHOUSE_BACKUP_TABLE_PATTERN=" + r.TablePattern,
+		"CLICKHOUSE_BACKUP_PARTITIONS=" + strings.Join(r.Partitions, ","),
+		"CLICKHOUSE_BACKUP_SCHEMA_ONLY=" + strconv.FormatBool(r.SchemaOnly),
+	}
+}
+
+// RunCommand - execute custom command, pass request via environment and stdin, return stdout, stderr is logged
+func RunCommand(ctx context.Context, cfg *config.Config, args []string, request CommandRequest) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("custom %s command is empty", request.Operation)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	stdout, stderr, err := utils.ExecCmdWithInput(ctx, cfg.Custom.CommandTimeoutDuration, request.Env(), bytes.NewReader(append(body, '\n')), args[0], args[1:]...)
+	if stderr != "" {
+		log.WithField("operation", request.Operation+"_custom").Info(stderr)
+	}
+	if err != nil {
+		return stdout, fmt.Errorf("%s: %v", strings.Join(args, " "), err)
+	}
+	return stdout, nil
+}
+
 func ApplyCommandTemplate(command string, templateData interface{}) []string {
 	var b bytes.Buffer
 	tpl, err := template.New("").Parse(command)
@@ -0,0 +1,24 @@
+package custom
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommandPassRequestViaStdinAndEnv(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Custom.CommandTimeoutDuration = 10 * time.Second
+	request := newCommandRequest("upload", "daily")
+	request.Partitions = []string{"202301", "202302"}
+	args := ApplyCommandTemplate(`sh -c 'cat; echo "$CLICKHOUSE_BACKUP_OPERATION $CLICKHOUSE_BACKUP_NAME $CLICKHOUSE_BACKUP_PARTITIONS"; echo ignored >&2'`, nil)
+	out, err := RunCommand(context.Background(), cfg, args, request)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"version\":1,\"operation\":\"upload\",\"backup_name\":\"daily\",\"partitions\":[\"202301\",\"202302\"]}\nupload daily 202301,202302\n", out)
+
+	_, err = RunCommand(context.Background(), cfg, []string{"sh", "-c", "exit 3"}, request)
+	assert.Error(t, err)
+}
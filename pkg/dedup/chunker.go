@@ -0,0 +1,103 @@
+package dedup
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// gear - random values for rolling hash, generated from sha256 to keep chunk boundaries stable between versions and replicas
+var gear [256]uint64
+
+func init() {
+	for i := range gear {
+		sum := sha256.Sum256([]byte{byte(i)})
+		gear[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+}
+
+// Chunker - content defined chunking with FastCDC gear hash and normalized chunking,
+// chunk sizes between avgSize/4 and avgSize*4, inserted or removed bytes change only neighbour chunks
+type Chunker struct {
+	r       *bufio.Reader
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64
+	maskL   uint64
+}
+
+func NewChunker(r io.Reader, avgSize int) (*Chunker, error) {
+	if avgSize < 64 || avgSize&(avgSize-1) != 0 {
+		return nil, fmt.Errorf("chunk size %d shall be power of two and not less than 64", avgSize)
+	}
+	maskBits := bits.TrailingZeros(uint(avgSize))
+	return &Chunker{
+		r:       bufio.NewReaderSize(r, avgSize*4),
+		minSize: avgSize / 4,
+		avgSize: avgSize,
+		maxSize: avgSize * 4,
+		// harder to find cut point before average size, easier after, so chunk sizes concentrate around average
+		maskS: gearMask(maskBits + 1),
+		maskL: gearMask(maskBits - 1),
+	}, nil
+}
+
+// gearMask - use high bits, cause high bits of gear hash depend on the last 64 bytes
+func gearMask(maskBits int) uint64 {
+	return ((uint64(1) << maskBits) - 1) << (64 - maskBits)
+}
+
+// Next - return next chunk, io.EOF when reader is exhausted, returned slice is a copy and could be used after next call
+func (c *Chunker) Next() ([]byte, error) {
+	data, err := c.r.Peek(c.maxSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	n := c.cutPoint(data)
+	chunk := make([]byte, n)
+	copy(chunk, data[:n])
+	if _, err = c.r.Discard(n); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+func (c *Chunker) cutPoint(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	normalSize := c.avgSize
+	if n < normalSize {
+		normalSize = n
+	}
+	var fingerprint uint64
+	i := c.minSize
+	for ; i < normalSize; i++ {
+		fingerprint = (fingerprint << 1) + gear[data[i]]
+		if fingerprint&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fingerprint = (fingerprint << 1) + gear[data[i]]
+		if fingerprint&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// Hash - chunk content address
+func Hash(chunk []byte) string {
+	sum := sha256.Sum256(chunk)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,56 @@
+package dedup
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func chunkAll(t *testing.T, data []byte, avgSize int) [][]byte {
+	chunker, err := NewChunker(bytes.NewReader(data), avgSize)
+	require.NoError(t, err)
+	chunks := make([][]byte, 0)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestChunkerBoundariesSurviveInsert(t *testing.T) {
+	avgSize := 4096
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	chunks := chunkAll(t, data, avgSize)
+	require.Equal(t, data, bytes.Join(chunks, nil))
+	for i, chunk := range chunks {
+		require.LessOrEqual(t, len(chunk), avgSize*4)
+		if i < len(chunks)-1 {
+			require.GreaterOrEqual(t, len(chunk), avgSize/4)
+		}
+	}
+
+	modified := append([]byte("inserted bytes"), data...)
+	modifiedChunks := chunkAll(t, modified, avgSize)
+	require.Equal(t, modified, bytes.Join(modifiedChunks, nil))
+	hashes := make(map[string]struct{}, len(chunks))
+	for _, chunk := range chunks {
+		hashes[Hash(chunk)] = struct{}{}
+	}
+	sameChunks := 0
+	for _, chunk := range modifiedChunks {
+		if _, exists := hashes[Hash(chunk)]; exists {
+			sameChunks += 1
+		}
+	}
+	require.GreaterOrEqual(t, sameChunks, len(chunks)-2)
+
+	_, err := NewChunker(bytes.NewReader(data), 1000)
+	require.Error(t, err)
+}
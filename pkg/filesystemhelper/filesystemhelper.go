@@ -15,6 +15,14 @@ import (
 	"github.com/Altinity/clickhouse-backup/pkg/common"
 	"github.com/Altinity/clickhouse-backup/pkg/metadata"
 	apexLog "github.com/apex/log"
+	recursiveCopy "github.com/otiai10/copy"
+)
+
+// restore data modes, how backup part files are placed into table data or detached folder
+const (
+	RestoreDataModeHardlink = "hardlink"
+	RestoreDataModeMove     = "move"
+	RestoreDataModeCopy     = "copy"
 )
 
 var (
@@ -42,9 +50,7 @@ func Chown(path string, ch *clickhouse.ClickHouse, disks []clickhouse.Disk, recu
 		if err != nil {
 			return err
 		}
-		stat := info.Sys().(*syscall.Stat_t)
-		intUid := int(stat.Uid)
-		intGid := int(stat.Gid)
+		intUid, intGid := getFileOwner(info)
 		uid = &intUid
 		gid = &intGid
 	}
@@ -113,8 +119,9 @@ func MkdirAll(path string, ch *clickhouse.ClickHouse, disks []clickhouse.Disk) e
 	return nil
 }
 
-// HardlinkBackupPartsToStorage - copy partitions for specific table to detached folder
-func HardlinkBackupPartsToStorage(backupName string, backupTable metadata.TableMetadata, disks []clickhouse.Disk, tableDataPaths []string, ch *clickhouse.ClickHouse, toDetached bool) error {
+// HardlinkBackupPartsToStorage - copy partitions for specific table to detached folder,
+// restoreDataMode `hardlink` (default) and `move` require backup and table data on the same filesystem, `move` leaves local backup without data
+func HardlinkBackupPartsToStorage(backupName string, backupTable metadata.TableMetadata, disks []clickhouse.Disk, tableDataPaths []string, ch *clickhouse.ClickHouse, toDetached bool, restoreDataMode string) error {
 	log := apexLog.WithFields(apexLog.Fields{"operation": "HardlinkBackupPartsToStorage"})
 	start := time.Now()
 	dstDataPaths := clickhouse.GetDisksByPaths(disks, tableDataPaths)
@@ -132,6 +139,11 @@ func HardlinkBackupPartsToStorage(backupName string, backupTable metadata.TableM
 		if toDetached {
 			dstParentDir = filepath.Join(dstParentDir, "detached")
 		}
+		diskRestoreDataMode := restoreDataMode
+		// object disk parts will copy later from metadata files inside backup, so they can't be moved
+		if diskRestoreDataMode == RestoreDataModeMove && (backupDisk.Type == "s3" || backupDisk.Type == "azure_blob_storage") {
+			diskRestoreDataMode = RestoreDataModeHardlink
+		}
 		for _, part := range backupTable.Parts[backupDiskName] {
 			dstPartPath := filepath.Join(dstParentDir, part.Name)
 			info, err := os.Stat(dstPartPath)
@@ -156,7 +168,7 @@ func HardlinkBackupPartsToStorage(backupName string, backupTable metadata.TableM
 				if err != nil {
 					return err
 				}
-				filename := strings.Trim(strings.TrimPrefix(filePath, partPath), "/")
+				filename := RelativePath(partPath, filePath)
 				dstFilePath := filepath.Join(dstPartPath, filename)
 				if info.IsDir() {
 					log.Debugf("MkDir %s", dstFilePath)
@@ -166,11 +178,8 @@ func HardlinkBackupPartsToStorage(backupName string, backupTable metadata.TableM
 					log.Debugf("'%s' is not a regular file, skipping.", filePath)
 					return nil
 				}
-				log.Debugf("Link %s -> %s", filePath, dstFilePath)
-				if err := os.Link(filePath, dstFilePath); err != nil {
-					if !os.IsExist(err) {
-						return fmt.Errorf("failed to create hard link '%s' -> '%s': %w", filePath, dstFilePath, err)
-					}
+				if err := placeBackupFile(filePath, dstFilePath, diskRestoreDataMode, log); err != nil {
+					return err
 				}
 				return Chown(dstFilePath, ch, disks, false)
 			}); err != nil {
@@ -182,28 +191,78 @@ func HardlinkBackupPartsToStorage(backupName string, backupTable metadata.TableM
 	return nil
 }
 
+func placeBackupFile(filePath, dstFilePath, restoreDataMode string, log *apexLog.Entry) error {
+	switch restoreDataMode {
+	case RestoreDataModeMove:
+		log.Debugf("Move %s -> %s", filePath, dstFilePath)
+		if err := os.Rename(filePath, dstFilePath); err != nil {
+			return fmt.Errorf("failed to move '%s' -> '%s': %w", filePath, dstFilePath, err)
+		}
+	case RestoreDataModeCopy:
+		log.Debugf("Copy %s -> %s", filePath, dstFilePath)
+		if err := recursiveCopy.Copy(filePath, dstFilePath); err != nil {
+			return fmt.Errorf("failed to copy '%s' -> '%s': %w", filePath, dstFilePath, err)
+		}
+	default:
+		log.Debugf("Link %s -> %s", filePath, dstFilePath)
+		if err := os.Link(filePath, dstFilePath); err != nil {
+			if !os.IsExist(err) {
+				return fmt.Errorf("failed to create hard link '%s' -> '%s': %w", filePath, dstFilePath, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RelativePath - slash separated path of filePath inside basePath, filepath.Walk returns paths with OS separator, but relative paths are used for archive entries and remote keys
+func RelativePath(basePath, filePath string) string {
+	relativePath, err := filepath.Rel(basePath, filePath)
+	if err != nil {
+		return strings.Trim(strings.TrimPrefix(filepath.ToSlash(filePath), filepath.ToSlash(basePath)), "/")
+	}
+	if relativePath == "." {
+		return ""
+	}
+	return filepath.ToSlash(relativePath)
+}
+
 func IsPartInPartition(partName string, partitionsBackupMap common.EmptyMap) bool {
-	_, ok := partitionsBackupMap[strings.Split(partName, "_")[0]]
-	return ok
+	return IsPartitionIdMatched(strings.Split(partName, "_")[0], partitionsBackupMap)
 }
 
 func IsFileInPartition(disk, fileName string, partitionsBackupMap common.EmptyMap) bool {
 	fileName = strings.TrimPrefix(fileName, disk+"_")
-	_, ok := partitionsBackupMap[strings.Split(fileName, "_")[0]]
-	return ok
+	return IsPartitionIdMatched(strings.Split(fileName, "_")[0], partitionsBackupMap)
+}
+
+// IsPartitionIdMatched - partitionsBackupMap keys could be glob patterns like `2023-0[1-6]*`
+func IsPartitionIdMatched(partitionId string, partitionsBackupMap common.EmptyMap) bool {
+	if _, ok := partitionsBackupMap[partitionId]; ok {
+		return true
+	}
+	for pattern := range partitionsBackupMap {
+		if !strings.ContainsAny(pattern, "*?[") {
+			continue
+		}
+		if matched, _ := filepath.Match(pattern, partitionId); matched {
+			return true
+		}
+	}
+	return false
 }
 
 func MoveShadow(shadowPath, backupPartsPath string, partitionsBackupMap common.EmptyMap) ([]metadata.Part, int64, error) {
 	log := apexLog.WithField("logger", "MoveShadow")
 	size := int64(0)
 	parts := make([]metadata.Part, 0)
+	partIndex := make(map[string]int)
 	err := filepath.Walk(shadowPath, func(filePath string, info os.FileInfo, err error) error {
 		// possible relative path
 		// store / 1f9 / 1f9dc899-0de9-41f8-b95c-26c1f0d67d93 / 20181023_2_2_0 / checksums.txt
 		// store / 1f9 / 1f9dc899-0de9-41f8-b95c-26c1f0d67d93 / 20181023_2_2_0 / x.proj / checksums.txt
 		// data / database / table / 20181023_2_2_0 / checksums.txt
 		// data / database / table / 20181023_2_2_0 / x.proj / checksums.txt
-		relativePath := strings.Trim(strings.TrimPrefix(filePath, shadowPath), "/")
+		relativePath := RelativePath(shadowPath, filePath)
 		pathParts := strings.SplitN(relativePath, "/", 4)
 		if len(pathParts) != 4 {
 			return nil
@@ -214,6 +273,7 @@ func MoveShadow(shadowPath, backupPartsPath string, partitionsBackupMap common.E
 		dstFilePath := filepath.Join(backupPartsPath, pathParts[3])
 		if info.IsDir() {
 			if !strings.HasSuffix(pathParts[3], ".proj") {
+				partIndex[pathParts[3]] = len(parts)
 				parts = append(parts, metadata.Part{
 					Name: pathParts[3],
 				})
@@ -225,6 +285,10 @@ func MoveShadow(shadowPath, backupPartsPath string, partitionsBackupMap common.E
 			return nil
 		}
 		size += info.Size()
+		// files of projections are included into size of part
+		if i, exists := partIndex[strings.SplitN(pathParts[3], "/", 2)[0]]; exists {
+			parts[i].Size += info.Size()
+		}
 		return os.Rename(filePath, dstFilePath)
 	})
 	return parts, size, err
@@ -0,0 +1,43 @@
+package filesystemhelper
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlaceBackupFile(t *testing.T) {
+	log := apexLog.WithField("logger", "test")
+	for _, restoreDataMode := range []string{RestoreDataModeHardlink, RestoreDataModeMove, RestoreDataModeCopy} {
+		t.Run(restoreDataMode, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := path.Join(dir, "data.bin")
+			dstFilePath := path.Join(dir, "detached_data.bin")
+			require.NoError(t, os.WriteFile(filePath, []byte("part data"), 0640))
+			require.NoError(t, placeBackupFile(filePath, dstFilePath, restoreDataMode, log))
+
+			body, err := os.ReadFile(dstFilePath)
+			require.NoError(t, err)
+			assert.Equal(t, "part data", string(body))
+			srcInfo, srcErr := os.Stat(filePath)
+			if restoreDataMode == RestoreDataModeMove {
+				assert.True(t, os.IsNotExist(srcErr), "moved file shall be removed from backup")
+				return
+			}
+			require.NoError(t, srcErr)
+			dstInfo, err := os.Stat(dstFilePath)
+			require.NoError(t, err)
+			assert.Equal(t, restoreDataMode == RestoreDataModeHardlink, os.SameFile(srcInfo, dstInfo))
+			if restoreDataMode == RestoreDataModeHardlink {
+				// hardlink already created by previous attempt
+				assert.NoError(t, placeBackupFile(filePath, dstFilePath, restoreDataMode, log))
+			}
+		})
+	}
+	assert.ErrorContains(t, placeBackupFile(path.Join(t.TempDir(), "absent.bin"), path.Join(t.TempDir(), "data.bin"), RestoreDataModeMove, log), "failed to move")
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package filesystemhelper
+
+import (
+	"os"
+	"syscall"
+)
+
+func getFileOwner(info os.FileInfo) (int, int) {
+	stat := info.Sys().(*syscall.Stat_t)
+	return int(stat.Uid), int(stat.Gid)
+}
+
+// GetFileLinks - count of hardlinks, frozen file with one link doesn't share disk space with active part anymore
+func GetFileLinks(info os.FileInfo) uint64 {
+	return uint64(info.Sys().(*syscall.Stat_t).Nlink)
+}
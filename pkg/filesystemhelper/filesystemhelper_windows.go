@@ -0,0 +1,17 @@
+//go:build windows
+
+package filesystemhelper
+
+import (
+	"os"
+)
+
+// getFileOwner - NTFS doesn't have uid and gid, os.Getuid returns -1 on Windows, so Chown never calls it
+func getFileOwner(os.FileInfo) (int, int) {
+	return -1, -1
+}
+
+// GetFileLinks - hardlinks count is not available from os.FileInfo on Windows, each file counted as not shared
+func GetFileLinks(os.FileInfo) uint64 {
+	return 1
+}
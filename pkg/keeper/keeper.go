@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"github.com/antchfx/xmlquery"
@@ -122,9 +123,7 @@ func (k *Keeper) Dump(prefix, dumpFile string) (int, error) {
 			k.Log.Warnf("can't close %s: %v", dumpFile, err)
 		}
 	}()
-	if !strings.HasPrefix(prefix, "/") && k.root != "" {
-		prefix = path.Join(k.root, prefix)
-	}
+	prefix = k.absolutePath(prefix)
 	bytes, err := k.dumpNodeRecursive(prefix, "", f)
 	if err != nil {
 		return 0, fmt.Errorf("dumpNodeRecursive(%s) return error: %v", prefix, err)
@@ -133,10 +132,14 @@ func (k *Keeper) Dump(prefix, dumpFile string) (int, error) {
 }
 
 func (k *Keeper) dumpNodeRecursive(prefix, nodePath string, f *os.File) (int, error) {
-	value, _, err := k.conn.Get(path.Join(prefix, nodePath))
+	value, stat, err := k.conn.Get(path.Join(prefix, nodePath))
 	if err != nil {
 		return 0, err
 	}
+	// ephemeral nodes, like replica is_active, belong to alive sessions and shall not restore as persistent
+	if stat.EphemeralOwner != 0 {
+		return 0, nil
+	}
 	bytes, err := k.writeJsonString(f, keeperDumpNode{Path: nodePath, Value: string(value)})
 	if err != nil {
 		return 0, err
@@ -169,23 +172,48 @@ func (k *Keeper) writeJsonString(f *os.File, node keeperDumpNode) (int, error) {
 }
 
 func (k *Keeper) Restore(dumpFile, prefix string) error {
+	_, err := k.restore(dumpFile, prefix, true, nil)
+	return err
+}
+
+// RestoreMissing - create only znodes which not exists, values of existing znodes stay untouched, return count of created znodes
+func (k *Keeper) RestoreMissing(dumpFile, prefix string) (int, error) {
+	return k.restore(dumpFile, prefix, false, nil)
+}
+
+// RestoreMissingExceptReplicas - the same as RestoreMissing for zookeeper_path of Replicated table, but skip `replicas/<replica>` subtrees,
+// replicas are registered by CREATE TABLE, restored replica znodes lead to REPLICA_ALREADY_EXISTS or phantom parts
+func (k *Keeper) RestoreMissingExceptReplicas(dumpFile, prefix string) (int, error) {
+	return k.restore(dumpFile, prefix, false, func(nodePath string) bool {
+		return strings.HasPrefix(nodePath, "replicas/")
+	})
+}
+
+func (k *Keeper) restore(dumpFile, prefix string, overwrite bool, skipNode func(nodePath string) bool) (int, error) {
 	f, err := os.Open(dumpFile)
 	if err != nil {
-		return fmt.Errorf("can't open %s: %v", dumpFile, err)
+		return 0, fmt.Errorf("can't open %s: %v", dumpFile, err)
 	}
 	defer func() {
 		if err = f.Close(); err != nil {
 			k.Log.Warnf("can't close %s: %v", dumpFile, err)
 		}
 	}()
-	if !strings.HasPrefix(prefix, "/") && k.root != "" {
-		prefix = path.Join(k.root, prefix)
+	prefix = k.absolutePath(prefix)
+	if err = k.createParents(prefix); err != nil {
+		return 0, err
 	}
+	created := 0
 	scanner := bufio.NewScanner(f)
+	// znode value could be up to 1Mb
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
 	for scanner.Scan() {
 		node := keeperDumpNode{}
 		if err = json.Unmarshal(scanner.Bytes(), &node); err != nil {
-			return err
+			return created, err
+		}
+		if skipNode != nil && skipNode(strings.TrimPrefix(node.Path, "/")) {
+			continue
 		}
 		node.Path = path.Join(prefix, node.Path)
 		version := int32(0)
@@ -193,20 +221,45 @@ func (k *Keeper) Restore(dumpFile, prefix string) error {
 		if err != nil {
 			_, err = k.conn.Create(node.Path, []byte(node.Value), 0, zk.WorldACL(zk.PermAll))
 			if err != nil {
-				return fmt.Errorf("can't create znode %s, error: %v", node.Path, err)
+				return created, fmt.Errorf("can't create znode %s, error: %v", node.Path, err)
 			}
-		} else {
+			created += 1
+		} else if overwrite {
 			version = stat.Version
 			_, err = k.conn.Set(node.Path, []byte(node.Value), version)
 		}
 	}
 
 	if err = scanner.Err(); err != nil {
-		return fmt.Errorf("can't scan %s, error: %s", dumpFile, err)
+		return created, fmt.Errorf("can't scan %s, error: %s", dumpFile, err)
+	}
+	return created, nil
+}
+
+// createParents - create empty parent znodes for nodePath, fresh keeper doesn't contain it
+func (k *Keeper) createParents(nodePath string) error {
+	parentPath := ""
+	parts := strings.Split(strings.Trim(path.Dir(nodePath), "/"), "/")
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		parentPath = parentPath + "/" + part
+		if _, err := k.conn.Create(parentPath, []byte{}, 0, zk.WorldACL(zk.PermAll)); err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return fmt.Errorf("can't create znode %s, error: %v", parentPath, err)
+		}
 	}
 	return nil
 }
 
+// absolutePath - relative path prefixed with <zookeeper><root>
+func (k *Keeper) absolutePath(nodePath string) string {
+	if strings.HasPrefix(nodePath, "/") {
+		return nodePath
+	}
+	return path.Join("/", k.root, nodePath)
+}
+
 func (k *Keeper) Close() {
 	k.conn.Close()
 }
@@ -3,12 +3,22 @@ package logcli
 
 import (
 	"fmt"
+	"github.com/Altinity/clickhouse-backup/pkg/logjson"
 	"github.com/apex/log"
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
+// jsonFormat - general->log_format: json, global like log level, because config is reloaded after handler already wrapped, see status.NewLogHandler
+var jsonFormat atomic.Bool
+
+// SetFormat - `text` or `json`
+func SetFormat(format string) {
+	jsonFormat.Store(format == "json")
+}
+
 // Strings mapping.
 var Strings = [...]string{
 	log.DebugLevel: "debug",
@@ -23,6 +33,7 @@ type Handler struct {
 	mu      sync.Mutex
 	Writer  io.Writer
 	Padding int
+	json    *logjson.Handler
 }
 
 // New handler.
@@ -31,17 +42,22 @@ func New(w io.Writer) *Handler {
 		return &Handler{
 			Writer:  f,
 			Padding: 3,
+			json:    logjson.New(f),
 		}
 	}
 
 	return &Handler{
 		Writer:  w,
 		Padding: 3,
+		json:    logjson.New(w),
 	}
 }
 
 // HandleLog implements log.Handler.
 func (h *Handler) HandleLog(e *log.Entry) error {
+	if jsonFormat.Load() {
+		return h.json.HandleLog(e)
+	}
 	level := Strings[e.Level]
 	names := e.Fields.Names()
 
@@ -0,0 +1,65 @@
+// Package logjson implements a handler which writes each log record as one JSON object per line.
+package logjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// fieldAliases - fields which historically have different names in different places, written with one name to simplify queries in log aggregators
+var fieldAliases = map[string]string{
+	"backup":     "backup_name",
+	"backupName": "backup_name",
+}
+
+// Handler implementation.
+type Handler struct {
+	mu     sync.Mutex
+	Writer io.Writer
+}
+
+// New handler.
+func New(w io.Writer) *Handler {
+	return &Handler{
+		Writer: w,
+	}
+}
+
+// HandleLog implements log.Handler, fields are written on top level, `ts`, `level` and `msg` can't be overridden by fields
+func (h *Handler) HandleLog(e *log.Entry) error {
+	record := make(map[string]interface{}, len(e.Fields)+3)
+	for name, value := range e.Fields {
+		if alias, exists := fieldAliases[name]; exists {
+			name = alias
+		}
+		// error doesn't have exported fields and marshal to {}
+		if err, isError := value.(error); isError {
+			value = err.Error()
+		}
+		record[name] = value
+	}
+	record["ts"] = e.Timestamp.Format(time.RFC3339Nano)
+	record["level"] = e.Level.String()
+	record["msg"] = e.Message
+	body, err := json.Marshal(record)
+	if err != nil {
+		// field value which can't marshal shall not lose whole record
+		for name, value := range record {
+			record[name] = fmt.Sprint(value)
+		}
+		if body, err = json.Marshal(record); err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err = h.Writer.Write(append(body, '\n'))
+	return err
+}
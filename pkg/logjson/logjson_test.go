@@ -0,0 +1,33 @@
+package logjson_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Altinity/clickhouse-backup/pkg/logjson"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apex/log"
+)
+
+func init() {
+	log.Now = func() time.Time {
+		return time.Unix(0, 0).UTC()
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	log.SetHandler(logjson.New(&buf))
+	log.WithField("backup", "b1").WithField("bytes", 123).Info("hello")
+	log.WithField("msg", "override").WithError(fmt.Errorf("boom")).Error("world")
+
+	expected := `{"backup_name":"b1","bytes":123,"level":"info","msg":"hello","ts":"1970-01-01T00:00:00Z"}
+{"error":"boom","level":"error","msg":"world","ts":"1970-01-01T00:00:00Z"}
+`
+
+	assert.Equal(t, expected, buf.String())
+}
@@ -0,0 +1,12 @@
+package metadata
+
+// PartChunks - manifest for one uploaded part when `general->dedup_store: true`, file content stored as content addressed chunks in shared remote prefix
+type PartChunks struct {
+	Files []ChunkedFile `json:"files"`
+}
+
+type ChunkedFile struct {
+	Name   string   `json:"name"` // "part_name/file_name"
+	Size   int64    `json:"size"`
+	Chunks []string `json:"chunks"` // sha256 of each chunk, in file order
+}
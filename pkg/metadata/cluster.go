@@ -0,0 +1,24 @@
+package metadata
+
+import (
+	"time"
+)
+
+// ClusterBackupMetadata - manifest of `create_remote --on-cluster`, each shard has own backup with name `<backup_name>-shard<shard_num>`
+type ClusterBackupMetadata struct {
+	BackupName   string               `json:"backup_name"`
+	Cluster      string               `json:"cluster"`
+	CreationDate time.Time            `json:"creation_date"`
+	Shards       []ClusterShardBackup `json:"shards"`
+}
+
+type ClusterShardBackup struct {
+	ShardNum   uint32 `json:"shard_num"`
+	ReplicaNum uint32 `json:"replica_num"`
+	Host       string `json:"host"`
+	BackupName string `json:"backup_name"`
+	Start      string `json:"start,omitempty"`
+	Finish     string `json:"finish,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
@@ -0,0 +1,51 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ManifestVersion - version of metadata.json format, increment it only when meaning of existing fields changes, new optional fields don't require new version
+// 1 - backups created before `manifest_version` field was introduced
+// 2 - `manifest_version`, `incremental_chain`, `compression`, `encryption` and `checksum` for each part in table metadata
+const ManifestVersion = 2
+
+// ErrUnsupportedManifestVersion - metadata.json created by newer clickhouse-backup, fields could have other meaning, so backup can't be restored safely
+var ErrUnsupportedManifestVersion = errors.New("unsupported manifest_version")
+
+// CompressionMetadata - parameters which required to decompress archives of backup, filled during `upload`
+type CompressionMetadata struct {
+	Format        string `json:"format"`
+	Level         int    `json:"level,omitempty"`
+	ZstdWindowLog int    `json:"zstd_window_log,omitempty"`
+}
+
+// EncryptionMetadata - server side encryption of remote objects, KeyID contains only key identifier, never key material
+type EncryptionMetadata struct {
+	Type  string `json:"type"` // sse-s3, sse-kms, sse-c, gcs-cmek, gcs-csek
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// ParseBackupMetadata - unmarshal metadata.json of any supported manifest version, fields which absent in old versions filled from fields which contain the same information
+func ParseBackupMetadata(body []byte) (*BackupMetadata, error) {
+	backupMetadata := &BackupMetadata{}
+	if err := json.Unmarshal(body, backupMetadata); err != nil {
+		return nil, err
+	}
+	if backupMetadata.ManifestVersion > ManifestVersion {
+		return nil, fmt.Errorf("%w %d in %s, maximum supported is %d, upgrade clickhouse-backup", ErrUnsupportedManifestVersion, backupMetadata.ManifestVersion, backupMetadata.BackupName, ManifestVersion)
+	}
+	if backupMetadata.ManifestVersion == 0 {
+		backupMetadata.ManifestVersion = 1
+	}
+	if backupMetadata.ManifestVersion < 2 {
+		if backupMetadata.RequiredBackup != "" && len(backupMetadata.IncrementalChain) == 0 {
+			backupMetadata.IncrementalChain = []string{backupMetadata.RequiredBackup}
+		}
+		if backupMetadata.Compression == nil && backupMetadata.DataFormat != "" && backupMetadata.DataFormat != "directory" && backupMetadata.DataFormat != "chunks" {
+			backupMetadata.Compression = &CompressionMetadata{Format: backupMetadata.DataFormat}
+		}
+	}
+	return backupMetadata, nil
+}
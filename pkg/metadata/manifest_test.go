@@ -0,0 +1,26 @@
+package metadata
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBackupMetadata(t *testing.T) {
+	backupMetadata, err := ParseBackupMetadata([]byte(`{"backup_name":"increment","required_backup":"full","data_format":"zstd","tables":[]}`))
+	require.NoError(t, err)
+	assert.Equal(t, 1, backupMetadata.ManifestVersion)
+	assert.Equal(t, []string{"full"}, backupMetadata.IncrementalChain)
+	assert.Equal(t, &CompressionMetadata{Format: "zstd"}, backupMetadata.Compression)
+
+	backupMetadata, err = ParseBackupMetadata([]byte(`{"manifest_version":2,"backup_name":"increment","required_backup":"increment1","incremental_chain":["increment1","full"],"data_format":"directory"}`))
+	require.NoError(t, err)
+	assert.Equal(t, 2, backupMetadata.ManifestVersion)
+	assert.Equal(t, []string{"increment1", "full"}, backupMetadata.IncrementalChain)
+	assert.Nil(t, backupMetadata.Compression)
+
+	_, err = ParseBackupMetadata([]byte(`{"manifest_version":100,"backup_name":"future"}`))
+	assert.True(t, errors.Is(err, ErrUnsupportedManifestVersion))
+}
@@ -9,7 +9,9 @@ type TableTitle struct {
 	Table    string `json:"table"`
 }
 
+// BackupMetadata - content of metadata.json in backup root, format described in ReadMe.md "Backup manifest format", see ManifestVersion
 type BackupMetadata struct {
+	ManifestVersion         int               `json:"manifest_version,omitempty"`
 	BackupName              string            `json:"backup_name"`
 	Disks                   map[string]string `json:"disks"`      // "default": "/var/lib/clickhouse"
 	DiskTypes               map[string]string `json:"disk_types"` // "default": "local"
@@ -21,12 +23,37 @@ type BackupMetadata struct {
 	MetadataSize            uint64            `json:"metadata_size"`
 	RBACSize                uint64            `json:"rbac_size,omitempty"`
 	ConfigSize              uint64            `json:"config_size,omitempty"`
+	KeeperSize              uint64            `json:"keeper_size,omitempty"`
 	CompressedSize          uint64            `json:"compressed_size,omitempty"`
 	Databases               []DatabasesMeta   `json:"databases,omitempty"`
 	Tables                  []TableTitle      `json:"tables"`
+	SkippedTables           []TableTitle      `json:"skipped_tables,omitempty"` // tables which dropped during create
 	Functions               []FunctionsMeta   `json:"functions"`
 	DataFormat              string            `json:"data_format"`
 	RequiredBackup          string            `json:"required_backup,omitempty"`
+	SegmentSize             int64             `json:"segment_size,omitempty"`        // objects bigger than segment size uploaded as several segments
+	ZstdDictionary          string            `json:"zstd_dictionary,omitempty"`     // file name inside backup with dictionary used for small zstd archives
+	Destinations            []UploadStatus    `json:"upload_destinations,omitempty"` // filled only in local metadata.json when `upload_mirrors` defined
+	PartsCount              int64             `json:"parts_count,omitempty"`
+	UploadDurationSeconds   float64           `json:"upload_duration_seconds,omitempty"` // filled only in remote metadata.json
+	Macros                  map[string]string `json:"macros,omitempty"`                  // system.macros of source server, used to rewrite Replicated engine paths during restore on other server
+	// IncrementalChain - RequiredBackup and all its required backups, nearest parent first, filled during `upload`
+	IncrementalChain []string             `json:"incremental_chain,omitempty"`
+	Compression      *CompressionMetadata `json:"compression,omitempty"`
+	Encryption       *EncryptionMetadata  `json:"encryption,omitempty"`
+	// RemotePathPrefixes - path_prefix of general->table_storage_rules which used during `upload`, data of such tables stored in `<prefix>/<backup_name>/shadow`
+	RemotePathPrefixes []string `json:"remote_path_prefixes,omitempty"`
+	// DataMoved - filled only in local metadata.json, when `restore --restore-data-mode=move` started to move data parts into tables, such backup can't be uploaded or restored again
+	DataMoved bool `json:"data_moved,omitempty"`
+}
+
+// UploadStatus - result of `upload` to general->remote_storage or to one of `upload_mirrors`
+type UploadStatus struct {
+	Name          string    `json:"name"`
+	RemoteStorage string    `json:"remote_storage"`
+	Status        string    `json:"status"` // success, error
+	Error         string    `json:"error,omitempty"`
+	UploadDate    time.Time `json:"upload_date"`
 }
 
 type DatabasesMeta struct {
@@ -46,23 +73,69 @@ type TableMetadata struct {
 	Database             string              `json:"database"`
 	Parts                map[string][]Part   `json:"parts"`
 	Query                string              `json:"query"`
+	UUID                 string              `json:"uuid,omitempty"`        // system.tables.uuid, used for restore --preserve-uuid
 	Size                 map[string]int64    `json:"size"`                  // how much size on each disk
 	TotalBytes           uint64              `json:"total_bytes,omitempty"` // total table size
 	DependenciesTable    string              `json:"dependencies_table,omitempty"`
 	DependenciesDatabase string              `json:"dependencies_database,omitempty"`
 	Mutations            []MutationMetadata  `json:"mutations,omitempty"`
 	MetadataOnly         bool                `json:"metadata_only"`
+	// ReplicationLogPointer - system.replicas.log_pointer for Replicated*MergeTree before FREEZE
+	ReplicationLogPointer uint64 `json:"replication_log_pointer,omitempty"`
+	// FreezeTime - UTC time before FREEZE of table data, `restore --to-timestamp` replays rows from this time, nil for backups without data
+	FreezeTime *time.Time `json:"freeze_time,omitempty"`
+	// ColumnCodecs - system.columns.compression_codec for each column at backup time, empty value means default codec
+	ColumnCodecs map[string]string `json:"column_codecs,omitempty"`
+	// EngineData - not nil for Log family, File and EmbeddedRocksDB tables, which data copied from table data path as is
+	EngineData *EngineDataMetadata `json:"engine_data,omitempty"`
+	// PartHashAlgorithm - general->part_hash_algorithm during `create`, algorithm of Part.Hash
+	PartHashAlgorithm string `json:"part_hash_algorithm,omitempty"`
+	// RemotePathPrefix - filled during `upload` when table matched general->table_storage_rules with path_prefix
+	RemotePathPrefix string `json:"remote_path_prefix,omitempty"`
+	// DataFingerprint - aggregated active parts from system.parts before FREEZE, nil for tables without parts
+	DataFingerprint *DataFingerprint `json:"data_fingerprint,omitempty"`
+	// UnchangedFrom - local backup from `create --skip-unchanged-from` which parts were hardlinked without FREEZE, cause DataFingerprint was the same
+	UnchangedFrom string `json:"unchanged_from,omitempty"`
+}
+
+// DataFingerprint - when parts count, rows, bytes, latest part modification time and max data version of active parts are the same, table data didn't change,
+// INSERT and merges create new parts, mutations and lightweight deletes increase data_version
+type DataFingerprint struct {
+	Parts               uint64    `json:"parts" ch:"parts"`
+	Rows                uint64    `json:"rows" ch:"rows"`
+	Bytes               uint64    `json:"bytes" ch:"bytes"`
+	MaxModificationTime time.Time `json:"max_modification_time" ch:"max_modification_time"`
+	MaxDataVersion      uint64    `json:"max_data_version" ch:"max_data_version"`
+}
+
+func (f DataFingerprint) Equal(other DataFingerprint) bool {
+	return f.Parts == other.Parts && f.Rows == other.Rows && f.Bytes == other.Bytes && f.MaxModificationTime.Equal(other.MaxModificationTime) && f.MaxDataVersion == other.MaxDataVersion
+}
+
+// EngineDataPartPrefix - pseudo part in Parts which contains copy of table data path for EngineData tables,
+// name is unique for each backup, so incremental backup will never reuse it, files inside the same pseudo part could change between backups
+const EngineDataPartPrefix = "engine_data_"
+
+// EngineDataMetadata - how to put data of non MergeTree tables back, restore executes DETACH TABLE, copy files of pseudo part into table data path and ATTACH TABLE
+type EngineDataMetadata struct {
+	Engine string `json:"engine"`
+	Part   string `json:"part"`
 }
 
 type MutationMetadata struct {
 	MutationId string `json:"mutation_id" ch:"mutation_id"`
 	Command    string `json:"command" ch:"command"`
+	// PartitionIds and BlockNumbers - mutation version for each partition, parts with data version less than block number are not mutated yet
+	PartitionIds []string `json:"partition_ids,omitempty" ch:"partition_ids"`
+	BlockNumbers []int64  `json:"block_numbers,omitempty" ch:"block_numbers"`
 }
 
 type Part struct {
-	Name      string `json:"name"`
-	Required  bool   `json:"required,omitempty"`
-	Partition string `json:"partition,omitempty"`
+	Name     string `json:"name"`
+	Required bool   `json:"required,omitempty"`
+	// RequiredBackup - name of backup in incremental chain which owns part data, when Required is true
+	RequiredBackup string `json:"required_backup,omitempty"`
+	Partition      string `json:"partition,omitempty"`
 	// Path                              string    `json:"path"`              // TODO: make it relative? look like useless now, can be calculated from Name
 	HashOfAllFiles                    string     `json:"hash_of_all_files,omitempty"` // ???
 	HashOfUncompressedFiles           string     `json:"hash_of_uncompressed_files,omitempty"`
@@ -70,6 +143,14 @@ type Part struct {
 	PartitionID                       string     `json:"partition_id,omitempty"`
 	ModificationTime                  *time.Time `json:"modification_time,omitempty"`
 	Size                              int64      `json:"size,omitempty"`
+	// Checksum - CRC64 of part checksums.txt, which contains checksums of all part files, empty for object disk parts
+	Checksum string `json:"checksum,omitempty"`
+	// Hash - hash of content of all part files with TableMetadata.PartHashAlgorithm, empty for object disk parts
+	Hash string `json:"hash,omitempty"`
+	// Projections - projection name -> CRC64 of `<name>.proj/checksums.txt` inside part
+	Projections map[string]string `json:"projections,omitempty"`
+	// LightweightDelete - part contains `_row_exists` mask after `DELETE FROM`
+	LightweightDelete bool `json:"lightweight_delete,omitempty"`
 	// bytes_on_disk, data_compressed_bytes, data_uncompressed_bytes
 }
 
@@ -12,6 +12,7 @@ func (tm *TableMetadata) Save(location string, metadataOnly bool) (uint64, error
 		Table:                tm.Table,
 		Database:             tm.Database,
 		Query:                tm.Query,
+		UUID:                 tm.UUID,
 		DependenciesTable:    tm.DependenciesTable,
 		DependenciesDatabase: tm.DependenciesDatabase,
 		MetadataOnly:         true,
@@ -22,6 +23,12 @@ func (tm *TableMetadata) Save(location string, metadataOnly bool) (uint64, error
 		newTM.Parts = tm.Parts
 		newTM.Size = tm.Size
 		newTM.TotalBytes = tm.TotalBytes
+		newTM.Mutations = tm.Mutations
+		newTM.ReplicationLogPointer = tm.ReplicationLogPointer
+		newTM.FreezeTime = tm.FreezeTime
+		newTM.ColumnCodecs = tm.ColumnCodecs
+		newTM.PartHashAlgorithm = tm.PartHashAlgorithm
+		newTM.DataFingerprint = tm.DataFingerprint
 		newTM.MetadataOnly = false
 	}
 	if err := os.MkdirAll(path.Dir(location), 0750); err != nil {
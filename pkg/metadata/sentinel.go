@@ -0,0 +1,80 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc64"
+	"os"
+	"time"
+)
+
+// sentinelTrailerPrefix - last line of sentinel file contains length and crc64 of JSON body, so truncated or edited sentinel is detected before its content is trusted
+const sentinelTrailerPrefix = "#sentinel "
+
+var sentinelCRC64Table = crc64.MakeTable(crc64.ECMA)
+
+// BackupSentinel - integrity manifest for one `shadow/db/table/disk` directory, written during `create`, checked during `verify`
+type BackupSentinel struct {
+	CreationDate time.Time               `json:"creation_date"`
+	Files        map[string]SentinelFile `json:"files"`              // "part_name/file_name": size and checksum
+	Archives     map[string]int64        `json:"archives,omitempty"` // archive name: remote object size, filled during `upload`
+}
+
+type SentinelFile struct {
+	Size  int64  `json:"size"`
+	CRC64 string `json:"crc64"`
+}
+
+func (s *BackupSentinel) Load(location string) error {
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return err
+	}
+	return s.Unmarshal(data, location)
+}
+
+// Unmarshal - check trailer and parse JSON body, location used only in error messages
+func (s *BackupSentinel) Unmarshal(data []byte, location string) error {
+	trailerStart := bytes.LastIndex(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	if trailerStart < 0 || !bytes.HasPrefix(data[trailerStart+1:], []byte(sentinelTrailerPrefix)) {
+		return fmt.Errorf("%s: sentinel trailer not found, file truncated or changed", location)
+	}
+	body := data[:trailerStart]
+	var length int
+	var checksum string
+	if _, err := fmt.Sscanf(string(data[trailerStart+1:]), sentinelTrailerPrefix+"length=%d crc64=%s", &length, &checksum); err != nil {
+		return fmt.Errorf("%s: can't parse sentinel trailer: %v", location, err)
+	}
+	if length != len(body) {
+		return fmt.Errorf("%s: sentinel length %d, expected %d, file truncated or changed", location, len(body), length)
+	}
+	if actual := fmt.Sprintf("%016x", crc64.Checksum(body, sentinelCRC64Table)); actual != checksum {
+		return fmt.Errorf("%s: sentinel crc64 %s, expected %s, file changed", location, actual, checksum)
+	}
+	if err := json.Unmarshal(body, s); err != nil {
+		return fmt.Errorf("can't unmarshal %s: %v", location, err)
+	}
+	return nil
+}
+
+// Marshal - JSON body and trailer line with body length and crc64
+func (s *BackupSentinel) Marshal() ([]byte, error) {
+	body, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return nil, fmt.Errorf("can't marshall backup sentinel: %v", err)
+	}
+	trailer := fmt.Sprintf("\n%slength=%d crc64=%016x\n", sentinelTrailerPrefix, len(body), crc64.Checksum(body, sentinelCRC64Table))
+	return append(body, trailer...), nil
+}
+
+func (s *BackupSentinel) Save(location string) error {
+	body, err := s.Marshal()
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(location, body, 0640); err != nil {
+		return fmt.Errorf("can't save backup sentinel: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package metadata
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupSentinelTrailer(t *testing.T) {
+	sentinel := BackupSentinel{
+		CreationDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Files:        map[string]SentinelFile{"all_1_1_0/data.bin": {Size: 100, CRC64: "00000000000000ff"}},
+		Archives:     map[string]int64{"default_all_1_1_0.tar.zstd": 42},
+	}
+	body, err := sentinel.Marshal()
+	require.NoError(t, err)
+
+	loaded := BackupSentinel{}
+	require.NoError(t, loaded.Unmarshal(body, "default.sentinel.json"))
+	assert.Equal(t, sentinel, loaded)
+
+	assert.ErrorContains(t, loaded.Unmarshal(body[:len(body)/2], "default.sentinel.json"), "trailer not found")
+	trailerStart := bytes.LastIndex(bytes.TrimSuffix(body, []byte("\n")), []byte("\n"))
+	truncated := append(append([]byte{}, body[:trailerStart-2]...), body[trailerStart:]...)
+	assert.ErrorContains(t, loaded.Unmarshal(truncated, "default.sentinel.json"), "file truncated or changed")
+	changed := bytes.Replace(body, []byte(`"size": 100`), []byte(`"size": 101`), 1)
+	assert.ErrorContains(t, loaded.Unmarshal(changed, "default.sentinel.json"), "file changed")
+	unsigned := body[:trailerStart]
+	assert.ErrorContains(t, loaded.Unmarshal(unsigned, "default.sentinel.json"), "trailer not found")
+}
@@ -0,0 +1,273 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/eapache/go-resiliency/retrier"
+)
+
+const (
+	EventStart           = "start"
+	EventSuccess         = "success"
+	EventFailure         = "failure"
+	EventRetentionDelete = "retention_delete"
+	// EventRestoreVerifyMismatch - general->restore_verify_codecs found different codecs or size of restored table
+	EventRestoreVerifyMismatch = "restore_verify_mismatch"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// Event - JSON payload for `notifications->webhook_url`, and data for `notifications->message_template` and `notifications->webhook_template`
+type Event struct {
+	Event     string `json:"event"`
+	Operation string `json:"operation"`
+	Backup    string `json:"backup,omitempty"`
+	Location  string `json:"location,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Duration  string `json:"duration,omitempty"`
+	Hostname  string `json:"hostname"`
+	Time      string `json:"time"`
+	Message   string `json:"message"`
+}
+
+// Notifier - send Event to all configured channels, errors are only logged and never fail backup operation
+type Notifier struct {
+	cfg      config.NotificationsConfig
+	events   map[string]struct{}
+	hostname string
+	client   *http.Client
+	timeout  time.Duration
+	log      *apexLog.Entry
+}
+
+func New(cfg *config.Config) *Notifier {
+	events := make(map[string]struct{}, len(cfg.Notifications.Events))
+	for _, event := range cfg.Notifications.Events {
+		events[event] = struct{}{}
+	}
+	hostname, _ := os.Hostname()
+	timeout, _ := time.ParseDuration(cfg.Notifications.Timeout)
+	return &Notifier{
+		cfg:      cfg.Notifications,
+		events:   events,
+		hostname: hostname,
+		client:   &http.Client{Timeout: timeout},
+		timeout:  timeout,
+		log:      apexLog.WithField("logger", "notify"),
+	}
+}
+
+// IsEnabled - at least one channel is configured
+func (n *Notifier) IsEnabled() bool {
+	return n != nil && (n.cfg.WebhookURL != "" || n.cfg.SlackWebhookURL != "" || n.cfg.SMTPHost != "" || n.cfg.PagerDutyRoutingKey != "")
+}
+
+// Send - fill hostname, time and message, and send event to each channel with notifications->retries
+func (n *Notifier) Send(ctx context.Context, event Event) {
+	if !n.IsEnabled() {
+		return
+	}
+	if _, exists := n.events[event.Event]; !exists {
+		return
+	}
+	event.Hostname = n.hostname
+	event.Time = time.Now().Format(time.RFC3339)
+	message, err := renderTemplate(n.cfg.MessageTemplate, event)
+	if err != nil {
+		n.log.Warnf("can't render message_template: %v", err)
+		message = fmt.Sprintf("%s %s %s", event.Operation, event.Backup, event.Event)
+	}
+	event.Message = message
+	channels := map[string]func(context.Context, Event) error{}
+	if n.cfg.WebhookURL != "" {
+		channels["webhook"] = n.sendWebhook
+	}
+	if n.cfg.SlackWebhookURL != "" {
+		channels["slack"] = n.sendSlack
+	}
+	if n.cfg.SMTPHost != "" {
+		channels["smtp"] = n.sendSMTP
+	}
+	// PagerDuty incident opens on failure and resolves on next success of the same operation
+	if n.cfg.PagerDutyRoutingKey != "" && (event.Event == EventFailure || event.Event == EventSuccess) {
+		channels["pagerduty"] = n.sendPagerDuty
+	}
+	pause, _ := time.ParseDuration(n.cfg.RetriesPause)
+	for channel, send := range channels {
+		retry := retrier.New(retrier.ConstantBackoff(n.cfg.Retries, pause), nil)
+		if err = retry.RunCtx(ctx, func(ctx context.Context) error {
+			return send(ctx, event)
+		}); err != nil {
+			n.log.WithFields(apexLog.Fields{
+				"channel":   channel,
+				"event":     event.Event,
+				"operation": event.Operation,
+			}).Warnf("can't send notification: %v", err)
+		}
+	}
+}
+
+func renderTemplate(text string, event Event) (string, error) {
+	tmpl, err := template.New("notification").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, event); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (n *Notifier) postJSON(ctx context.Context, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			n.log.Warnf("can't close response body: %v", err)
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("POST %s return status code %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// sendWebhook - JSON of Event, or rendered notifications->webhook_template when it is not empty
+func (n *Notifier) sendWebhook(ctx context.Context, event Event) error {
+	var body []byte
+	if n.cfg.WebhookTemplate != "" {
+		rendered, err := renderTemplate(n.cfg.WebhookTemplate, event)
+		if err != nil {
+			return fmt.Errorf("can't render webhook_template: %v", err)
+		}
+		body = []byte(rendered)
+	} else {
+		var err error
+		if body, err = json.Marshal(event); err != nil {
+			return err
+		}
+	}
+	return n.postJSON(ctx, n.cfg.WebhookURL, body, n.cfg.WebhookHeaders)
+}
+
+func (n *Notifier) sendSlack(ctx context.Context, event Event) error {
+	body, err := json.Marshal(map[string]string{"text": event.Message})
+	if err != nil {
+		return err
+	}
+	return n.postJSON(ctx, n.cfg.SlackWebhookURL, body, nil)
+}
+
+func (n *Notifier) sendPagerDuty(ctx context.Context, event Event) error {
+	payload := map[string]interface{}{
+		"routing_key": n.cfg.PagerDutyRoutingKey,
+		"dedup_key":   fmt.Sprintf("clickhouse-backup-%s-%s", event.Hostname, event.Operation),
+	}
+	if event.Event == EventFailure {
+		payload["event_action"] = "trigger"
+		payload["payload"] = map[string]interface{}{
+			"summary":        event.Message,
+			"source":         event.Hostname,
+			"severity":       "error",
+			"component":      "clickhouse-backup",
+			"group":          event.Operation,
+			"custom_details": event,
+		}
+	} else {
+		payload["event_action"] = "resolve"
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return n.postJSON(ctx, pagerDutyEventsURL, body, nil)
+}
+
+// sendSMTP - plain text message, STARTTLS when server supports it, PLAIN auth only when notifications->smtp_username is not empty,
+// the whole SMTP session is limited by notifications->timeout, so hung SMTP server doesn't block backup operation
+func (n *Notifier) sendSMTP(ctx context.Context, event Event) error {
+	if n.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.timeout)
+		defer cancel()
+	}
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(n.cfg.SMTPHost, strconv.Itoa(n.cfg.SMTPPort)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+	// cancel of ctx interrupts blocked read or write
+	stopWatch := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stopWatch()
+	c, err := smtp.NewClient(conn, n.cfg.SMTPHost)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: n.cfg.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if n.cfg.SMTPUsername != "" {
+		if err = c.Auth(smtp.PlainAuth("", n.cfg.SMTPUsername, n.cfg.SMTPPassword, n.cfg.SMTPHost)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(n.cfg.SMTPFrom); err != nil {
+		return err
+	}
+	for _, to := range n.cfg.SMTPTo {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	subject := strings.ReplaceAll(strings.ReplaceAll(event.Message, "\r", " "), "\n", " ")
+	if _, err = fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", n.cfg.SMTPFrom, strings.Join(n.cfg.SMTPTo, ", "), subject, event.Message); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
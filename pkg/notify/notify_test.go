@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSMTPServer - minimal SMTP server, reply returns answer for each command, empty answer means server hangs
+func startSMTPServer(t *testing.T, greeting string, reply func(command string) string) (string, int, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		if greeting == "" {
+			time.Sleep(5 * time.Second)
+			return
+		}
+		r := bufio.NewReader(conn)
+		_, _ = conn.Write([]byte(greeting + "\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			answer := reply(strings.TrimSpace(line))
+			_, _ = conn.Write([]byte(answer + "\r\n"))
+			if strings.HasPrefix(answer, "354") {
+				message := strings.Builder{}
+				for {
+					dataLine, err := r.ReadString('\n')
+					if err != nil || dataLine == ".\r\n" {
+						break
+					}
+					message.WriteString(dataLine)
+				}
+				messages <- message.String()
+				_, _ = conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+	}()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return host, portNumber, messages
+}
+
+func newSMTPNotifier(host string, port int, timeout string) *Notifier {
+	cfg := config.DefaultConfig()
+	cfg.Notifications.SMTPHost = host
+	cfg.Notifications.SMTPPort = port
+	cfg.Notifications.SMTPFrom = "backup@example.com"
+	cfg.Notifications.SMTPTo = []string{"ops@example.com"}
+	cfg.Notifications.Timeout = timeout
+	return New(cfg)
+}
+
+func TestSendSMTP(t *testing.T) {
+	host, port, messages := startSMTPServer(t, "220 localhost ESMTP", func(command string) string {
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			return "250 localhost"
+		case command == "DATA":
+			return "354 Start mail input"
+		case command == "QUIT":
+			return "221 Bye"
+		}
+		return "250 OK"
+	})
+	n := newSMTPNotifier(host, port, "5s")
+	require.NoError(t, n.sendSMTP(context.Background(), Event{Message: "create backup1 failure\nno space left"}))
+	message := <-messages
+	assert.Contains(t, message, "Subject: create backup1 failure no space left\r\n")
+	assert.Contains(t, message, "To: ops@example.com\r\n")
+}
+
+func TestSendSMTPTimeout(t *testing.T) {
+	host, port, _ := startSMTPServer(t, "", nil)
+	n := newSMTPNotifier(host, port, "200ms")
+	start := time.Now()
+	err := n.sendSMTP(context.Background(), Event{Message: "create backup1 start"})
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second, "hung SMTP server shall not block operation longer than notifications->timeout")
+
+	host, port, _ = startSMTPServer(t, "", nil)
+	n = newSMTPNotifier(host, port, "1m")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	require.Error(t, n.sendSMTP(ctx, Event{Message: "create backup1 start"}))
+	assert.Less(t, time.Since(start), 2*time.Second, "canceled operation shall interrupt SMTP session")
+}
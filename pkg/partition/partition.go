@@ -177,6 +177,131 @@ func dropPartitionIdTable(ch *clickhouse.ClickHouse, database string, partitionI
 
 var partitionTupleRE = regexp.MustCompile(`\)\s*,\s*\(`)
 
+// HasGlobPattern - partition_id in --partitions could contain ?, * and [] wildcards, like `2023-0[1-6]*`
+func HasGlobPattern(partitions []string) bool {
+	for _, partitionArg := range partitions {
+		if !strings.HasPrefix(strings.Trim(partitionArg, " \t"), "(") && strings.ContainsAny(partitionArg, "*?[") {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionsWhereFunctions - regular functions allowed in --partitions-where, any other function call, including table functions, is rejected
+var partitionsWhereFunctions = map[string]struct{}{
+	"toInt8": {}, "toInt16": {}, "toInt32": {}, "toInt64": {}, "toUInt8": {}, "toUInt16": {}, "toUInt32": {}, "toUInt64": {}, "toFloat64": {},
+	"toInt64OrZero": {}, "toUInt32OrZero": {}, "toUInt64OrZero": {}, "toString": {},
+	"toDate": {}, "toDateOrNull": {}, "toDateTime": {}, "parseDateTimeBestEffort": {}, "parseDateTimeBestEffortOrNull": {},
+	"toYYYYMM": {}, "toYYYYMMDD": {}, "toYear": {}, "toMonth": {}, "toDayOfMonth": {}, "toStartOfMonth": {}, "toStartOfWeek": {}, "formatDateTime": {},
+	"today": {}, "yesterday": {}, "now": {}, "addDays": {}, "addMonths": {}, "addYears": {}, "subtractDays": {}, "subtractMonths": {}, "subtractYears": {},
+	"length": {}, "lower": {}, "upper": {}, "substring": {}, "substr": {}, "left": {}, "right": {}, "concat": {}, "position": {},
+	"startsWith": {}, "endsWith": {}, "like": {}, "notLike": {}, "match": {}, "replaceRegexpOne": {}, "extract": {},
+	"in": {}, "notIn": {}, "has": {}, "if": {}, "multiIf": {}, "isNull": {}, "isNotNull": {}, "coalesce": {},
+	"and": {}, "or": {}, "not": {}, "equals": {}, "notEquals": {}, "less": {}, "greater": {}, "lessOrEquals": {}, "greaterOrEquals": {},
+}
+
+// partitionsWhereKeywords - SQL keywords allowed in --partitions-where, SELECT, SETTINGS, FORMAT and others are rejected, so expression can't contain subqueries
+var partitionsWhereKeywords = map[string]struct{}{
+	"AND": {}, "OR": {}, "NOT": {}, "IN": {}, "LIKE": {}, "ILIKE": {}, "BETWEEN": {}, "IS": {}, "NULL": {}, "TRUE": {}, "FALSE": {},
+	"INTERVAL": {}, "DAY": {}, "WEEK": {}, "MONTH": {}, "QUARTER": {}, "YEAR": {},
+}
+
+var partitionsWhereNumberRE = regexp.MustCompile(`^(0[xX][0-9a-fA-F]+|[0-9]+(\.[0-9]*)?([eE][+-]?[0-9]+)?)`)
+
+// ValidatePartitionsWhere - --partitions-where shall be plain boolean expression over `partition_id` column, which contains only literals, operators, allowed functions and keywords,
+// cause it is pasted into SQL query as is
+func ValidatePartitionsWhere(where string) error {
+	if strings.Trim(where, " \t\r\n") == "" {
+		return fmt.Errorf("--partitions-where is empty")
+	}
+	depth := 0
+	for i := 0; i < len(where); {
+		c := where[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '\'':
+			j := i + 1
+			for ; j < len(where) && where[j] != '\''; j++ {
+				if where[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(where) {
+				return fmt.Errorf("--partitions-where=%s: unterminated string literal", where)
+			}
+			i = j + 1
+		case c >= '0' && c <= '9':
+			number := partitionsWhereNumberRE.FindString(where[i:])
+			if i += len(number); i < len(where) && isPartitionsWhereIdentifierChar(where[i]) {
+				return fmt.Errorf("--partitions-where=%s: invalid number %s%c", where, number, where[i])
+			}
+		case isPartitionsWhereIdentifierChar(c):
+			j := i
+			for ; j < len(where) && isPartitionsWhereIdentifierChar(where[j]); j++ {
+			}
+			identifier := where[i:j]
+			next := strings.TrimLeft(where[j:], " \t\r\n")
+			if _, isKeyword := partitionsWhereKeywords[strings.ToUpper(identifier)]; isKeyword {
+				i = j
+				continue
+			}
+			if strings.HasPrefix(next, "(") {
+				if _, allowed := partitionsWhereFunctions[identifier]; !allowed {
+					return fmt.Errorf("--partitions-where=%s: function %s is not allowed", where, identifier)
+				}
+			} else if identifier != "partition_id" {
+				return fmt.Errorf("--partitions-where=%s: only partition_id column is allowed, got %s", where, identifier)
+			}
+			i = j
+		case (c == '-' || c == '/') && i+1 < len(where) && (where[i+1] == '-' || where[i+1] == '*'):
+			return fmt.Errorf("--partitions-where=%s: comments are not allowed", where)
+		case c == '(' || c == '[':
+			depth++
+			i++
+		case c == ')' || c == ']':
+			if depth--; depth < 0 {
+				return fmt.Errorf("--partitions-where=%s: unbalanced brackets", where)
+			}
+			i++
+		case strings.IndexByte("=!<>+-*/%,", c) >= 0:
+			i++
+		default:
+			return fmt.Errorf("--partitions-where=%s: unexpected character %q", where, c)
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("--partitions-where=%s: unbalanced brackets", where)
+	}
+	return nil
+}
+
+func isPartitionsWhereIdentifierChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// FilterPartitionIdsByExpression - evaluate --partitions-where expression on clickhouse-server side against each partition_id, return matched partition_id
+func FilterPartitionIdsByExpression(ctx context.Context, ch *clickhouse.ClickHouse, partitionIds []string, where string) (common.EmptyMap, error) {
+	if err := ValidatePartitionsWhere(where); err != nil {
+		return nil, err
+	}
+	matchedIds := make(common.EmptyMap, 0)
+	if len(partitionIds) == 0 {
+		return matchedIds, nil
+	}
+	rows := make([]struct {
+		Id string `ch:"partition_id"`
+	}, 0)
+	sql := fmt.Sprintf("SELECT partition_id FROM (SELECT arrayJoin(?) AS partition_id) WHERE %s", where)
+	if err := ch.SelectContext(ctx, &rows, sql, partitionIds); err != nil {
+		return nil, fmt.Errorf("can't apply --partitions-where=%s: %v", where, err)
+	}
+	for _, row := range rows {
+		matchedIds[row.Id] = struct{}{}
+	}
+	return matchedIds, nil
+}
+
 // ConvertPartitionsToIdsMapAndNamesList - get partitions from CLI/API params and convert it for NameList and IdMap for each table
 func ConvertPartitionsToIdsMapAndNamesList(ctx context.Context, ch *clickhouse.ClickHouse, tablesFromClickHouse []clickhouse.Table, tablesFromMetadata []metadata.TableMetadata, partitions []string) (map[metadata.TableTitle]common.EmptyMap, map[metadata.TableTitle][]string) {
 	partitionsIdMap := map[metadata.TableTitle]common.EmptyMap{}
@@ -219,13 +344,18 @@ func ConvertPartitionsToIdsMapAndNamesList(ctx context.Context, ch *clickhouse.C
 			// when partitionId == partitionName
 			for _, item := range strings.Split(partitionArg, ",") {
 				item = strings.Trim(item, " \t")
+				// glob pattern is not valid partition name, it matched with partition_id only
+				itemName := item
+				if strings.ContainsAny(item, "*?[") {
+					itemName = ""
+				}
 				for _, t := range tablesFromClickHouse {
 					createIdMapAndNameListIfNotExists(t.Database, t.Name, partitionsIdMap, partitionsNameList)
-					addItemToIdMapAndNameListIfNotExists(item, item, t.Database, t.Name, partitionsIdMap, partitionsNameList)
+					addItemToIdMapAndNameListIfNotExists(item, itemName, t.Database, t.Name, partitionsIdMap, partitionsNameList)
 				}
 				for _, t := range tablesFromMetadata {
 					createIdMapAndNameListIfNotExists(t.Database, t.Table, partitionsIdMap, partitionsNameList)
-					addItemToIdMapAndNameListIfNotExists(item, item, t.Database, t.Table, partitionsIdMap, partitionsNameList)
+					addItemToIdMapAndNameListIfNotExists(item, itemName, t.Database, t.Table, partitionsIdMap, partitionsNameList)
 				}
 			}
 		}
@@ -0,0 +1,39 @@
+package partition
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePartitionsWhere(t *testing.T) {
+	for _, where := range []string{
+		"partition_id >= '202401'",
+		"toUInt32(partition_id) BETWEEN 20240101 AND 20240131",
+		"partition_id IN ('2024-01', '2024-02') OR startsWith(partition_id, '2023')",
+		"toDate(partition_id) >= today() - INTERVAL 7 DAY",
+		"NOT (partition_id = 'all') and partition_id != 'it''s\\' quoted'",
+		"toFloat64(partition_id) > 1.5e3 or toUInt64(partition_id) = 0xFF",
+	} {
+		assert.NoError(t, ValidatePartitionsWhere(where), where)
+	}
+	for _, where := range []string{
+		"",
+		"partition_id IN (SELECT name FROM system.tables)",
+		"partition_id IN url('http://example.com/ids', 'TSV', 'id String')",
+		"length(file('/etc/passwd')) > 0",
+		"partition_id = '1' SETTINGS max_threads=1",
+		"1 FORMAT JSON",
+		"1FORMAT",
+		"partition_id = '1'; DROP TABLE db.t",
+		"partition_id = '1' -- comment",
+		"partition_id = '1' /* comment */",
+		"partition_id = `partition_id`",
+		"database = 'system'",
+		"partition_id = 'unterminated",
+		"(partition_id = '1'))",
+		"partition_id = {param:String}",
+	} {
+		assert.Error(t, ValidatePartitionsWhere(where), where)
+	}
+}
@@ -55,3 +55,32 @@ func (b *Bar) NewProxyReader(r io.Reader) io.Reader {
 	}
 	return r
 }
+
+// StartNewCommandBar - one bar for whole command, total could be unknown during start, see SetTotal64
+func StartNewCommandBar(w io.Writer, total int64) *Bar {
+	pb := progressbar.New64(total).SetUnits(progressbar.U_BYTES)
+	pb.Output = w
+	pb.ShowSpeed = true
+	return &Bar{
+		show: true,
+		pb:   pb.Start(),
+	}
+}
+
+func (b *Bar) SetTotal64(total int64) {
+	if b.show {
+		b.pb.SetTotal64(total)
+	}
+}
+
+func (b *Bar) Set64(current int64) {
+	if b.show {
+		b.pb.Set64(current)
+	}
+}
+
+func (b *Bar) Postfix(postfix string) {
+	if b.show {
+		b.pb.Postfix(postfix)
+	}
+}
@@ -0,0 +1,50 @@
+// Package resources - lower CPU and I/O priority of clickhouse-backup process and move it into separate cgroup,
+// to reduce impact of compression and copy on clickhouse-server queries on shared hosts
+package resources
+
+import (
+	"sync/atomic"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+)
+
+// disabled - see Disable
+var disabled atomic.Bool
+
+// Disable - Apply does nothing until process exit, used by `server`, priority of process can't be raised back after operation finished,
+// so API, metrics and next operations of long-running process would run with lowered priority and inside cgroup limits
+func Disable() {
+	disabled.Store(true)
+}
+
+// Apply - apply general->cpu_nice, io_nice_class, io_nice_level and cgroup_path to all threads of current process,
+// new threads inherit priority from parent thread, settings are kept until process exit
+func Apply(cfg *config.GeneralConfig) error {
+	log := apexLog.WithField("logger", "resources")
+	if disabled.Load() {
+		if cfg.CPUNice != 0 || cfg.IONiceClass != "" || cfg.CgroupPath != "" {
+			log.Debugf("cpu_nice, io_nice_class and cgroup_path are ignored by `server`")
+		}
+		return nil
+	}
+	if cfg.CPUNice != 0 {
+		if err := setCPUNice(cfg.CPUNice); err != nil {
+			return err
+		}
+		log.Debugf("cpu_nice: %d applied", cfg.CPUNice)
+	}
+	if cfg.IONiceClass != "" {
+		if err := setIONice(cfg.IONiceClass, cfg.IONiceLevel); err != nil {
+			return err
+		}
+		log.Debugf("io_nice_class: %s, io_nice_level: %d applied", cfg.IONiceClass, cfg.IONiceLevel)
+	}
+	if cfg.CgroupPath != "" {
+		if err := moveToCgroup(cfg.CgroupPath); err != nil {
+			return err
+		}
+		log.Debugf("moved to cgroup %s", cfg.CgroupPath)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package resources
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+)
+
+// https://github.com/torvalds/linux/blob/master/include/uapi/linux/ioprio.h
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+var ioprioClasses = map[string]int{
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// threadIds - nice and ioprio on Linux are per thread, go runtime could run goroutines on any thread
+func threadIds() ([]int, error) {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil, err
+	}
+	tids := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		tids = append(tids, tid)
+	}
+	return tids, nil
+}
+
+func setCPUNice(nice int) error {
+	tids, err := threadIds()
+	if err != nil {
+		return err
+	}
+	for _, tid := range tids {
+		// thread could exit after read /proc/self/task
+		if err = syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("can't set cpu_nice: %d for thread %d: %v", nice, tid, err)
+		}
+	}
+	return nil
+}
+
+func setIONice(class string, level int) error {
+	ioClass, exists := ioprioClasses[class]
+	if !exists {
+		return fmt.Errorf("unknown io_nice_class: %s", class)
+	}
+	// idle class doesn't have levels
+	if class == "idle" {
+		level = 0
+	}
+	ioprio := ioClass<<ioprioClassShift | level
+	tids, err := threadIds()
+	if err != nil {
+		return err
+	}
+	for _, tid := range tids {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio)); errno != 0 && errno != syscall.ESRCH {
+			return fmt.Errorf("can't set io_nice_class: %s, io_nice_level: %d for thread %d: %v", class, level, tid, errno)
+		}
+	}
+	return nil
+}
+
+// moveToCgroup - cgroup.procs moves all threads of process, works for cgroup v2 and for cgroup v1 controller directory
+func moveToCgroup(cgroupPath string) error {
+	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+		return fmt.Errorf("can't create cgroup %s: %v", cgroupPath, err)
+	}
+	if err := os.WriteFile(path.Join(cgroupPath, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return fmt.Errorf("can't move process into cgroup %s: %v", cgroupPath, err)
+	}
+	return nil
+}
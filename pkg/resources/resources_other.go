@@ -0,0 +1,20 @@
+//go:build !linux
+
+package resources
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func setCPUNice(int) error {
+	return fmt.Errorf("cpu_nice is not supported on %s", runtime.GOOS)
+}
+
+func setIONice(string, int) error {
+	return fmt.Errorf("io_nice_class is not supported on %s", runtime.GOOS)
+}
+
+func moveToCgroup(string) error {
+	return fmt.Errorf("cgroup_path is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,16 @@
+package resources
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyDisabled(t *testing.T) {
+	cfg := &config.GeneralConfig{IONiceClass: "unknown", CgroupPath: "/proc/clickhouse-backup"}
+	assert.Error(t, Apply(cfg))
+	Disable()
+	assert.NoError(t, Apply(cfg), "`server` shall not change priority and cgroup of process")
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+)
+
+type apiRoleKey struct{}
+
+// authenticate - bearer token from api->tokens first, then client certificate common name from api->client_certificate_roles, then basic auth with api->user_role
+func (api *APIServer) authenticate(r *http.Request) (role string, principal string, ok bool) {
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+		for expectedToken, tokenRole := range api.config.API.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(expectedToken)) == 1 {
+				return tokenRole, "token", true
+			}
+		}
+		return "", "token", false
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.PeerCertificates) > 0 {
+		commonName := r.TLS.PeerCertificates[0].Subject.CommonName
+		if certificateRole, exists := api.config.API.ClientCertificateRoles[commonName]; exists {
+			return certificateRole, "certificate " + commonName, true
+		}
+	}
+	user, pass, _ := r.BasicAuth()
+	query := r.URL.Query()
+	if u, exist := query["user"]; exist {
+		user = u[0]
+	}
+	if p, exist := query["pass"]; exist {
+		pass = p[0]
+	}
+	if (user != api.config.API.Username) || (pass != api.config.API.Password) {
+		return "", fmt.Sprintf("%s:%s", user, pass), false
+	}
+	return api.config.API.UserRole, user, true
+}
+
+func withAPIRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, apiRoleKey{}, role)
+}
+
+func getAPIRole(r *http.Request) string {
+	if role, ok := r.Context().Value(apiRoleKey{}).(string); ok {
+		return role
+	}
+	return ""
+}
+
+// roleGuard - authenticated role shall be at least requiredRole, 403 otherwise, guarded routes are disabled with 405 when `api->read_only: true`
+func (api *APIServer) roleGuard(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if api.config.API.ReadOnly {
+			api.writeError(w, http.StatusMethodNotAllowed, r.URL.Path, fmt.Errorf("405 Method %s %s Not Allowed, api->read_only: true", r.Method, r.URL.Path))
+			return
+		}
+		if !api.isRoleAllowed(r, requiredRole) {
+			api.writeError(w, http.StatusForbidden, r.URL.Path, fmt.Errorf("403 Forbidden %s %s, required role `%s`", r.Method, r.URL.Path, requiredRole))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (api *APIServer) isRoleAllowed(r *http.Request, requiredRole string) bool {
+	return config.APIRoleLevel(getAPIRole(r)) >= config.APIRoleLevel(requiredRole)
+}
+
+// operatorGuard - create, upload, download, restore and other routes which run commands require `operator` role
+func (api *APIServer) operatorGuard(next http.HandlerFunc) http.HandlerFunc {
+	return api.roleGuard(config.APIRoleOperator, next)
+}
+
+// adminGuard - delete backups, apply remote retention and restart require `admin` role
+func (api *APIServer) adminGuard(next http.HandlerFunc) http.HandlerFunc {
+	return api.roleGuard(config.APIRoleAdmin, next)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIRoles(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.API.Username = "user"
+	cfg.API.Password = "pass"
+	cfg.API.UserRole = config.APIRoleOperator
+	cfg.API.Tokens = map[string]string{"monitoring-token": config.APIRoleReadOnly, "admin-token": config.APIRoleAdmin}
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "test")}
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	handler := func(guard func(http.HandlerFunc) http.HandlerFunc) http.Handler {
+		return api.basicAuthMiddleware(guard(ok))
+	}
+	request := func(h http.Handler, setAuth func(r *http.Request)) int {
+		r := httptest.NewRequest(http.MethodPost, "/backup/delete/local/test", nil)
+		setAuth(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	basic := func(r *http.Request) { r.SetBasicAuth("user", "pass") }
+	wrongBasic := func(r *http.Request) { r.SetBasicAuth("user", "wrong") }
+	readOnlyToken := func(r *http.Request) { r.Header.Set("Authorization", "Bearer monitoring-token") }
+	adminToken := func(r *http.Request) { r.Header.Set("Authorization", "Bearer admin-token") }
+	unknownToken := func(r *http.Request) { r.Header.Set("Authorization", "Bearer unknown") }
+
+	assert.Equal(t, http.StatusUnauthorized, request(handler(api.operatorGuard), wrongBasic))
+	assert.Equal(t, http.StatusUnauthorized, request(handler(api.operatorGuard), unknownToken))
+	assert.Equal(t, http.StatusOK, request(handler(api.operatorGuard), basic))
+	assert.Equal(t, http.StatusForbidden, request(handler(api.adminGuard), basic))
+	assert.Equal(t, http.StatusForbidden, request(handler(api.operatorGuard), readOnlyToken))
+	assert.Equal(t, http.StatusOK, request(handler(api.adminGuard), adminToken))
+	assert.Equal(t, http.StatusOK, request(api.basicAuthMiddleware(http.HandlerFunc(ok)), readOnlyToken))
+}
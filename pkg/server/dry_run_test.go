@@ -0,0 +1,22 @@
+package server
+
+import (
+	"testing"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunWithoutMetrics(t *testing.T) {
+	assert.True(t, hasDryRunArg([]string{"create", "--dry-run", "backup"}))
+	assert.True(t, hasDryRunArg([]string{"upload", "--dry-run=true", "backup"}))
+	assert.False(t, hasDryRunArg([]string{"create", "--dry-run=false", "backup"}))
+	// api.metrics is nil, so dry run shall not touch it
+	api := &APIServer{log: apexLog.WithField("logger", "test")}
+	executed := false
+	assert.NoError(t, api.executeWithMetrics("create", true, func() error {
+		executed = true
+		return nil
+	}))
+	assert.True(t, executed)
+}
@@ -22,6 +22,9 @@ type APIMetrics struct {
 	LastFinish        map[string]prometheus.Gauge
 	LastDuration      map[string]prometheus.Gauge
 	LastStatus        map[string]prometheus.Gauge
+	// LastDurationSeconds and LastSuccess allow alerting on schedule drift without knowing nanoseconds and status encoding
+	LastDurationSeconds map[string]prometheus.Gauge
+	LastSuccess         map[string]prometheus.Gauge
 
 	LastBackupSizeLocal         prometheus.Gauge
 	LastBackupSizeRemote        prometheus.Gauge
@@ -30,11 +33,81 @@ type APIMetrics struct {
 	NumberBackupsLocal          prometheus.Gauge
 	NumberBackupsRemoteExpected prometheus.Gauge
 	NumberBackupsLocalExpected  prometheus.Gauge
+	LastCreateSizeBytes         prometheus.Gauge
+	RemoteBackupsTotal          prometheus.Gauge
+	PartsTotal                  *prometheus.GaugeVec
+	TableSizeBytes              *prometheus.GaugeVec
+
+	RemoteTotalBytes          prometheus.Gauge
+	RemoteOrphanedBytes       prometheus.Gauge
+	RemoteBackupBytes         *prometheus.GaugeVec
+	RemoteOldestBackupAge     prometheus.Gauge
+	RemoteNewestBackupAge     prometheus.Gauge
+	RemoteInventoryLastFinish prometheus.Gauge
+
+	ScheduleLastStatus   *prometheus.GaugeVec
+	ScheduleLastFinish   *prometheus.GaugeVec
+	ScheduleLastDuration *prometheus.GaugeVec
 
 	SubCommands map[string][]string
 	log         *apexLog.Entry
 }
 
+// Storage* metrics observed by storage.BackupDestination via StorageMetrics, which `server` passes to storage.SetOperationMetrics
+var (
+	StorageOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Duration of remote storage operation, GetFileReader duration include reading whole object",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"kind", "operation"})
+	StorageOperationThroughput = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "storage_operation_bytes_per_second",
+		Help:      "Throughput of remote storage PutFile, GetFileReader and CopyObject operations",
+		Buckets:   prometheus.ExponentialBuckets(64*1024, 4, 10),
+	}, []string{"kind", "operation"})
+	StorageOperationsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "storage_operations_in_flight",
+		Help:      "Number of currently running remote storage operations",
+	}, []string{"kind", "operation"})
+	StorageOperationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "storage_operation_errors",
+		Help:      "Counter of failed remote storage operations",
+	}, []string{"kind", "operation"})
+	StorageSourceReadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "storage_put_source_read_duration_seconds",
+		Help:      "Time spent in reading local source data during PutFile, high value means slow local disk or compression",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"kind"})
+)
+
+// StorageMetrics - implements storage.OperationMetrics with Storage* metrics
+type StorageMetrics struct{}
+
+func (StorageMetrics) OperationStarted(kind, operation string) {
+	StorageOperationsInFlight.WithLabelValues(kind, operation).Inc()
+}
+
+func (StorageMetrics) OperationFinished(kind, operation string, bytes int64, duration time.Duration, err error) {
+	StorageOperationsInFlight.WithLabelValues(kind, operation).Dec()
+	if err != nil {
+		StorageOperationErrors.WithLabelValues(kind, operation).Inc()
+		return
+	}
+	StorageOperationDuration.WithLabelValues(kind, operation).Observe(duration.Seconds())
+	if bytes > 0 && duration > 0 {
+		StorageOperationThroughput.WithLabelValues(kind, operation).Observe(float64(bytes) / duration.Seconds())
+	}
+}
+
+func (StorageMetrics) SourceRead(kind string, duration time.Duration) {
+	StorageSourceReadDuration.WithLabelValues(kind).Observe(duration.Seconds())
+}
+
 func NewAPIMetrics() *APIMetrics {
 	metrics := &APIMetrics{
 		SubCommands: map[string][]string{
@@ -55,6 +128,8 @@ func (m *APIMetrics) RegisterMetrics() {
 	lastFinish := map[string]prometheus.Gauge{}
 	lastDuration := map[string]prometheus.Gauge{}
 	lastStatus := map[string]prometheus.Gauge{}
+	lastDurationSeconds := map[string]prometheus.Gauge{}
+	lastSuccess := map[string]prometheus.Gauge{}
 
 	for _, command := range commandList {
 		successfulCounter[command] = prometheus.NewCounter(prometheus.CounterOpts{
@@ -87,6 +162,16 @@ func (m *APIMetrics) RegisterMetrics() {
 			Name:      fmt.Sprintf("last_%s_status", command),
 			Help:      fmt.Sprintf("Last backup %s status: 0=failed, 1=success, 2=unknown", command),
 		})
+		lastDurationSeconds[command] = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      fmt.Sprintf("last_%s_duration_seconds", command),
+			Help:      fmt.Sprintf("Last backup %s duration in seconds", command),
+		})
+		lastSuccess[command] = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "clickhouse_backup",
+			Name:      fmt.Sprintf("last_%s_success_timestamp", command),
+			Help:      fmt.Sprintf("Last successful backup %s finish timestamp", command),
+		})
 	}
 
 	m.SuccessfulCounter = successfulCounter
@@ -95,6 +180,8 @@ func (m *APIMetrics) RegisterMetrics() {
 	m.LastFinish = lastFinish
 	m.LastDuration = lastDuration
 	m.LastStatus = lastStatus
+	m.LastDurationSeconds = lastDurationSeconds
+	m.LastSuccess = lastSuccess
 
 	m.LastBackupSizeLocal = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: "clickhouse_backup",
@@ -137,6 +224,84 @@ func (m *APIMetrics) RegisterMetrics() {
 		Help:      "How many backups expected on local storage",
 	})
 
+	m.LastCreateSizeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "last_create_size_bytes",
+		Help:      "Size of last created local backup in bytes, data + metadata + rbac + configs",
+	})
+
+	m.RemoteBackupsTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_backups_total",
+		Help:      "Number of remote backups, including broken",
+	})
+
+	m.PartsTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "parts_total",
+		Help:      "Number of data parts for each table in last created local backup",
+	}, []string{"table"})
+
+	m.TableSizeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "table_size_bytes",
+		Help:      "Size of each table in last created local backup in bytes",
+	}, []string{"table"})
+
+	m.RemoteTotalBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_total_bytes",
+		Help:      "Total size of all objects in remote storage path in bytes, calculated by inventory scan",
+	})
+
+	m.RemoteOrphanedBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_orphaned_bytes",
+		Help:      "Size of objects in remote storage path which don't belong to any valid backup in bytes",
+	})
+
+	m.RemoteBackupBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_backup_bytes",
+		Help:      "Size of each remote backup in bytes, calculated by inventory scan",
+	}, []string{"backup_name"})
+
+	m.RemoteOldestBackupAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_oldest_backup_age_seconds",
+		Help:      "Age of the oldest valid remote backup in seconds",
+	})
+
+	m.RemoteNewestBackupAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_newest_backup_age_seconds",
+		Help:      "Age of the newest valid remote backup in seconds",
+	})
+
+	m.RemoteInventoryLastFinish = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_inventory_last_finish",
+		Help:      "Last successful remote storage inventory scan finish timestamp",
+	})
+
+	m.ScheduleLastStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "schedule_last_status",
+		Help:      "Last scheduled job status: 0=failed, 1=success",
+	}, []string{"job"})
+
+	m.ScheduleLastFinish = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "schedule_last_finish",
+		Help:      "Last scheduled job finish timestamp",
+	}, []string{"job"})
+
+	m.ScheduleLastDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "schedule_last_duration",
+		Help:      "Last scheduled job duration in nanoseconds",
+	}, []string{"job"})
+
 	for _, command := range commandList {
 		prometheus.MustRegister(
 			m.SuccessfulCounter[command],
@@ -145,6 +310,8 @@ func (m *APIMetrics) RegisterMetrics() {
 			m.LastFinish[command],
 			m.LastDuration[command],
 			m.LastStatus[command],
+			m.LastDurationSeconds[command],
+			m.LastSuccess[command],
 		)
 	}
 
@@ -155,6 +322,24 @@ func (m *APIMetrics) RegisterMetrics() {
 		m.NumberBackupsLocal,
 		m.NumberBackupsRemoteExpected,
 		m.NumberBackupsLocalExpected,
+		m.LastCreateSizeBytes,
+		m.RemoteBackupsTotal,
+		m.PartsTotal,
+		m.TableSizeBytes,
+		m.RemoteTotalBytes,
+		m.RemoteOrphanedBytes,
+		m.RemoteBackupBytes,
+		m.RemoteOldestBackupAge,
+		m.RemoteNewestBackupAge,
+		m.RemoteInventoryLastFinish,
+		m.ScheduleLastStatus,
+		m.ScheduleLastFinish,
+		m.ScheduleLastDuration,
+		StorageOperationDuration,
+		StorageOperationThroughput,
+		StorageOperationsInFlight,
+		StorageOperationErrors,
+		StorageSourceReadDuration,
 	)
 
 	for _, command := range commandList {
@@ -179,11 +364,13 @@ func (m *APIMetrics) Start(command string, startTime time.Time) {
 func (m *APIMetrics) Finish(command string, startTime time.Time) {
 	if _, exists := m.LastFinish[command]; exists {
 		m.LastDuration[command].Set(float64(time.Since(startTime).Nanoseconds()))
+		m.LastDurationSeconds[command].Set(time.Since(startTime).Seconds())
 		m.LastFinish[command].Set(float64(time.Now().Unix()))
 		if subCommands, subCommandsExists := m.SubCommands[command]; subCommandsExists {
 			for _, subCommand := range subCommands {
 				if _, exists := m.LastFinish[subCommand]; exists {
 					m.LastDuration[subCommand].Set(float64(time.Since(startTime).Nanoseconds()))
+					m.LastDurationSeconds[subCommand].Set(time.Since(startTime).Seconds())
 					m.LastFinish[subCommand].Set(float64(startTime.Unix()))
 				}
 			}
@@ -200,6 +387,12 @@ func (m *APIMetrics) Success(command string) {
 	}
 	if _, exists := m.LastStatus[command]; exists {
 		m.LastStatus[command].Set(1)
+		m.LastSuccess[command].Set(float64(time.Now().Unix()))
+		for _, subCommand := range m.SubCommands[command] {
+			if _, exists := m.LastSuccess[subCommand]; exists {
+				m.LastSuccess[subCommand].Set(float64(time.Now().Unix()))
+			}
+		}
 	} else {
 		m.log.Warnf("%s not found in LastStatus metrics", command)
 	}
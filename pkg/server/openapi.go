@@ -0,0 +1,207 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// openAPIParameter - query or path parameter, flags without value like `schema` have boolean type and work when present with any value
+type openAPIParameter struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+}
+
+// openAPIOperation - one route of registerHTTPHandlers, Response is name of schema in components, array responses are returned as JSONEachRow,
+// IsText responses are plain text without schema, Deprecated operations are legacy aliases of other operations
+type openAPIOperation struct {
+	Method      string
+	Path        string
+	OperationId string
+	Summary     string
+	Parameters  []openAPIParameter
+	Response    string
+	IsArray     bool
+	IsText      bool
+	Deprecated  bool
+}
+
+func queryFlag(name, description string) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Type: "boolean", Description: description}
+}
+
+func queryString(name, description string) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Type: "string", Description: description}
+}
+
+func pathString(name, description string) openAPIParameter {
+	return openAPIParameter{Name: name, In: "path", Type: "string", Description: description}
+}
+
+var (
+	tableParameter         = queryString("table", "table name patterns, separated by comma, allow ? and * as wildcard, works as --tables")
+	excludeTablesParameter = queryString("exclude_tables", "works as --exclude-tables")
+	partitionsParameter    = queryString("partitions", "works as --partitions")
+	callbackParameter      = queryString("callback", "URL which receives POST request with operation result after async command finished")
+	nameParameter          = pathString("name", "backup name")
+)
+
+var openAPIOperations = []openAPIOperation{
+	{Method: "GET", Path: "/", OperationId: "getRoutes", Summary: "List of registered API routes", IsText: true},
+	{Method: "POST", Path: "/", OperationId: "restartRoot", Summary: "Legacy alias for `POST /restart`", Response: "OperationResult", Deprecated: true},
+	{Method: "GET", Path: "/openapi.json", OperationId: "getOpenAPI", Summary: "This document", Response: "OperationResult"},
+	{Method: "GET", Path: "/health", OperationId: "health", Summary: "Health check, returns `OK` status when API server accepts requests", Response: "OperationResult"},
+	{Method: "GET", Path: "/metrics", OperationId: "metrics", Summary: "Prometheus metrics, available when `api->enable_metrics: true`", IsText: true},
+	{Method: "POST", Path: "/restart", OperationId: "restart", Summary: "Restart HTTP server, close all current connections, reload config", Response: "OperationResult"},
+	{Method: "GET", Path: "/restart", OperationId: "restartGet", Summary: "Legacy alias for `POST /restart`", Response: "OperationResult", Deprecated: true},
+	{Method: "POST", Path: "/backup/kill", OperationId: "kill", Summary: "Kill selected command from `GET /backup/actions` command list", Parameters: []openAPIParameter{queryString("command", "command from `GET /backup/actions`, last in progress command when absent")}, Response: "OperationResult"},
+	{Method: "GET", Path: "/backup/kill", OperationId: "killGet", Summary: "Legacy alias for `POST /backup/kill`", Parameters: []openAPIParameter{queryString("command", "command from `GET /backup/actions`, last in progress command when absent")}, Response: "OperationResult", Deprecated: true},
+	{Method: "GET", Path: "/backup/schedule", OperationId: "getSchedule", Summary: "Scheduled jobs from `api.schedule` config section", Response: "OperationResult", IsArray: true},
+	{Method: "GET", Path: "/config", OperationId: "getConfig", Summary: "Active config, credentials replaced with `******`", Response: "OperationResult"},
+	{Method: "POST", Path: "/backup/watch", OperationId: "watch", Summary: "Run background watch process, create full and incremental backups by schedule", Parameters: []openAPIParameter{
+		tableParameter, excludeTablesParameter, partitionsParameter,
+		queryString("watch_interval", "works as --watch-interval"), queryString("incremental_interval", "alias for watch_interval, works as --incremental-interval"), queryString("full_interval", "works as --full-interval"),
+		queryString("watch_backup_name_template", "works as --watch-backup-name-template"),
+		queryFlag("schema", "works as --schema"), queryFlag("rbac", "works as --rbac"), queryFlag("configs", "works as --configs"),
+		queryFlag("skip_check_parts_columns", "works as --skip-check-parts-columns"),
+	}, Response: "OperationResult"},
+	{Method: "GET", Path: "/backup/watch", OperationId: "watchGet", Summary: "Legacy alias for `POST /backup/watch`", Response: "OperationResult", Deprecated: true},
+	{Method: "GET", Path: "/backup/tables", OperationId: "getTables", Summary: "Tables available for backup", Parameters: []openAPIParameter{tableParameter, excludeTablesParameter}, Response: "Table", IsArray: true},
+	{Method: "GET", Path: "/backup/tables/all", OperationId: "getAllTables", Summary: "All tables, including tables matched with `skip_tables`", Parameters: []openAPIParameter{tableParameter, excludeTablesParameter}, Response: "Table", IsArray: true},
+	{Method: "GET", Path: "/backup/list", OperationId: "list", Summary: "Local and remote backups", Response: "BackupListItem", IsArray: true},
+	{Method: "GET", Path: "/backup/list/{where}", OperationId: "listWhere", Summary: "Local or remote backups", Parameters: []openAPIParameter{pathString("where", "`local` or `remote`")}, Response: "BackupListItem", IsArray: true},
+	{Method: "POST", Path: "/backup/create", OperationId: "create", Summary: "Create new local backup, async", Parameters: []openAPIParameter{
+		queryString("name", "backup name, generated when absent"), tableParameter, excludeTablesParameter, partitionsParameter,
+		queryFlag("schema", "works as --schema"), queryFlag("rbac", "works as --rbac"), queryFlag("configs", "works as --configs"),
+		queryFlag("resumable", "works as --resumable"), queryFlag("dry_run", "works as --dry-run"),
+		queryString("check_parts_columns", "works as --check-parts-columns"), queryString("wait_mutations", "works as --wait-mutations"),
+		queryString("skip_unchanged_from", "works as --skip-unchanged-from"), callbackParameter,
+	}, Response: "Acknowledged"},
+	{Method: "POST", Path: "/backup/clean", OperationId: "clean", Summary: "Remove data in `shadow` folder for all disks", Parameters: []openAPIParameter{queryFlag("dry_run", "works as --dry-run"), queryFlag("orphaned", "works as --orphaned")}, Response: "OperationResult"},
+	{Method: "POST", Path: "/backup/clean/remote_broken", OperationId: "cleanRemoteBroken", Summary: "Remove all broken remote backups", Response: "OperationResult"},
+	{Method: "POST", Path: "/backup/clean/remote", OperationId: "cleanRemote", Summary: "Apply remote retention policy", Parameters: []openAPIParameter{queryFlag("dry_run", "works as --dry-run"), queryFlag("orphans", "works as --orphans")}, Response: "OperationResult"},
+	{Method: "POST", Path: "/backup/verify/{name}", OperationId: "verify", Summary: "Verify local backup against checksums and sentinel files", Parameters: []openAPIParameter{nameParameter}, Response: "OperationResult"},
+	{Method: "POST", Path: "/backup/upload/{name}", OperationId: "upload", Summary: "Upload local backup to remote storage, async", Parameters: []openAPIParameter{
+		nameParameter, queryString("diff-from", "works as --diff-from"), queryString("diff-from-remote", "works as --diff-from-remote"),
+		tableParameter, excludeTablesParameter, partitionsParameter,
+		queryFlag("schema", "works as --schema"), queryFlag("resumable", "works as --resumable"), queryFlag("dry_run", "works as --dry-run"), callbackParameter,
+	}, Response: "Acknowledged"},
+	{Method: "POST", Path: "/backup/download/{name}", OperationId: "download", Summary: "Download backup from remote storage, async", Parameters: []openAPIParameter{
+		nameParameter, tableParameter, excludeTablesParameter, partitionsParameter, queryString("partitions_where", "works as --partitions-where"),
+		queryFlag("schema", "works as --schema"), queryFlag("resumable", "works as --resumable"), queryString("max_rehydration_wait", "works as --max-rehydration-wait"), callbackParameter,
+	}, Response: "Acknowledged"},
+	{Method: "POST", Path: "/backup/restore/{name}", OperationId: "restore", Summary: "Create schema and restore data from local backup, async", Parameters: []openAPIParameter{
+		nameParameter, tableParameter, excludeTablesParameter, partitionsParameter, queryString("partitions_where", "works as --partitions-where"),
+		queryString("restore_database_mapping", "works as --restore-database-mapping"), queryString("restore_table_mapping", "works as --restore-table-mapping"),
+		queryString("to_timestamp", "works as --to-timestamp"), queryString("materialized_views", "works as --materialized-views"), queryString("restore_data_mode", "works as --restore-data-mode"), queryString("convert_engines", "works as --convert-engines"), queryString("projections", "works as --projections"), queryString("restore_disk_mapping", "works as --restore-disk-mapping"),
+		queryFlag("schema", "works as --schema"), queryFlag("data", "works as --data"), queryFlag("rm", "works as --rm"), queryFlag("drop", "works as --drop"),
+		queryFlag("ignore_dependencies", "works as --ignore-dependencies"), queryFlag("preserve_uuid", "works as --preserve-uuid"),
+		queryFlag("materialize_external", "works as --materialize-external"), queryFlag("rbac", "works as --rbac"), queryFlag("configs", "works as --configs"), callbackParameter,
+	}, Response: "Acknowledged"},
+	{Method: "POST", Path: "/backup/delete/{where}/{name}", OperationId: "delete", Summary: "Delete local or remote backup", Parameters: []openAPIParameter{pathString("where", "`local` or `remote`"), nameParameter}, Response: "OperationResult"},
+	{Method: "GET", Path: "/backup/status", OperationId: "getStatus", Summary: "Status of in progress or last finished command", Response: "ActionStatus", IsArray: true},
+	{Method: "GET", Path: "/backup/bandwidth", OperationId: "getBandwidth", Summary: "Current upload and download bandwidth limits", Response: "OperationResult"},
+	{Method: "POST", Path: "/backup/bandwidth", OperationId: "setBandwidth", Summary: "Change upload and download bandwidth limits for running and next commands", Parameters: []openAPIParameter{
+		queryString("upload_max_bytes_per_second", "non negative integer, 0 means without limit"), queryString("download_max_bytes_per_second", "non negative integer, 0 means without limit"), queryFlag("reset", "restore limits from config"),
+	}, Response: "OperationResult"},
+	{Method: "GET", Path: "/backup/actions", OperationId: "getActions", Summary: "List of all commands started via API", Parameters: []openAPIParameter{queryString("filter", "substring of command"), queryString("last", "show only last N commands")}, Response: "ActionStatus", IsArray: true},
+	{Method: "POST", Path: "/backup/actions", OperationId: "postActions", Summary: "Execute commands, request body contains JSONEachRow rows with `command` field", Response: "OperationResult", IsArray: true},
+	{Method: "GET", Path: "/backup/actions/{job_id}", OperationId: "getJob", Summary: "Detailed status and progress of async command", Parameters: []openAPIParameter{pathString("job_id", "job_id from async command response")}, Response: "JobStatus"},
+	{Method: "GET", Path: "/backup/actions/{job_id}/log", OperationId: "getJobLog", Summary: "Log records of command as JSON lines", Parameters: []openAPIParameter{pathString("job_id", "job_id from async command response"), queryFlag("follow", "wait for new records until command finished")}, Response: "OperationResult", IsArray: true},
+	{Method: "POST", Path: "/backup/actions/{job_id}/cancel", OperationId: "cancelJob", Summary: "Cancel in progress or queued command, command stops after current part and cleans frozen data", Parameters: []openAPIParameter{pathString("job_id", "job_id from async command response")}, Response: "OperationResult"},
+}
+
+func openAPIObject(properties map[string]string) map[string]interface{} {
+	props := make(map[string]interface{}, len(properties))
+	for name, propertyType := range properties {
+		props[name] = map[string]interface{}{"type": propertyType}
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+var openAPISchemas = map[string]interface{}{
+	"Error":           openAPIObject(map[string]string{"status": "string", "operation": "string", "error": "string"}),
+	"OperationResult": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"status": map[string]interface{}{"type": "string"}, "operation": map[string]interface{}{"type": "string"}}, "additionalProperties": true},
+	"Acknowledged":    openAPIObject(map[string]string{"status": "string", "operation": "string", "backup_name": "string", "job_id": "integer"}),
+	"BackupListItem":  openAPIObject(map[string]string{"name": "string", "created": "string", "size": "integer", "location": "string", "required": "string", "desc": "string"}),
+	"Table":           openAPIObject(map[string]string{"Database": "string", "Name": "string", "Engine": "string", "TotalBytes": "integer", "Skip": "boolean"}),
+	"ActionStatus":    openAPIObject(map[string]string{"command": "string", "status": "string", "start": "string", "finish": "string", "error": "string", "correlation_id": "string"}),
+	"JobStatus":       openAPIObject(map[string]string{"job_id": "integer", "command": "string", "status": "string", "start": "string", "finish": "string", "error": "string", "correlation_id": "string", "progress_percent": "number", "total_bytes": "integer", "processed_bytes": "integer", "bytes_transferred": "integer"}),
+}
+
+// buildOpenAPISpec - OpenAPI 3 document for GET /openapi.json, each response body is one JSON object per line, schema describes one line
+func buildOpenAPISpec(clickhouseBackupVersion string) map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, op := range openAPIOperations {
+		parameters := make([]interface{}, 0, len(op.Parameters))
+		for _, p := range op.Parameters {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.In == "path",
+				"description": p.Description,
+				"schema":      map[string]interface{}{"type": p.Type},
+			})
+		}
+		var responseSchema interface{} = map[string]interface{}{"$ref": "#/components/schemas/" + op.Response}
+		description := "JSON object"
+		if op.IsArray {
+			responseSchema = map[string]interface{}{"type": "array", "items": responseSchema}
+			description = "JSONEachRow, one object per line"
+		}
+		content := map[string]interface{}{"application/json": map[string]interface{}{"schema": responseSchema}}
+		if op.IsText {
+			description = "plain text"
+			content = map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+		}
+		errorResponse := map[string]interface{}{
+			"description": "error",
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"}}},
+		}
+		operation := map[string]interface{}{
+			"operationId": op.OperationId,
+			"summary":     op.Summary,
+			"parameters":  parameters,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": description,
+					"content":     content,
+				},
+				"403":     errorResponse,
+				"423":     errorResponse,
+				"default": errorResponse,
+			},
+		}
+		if op.Deprecated {
+			operation["deprecated"] = true
+		}
+		pathItem, exists := paths[op.Path].(map[string]interface{})
+		if !exists {
+			pathItem = map[string]interface{}{}
+			paths[op.Path] = pathItem
+		}
+		pathItem[strings.ToLower(op.Method)] = operation
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "clickhouse-backup API",
+			"version": clickhouseBackupVersion,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": openAPISchemas,
+			"securitySchemes": map[string]interface{}{
+				"basicAuth":  map[string]interface{}{"type": "http", "scheme": "basic"},
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"basicAuth": []interface{}{}}, map[string]interface{}{"bearerAuth": []interface{}{}}},
+	}
+}
+
+// httpOpenAPIHandler - machine-readable description of all API endpoints
+func (api *APIServer) httpOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	api.sendJSONEachRow(w, http.StatusOK, buildOpenAPISpec(api.clickhouseBackupVersion))
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/gorilla/mux"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildOpenAPISpec(t *testing.T) {
+	spec := buildOpenAPISpec("test")
+	_, err := json.Marshal(spec)
+	assert.NoError(t, err)
+	paths := spec["paths"].(map[string]interface{})
+	for _, op := range openAPIOperations {
+		assert.Contains(t, paths, op.Path)
+		if !op.IsText {
+			assert.Contains(t, openAPISchemas, op.Response, op.OperationId)
+		}
+	}
+	restore := paths["/backup/restore/{name}"].(map[string]interface{})["post"].(map[string]interface{})
+	assert.Equal(t, "restore", restore["operationId"])
+	actions := paths["/backup/actions"].(map[string]interface{})
+	assert.Contains(t, actions, "get")
+	assert.Contains(t, actions, "post")
+}
+
+// TestOpenAPIMatchesRoutes - each route registered in registerHTTPHandlers shall be described in openAPIOperations and vice versa
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.API.EnableMetrics = true
+	cfg.API.EnablePprof = false
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "test")}
+	router, ok := api.registerHTTPHandlers().Handler.(*mux.Router)
+	require.True(t, ok)
+
+	routes := map[string]struct{}{}
+	require.NoError(t, router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// route without methods, like /health and /metrics, answers GET
+			methods = []string{"GET"}
+		}
+		for _, method := range methods {
+			// HEAD answers the same as GET without body
+			if method != "HEAD" {
+				routes[method+" "+path] = struct{}{}
+			}
+		}
+		return nil
+	}))
+
+	operations := map[string]struct{}{}
+	operationIds := map[string]struct{}{}
+	for _, op := range openAPIOperations {
+		operations[op.Method+" "+op.Path] = struct{}{}
+		assert.NotContains(t, operationIds, op.OperationId, "operationId shall be unique")
+		operationIds[op.OperationId] = struct{}{}
+	}
+	for route := range routes {
+		assert.Contains(t, operations, route, "route is not described in openAPIOperations")
+	}
+	for operation := range operations {
+		assert.Contains(t, routes, operation, "operation is not registered in registerHTTPHandlers")
+	}
+}
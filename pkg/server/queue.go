@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+)
+
+// startOperation - check and register operation under status lock, so concurrent requests can't exceed api->max_queue_size,
+// operation will be queued when other operation in progress and api->allow_parallel: false, api->queue_policy could reject it instead,
+// return error which wraps ErrAPILocked when operation can't run now and can't be queued
+func (api *APIServer) startOperation(command string) (int, context.Context, error) {
+	if api.config.API.AllowParallel {
+		commandId, ctx := status.Current.Start(command)
+		return commandId, ctx, nil
+	}
+	maxQueueSize := api.config.API.MaxQueueSize
+	policy := api.config.API.QueuePolicy[status.CommandName(command)]
+	if policy == "reject" {
+		maxQueueSize = 0
+	}
+	commandId, ctx, queued, err := status.Current.StartOrQueue(command, maxQueueSize, policy == "reject_duplicate")
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrAPILocked, err)
+	}
+	if queued {
+		api.log.Infof("`%s` queued, job_id=%d", command, commandId)
+	}
+	return commandId, ctx, nil
+}
+
+// waitOperationQueue - block until queued operation turns into `in progress`, return error when operation was canceled
+func (api *APIServer) waitOperationQueue(ctx context.Context, commandId int) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for !status.Current.RunQueued(commandId) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// startOperationAndWait - startOperation for synchronous handlers, block until queued operation turns into `in progress`
+func (api *APIServer) startOperationAndWait(command string) (int, context.Context, error) {
+	commandId, ctx, err := api.startOperation(command)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err = api.waitOperationQueue(ctx, commandId); err != nil {
+		status.Current.Stop(commandId, err)
+		return 0, nil, err
+	}
+	return commandId, ctx, nil
+}
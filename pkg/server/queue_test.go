@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartOperationQueuePolicy(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.API.MaxQueueSize = 2
+	cfg.API.QueuePolicy = map[string]string{"create": "reject_duplicate", "delete": "reject"}
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "test")}
+
+	createId, _, err := api.startOperation("create backup1")
+	require.NoError(t, err)
+	defer status.Current.Stop(createId, nil)
+	_, _, err = api.startOperation("create backup2")
+	assert.True(t, errors.Is(err, ErrAPILocked), "second create shall be rejected with reject_duplicate policy")
+	_, _, err = api.startOperation("delete local backup0")
+	assert.True(t, errors.Is(err, ErrAPILocked), "delete shall be rejected with reject policy")
+
+	uploadId, _, err := api.startOperation("upload backup1")
+	require.NoError(t, err)
+	defer status.Current.Stop(uploadId, nil)
+	downloadId, _, err := api.startOperation("download backup0")
+	require.NoError(t, err)
+	defer status.Current.Stop(downloadId, nil)
+	_, _, err = api.startOperation("restore backup0")
+	assert.True(t, errors.Is(err, ErrAPILocked), "queue shall not exceed api->max_queue_size")
+
+	cfg.API.AllowParallel = true
+	restoreId, _, err := api.startOperation("restore backup0")
+	require.NoError(t, err)
+	status.Current.Stop(restoreId, nil)
+}
+
+func TestStartOperationAndWait(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.API.MaxQueueSize = 1
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "test")}
+
+	createId, _, err := api.startOperation("create backup1")
+	require.NoError(t, err)
+	started := make(chan int)
+	go func() {
+		deleteId, _, err := api.startOperationAndWait("delete local backup0")
+		assert.NoError(t, err)
+		started <- deleteId
+	}()
+	select {
+	case <-started:
+		t.Fatal("delete shall wait while create in progress")
+	case <-time.After(300 * time.Millisecond):
+	}
+	status.Current.Stop(createId, nil)
+	select {
+	case deleteId := <-started:
+		status.Current.Stop(deleteId, nil)
+	case <-time.After(5 * time.Second):
+		t.Fatal("delete shall start after create finished")
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+)
+
+// Reload - SIGHUP and api->config_reload_interval, apply new config without canceling running commands,
+// running commands and scheduled jobs keep config which was active when they started, HTTP server restarts only when listen or TLS settings changed
+func (api *APIServer) Reload() error {
+	log := api.log.WithField("logger", "server.Reload")
+	oldCfg := api.config
+	cfg, err := api.ReloadConfig(nil, "reload")
+	if err != nil {
+		return err
+	}
+	if isScheduleChanged(oldCfg, cfg) {
+		if err = api.restartSchedule(cfg); err != nil {
+			return err
+		}
+		log.Infof("schedule reloaded, %d jobs", len(api.scheduledJobs))
+	}
+	if isHTTPServerChanged(oldCfg, cfg) {
+		if err = api.restartHTTPServer(); err != nil {
+			return err
+		}
+		log.Infof("HTTP server restarted on %s", cfg.API.ListenAddr)
+	}
+	return nil
+}
+
+func isScheduleChanged(oldCfg, cfg *config.Config) bool {
+	return !reflect.DeepEqual(oldCfg.Schedule, cfg.Schedule)
+}
+
+// isHTTPServerChanged - settings which applied only during registerHTTPHandlers and ListenAndServe, credentials and roles are read on each request
+func isHTTPServerChanged(oldCfg, cfg *config.Config) bool {
+	o, n := oldCfg.API, cfg.API
+	return o.ListenAddr != n.ListenAddr || o.Secure != n.Secure || o.CertificateFile != n.CertificateFile || o.PrivateKeyFile != n.PrivateKeyFile ||
+		o.CACertFile != n.CACertFile || o.EnableMetrics != n.EnableMetrics || o.EnablePprof != n.EnablePprof
+}
+
+func (api *APIServer) startSchedule() {
+	if len(api.scheduledJobs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	api.scheduleCancel = cancel
+	go api.RunSchedule(ctx)
+}
+
+// restartSchedule - wrong `schedule` section keeps previous jobs, running jobs finish with previous config
+func (api *APIServer) restartSchedule(cfg *config.Config) error {
+	jobs, err := prepareScheduledJobs(cfg)
+	if err != nil {
+		return fmt.Errorf("keep previous schedule: %v", err)
+	}
+	if api.scheduleCancel != nil {
+		api.scheduleCancel()
+		api.scheduleCancel = nil
+	}
+	api.scheduledJobs = jobs
+	api.startSchedule()
+	return nil
+}
+
+// WatchConfig - poll config file modification time and size, api->config_reload_interval could be changed by reload itself, 0s stops watching
+func (api *APIServer) WatchConfig(ctx context.Context) {
+	log := api.log.WithField("logger", "server.WatchConfig")
+	lastModTime, lastSize := time.Time{}, int64(-1)
+	if info, err := os.Stat(api.configPath); err == nil {
+		lastModTime, lastSize = info.ModTime(), info.Size()
+	}
+	for {
+		interval := api.config.API.ConfigReloadDuration
+		if interval <= 0 {
+			log.Info("api->config_reload_interval is 0s, stop watching config file")
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		info, err := os.Stat(api.configPath)
+		if err != nil {
+			log.Warnf("can't stat %s: %v", api.configPath, err)
+			continue
+		}
+		if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+			continue
+		}
+		lastModTime, lastSize = info.ModTime(), info.Size()
+		if err = api.Reload(); err != nil {
+			log.Errorf("can't reload changed %s: %v", api.configPath, err)
+			continue
+		}
+		log.Infof("reloaded changed %s", api.configPath)
+	}
+}
+
+// httpConfigHandler - active config with credentials replaced by `******`
+func (api *APIServer) httpConfigHandler(w http.ResponseWriter, _ *http.Request) {
+	redacted, err := api.config.Redacted()
+	if err != nil {
+		api.writeError(w, http.StatusInternalServerError, "config", err)
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, redacted)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpConfigHandlerRedactSecrets(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ClickHouse.Password = "ch-password"
+	cfg.S3.AccessKey = "s3-access"
+	cfg.S3.SecretKey = "s3-secret"
+	cfg.API.Tokens = map[string]string{"token-secret": config.APIRoleReadOnly}
+	cfg.Mirrors = []config.MirrorConfig{{"name": "gcs", "gcs": map[string]interface{}{"credentials_json": "{}", "bucket": "mirror"}}}
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "test")}
+
+	w := httptest.NewRecorder()
+	api.httpConfigHandler(w, httptest.NewRequest("GET", "/config", nil))
+	body := w.Body.String()
+	for _, secret := range []string{"ch-password", "s3-access", "s3-secret", "token-secret"} {
+		assert.NotContains(t, body, secret)
+	}
+	result := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, config.RedactedValue, result["clickhouse"].(map[string]interface{})["password"])
+	assert.Equal(t, config.RedactedValue, result["api"].(map[string]interface{})["tokens"])
+	assert.Equal(t, "", result["ftp"].(map[string]interface{})["password"])
+	mirror := result["upload_mirrors"].([]interface{})[0].(map[string]interface{})["gcs"].(map[string]interface{})
+	assert.Equal(t, config.RedactedValue, mirror["credentials_json"])
+	assert.Equal(t, "mirror", mirror["bucket"])
+}
+
+func TestReloadChanges(t *testing.T) {
+	oldCfg, cfg := config.DefaultConfig(), config.DefaultConfig()
+	cfg.General.BackupsToKeepRemote = 10
+	cfg.S3.SecretKey = "new-secret"
+	assert.False(t, isHTTPServerChanged(oldCfg, cfg))
+	assert.False(t, isScheduleChanged(oldCfg, cfg))
+
+	cfg.API.ListenAddr = "0.0.0.0:7171"
+	assert.True(t, isHTTPServerChanged(oldCfg, cfg))
+	cfg.Schedule.Jobs = []config.ScheduleJobConfig{{Name: "daily", Cron: "@daily", Command: "create_remote"}}
+	assert.True(t, isScheduleChanged(oldCfg, cfg))
+
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "test")}
+	require.NoError(t, api.restartSchedule(cfg))
+	require.Len(t, api.scheduledJobs, 1)
+	cancel := api.scheduleCancel
+	require.NotNil(t, cancel)
+
+	invalidCfg := config.DefaultConfig()
+	invalidCfg.Schedule.Jobs = []config.ScheduleJobConfig{{Name: "broken", Cron: "wrong", Command: "create"}}
+	assert.Error(t, api.restartSchedule(invalidCfg))
+	assert.Equal(t, "daily", api.scheduledJobs[0].Name)
+	api.scheduleCancel()
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	apexLog "github.com/apex/log"
+	"github.com/google/shlex"
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule - standard 5 fields cron expression `minute hour day-of-month month day-of-week`, each field stored as bitset
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+func parseCronExpression(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, exists := cronMacros[expr]; exists {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression `%s`, expected 5 fields `minute hour day-of-month month day-of-week`", expr)
+	}
+	s := &cronSchedule{
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute in `%s`: %v", expr, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour in `%s`: %v", expr, err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month in `%s`: %v", expr, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month in `%s`: %v", expr, err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week in `%s`: %v", expr, err)
+	}
+	// 7 and 0 both mean Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField - parse `*`, `*/step`, `value`, `from-to`, `from-to/step` and comma separated list of them
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if stepIdx := strings.Index(item, "/"); stepIdx != -1 {
+			var err error
+			if step, err = strconv.Atoi(item[stepIdx+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in `%s`", item)
+			}
+			item = item[:stepIdx]
+		}
+		from, to := min, max
+		if item != "*" && item != "?" {
+			var err error
+			rangeValues := strings.SplitN(item, "-", 2)
+			if from, err = strconv.Atoi(rangeValues[0]); err != nil {
+				return 0, fmt.Errorf("invalid value `%s`", item)
+			}
+			to = from
+			if len(rangeValues) == 2 {
+				if to, err = strconv.Atoi(rangeValues[1]); err != nil {
+					return 0, fmt.Errorf("invalid value `%s`", item)
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("`%s` out of range %d-%d", item, min, max)
+		}
+		for i := from; i <= to; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	// when both day-of-month and day-of-week restricted, cron runs when either field matches
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next - return first time after `t` which match cron expression, zero time when nothing match during 5 years
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+	for t.Year() <= yearLimit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+var scheduleTimeMacroRE = regexp.MustCompile(`{time:([^}]+)}`)
+
+// scheduledJob - one item from `schedule->jobs`, contains last run status for GET /backup/schedule and metrics
+type scheduledJob struct {
+	config.ScheduleJobConfig
+	schedule   *cronSchedule
+	jitter     time.Duration
+	mx         sync.Mutex
+	running    bool
+	nextRun    time.Time
+	lastStart  time.Time
+	lastFinish time.Time
+	lastStatus string
+	lastError  string
+}
+
+type scheduledJobStatus struct {
+	Name       string `json:"name"`
+	Cron       string `json:"cron"`
+	Command    string `json:"command"`
+	Running    bool   `json:"running"`
+	NextRun    string `json:"next_run,omitempty"`
+	LastStart  string `json:"last_start,omitempty"`
+	LastFinish string `json:"last_finish,omitempty"`
+	LastStatus string `json:"last_status,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+func formatScheduleTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(common.TimeFormat)
+}
+
+func (job *scheduledJob) getStatus() scheduledJobStatus {
+	job.mx.Lock()
+	defer job.mx.Unlock()
+	return scheduledJobStatus{
+		Name:       job.Name,
+		Cron:       job.Cron,
+		Command:    job.Command,
+		Running:    job.running,
+		NextRun:    formatScheduleTime(job.nextRun),
+		LastStart:  formatScheduleTime(job.lastStart),
+		LastFinish: formatScheduleTime(job.lastFinish),
+		LastStatus: job.lastStatus,
+		LastError:  job.lastError,
+	}
+}
+
+// prepareScheduledJobs - parse `schedule->jobs`, commands which never finish are not allowed
+func prepareScheduledJobs(cfg *config.Config) ([]*scheduledJob, error) {
+	jobs := make([]*scheduledJob, len(cfg.Schedule.Jobs))
+	for i, jobConfig := range cfg.Schedule.Jobs {
+		if jobConfig.Name == "" {
+			jobConfig.Name = fmt.Sprintf("job%d", i)
+		}
+		schedule, err := parseCronExpression(jobConfig.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule->jobs[%s]: %v", jobConfig.Name, err)
+		}
+		args, err := shlex.Split(jobConfig.Command)
+		if err != nil || len(args) == 0 {
+			return nil, fmt.Errorf("schedule->jobs[%s]: invalid command `%s`", jobConfig.Name, jobConfig.Command)
+		}
+		if args[0] == "server" || args[0] == "watch" {
+			return nil, fmt.Errorf("schedule->jobs[%s]: `%s` command can't be scheduled", jobConfig.Name, args[0])
+		}
+		var jitter time.Duration
+		if jobConfig.Jitter != "" {
+			if jitter, err = time.ParseDuration(jobConfig.Jitter); err != nil {
+				return nil, fmt.Errorf("schedule->jobs[%s]: invalid jitter: %v", jobConfig.Name, err)
+			}
+		}
+		jobs[i] = &scheduledJob{ScheduleJobConfig: jobConfig, schedule: schedule, jitter: jitter}
+	}
+	return jobs, nil
+}
+
+// RunSchedule - run each job from `schedule->jobs` according to cron expression, next run of the same job skipped while previous is still running
+func (api *APIServer) RunSchedule(ctx context.Context) {
+	log := api.log.WithField("logger", "RunSchedule")
+	log.Infof("start %d scheduled jobs", len(api.scheduledJobs))
+	var wg sync.WaitGroup
+	for _, job := range api.scheduledJobs {
+		wg.Add(1)
+		go func(job *scheduledJob) {
+			defer wg.Done()
+			api.runScheduledJob(ctx, job, log.WithField("job", job.Name))
+		}(job)
+	}
+	wg.Wait()
+}
+
+func (api *APIServer) runScheduledJob(ctx context.Context, job *scheduledJob, log *apexLog.Entry) {
+	for {
+		nextRun := job.schedule.Next(time.Now())
+		if nextRun.IsZero() {
+			log.Warnf("cron `%s` doesn't match any time, stop job", job.Cron)
+			return
+		}
+		if job.jitter > 0 {
+			nextRun = nextRun.Add(time.Duration(rand.Int63n(int64(job.jitter))))
+		}
+		job.mx.Lock()
+		job.nextRun = nextRun
+		job.mx.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(nextRun)):
+		}
+		job.mx.Lock()
+		if job.running {
+			job.mx.Unlock()
+			log.Warnf("previous run still in progress, skip")
+			continue
+		}
+		job.running = true
+		job.mx.Unlock()
+		go api.executeScheduledJob(job, log)
+	}
+}
+
+func (api *APIServer) executeScheduledJob(job *scheduledJob, log *apexLog.Entry) {
+	startTime := time.Now()
+	var err error
+	defer func() {
+		job.mx.Lock()
+		job.running = false
+		job.lastFinish = time.Now()
+		job.lastStatus = status.SuccessStatus
+		job.lastError = ""
+		if err != nil {
+			job.lastStatus = status.ErrorStatus
+			job.lastError = err.Error()
+		}
+		job.mx.Unlock()
+		if api.config.API.EnableMetrics {
+			api.metrics.ScheduleLastFinish.WithLabelValues(job.Name).Set(float64(time.Now().Unix()))
+			api.metrics.ScheduleLastDuration.WithLabelValues(job.Name).Set(float64(time.Since(startTime).Nanoseconds()))
+			if err != nil {
+				api.metrics.ScheduleLastStatus.WithLabelValues(job.Name).Set(0)
+			} else {
+				api.metrics.ScheduleLastStatus.WithLabelValues(job.Name).Set(1)
+			}
+		}
+	}()
+	job.mx.Lock()
+	job.lastStart = startTime
+	job.mx.Unlock()
+	command := scheduleTimeMacroRE.ReplaceAllStringFunc(job.Command, func(macro string) string {
+		return startTime.Format(scheduleTimeMacroRE.FindStringSubmatch(macro)[1])
+	})
+	args, _ := shlex.Split(command)
+	commandId, _, err := api.startOperationAndWait(command)
+	if err != nil {
+		log.Warnf("skip `%s`: %v", command, err)
+		return
+	}
+	log.Infof("run `%s`", command)
+	run := func() error {
+		return api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
+	}
+	if _, isMeasured := api.metrics.LastStart[args[0]]; isMeasured {
+		err, _ = api.metrics.ExecuteWithMetrics(args[0], 0, run)
+	} else {
+		err = run()
+	}
+	status.Current.Stop(commandId, err)
+	if err != nil {
+		log.Errorf("`%s` return error: %v", command, err)
+		return
+	}
+	log.Infof("`%s` done", command)
+	if err := api.UpdateBackupMetrics(context.Background(), args[0] == "create" || args[0] == "restore"); err != nil {
+		log.Errorf("UpdateBackupMetrics return error: %v", err)
+	}
+}
+
+// httpScheduleHandler - display scheduled jobs with next run time and last run status
+func (api *APIServer) httpScheduleHandler(w http.ResponseWriter, _ *http.Request) {
+	jobsStatus := make([]scheduledJobStatus, len(api.scheduledJobs))
+	for i, job := range api.scheduledJobs {
+		jobsStatus[i] = job.getStatus()
+	}
+	api.sendJSONEachRow(w, http.StatusOK, jobsStatus)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	from := time.Date(2023, time.March, 31, 10, 17, 30, 0, time.UTC)
+	testCases := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2023, time.March, 31, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, time.March, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2023, time.April, 1, 3, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2023, time.March, 31, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * 7", time.Date(2023, time.April, 2, 2, 30, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2023, time.May, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 1 1,15 * 1-5", time.Date(2023, time.April, 1, 1, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range testCases {
+		schedule, err := parseCronExpression(tc.expr)
+		if err != nil {
+			t.Fatalf("parseCronExpression(%s) return error: %v", tc.expr, err)
+		}
+		if next := schedule.Next(from); !next.Equal(tc.expected) {
+			t.Errorf("`%s` expected next run %s, got %s", tc.expr, tc.expected, next)
+		}
+	}
+	for _, invalidExpr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 x"} {
+		if _, err := parseCronExpression(invalidExpr); err == nil {
+			t.Errorf("parseCronExpression(%s) expected error", invalidExpr)
+		}
+	}
+}
@@ -26,9 +26,12 @@ import (
 	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
 	"github.com/Altinity/clickhouse-backup/pkg/common"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/partition"
 	"github.com/Altinity/clickhouse-backup/pkg/resumable"
 	"github.com/Altinity/clickhouse-backup/pkg/server/metrics"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
 	"github.com/Altinity/clickhouse-backup/pkg/utils"
 
 	apexLog "github.com/apex/log"
@@ -49,6 +52,8 @@ type APIServer struct {
 	log                     *apexLog.Entry
 	routes                  []string
 	clickhouseBackupVersion string
+	scheduledJobs           []*scheduledJob
+	scheduleCancel          context.CancelFunc
 }
 
 var (
@@ -98,6 +103,16 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 		}
 	}
 	api.metrics.RegisterMetrics()
+	storage.SetOperationMetrics(metrics.StorageMetrics{})
+	// keep log records of commands started via API for GET /backup/actions/{job_id}/log
+	if logger, ok := apexLog.Log.(*apexLog.Logger); ok {
+		if _, isWrapped := logger.Handler.(*status.LogHandler); !isWrapped {
+			logger.Handler = status.NewLogHandler(logger.Handler)
+		}
+	}
+	if api.scheduledJobs, err = prepareScheduledJobs(cfg); err != nil {
+		return err
+	}
 
 	log.Infof("Starting API server on %s", api.config.API.ListenAddr)
 	sigterm := make(chan os.Signal, 1)
@@ -114,6 +129,13 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 			}
 		}()
 	}
+	// crashed commands leave frozen data in shadow folders, without api->clean_orphaned_after_restart only report it
+	go func() {
+		b := backup.NewBackuper(api.config, backup.WithDryRun(!api.config.API.CleanOrphanedAfterRestart))
+		if err := b.CleanOrphaned(context.Background()); err != nil {
+			log.Warnf("CleanOrphaned return error: %v", err)
+		}
+	}()
 
 	go func() {
 		if err := api.UpdateBackupMetrics(context.Background(), false); err != nil {
@@ -121,10 +143,20 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 		}
 	}()
 
+	if api.config.API.EnableMetrics && api.config.API.InventoryScanDuration > 0 && api.config.General.RemoteStorage != "none" && api.config.General.RemoteStorage != "custom" {
+		go api.RunRemoteInventory(context.Background())
+	}
+
+	api.startSchedule()
+
 	if cliCtx.Bool("watch") {
 		go api.RunWatch(cliCtx)
 	}
 
+	if api.config.API.ConfigReloadDuration > 0 {
+		go api.WatchConfig(context.Background())
+	}
+
 	for {
 		select {
 		case <-api.restart:
@@ -134,8 +166,8 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 			}
 			log.Infof("Reloaded by HTTP")
 		case <-sighup:
-			if err := api.Restart(); err != nil {
-				log.Errorf("Failed to restarting API server: %v", err)
+			if err := api.Reload(); err != nil {
+				log.Errorf("Failed to reload config: %v", err)
 				continue
 			}
 			log.Info("Reloaded by SIGHUP")
@@ -162,19 +194,39 @@ func (api *APIServer) RunWatch(cliCtx *cli.Context) {
 	status.Current.Stop(commandId, err)
 }
 
-// Stop cancel all running commands, @todo think about graceful period
+// Stop cancel all running commands and wait up to `--shutdown-timeout` while they finish current part, save resumable state and clean frozen data
 func (api *APIServer) Stop() error {
 	status.Current.CancelAll("canceled during server stop")
+	shutdownTimeout := api.cliCtx.Duration("shutdown-timeout")
+	if shutdownTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := status.Current.WaitFinished(ctx); err != nil {
+			api.log.Warnf("commands don't stop after --shutdown-timeout=%s: %v", shutdownTimeout, err)
+		}
+		cancel()
+	}
 	return api.server.Close()
 }
 
 func (api *APIServer) Restart() error {
-	log := apexLog.WithField("logger", "server.Restart")
-	_, err := api.ReloadConfig(nil, "restart")
+	oldCfg := api.config
+	cfg, err := api.ReloadConfig(nil, "restart")
 	if err != nil {
 		return err
 	}
 	status.Current.CancelAll("canceled via API /restart")
+	if oldCfg != nil && isScheduleChanged(oldCfg, cfg) {
+		if err = api.restartSchedule(cfg); err != nil {
+			return err
+		}
+	}
+	return api.restartHTTPServer()
+}
+
+// restartHTTPServer - close current connections and listen with current api section of config, running commands are not canceled
+func (api *APIServer) restartHTTPServer() error {
+	log := apexLog.WithField("logger", "server.Restart")
+	var err error
 	if api.server != nil {
 		_ = api.server.Close()
 	}
@@ -219,25 +271,35 @@ func (api *APIServer) registerHTTPHandlers() *http.Server {
 	})
 
 	r.HandleFunc("/", api.httpRootHandler).Methods("GET", "HEAD")
-	r.HandleFunc("/", api.httpRestartHandler).Methods("POST")
-	r.HandleFunc("/restart", api.httpRestartHandler).Methods("POST", "GET")
-	r.HandleFunc("/backup/kill", api.httpKillHandler).Methods("POST", "GET")
-	r.HandleFunc("/backup/watch", api.httpWatchHandler).Methods("POST", "GET")
+	r.HandleFunc("/openapi.json", api.httpOpenAPIHandler).Methods("GET")
+	r.HandleFunc("/", api.adminGuard(api.httpRestartHandler)).Methods("POST")
+	r.HandleFunc("/restart", api.adminGuard(api.httpRestartHandler)).Methods("POST", "GET")
+	r.HandleFunc("/backup/kill", api.operatorGuard(api.httpKillHandler)).Methods("POST", "GET")
+	r.HandleFunc("/backup/schedule", api.httpScheduleHandler).Methods("GET")
+	r.HandleFunc("/config", api.httpConfigHandler).Methods("GET")
+	r.HandleFunc("/backup/watch", api.operatorGuard(api.httpWatchHandler)).Methods("POST", "GET")
 	r.HandleFunc("/backup/tables", api.httpTablesHandler).Methods("GET")
 	r.HandleFunc("/backup/tables/all", api.httpTablesHandler).Methods("GET")
 	r.HandleFunc("/backup/list", api.httpListHandler).Methods("GET", "HEAD")
 	r.HandleFunc("/backup/list/{where}", api.httpListHandler).Methods("GET")
-	r.HandleFunc("/backup/create", api.httpCreateHandler).Methods("POST")
-	r.HandleFunc("/backup/clean", api.httpCleanHandler).Methods("POST")
-	r.HandleFunc("/backup/clean/remote_broken", api.httpCleanRemoteBrokenHandler).Methods("POST")
-	r.HandleFunc("/backup/upload/{name}", api.httpUploadHandler).Methods("POST")
-	r.HandleFunc("/backup/download/{name}", api.httpDownloadHandler).Methods("POST")
-	r.HandleFunc("/backup/restore/{name}", api.httpRestoreHandler).Methods("POST")
-	r.HandleFunc("/backup/delete/{where}/{name}", api.httpDeleteHandler).Methods("POST")
+	r.HandleFunc("/backup/create", api.operatorGuard(api.httpCreateHandler)).Methods("POST")
+	r.HandleFunc("/backup/clean", api.operatorGuard(api.httpCleanHandler)).Methods("POST")
+	r.HandleFunc("/backup/clean/remote_broken", api.adminGuard(api.httpCleanRemoteBrokenHandler)).Methods("POST")
+	r.HandleFunc("/backup/clean/remote", api.adminGuard(api.httpCleanRemoteHandler)).Methods("POST")
+	r.HandleFunc("/backup/verify/{name}", api.operatorGuard(api.httpVerifyHandler)).Methods("POST")
+	r.HandleFunc("/backup/upload/{name}", api.operatorGuard(api.httpUploadHandler)).Methods("POST")
+	r.HandleFunc("/backup/download/{name}", api.operatorGuard(api.httpDownloadHandler)).Methods("POST")
+	r.HandleFunc("/backup/restore/{name}", api.operatorGuard(api.httpRestoreHandler)).Methods("POST")
+	r.HandleFunc("/backup/delete/{where}/{name}", api.adminGuard(api.httpDeleteHandler)).Methods("POST")
 	r.HandleFunc("/backup/status", api.httpBackupStatusHandler).Methods("GET")
+	r.HandleFunc("/backup/bandwidth", api.httpBandwidthHandler).Methods("GET")
+	r.HandleFunc("/backup/bandwidth", api.operatorGuard(api.httpBandwidthHandler)).Methods("POST")
 
 	r.HandleFunc("/backup/actions", api.actionsLog).Methods("GET", "HEAD")
-	r.HandleFunc("/backup/actions", api.actions).Methods("POST")
+	r.HandleFunc("/backup/actions", api.operatorGuard(api.actions)).Methods("POST")
+	r.HandleFunc("/backup/actions/{job_id}", api.actionsJobHandler).Methods("GET")
+	r.HandleFunc("/backup/actions/{job_id}/log", api.actionsJobLogHandler).Methods("GET")
+	r.HandleFunc("/backup/actions/{job_id}/cancel", api.operatorGuard(api.actionsJobCancelHandler)).Methods("POST")
 
 	var routes []string
 	if err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
@@ -281,16 +343,9 @@ func (api *APIServer) basicAuthMiddleware(next http.Handler) http.Handler {
 		} else {
 			api.log.Debugf("API call %s %s", r.Method, r.URL.Path)
 		}
-		user, pass, _ := r.BasicAuth()
-		query := r.URL.Query()
-		if u, exist := query["user"]; exist {
-			user = u[0]
-		}
-		if p, exist := query["pass"]; exist {
-			pass = p[0]
-		}
-		if (user != api.config.API.Username) || (pass != api.config.API.Password) {
-			api.log.Warnf("%s %s Authorization failed %s:%s", r.Method, r.URL, user, pass)
+		role, principal, ok := api.authenticate(r)
+		if !ok {
+			api.log.Warnf("%s %s Authorization failed %s", r.Method, r.URL, principal)
 			w.Header().Set("WWW-Authenticate", "Basic realm=\"Provide username and password\"")
 			w.WriteHeader(http.StatusUnauthorized)
 			if _, err := w.Write([]byte("401 Unauthorized\n")); err != nil {
@@ -298,13 +353,15 @@ func (api *APIServer) basicAuthMiddleware(next http.Handler) http.Handler {
 			}
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(withAPIRole(r.Context(), role)))
 	})
 }
 
 type actionsResultsRow struct {
 	Status    string `json:"status"`
 	Operation string `json:"operation"`
+	// JobId - for async commands, allow poll GET /backup/actions/{job_id}
+	JobId *int `json:"job_id,omitempty"`
 }
 
 // CREATE TABLE system.backup_actions (command String, start DateTime, finish DateTime, status String, error String) ENGINE=URL('http://127.0.0.1:7171/backup/actions?user=user&pass=pass', JSONEachRow)
@@ -338,16 +395,28 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		command := args[0]
+		if (command == "delete" || command == "clean_remote_broken") && !api.isRoleAllowed(r, config.APIRoleAdmin) {
+			api.writeError(w, http.StatusForbidden, row.Command, fmt.Errorf("403 Forbidden, required role `%s`", config.APIRoleAdmin))
+			return
+		}
 		switch command {
 		// watch command can't be run via cli app.Run, need parsing args
 		case "watch":
 			actionsResults, err = api.actionsWatchHandler(w, row, args, actionsResults)
+			if errors.Is(err, ErrAPILocked) {
+				api.writeError(w, http.StatusLocked, row.Command, err)
+				return
+			}
 			if err != nil {
 				api.writeError(w, http.StatusInternalServerError, row.Command, err)
 				return
 			}
 		case "clean_remote_broken":
 			actionsResults, err = api.actionsCleanRemoteBrokenHandler(w, row, command, actionsResults)
+			if errors.Is(err, ErrAPILocked) {
+				api.writeError(w, http.StatusLocked, row.Command, err)
+				return
+			}
 			if err != nil {
 				api.writeError(w, http.StatusInternalServerError, row.Command, err)
 				return
@@ -360,12 +429,20 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 			}
 		case "create", "restore", "upload", "download", "create_remote", "restore_remote":
 			actionsResults, err = api.actionsAsyncCommandsHandler(command, args, row, actionsResults)
+			if errors.Is(err, ErrAPILocked) {
+				api.writeError(w, http.StatusLocked, row.Command, err)
+				return
+			}
 			if err != nil {
 				api.writeError(w, http.StatusInternalServerError, row.Command, err)
 				return
 			}
 		case "delete":
 			actionsResults, err = api.actionsDeleteHandler(row, args, actionsResults)
+			if errors.Is(err, ErrAPILocked) {
+				api.writeError(w, http.StatusLocked, row.Command, err)
+				return
+			}
 			if err != nil {
 				api.writeError(w, http.StatusInternalServerError, row.Command, err)
 				return
@@ -379,11 +456,11 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *APIServer) actionsDeleteHandler(row status.ActionRow, args []string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		return actionsResults, ErrAPILocked
+	commandId, _, err := api.startOperationAndWait(row.Command)
+	if err != nil {
+		return actionsResults, err
 	}
-	commandId, _ := status.Current.Start(row.Command)
-	err := api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
+	err = api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
 	status.Current.Stop(commandId, err)
 	if err != nil {
 		return actionsResults, err
@@ -402,13 +479,19 @@ func (api *APIServer) actionsDeleteHandler(row status.ActionRow, args []string,
 }
 
 func (api *APIServer) actionsAsyncCommandsHandler(command string, args []string, row status.ActionRow, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		return actionsResults, ErrAPILocked
-	}
 	// to avoid race condition between GET /backup/actions and POST /backup/actions
-	commandId, _ := status.Current.Start(row.Command)
+	commandId, ctx, err := api.startOperation(row.Command)
+	if err != nil {
+		return actionsResults, err
+	}
 	go func() {
-		err, _ := api.metrics.ExecuteWithMetrics(command, 0, func() error {
+		if err := api.waitOperationQueue(ctx, commandId); err != nil {
+			api.log.Errorf("API /backup/actions error: %v", err)
+			status.Current.Stop(commandId, err)
+			return
+		}
+		dryRun := hasDryRunArg(args)
+		err := api.executeWithMetrics(command, dryRun, func() error {
 			return api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
 		})
 		status.Current.Stop(commandId, err)
@@ -416,6 +499,9 @@ func (api *APIServer) actionsAsyncCommandsHandler(command string, args []string,
 			api.log.Errorf("API /backup/actions error: %v", err)
 			return
 		}
+		if dryRun {
+			return
+		}
 		go func() {
 			if err := api.UpdateBackupMetrics(context.Background(), command == "create" || command == "restore"); err != nil {
 				api.log.Errorf("UpdateBackupMetrics return error: %v", err)
@@ -425,10 +511,30 @@ func (api *APIServer) actionsAsyncCommandsHandler(command string, args []string,
 	actionsResults = append(actionsResults, actionsResultsRow{
 		Status:    "acknowledged",
 		Operation: row.Command,
+		JobId:     &commandId,
 	})
 	return actionsResults, nil
 }
 
+// hasDryRunArg - `--dry-run` in command line of POST /backup/actions
+func hasDryRunArg(args []string) bool {
+	for _, arg := range args {
+		if arg == "--dry-run" || arg == "--dry-run=true" || arg == "--dry-run=1" {
+			return true
+		}
+	}
+	return false
+}
+
+// executeWithMetrics - dry run doesn't create or upload anything, so it shall not change success, failure and last finish metrics
+func (api *APIServer) executeWithMetrics(command string, dryRun bool, f func() error) error {
+	if dryRun {
+		return f()
+	}
+	err, _ := api.metrics.ExecuteWithMetrics(command, 0, f)
+	return err
+}
+
 func (api *APIServer) actionsKillHandler(row status.ActionRow, args []string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
 	if len(args) <= 1 {
 		return actionsResults, errors.New("kill <command> parameter empty")
@@ -448,11 +554,11 @@ func (api *APIServer) actionsKillHandler(row status.ActionRow, args []string, ac
 }
 
 func (api *APIServer) actionsCleanRemoteBrokenHandler(w http.ResponseWriter, row status.ActionRow, command string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Warn(ErrAPILocked.Error())
-		return actionsResults, ErrAPILocked
+	commandId, ctx, err := api.startOperationAndWait(command)
+	if err != nil {
+		api.log.Warn(err.Error())
+		return actionsResults, err
 	}
-	commandId, ctx := status.Current.Start(command)
 	cfg, err := api.ReloadConfig(w, "clean_remote_broken")
 	if err != nil {
 		status.Current.Stop(commandId, err)
@@ -479,7 +585,7 @@ func (api *APIServer) actionsCleanRemoteBrokenHandler(w http.ResponseWriter, row
 }
 
 func (api *APIServer) actionsWatchHandler(w http.ResponseWriter, row status.ActionRow, args []string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if (!api.config.API.AllowParallel && status.Current.InProgress()) || status.Current.CheckCommandInProgress(row.Command) {
+	if status.Current.CheckCommandInProgress(row.Command) {
 		api.log.Info(ErrAPILocked.Error())
 		return actionsResults, ErrAPILocked
 	}
@@ -518,13 +624,19 @@ func (api *APIServer) actionsWatchHandler(w http.ResponseWriter, row status.Acti
 	}
 	for i := range args {
 		matchParam := false
-		if matchParam, watchInterval = simpleParseArg(i, args, "--watch-interval"); matchParam {
-			fullCommand = fmt.Sprintf("%s --watch-interval=\"%s\"", fullCommand, watchInterval)
+		// --incremental-interval is alias for --watch-interval, don't assign parsed value for not matched args to avoid reset intervals from previous args
+		for _, intervalArg := range []string{"--watch-interval", "--incremental-interval"} {
+			if matchParam, interval := simpleParseArg(i, args, intervalArg); matchParam {
+				watchInterval = interval
+				fullCommand = fmt.Sprintf("%s --watch-interval=\"%s\"", fullCommand, watchInterval)
+			}
 		}
-		if matchParam, fullInterval = simpleParseArg(i, args, "--full-interval"); matchParam {
+		if matchParam, interval := simpleParseArg(i, args, "--full-interval"); matchParam {
+			fullInterval = interval
 			fullCommand = fmt.Sprintf("%s --full-interval=\"%s\"", fullCommand, fullInterval)
 		}
-		if matchParam, watchBackupNameTemplate = simpleParseArg(i, args, "--watch-backup-name-template"); matchParam {
+		if matchParam, template := simpleParseArg(i, args, "--watch-backup-name-template"); matchParam {
+			watchBackupNameTemplate = template
 			fullCommand = fmt.Sprintf("%s --watch-backup-name-template=\"%s\"", fullCommand, watchBackupNameTemplate)
 		}
 		if matchParam, tablePattern = simpleParseArg(i, args, "--tables"); matchParam {
@@ -552,8 +664,17 @@ func (api *APIServer) actionsWatchHandler(w http.ResponseWriter, row status.Acti
 		}
 	}
 
-	commandId, _ := status.Current.Start(fullCommand)
+	commandId, ctx, err := api.startOperation(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		return actionsResults, err
+	}
 	go func() {
+		if err := api.waitOperationQueue(ctx, commandId); err != nil {
+			api.log.Errorf("Watch error: %v", err)
+			status.Current.Stop(commandId, err)
+			return
+		}
 		b := backup.NewBackuper(cfg)
 		err := b.Watch(watchInterval, fullInterval, watchBackupNameTemplate, tablePattern, partitionsToBackup, schemaOnly, rbacOnly, configsOnly, skipCheckPartsColumns, api.clickhouseBackupVersion, commandId, api.GetMetrics(), api.cliCtx)
 		defer status.Current.Stop(commandId, err)
@@ -589,6 +710,134 @@ func (api *APIServer) actionsLog(w http.ResponseWriter, r *http.Request) {
 	api.sendJSONEachRow(w, http.StatusOK, status.Current.GetStatus(false, q.Get("filter"), int(last)))
 }
 
+// actionsJobHandler - return state, progress and error details for one asynchronous command
+func (api *APIServer) actionsJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobId, err := strconv.Atoi(mux.Vars(r)["job_id"])
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "actions", fmt.Errorf("invalid job_id: %v", err))
+		return
+	}
+	jobStatus, err := status.Current.GetJobStatus(jobId)
+	if err != nil {
+		api.writeError(w, http.StatusNotFound, "actions", err)
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, jobStatus)
+}
+
+// actionsJobCancelHandler - cancel in progress or queued command, command stop after current part, keep resumable state and clean frozen data,
+// poll GET /backup/actions/{job_id} to know when cleanup finished
+func (api *APIServer) actionsJobCancelHandler(w http.ResponseWriter, r *http.Request) {
+	jobId, err := strconv.Atoi(mux.Vars(r)["job_id"])
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "cancel", fmt.Errorf("invalid job_id: %v", err))
+		return
+	}
+	if err = status.Current.CancelById(jobId, fmt.Errorf("canceled from API /backup/actions/%d/cancel", jobId)); err != nil {
+		api.writeError(w, http.StatusNotFound, "cancel", err)
+		return
+	}
+	api.log.Infof("job_id=%d canceled via API", jobId)
+	api.sendJSONEachRow(w, http.StatusOK, struct {
+		Status    string `json:"status"`
+		Operation string `json:"operation"`
+		JobId     int    `json:"job_id"`
+	}{
+		Status:    "success",
+		Operation: "cancel",
+		JobId:     jobId,
+	})
+}
+
+// actionsJobLogHandler - stream log records of command as JSON lines, `follow=true` keep connection and send new records until command finished,
+// `Accept: text/event-stream` header switch output to server-sent events, last `end` event contains final job status
+func (api *APIServer) actionsJobLogHandler(w http.ResponseWriter, r *http.Request) {
+	jobId, err := strconv.Atoi(mux.Vars(r)["job_id"])
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "actions", fmt.Errorf("invalid job_id: %v", err))
+		return
+	}
+	if _, err = status.Current.GetJobStatus(jobId); err != nil {
+		api.writeError(w, http.StatusNotFound, "actions", err)
+		return
+	}
+	follow := false
+	if followParam := r.URL.Query().Get("follow"); followParam != "" {
+		if follow, err = strconv.ParseBool(followParam); err != nil {
+			api.writeError(w, http.StatusBadRequest, "actions", fmt.Errorf("invalid follow: %v", err))
+			return
+		}
+	}
+	isSSE := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if isSSE {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=UTF-8")
+	}
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	writeEvent := func(event string, v interface{}) error {
+		out, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if isSSE {
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, out)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", out)
+		}
+		return err
+	}
+	writeRecords := func(records []status.LogRecord) error {
+		for _, record := range records {
+			if err := writeEvent("log", record); err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	offset := 0
+	for {
+		records, next, changed := status.Current.GetLogs(jobId, offset)
+		if err = writeRecords(records); err != nil {
+			api.log.Warnf("can't write log records for job_id=%d: %v", jobId, err)
+			return
+		}
+		offset = next
+		if !follow {
+			return
+		}
+		jobStatus, _ := status.Current.GetJobStatus(jobId)
+		if jobStatus.Status != status.InProgressStatus {
+			// records which appended between GetLogs and GetJobStatus
+			records, _, _ = status.Current.GetLogs(jobId, offset)
+			if err = writeRecords(records); err != nil {
+				api.log.Warnf("can't write log records for job_id=%d: %v", jobId, err)
+				return
+			}
+			if isSSE {
+				if err = writeEvent("end", jobStatus); err != nil {
+					api.log.Warnf("can't write end event for job_id=%d: %v", jobId, err)
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
 // httpRootHandler - display API index
 func (api *APIServer) httpRootHandler(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
@@ -653,8 +902,8 @@ func (api *APIServer) httpTablesHandler(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return
 	}
-	b := backup.NewBackuper(cfg)
 	q := r.URL.Query()
+	b := backup.NewBackuper(cfg, backup.WithExcludeTables(q.Get("exclude_tables")))
 	tables, err := b.GetTables(context.Background(), q.Get("table"))
 	if err != nil {
 		api.writeError(w, http.StatusInternalServerError, "tables", err)
@@ -795,28 +1044,31 @@ func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
 
 // httpCreateHandler - create a backup
 func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "create", ErrAPILocked)
-		return
-	}
 	cfg, err := api.ReloadConfig(w, "create")
 	if err != nil {
 		return
 	}
 	tablePattern := ""
 	partitionsToBackup := make([]string, 0)
-	backupName := backup.NewBackupName()
+	backupName := ""
 	schemaOnly := false
 	createRBAC := false
 	createConfigs := false
 	checkPartsColumns := true
+	waitMutations := false
+	resume := false
+	dryRun := false
 	fullCommand := "create"
 	query := r.URL.Query()
 	if tp, exist := query["table"]; exist {
 		tablePattern = tp[0]
 		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, tablePattern)
 	}
+	excludeTables := ""
+	if et, exist := query["exclude_tables"]; exist {
+		excludeTables = et[0]
+		fullCommand = fmt.Sprintf("%s --exclude-tables=\"%s\"", fullCommand, excludeTables)
+	}
 	if partitions, exist := query["partitions"]; exist {
 		partitionsToBackup = strings.Split(partitions[0], ",")
 		fullCommand = fmt.Sprintf("%s --partitions=\"%s\"", fullCommand, partitions)
@@ -844,11 +1096,36 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 		checkPartsColumns, _ = strconv.ParseBool(partsColumns[0])
 		fullCommand = fmt.Sprintf("%s --check-parts-columns=%v", fullCommand, checkPartsColumns)
 	}
+	if wait, exist := query["wait_mutations"]; exist {
+		waitMutations, _ = strconv.ParseBool(wait[0])
+		if waitMutations {
+			fullCommand = fmt.Sprintf("%s --wait-mutations", fullCommand)
+		}
+	}
+	skipUnchangedFrom := ""
+	if unchangedFrom, exist := query["skip_unchanged_from"]; exist {
+		skipUnchangedFrom = unchangedFrom[0]
+		fullCommand = fmt.Sprintf("%s --skip-unchanged-from=\"%s\"", fullCommand, skipUnchangedFrom)
+	}
+	if _, exist := query["resumable"]; exist {
+		resume = true
+		fullCommand += " --resumable"
+	}
+	if _, exist := query["dry_run"]; exist {
+		dryRun = true
+		fullCommand += " --dry-run"
+	}
 
 	if name, exist := query["name"]; exist {
-		backupName = utils.CleanBackupNameRE.ReplaceAllString(name[0], "")
-		fullCommand = fmt.Sprintf("%s %s", fullCommand, backupName)
+		backupName = name[0]
+	}
+	// resolve backup_name_template before start, to return actual backup name in response
+	if backupName, err = backup.NewBackuper(cfg).ResolveBackupName(r.Context(), backupName); err != nil {
+		api.log.Error(err.Error())
+		api.writeError(w, http.StatusBadRequest, "create", err)
+		return
 	}
+	fullCommand = fmt.Sprintf("%s %s", fullCommand, backupName)
 
 	callback, err := parseCallback(query)
 	if err != nil {
@@ -857,11 +1134,22 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	commandId, ctx := status.Current.Start(fullCommand)
+	commandId, ctx, err := api.startOperation(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "create", err)
+		return
+	}
 	go func() {
-		err, _ := api.metrics.ExecuteWithMetrics("create", 0, func() error {
-			b := backup.NewBackuper(cfg)
-			return b.CreateBackup(backupName, tablePattern, partitionsToBackup, schemaOnly, createRBAC, false, createConfigs, false, checkPartsColumns, api.clickhouseBackupVersion, commandId)
+		if err := api.waitOperationQueue(ctx, commandId); err != nil {
+			api.log.Errorf("API /backup/create error: %v", err)
+			status.Current.Stop(commandId, err)
+			api.errorCallback(context.Background(), err, callback)
+			return
+		}
+		err := api.executeWithMetrics("create", dryRun, func() error {
+			b := backup.NewBackuper(cfg, backup.WithDryRun(dryRun), backup.WithExcludeTables(excludeTables), backup.WithSkipUnchangedFrom(skipUnchangedFrom))
+			return b.CreateBackup(backupName, tablePattern, partitionsToBackup, schemaOnly, createRBAC, false, createConfigs, false, checkPartsColumns, waitMutations, resume, api.clickhouseBackupVersion, commandId)
 		})
 		if err != nil {
 			api.log.Errorf("API /backup/create error: %v", err)
@@ -869,6 +1157,11 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 			api.errorCallback(context.Background(), err, callback)
 			return
 		}
+		if dryRun {
+			status.Current.Stop(commandId, nil)
+			api.successCallback(context.Background(), callback)
+			return
+		}
 		if err := api.UpdateBackupMetrics(ctx, true); err != nil {
 			api.log.Errorf("UpdateBackupMetrics return error: %v", err)
 			status.Current.Stop(commandId, err)
@@ -882,20 +1175,17 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 		Status     string `json:"status"`
 		Operation  string `json:"operation"`
 		BackupName string `json:"backup_name"`
+		JobId      int    `json:"job_id"`
 	}{
 		Status:     "acknowledged",
 		Operation:  "create",
 		BackupName: backupName,
+		JobId:      commandId,
 	})
 }
 
 // httpWatchHandler - run watch command go routine, can't run the same watch command twice
 func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "watch", ErrAPILocked)
-		return
-	}
 	cfg, err := api.ReloadConfig(w, "watch")
 	if err != nil {
 		return
@@ -914,6 +1204,9 @@ func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
 	if interval, exist := query["watch_interval"]; exist {
 		watchInterval = interval[0]
 		fullCommand = fmt.Sprintf("%s --watch-interval=\"%s\"", fullCommand, watchInterval)
+	} else if interval, exist := query["incremental_interval"]; exist {
+		watchInterval = interval[0]
+		fullCommand = fmt.Sprintf("%s --watch-interval=\"%s\"", fullCommand, watchInterval)
 	}
 	if interval, exist := query["full_interval"]; exist {
 		fullInterval = interval[0]
@@ -927,6 +1220,11 @@ func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
 		tablePattern = tp[0]
 		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, tablePattern)
 	}
+	excludeTables := ""
+	if et, exist := query["exclude_tables"]; exist {
+		excludeTables = et[0]
+		fullCommand = fmt.Sprintf("%s --exclude-tables=\"%s\"", fullCommand, excludeTables)
+	}
 	if partitions, exist := query["partitions"]; exist {
 		partitionsToBackup = strings.Split(partitions[0], ",")
 		fullCommand = fmt.Sprintf("%s --partitions=\"%s\"", fullCommand, partitions)
@@ -962,9 +1260,19 @@ func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	commandId, _ := status.Current.Start(fullCommand)
+	commandId, ctx, err := api.startOperation(fullCommand)
+	if err != nil {
+		api.log.Warnf("%s error: %v", fullCommand, err)
+		api.writeError(w, http.StatusLocked, "watch", err)
+		return
+	}
 	go func() {
-		b := backup.NewBackuper(cfg)
+		if err := api.waitOperationQueue(ctx, commandId); err != nil {
+			api.log.Errorf("Watch error: %v", err)
+			status.Current.Stop(commandId, err)
+			return
+		}
+		b := backup.NewBackuper(cfg, backup.WithExcludeTables(excludeTables))
 		err := b.Watch(watchInterval, fullInterval, watchBackupNameTemplate, tablePattern, partitionsToBackup, schemaOnly, rbacOnly, configsOnly, skipCheckPartsColumns, api.clickhouseBackupVersion, commandId, api.GetMetrics(), api.cliCtx)
 		defer status.Current.Stop(commandId, err)
 		if err != nil {
@@ -984,12 +1292,28 @@ func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // httpCleanHandler - clean ./shadow directory
-func (api *APIServer) httpCleanHandler(w http.ResponseWriter, _ *http.Request) {
-	var err error
+func (api *APIServer) httpCleanHandler(w http.ResponseWriter, r *http.Request) {
 	fullCommand := "clean"
-	commandId, ctx := status.Current.Start(fullCommand)
-	b := backup.NewBackuper(api.config)
-	err = b.Clean(ctx)
+	_, dryRun := r.URL.Query()["dry_run"]
+	if dryRun {
+		fullCommand += " --dry-run"
+	}
+	_, orphaned := r.URL.Query()["orphaned"]
+	if orphaned {
+		fullCommand += " --orphaned"
+	}
+	commandId, ctx, err := api.startOperationAndWait(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "clean", err)
+		return
+	}
+	b := backup.NewBackuper(api.config, backup.WithDryRun(dryRun))
+	if orphaned {
+		err = b.CleanOrphaned(ctx)
+	} else {
+		err = b.Clean(ctx)
+	}
 	defer status.Current.Stop(commandId, err)
 	if err != nil {
 		api.log.Errorf("Clean error: %v", err)
@@ -1011,7 +1335,12 @@ func (api *APIServer) httpCleanRemoteBrokenHandler(w http.ResponseWriter, _ *htt
 	if err != nil {
 		return
 	}
-	commandId, ctx := status.Current.Start("clean_remote_broken")
+	commandId, ctx, err := api.startOperationAndWait("clean_remote_broken")
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "clean_remote_broken", err)
+		return
+	}
 	defer status.Current.Stop(commandId, err)
 
 	b := backup.NewBackuper(cfg)
@@ -1038,13 +1367,96 @@ func (api *APIServer) httpCleanRemoteBrokenHandler(w http.ResponseWriter, _ *htt
 	})
 }
 
-// httpUploadHandler - upload a backup to remote storage
-func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "upload", ErrAPILocked)
+// httpCleanRemoteHandler - delete old remote backups according to retention policy
+func (api *APIServer) httpCleanRemoteHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, err := api.ReloadConfig(w, "clean_remote")
+	if err != nil {
+		return
+	}
+	dryRun := false
+	if _, exist := r.URL.Query()["dry_run"]; exist {
+		dryRun = true
+	}
+	_, orphans := r.URL.Query()["orphans"]
+	fullCommand := "clean_remote"
+	if orphans {
+		fullCommand += " --orphans"
+	}
+	commandId, ctx, err := api.startOperationAndWait(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "clean_remote", err)
+		return
+	}
+	defer status.Current.Stop(commandId, err)
+
+	b := backup.NewBackuper(cfg)
+	if orphans {
+		err = b.CleanRemoteOrphans(dryRun, nil, commandId)
+	} else {
+		err = b.CleanRemote(dryRun, commandId)
+	}
+	if err != nil {
+		api.log.Errorf("Clean remote error: %v", err)
+		api.writeError(w, http.StatusInternalServerError, "clean_remote", err)
+		return
+	}
+
+	err = api.UpdateBackupMetrics(ctx, false)
+	if err != nil {
+		api.log.Errorf("UpdateBackupMetrics return error: %v", err)
+		api.writeError(w, http.StatusInternalServerError, "clean_remote", err)
+		return
+	}
+
+	api.sendJSONEachRow(w, http.StatusOK, struct {
+		Status    string `json:"status"`
+		Operation string `json:"operation"`
+	}{
+		Status:    "success",
+		Operation: "clean_remote",
+	})
+}
+
+// httpVerifyHandler - check local or remote backup integrity with sentinel files
+func (api *APIServer) httpVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, err := api.ReloadConfig(w, "verify")
+	if err != nil {
+		return
+	}
+	name := utils.CleanBackupNameRE.ReplaceAllString(mux.Vars(r)["name"], "")
+	remote := false
+	if _, exist := r.URL.Query()["remote"]; exist {
+		remote = true
+	}
+	commandId, _, err := api.startOperationAndWait("verify")
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "verify", err)
+		return
+	}
+	defer status.Current.Stop(commandId, err)
+
+	b := backup.NewBackuper(cfg)
+	err = b.Verify(name, remote, commandId)
+	if err != nil {
+		api.log.Errorf("Verify error: %v", err)
+		api.writeError(w, http.StatusInternalServerError, "verify", err)
 		return
 	}
+	api.sendJSONEachRow(w, http.StatusOK, struct {
+		Status     string `json:"status"`
+		Operation  string `json:"operation"`
+		BackupName string `json:"backup_name"`
+	}{
+		Status:     "success",
+		Operation:  "verify",
+		BackupName: name,
+	})
+}
+
+// httpUploadHandler - upload a backup to remote storage
+func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request) {
 	cfg, err := api.ReloadConfig(w, "upload")
 	if err != nil {
 		return
@@ -1058,6 +1470,7 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 	partitionsToBackup := make([]string, 0)
 	schemaOnly := false
 	resume := false
+	dryRun := false
 	fullCommand := "upload"
 
 	if df, exist := query["diff-from"]; exist {
@@ -1072,6 +1485,11 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		tablePattern = tp[0]
 		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, tablePattern)
 	}
+	excludeTables := ""
+	if et, exist := query["exclude_tables"]; exist {
+		excludeTables = et[0]
+		fullCommand = fmt.Sprintf("%s --exclude-tables=\"%s\"", fullCommand, excludeTables)
+	}
 	if partitions, exist := query["partitions"]; exist {
 		partitionsToBackup = strings.Split(partitions[0], ",")
 		fullCommand = fmt.Sprintf("%s --partitions=\"%s\"", fullCommand, partitions)
@@ -1084,6 +1502,10 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		resume = true
 		fullCommand += " --resumable"
 	}
+	if _, exist := query["dry_run"]; exist {
+		dryRun = true
+		fullCommand += " --dry-run"
+	}
 
 	fullCommand = fmt.Sprint(fullCommand, " ", name)
 
@@ -1094,10 +1516,21 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	commandId, ctx := status.Current.Start(fullCommand)
+	commandId, ctx, err := api.startOperation(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "upload", err)
+		return
+	}
 	go func() {
-		err, _ := api.metrics.ExecuteWithMetrics("upload", 0, func() error {
-			b := backup.NewBackuper(cfg)
+		if err := api.waitOperationQueue(ctx, commandId); err != nil {
+			api.log.Errorf("API /backup/upload error: %v", err)
+			status.Current.Stop(commandId, err)
+			api.errorCallback(context.Background(), err, callback)
+			return
+		}
+		err := api.executeWithMetrics("upload", dryRun, func() error {
+			b := backup.NewBackuper(cfg, backup.WithDryRun(dryRun), backup.WithExcludeTables(excludeTables))
 			return b.Upload(name, diffFrom, diffFromRemote, tablePattern, partitionsToBackup, schemaOnly, resume, commandId)
 		})
 		if err != nil {
@@ -1106,6 +1539,11 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 			api.errorCallback(context.Background(), err, callback)
 			return
 		}
+		if dryRun {
+			status.Current.Stop(commandId, nil)
+			api.successCallback(context.Background(), callback)
+			return
+		}
 		if err := api.UpdateBackupMetrics(ctx, false); err != nil {
 			api.log.Errorf("UpdateBackupMetrics return error: %v", err)
 			status.Current.Stop(commandId, err)
@@ -1121,12 +1559,14 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		BackupName string `json:"backup_name"`
 		BackupFrom string `json:"backup_from,omitempty"`
 		Diff       bool   `json:"diff"`
+		JobId      int    `json:"job_id"`
 	}{
 		Status:     "acknowledged",
 		Operation:  "upload",
 		BackupName: name,
 		BackupFrom: diffFrom,
 		Diff:       diffFrom != "",
+		JobId:      commandId,
 	})
 }
 
@@ -1134,11 +1574,6 @@ var databaseMappingRE = regexp.MustCompile(`[\w+]:[\w+]`)
 
 // httpRestoreHandler - restore a backup from local storage
 func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "restore", ErrAPILocked)
-		return
-	}
 	_, err := api.ReloadConfig(w, "restore")
 	if err != nil {
 		return
@@ -1146,11 +1581,16 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	tablePattern := ""
 	databaseMappingToRestore := make([]string, 0)
+	tableMappingToRestore := make([]string, 0)
+	toTimestamp := ""
 	partitionsToBackup := make([]string, 0)
+	partitionsWhere := ""
 	schemaOnly := false
 	dataOnly := false
 	dropTable := false
 	ignoreDependencies := false
+	preserveUUID := false
+	materializeExternal := false
 	restoreRBAC := false
 	restoreConfigs := false
 	fullCommand := "restore"
@@ -1160,6 +1600,11 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 		tablePattern = tp[0]
 		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, tablePattern)
 	}
+	excludeTables := ""
+	if et, exist := query["exclude_tables"]; exist {
+		excludeTables = et[0]
+		fullCommand = fmt.Sprintf("%s --exclude-tables=\"%s\"", fullCommand, excludeTables)
+	}
 	if databaseMappingQuery, exist := query["restore_database_mapping"]; exist {
 		for _, databaseMapping := range databaseMappingQuery {
 			mappingItems := strings.Split(databaseMapping, ",")
@@ -1175,10 +1620,35 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 
 		fullCommand = fmt.Sprintf("%s --restore-database-mapping=\"%s\"", fullCommand, strings.Join(databaseMappingToRestore, ","))
 	}
+	if tableMappingQuery, exist := query["restore_table_mapping"]; exist {
+		for _, tableMapping := range tableMappingQuery {
+			mappingItems := strings.Split(tableMapping, ",")
+			for _, m := range mappingItems {
+				if strings.Count(m, ":") != 1 || !databaseMappingRE.MatchString(m) {
+					api.writeError(w, http.StatusInternalServerError, "restore", fmt.Errorf("invalid values in restore_table_mapping %s", m))
+					return
+				}
+			}
+			tableMappingToRestore = append(tableMappingToRestore, mappingItems...)
+		}
+		fullCommand = fmt.Sprintf("%s --restore-table-mapping=\"%s\"", fullCommand, strings.Join(tableMappingToRestore, ","))
+	}
+	if ts, exist := query["to_timestamp"]; exist {
+		toTimestamp = ts[0]
+		fullCommand = fmt.Sprintf("%s --to-timestamp=\"%s\"", fullCommand, toTimestamp)
+	}
 	if partitions, exist := query["partitions"]; exist {
 		partitionsToBackup = partitions
 		fullCommand = fmt.Sprintf("%s --partitions=\"%s\"", fullCommand, strings.Join(partitions, ","))
 	}
+	if where, exist := query["partitions_where"]; exist {
+		partitionsWhere = where[0]
+		if err := partition.ValidatePartitionsWhere(partitionsWhere); err != nil {
+			api.writeError(w, http.StatusBadRequest, "restore", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --partitions-where=\"%s\"", fullCommand, partitionsWhere)
+	}
 	if _, exist := query["schema"]; exist {
 		schemaOnly = true
 		fullCommand += " --schema"
@@ -1199,6 +1669,59 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 		ignoreDependencies = true
 		fullCommand += " --ignore-dependencies"
 	}
+	if _, exists := query["preserve_uuid"]; exists {
+		preserveUUID = true
+		fullCommand += " --preserve-uuid"
+	}
+	if _, exists := query["materialize_external"]; exists {
+		materializeExternal = true
+		fullCommand += " --materialize-external"
+	}
+	materializedViews := ""
+	if mv, exists := query["materialized_views"]; exists {
+		materializedViews = mv[0]
+		if err := backup.ValidateMaterializedViewsMode(materializedViews); err != nil {
+			api.writeError(w, http.StatusBadRequest, "restore", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --materialized-views=%s", fullCommand, materializedViews)
+	}
+	restoreDataMode := ""
+	if mode, exists := query["restore_data_mode"]; exists {
+		restoreDataMode = mode[0]
+		if err := backup.ValidateRestoreDataMode(restoreDataMode); err != nil {
+			api.writeError(w, http.StatusBadRequest, "restore", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --restore-data-mode=%s", fullCommand, restoreDataMode)
+	}
+	var convertEngines []string
+	if rules, exists := query["convert_engines"]; exists {
+		convertEngines = rules
+		if err := backup.ValidateConvertEngines(convertEngines); err != nil {
+			api.writeError(w, http.StatusBadRequest, "restore", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --convert-engines=\"%s\"", fullCommand, strings.Join(convertEngines, ","))
+	}
+	projectionsMode := ""
+	if mode, exists := query["projections"]; exists {
+		projectionsMode = mode[0]
+		if err := backup.ValidateProjectionsMode(projectionsMode); err != nil {
+			api.writeError(w, http.StatusBadRequest, "restore", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --projections=%s", fullCommand, projectionsMode)
+	}
+	var restoreDiskMapping []string
+	if rules, exists := query["restore_disk_mapping"]; exists {
+		restoreDiskMapping = rules
+		if err := backup.ValidateRestoreDiskMapping(restoreDiskMapping); err != nil {
+			api.writeError(w, http.StatusBadRequest, "restore", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --restore-disk-mapping=\"%s\"", fullCommand, strings.Join(restoreDiskMapping, ","))
+	}
 	if _, exist := query["rbac"]; exist {
 		restoreRBAC = true
 		fullCommand += " --rbac"
@@ -1218,11 +1741,22 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	commandId, _ := status.Current.Start(fullCommand)
+	commandId, queueCtx, err := api.startOperation(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "restore", err)
+		return
+	}
 	go func() {
+		if err := api.waitOperationQueue(queueCtx, commandId); err != nil {
+			api.log.Errorf("API /backup/restore error: %v", err)
+			status.Current.Stop(commandId, err)
+			api.errorCallback(context.Background(), err, callback)
+			return
+		}
 		err, _ := api.metrics.ExecuteWithMetrics("restore", 0, func() error {
-			b := backup.NewBackuper(api.config)
-			return b.Restore(name, tablePattern, databaseMappingToRestore, partitionsToBackup, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, false, restoreConfigs, false, commandId)
+			b := backup.NewBackuper(api.config, backup.WithExcludeTables(excludeTables), backup.WithMaterializedViewsMode(materializedViews), backup.WithRestoreDataMode(restoreDataMode), backup.WithConvertEngines(convertEngines), backup.WithProjectionsMode(projectionsMode), backup.WithRestoreDiskMapping(restoreDiskMapping))
+			return b.Restore(name, tablePattern, databaseMappingToRestore, tableMappingToRestore, partitionsToBackup, partitionsWhere, toTimestamp, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, false, restoreConfigs, false, preserveUUID, materializeExternal, commandId)
 		})
 		status.Current.Stop(commandId, err)
 		if err != nil {
@@ -1236,20 +1770,17 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 		Status     string `json:"status"`
 		Operation  string `json:"operation"`
 		BackupName string `json:"backup_name"`
+		JobId      int    `json:"job_id"`
 	}{
 		Status:     "acknowledged",
 		Operation:  "restore",
 		BackupName: name,
+		JobId:      commandId,
 	})
 }
 
 // httpDownloadHandler - download a backup from remote to local storage
 func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "download", ErrAPILocked)
-		return
-	}
 	cfg, err := api.ReloadConfig(w, "download")
 	if err != nil {
 		return
@@ -1260,6 +1791,7 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 	query := r.URL.Query()
 	tablePattern := ""
 	partitionsToBackup := make([]string, 0)
+	partitionsWhere := ""
 	schemaOnly := false
 	resume := false
 	fullCommand := "download"
@@ -1268,10 +1800,23 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 		tablePattern = tp[0]
 		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, tablePattern)
 	}
+	excludeTables := ""
+	if et, exist := query["exclude_tables"]; exist {
+		excludeTables = et[0]
+		fullCommand = fmt.Sprintf("%s --exclude-tables=\"%s\"", fullCommand, excludeTables)
+	}
 	if partitions, exist := query["partitions"]; exist {
 		partitionsToBackup = partitions
 		fullCommand = fmt.Sprintf("%s --partitions=\"%s\"", fullCommand, strings.Join(partitions, ","))
 	}
+	if where, exist := query["partitions_where"]; exist {
+		partitionsWhere = where[0]
+		if err := partition.ValidatePartitionsWhere(partitionsWhere); err != nil {
+			api.writeError(w, http.StatusBadRequest, "download", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --partitions-where=\"%s\"", fullCommand, partitionsWhere)
+	}
 	if _, exist := query["schema"]; exist {
 		schemaOnly = true
 		fullCommand += " --schema"
@@ -1280,6 +1825,15 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 		resume = true
 		fullCommand += " --resumable"
 	}
+	maxRehydrationWait := ""
+	if maxWait, exist := query["max_rehydration_wait"]; exist {
+		maxRehydrationWait = maxWait[0]
+		if err := backup.ValidateMaxRehydrationWait(maxRehydrationWait); err != nil {
+			api.writeError(w, http.StatusBadRequest, "download", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --max-rehydration-wait=%s", fullCommand, maxRehydrationWait)
+	}
 	fullCommand += fmt.Sprintf(" %s", name)
 
 	callback, err := parseCallback(query)
@@ -1289,11 +1843,22 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	commandId, ctx := status.Current.Start(fullCommand)
+	commandId, ctx, err := api.startOperation(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "download", err)
+		return
+	}
 	go func() {
+		if err := api.waitOperationQueue(ctx, commandId); err != nil {
+			api.log.Errorf("API /backup/download error: %v", err)
+			status.Current.Stop(commandId, err)
+			api.errorCallback(context.Background(), err, callback)
+			return
+		}
 		err, _ := api.metrics.ExecuteWithMetrics("download", 0, func() error {
-			b := backup.NewBackuper(cfg)
-			return b.Download(name, tablePattern, partitionsToBackup, schemaOnly, resume, commandId)
+			b := backup.NewBackuper(cfg, backup.WithExcludeTables(excludeTables), backup.WithMaxRehydrationWait(maxRehydrationWait))
+			return b.Download(name, tablePattern, partitionsToBackup, partitionsWhere, schemaOnly, resume, commandId)
 		})
 		if err != nil {
 			api.log.Errorf("API /backup/download error: %v", err)
@@ -1314,35 +1879,46 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 		Status     string `json:"status"`
 		Operation  string `json:"operation"`
 		BackupName string `json:"backup_name"`
+		JobId      int    `json:"job_id"`
 	}{
 		Status:     "acknowledged",
 		Operation:  "download",
 		BackupName: name,
+		JobId:      commandId,
 	})
 }
 
 // httpDeleteHandler - delete a backup from local or remote storage
 func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "delete", ErrAPILocked)
-		return
-	}
 	cfg, err := api.ReloadConfig(w, "delete")
 	if err != nil {
 		return
 	}
 	vars := mux.Vars(r)
+	_, dryRun := r.URL.Query()["dry_run"]
 	fullCommand := fmt.Sprintf("delete %s %s", vars["where"], vars["name"])
-	commandId, ctx := status.Current.Start(fullCommand)
-	b := backup.NewBackuper(cfg)
-	switch vars["where"] {
-	case "local":
-		err = b.RemoveBackupLocal(ctx, vars["name"], nil)
-	case "remote":
-		err = b.RemoveBackupRemote(ctx, vars["name"])
-	default:
-		err = fmt.Errorf("backup location must be 'local' or 'remote'")
+	if dryRun {
+		fullCommand = fmt.Sprintf("delete --dry-run %s %s", vars["where"], vars["name"])
+	}
+	commandId, ctx, err := api.startOperationAndWait(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "delete", err)
+		return
+	}
+	b := backup.NewBackuper(cfg, backup.WithDryRun(dryRun))
+	backupName, err := b.ResolveExistingBackupName(ctx, vars["where"], vars["name"])
+	if err == nil && dryRun {
+		err = b.Delete(vars["where"], backupName, commandId)
+	} else if err == nil {
+		switch vars["where"] {
+		case "local":
+			err = b.RemoveBackupLocal(ctx, backupName, nil)
+		case "remote":
+			err = b.RemoveBackupRemote(ctx, backupName)
+		default:
+			err = fmt.Errorf("backup location must be 'local' or 'remote'")
+		}
 	}
 	status.Current.Stop(commandId, err)
 	if err != nil {
@@ -1372,6 +1948,84 @@ func (api *APIServer) httpBackupStatusHandler(w http.ResponseWriter, _ *http.Req
 	api.sendJSONEachRow(w, http.StatusOK, status.Current.GetStatus(true, "", 0))
 }
 
+// httpBandwidthHandler - show and change upload and download bandwidth limits, changed limits shared by all running operations
+func (api *APIServer) httpBandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	storage.SetBandwidthLimits(api.config.General.UploadMaxBytesPerSec, api.config.General.DownloadMaxBytesPerSec)
+	if r.Method == http.MethodPost {
+		query := r.URL.Query()
+		current := storage.GetBandwidthLimits()
+		upload, download := current.Upload, current.Download
+		if _, exist := query["reset"]; exist {
+			upload, download = -1, -1
+		}
+		for name, limit := range map[string]*int64{"upload_max_bytes_per_second": &upload, "download_max_bytes_per_second": &download} {
+			if value := query.Get(name); value != "" {
+				parsed, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || parsed < 0 {
+					api.writeError(w, http.StatusBadRequest, "bandwidth", fmt.Errorf("invalid %s=%s, shall be non negative integer", name, value))
+					return
+				}
+				*limit = parsed
+			}
+		}
+		storage.OverrideBandwidthLimits(upload, download)
+		api.log.Infof("bandwidth limits changed, upload_max_bytes_per_second=%d download_max_bytes_per_second=%d", upload, download)
+	}
+	api.sendJSONEachRow(w, http.StatusOK, storage.GetBandwidthLimits())
+}
+
+// RunRemoteInventory - periodically walk remote storage and update inventory metrics, scan interval could be changed via config reload
+func (api *APIServer) RunRemoteInventory(ctx context.Context) {
+	log := api.log.WithField("logger", "RunRemoteInventory")
+	for {
+		if err := api.UpdateRemoteInventoryMetrics(ctx); err != nil {
+			log.Errorf("UpdateRemoteInventoryMetrics return error: %v", err)
+		}
+		interval := api.config.API.InventoryScanDuration
+		if interval <= 0 {
+			log.Info("api->inventory_scan_interval is 0, stop remote inventory scan")
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// UpdateRemoteInventoryMetrics - walk all objects in remote storage and update size and age metrics
+func (api *APIServer) UpdateRemoteInventoryMetrics(ctx context.Context) error {
+	startTime := time.Now()
+	b := backup.NewBackuper(api.config)
+	inventory, err := b.GetRemoteInventory(ctx)
+	if err != nil {
+		return err
+	}
+	api.metrics.RemoteTotalBytes.Set(float64(inventory.TotalBytes))
+	api.metrics.RemoteOrphanedBytes.Set(float64(inventory.OrphanedBytes))
+	api.metrics.RemoteBackupBytes.Reset()
+	for backupName, size := range inventory.BackupBytes {
+		api.metrics.RemoteBackupBytes.WithLabelValues(backupName).Set(float64(size))
+	}
+	if !inventory.OldestBackup.IsZero() {
+		api.metrics.RemoteOldestBackupAge.Set(time.Since(inventory.OldestBackup).Seconds())
+		api.metrics.RemoteNewestBackupAge.Set(time.Since(inventory.NewestBackup).Seconds())
+	} else {
+		api.metrics.RemoteOldestBackupAge.Set(0)
+		api.metrics.RemoteNewestBackupAge.Set(0)
+	}
+	api.metrics.RemoteInventoryLastFinish.Set(float64(time.Now().Unix()))
+	api.log.WithFields(apexLog.Fields{
+		"duration":      utils.HumanizeDuration(time.Since(startTime)),
+		"TotalBytes":    inventory.TotalBytes,
+		"OrphanedBytes": inventory.OrphanedBytes,
+		"ChunksBytes":   inventory.ChunksBytes,
+		"Backups":       len(inventory.BackupBytes),
+	}).Info("Update remote inventory metrics finish")
+	return nil
+}
+
 func (api *APIServer) UpdateBackupMetrics(ctx context.Context, onlyLocal bool) error {
 	// calc lastXXX metrics, fix https://github.com/Altinity/clickhouse-backup/issues/515
 	var lastBackupCreateLocal *time.Time
@@ -1399,10 +2053,17 @@ func (api *APIServer) UpdateBackupMetrics(ctx context.Context, onlyLocal bool) e
 		lastSizeLocal = lastBackup.DataSize + lastBackup.MetadataSize + lastBackup.ConfigSize + lastBackup.RBACSize
 		lastBackupCreateLocal = &lastBackup.CreationDate
 		api.metrics.LastBackupSizeLocal.Set(float64(lastSizeLocal))
+		api.metrics.LastCreateSizeBytes.Set(float64(lastSizeLocal))
 		api.metrics.NumberBackupsLocal.Set(float64(numberBackupsLocal))
+		if err = api.updateTableMetrics(ctx, b, lastBackup.BackupName); err != nil {
+			return err
+		}
 	} else {
 		api.metrics.LastBackupSizeLocal.Set(0)
+		api.metrics.LastCreateSizeBytes.Set(0)
 		api.metrics.NumberBackupsLocal.Set(0)
+		api.metrics.PartsTotal.Reset()
+		api.metrics.TableSizeBytes.Reset()
 	}
 	if api.config.General.RemoteStorage == "none" || onlyLocal {
 		return nil
@@ -1424,10 +2085,12 @@ func (api *APIServer) UpdateBackupMetrics(ctx context.Context, onlyLocal bool) e
 		lastBackupUpload = &lastBackup.UploadDate
 		api.metrics.LastBackupSizeRemote.Set(float64(lastSizeRemote))
 		api.metrics.NumberBackupsRemote.Set(float64(numberBackupsRemote))
+		api.metrics.RemoteBackupsTotal.Set(float64(numberBackupsRemote))
 		api.metrics.NumberBackupsRemoteBroken.Set(float64(numberBackupsRemoteBroken))
 	} else {
 		api.metrics.LastBackupSizeRemote.Set(0)
 		api.metrics.NumberBackupsRemote.Set(0)
+		api.metrics.RemoteBackupsTotal.Set(0)
 		api.metrics.NumberBackupsRemoteBroken.Set(0)
 	}
 
@@ -1460,6 +2123,39 @@ func (api *APIServer) UpdateBackupMetrics(ctx context.Context, onlyLocal bool) e
 	return nil
 }
 
+// updateTableMetrics - set `parts_total` and `table_size_bytes` for each table of last local backup, previous label values are removed to avoid stale series for dropped tables
+func (api *APIServer) updateTableMetrics(ctx context.Context, b *backup.Backuper, backupName string) error {
+	tables, err := b.GetLocalBackupTablesMetadata(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	api.metrics.PartsTotal.Reset()
+	api.metrics.TableSizeBytes.Reset()
+	for _, t := range tables {
+		if t.MetadataOnly {
+			continue
+		}
+		partsCount := 0
+		for _, parts := range t.Parts {
+			for _, p := range parts {
+				if !strings.HasPrefix(p.Name, metadata.EngineDataPartPrefix) {
+					partsCount++
+				}
+			}
+		}
+		tableSize := t.TotalBytes
+		if tableSize == 0 {
+			for _, diskSize := range t.Size {
+				tableSize += uint64(diskSize)
+			}
+		}
+		tableName := fmt.Sprintf("%s.%s", t.Database, t.Table)
+		api.metrics.PartsTotal.WithLabelValues(tableName).Set(float64(partsCount))
+		api.metrics.TableSizeBytes.WithLabelValues(tableName).Set(float64(tableSize))
+	}
+	return nil
+}
+
 func (api *APIServer) registerMetricsHandlers(r *mux.Router, enableMetrics bool, enablePprof bool) {
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		api.sendJSONEachRow(w, http.StatusOK, struct {
@@ -0,0 +1,127 @@
+package status
+
+import (
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	apexLog "github.com/apex/log"
+)
+
+// CommandIdField - log field which bind log record to command started via API, see Backuper.setCommandLog
+const CommandIdField = common.CommandIdField
+
+// maxCommandLogRecords - oldest records are dropped to limit memory usage for long running commands like watch
+const maxCommandLogRecords = 10000
+
+// LogRecord - one log record of command, returned by GET /backup/actions/{job_id}/log as JSON line
+type LogRecord struct {
+	Timestamp string                 `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Message   string                 `json:"message"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+type commandLog struct {
+	records []LogRecord
+	// dropped - how much records was removed from the beginning, record offset = dropped + index in records
+	dropped int
+	// changed - closed and replaced on each new record and when command finished, wake up all followers
+	changed chan struct{}
+}
+
+// LogHandler - pass log records to next handler and keep records with command_id field for GET /backup/actions/{job_id}/log
+type LogHandler struct {
+	next apexLog.Handler
+}
+
+func NewLogHandler(next apexLog.Handler) *LogHandler {
+	return &LogHandler{next: next}
+}
+
+func (h *LogHandler) HandleLog(e *apexLog.Entry) error {
+	if commandId, ok := e.Fields[CommandIdField].(int); ok && commandId >= 0 {
+		Current.appendLog(commandId, e)
+	}
+	return h.next.HandleLog(e)
+}
+
+// newCommandLog - called when command started, log is removed together with command by trimHistory
+func (status *AsyncStatus) newCommandLog(commandId int) {
+	status.logsMx.Lock()
+	defer status.logsMx.Unlock()
+	if status.logs == nil {
+		status.logs = make(map[int]*commandLog)
+	}
+	status.logs[commandId] = &commandLog{changed: make(chan struct{})}
+}
+
+// getCommandLog - nil when command not exists or was removed from history, records which logged after that are not kept, shall be called under logsMx
+func (status *AsyncStatus) getCommandLog(commandId int) *commandLog {
+	return status.logs[commandId]
+}
+
+func (status *AsyncStatus) appendLog(commandId int, e *apexLog.Entry) {
+	record := LogRecord{
+		Timestamp: e.Timestamp.Format(time.RFC3339Nano),
+		Level:     e.Level.String(),
+		Message:   e.Message,
+	}
+	for name, value := range e.Fields {
+		if name == CommandIdField {
+			continue
+		}
+		if record.Fields == nil {
+			record.Fields = make(map[string]interface{}, len(e.Fields))
+		}
+		// error doesn't have exported fields and marshal to {}
+		if err, isError := value.(error); isError {
+			value = err.Error()
+		}
+		record.Fields[name] = value
+	}
+	status.logsMx.Lock()
+	defer status.logsMx.Unlock()
+	l := status.getCommandLog(commandId)
+	if l == nil {
+		return
+	}
+	l.records = append(l.records, record)
+	if len(l.records) > maxCommandLogRecords {
+		l.dropped += len(l.records) - maxCommandLogRecords
+		l.records = append([]LogRecord(nil), l.records[len(l.records)-maxCommandLogRecords:]...)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// notifyLogFollowers - wake up followers when command finished, they shall check command status
+func (status *AsyncStatus) notifyLogFollowers(commandId int) {
+	status.logsMx.Lock()
+	defer status.logsMx.Unlock()
+	if l := status.getCommandLog(commandId); l != nil {
+		close(l.changed)
+		l.changed = make(chan struct{})
+	}
+}
+
+// GetLogs - return records of command starting from offset, offset for next call and channel which will close when new records appended or command finished
+func (status *AsyncStatus) GetLogs(commandId, offset int) ([]LogRecord, int, <-chan struct{}) {
+	status.logsMx.Lock()
+	defer status.logsMx.Unlock()
+	l := status.getCommandLog(commandId)
+	if l == nil {
+		removed := make(chan struct{})
+		close(removed)
+		return nil, offset, removed
+	}
+	if offset < l.dropped {
+		offset = l.dropped
+	}
+	next := l.dropped + len(l.records)
+	if offset >= next {
+		return nil, next, l.changed
+	}
+	records := make([]LogRecord, next-offset)
+	copy(records, l.records[offset-l.dropped:])
+	return records, next, l.changed
+}
@@ -0,0 +1,90 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/progressbar"
+)
+
+const (
+	// ProgressAuto - `bar` when stdout is terminal, `none` otherwise
+	ProgressAuto = "auto"
+	ProgressNone = "none"
+	ProgressBar  = "bar"
+	// ProgressJSON - one ProgressRecord per line, logs shall be written into stderr
+	ProgressJSON = "json"
+)
+
+func ValidateProgressMode(mode string) error {
+	switch mode {
+	case "", ProgressAuto, ProgressNone, ProgressBar, ProgressJSON:
+		return nil
+	}
+	return fmt.Errorf("unsupported --progress=%s, shall be one of %s, %s, %s, %s", mode, ProgressAuto, ProgressNone, ProgressBar, ProgressJSON)
+}
+
+// ProgressRecord - one line of `--progress=json` output, the same fields as GET /backup/actions/{job_id}
+type ProgressRecord struct {
+	Timestamp string `json:"timestamp"`
+	JobStatus
+}
+
+// StartProgress - report progress of command which run from CLI into w each interval, until returned func called,
+// `json` writes records only when command process data and always writes last record with final status
+func (status *AsyncStatus) StartProgress(mode string, w io.Writer, interval time.Duration) func() {
+	if mode != ProgressBar && mode != ProgressJSON {
+		return func() {}
+	}
+	var bar *progressbar.Bar
+	report := func(final bool) {
+		jobStatus := status.GetCLIJobStatus()
+		if jobStatus.TotalBytes == 0 && !final {
+			return
+		}
+		if mode == ProgressJSON {
+			record, err := json.Marshal(ProgressRecord{Timestamp: time.Now().Format(time.RFC3339), JobStatus: jobStatus})
+			if err == nil {
+				_, _ = fmt.Fprintln(w, string(record))
+			}
+			return
+		}
+		if bar == nil {
+			if jobStatus.TotalBytes == 0 {
+				return
+			}
+			bar = progressbar.StartNewCommandBar(w, int64(jobStatus.TotalBytes))
+		}
+		bar.SetTotal64(int64(jobStatus.TotalBytes))
+		bar.Set64(int64(jobStatus.ProcessedBytes))
+		if jobStatus.CurrentTable != "" {
+			bar.Postfix(" " + jobStatus.CurrentTable)
+		}
+		if final {
+			bar.Postfix("")
+			bar.Finish()
+		}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				report(false)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		report(true)
+	}
+}
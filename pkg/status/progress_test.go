@@ -0,0 +1,44 @@
+package status
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartProgressJSON(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	s.StartCLI("upload")
+	out := &bytes.Buffer{}
+	stop := s.StartProgress(ProgressJSON, out, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, out.String(), "records shall not be written before command knows total bytes")
+	s.SetTotalBytes(NotFromAPI, 100)
+	s.AddStreamedBytes(NotFromAPI, "db.table", "default_all_1_1_0", 40)
+	time.Sleep(10 * time.Millisecond)
+	s.StopCLI(errors.New("connection reset"))
+	stop()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Greater(t, len(lines), 1)
+	record := ProgressRecord{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "upload", record.Command)
+	assert.Equal(t, InProgressStatus, record.Status)
+	assert.Equal(t, uint64(40), record.ProcessedBytes)
+	assert.Equal(t, "db.table", record.CurrentTable)
+	assert.NotEmpty(t, record.Timestamp)
+
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &record))
+	assert.Equal(t, ErrorStatus, record.Status)
+	assert.Equal(t, "connection reset", record.Error)
+
+	assert.Error(t, ValidateProgressMode("verbose"))
+}
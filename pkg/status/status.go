@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/common"
 	apexLog "github.com/apex/log"
+	"math"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,18 +17,37 @@ const (
 	SuccessStatus    = "success"
 	CancelStatus     = "cancel"
 	ErrorStatus      = "error"
+	// QueuedStatus - command waits until previous operations finished, see api->max_queue_size
+	QueuedStatus = "queued"
 )
 
 var Current = &AsyncStatus{
-	log: apexLog.WithField("logger", "status"),
+	log:              apexLog.WithField("logger", "status"),
+	cliCorrelationId: common.NewCorrelationId(),
 }
 
 const NotFromAPI = int(-1)
 
+// maxCommandHistory - how many finished commands with their logs are kept for GET /backup/actions and GET /backup/actions/{job_id}/log,
+// older commands are removed to limit memory usage of long-running server
+const maxCommandHistory = 100
+
 type AsyncStatus struct {
 	commands []ActionRow
 	log      *apexLog.Entry
+	// cliCorrelationId - CLI process runs only one command, all contexts of this command share the same correlation_id
+	cliCorrelationId string
 	sync.RWMutex
+	// nextCommandId - job_id of next started command, commands are sorted by job_id, the oldest finished commands are removed from history, see trimHistory
+	nextCommandId int
+	// logs - records with command_id field for each command, separate mutex to avoid lock status during logging
+	logs   map[int]*commandLog
+	logsMx sync.Mutex
+	// cli - progress of command which run from CLI, see StartCLI
+	cli ActionRow
+	// cliCtx - parent for all contexts of command which run from CLI, canceled by CancelCLI on SIGTERM
+	cliCtx    context.Context
+	cliCancel context.CancelFunc
 }
 
 type ActionRowStatus struct {
@@ -35,30 +56,195 @@ type ActionRowStatus struct {
 	Start   string `json:"start,omitempty"`
 	Finish  string `json:"finish,omitempty"`
 	Error   string `json:"error,omitempty"`
+	// CorrelationId - value of correlation_id log field for all records of this command
+	CorrelationId string `json:"correlation_id,omitempty"`
 }
 
 type ActionRow struct {
 	ActionRowStatus
+	// id - job_id, doesn't match index in commands after trimHistory
+	id     int
 	Ctx    context.Context
 	Cancel context.CancelFunc
+	// done - closed by Stop, even when command was canceled before, allow WaitFinished wait cleanup after cancel
+	done chan struct{}
+	// totalBytes, processedBytes used for progress percentage calculation, transferredBytes is real bytes uploaded or downloaded
+	totalBytes       uint64
+	processedBytes   uint64
+	transferredBytes uint64
+	// streamedBytes - bytes of tables which still upload or download, reported by storage during streaming, replaced by processedBytes when table finished
+	streamedBytes int64
+	progressStart time.Time
+	currentTable  string
+	currentPart   string
+}
+
+// JobStatus - detailed status for one command, returned by GET /backup/actions/{job_id}
+type JobStatus struct {
+	JobId int `json:"job_id"`
+	ActionRowStatus
+	ProgressPercent  float64 `json:"progress_percent"`
+	TotalBytes       uint64  `json:"total_bytes"`
+	ProcessedBytes   uint64  `json:"processed_bytes"`
+	BytesTransferred uint64  `json:"bytes_transferred"`
+	// EtaSeconds - estimated time until processed_bytes reach total_bytes, calculated by average speed from start of data processing
+	EtaSeconds   int64  `json:"eta_seconds,omitempty"`
+	CurrentTable string `json:"current_table,omitempty"`
+	CurrentPart  string `json:"current_part,omitempty"`
 }
 
 func (status *AsyncStatus) Start(command string) (int, context.Context) {
+	return status.start(command, InProgressStatus)
+}
+
+// StartQueued - register command which will run after all previous operations finished, RunQueued turns it into `in progress`
+func (status *AsyncStatus) StartQueued(command string) (int, context.Context) {
+	return status.start(command, QueuedStatus)
+}
+
+// RunQueued - true when command is `in progress` already, or it was first in queue and no other operation in progress
+func (status *AsyncStatus) RunQueued(commandId int) bool {
+	status.Lock()
+	defer status.Unlock()
+	row := status.row(commandId)
+	if row == nil {
+		return false
+	}
+	if row.Status == InProgressStatus {
+		return true
+	}
+	if row.Status != QueuedStatus {
+		return false
+	}
+	for _, cmd := range status.commands {
+		if cmd.id != commandId && (cmd.Status == InProgressStatus || (cmd.id < commandId && cmd.Status == QueuedStatus)) {
+			return false
+		}
+	}
+	row.Status = InProgressStatus
+	row.Start = time.Now().Format(common.TimeFormat)
+	status.log.Debugf("api.status.RunQueued -> status.commands[%d] == %+v", commandId, *row)
+	return true
+}
+
+// StartOrQueue - check and register command under one lock, so concurrent requests can't exceed maxQueueSize,
+// command starts immediately when no other command in progress or queued, otherwise it's queued,
+// rejectDuplicate rejects command when the same command (first word, like `create`) is in progress or queued already
+func (status *AsyncStatus) StartOrQueue(command string, maxQueueSize int, rejectDuplicate bool) (int, context.Context, bool, error) {
 	status.Lock()
 	defer status.Unlock()
-	ctx, cancel := context.WithCancel(context.Background())
+	inProgress, queued, duplicate := false, 0, false
+	for _, cmd := range status.commands {
+		if cmd.Status != InProgressStatus && cmd.Status != QueuedStatus {
+			continue
+		}
+		if cmd.Status == InProgressStatus {
+			inProgress = true
+		} else {
+			queued++
+		}
+		if CommandName(cmd.Command) == CommandName(command) {
+			duplicate = true
+		}
+	}
+	if !inProgress && queued == 0 {
+		commandId, ctx := status.startLocked(command, InProgressStatus)
+		return commandId, ctx, false, nil
+	}
+	if rejectDuplicate && duplicate {
+		return 0, nil, false, fmt.Errorf("`%s` already in progress or queued", CommandName(command))
+	}
+	if queued >= maxQueueSize {
+		return 0, nil, false, fmt.Errorf("another operation in progress and queue contains %d commands, see api->max_queue_size", queued)
+	}
+	commandId, ctx := status.startLocked(command, QueuedStatus)
+	return commandId, ctx, true, nil
+}
+
+// CommandName - first word of command, like `create` for `create --tables=db.* backup_name`
+func CommandName(command string) string {
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return fields[0]
+	}
+	return command
+}
+
+// QueueLength - how many commands wait in queue
+func (status *AsyncStatus) QueueLength() int {
+	status.RLock()
+	defer status.RUnlock()
+	queued := 0
+	for _, cmd := range status.commands {
+		if cmd.Status == QueuedStatus {
+			queued++
+		}
+	}
+	return queued
+}
+
+func (status *AsyncStatus) start(command, commandStatus string) (int, context.Context) {
+	status.Lock()
+	defer status.Unlock()
+	return status.startLocked(command, commandStatus)
+}
+
+// startLocked - shall be called under lock
+func (status *AsyncStatus) startLocked(command, commandStatus string) (int, context.Context) {
+	commandId := status.nextCommandId
+	status.nextCommandId++
+	correlationId := common.NewCorrelationId()
+	ctx, cancel := context.WithCancel(common.WithCommandId(common.WithCorrelationId(context.Background(), correlationId), commandId))
 	status.commands = append(status.commands, ActionRow{
+		id: commandId,
 		ActionRowStatus: ActionRowStatus{
-			Command: command,
-			Start:   time.Now().Format(common.TimeFormat),
-			Status:  InProgressStatus,
+			Command:       command,
+			Start:         time.Now().Format(common.TimeFormat),
+			Status:        commandStatus,
+			CorrelationId: correlationId,
 		},
 		Ctx:    ctx,
 		Cancel: cancel,
+		done:   make(chan struct{}),
+	})
+	status.newCommandLog(commandId)
+	status.log.Debugf("api.status.Start -> status.commands[%d] == %+v", commandId, status.commands[len(status.commands)-1])
+	return commandId, ctx
+}
+
+// row - command by job_id, nil when command not exists or was removed from history, shall be called under lock
+func (status *AsyncStatus) row(commandId int) *ActionRow {
+	i := sort.Search(len(status.commands), func(i int) bool {
+		return status.commands[i].id >= commandId
 	})
-	lastCommandId := len(status.commands) - 1
-	status.log.Debugf("api.status.Start -> status.commands[%d] == %+v", lastCommandId, status.commands[lastCommandId])
-	return lastCommandId, ctx
+	if i >= len(status.commands) || status.commands[i].id != commandId {
+		return nil
+	}
+	return &status.commands[i]
+}
+
+// trimHistory - remove the oldest finished commands and their logs when history contains more than maxCommandHistory commands,
+// commands which are in progress, queued or still clean up after cancel are kept, shall be called under lock
+func (status *AsyncStatus) trimHistory() {
+	toRemove := len(status.commands) - maxCommandHistory
+	if toRemove <= 0 {
+		return
+	}
+	status.logsMx.Lock()
+	defer status.logsMx.Unlock()
+	commands := make([]ActionRow, 0, maxCommandHistory)
+	for _, cmd := range status.commands {
+		if toRemove > 0 && cmd.Status != InProgressStatus && cmd.Status != QueuedStatus && cmd.done == nil {
+			if l, exists := status.logs[cmd.id]; exists {
+				close(l.changed)
+				delete(status.logs, cmd.id)
+			}
+			toRemove--
+			continue
+		}
+		commands = append(commands, cmd)
+	}
+	status.log.Debugf("api.status.trimHistory -> removed %d commands", len(status.commands)-len(commands))
+	status.commands = commands
 }
 
 func (status *AsyncStatus) CheckCommandInProgress(command string) bool {
@@ -75,48 +261,91 @@ func (status *AsyncStatus) CheckCommandInProgress(command string) bool {
 func (status *AsyncStatus) InProgress() bool {
 	status.RLock()
 	defer status.RUnlock()
-	n := len(status.commands) - 1
-	if n < 0 {
-		status.log.Debugf("api.status.inProgress -> len(status.commands)=%d, inProgress=false", len(status.commands))
-		return false
+	// queued commands could be registered after command which is in progress now
+	for n := len(status.commands) - 1; n >= 0; n-- {
+		if status.commands[n].Status == InProgressStatus {
+			status.log.Debugf("api.status.inProgress -> status.commands[%d].Status == %s, inProgress=true", n, status.commands[n].Status)
+			return true
+		}
+	}
+	status.log.Debugf("api.status.inProgress -> len(status.commands)=%d, inProgress=false", len(status.commands))
+	return false
+}
+
+// SetCLITimeout - all contexts for commands which run from CLI will cancel after timeout, one deadline for whole command, nested operations don't extend it
+func (status *AsyncStatus) SetCLITimeout(timeout time.Duration) {
+	status.Lock()
+	defer status.Unlock()
+	parentCtx, parentCancel := status.cliCtx, status.cliCancel
+	if parentCtx == nil {
+		parentCtx, parentCancel = context.WithCancel(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(parentCtx, timeout)
+	status.cliCtx = ctx
+	status.cliCancel = func() {
+		cancel()
+		parentCancel()
 	}
-	status.log.Debugf("api.status.inProgress -> status.commands[n].Status == %s, inProgress=%v", status.commands[n].Status, status.commands[n].Status == InProgressStatus)
-	return status.commands[n].Status == InProgressStatus
 }
 
 func (status *AsyncStatus) GetContextWithCancel(commandId int) (context.Context, context.CancelFunc, error) {
-	status.RLock()
-	defer status.RUnlock()
+	status.Lock()
+	defer status.Unlock()
 	if commandId == NotFromAPI {
-		ctx, cancel := context.WithCancel(context.Background())
+		if status.cliCtx == nil {
+			status.cliCtx, status.cliCancel = context.WithCancel(context.Background())
+		}
+		ctx, cancel := context.WithCancel(common.WithCorrelationId(status.cliCtx, status.cliCorrelationId))
 		return ctx, cancel, nil
 	}
-	if commandId >= len(status.commands) {
+	row := status.row(commandId)
+	if row == nil {
 		return nil, nil, fmt.Errorf("commandId=%d not exists in current running commands", commandId)
 	}
-	if status.commands[commandId].Ctx == nil {
-		return nil, nil, fmt.Errorf("commands[%d]=%s have nil context ", commandId, status.commands[commandId].Command)
+	if row.Ctx == nil {
+		return nil, nil, fmt.Errorf("commands[%d]=%s have nil context ", commandId, row.Command)
 	}
-	return status.commands[commandId].Ctx, status.commands[commandId].Cancel, nil
+	return row.Ctx, row.Cancel, nil
+}
+
+// GetCorrelationId - correlation_id of command started via API, or of current CLI command
+func (status *AsyncStatus) GetCorrelationId(commandId int) string {
+	status.RLock()
+	defer status.RUnlock()
+	row := status.row(commandId)
+	if row == nil {
+		return status.cliCorrelationId
+	}
+	return row.CorrelationId
 }
 
 func (status *AsyncStatus) Stop(commandId int, err error) {
 	status.Lock()
 	defer status.Unlock()
-	if status.commands[commandId].Status != InProgressStatus {
+	row := status.row(commandId)
+	if row == nil {
+		return
+	}
+	defer status.trimHistory()
+	if row.done != nil {
+		close(row.done)
+		row.done = nil
+	}
+	if row.Status != InProgressStatus && row.Status != QueuedStatus {
 		return
 	}
-	status.commands[commandId].Cancel()
+	row.Cancel()
 	s := SuccessStatus
 	if err != nil {
 		s = ErrorStatus
-		status.commands[commandId].Error = err.Error()
+		row.Error = err.Error()
 	}
-	status.commands[commandId].Status = s
-	status.commands[commandId].Finish = time.Now().Format(common.TimeFormat)
-	status.commands[commandId].Ctx = nil
-	status.commands[commandId].Cancel = nil
-	status.log.Debugf("api.status.stop -> status.commands[%d] == %+v", commandId, status.commands[commandId])
+	row.Status = s
+	row.Finish = time.Now().Format(common.TimeFormat)
+	row.Ctx = nil
+	row.Cancel = nil
+	status.notifyLogFollowers(commandId)
+	status.log.Debugf("api.status.stop -> status.commands[%d] == %+v", commandId, *row)
 }
 
 func (status *AsyncStatus) Cancel(command string, err error) error {
@@ -129,16 +358,16 @@ func (status *AsyncStatus) Cancel(command string, err error) error {
 	}
 	commandId := -1
 	if command == "" {
-		for i, cmd := range status.commands {
+		for _, cmd := range status.commands {
 			if cmd.Status == InProgressStatus {
-				commandId = i
+				commandId = cmd.id
 				break
 			}
 		}
 	} else {
-		for i, cmd := range status.commands {
+		for _, cmd := range status.commands {
 			if cmd.Command == command && cmd.Ctx != nil {
-				commandId = i
+				commandId = cmd.id
 				break
 			}
 		}
@@ -148,34 +377,94 @@ func (status *AsyncStatus) Cancel(command string, err error) error {
 		status.log.Warnf(err.Error())
 		return err
 	}
-	if status.commands[commandId].Status != InProgressStatus {
-		status.log.Warnf("found `%s` with status=%s", command, status.commands[commandId].Status)
+	if commandStatus := status.row(commandId).Status; commandStatus != InProgressStatus {
+		status.log.Warnf("found `%s` with status=%s", command, commandStatus)
 	}
-	if status.commands[commandId].Ctx != nil {
-		status.commands[commandId].Cancel()
-		status.commands[commandId].Ctx = nil
-		status.commands[commandId].Cancel = nil
+	status.cancel(commandId, err)
+	return nil
+}
+
+// CancelById - cancel in progress or queued command by job_id, finished command can't be canceled
+func (status *AsyncStatus) CancelById(commandId int, err error) error {
+	status.Lock()
+	defer status.Unlock()
+	row := status.row(commandId)
+	if row == nil {
+		return fmt.Errorf("job_id=%d not found", commandId)
+	}
+	if commandStatus := row.Status; commandStatus != InProgressStatus && commandStatus != QueuedStatus {
+		return fmt.Errorf("job_id=%d already finished with status=%s", commandId, commandStatus)
+	}
+	status.cancel(commandId, err)
+	return nil
+}
+
+// cancel - shall be called under lock
+func (status *AsyncStatus) cancel(commandId int, err error) {
+	row := status.row(commandId)
+	if row.Ctx != nil {
+		row.Cancel()
+		row.Ctx = nil
+		row.Cancel = nil
+	}
+	// queued command didn't start, nothing to wait in WaitFinished
+	if row.Status == QueuedStatus && row.done != nil {
+		close(row.done)
+		row.done = nil
+	}
+	row.Error = err.Error()
+	row.Status = CancelStatus
+	row.Finish = time.Now().Format(common.TimeFormat)
+	status.notifyLogFollowers(commandId)
+	status.log.Debugf("api.status.cancel -> status.commands[%d] == %+v", commandId, *row)
+	status.trimHistory()
+}
+
+// CancelCLI - cancel contexts of command which run from CLI, command shall finish current part, keep resumable state and clean frozen data
+func (status *AsyncStatus) CancelCLI() {
+	status.Lock()
+	defer status.Unlock()
+	if status.cliCancel != nil {
+		status.cliCancel()
+	}
+}
+
+// WaitFinished - wait until all commands which were started via API call Stop, canceled commands still clean up after cancel
+func (status *AsyncStatus) WaitFinished(ctx context.Context) error {
+	status.RLock()
+	running := make([]chan struct{}, 0)
+	for _, cmd := range status.commands {
+		if cmd.done != nil && cmd.Status != QueuedStatus {
+			running = append(running, cmd.done)
+		}
+	}
+	status.RUnlock()
+	for _, done := range running {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	status.commands[commandId].Error = err.Error()
-	status.commands[commandId].Status = CancelStatus
-	status.commands[commandId].Finish = time.Now().Format(common.TimeFormat)
-	status.log.Debugf("api.status.cancel -> status.commands[%d] == %+v", commandId, status.commands[commandId])
 	return nil
 }
 
 func (status *AsyncStatus) CancelAll(cancelMsg string) {
 	status.Lock()
 	defer status.Unlock()
-	for commandId := range status.commands {
-		if status.commands[commandId].Ctx != nil {
-			status.commands[commandId].Cancel()
-			status.commands[commandId].Ctx = nil
-			status.commands[commandId].Cancel = nil
+	for i := range status.commands {
+		row := &status.commands[i]
+		commandId := row.id
+		if row.Ctx != nil {
+			row.Cancel()
+			row.Ctx = nil
+			row.Cancel = nil
 		}
-		status.commands[commandId].Status = CancelStatus
-		status.commands[commandId].Error = cancelMsg
-		status.commands[commandId].Finish = time.Now().Format(common.TimeFormat)
-		status.log.Debugf("api.status.cancel -> status.commands[%d] == %+v", commandId, status.commands[commandId])
+		row.Status = CancelStatus
+		row.Error = cancelMsg
+		row.Finish = time.Now().Format(common.TimeFormat)
+		status.notifyLogFollowers(commandId)
+		status.log.Debugf("api.status.cancel -> status.commands[%d] == %+v", commandId, *row)
 	}
 }
 
@@ -195,11 +484,12 @@ func (status *AsyncStatus) GetStatus(current bool, filter string, last int) []Ac
 		if filter == "" || (strings.Contains(command.Command, filter) || strings.Contains(command.Status, filter) || strings.Contains(command.Error, filter)) {
 			// copy without context and cancel
 			filteredCommands = append(filteredCommands, ActionRowStatus{
-				Command: command.Command,
-				Status:  command.Status,
-				Start:   command.Start,
-				Finish:  command.Finish,
-				Error:   command.Error,
+				Command:       command.Command,
+				Status:        command.Status,
+				Start:         command.Start,
+				Finish:        command.Finish,
+				Error:         command.Error,
+				CorrelationId: command.CorrelationId,
 			})
 		}
 	}
@@ -218,3 +508,131 @@ func (status *AsyncStatus) GetStatus(current bool, filter string, last int) []Ac
 	}
 	return filteredCommands[begin:end]
 }
+
+// StartCLI - progress of command which run from CLI tracked with commandId=NotFromAPI, see GetJobStatus
+func (status *AsyncStatus) StartCLI(command string) {
+	status.Lock()
+	defer status.Unlock()
+	status.cli = ActionRow{
+		ActionRowStatus: ActionRowStatus{
+			Command:       command,
+			Start:         time.Now().Format(common.TimeFormat),
+			Status:        InProgressStatus,
+			CorrelationId: status.cliCorrelationId,
+		},
+	}
+}
+
+// StopCLI - set final status of command which run from CLI
+func (status *AsyncStatus) StopCLI(err error) {
+	status.Lock()
+	defer status.Unlock()
+	status.cli.Status = SuccessStatus
+	if err != nil {
+		status.cli.Status = ErrorStatus
+		status.cli.Error = err.Error()
+	}
+	status.cli.Finish = time.Now().Format(common.TimeFormat)
+	status.cli.streamedBytes = 0
+}
+
+// progressRow - command row for progress tracking, nil when commandId not exists, shall be called under lock
+func (status *AsyncStatus) progressRow(commandId int) *ActionRow {
+	if commandId == NotFromAPI {
+		return &status.cli
+	}
+	return status.row(commandId)
+}
+
+// SetTotalBytes - set how much bytes command shall process, used for progress percentage
+func (status *AsyncStatus) SetTotalBytes(commandId int, totalBytes uint64) {
+	status.Lock()
+	defer status.Unlock()
+	row := status.progressRow(commandId)
+	if row == nil {
+		return
+	}
+	row.totalBytes = totalBytes
+	if row.progressStart.IsZero() {
+		row.progressStart = time.Now()
+	}
+}
+
+// AddProgress - increase processed bytes and real transferred bytes for command
+func (status *AsyncStatus) AddProgress(commandId int, processedBytes, transferredBytes uint64) {
+	status.Lock()
+	defer status.Unlock()
+	row := status.progressRow(commandId)
+	if row == nil {
+		return
+	}
+	row.processedBytes += processedBytes
+	row.transferredBytes += transferredBytes
+}
+
+// AddStreamedBytes - bytes of table data which already read or written during upload or download, negative value remove bytes of finished table,
+// empty table doesn't change current table and part
+func (status *AsyncStatus) AddStreamedBytes(commandId int, table, part string, bytes int64) {
+	status.Lock()
+	defer status.Unlock()
+	row := status.progressRow(commandId)
+	if row == nil {
+		return
+	}
+	row.streamedBytes += bytes
+	if table != "" {
+		row.currentTable = table
+		row.currentPart = part
+	}
+}
+
+func (status *AsyncStatus) GetJobStatus(commandId int) (JobStatus, error) {
+	status.RLock()
+	defer status.RUnlock()
+	row := status.row(commandId)
+	if row == nil {
+		return JobStatus{}, fmt.Errorf("job_id=%d not found", commandId)
+	}
+	return newJobStatus(commandId, *row), nil
+}
+
+// GetCLIJobStatus - progress of command which run from CLI, see StartCLI
+func (status *AsyncStatus) GetCLIJobStatus() JobStatus {
+	status.RLock()
+	defer status.RUnlock()
+	return newJobStatus(NotFromAPI, status.cli)
+}
+
+func newJobStatus(commandId int, command ActionRow) JobStatus {
+	jobStatus := JobStatus{
+		JobId: commandId,
+		ActionRowStatus: ActionRowStatus{
+			Command:       command.Command,
+			Status:        command.Status,
+			Start:         command.Start,
+			Finish:        command.Finish,
+			Error:         command.Error,
+			CorrelationId: command.CorrelationId,
+		},
+		TotalBytes:       command.totalBytes,
+		ProcessedBytes:   command.processedBytes,
+		BytesTransferred: command.transferredBytes,
+	}
+	if command.streamedBytes > 0 {
+		jobStatus.ProcessedBytes = uint64(math.Min(float64(command.processedBytes)+float64(command.streamedBytes), float64(command.totalBytes)))
+	}
+	if command.Status == SuccessStatus {
+		jobStatus.ProgressPercent = 100
+	} else if command.totalBytes > 0 {
+		jobStatus.ProgressPercent = math.Min(100, math.Round(float64(jobStatus.ProcessedBytes)*10000/float64(command.totalBytes))/100)
+	}
+	if command.Status == InProgressStatus {
+		jobStatus.CurrentTable = command.currentTable
+		jobStatus.CurrentPart = command.currentPart
+		if jobStatus.ProcessedBytes > 0 && jobStatus.ProcessedBytes < command.totalBytes && !command.progressStart.IsZero() {
+			elapsed := time.Since(command.progressStart).Seconds()
+			jobStatus.EtaSeconds = int64(math.Ceil(elapsed * float64(command.totalBytes-jobStatus.ProcessedBytes) / float64(jobStatus.ProcessedBytes)))
+		}
+	}
+	return jobStatus
+}
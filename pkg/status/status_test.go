@@ -0,0 +1,191 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueuedCommands(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	createId, _ := s.Start("create")
+	uploadId, _ := s.StartQueued("upload")
+	downloadId, _ := s.StartQueued("download")
+	assert.True(t, s.InProgress())
+	assert.Equal(t, 2, s.QueueLength())
+	assert.True(t, s.RunQueued(createId))
+	assert.False(t, s.RunQueued(uploadId))
+
+	s.Stop(createId, nil)
+	assert.False(t, s.InProgress())
+	assert.False(t, s.RunQueued(downloadId), "download shall wait for upload which was queued before")
+	assert.True(t, s.RunQueued(uploadId))
+	assert.Equal(t, 1, s.QueueLength())
+
+	assert.NoError(t, s.Cancel("download", errors.New("canceled")))
+	assert.Equal(t, 0, s.QueueLength())
+	assert.False(t, s.RunQueued(downloadId))
+	s.Stop(uploadId, nil)
+	assert.False(t, s.InProgress())
+}
+
+func TestStartOrQueue(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	started := make(chan bool, 10)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, queued, err := s.StartOrQueue("upload backup", 2, false)
+			if err == nil {
+				started <- queued
+			}
+		}()
+	}
+	wg.Wait()
+	close(started)
+	inProgress, queued := 0, 0
+	for isQueued := range started {
+		if isQueued {
+			queued++
+		} else {
+			inProgress++
+		}
+	}
+	assert.Equal(t, 1, inProgress, "concurrent requests shall not start several commands")
+	assert.Equal(t, 2, queued, "concurrent requests shall not exceed max queue size")
+
+	_, _, _, err := s.StartOrQueue("upload other_backup", 3, true)
+	assert.ErrorContains(t, err, "`upload` already in progress or queued")
+	_, _, queuedCreate, err := s.StartOrQueue("create --tables=db.* backup", 3, true)
+	assert.NoError(t, err)
+	assert.True(t, queuedCreate)
+	assert.Equal(t, "create", CommandName("create --tables=db.* backup"))
+}
+
+func TestJobProgress(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	uploadId, _ := s.Start("upload")
+	s.SetTotalBytes(uploadId, 1000)
+	s.commands[uploadId].progressStart = time.Now().Add(-10 * time.Second)
+	s.AddProgress(uploadId, 200, 100)
+	s.AddStreamedBytes(uploadId, "db.table", "default_all_1_1_0", 300)
+	jobStatus, err := s.GetJobStatus(uploadId)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(500), jobStatus.ProcessedBytes)
+	assert.Equal(t, 50.0, jobStatus.ProgressPercent)
+	assert.Equal(t, "db.table", jobStatus.CurrentTable)
+	assert.Equal(t, "default_all_1_1_0", jobStatus.CurrentPart)
+	assert.InDelta(t, 10, jobStatus.EtaSeconds, 1)
+
+	// table finished, streamed bytes replaced by table size
+	s.AddStreamedBytes(uploadId, "", "", -300)
+	s.AddProgress(uploadId, 400, 200)
+	jobStatus, err = s.GetJobStatus(uploadId)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(600), jobStatus.ProcessedBytes)
+	assert.Equal(t, uint64(300), jobStatus.BytesTransferred)
+
+	s.Stop(uploadId, nil)
+	jobStatus, err = s.GetJobStatus(uploadId)
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, jobStatus.ProgressPercent)
+	assert.Empty(t, jobStatus.CurrentTable)
+	assert.Zero(t, jobStatus.EtaSeconds)
+
+	s.StartCLI("download")
+	s.SetTotalBytes(NotFromAPI, 10)
+	s.AddProgress(NotFromAPI, 5, 5)
+	jobStatus = s.GetCLIJobStatus()
+	assert.Equal(t, "download", jobStatus.Command)
+	assert.Equal(t, 50.0, jobStatus.ProgressPercent)
+	_, err = s.GetJobStatus(NotFromAPI)
+	assert.Error(t, err, "CLI command is not available as API job")
+}
+
+func TestCancelById(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	uploadId, ctx := s.Start("upload")
+	queuedId, _ := s.StartQueued("download")
+	assert.Error(t, s.CancelById(100, errors.New("canceled")))
+	assert.NoError(t, s.CancelById(uploadId, errors.New("canceled")))
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	assert.Error(t, s.CancelById(uploadId, errors.New("canceled")), "finished command can't be canceled twice")
+	assert.NoError(t, s.CancelById(queuedId, errors.New("canceled")))
+
+	// canceled command still cleans up, WaitFinished waits until Stop
+	waitCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.WaitFinished(waitCtx), context.DeadlineExceeded)
+	s.Stop(uploadId, context.Canceled)
+	jobStatus, err := s.GetJobStatus(uploadId)
+	assert.NoError(t, err)
+	assert.Equal(t, CancelStatus, jobStatus.Status)
+	assert.NoError(t, s.WaitFinished(context.Background()))
+}
+
+func TestCLITimeout(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	s.SetCLITimeout(time.Hour)
+	ctx, cancel, err := s.GetContextWithCancel(NotFromAPI)
+	assert.NoError(t, err)
+	defer cancel()
+	deadline, hasDeadline := ctx.Deadline()
+	assert.True(t, hasDeadline)
+	time.Sleep(10 * time.Millisecond)
+	nestedCtx, nestedCancel, err := s.GetContextWithCancel(NotFromAPI)
+	assert.NoError(t, err)
+	defer nestedCancel()
+	nestedDeadline, _ := nestedCtx.Deadline()
+	assert.Equal(t, deadline, nestedDeadline, "nested operation shall not extend --timeout")
+	s.CancelCLI()
+	assert.True(t, errors.Is(nestedCtx.Err(), context.Canceled))
+}
+
+func TestCommandHistoryTrim(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	runningId, runningCtx := s.Start("watch")
+	commandId, ok := common.GetCommandId(runningCtx)
+	assert.True(t, ok)
+	assert.Equal(t, runningId, commandId)
+	firstId := 0
+	for i := 0; i < maxCommandHistory+10; i++ {
+		id, _ := s.Start("create")
+		if i == 0 {
+			firstId = id
+		}
+		s.appendLog(id, &apexLog.Entry{Timestamp: time.Now(), Level: apexLog.InfoLevel, Message: "done"})
+		s.Stop(id, nil)
+	}
+	_, err := s.GetJobStatus(runningId)
+	assert.NoError(t, err, "command in progress shall be kept")
+	_, err = s.GetJobStatus(firstId)
+	assert.Error(t, err, "the oldest finished command shall be removed from history")
+	records, _, changed := s.GetLogs(firstId, 0)
+	assert.Empty(t, records)
+	select {
+	case <-changed:
+	default:
+		assert.Fail(t, "followers of removed command shall not wait")
+	}
+	assert.Len(t, s.logs, maxCommandHistory)
+	assert.Len(t, s.GetStatus(false, "", 0), maxCommandHistory)
+
+	lastId, ctx := s.Start("upload")
+	commandId, _ = common.GetCommandId(ctx)
+	assert.Equal(t, lastId, commandId)
+	jobStatus, err := s.GetJobStatus(lastId)
+	assert.NoError(t, err)
+	assert.Equal(t, "upload", jobStatus.Command)
+	s.Stop(runningId, nil)
+	assert.Len(t, s.GetStatus(false, "", 0), maxCommandHistory)
+	_, err = s.GetJobStatus(runningId)
+	assert.Error(t, err, "finished long-running command shall be removed as the oldest")
+}
@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"io"
+	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"strings"
 	"time"
@@ -19,6 +21,7 @@ import (
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
+	apexLog "github.com/apex/log"
 	"github.com/pkg/errors"
 )
 
@@ -45,8 +48,8 @@ func (a *AzureBlob) Connect(ctx context.Context) error {
 	if a.Config.AccountName == "" {
 		return fmt.Errorf("azblob account name not set")
 	}
-	if a.Config.AccountKey == "" && a.Config.SharedAccessSignature == "" && !a.Config.UseManagedIdentity {
-		return fmt.Errorf("azblob account key or SAS or use_managed_identity must be set")
+	if a.Config.AccountKey == "" && a.Config.SharedAccessSignature == "" && !a.Config.UseManagedIdentity && !a.Config.UseWorkloadIdentity {
+		return fmt.Errorf("azblob account key or SAS or use_managed_identity or use_workload_identity must be set")
 	}
 	var (
 		err        error
@@ -66,14 +69,21 @@ func (a *AzureBlob) Connect(ctx context.Context) error {
 	} else if a.Config.SharedAccessSignature != "" {
 		credential = azblob.NewAnonymousCredential()
 		urlString = fmt.Sprintf("%s://%s.blob.%s?%s", a.Config.EndpointSchema, a.Config.AccountName, a.Config.EndpointSuffix, a.Config.SharedAccessSignature)
-	} else if a.Config.UseManagedIdentity {
+	} else if a.Config.UseManagedIdentity || a.Config.UseWorkloadIdentity {
 		azureEnv, err := azure.EnvironmentFromName("AZUREPUBLICCLOUD")
 		if err != nil {
 			return err
 		}
 		var spToken *adal.ServicePrincipalToken
-		msiEndpoint, _ := adal.GetMSIVMEndpoint()
-		spToken, err = adal.NewServicePrincipalTokenFromMSI(msiEndpoint, azureEnv.ResourceIdentifiers.Storage)
+		if a.Config.UseWorkloadIdentity {
+			spToken, err = a.newWorkloadIdentityToken(azureEnv)
+		} else if a.Config.ManagedIdentityID != "" {
+			msiEndpoint, _ := adal.GetMSIVMEndpoint()
+			spToken, err = adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(msiEndpoint, azureEnv.ResourceIdentifiers.Storage, a.Config.ManagedIdentityID)
+		} else {
+			msiEndpoint, _ := adal.GetMSIVMEndpoint()
+			spToken, err = adal.NewServicePrincipalTokenFromMSI(msiEndpoint, azureEnv.ResourceIdentifiers.Storage)
+		}
 		if err != nil {
 			return err
 		}
@@ -105,15 +115,36 @@ func (a *AzureBlob) Connect(ctx context.Context) error {
 	// don't pollute syslog with expected 404'a and other garbage logs
 	pipeline.SetForceLogEnabled(false)
 
+	pipelineOptions := azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{
+			TryTimeout: timeout,
+		},
+	}
+	if isCustomHTTPTransport(a.Config.CACert, a.Config.InsecureSkipVerify, a.Config.Proxy) {
+		if a.Config.InsecureSkipVerify {
+			apexLog.Warn("insecure_skip_verify: true, TLS certificate of Azure Blob endpoint will not be verified")
+		}
+		transport, err := newHTTPTransport(a.Config.CACert, a.Config.InsecureSkipVerify, a.Config.Proxy)
+		if err != nil {
+			return err
+		}
+		httpClient := &http.Client{Transport: transport}
+		pipelineOptions.HTTPSender = pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+			return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+				response, err := httpClient.Do(request.WithContext(ctx))
+				if err != nil {
+					err = pipeline.NewError(err, "HTTP request failed")
+				}
+				return pipeline.NewHTTPResponse(response), err
+			}
+		})
+	}
+
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
-		a.Pipeline = azblob.NewPipeline(credential, azblob.PipelineOptions{
-			Retry: azblob.RetryOptions{
-				TryTimeout: timeout,
-			},
-		})
+		a.Pipeline = azblob.NewPipeline(credential, pipelineOptions)
 		a.Container = azblob.NewServiceURL(*u, a.Pipeline).NewContainerURL(a.Config.Container)
 		_, err = a.Container.Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone)
 		if err != nil && !isContainerAlreadyExists(err) {
@@ -147,6 +178,37 @@ func (a *AzureBlob) Connect(ctx context.Context) error {
 	}
 }
 
+// newWorkloadIdentityToken - exchange federated token which AKS workload identity webhook mount into pod, to Azure AD token
+// AZURE_CLIENT_ID, AZURE_TENANT_ID, AZURE_FEDERATED_TOKEN_FILE and AZURE_AUTHORITY_HOST environment variables are injected by webhook
+func (a *AzureBlob) newWorkloadIdentityToken(azureEnv azure.Environment) (*adal.ServicePrincipalToken, error) {
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	if a.Config.ManagedIdentityID != "" {
+		clientID = a.Config.ManagedIdentityID
+	}
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+	if clientID == "" || tenantID == "" || tokenFile == "" {
+		return nil, fmt.Errorf("azblob use_workload_identity require AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_FEDERATED_TOKEN_FILE environment variables")
+	}
+	authorityHost := azureEnv.ActiveDirectoryEndpoint
+	if envAuthorityHost := os.Getenv("AZURE_AUTHORITY_HOST"); envAuthorityHost != "" {
+		authorityHost = envAuthorityHost
+	}
+	oauthConfig, err := adal.NewOAuthConfig(authorityHost, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	// token file is rotated by kubelet, so read it before each refresh
+	jwtCallback := func() (string, error) {
+		jwt, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("can't read %s: %v", tokenFile, err)
+		}
+		return string(jwt), nil
+	}
+	return adal.NewServicePrincipalTokenFromFederatedTokenCallback(*oauthConfig, clientID, jwtCallback, azureEnv.ResourceIdentifiers.Storage)
+}
+
 func (a *AzureBlob) Close(ctx context.Context) error {
 	return nil
 }
@@ -239,6 +301,7 @@ func (a *AzureBlob) Walk(ctx context.Context, azPath string, recursive bool, pro
 					name:         strings.TrimPrefix(blob.Name, prefix),
 					size:         size,
 					lastModified: blob.Properties.LastModified,
+					accessTier:   string(blob.Properties.AccessTier),
 				}); err != nil {
 					return err
 				}
@@ -260,6 +323,7 @@ func (a *AzureBlob) Walk(ctx context.Context, azPath string, recursive bool, pro
 					name:         strings.TrimPrefix(blob.Name, prefix),
 					size:         size,
 					lastModified: blob.Properties.LastModified,
+					accessTier:   string(blob.Properties.AccessTier),
 				}); err != nil {
 					return err
 				}
@@ -283,7 +347,7 @@ func (a *AzureBlob) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey st
 
 	startCopy, err := destinationBlobURL.StartCopyFromURL(ctx, sourceBlobURL.URL(), nil, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{}, azblob.AccessTierNone, nil)
 	if err != nil {
-		return 0, fmt.Errorf("azblob->CopyObject failed to start copy operation: %v", err)
+		return 0, fmt.Errorf("azblob->CopyObject failed to start copy operation: %w", err)
 	}
 	copyStatus := startCopy.CopyStatus()
 	copyStatusDesc := ""
@@ -295,7 +359,7 @@ func (a *AzureBlob) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey st
 		time.Sleep(sleepDuration * time.Duration(pollCount*2))
 		dstMeta, err := destinationBlobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
 		if err != nil {
-			return 0, fmt.Errorf("azblob->CopyObject failed to destinationBlobURL.GetProperties operation: %v", err)
+			return 0, fmt.Errorf("azblob->CopyObject failed to destinationBlobURL.GetProperties operation: %w", err)
 		}
 		copyStatus = dstMeta.CopyStatus()
 		copyStatusDesc = dstMeta.CopyStatusDescription()
@@ -308,10 +372,20 @@ func (a *AzureBlob) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey st
 	return size, nil
 }
 
+// IsRetryableError - response error implements net.Error, so check HTTP status before network errors
+func (a *AzureBlob) IsRetryableError(err error) bool {
+	var responseErr azblob.ResponseError
+	if errors.As(err, &responseErr) && responseErr.Response() != nil {
+		return isRetryableHTTPStatus(responseErr.Response().StatusCode)
+	}
+	return isTransientError(err)
+}
+
 type azureBlobFile struct {
 	size         int64
 	lastModified time.Time
 	name         string
+	accessTier   string
 }
 
 func (f *azureBlobFile) Size() int64 {
@@ -326,6 +400,37 @@ func (f *azureBlobFile) LastModified() time.Time {
 	return f.lastModified
 }
 
+func (f *azureBlobFile) StorageClass() string {
+	return f.accessTier
+}
+
+func (a *AzureBlob) isArchivalStorageClass(storageClass string) bool {
+	return azblob.AccessTierType(storageClass) == azblob.AccessTierArchive
+}
+
+// rehydrationState - blob stays in Archive tier with rehydrate-pending-to-* archive status until rehydration done
+func (a *AzureBlob) rehydrationState(ctx context.Context, key string) (RehydrationState, error) {
+	blob := a.Container.NewBlobURL(path.Join(a.Config.Path, key))
+	r, err := blob.GetProperties(ctx, azblob.BlobAccessConditions{}, a.CPK)
+	if err != nil {
+		return RehydrationNotRequired, err
+	}
+	if azblob.AccessTierType(r.AccessTier()) != azblob.AccessTierArchive {
+		return RehydrationNotRequired, nil
+	}
+	if strings.HasPrefix(r.ArchiveStatus(), "rehydrate-pending-") {
+		return RehydrationInProgress, nil
+	}
+	return RehydrationRequired, nil
+}
+
+// requestRehydration - change tier to azblob->rehydrate_tier, Azure keeps blob in new tier after rehydration
+func (a *AzureBlob) requestRehydration(ctx context.Context, key, _ string) error {
+	blob := a.Container.NewBlobURL(path.Join(a.Config.Path, key))
+	_, err := blob.SetTier(ctx, azblob.AccessTierType(a.Config.RehydrateTier), azblob.LeaseAccessConditions{}, azblob.RehydratePriorityType(a.Config.RehydratePriority))
+	return err
+}
+
 func isContainerAlreadyExists(err error) bool {
 	if err != nil {
 		if storageErr, ok := err.(azblob.StorageError); ok { // This error is a Service-specific
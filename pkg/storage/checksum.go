@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// ChecksumAlgorithm values for s3->checksum_algorithm
+const (
+	ChecksumCRC32C = "CRC32C"
+	ChecksumSHA256 = "SHA256"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func newChecksumHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case ChecksumCRC32C:
+		return func() hash.Hash { return crc32.New(crc32cTable) }, nil
+	case ChecksumSHA256:
+		return sha256.New, nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %s, shall be %s or %s", algorithm, ChecksumCRC32C, ChecksumSHA256)
+}
+
+// partChecksumReader - calculate checksum of whole stream and checksum of each partSize chunk during upload,
+// to compare with checksum which remote storage calculated for single part or multipart object
+type partChecksumReader struct {
+	r         io.Reader
+	newHash   func() hash.Hash
+	partSize  int64
+	full      hash.Hash
+	part      hash.Hash
+	partBytes int64
+	partSums  [][]byte
+}
+
+func newPartChecksumReader(r io.Reader, newHash func() hash.Hash, partSize int64) *partChecksumReader {
+	return &partChecksumReader{
+		r:        r,
+		newHash:  newHash,
+		partSize: partSize,
+		full:     newHash(),
+		part:     newHash(),
+	}
+}
+
+func (c *partChecksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	buf := p[:n]
+	for len(buf) > 0 {
+		chunk := int64(len(buf))
+		if c.partSize > 0 && chunk > c.partSize-c.partBytes {
+			chunk = c.partSize - c.partBytes
+		}
+		c.full.Write(buf[:chunk])
+		c.part.Write(buf[:chunk])
+		c.partBytes += chunk
+		buf = buf[chunk:]
+		if c.partSize > 0 && c.partBytes == c.partSize {
+			c.partSums = append(c.partSums, c.part.Sum(nil))
+			c.part = c.newHash()
+			c.partBytes = 0
+		}
+	}
+	return n, err
+}
+
+// Checksum - base64 encoded checksum of whole stream, the same as for single part upload
+func (c *partChecksumReader) Checksum() string {
+	return base64.StdEncoding.EncodeToString(c.full.Sum(nil))
+}
+
+// CompositeChecksum - checksum of concatenated part checksums with `-<parts count>` suffix, the same as for S3 multipart upload
+func (c *partChecksumReader) CompositeChecksum() string {
+	sums := c.partSums
+	if c.partBytes > 0 {
+		sums = append(sums, c.part.Sum(nil))
+	}
+	h := c.newHash()
+	for _, sum := range sums {
+		h.Write(sum)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(sums))
+}
+
+// Verify - remoteChecksum could be full object or composite multipart checksum
+func (c *partChecksumReader) Verify(remoteChecksum string) error {
+	expected := c.Checksum()
+	if strings.Contains(remoteChecksum, "-") {
+		expected = c.CompositeChecksum()
+	}
+	if remoteChecksum != expected {
+		return fmt.Errorf("%w: remote %s, local %s", ErrChecksumMismatch, remoteChecksum, expected)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartChecksumReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 25)
+	newHash, err := newChecksumHash("sha256")
+	assert.NoError(t, err)
+	r := newPartChecksumReader(bytes.NewReader(data), newHash, 100)
+	_, err = io.Copy(io.Discard, r)
+	assert.NoError(t, err)
+
+	full := sha256.Sum256(data)
+	assert.NoError(t, r.Verify(base64.StdEncoding.EncodeToString(full[:])))
+
+	composite := sha256.New()
+	for _, part := range [][]byte{data[:100], data[100:200], data[200:]} {
+		sum := sha256.Sum256(part)
+		composite.Write(sum[:])
+	}
+	assert.NoError(t, r.Verify(fmt.Sprintf("%s-3", base64.StdEncoding.EncodeToString(composite.Sum(nil)))))
+	assert.True(t, errors.Is(r.Verify(fmt.Sprintf("%s-2", base64.StdEncoding.EncodeToString(composite.Sum(nil)))), ErrChecksumMismatch))
+
+	_, err = newChecksumHash("md5")
+	assert.Error(t, err)
+}
+
+func TestGCSChecksumReader(t *testing.T) {
+	data := []byte("clickhouse-backup")
+	read, err := io.ReadAll(&gcsChecksumReader{ReadCloser: io.NopCloser(bytes.NewReader(data)), key: "key"})
+	assert.NoError(t, err)
+	assert.Equal(t, data, read)
+	badCRC := iotest.ErrReader(fmt.Errorf("storage: bad CRC on read: got 1, want 2"))
+	_, err = io.ReadAll(&gcsChecksumReader{ReadCloser: io.NopCloser(badCRC), key: "key"})
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	assert.EqualError(t, err, "key: checksum mismatch: got 1, want 2")
+	_, err = io.ReadAll(&gcsChecksumReader{ReadCloser: io.NopCloser(iotest.ErrReader(io.ErrUnexpectedEOF)), key: "key"})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDictionaryMaxArchiveSize - trained dictionary helps only for small inputs, like archives of small parts with checksums.txt, columns.txt, count.txt,
+// bigger archives compressed without dictionary, decoder choose dictionary by ID from frame header
+const zstdDictionaryMaxArchiveSize = 1 << 20
+
+// zstdOptions - applied only when compression_format: zstd
+type zstdOptions struct {
+	// windowLog - 10..29 set maximum window size to 2^windowLog, 0 means encoder default, klauspost/compress doesn't implement long distance matching, so it is not the same as `zstd --long=windowLog`
+	windowLog  int
+	dictionary []byte
+	// decoderDictionaries - backups in incremental chain could be uploaded with different dictionaries
+	decoderDictionaries [][]byte
+	// compressors - shared by all parallel uploads of BackupDestination, limits CPU usage for multistream compression, see general->compression_concurrency
+	compressors chan struct{}
+}
+
+// zstdLargeWindowLog - each encoder keeps about 2 windows of history and blocks, with bigger window encoder uses single goroutine and multistream is disabled,
+// otherwise memory grows as 2^windowLog * compression_concurrency * upload_concurrency and upload fails with OOM
+const zstdLargeWindowLog = 24
+
+// ZstdEncoderMemory - approximate memory required for compress data with zstd_window_log during upload with upload_concurrency
+func ZstdEncoderMemory(windowLog int, uploadConcurrency int) uint64 {
+	if windowLog <= zstdLargeWindowLog {
+		return 0
+	}
+	return uint64(uploadConcurrency) * 2 << windowLog
+}
+
+func newZstdOptions(cfg *config.Config) zstdOptions {
+	concurrency := cfg.General.CompressionConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	return zstdOptions{
+		windowLog:   cfg.General.ZstdWindowLog,
+		compressors: make(chan struct{}, concurrency),
+	}
+}
+
+// SetZstdDictionary - dictionary trained via `zstd --train`, used for small archives during upload
+func (bd *BackupDestination) SetZstdDictionary(dictionary []byte) {
+	bd.zstd.dictionary = dictionary
+}
+
+func (bd *BackupDestination) AddZstdDecoderDictionary(dictionary []byte) {
+	bd.zstd.decoderDictionaries = append(bd.zstd.decoderDictionaries, dictionary)
+}
+
+func (bd *BackupDestination) zstdEncoderOptions(totalBytes int64) []zstd.EOption {
+	options := make([]zstd.EOption, 0)
+	if bd.zstd.windowLog > 0 {
+		options = append(options, zstd.WithWindowSize(1<<bd.zstd.windowLog))
+	}
+	if bd.zstd.windowLog > zstdLargeWindowLog {
+		options = append(options, zstd.WithEncoderConcurrency(1))
+	}
+	if len(bd.zstd.dictionary) > 0 && totalBytes <= zstdDictionaryMaxArchiveSize {
+		options = append(options, zstd.WithEncoderDict(bd.zstd.dictionary))
+	}
+	return options
+}
+
+func (bd *BackupDestination) zstdDecoderOptions() []zstd.DOption {
+	if len(bd.zstd.decoderDictionaries) == 0 {
+		return nil
+	}
+	return []zstd.DOption{zstd.WithDecoderDicts(bd.zstd.decoderDictionaries...)}
+}
+
+// ValidateZstdDictionary - dictionary shall be in zstd format with magic number and ID, raw content dictionaries are not supported
+func ValidateZstdDictionary(dictionary []byte) (uint32, error) {
+	d, err := zstd.InspectDictionary(dictionary)
+	if err != nil {
+		return 0, fmt.Errorf("invalid zstd dictionary, use `zstd --train` to create it: %v", err)
+	}
+	return d.ID(), nil
+}
+
+// CompressionLevel - compression_level from remote storage config section
+func (bd *BackupDestination) CompressionLevel() int {
+	return bd.compressionLevel
+}
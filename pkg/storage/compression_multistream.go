@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMultistreamBlockSize - equal to window of zstdLargeWindowLog, concatenated zstd frames is valid zstd stream, so archive could be split into blocks which compressed independently,
+// each block lose matches with previous blocks, so block shall be big enough to keep compression ratio close to single stream
+const zstdMultistreamBlockSize = 16 << 20
+
+type zstdMultistreamBlock struct {
+	data []byte
+	err  error
+}
+
+// zstdMultistreamWriter - compress blocks in parallel and write compressed frames to dst in original order,
+// queue capacity limits how many blocks could wait for write, so slow upload stops reading local files instead of memory growth
+type zstdMultistreamWriter struct {
+	ctx         context.Context
+	dst         io.Writer
+	encoder     *zstd.Encoder
+	compressors chan struct{}
+	blockSize   int
+	buf         []byte
+	queue       chan chan zstdMultistreamBlock
+	// failed - closed after first compression or write error, to stop reading rest of archive
+	failed     chan struct{}
+	writerDone chan struct{}
+	writerErr  error
+	closed     bool
+}
+
+// useZstdMultistream - each parallel block requires own window, so large windows are compressed as single stream, see zstdLargeWindowLog
+func (bd *BackupDestination) useZstdMultistream(totalBytes int64) bool {
+	return bd.compressionFormat == "zstd" && cap(bd.zstd.compressors) > 1 && bd.zstd.windowLog <= zstdLargeWindowLog && totalBytes > zstdMultistreamBlockSize
+}
+
+func (bd *BackupDestination) newZstdMultistreamWriter(ctx context.Context, dst io.Writer, compressionLevel int, totalBytes int64) (*zstdMultistreamWriter, error) {
+	concurrency := cap(bd.zstd.compressors)
+	encoderOptions := append([]zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compressionLevel)),
+		zstd.WithEncoderConcurrency(concurrency),
+	}, bd.zstdEncoderOptions(totalBytes)...)
+	encoder, err := zstd.NewWriter(nil, encoderOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("can't create zstd encoder: %v", err)
+	}
+	blockSize := zstdMultistreamBlockSize
+	m := &zstdMultistreamWriter{
+		ctx:         ctx,
+		dst:         dst,
+		encoder:     encoder,
+		compressors: bd.zstd.compressors,
+		blockSize:   blockSize,
+		buf:         make([]byte, 0, blockSize),
+		queue:       make(chan chan zstdMultistreamBlock, concurrency),
+		failed:      make(chan struct{}),
+		writerDone:  make(chan struct{}),
+	}
+	go m.writeFrames()
+	return m, nil
+}
+
+func (m *zstdMultistreamWriter) writeFrames() {
+	defer close(m.writerDone)
+	for result := range m.queue {
+		block := <-result
+		if m.writerErr != nil {
+			continue
+		}
+		err := block.err
+		if err == nil {
+			_, err = m.dst.Write(block.data)
+		}
+		if err != nil {
+			m.writerErr = err
+			close(m.failed)
+		}
+	}
+}
+
+func (m *zstdMultistreamWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := m.blockSize - len(m.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		m.buf = append(m.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(m.buf) == m.blockSize {
+			if err := m.compressBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (m *zstdMultistreamWriter) compressBlock() error {
+	select {
+	case <-m.failed:
+		return fmt.Errorf("zstd multistream writer failed: %v", m.writerErr)
+	case <-m.ctx.Done():
+		return m.ctx.Err()
+	case m.compressors <- struct{}{}:
+	}
+	block := m.buf
+	m.buf = make([]byte, 0, m.blockSize)
+	result := make(chan zstdMultistreamBlock, 1)
+	go func() {
+		defer func() { <-m.compressors }()
+		result <- zstdMultistreamBlock{data: m.encoder.EncodeAll(block, make([]byte, 0, len(block)/2))}
+	}()
+	select {
+	case m.queue <- result:
+		return nil
+	case <-m.failed:
+		return fmt.Errorf("zstd multistream writer failed: %v", m.writerErr)
+	case <-m.ctx.Done():
+		// compression goroutine write into buffered channel, so it will not leak
+		return m.ctx.Err()
+	}
+}
+
+// Close - flush last block and wait until all frames written, dst is not closed
+func (m *zstdMultistreamWriter) Close() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	var err error
+	if len(m.buf) > 0 {
+		err = m.compressBlock()
+	}
+	close(m.queue)
+	<-m.writerDone
+	if closeErr := m.encoder.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = m.writerErr
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZstdMultistreamWriter(t *testing.T) {
+	bd := &BackupDestination{compressionFormat: "zstd", zstd: zstdOptions{compressors: make(chan struct{}, 4)}}
+	data := make([]byte, zstdMultistreamBlockSize*3+12345)
+	rnd := rand.New(rand.NewSource(0))
+	for i := range data {
+		data[i] = byte('a' + rnd.Intn(4))
+	}
+	require.True(t, bd.useZstdMultistream(int64(len(data))))
+	compressed := &bytes.Buffer{}
+	w, err := bd.newZstdMultistreamWriter(context.Background(), compressed, 1, int64(len(data)))
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	r, err := zstd.NewReader(compressed)
+	require.NoError(t, err)
+	defer r.Close()
+	decompressed, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, decompressed)
+}
+
+func TestZstdLargeWindow(t *testing.T) {
+	bd := &BackupDestination{compressionFormat: "zstd", zstd: zstdOptions{windowLog: 27, compressors: make(chan struct{}, 4)}}
+	require.False(t, bd.useZstdMultistream(zstdMultistreamBlockSize*3), "large window shall compress single stream")
+	require.Len(t, bd.zstdEncoderOptions(zstdMultistreamBlockSize*3), 2)
+	require.Equal(t, uint64(4*2<<27), ZstdEncoderMemory(27, 4))
+	require.Equal(t, uint64(0), ZstdEncoderMemory(20, 4))
+}
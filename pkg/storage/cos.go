@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"io"
@@ -154,6 +155,15 @@ func (c *COS) DeleteFileFromObjectDiskBackup(ctx context.Context, key string) er
 	return fmt.Errorf("DeleteFileFromObjectDiskBackup not imlemented for %s", c.Kind())
 }
 
+// IsRetryableError - the same classification as S3, access denied or invalid request repeat on each attempt
+func (c *COS) IsRetryableError(err error) bool {
+	var responseErr *cos.ErrorResponse
+	if errors.As(err, &responseErr) && responseErr.Response != nil {
+		return isRetryableHTTPStatus(responseErr.Response.StatusCode)
+	}
+	return isTransientError(err)
+}
+
 type cosFile struct {
 	size         int64
 	lastModified time.Time
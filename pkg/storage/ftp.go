@@ -3,10 +3,13 @@ package storage
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	apexLog "github.com/apex/log"
 	"io"
+	"net"
+	"net/textproto"
 	"os"
 	"path"
 	"strings"
@@ -42,10 +45,28 @@ func (f *FTP) Connect(ctx context.Context) error {
 	if f.Config.Debug {
 		options = append(options, ftp.DialWithDebugOutput(os.Stdout))
 	}
-	if f.Config.TLS {
-		tlsConfig := tls.Config{InsecureSkipVerify: f.Config.SkipTLSVerify}
-		options = append(options, ftp.DialWithTLS(&tlsConfig))
+	if f.Config.TLS || f.Config.TLSExplicit {
+		tlsConfig, err := newTLSConfig(f.Config.CACert, f.Config.SkipTLSVerify)
+		if err != nil {
+			return err
+		}
+		// AUTH TLS upgrade plain connection, so certificate verification require server name explicitly
+		if host, _, splitErr := net.SplitHostPort(f.Config.Address); splitErr == nil {
+			tlsConfig.ServerName = host
+		}
+		// vsftpd, proftpd and others could require TLS session reuse for data connections
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+		if f.Config.SkipTLSVerify {
+			f.Log.Warnf("ftp->skip_tls_verify enabled, server certificate will not be verified")
+		}
+		if f.Config.TLSExplicit {
+			options = append(options, ftp.DialWithExplicitTLS(tlsConfig))
+		} else {
+			options = append(options, ftp.DialWithTLS(tlsConfig))
+		}
 	}
+	// MLSD and MLST are used when server advertise MLST in FEAT, they return machine-readable entries with precise UTC timestamps
+	options = append(options, ftp.DialWithDisabledMLSD(f.Config.DisableMLSD))
 	f.clients = pool.NewObjectPoolWithDefaultConfig(ctx, &ftpPoolFactory{options: options, ftp: f})
 	if f.Config.Concurrency > 1 {
 		f.clients.Config.MaxTotal = int(f.Config.Concurrency) * 3
@@ -84,13 +105,28 @@ func (f *FTP) returnConnectionToPool(ctx context.Context, where string, client *
 }
 
 func (f *FTP) StatFile(ctx context.Context, key string) (RemoteFile, error) {
-	// cant list files, so check the dir
-	dir := path.Dir(path.Join(f.Config.Path, key))
 	client, err := f.getConnectionFromPool(ctx, fmt.Sprintf("StatFile, key=%s", key))
 	if err != nil {
 		return nil, err
 	}
 	defer f.returnConnectionToPool(ctx, fmt.Sprintf("StatFile, key=%s", key), client)
+	// IsTimePreciseInList means server support MLST, so one entry could be requested without listing whole directory
+	if client.IsTimePreciseInList() {
+		entry, err := client.GetEntry(path.Join(f.Config.Path, key))
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "550") {
+				return nil, ErrNotFound
+			}
+			return nil, err
+		}
+		return &ftpFile{
+			size:         int64(entry.Size),
+			lastModified: entry.Time,
+			name:         path.Base(key),
+		}, nil
+	}
+	// cant list files, so check the dir
+	dir := path.Dir(path.Join(f.Config.Path, key))
 	entries, err := client.List(dir)
 	if err != nil {
 		// proftpd return 550 error if `dir` not exists
@@ -216,6 +252,15 @@ func (f *FTP) DeleteFileFromObjectDiskBackup(ctx context.Context, key string) er
 	return fmt.Errorf("DeleteFileFromObjectDiskBackup not imlemented for %s", f.Kind())
 }
 
+// IsRetryableError - 4xx FTP reply codes are transient negative completion, 5xx permanent, like access denied or not found
+func (f *FTP) IsRetryableError(err error) bool {
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) {
+		return replyErr.Code >= 400 && replyErr.Code < 500
+	}
+	return isTransientError(err)
+}
+
 type ftpFile struct {
 	size         int64
 	lastModified time.Time
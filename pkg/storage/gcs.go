@@ -5,10 +5,12 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net/http"
 	"path"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/api/iterator"
@@ -19,6 +21,7 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/apex/log"
+	gax "github.com/googleapis/gax-go/v2"
 	"google.golang.org/api/option"
 	googleHTTPTransport "google.golang.org/api/transport/http"
 )
@@ -28,6 +31,12 @@ type GCS struct {
 	client     *storage.Client
 	Config     *config.GCSConfig
 	clientPool *pool.ObjectPool
+	// encryptionKey - decoded gcs->encryption_key, shall be passed for each read and write of object
+	encryptionKey      []byte
+	retryBackoff       gax.Backoff
+	retryPolicy        storage.RetryPolicy
+	chunkRetryDeadline time.Duration
+	timeout            time.Duration
 }
 
 type debugGCSTransport struct {
@@ -69,6 +78,14 @@ func (gcs *GCS) Kind() string {
 // Connect - connect to GCS
 func (gcs *GCS) Connect(ctx context.Context) error {
 	var err error
+	if gcs.Config.EncryptionKey != "" {
+		if gcs.encryptionKey, err = base64.StdEncoding.DecodeString(gcs.Config.EncryptionKey); err != nil {
+			return fmt.Errorf("can't decode gcs->encryption_key: %v", err)
+		}
+	}
+	if err = gcs.parseRetryConfig(); err != nil {
+		return err
+	}
 	clientOptions := make([]option.ClientOption, 0)
 	clientOptions = append(clientOptions, option.WithTelemetryDisabled())
 	endpoint := "https://storage.googleapis.com/storage/v1/"
@@ -86,7 +103,8 @@ func (gcs *GCS) Connect(ctx context.Context) error {
 		clientOptions = append(clientOptions, option.WithCredentialsFile(gcs.Config.CredentialsFile))
 	}
 
-	if gcs.Config.Debug {
+	customTransport := isCustomHTTPTransport(gcs.Config.CACert, gcs.Config.InsecureSkipVerify, gcs.Config.Proxy)
+	if gcs.Config.Debug || customTransport {
 		if gcs.Config.Endpoint == "" {
 			clientOptions = append([]option.ClientOption{option.WithScopes(storage.ScopeFullControl)}, clientOptions...)
 		}
@@ -95,12 +113,31 @@ func (gcs *GCS) Connect(ctx context.Context) error {
 			clientOptions = append(clientOptions, internaloption.WithDefaultMTLSEndpoint(endpoint))
 		}
 
-		debugClient, _, err := googleHTTPTransport.NewClient(ctx, clientOptions...)
-		if err != nil {
-			return fmt.Errorf("googleHTTPTransport.NewClient error: %v", err)
+		var httpClient *http.Client
+		if customTransport {
+			if gcs.Config.InsecureSkipVerify {
+				log.Warn("insecure_skip_verify: true, TLS certificate of GCS endpoint will not be verified")
+			}
+			baseTransport, err := newHTTPTransport(gcs.Config.CACert, gcs.Config.InsecureSkipVerify, gcs.Config.Proxy)
+			if err != nil {
+				return err
+			}
+			// NewTransport wraps base transport with authentication, like NewClient do with default transport
+			authTransport, err := googleHTTPTransport.NewTransport(ctx, baseTransport, clientOptions...)
+			if err != nil {
+				return fmt.Errorf("googleHTTPTransport.NewTransport error: %v", err)
+			}
+			httpClient = &http.Client{Transport: authTransport}
+		} else {
+			httpClient, _, err = googleHTTPTransport.NewClient(ctx, clientOptions...)
+			if err != nil {
+				return fmt.Errorf("googleHTTPTransport.NewClient error: %v", err)
+			}
+		}
+		if gcs.Config.Debug {
+			httpClient.Transport = debugGCSTransport{base: httpClient.Transport}
 		}
-		debugClient.Transport = debugGCSTransport{base: debugClient.Transport}
-		clientOptions = append(clientOptions, option.WithHTTPClient(debugClient))
+		clientOptions = append(clientOptions, option.WithHTTPClient(httpClient))
 	}
 
 	factory := pool.NewPooledObjectFactory(
@@ -109,6 +146,7 @@ func (gcs *GCS) Connect(ctx context.Context) error {
 			if err != nil {
 				return nil, err
 			}
+			sClient.SetRetry(storage.WithBackoff(gcs.retryBackoff), storage.WithPolicy(gcs.retryPolicy))
 			return &clientObject{
 					Client: sClient,
 				},
@@ -186,6 +224,65 @@ func (gcs *GCS) Walk(ctx context.Context, gcsPath string, recursive bool, proces
 	}
 }
 
+func (gcs *GCS) parseRetryConfig() error {
+	var err error
+	if gcs.retryBackoff.Initial, err = time.ParseDuration(gcs.Config.RetryInitialBackoff); err != nil {
+		return fmt.Errorf("invalid gcs->retry_initial_backoff: %v", err)
+	}
+	if gcs.retryBackoff.Max, err = time.ParseDuration(gcs.Config.RetryMaxBackoff); err != nil {
+		return fmt.Errorf("invalid gcs->retry_max_backoff: %v", err)
+	}
+	gcs.retryBackoff.Multiplier = gcs.Config.RetryMultiplier
+	if gcs.chunkRetryDeadline, err = time.ParseDuration(gcs.Config.ChunkRetryDeadline); err != nil {
+		return fmt.Errorf("invalid gcs->chunk_retry_deadline: %v", err)
+	}
+	if gcs.timeout, err = time.ParseDuration(gcs.Config.Timeout); err != nil {
+		return fmt.Errorf("invalid gcs->timeout: %v", err)
+	}
+	switch gcs.Config.RetryPolicy {
+	case "always":
+		gcs.retryPolicy = storage.RetryAlways
+	case "never":
+		gcs.retryPolicy = storage.RetryNever
+	default:
+		gcs.retryPolicy = storage.RetryIdempotent
+	}
+	return nil
+}
+
+// withRetry - storage.Client doesn't limit attempts, only context deadline, so each object operation get own counter of failed attempts
+// IsRetryableError - the same classification which GCS client uses for own retries
+func (gcs *GCS) IsRetryableError(err error) bool {
+	return !errors.Is(err, storage.ErrObjectNotExist) && !errors.Is(err, storage.ErrBucketNotExist) && storage.ShouldRetry(err)
+}
+
+func (gcs *GCS) withRetry(obj *storage.ObjectHandle) *storage.ObjectHandle {
+	if gcs.Config.RetryMaxAttempts <= 0 {
+		return obj
+	}
+	var failedAttempts int32
+	return obj.Retryer(storage.WithErrorFunc(func(err error) bool {
+		return storage.ShouldRetry(err) && atomic.AddInt32(&failedAttempts, 1) < int32(gcs.Config.RetryMaxAttempts)
+	}))
+}
+
+// object - apply customer-supplied encryption key, GCS returns error for read and write of CSEK encrypted object without the same key
+func (gcs *GCS) object(client *storage.Client, key string) *storage.ObjectHandle {
+	obj := client.Bucket(gcs.Config.Bucket).Object(key)
+	if len(gcs.encryptionKey) > 0 {
+		obj = obj.Key(gcs.encryptionKey)
+	}
+	return gcs.withRetry(obj)
+}
+
+// withTimeout - applied only for short operations, upload and download duration depends on object size
+func (gcs *GCS) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if gcs.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, gcs.timeout)
+}
+
 func (gcs *GCS) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
 	pClientObj, err := gcs.clientPool.BorrowObject(ctx)
 	if err != nil {
@@ -193,14 +290,33 @@ func (gcs *GCS) GetFileReader(ctx context.Context, key string) (io.ReadCloser, e
 		return nil, err
 	}
 	pClient := pClientObj.(*clientObject).Client
-	obj := pClient.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key))
+	key = path.Join(gcs.Config.Path, key)
+	obj := gcs.object(pClient, key)
 	reader, err := obj.NewReader(ctx)
 	if err != nil {
 		gcs.clientPool.InvalidateObject(ctx, pClientObj)
 		return nil, err
 	}
 	gcs.clientPool.ReturnObject(ctx, pClientObj)
-	return reader, nil
+	// reader compares CRC32C from response headers with downloaded data when whole object is read, check is skipped for decompressive transcoding
+	if reader.Attrs.ContentEncoding == "gzip" {
+		return reader, nil
+	}
+	return &gcsChecksumReader{ReadCloser: reader, key: key}, nil
+}
+
+// gcsChecksumReader - wrap CRC32C mismatch error of storage.Reader into ErrChecksumMismatch
+type gcsChecksumReader struct {
+	io.ReadCloser
+	key string
+}
+
+func (r *gcsChecksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && strings.HasPrefix(err.Error(), "storage: bad CRC on read") {
+		return n, fmt.Errorf("%s: %w: %s", r.key, ErrChecksumMismatch, strings.TrimPrefix(err.Error(), "storage: bad CRC on read: "))
+	}
+	return n, err
 }
 
 func (gcs *GCS) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
@@ -215,24 +331,49 @@ func (gcs *GCS) PutFile(ctx context.Context, key string, r io.ReadCloser) error
 	}
 	pClient := pClientObj.(*clientObject).Client
 	key = path.Join(gcs.Config.Path, key)
-	obj := pClient.Bucket(gcs.Config.Bucket).Object(key)
+	obj := gcs.object(pClient, key)
 
-	writer := obj.NewWriter(ctx)
-	writer.StorageClass = gcs.Config.StorageClass
+	// cancel of writer context aborts upload, so object will not be created with partial data
+	writerCtx, cancelWriter := context.WithCancel(ctx)
+	defer cancelWriter()
+	writer := obj.NewWriter(writerCtx)
+	writer.StorageClass = getStorageClass(ctx, gcs.Config.StorageClass)
+	writer.ChunkRetryDeadline = gcs.chunkRetryDeadline
+	if gcs.Config.KMSKeyName != "" {
+		writer.KMSKeyName = gcs.Config.KMSKeyName
+	}
 	if len(gcs.Config.ObjectLabels) > 0 {
 		writer.Metadata = gcs.Config.ObjectLabels
 	}
-	defer func() {
-		if err := writer.Close(); err != nil {
-			log.Warnf("can't close writer: %+v", err)
+	crc := crc32.New(crc32cTable)
+	buffer := make([]byte, 512*1024)
+	if _, err = io.CopyBuffer(writer, io.TeeReader(r, crc), buffer); err != nil {
+		cancelWriter()
+		if closeErr := writer.Close(); closeErr != nil {
+			log.Warnf("can't close writer: %+v", closeErr)
+		}
+		gcs.clientPool.InvalidateObject(ctx, pClientObj)
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		gcs.clientPool.InvalidateObject(ctx, pClientObj)
+		return err
+	}
+	// CRC32C of data is unknown before upload, so compare it with CRC32C calculated by GCS after upload
+	if attrs, localCRC32C := writer.Attrs(), crc.Sum32(); attrs != nil && attrs.CRC32C != localCRC32C {
+		mismatchErr := fmt.Errorf("%s: %w: remote crc32c %08x, local %08x", key, ErrChecksumMismatch, attrs.CRC32C, localCRC32C)
+		// don't keep corrupted object, delete only generation which was uploaded, concurrent upload of the same key is not affected
+		deleteCtx, cancel := gcs.withTimeout(ctx)
+		defer cancel()
+		if err = obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(deleteCtx); err != nil {
 			gcs.clientPool.InvalidateObject(ctx, pClientObj)
-			return
+			return fmt.Errorf("%w, can't delete corrupted object: %v", mismatchErr, err)
 		}
 		gcs.clientPool.ReturnObject(ctx, pClientObj)
-	}()
-	buffer := make([]byte, 512*1024)
-	_, err = io.CopyBuffer(writer, r, buffer)
-	return err
+		return mismatchErr
+	}
+	gcs.clientPool.ReturnObject(ctx, pClientObj)
+	return nil
 }
 
 func (gcs *GCS) StatFile(ctx context.Context, key string) (RemoteFile, error) {
@@ -242,7 +383,9 @@ func (gcs *GCS) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 		return nil, err
 	}
 	pClient := pClientObj.(*clientObject).Client
-	objAttr, err := pClient.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key)).Attrs(ctx)
+	ctx, cancel := gcs.withTimeout(ctx)
+	defer cancel()
+	objAttr, err := gcs.object(pClient, path.Join(gcs.Config.Path, key)).Attrs(ctx)
 	if err != nil {
 		if errors.Is(err, storage.ErrObjectNotExist) {
 			return nil, ErrNotFound
@@ -265,8 +408,10 @@ func (gcs *GCS) deleteKey(ctx context.Context, key string) error {
 		return err
 	}
 	pClient := pClientObj.(*clientObject).Client
-	object := pClient.Bucket(gcs.Config.Bucket).Object(key)
-	err = object.Delete(ctx)
+	object := gcs.withRetry(pClient.Bucket(gcs.Config.Bucket).Object(key))
+	deleteCtx, cancel := gcs.withTimeout(ctx)
+	defer cancel()
+	err = object.Delete(deleteCtx)
 	if err != nil {
 		gcs.clientPool.InvalidateObject(ctx, pClientObj)
 		return err
@@ -293,14 +438,21 @@ func (gcs *GCS) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey string
 	}
 	pClient := pClientObj.(*clientObject).Client
 	dstKey = path.Join(gcs.Config.ObjectDiskPath, dstKey)
-	src := pClient.Bucket(srcBucket).Object(srcKey)
-	dst := pClient.Bucket(gcs.Config.Bucket).Object(dstKey)
-	attrs, err := src.Attrs(ctx)
+	src := gcs.withRetry(pClient.Bucket(srcBucket).Object(srcKey))
+	dst := gcs.withRetry(pClient.Bucket(gcs.Config.Bucket).Object(dstKey))
+	copyCtx, cancel := gcs.withTimeout(ctx)
+	defer cancel()
+	attrs, err := src.Attrs(copyCtx)
 	if err != nil {
 		gcs.clientPool.InvalidateObject(ctx, pClientObj)
 		return 0, err
 	}
-	if _, err = dst.CopierFrom(src).Run(ctx); err != nil {
+	// encryption_key is not applied, because ClickHouse restore object disk data via S3 compatible API which can't pass CSEK
+	copier := dst.CopierFrom(src)
+	if gcs.Config.KMSKeyName != "" {
+		copier.DestinationKMSKeyName = gcs.Config.KMSKeyName
+	}
+	if _, err = copier.Run(copyCtx); err != nil {
 		gcs.clientPool.InvalidateObject(ctx, pClientObj)
 		return 0, err
 	}
@@ -4,8 +4,10 @@ import (
 	"archive/tar"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"github.com/Altinity/clickhouse-backup/pkg/progressbar"
 	"github.com/Altinity/clickhouse-backup/pkg/utils"
@@ -31,6 +33,10 @@ import (
 const (
 	// BufferSize - size of ring buffer between stream handlers
 	BufferSize = 512 * 1024
+	// DedupChunksPrefix - shared prefix for content addressed chunks when `general->dedup_store: true`, not a backup
+	DedupChunksPrefix = ".chunks"
+	// ClusterManifestsPrefix - `<prefix>/<backup_name>.json` written by `create_remote --on-cluster`, links backups of each shard, not a backup
+	ClusterManifestsPrefix = ".cluster"
 )
 
 type readerWrapperForContext func(p []byte) (n int, err error)
@@ -53,29 +59,49 @@ type BackupDestination struct {
 	compressionFormat  string
 	compressionLevel   int
 	disableProgressBar bool
+	segmentSize        int64
+	zstd               zstdOptions
+	tablePathPrefixes  map[string]struct{}
 }
 
 var metadataCacheLock sync.RWMutex
 
-func (bd *BackupDestination) RemoveOldBackups(ctx context.Context, keep int) error {
-	if keep < 1 {
-		return nil
+// RemoveOldBackups - delete remote backups by retention policy, return successfully deleted backups
+func (bd *BackupDestination) RemoveOldBackups(ctx context.Context, retention BackupRetention, dryRun bool) ([]Backup, error) {
+	if !retention.IsEnabled() {
+		return nil, nil
 	}
 	start := time.Now()
 	backupList, err := bd.BackupList(ctx, true, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	backupsToDelete := GetBackupsToDelete(backupList, keep)
+	clusterManifests, err := bd.GetClusterManifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deletedBackups := make([]Backup, 0)
+	backupsToDelete := GetBackupsToDeleteByRetentionWithGroups(backupList, GetClusterBackupGroups(clusterManifests), retention, time.Now())
 	bd.Log.WithFields(apexLog.Fields{
 		"operation": "RemoveOldBackups",
 		"duration":  utils.HumanizeDuration(time.Since(start)),
 	}).Info("calculate backup list for deleteKey")
 	for _, backupToDelete := range backupsToDelete {
+		if dryRun {
+			bd.Log.WithFields(apexLog.Fields{
+				"operation": "RemoveOldBackups",
+				"location":  "remote",
+				"backup":    backupToDelete.BackupName,
+				"size":      utils.FormatBytes(backupToDelete.CompressedSize + backupToDelete.MetadataSize),
+			}).Info("dry-run, will delete")
+			continue
+		}
 		startDelete := time.Now()
 		if err := bd.RemoveBackup(ctx, backupToDelete); err != nil {
 			bd.Log.Warnf("can't deleteKey %s return error : %v", backupToDelete.BackupName, err)
+			continue
 		}
+		deletedBackups = append(deletedBackups, backupToDelete)
 		bd.Log.WithFields(apexLog.Fields{
 			"operation": "RemoveOldBackups",
 			"location":  "remote",
@@ -83,27 +109,119 @@ func (bd *BackupDestination) RemoveOldBackups(ctx context.Context, keep int) err
 			"duration":  utils.HumanizeDuration(time.Since(startDelete)),
 		}).Info("done")
 	}
+	if !dryRun {
+		bd.removeClusterManifests(ctx, clusterManifests, backupList, deletedBackups)
+	}
 	bd.Log.WithFields(apexLog.Fields{"operation": "RemoveOldBackups", "duration": utils.HumanizeDuration(time.Since(start))}).Info("done")
-	return nil
+	return deletedBackups, nil
+}
+
+// GetClusterManifests - manifests from `.cluster/<backup_name>.json`, written by `create_remote --on-cluster` before shards start
+func (bd *BackupDestination) GetClusterManifests(ctx context.Context) ([]metadata.ClusterBackupMetadata, error) {
+	manifests := make([]metadata.ClusterBackupMetadata, 0)
+	err := bd.Walk(ctx, ClusterManifestsPrefix+"/", false, func(ctx context.Context, f RemoteFile) error {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			return nil
+		}
+		manifestKey := path.Join(ClusterManifestsPrefix, strings.TrimPrefix(f.Name(), "/"))
+		reader, err := bd.GetFileReader(ctx, manifestKey)
+		if err != nil {
+			return fmt.Errorf("can't read %s: %v", manifestKey, err)
+		}
+		manifest := metadata.ClusterBackupMetadata{}
+		decodeErr := json.NewDecoder(reader).Decode(&manifest)
+		if closeErr := reader.Close(); closeErr != nil {
+			bd.Log.Warnf("can't close %s: %v", manifestKey, closeErr)
+		}
+		if decodeErr != nil {
+			return fmt.Errorf("can't parse %s: %v", manifestKey, decodeErr)
+		}
+		manifests = append(manifests, manifest)
+		return nil
+	})
+	// SFTP can't walk on non exists paths and return error
+	if err != nil && !strings.Contains(err.Error(), "not exist") {
+		return nil, err
+	}
+	return manifests, nil
+}
+
+// GetClusterBackupGroups - cluster backup name for each shard backup name
+func GetClusterBackupGroups(clusterManifests []metadata.ClusterBackupMetadata) map[string]string {
+	groups := make(map[string]string)
+	for _, manifest := range clusterManifests {
+		for _, shard := range manifest.Shards {
+			groups[shard.BackupName] = manifest.BackupName
+		}
+	}
+	return groups
 }
 
+// removeClusterManifests - delete `.cluster/<backup_name>.json` when retention deleted shard backups and none of them remains on remote storage
+func (bd *BackupDestination) removeClusterManifests(ctx context.Context, clusterManifests []metadata.ClusterBackupMetadata, backupList, deletedBackups []Backup) {
+	deleted := make(map[string]struct{}, len(deletedBackups))
+	for _, backup := range deletedBackups {
+		deleted[backup.BackupName] = struct{}{}
+	}
+	remaining := make(map[string]struct{}, len(backupList))
+	for _, backup := range backupList {
+		if _, isDeleted := deleted[backup.BackupName]; !isDeleted {
+			remaining[backup.BackupName] = struct{}{}
+		}
+	}
+	for _, manifest := range clusterManifests {
+		hasDeleted, hasRemaining := false, false
+		for _, shard := range manifest.Shards {
+			if _, isDeleted := deleted[shard.BackupName]; isDeleted {
+				hasDeleted = true
+			}
+			if _, isRemaining := remaining[shard.BackupName]; isRemaining {
+				hasRemaining = true
+			}
+		}
+		if !hasDeleted || hasRemaining {
+			continue
+		}
+		manifestKey := path.Join(ClusterManifestsPrefix, manifest.BackupName+".json")
+		if err := bd.DeleteFile(ctx, manifestKey); err != nil {
+			bd.Log.Warnf("can't delete %s: %v", manifestKey, err)
+		}
+	}
+}
+
+// RemoveBackup - data under general->table_storage_rules path_prefix deleted first, so metadata.json remains and delete could be repeated after failure
 func (bd *BackupDestination) RemoveBackup(ctx context.Context, backup Backup) error {
-	if bd.Kind() == "SFTP" || bd.Kind() == "FTP" {
-		return bd.DeleteFile(ctx, backup.BackupName)
+	dataPaths := GetRemoteBackupDataPaths(backup.BackupMetadata)
+	if bd.Kind() == "SFTP" || bd.Kind() == "FTP" || bd.Kind() == "RCLONE" {
+		for i := len(dataPaths) - 1; i >= 0; i-- {
+			if err := bd.DeleteFile(ctx, dataPaths[i]); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 	if backup.Legacy {
 		archiveName := fmt.Sprintf("%s.%s", backup.BackupName, backup.FileExtension)
 		return bd.DeleteFile(ctx, archiveName)
 	}
-	return bd.Walk(ctx, backup.BackupName+"/", true, func(ctx context.Context, f RemoteFile) error {
+	for i := len(dataPaths) - 1; i >= 0; i-- {
+		if err := bd.removeBackupPath(ctx, dataPaths[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bd *BackupDestination) removeBackupPath(ctx context.Context, backupPath string) error {
+	return bd.Walk(ctx, backupPath+"/", true, func(ctx context.Context, f RemoteFile) error {
 		if bd.Kind() == "azblob" {
 			if f.Size() > 0 || !f.LastModified().IsZero() {
-				return bd.DeleteFile(ctx, path.Join(backup.BackupName, f.Name()))
+				return bd.DeleteFile(ctx, path.Join(backupPath, f.Name()))
 			} else {
 				return nil
 			}
 		}
-		return bd.DeleteFile(ctx, path.Join(backup.BackupName, f.Name()))
+		return bd.DeleteFile(ctx, path.Join(backupPath, f.Name()))
 	})
 }
 
@@ -224,6 +342,9 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 			return nil
 		}
 		backupName := strings.Trim(o.Name(), "/")
+		if backupName == DedupChunksPrefix || backupName == ClusterManifestsPrefix || bd.IsTablePathPrefix(backupName) {
+			return nil
+		}
 		if !parseMetadata || (parseMetadataOnly != "" && parseMetadataOnly != backupName) {
 			if cachedMetadata, isCached := listCache[backupName]; isCached {
 				result = append(result, cachedMetadata)
@@ -286,22 +407,26 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 		if err := r.Close(); err != nil { // Never use defer in loops
 			return err
 		}
-		var m metadata.BackupMetadata
-		if err := json.Unmarshal(b, &m); err != nil {
+		m, err := metadata.ParseBackupMetadata(b)
+		if err != nil {
+			brokenReason := "broken (bad metadata.json)"
+			if errors.Is(err, metadata.ErrUnsupportedManifestVersion) {
+				brokenReason = "broken (unsupported manifest_version)"
+			}
 			brokenBackup := Backup{
 				metadata.BackupMetadata{
 					BackupName: backupName,
 				},
 				false,
 				"",
-				"broken (bad metadata.json)",
+				brokenReason,
 				o.LastModified(), // folder
 			}
 			result = append(result, brokenBackup)
 			return nil
 		}
 		goodBackup := Backup{
-			m, false, "", "", mf.LastModified(),
+			*m, false, "", "", mf.LastModified(),
 		}
 		listCache[backupName] = goodBackup
 		result = append(result, goodBackup)
@@ -310,6 +435,7 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 	if err != nil {
 		bd.Log.Warnf("BackupList bd.Walk return error: %v", err)
 	}
+	result = removeTablePathPrefixes(result)
 	// sort by name for the same not parsed metadata.json
 	sort.SliceStable(result, func(i, j int) bool {
 		return result[i].BackupName < result[j].BackupName
@@ -361,7 +487,7 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 		bd.Log.Warnf("remote file backup extension %s not equal with %s", remotePath, compressionFormat)
 		compressionFormat = strings.Replace(path.Ext(remotePath), ".", "", -1)
 	}
-	z, err := getArchiveReader(compressionFormat)
+	z, err := getArchiveReader(compressionFormat, bd.zstdDecoderOptions()...)
 	if err != nil {
 		return err
 	}
@@ -388,7 +514,9 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 			case <-ctx.Done():
 				return 0, ctx.Err()
 			default:
-				return f.Read(p)
+				n, err := f.Read(p)
+				reportProgress(ctx, remotePath, int64(n))
+				return n, err
 			}
 		})); err != nil {
 			return err
@@ -408,6 +536,11 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 }
 
 func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLocalPath string, files []string, remotePath string) error {
+	return bd.UploadCompressedStreamWithLevel(ctx, baseLocalPath, files, remotePath, bd.compressionLevel)
+}
+
+// UploadCompressedStreamWithLevel - compression level could depend on table size, see general->compression_level_by_table_size
+func (bd *BackupDestination) UploadCompressedStreamWithLevel(ctx context.Context, baseLocalPath string, files []string, remotePath string, compressionLevel int) error {
 	if _, err := bd.StatFile(ctx, remotePath); err != nil {
 		if err != ErrNotFound && !os.IsNotExist(err) {
 			return err
@@ -442,7 +575,7 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 				}
 			}
 		}()
-		z, err := getArchiveWriter(bd.compressionFormat, bd.compressionLevel)
+		z, err := getArchiveWriter(bd.compressionFormat, compressionLevel, bd.zstdEncoderOptions(totalBytes)...)
 		if err != nil {
 			return err
 		}
@@ -461,16 +594,34 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 				FileInfo:      info,
 				NameInArchive: f,
 				Open: func() (io.ReadCloser, error) {
-					return os.Open(localPath)
+					f, err := os.Open(localPath)
+					if err != nil {
+						return nil, err
+					}
+					return newProgressReadCloser(ctx, remotePath, f), nil
 				},
 			}
 			archiveFiles = append(archiveFiles, file)
 			//bd.Log.Debugf("add %s to archive %s", filePath, remotePath)
 		}
-		if writerErr = z.Archive(ctx, w, archiveFiles); writerErr != nil {
+		if !bd.useZstdMultistream(totalBytes) {
+			if writerErr = z.Archive(ctx, w, archiveFiles); writerErr != nil {
+				return writerErr
+			}
+			return nil
+		}
+		// tar stream split into blocks which compressed in parallel, w receive concatenated zstd frames
+		multistream, err := bd.newZstdMultistreamWriter(ctx, w, compressionLevel, totalBytes)
+		if err != nil {
+			writerErr = err
 			return writerErr
 		}
-		return nil
+		tarOnly := archiver.CompressedArchive{Archival: z.Archival}
+		writerErr = tarOnly.Archive(ctx, multistream, archiveFiles)
+		if closeErr := multistream.Close(); writerErr == nil {
+			writerErr = closeErr
+		}
+		return writerErr
 	})
 	g.Go(func() error {
 		defer func() {
@@ -513,6 +664,10 @@ func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remot
 		if bd.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
 			return nil
 		}
+		// additional segments read by GetFileReader together with first segment
+		if _, isSegment := SegmentBaseKey(f.Name()); isSegment && bd.getSegmentSize(ctx) > 0 {
+			return nil
+		}
 		retry := retrier.New(retrier.ConstantBackoff(RetriesOnFailure, RetriesDuration), nil)
 		err := retry.RunCtx(ctx, func(ctx context.Context) error {
 			r, err := bd.GetFileReader(ctx, path.Join(remotePath, f.Name()))
@@ -531,7 +686,7 @@ func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remot
 				log.Error(err.Error())
 				return err
 			}
-			if _, err := io.CopyBuffer(dst, r, nil); err != nil {
+			if _, err := io.CopyBuffer(dst, newProgressReadCloser(ctx, path.Join(remotePath, f.Name()), r), nil); err != nil {
 				log.Error(err.Error())
 				return err
 			}
@@ -586,7 +741,7 @@ func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLoc
 		}
 		retry := retrier.New(retrier.ConstantBackoff(RetriesOnFailure, RetriesDuration), nil)
 		err = retry.RunCtx(ctx, func(ctx context.Context) error {
-			return bd.PutFile(ctx, path.Join(remotePath, filename), f)
+			return bd.PutFile(ctx, path.Join(remotePath, filename), newProgressReadCloser(ctx, path.Join(remotePath, filename), f))
 		})
 		if err != nil {
 			closeFile()
@@ -607,7 +762,14 @@ func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLoc
 
 func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse, calcMaxSize bool, backupName string) (*BackupDestination, error) {
 	log := apexLog.WithField("logger", "NewBackupDestination")
+	if correlationId := common.GetCorrelationId(ctx); correlationId != "" {
+		log = log.WithField(common.CorrelationIdField, correlationId)
+	}
+	if commandId, ok := common.GetCommandId(ctx); ok {
+		log = log.WithField(common.CommandIdField, commandId)
+	}
 	var err error
+	SetBandwidthLimits(cfg.General.UploadMaxBytesPerSec, cfg.General.DownloadMaxBytesPerSec)
 	// https://github.com/Altinity/clickhouse-backup/issues/404
 	if calcMaxSize {
 		maxFileSize, err := ch.CalculateMaxFileSize(ctx, cfg)
@@ -645,11 +807,14 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 		}
 		azblobStorage.Config.BufferSize = bufferSize
 		return &BackupDestination{
-			azblobStorage,
+			newMetricsStorage(newRetryStorage(azblobStorage, cfg, log)),
 			log.WithField("logger", "azure"),
 			cfg.AzureBlob.CompressionFormat,
 			cfg.AzureBlob.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
 		}, nil
 	case "s3":
 		partSize := cfg.S3.PartSize
@@ -686,11 +851,14 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			s3Storage.Config.ObjectLabels = objectLabels
 		}
 		return &BackupDestination{
-			s3Storage,
+			newMetricsStorage(newRetryStorage(s3Storage, cfg, log)),
 			log.WithField("logger", "s3"),
 			cfg.S3.CompressionFormat,
 			cfg.S3.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
 		}, nil
 	case "gcs":
 		googleCloudStorage := &GCS{Config: &cfg.GCS}
@@ -708,11 +876,14 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			googleCloudStorage.Config.ObjectLabels = objectLabels
 		}
 		return &BackupDestination{
-			googleCloudStorage,
+			newMetricsStorage(newRetryStorage(googleCloudStorage, cfg, log)),
 			log.WithField("logger", "gcs"),
 			cfg.GCS.CompressionFormat,
 			cfg.GCS.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
 		}, nil
 	case "cos":
 		tencentStorage := &COS{Config: &cfg.COS}
@@ -721,11 +892,14 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			return nil, err
 		}
 		return &BackupDestination{
-			tencentStorage,
+			newMetricsStorage(newRetryStorage(tencentStorage, cfg, log)),
 			log.WithField("logger", "cos"),
 			cfg.COS.CompressionFormat,
 			cfg.COS.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
 		}, nil
 	case "ftp":
 		ftpStorage := &FTP{
@@ -737,11 +911,14 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			return nil, err
 		}
 		return &BackupDestination{
-			ftpStorage,
+			newMetricsStorage(newRetryStorage(ftpStorage, cfg, log)),
 			log.WithField("logger", "FTP"),
 			cfg.FTP.CompressionFormat,
 			cfg.FTP.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
 		}, nil
 	case "sftp":
 		sftpStorage := &SFTP{
@@ -751,15 +928,115 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 		if err != nil {
 			return nil, err
 		}
+		// each upload or download goroutine use own SSH session
+		if sftpStorage.Config.ClientPoolSize <= 0 {
+			sftpStorage.Config.ClientPoolSize = int(max(cfg.General.UploadConcurrency, cfg.General.DownloadConcurrency)) + 1
+		}
 		return &BackupDestination{
-			sftpStorage,
+			newMetricsStorage(newRetryStorage(sftpStorage, cfg, log)),
 			log.WithField("logger", "SFTP"),
 			cfg.SFTP.CompressionFormat,
 			cfg.SFTP.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
+		}, nil
+	case "rclone":
+		rcloneStorage := &Rclone{
+			Config: &cfg.Rclone,
+			Log:    log.WithField("logger", "RCLONE"),
+		}
+		rcloneStorage.Config.Path, err = ch.ApplyMacros(ctx, rcloneStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &BackupDestination{
+			newMetricsStorage(newRetryStorage(rcloneStorage, cfg, log)),
+			log.WithField("logger", "RCLONE"),
+			cfg.Rclone.CompressionFormat,
+			cfg.Rclone.CompressionLevel,
+			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
+		}, nil
+	case "swift":
+		swiftStorage := &Swift{
+			Config: &cfg.Swift,
+			Log:    log.WithField("logger", "SWIFT"),
+		}
+		swiftStorage.Config.Path, err = ch.ApplyMacros(ctx, swiftStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &BackupDestination{
+			newMetricsStorage(newRetryStorage(swiftStorage, cfg, log)),
+			log.WithField("logger", "SWIFT"),
+			cfg.Swift.CompressionFormat,
+			cfg.Swift.CompressionLevel,
+			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
+		}, nil
+	case "oss":
+		ossStorage := &OSS{
+			Config: &cfg.OSS,
+			Log:    log.WithField("logger", "OSS"),
+		}
+		ossStorage.Config.Path, err = ch.ApplyMacros(ctx, ossStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &BackupDestination{
+			newMetricsStorage(newRetryStorage(ossStorage, cfg, log)),
+			log.WithField("logger", "OSS"),
+			cfg.OSS.CompressionFormat,
+			cfg.OSS.CompressionLevel,
+			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
+		}, nil
+	case "obs":
+		obsStorage := &OBS{
+			Config: &cfg.OBS,
+			Log:    log.WithField("logger", "OBS"),
+		}
+		obsStorage.Config.Path, err = ch.ApplyMacros(ctx, obsStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &BackupDestination{
+			newMetricsStorage(newRetryStorage(obsStorage, cfg, log)),
+			log.WithField("logger", "OBS"),
+			cfg.OBS.CompressionFormat,
+			cfg.OBS.CompressionLevel,
+			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
 		}, nil
 	default:
-		return nil, fmt.Errorf("storage type '%s' is not supported", cfg.General.RemoteStorage)
+		factory, isRegistered := getRemoteStorageFactory(cfg.General.RemoteStorage)
+		if !isRegistered {
+			return nil, fmt.Errorf("storage type '%s' is not supported", cfg.General.RemoteStorage)
+		}
+		remoteStorage, err := factory(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("can't create '%s' remote storage: %v", cfg.General.RemoteStorage, err)
+		}
+		return &BackupDestination{
+			newMetricsStorage(newRetryStorage(remoteStorage, cfg, log)),
+			log.WithField("logger", cfg.General.RemoteStorage),
+			cfg.Plugin.CompressionFormat,
+			cfg.Plugin.CompressionLevel,
+			cfg.General.DisableProgressBar,
+			cfg.GetMaxObjectSize(),
+			newZstdOptions(cfg),
+			newTablePathPrefixes(cfg),
+		}, nil
 	}
 }
 
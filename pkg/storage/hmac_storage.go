@@ -0,0 +1,441 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apexLog "github.com/apex/log"
+)
+
+// hmacCredentials - access key pair, SecurityToken and Expiration are not empty for STS temporary credentials
+type hmacCredentials struct {
+	AccessKey     string
+	SecretKey     string
+	SecurityToken string
+	Expiration    time.Time
+}
+
+// hmacCredentialsProvider - return static credentials or fetch temporary credentials from instance metadata service
+type hmacCredentialsProvider func(ctx context.Context) (hmacCredentials, error)
+
+// hmacCredentialsRefreshWindow - temporary credentials requested again before expiration, so long upload doesn't fail in the middle
+const hmacCredentialsRefreshWindow = 5 * time.Minute
+
+// hmacDialect - difference between Alibaba Cloud OSS and Huawei Cloud OBS, both use `Authorization: <scheme> <ak>:<signature>` signed with HMAC-SHA1
+type hmacDialect struct {
+	kind         string
+	authScheme   string
+	headerPrefix string
+}
+
+// hmacSubResources - query parameters which are part of signed resource
+var hmacSubResources = map[string]struct{}{
+	"acl": {}, "uploads": {}, "uploadId": {}, "partNumber": {}, "delete": {},
+}
+
+// hmacObjectStorage - RemoteStorage for object storage compatible with OSS / OBS native API, virtual hosted bucket URL `https://<bucket>.<endpoint>/<key>`
+type hmacObjectStorage struct {
+	dialect      hmacDialect
+	endpoint     *url.URL
+	bucket       string
+	path         string
+	partSize     int64
+	storageClass string
+	// sseHeaders - server side encryption headers for PutObject and InitiateMultipartUpload, without header prefix
+	sseHeaders  map[string]string
+	credentials hmacCredentialsProvider
+	debug       bool
+	log         *apexLog.Entry
+
+	client  *http.Client
+	credsMx sync.Mutex
+	creds   *hmacCredentials
+}
+
+// hmacHTTPError - error response from OSS / OBS
+type hmacHTTPError struct {
+	Kind       string
+	Method     string
+	Key        string
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	RequestID  string `xml:"RequestId"`
+}
+
+func (e *hmacHTTPError) Error() string {
+	return fmt.Sprintf("%s %s %s return %d %s: %s, request_id=%s", e.Kind, e.Method, e.Key, e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+type hmacListBucketResult struct {
+	IsTruncated    bool   `xml:"IsTruncated"`
+	NextMarker     string `xml:"NextMarker"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+type hmacCompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type hmacCompleteMultipartUpload struct {
+	XMLName xml.Name           `xml:"CompleteMultipartUpload"`
+	Parts   []hmacCompletePart `xml:"Part"`
+}
+
+func (h *hmacObjectStorage) connect(ctx context.Context, endpoint string, timeout time.Duration, transport http.RoundTripper) error {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("can't parse %s endpoint %s: %v", h.dialect.kind, endpoint, err)
+	}
+	h.endpoint = u
+	h.client = &http.Client{Timeout: timeout, Transport: transport}
+	// check bucket exists and credentials are valid
+	_, err = h.request(ctx, http.MethodGet, "", url.Values{"max-keys": {"1"}}, nil, nil, http.StatusOK)
+	return err
+}
+
+func (h *hmacObjectStorage) getCredentials(ctx context.Context) (hmacCredentials, error) {
+	h.credsMx.Lock()
+	defer h.credsMx.Unlock()
+	if h.creds != nil && (h.creds.Expiration.IsZero() || time.Until(h.creds.Expiration) > hmacCredentialsRefreshWindow) {
+		return *h.creds, nil
+	}
+	creds, err := h.credentials(ctx)
+	if err != nil {
+		return hmacCredentials{}, fmt.Errorf("can't get %s credentials: %v", h.dialect.kind, err)
+	}
+	h.creds = &creds
+	return creds, nil
+}
+
+func (h *hmacObjectStorage) objectURL(key string, query url.Values) string {
+	u := *h.endpoint
+	u.Host = h.bucket + "." + u.Host
+	u.Path = "/" + key
+	// sub-resources without value, like `?uploads`, shall be sent without `=`
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, len(keys))
+	for i, k := range keys {
+		params[i] = url.QueryEscape(k)
+		if v := query.Get(k); v != "" {
+			params[i] += "=" + url.QueryEscape(v)
+		}
+	}
+	u.RawQuery = strings.Join(params, "&")
+	return u.String()
+}
+
+// stringToSign - VERB\nContent-MD5\nContent-Type\nDate\nCanonicalizedHeaders CanonicalizedResource
+func (h *hmacObjectStorage) stringToSign(req *http.Request, key string, query url.Values) string {
+	var headerKeys []string
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, h.dialect.headerPrefix) {
+			headerKeys = append(headerKeys, lk)
+		}
+	}
+	sort.Strings(headerKeys)
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.Header.Get("Content-MD5") + "\n")
+	b.WriteString(req.Header.Get("Content-Type") + "\n")
+	b.WriteString(req.Header.Get("Date") + "\n")
+	for _, k := range headerKeys {
+		b.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+	b.WriteString("/" + h.bucket + "/" + key)
+	var subResources []string
+	for k := range query {
+		if _, isSubResource := hmacSubResources[k]; isSubResource {
+			subResources = append(subResources, k)
+		}
+	}
+	sort.Strings(subResources)
+	for i, k := range subResources {
+		if i == 0 {
+			b.WriteString("?")
+		} else {
+			b.WriteString("&")
+		}
+		b.WriteString(k)
+		if v := query.Get(k); v != "" {
+			b.WriteString("=" + v)
+		}
+	}
+	return b.String()
+}
+
+// sign - Authorization header value, base64 of HMAC-SHA1 of stringToSign
+func (h *hmacObjectStorage) sign(req *http.Request, key string, query url.Values, creds hmacCredentials) string {
+	mac := hmac.New(sha1.New, []byte(creds.SecretKey))
+	mac.Write([]byte(h.stringToSign(req, key, query)))
+	return h.dialect.authScheme + " " + creds.AccessKey + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// do - sign and execute request, body is fully in memory, so it could be retried by retryStorage
+func (h *hmacObjectStorage) do(ctx context.Context, method, key string, query url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	creds, err := h.getCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.objectURL(key, query), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if body != nil {
+		sum := md5.Sum(body)
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if creds.SecurityToken != "" {
+		req.Header.Set(h.dialect.headerPrefix+"security-token", creds.SecurityToken)
+	}
+	req.Header.Set("Authorization", h.sign(req, key, query, creds))
+	if h.debug {
+		h.log.Infof("[%s_DEBUG] %s %s", strings.ToUpper(h.dialect.kind), method, req.URL.String())
+	}
+	return h.client.Do(req)
+}
+
+func (h *hmacObjectStorage) closeBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if err := resp.Body.Close(); err != nil {
+		h.log.Warnf("can't close %s response body: %v", h.dialect.kind, err)
+	}
+}
+
+func (h *hmacObjectStorage) responseError(method, key string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	httpErr := &hmacHTTPError{Kind: h.dialect.kind, Method: method, Key: key, StatusCode: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if xmlErr := xml.Unmarshal(body, httpErr); xmlErr != nil {
+		httpErr.Message = strings.TrimSpace(string(body))
+	}
+	return httpErr
+}
+
+// request - execute request, check status and return response body
+func (h *hmacObjectStorage) request(ctx context.Context, method, key string, query url.Values, headers map[string]string, body []byte, expectedStatus int) (*http.Response, error) {
+	resp, err := h.do(ctx, method, key, query, headers, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expectedStatus {
+		defer h.closeBody(resp)
+		return nil, h.responseError(method, key, resp)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	h.closeBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+func (h *hmacObjectStorage) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	resp, err := h.request(ctx, http.MethodHead, path.Join(h.path, key), nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	lastModified, _ := parseTime(resp.Header.Get("Last-Modified"))
+	return &hmacFile{size: resp.ContentLength, lastModified: lastModified, name: key}, nil
+}
+
+func (h *hmacObjectStorage) DeleteFile(ctx context.Context, key string) error {
+	_, err := h.request(ctx, http.MethodDelete, path.Join(h.path, key), nil, nil, nil, http.StatusNoContent)
+	return err
+}
+
+func (h *hmacObjectStorage) DeleteFileFromObjectDiskBackup(ctx context.Context, key string) error {
+	return fmt.Errorf("DeleteFileFromObjectDiskBackup not imlemented for %s", h.dialect.kind)
+}
+
+func (h *hmacObjectStorage) Walk(ctx context.Context, remotePath string, recursive bool, process func(context.Context, RemoteFile) error) error {
+	prefix := strings.TrimPrefix(path.Join(h.path, remotePath), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	query := url.Values{"prefix": {prefix}, "max-keys": {"1000"}}
+	if !recursive {
+		query.Set("delimiter", "/")
+	}
+	for {
+		resp, err := h.request(ctx, http.MethodGet, "", query, nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		var result hmacListBucketResult
+		if err = xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("can't parse %s list objects response: %v", h.dialect.kind, err)
+		}
+		for _, p := range result.CommonPrefixes {
+			if err = process(ctx, &hmacFile{name: strings.TrimPrefix(p.Prefix, prefix)}); err != nil {
+				return err
+			}
+		}
+		for _, c := range result.Contents {
+			if err = process(ctx, &hmacFile{name: strings.TrimPrefix(c.Key, prefix), size: c.Size, lastModified: c.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextMarker == "" {
+			return nil
+		}
+		query.Set("marker", result.NextMarker)
+	}
+}
+
+func (h *hmacObjectStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	key = path.Join(h.path, key)
+	resp, err := h.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer h.closeBody(resp)
+		return nil, h.responseError(http.MethodGet, key, resp)
+	}
+	return resp.Body, nil
+}
+
+func (h *hmacObjectStorage) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return h.GetFileReader(ctx, key)
+}
+
+func (h *hmacObjectStorage) objectHeaders(ctx context.Context) map[string]string {
+	headers := make(map[string]string, len(h.sseHeaders)+1)
+	for k, v := range h.sseHeaders {
+		headers[h.dialect.headerPrefix+k] = v
+	}
+	if storageClass := getStorageClass(ctx, h.storageClass); storageClass != "" {
+		headers[h.dialect.headerPrefix+"storage-class"] = storageClass
+	}
+	return headers
+}
+
+// PutFile - source which fits into part_size upload via PutObject, bigger source upload via multipart upload, each part is kept in memory
+func (h *hmacObjectStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	key = path.Join(h.path, key)
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, r, h.partSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if n < h.partSize {
+		_, err = h.request(ctx, http.MethodPut, key, nil, h.objectHeaders(ctx), buf.Bytes(), http.StatusOK)
+		return err
+	}
+	resp, err := h.request(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, h.objectHeaders(ctx), nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err = xml.NewDecoder(resp.Body).Decode(&initiated); err != nil {
+		return fmt.Errorf("can't parse %s initiate multipart upload response: %v", h.dialect.kind, err)
+	}
+	complete := hmacCompleteMultipartUpload{}
+	for n > 0 {
+		partNumber := len(complete.Parts) + 1
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {initiated.UploadID}}
+		resp, err = h.request(ctx, http.MethodPut, key, query, nil, buf.Bytes(), http.StatusOK)
+		if err != nil {
+			return h.abortMultipartUpload(ctx, key, initiated.UploadID, err)
+		}
+		complete.Parts = append(complete.Parts, hmacCompletePart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+		buf.Reset()
+		n, err = io.CopyN(buf, r, h.partSize)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return h.abortMultipartUpload(ctx, key, initiated.UploadID, err)
+		}
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return h.abortMultipartUpload(ctx, key, initiated.UploadID, err)
+	}
+	if _, err = h.request(ctx, http.MethodPost, key, url.Values{"uploadId": {initiated.UploadID}}, map[string]string{"Content-Type": "application/xml"}, body, http.StatusOK); err != nil {
+		return h.abortMultipartUpload(ctx, key, initiated.UploadID, err)
+	}
+	return nil
+}
+
+// abortMultipartUpload - uploaded parts are billed until upload is aborted
+func (h *hmacObjectStorage) abortMultipartUpload(ctx context.Context, key, uploadID string, uploadErr error) error {
+	if _, err := h.request(context.WithoutCancel(ctx), http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, http.StatusNoContent); err != nil {
+		h.log.Warnf("can't abort %s multipart upload %s for %s: %v", h.dialect.kind, uploadID, key, err)
+	}
+	return uploadErr
+}
+
+func (h *hmacObjectStorage) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey string) (int64, error) {
+	return 0, fmt.Errorf("CopyObject not imlemented for %s", h.dialect.kind)
+}
+
+// IsRetryableError - access denied, invalid request and quota errors repeat on each attempt, only network errors and throttling are transient
+func (h *hmacObjectStorage) IsRetryableError(err error) bool {
+	var httpErr *hmacHTTPError
+	if errors.As(err, &httpErr) {
+		return isRetryableHTTPStatus(httpErr.StatusCode)
+	}
+	return isTransientError(err)
+}
+
+type hmacFile struct {
+	size         int64
+	lastModified time.Time
+	name         string
+}
+
+func (f *hmacFile) Size() int64 {
+	return f.size
+}
+
+func (f *hmacFile) Name() string {
+	return f.name
+}
+
+func (f *hmacFile) LastModified() time.Time {
+	return f.lastModified
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHMACStringToSign(t *testing.T) {
+	h := &hmacObjectStorage{dialect: hmacDialect{kind: "OSS", authScheme: "OSS", headerPrefix: "x-oss-"}, bucket: "oss-example"}
+	req, err := http.NewRequest(http.MethodPut, "http://oss-example.oss-cn-hangzhou.aliyuncs.com/nelson", nil)
+	require.NoError(t, err)
+	req.Header.Set("Content-MD5", "eB5eJF1ptWaXm4bijSPyxw==")
+	req.Header.Set("Content-Type", "text/html")
+	req.Header.Set("Date", "Thu, 17 Nov 2005 18:49:58 GMT")
+	req.Header.Set("X-OSS-Meta-Author", "foo@example.com")
+	req.Header.Set("X-OSS-Magic", "abracadabra")
+	req.Header.Set("X-Other", "not signed")
+	query := url.Values{"uploadId": {"id1"}, "partNumber": {"2"}, "max-keys": {"1"}}
+	assert.Equal(t,
+		"PUT\neB5eJF1ptWaXm4bijSPyxw==\ntext/html\nThu, 17 Nov 2005 18:49:58 GMT\nx-oss-magic:abracadabra\nx-oss-meta-author:foo@example.com\n/oss-example/nelson?partNumber=2&uploadId=id1",
+		h.stringToSign(req, "nelson", query),
+	)
+	creds := hmacCredentials{AccessKey: "44CF9590006BF252F707", SecretKey: "OtxrzxIsfpFjA7SwPzILwy8Bw21TLhquhboDYROV"}
+	assert.True(t, strings.HasPrefix(h.sign(req, "nelson", query, creds), "OSS 44CF9590006BF252F707:"))
+}
+
+// fakeHMACStorage - in-memory bucket with multipart upload, check security token and SSE headers
+type fakeHMACStorage struct {
+	mx       sync.Mutex
+	prefix   string
+	token    string
+	objects  map[string][]byte
+	parts    map[string][]byte
+	headers  map[string]http.Header
+	aborted  int
+	failPart bool
+}
+
+func (f *fakeHMACStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "OBS ") && !strings.HasPrefix(r.Header.Get("Authorization"), "OSS ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.Header.Get(f.prefix+"security-token") != f.token {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprint(w, "<Error><Code>InvalidSecurityToken</Code><Message>expired</Message><RequestId>1</RequestId></Error>")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		result := hmacListBucketResult{}
+		prefix := query.Get("prefix")
+		for k, v := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, struct {
+					Key          string    `xml:"Key"`
+					LastModified time.Time `xml:"LastModified"`
+					Size         int64     `xml:"Size"`
+				}{Key: k, Size: int64(len(v))})
+			}
+		}
+		_ = xml.NewEncoder(w).Encode(struct {
+			XMLName xml.Name `xml:"ListBucketResult"`
+			hmacListBucketResult
+		}{hmacListBucketResult: result})
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.headers[key] = r.Header.Clone()
+		_, _ = fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>upload1</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		if f.failPart {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.parts[query.Get("partNumber")] = body
+		w.Header().Set("ETag", `"etag`+query.Get("partNumber")+`"`)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete hmacCompleteMultipartUpload
+		_ = xml.Unmarshal(body, &complete)
+		data := make([]byte, 0)
+		for _, p := range complete.Parts {
+			data = append(data, f.parts[fmt.Sprint(p.PartNumber)]...)
+		}
+		f.objects[key] = data
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.headers[key] = r.Header.Clone()
+		f.objects[key] = body
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, exists := f.objects[key]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		_, _ = w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// newTestHMACStorage - all virtual hosted bucket requests go to test server
+func newTestHMACStorage(t *testing.T, h *hmacObjectStorage, srv *httptest.Server) {
+	addr := srv.Listener.Addr().String()
+	transport := &http.Transport{DialContext: func(ctx context.Context, network, hostPort string) (net.Conn, error) {
+		if strings.HasPrefix(hostPort, h.bucket+".") {
+			hostPort = addr
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, hostPort)
+	}}
+	require.NoError(t, h.connect(context.Background(), srv.URL, time.Minute, transport))
+}
+
+func TestOBSMultipartUploadWithECSMetadataCredentials(t *testing.T) {
+	f := &fakeHMACStorage{prefix: "x-obs-", token: "token1", objects: map[string][]byte{}, parts: map[string][]byte{}, headers: map[string]http.Header{}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	metadataRequests := 0
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadataRequests++
+		_, _ = fmt.Fprintf(w, `{"credential":{"access":"ak","secret":"sk","securitytoken":"token%d","expires_at":"%s"}}`, metadataRequests, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer metadata.Close()
+	obsMetadataURL = metadata.URL
+	defer func() { obsMetadataURL = "http://169.254.169.254/openstack/latest/securitykey" }()
+
+	cfg := config.DefaultConfig()
+	cfg.OBS.UseECSMetadata = true
+	cfg.OBS.Bucket = "backups"
+	cfg.OBS.Path = "shard1"
+	cfg.OBS.SSE = "kms"
+	cfg.OBS.SSEKMSKeyID = "key1"
+	o := &OBS{Config: &cfg.OBS, Log: apexLog.WithField("logger", "OBS")}
+	o.hmacObjectStorage = hmacObjectStorage{
+		dialect:     hmacDialect{kind: "OBS", authScheme: "OBS", headerPrefix: "x-obs-"},
+		bucket:      "backups",
+		path:        "shard1",
+		partSize:    4,
+		sseHeaders:  map[string]string{"server-side-encryption": "kms", "server-side-encryption-kms-key-id": "key1"},
+		credentials: o.credentials,
+		log:         o.Log,
+	}
+	newTestHMACStorage(t, &o.hmacObjectStorage, srv)
+	ctx := context.Background()
+
+	require.NoError(t, o.PutFile(ctx, "backup1/data.tar", io.NopCloser(strings.NewReader("0123456789"))))
+	assert.Equal(t, []byte("0123456789"), f.objects["shard1/backup1/data.tar"])
+	assert.Equal(t, "kms", f.headers["shard1/backup1/data.tar"].Get("x-obs-server-side-encryption"))
+	assert.Equal(t, "key1", f.headers["shard1/backup1/data.tar"].Get("x-obs-server-side-encryption-kms-key-id"))
+	assert.Empty(t, f.headers["shard1/backup1/data.tar"].Get("x-obs-storage-class"))
+	require.NoError(t, o.PutFile(WithStorageClass(ctx, "COLD"), "cold/backup1/shadow/data.tar", io.NopCloser(strings.NewReader("0123"))))
+	assert.Equal(t, "COLD", f.headers["shard1/cold/backup1/shadow/data.tar"].Get("x-obs-storage-class"))
+
+	file, err := o.StatFile(ctx, "backup1/data.tar")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), file.Size())
+	assert.Equal(t, 1, metadataRequests)
+
+	// temporary credentials near expiration requested again
+	o.creds.Expiration = time.Now().Add(time.Minute)
+	f.token = "token2"
+	_, err = o.StatFile(ctx, "backup1/data.tar")
+	require.NoError(t, err)
+	assert.Equal(t, 2, metadataRequests)
+
+	_, err = o.StatFile(ctx, "backup1/missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestOSSAbortMultipartUploadOnFailure(t *testing.T) {
+	f := &fakeHMACStorage{prefix: "x-oss-", token: "sts", objects: map[string][]byte{}, parts: map[string][]byte{}, headers: map[string]http.Header{}, failPart: true}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	cfg := config.DefaultConfig()
+	cfg.OSS.AccessKeyID = "ak"
+	cfg.OSS.AccessKeySecret = "sk"
+	cfg.OSS.SecurityToken = "sts"
+	o := &OSS{Config: &cfg.OSS, Log: apexLog.WithField("logger", "OSS")}
+	o.hmacObjectStorage = hmacObjectStorage{
+		dialect:     hmacDialect{kind: "OSS", authScheme: "OSS", headerPrefix: "x-oss-"},
+		bucket:      "backups",
+		partSize:    4,
+		credentials: o.credentials,
+		log:         o.Log,
+	}
+	newTestHMACStorage(t, &o.hmacObjectStorage, srv)
+
+	err := o.PutFile(context.Background(), "data.tar", io.NopCloser(strings.NewReader("0123456789")))
+	require.Error(t, err)
+	assert.True(t, o.IsRetryableError(err))
+	assert.Equal(t, 1, f.aborted)
+	assert.False(t, o.IsRetryableError(&hmacHTTPError{StatusCode: http.StatusForbidden}))
+
+	u, err := url.Parse(o.objectURL("a b", url.Values{"uploads": {""}}))
+	require.NoError(t, err)
+	assert.Equal(t, "uploads", u.RawQuery)
+	assert.Equal(t, "/a%20b", u.EscapedPath())
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// isCustomHTTPTransport - default http.Transport is used as is when nothing configured, to keep SDK defaults
+func isCustomHTTPTransport(caCert string, insecureSkipVerify bool, proxy string) bool {
+	return caCert != "" || insecureSkipVerify || proxy != ""
+}
+
+// newTLSConfig - add PEM CA bundle to system cert pool
+func newTLSConfig(caCert string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("can't read ca_cert %s: %v", caCert, err)
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_cert %s doesn't contain any PEM certificate", caCert)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	return tlsConfig, nil
+}
+
+// newHTTPTransport - clone http.DefaultTransport, add PEM CA bundle to system cert pool, proxy supports http://, https:// and socks5:// schemes, empty proxy means HTTP_PROXY, HTTPS_PROXY, NO_PROXY environment variables
+func newHTTPTransport(caCert string, insecureSkipVerify bool, proxy string) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig, err := newTLSConfig(caCert, insecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse proxy %s: %v", proxy, err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("proxy %s has unsupported scheme, only http, https and socks5 allowed", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return transport, nil
+}
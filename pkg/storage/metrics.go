@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// OperationMetrics - observer of remote storage operations, `server` injects prometheus implementation via SetOperationMetrics, storage doesn't depend on server packages
+type OperationMetrics interface {
+	OperationStarted(kind, operation string)
+	OperationFinished(kind, operation string, bytes int64, duration time.Duration, err error)
+	SourceRead(kind string, duration time.Duration)
+}
+
+type noopOperationMetrics struct{}
+
+func (noopOperationMetrics) OperationStarted(string, string) {}
+
+func (noopOperationMetrics) OperationFinished(string, string, int64, time.Duration, error) {}
+
+func (noopOperationMetrics) SourceRead(string, time.Duration) {}
+
+var operationMetrics atomic.Pointer[OperationMetrics]
+
+// SetOperationMetrics - nil disables observing, applied for next operations
+func SetOperationMetrics(m OperationMetrics) {
+	if m == nil {
+		operationMetrics.Store(nil)
+		return
+	}
+	operationMetrics.Store(&m)
+}
+
+func getOperationMetrics() OperationMetrics {
+	if m := operationMetrics.Load(); m != nil {
+		return *m
+	}
+	return noopOperationMetrics{}
+}
+
+// metricsStorage - RemoteStorage wrapper which observe latency, throughput and in-flight operations for each backend kind, and create tracing span for each call
+type metricsStorage struct {
+	RemoteStorage
+}
+
+func newMetricsStorage(s RemoteStorage) RemoteStorage {
+	return &metricsStorage{s}
+}
+
+func (m *metricsStorage) observe(ctx context.Context, operation, key string) func(bytes int64, err error) {
+	kind := m.Kind()
+	observer := getOperationMetrics()
+	observer.OperationStarted(kind, operation)
+	_, span := tracing.Start(ctx, "storage."+operation, attribute.String("storage", kind), attribute.String("key", key))
+	startTime := time.Now()
+	return func(bytes int64, err error) {
+		span.SetAttributes(attribute.Int64("bytes", bytes))
+		span.End(err)
+		observer.OperationFinished(kind, operation, bytes, time.Since(startTime), err)
+	}
+}
+
+func (m *metricsStorage) DeleteFile(ctx context.Context, key string) error {
+	finish := m.observe(ctx, "DeleteFile", key)
+	err := m.RemoteStorage.DeleteFile(ctx, key)
+	finish(0, err)
+	return err
+}
+
+func (m *metricsStorage) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey string) (int64, error) {
+	finish := m.observe(ctx, "CopyObject", dstKey)
+	size, err := m.RemoteStorage.CopyObject(ctx, srcBucket, srcKey, dstKey)
+	finish(size, err)
+	return size, err
+}
+
+// StatFile - ErrNotFound is expected result during resume and diff checks, so it is not observed as error
+func (m *metricsStorage) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	finish := m.observe(ctx, "StatFile", key)
+	file, err := m.RemoteStorage.StatFile(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		finish(0, nil)
+	} else {
+		finish(0, err)
+	}
+	return file, err
+}
+
+func (m *metricsStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(context.Context, RemoteFile) error) error {
+	finish := m.observe(ctx, "Walk", prefix)
+	err := m.RemoteStorage.Walk(ctx, prefix, recursive, fn)
+	finish(0, err)
+	return err
+}
+
+// PutFile - source reader usually reads local disk, time spent in source Read observed separately, to distinguish slow local disk from slow remote storage
+func (m *metricsStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	finish := m.observe(ctx, "PutFile", key)
+	reader := &metricsReader{ReadCloser: r}
+	err := m.RemoteStorage.PutFile(ctx, key, reader)
+	finish(reader.getBytes(), err)
+	if err == nil {
+		getOperationMetrics().SourceRead(m.Kind(), reader.getReadDuration())
+	}
+	return err
+}
+
+// GetFileReader - operation finish when returned reader is closed, so duration and throughput include reading whole object
+func (m *metricsStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	finish := m.observe(ctx, "GetFileReader", key)
+	r, err := m.RemoteStorage.GetFileReader(ctx, key)
+	if err != nil {
+		finish(0, err)
+		return nil, err
+	}
+	return &metricsReader{ReadCloser: r, onClose: finish}, nil
+}
+
+func (m *metricsStorage) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error) {
+	finish := m.observe(ctx, "GetFileReader", key)
+	r, err := m.RemoteStorage.GetFileReaderWithLocalPath(ctx, key, localPath)
+	if err != nil {
+		finish(0, err)
+		return nil, err
+	}
+	return &metricsReader{ReadCloser: r, onClose: finish}, nil
+}
+
+// metricsReader - count read bytes and time spent inside Read
+type metricsReader struct {
+	io.ReadCloser
+	mx           sync.Mutex
+	bytes        int64
+	readDuration time.Duration
+	onClose      func(bytes int64, err error)
+	closeOnce    sync.Once
+}
+
+func (r *metricsReader) Read(p []byte) (int, error) {
+	startTime := time.Now()
+	n, err := r.ReadCloser.Read(p)
+	r.mx.Lock()
+	r.bytes += int64(n)
+	r.readDuration += time.Since(startTime)
+	r.mx.Unlock()
+	return n, err
+}
+
+func (r *metricsReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.onClose != nil {
+		r.closeOnce.Do(func() {
+			r.onClose(r.getBytes(), err)
+		})
+	}
+	return err
+}
+
+func (r *metricsReader) getBytes() int64 {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.bytes
+}
+
+func (r *metricsReader) getReadDuration() time.Duration {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.readDuration
+}
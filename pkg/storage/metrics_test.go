@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingOperationMetrics struct {
+	mx     sync.Mutex
+	events []string
+}
+
+func (r *recordingOperationMetrics) record(event string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingOperationMetrics) OperationStarted(kind, operation string) {
+	r.record(fmt.Sprintf("start %s %s", kind, operation))
+}
+
+func (r *recordingOperationMetrics) OperationFinished(kind, operation string, bytes int64, duration time.Duration, err error) {
+	r.record(fmt.Sprintf("finish %s %s %d %v", kind, operation, bytes, err))
+}
+
+func (r *recordingOperationMetrics) SourceRead(kind string, duration time.Duration) {
+	r.record("source read " + kind)
+}
+
+func TestMetricsStorage(t *testing.T) {
+	observer := &recordingOperationMetrics{}
+	SetOperationMetrics(observer)
+	defer SetOperationMetrics(nil)
+	ctx := context.Background()
+	remote := newMetricsStorage(&memoryStorage{kind: "memory", objects: map[string][]byte{}})
+
+	require.NoError(t, remote.PutFile(ctx, "key", io.NopCloser(bytes.NewReader([]byte("body")))))
+	r, err := remote.GetFileReader(ctx, "key")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	_, err = remote.StatFile(ctx, "absent")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, []string{
+		"start memory PutFile", "finish memory PutFile 4 <nil>", "source read memory",
+		"start memory GetFileReader", "finish memory GetFileReader 4 <nil>",
+		"start memory StatFile", "finish memory StatFile 0 <nil>",
+	}, observer.events)
+
+	SetOperationMetrics(nil)
+	require.NoError(t, remote.DeleteFile(ctx, "key"))
+	assert.Len(t, observer.events, 7, "disabled metrics shall not observe operations")
+}
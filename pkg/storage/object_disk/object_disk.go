@@ -196,6 +196,8 @@ type ObjectStorageConnection struct {
 	S3           *storage.S3
 	AzureBlob    *storage.AzureBlob
 	MetadataPath string
+	// IsGCS - `s3` disk which use GCS over S3 compatible API, data could be copied with GCS server-side copy when `remote_storage: gcs`
+	IsGCS bool
 }
 
 func (c *ObjectStorageConnection) GetRemoteStorage() storage.RemoteStorage {
@@ -320,6 +322,9 @@ func getObjectDisksCredentials(ctx context.Context, ch *clickhouse.ClickHouse) (
 				}
 				if endPointNode := d.SelectElement("endpoint"); endPointNode != nil {
 					creds.EndPoint = strings.Trim(endPointNode.InnerText(), "\r\n \t")
+					if strings.Contains(creds.EndPoint, "storage.googleapis.com") {
+						creds.Type = "gcs"
+					}
 				} else {
 					return nil, fmt.Errorf("%s -> /%s/storage_configuration/disks/%s doesn't contains <endpoint>", configFile, root.Data, diskName)
 				}
@@ -423,6 +428,7 @@ func makeObjectDiskConnection(ctx context.Context, ch *clickhouse.ClickHouse, cf
 	switch creds.Type {
 	case "s3", "gcs":
 		connection.Type = "s3"
+		connection.IsGCS = creds.Type == "gcs"
 		s3cfg := config.S3Config{Debug: cfg.S3.Debug, MaxPartsCount: cfg.S3.MaxPartsCount, Concurrency: 1}
 		s3URL, err := url.Parse(creds.EndPoint)
 		if err != nil {
@@ -469,6 +475,8 @@ func makeObjectDiskConnection(ctx context.Context, ch *clickhouse.ClickHouse, cf
 			s3cfg.Path = path.Join(pathItems[1:]...)
 			s3cfg.ForcePathStyle = true
 		}
+		s3cfg.ForcePathStyle = cfg.S3.UsePathStyle(s3cfg.Bucket, s3cfg.ForcePathStyle)
+		s3cfg.RequestPayer = cfg.S3.RequestPayer
 		// need for CopyObject
 		s3cfg.ObjectDiskPath = s3cfg.Path
 		connection.S3 = &storage.S3{Config: &s3cfg, Log: apexLog.WithField("logger", "S3")}
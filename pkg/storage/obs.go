@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+)
+
+// obsMetadataURL - ECS metadata service which returns temporary credentials for agency attached to instance
+var obsMetadataURL = "http://169.254.169.254/openstack/latest/securitykey"
+
+// OBS - implement RemoteStorage for Huawei Cloud Object Storage Service native API
+type OBS struct {
+	hmacObjectStorage
+	Config *config.OBSConfig
+	Log    *apexLog.Entry
+}
+
+func (o *OBS) Kind() string {
+	return "OBS"
+}
+
+func (o *OBS) Connect(ctx context.Context) error {
+	timeout, err := time.ParseDuration(o.Config.Timeout)
+	if err != nil {
+		return err
+	}
+	transport, err := newHTTPTransport(o.Config.CACert, o.Config.SkipTLSVerify, "")
+	if err != nil {
+		return err
+	}
+	o.hmacObjectStorage = hmacObjectStorage{
+		dialect:      hmacDialect{kind: o.Kind(), authScheme: "OBS", headerPrefix: "x-obs-"},
+		bucket:       o.Config.Bucket,
+		path:         o.Config.Path,
+		partSize:     o.Config.PartSize,
+		storageClass: o.Config.StorageClass,
+		sseHeaders:   map[string]string{},
+		credentials:  o.credentials,
+		debug:        o.Config.Debug,
+		log:          o.Log,
+	}
+	if o.Config.SSE != "" {
+		o.sseHeaders["server-side-encryption"] = o.Config.SSE
+	}
+	if o.Config.SSEKMSKeyID != "" {
+		o.sseHeaders["server-side-encryption-kms-key-id"] = o.Config.SSEKMSKeyID
+	}
+	return o.connect(ctx, o.Config.Endpoint, timeout, transport)
+}
+
+func (o *OBS) Close(ctx context.Context) error {
+	o.client.CloseIdleConnections()
+	return nil
+}
+
+// credentials - static AK/SK with optional security token, or temporary AK/SK of ECS agency from metadata, refreshed before expiration
+func (o *OBS) credentials(ctx context.Context) (hmacCredentials, error) {
+	if !o.Config.UseECSMetadata {
+		return hmacCredentials{AccessKey: o.Config.AccessKey, SecretKey: o.Config.SecretKey, SecurityToken: o.Config.SecurityToken}, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, obsMetadataURL, nil)
+	if err != nil {
+		return hmacCredentials{}, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return hmacCredentials{}, err
+	}
+	defer o.closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return hmacCredentials{}, fmt.Errorf("ECS metadata return %d: %s", resp.StatusCode, body)
+	}
+	var securityKey struct {
+		Credential struct {
+			Access        string    `json:"access"`
+			Secret        string    `json:"secret"`
+			SecurityToken string    `json:"securitytoken"`
+			ExpiresAt     time.Time `json:"expires_at"`
+		} `json:"credential"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&securityKey); err != nil {
+		return hmacCredentials{}, fmt.Errorf("can't parse ECS metadata security key: %v", err)
+	}
+	return hmacCredentials{
+		AccessKey:     securityKey.Credential.Access,
+		SecretKey:     securityKey.Credential.Secret,
+		SecurityToken: securityKey.Credential.SecurityToken,
+		Expiration:    securityKey.Credential.ExpiresAt,
+	}, nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+)
+
+// ossMetadataURL - ECS instance metadata service which returns STS credentials for RAM role attached to instance
+var ossMetadataURL = "http://100.100.100.200/latest/meta-data/ram/security-credentials/"
+
+// OSS - implement RemoteStorage for Alibaba Cloud Object Storage Service native API
+type OSS struct {
+	hmacObjectStorage
+	Config *config.OSSConfig
+	Log    *apexLog.Entry
+}
+
+func (o *OSS) Kind() string {
+	return "OSS"
+}
+
+func (o *OSS) Connect(ctx context.Context) error {
+	timeout, err := time.ParseDuration(o.Config.Timeout)
+	if err != nil {
+		return err
+	}
+	transport, err := newHTTPTransport(o.Config.CACert, o.Config.SkipTLSVerify, "")
+	if err != nil {
+		return err
+	}
+	o.hmacObjectStorage = hmacObjectStorage{
+		dialect:      hmacDialect{kind: o.Kind(), authScheme: "OSS", headerPrefix: "x-oss-"},
+		bucket:       o.Config.Bucket,
+		path:         o.Config.Path,
+		partSize:     o.Config.PartSize,
+		storageClass: o.Config.StorageClass,
+		sseHeaders:   map[string]string{},
+		credentials:  o.credentials,
+		debug:        o.Config.Debug,
+		log:          o.Log,
+	}
+	if o.Config.SSE != "" {
+		o.sseHeaders["server-side-encryption"] = o.Config.SSE
+	}
+	if o.Config.SSEKMSKeyID != "" {
+		o.sseHeaders["server-side-encryption-key-id"] = o.Config.SSEKMSKeyID
+	}
+	return o.connect(ctx, o.Config.Endpoint, timeout, transport)
+}
+
+func (o *OSS) Close(ctx context.Context) error {
+	o.client.CloseIdleConnections()
+	return nil
+}
+
+// credentials - static AccessKey with optional STS security token, or temporary credentials of RAM role from ECS metadata, refreshed before expiration
+func (o *OSS) credentials(ctx context.Context) (hmacCredentials, error) {
+	if o.Config.RAMRole == "" {
+		return hmacCredentials{AccessKey: o.Config.AccessKeyID, SecretKey: o.Config.AccessKeySecret, SecurityToken: o.Config.SecurityToken}, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ossMetadataURL+o.Config.RAMRole, nil)
+	if err != nil {
+		return hmacCredentials{}, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return hmacCredentials{}, err
+	}
+	defer o.closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return hmacCredentials{}, fmt.Errorf("RAM role %s metadata return %d: %s", o.Config.RAMRole, resp.StatusCode, body)
+	}
+	var roleCreds struct {
+		Code            string    `json:"Code"`
+		AccessKeyID     string    `json:"AccessKeyId"`
+		AccessKeySecret string    `json:"AccessKeySecret"`
+		SecurityToken   string    `json:"SecurityToken"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&roleCreds); err != nil {
+		return hmacCredentials{}, fmt.Errorf("can't parse RAM role %s credentials: %v", o.Config.RAMRole, err)
+	}
+	if roleCreds.Code != "Success" {
+		return hmacCredentials{}, fmt.Errorf("RAM role %s metadata return code %s", o.Config.RAMRole, roleCreds.Code)
+	}
+	return hmacCredentials{
+		AccessKey:     roleCreds.AccessKeyID,
+		SecretKey:     roleCreds.AccessKeySecret,
+		SecurityToken: roleCreds.SecurityToken,
+		Expiration:    roleCreds.Expiration,
+	}, nil
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+type progressKey struct{}
+
+// ProgressCallback - receive remote path of archive or file and count of uncompressed bytes which were read from local disk during upload or written to local disk during download
+type ProgressCallback func(remotePath string, bytes int64)
+
+// WithProgress - upload and download with returned ctx report streamed bytes into callback, used for progress of command
+func WithProgress(ctx context.Context, callback ProgressCallback) context.Context {
+	return context.WithValue(ctx, progressKey{}, callback)
+}
+
+func reportProgress(ctx context.Context, remotePath string, bytes int64) {
+	if callback, ok := ctx.Value(progressKey{}).(ProgressCallback); ok && bytes > 0 {
+		callback(remotePath, bytes)
+	}
+}
+
+type progressReadCloser struct {
+	io.ReadCloser
+	ctx        context.Context
+	remotePath string
+}
+
+func (r *progressReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	reportProgress(r.ctx, r.remotePath, int64(n))
+	return n, err
+}
+
+// newProgressReadCloser - return r as is when ctx was not created via WithProgress
+func newProgressReadCloser(ctx context.Context, remotePath string, r io.ReadCloser) io.ReadCloser {
+	if _, ok := ctx.Value(progressKey{}).(ProgressCallback); !ok {
+		return r
+	}
+	return &progressReadCloser{ReadCloser: r, ctx: ctx, remotePath: remotePath}
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+)
+
+// Rclone - implement RemoteStorage via rclone CLI, allow to use any rclone backend (OneDrive, Dropbox, Swift, etc.) which defined as remote in rclone config
+type Rclone struct {
+	Config *config.RcloneConfig
+	Log    *apexLog.Entry
+}
+
+// rcloneObject - one item from `rclone lsjson` output
+type rcloneObject struct {
+	Path    string    `json:"Path"`
+	Name    string    `json:"Name"`
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+	IsDir   bool      `json:"IsDir"`
+}
+
+func (r *Rclone) Kind() string {
+	return "RCLONE"
+}
+
+func (r *Rclone) Connect(ctx context.Context) error {
+	if _, err := exec.LookPath(r.Config.Binary); err != nil {
+		return fmt.Errorf("rclone binary %s not found: %v", r.Config.Binary, err)
+	}
+	return nil
+}
+
+func (r *Rclone) Close(ctx context.Context) error {
+	return nil
+}
+
+// remotePath - `remote:path/key`, remote could contain path inside remote, like `onedrive:backups`
+func (r *Rclone) remotePath(key string) string {
+	if strings.HasSuffix(r.Config.Remote, ":") {
+		return r.Config.Remote + key
+	}
+	return r.Config.Remote + "/" + key
+}
+
+func (r *Rclone) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmdArgs := make([]string, 0, len(args)+len(r.Config.ExtraArgs)+2)
+	if r.Config.ConfigFile != "" {
+		cmdArgs = append(cmdArgs, "--config", r.Config.ConfigFile)
+	}
+	cmdArgs = append(cmdArgs, r.Config.ExtraArgs...)
+	cmdArgs = append(cmdArgs, args...)
+	if r.Config.Debug {
+		r.Log.Infof("[RCLONE_DEBUG] %s %s", r.Config.Binary, strings.Join(cmdArgs, " "))
+	}
+	return exec.CommandContext(ctx, r.Config.Binary, cmdArgs...)
+}
+
+// run - execute rclone and return stdout, rclone exit codes 3 and 4 mean directory or file not found
+func (r *Rclone) run(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := r.command(ctx, args...)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, r.wrapError(err, stderr.String(), args)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (r *Rclone) wrapError(err error, stderr string, args []string) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && (exitErr.ExitCode() == 3 || exitErr.ExitCode() == 4) {
+		return ErrNotFound
+	}
+	return fmt.Errorf("rclone %s return error: %w, stderr: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr))
+}
+
+// IsRetryableError - rclone retries low level errors itself, exit code 5 means temporary error, other exit codes repeat on each attempt
+func (r *Rclone) IsRetryableError(err error) bool {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode() == 5
+	}
+	return isTransientError(err)
+}
+
+func (r *Rclone) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	out, err := r.run(ctx, nil, "lsjson", "--stat", r.remotePath(path.Join(r.Config.Path, key)))
+	if err != nil {
+		return nil, err
+	}
+	object := rcloneObject{}
+	if err = json.Unmarshal(out, &object); err != nil {
+		return nil, fmt.Errorf("can't parse rclone lsjson --stat output: %v", err)
+	}
+	return &rcloneFile{size: object.Size, lastModified: object.ModTime, name: object.Name}, nil
+}
+
+// DeleteFile - directory based backends don't support delete by prefix, so directories are purged
+func (r *Rclone) DeleteFile(ctx context.Context, key string) error {
+	remotePath := r.remotePath(path.Join(r.Config.Path, key))
+	out, err := r.run(ctx, nil, "lsjson", "--stat", remotePath)
+	if err != nil {
+		return err
+	}
+	object := rcloneObject{}
+	if err = json.Unmarshal(out, &object); err != nil {
+		return fmt.Errorf("can't parse rclone lsjson --stat output: %v", err)
+	}
+	if object.IsDir {
+		_, err = r.run(ctx, nil, "purge", remotePath)
+		return err
+	}
+	_, err = r.run(ctx, nil, "deletefile", remotePath)
+	return err
+}
+
+func (r *Rclone) DeleteFileFromObjectDiskBackup(ctx context.Context, key string) error {
+	_, err := r.run(ctx, nil, "deletefile", r.remotePath(path.Join(r.Config.ObjectDiskPath, key)))
+	return err
+}
+
+// Walk - stream `rclone lsjson` output, recursive walk returns only files, names are relative to prefix
+func (r *Rclone) Walk(ctx context.Context, prefix string, recursive bool, process func(context.Context, RemoteFile) error) error {
+	args := []string{"lsjson", "--no-mimetype"}
+	if recursive {
+		args = append(args, "--recursive", "--files-only")
+	}
+	args = append(args, r.remotePath(path.Join(r.Config.Path, prefix)))
+	cmd := r.command(ctx, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	processErr := func() error {
+		decoder := json.NewDecoder(stdout)
+		if _, err := decoder.Token(); err != nil {
+			// empty output when prefix not exists
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("can't parse rclone lsjson output: %v", err)
+		}
+		for decoder.More() {
+			object := rcloneObject{}
+			if err := decoder.Decode(&object); err != nil {
+				return fmt.Errorf("can't parse rclone lsjson output: %v", err)
+			}
+			if err := process(ctx, &rcloneFile{size: object.Size, lastModified: object.ModTime, name: object.Path}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if processErr != nil {
+		// close pipe to allow rclone exit, rclone exit error doesn't matter in this case
+		_ = stdout.Close()
+		_ = cmd.Wait()
+		return processErr
+	}
+	if err = cmd.Wait(); err != nil {
+		if err = r.wrapError(err, stderr.String(), args); errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *Rclone) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	args := []string{"cat", r.remotePath(path.Join(r.Config.Path, key))}
+	cmd := r.command(ctx, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &rcloneReader{ReadCloser: stdout, cmd: cmd, stderr: stderr, args: args, rclone: r}, nil
+}
+
+func (r *Rclone) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return r.GetFileReader(ctx, key)
+}
+
+func (r *Rclone) PutFile(ctx context.Context, key string, localFile io.ReadCloser) error {
+	_, err := r.run(ctx, localFile, "rcat", r.remotePath(path.Join(r.Config.Path, key)))
+	return err
+}
+
+func (r *Rclone) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey string) (int64, error) {
+	return 0, fmt.Errorf("CopyObject not imlemented for %s", r.Kind())
+}
+
+// rcloneReader - wait rclone process on Close, to return download errors which happened after all stdout was read, when reader closed before EOF rclone will exit with broken pipe error
+type rcloneReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	args   []string
+	rclone *Rclone
+}
+
+func (reader *rcloneReader) Close() error {
+	if err := reader.ReadCloser.Close(); err != nil {
+		reader.rclone.Log.Warnf("can't close rclone stdout: %v", err)
+	}
+	if err := reader.cmd.Wait(); err != nil {
+		return reader.rclone.wrapError(err, reader.stderr.String(), reader.args)
+	}
+	return nil
+}
+
+// Implement RemoteFile
+type rcloneFile struct {
+	size         int64
+	lastModified time.Time
+	name         string
+}
+
+func (file *rcloneFile) Size() int64 {
+	return file.size
+}
+
+func (file *rcloneFile) LastModified() time.Time {
+	return file.lastModified
+}
+
+func (file *rcloneFile) Name() string {
+	return file.name
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+)
+
+// RemoteStorageFactory - return not connected RemoteStorage, BackupDestination call Connect later, settings from plugin->settings available via cfg.Plugin.Settings
+type RemoteStorageFactory func(ctx context.Context, cfg *config.Config) (RemoteStorage, error)
+
+var builtinRemoteStorages = map[string]struct{}{
+	"s3": {}, "gcs": {}, "cos": {}, "azblob": {}, "ftp": {}, "sftp": {}, "rclone": {}, "swift": {}, "oss": {}, "obs": {}, "custom": {}, "none": {},
+}
+
+var remoteStorageFactories = map[string]RemoteStorageFactory{}
+var remoteStorageFactoriesMx sync.RWMutex
+
+// RegisterRemoteStorage - allow third party RemoteStorage implementation without fork, call it from init() in own main package which import clickhouse-backup packages,
+// or from init() of Go plugin loaded via plugin->path, after that `remote_storage: <kind>` use this implementation with compression settings from `plugin` section
+func RegisterRemoteStorage(kind string, factory RemoteStorageFactory) error {
+	if kind == "" || factory == nil {
+		return fmt.Errorf("RegisterRemoteStorage require not empty kind and factory")
+	}
+	if _, isBuiltin := builtinRemoteStorages[kind]; isBuiltin {
+		return fmt.Errorf("remote storage '%s' is builtin and can't be registered", kind)
+	}
+	remoteStorageFactoriesMx.Lock()
+	defer remoteStorageFactoriesMx.Unlock()
+	if _, exists := remoteStorageFactories[kind]; exists {
+		return fmt.Errorf("remote storage '%s' already registered", kind)
+	}
+	remoteStorageFactories[kind] = factory
+	config.RegisterRemoteStorageKind(kind)
+	return nil
+}
+
+func getRemoteStorageFactory(kind string) (RemoteStorageFactory, bool) {
+	remoteStorageFactoriesMx.RLock()
+	defer remoteStorageFactoriesMx.RUnlock()
+	factory, exists := remoteStorageFactories[kind]
+	return factory, exists
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+)
+
+// ErrRehydrationPending - objects in archival storage class are not restored yet, rehydration was requested and command could be repeated later
+var ErrRehydrationPending = errors.New("objects in archival storage are not rehydrated yet")
+
+// RehydrationState - object state returned by rehydrator.rehydrationState
+type RehydrationState int
+
+const (
+	RehydrationNotRequired RehydrationState = iota
+	RehydrationRequired
+	RehydrationInProgress
+)
+
+// rehydrator - remote storage which could keep objects in archival tier, like S3 GLACIER, DEEP_ARCHIVE and Azure Archive, such objects can't be read until restored,
+// GCS ARCHIVE storage class is online and doesn't require rehydration
+type rehydrator interface {
+	// isArchivalStorageClass - storage class from RemoteFile returned by Walk, only such objects are checked by rehydrationState
+	isArchivalStorageClass(storageClass string) bool
+	rehydrationState(ctx context.Context, key string) (RehydrationState, error)
+	requestRehydration(ctx context.Context, key, storageClass string) error
+}
+
+// storageClassFile - RemoteFile which knows storage class without additional request
+type storageClassFile interface {
+	StorageClass() string
+}
+
+var rehydrationPollMinInterval = 10 * time.Second
+var rehydrationPollMaxInterval = 5 * time.Minute
+
+// unwrapRemoteStorage - metrics and retry wrappers hide optional interfaces of storage backend
+func unwrapRemoteStorage(s RemoteStorage) RemoteStorage {
+	for {
+		switch wrapper := s.(type) {
+		case *metricsStorage:
+			s = wrapper.RemoteStorage
+		case *retryStorage:
+			s = wrapper.RemoteStorage
+		default:
+			return s
+		}
+	}
+}
+
+// RehydrateObjects - walk remotePaths, request rehydration for each object in archival storage class and wait until all of them become readable,
+// requests for all objects are sent before waiting, so restore jobs run in parallel on storage side,
+// maxWait=0 means don't wait and return ErrRehydrationPending when at least one object is not restored yet
+func (bd *BackupDestination) RehydrateObjects(ctx context.Context, remotePaths []string, maxWait time.Duration) error {
+	r, isRehydrator := unwrapRemoteStorage(bd.RemoteStorage).(rehydrator)
+	if !isRehydrator {
+		return nil
+	}
+	start := time.Now()
+	pending := make([]string, 0)
+	for _, remotePath := range remotePaths {
+		err := bd.Walk(ctx, remotePath+"/", true, func(ctx context.Context, f RemoteFile) error {
+			classFile, ok := f.(storageClassFile)
+			if !ok || !r.isArchivalStorageClass(classFile.StorageClass()) {
+				return nil
+			}
+			key := path.Join(remotePath, f.Name())
+			state, err := r.rehydrationState(ctx, key)
+			if err != nil {
+				return err
+			}
+			if state == RehydrationRequired {
+				if err = r.requestRehydration(ctx, key, classFile.StorageClass()); err != nil {
+					return fmt.Errorf("can't request rehydration for %s: %v", key, err)
+				}
+				state = RehydrationInProgress
+			}
+			if state == RehydrationInProgress {
+				pending = append(pending, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	total := len(pending)
+	bd.Log.Infof("rehydration requested for %d objects in archival storage, max wait %s", total, utils.HumanizeDuration(maxWait))
+	pollInterval := rehydrationPollMinInterval
+	for {
+		if time.Since(start) >= maxWait {
+			sort.Strings(pending)
+			examples := pending
+			if len(examples) > 5 {
+				examples = examples[:5]
+			}
+			return fmt.Errorf("%w: %d of %d objects still in progress after %s, for example %s, repeat command later or increase --max-rehydration-wait", ErrRehydrationPending, len(pending), total, utils.HumanizeDuration(time.Since(start)), strings.Join(examples, ", "))
+		}
+		wait := pollInterval
+		if left := maxWait - time.Since(start); left < wait {
+			wait = left
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		stillPending := pending[:0]
+		for _, key := range pending {
+			state, err := r.rehydrationState(ctx, key)
+			if err != nil {
+				return err
+			}
+			if state != RehydrationNotRequired {
+				stillPending = append(stillPending, key)
+			}
+		}
+		pending = stillPending
+		if len(pending) == 0 {
+			bd.Log.Infof("rehydration of %d objects done, duration %s", total, utils.HumanizeDuration(time.Since(start)))
+			return nil
+		}
+		bd.Log.Infof("rehydration %d of %d objects done, duration %s", total-len(pending), total, utils.HumanizeDuration(time.Since(start)))
+		if pollInterval *= 2; pollInterval > rehydrationPollMaxInterval {
+			pollInterval = rehydrationPollMaxInterval
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type archivalStorage struct {
+	RemoteStorage
+	mx        sync.Mutex
+	files     map[string]string
+	requested map[string]int
+	// pollsUntilDone - how many rehydrationState calls after requestRehydration object stays in progress
+	pollsUntilDone int
+	polls          map[string]int
+}
+
+func (a *archivalStorage) Kind() string {
+	return "archival"
+}
+
+func (a *archivalStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(context.Context, RemoteFile) error) error {
+	for name, storageClass := range a.files {
+		if err := fn(ctx, &s3File{name: name, storageClass: storageClass}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *archivalStorage) isArchivalStorageClass(storageClass string) bool {
+	return storageClass == "GLACIER"
+}
+
+func (a *archivalStorage) rehydrationState(ctx context.Context, key string) (RehydrationState, error) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if _, requested := a.requested[key]; !requested {
+		return RehydrationRequired, nil
+	}
+	a.polls[key]++
+	if a.polls[key] > a.pollsUntilDone {
+		return RehydrationNotRequired, nil
+	}
+	return RehydrationInProgress, nil
+}
+
+func (a *archivalStorage) requestRehydration(ctx context.Context, key, storageClass string) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.requested[key]++
+	return nil
+}
+
+func newArchivalStorage(pollsUntilDone int) *archivalStorage {
+	return &archivalStorage{
+		files: map[string]string{
+			"default/data_1.tar":  "GLACIER",
+			"default/data_2.tar":  "GLACIER",
+			"default/hot_1.tar":   "STANDARD",
+			"default/glacier_ir":  "GLACIER_IR",
+			"default/segment.000": "GLACIER",
+		},
+		requested:      make(map[string]int),
+		polls:          make(map[string]int),
+		pollsUntilDone: pollsUntilDone,
+	}
+}
+
+func TestRehydrateObjects(t *testing.T) {
+	rehydrationPollMinInterval = time.Millisecond
+	defer func() {
+		rehydrationPollMinInterval = 10 * time.Second
+	}()
+	cfg := config.DefaultConfig()
+	cfg.General.StorageRetries = 1
+	log := apexLog.WithField("logger", "test")
+
+	archival := newArchivalStorage(2)
+	bd := &BackupDestination{RemoteStorage: newMetricsStorage(newRetryStorage(archival, cfg, log)), Log: log}
+	require.NoError(t, bd.RehydrateObjects(context.Background(), []string{"backup/shadow/db/table"}, time.Minute))
+	assert.Equal(t, map[string]int{
+		"backup/shadow/db/table/default/data_1.tar":  1,
+		"backup/shadow/db/table/default/data_2.tar":  1,
+		"backup/shadow/db/table/default/segment.000": 1,
+	}, archival.requested, "only archival objects shall be requested once, through metrics and retry wrappers")
+
+	archival = newArchivalStorage(1000)
+	bd = &BackupDestination{RemoteStorage: archival, Log: log}
+	err := bd.RehydrateObjects(context.Background(), []string{"backup/shadow/db/table"}, 0)
+	assert.ErrorIs(t, err, ErrRehydrationPending)
+	assert.Len(t, archival.requested, 3, "max wait 0 shall request rehydration before fail")
+
+	err = bd.RehydrateObjects(context.Background(), []string{"backup/shadow/db/table"}, 5*time.Millisecond)
+	assert.ErrorIs(t, err, ErrRehydrationPending)
+	assert.Equal(t, 1, archival.requested["backup/shadow/db/table/default/data_1.tar"], "in progress objects shall not be requested again")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, bd.RehydrateObjects(ctx, []string{"backup/shadow/db/table"}, time.Hour), context.Canceled)
+
+	bd = &BackupDestination{RemoteStorage: &flakyStorage{}, Log: log}
+	assert.NoError(t, bd.RehydrateObjects(context.Background(), []string{"backup"}, 0), "storage without archival tiers shall skip rehydration")
+}
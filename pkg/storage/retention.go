@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+)
+
+// BackupRetention - retention policy for remote backups, zero value for each keep option means disabled
+type BackupRetention struct {
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	MinAge      time.Duration
+}
+
+func NewBackupRetention(cfg *config.Config) BackupRetention {
+	return BackupRetention{
+		KeepLast:    cfg.General.BackupsToKeepRemote,
+		KeepDaily:   cfg.General.KeepDailyRemote,
+		KeepWeekly:  cfg.General.KeepWeeklyRemote,
+		KeepMonthly: cfg.General.KeepMonthlyRemote,
+		MinAge:      cfg.General.MinAgeRemoteDuration,
+	}
+}
+
+// ParseBackupRetention - parse retention scenario like `keep_last=7,keep_daily=14,keep_weekly=4,keep_monthly=12,min_age=24h`, options could be separated by comma, semicolon or space
+func ParseBackupRetention(scenario string) (BackupRetention, error) {
+	retention := BackupRetention{}
+	options := strings.FieldsFunc(scenario, func(r rune) bool {
+		return r == ',' || r == ';' || unicode.IsSpace(r)
+	})
+	for _, option := range options {
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 {
+			return retention, fmt.Errorf("invalid retention option '%s' in '%s', expected key=value", option, scenario)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if key == "min_age" {
+			minAge, err := time.ParseDuration(value)
+			if err != nil {
+				return retention, fmt.Errorf("invalid min_age in '%s': %v", scenario, err)
+			}
+			retention.MinAge = minAge
+			continue
+		}
+		keep, err := strconv.Atoi(value)
+		if err != nil {
+			return retention, fmt.Errorf("invalid %s in '%s': %v", key, scenario, err)
+		}
+		switch key {
+		case "keep_last":
+			retention.KeepLast = keep
+		case "keep_daily":
+			retention.KeepDaily = keep
+		case "keep_weekly":
+			retention.KeepWeekly = keep
+		case "keep_monthly":
+			retention.KeepMonthly = keep
+		default:
+			return retention, fmt.Errorf("unknown retention option '%s' in '%s', allowed keep_last, keep_daily, keep_weekly, keep_monthly, min_age", key, scenario)
+		}
+	}
+	return retention, nil
+}
+
+func (r BackupRetention) String() string {
+	options := make([]string, 0, 5)
+	for _, option := range []struct {
+		key   string
+		value int
+	}{{"keep_last", r.KeepLast}, {"keep_daily", r.KeepDaily}, {"keep_weekly", r.KeepWeekly}, {"keep_monthly", r.KeepMonthly}} {
+		if option.value > 0 {
+			options = append(options, fmt.Sprintf("%s=%d", option.key, option.value))
+		}
+	}
+	if r.MinAge > 0 {
+		options = append(options, fmt.Sprintf("min_age=%s", r.MinAge))
+	}
+	return strings.Join(options, ",")
+}
+
+// IsEnabled - min age only protects backups from deletion, so retention applies only when some keep option defined
+func (r BackupRetention) IsEnabled() bool {
+	return r.KeepLast > 0 || r.KeepDaily > 0 || r.KeepWeekly > 0 || r.KeepMonthly > 0
+}
+
+// GetBackupsToDeleteByRetention - keep latest `KeepLast` backups, the newest backup for each of latest `KeepDaily` days, `KeepWeekly` ISO weeks, `KeepMonthly` months (grandfather-father-son),
+// all backups younger than `MinAge`, and all backups which required by kept incremental backups
+func GetBackupsToDeleteByRetention(backups []Backup, retention BackupRetention, now time.Time) []Backup {
+	if !retention.IsEnabled() || len(backups) == 0 {
+		return []Backup{}
+	}
+	// sort backup descending
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].UploadDate.After(backups[j].UploadDate)
+	})
+	keepBackups := make(map[string]struct{})
+	for i := 0; i < retention.KeepLast && i < len(backups); i++ {
+		keepBackups[backups[i].BackupName] = struct{}{}
+	}
+	keepByPeriod := func(keepPeriods int, periodKey func(t time.Time) string) {
+		periods := make(map[string]struct{})
+		for _, b := range backups {
+			if len(periods) >= keepPeriods {
+				return
+			}
+			if b.Broken != "" || b.UploadDate.IsZero() {
+				continue
+			}
+			key := periodKey(b.UploadDate)
+			if _, exists := periods[key]; exists {
+				continue
+			}
+			periods[key] = struct{}{}
+			keepBackups[b.BackupName] = struct{}{}
+		}
+	}
+	keepByPeriod(retention.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepByPeriod(retention.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%02d", year, week)
+	})
+	keepByPeriod(retention.KeepMonthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+	backupByName := make(map[string]Backup, len(backups))
+	for _, b := range backups {
+		backupByName[b.BackupName] = b
+		// backup with UploadDate `0001-01-01 00:00:00` could be uploading right now from another shard, fix https://github.com/Altinity/clickhouse-backup/issues/409
+		if b.UploadDate.IsZero() || (retention.MinAge > 0 && now.Sub(b.UploadDate) < retention.MinAge) {
+			keepBackups[b.BackupName] = struct{}{}
+		}
+	}
+	// never delete base backup which still referenced by kept incremental backup
+	for backupName := range keepBackups {
+		for required := backupByName[backupName].RequiredBackup; required != ""; required = backupByName[required].RequiredBackup {
+			if _, exists := keepBackups[required]; exists {
+				break
+			}
+			keepBackups[required] = struct{}{}
+		}
+	}
+	deletedBackups := make([]Backup, 0)
+	for _, b := range backups {
+		if _, exists := keepBackups[b.BackupName]; !exists {
+			deletedBackups = append(deletedBackups, b)
+		}
+	}
+	return deletedBackups
+}
+
+// GetBackupsToDeleteByRetentionWithGroups - backups of the same group, like `<backup_name>-shard<N>` backups of `create_remote --on-cluster`, counted by retention as one backup and kept or deleted together,
+// groups contains group name for each grouped backup name
+func GetBackupsToDeleteByRetentionWithGroups(backups []Backup, groups map[string]string, retention BackupRetention, now time.Time) []Backup {
+	if len(groups) == 0 {
+		return GetBackupsToDeleteByRetention(backups, retention, now)
+	}
+	groupMembers := make(map[string][]Backup)
+	groupIdx := make(map[string]int)
+	groupedBackups := make([]Backup, 0, len(backups))
+	for _, b := range backups {
+		if group, isRequiredGrouped := groups[b.RequiredBackup]; isRequiredGrouped {
+			b.RequiredBackup = group
+		}
+		group, isGrouped := groups[b.BackupName]
+		if !isGrouped {
+			groupedBackups = append(groupedBackups, b)
+			continue
+		}
+		groupMembers[group] = append(groupMembers[group], b)
+		if i, exists := groupIdx[group]; exists {
+			// zero UploadDate means shard backup is uploading right now, so whole group is kept
+			if b.UploadDate.IsZero() || (!groupedBackups[i].UploadDate.IsZero() && b.UploadDate.After(groupedBackups[i].UploadDate)) {
+				groupedBackups[i].UploadDate = b.UploadDate
+			}
+			if b.Broken == "" {
+				groupedBackups[i].Broken = ""
+			}
+			if groupedBackups[i].RequiredBackup == "" {
+				groupedBackups[i].RequiredBackup = b.RequiredBackup
+			}
+			continue
+		}
+		groupIdx[group] = len(groupedBackups)
+		b.BackupName = group
+		groupedBackups = append(groupedBackups, b)
+	}
+	deletedBackups := make([]Backup, 0)
+	for _, b := range GetBackupsToDeleteByRetention(groupedBackups, retention, now) {
+		if members, isGroup := groupMembers[b.BackupName]; isGroup {
+			deletedBackups = append(deletedBackups, members...)
+		} else {
+			deletedBackups = append(deletedBackups, b)
+		}
+	}
+	return deletedBackups
+}
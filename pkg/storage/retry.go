@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/apex/log"
+	"github.com/eapache/go-resiliency/retrier"
+)
+
+// retryableErrorClassifier - backend could implement it to distinguish transient errors from errors which will repeat on each attempt, like access denied
+type retryableErrorClassifier interface {
+	IsRetryableError(err error) bool
+}
+
+// putFileRetryBufferSize - PutFile body which fits into buffer is retried, bigger source reader can't rewind and is uploaded once, whole upload retries use general->retries_on_failure
+const putFileRetryBufferSize = 1024 * 1024
+
+// retryStorage - RemoteStorage wrapper which retries idempotent operations with exponential backoff, see general->storage_retries
+type retryStorage struct {
+	RemoteStorage
+	retries  int
+	pause    time.Duration
+	maxPause time.Duration
+	log      *log.Entry
+}
+
+// newRetryStorage - logger contains correlation_id and command_id of current operation
+func newRetryStorage(s RemoteStorage, cfg *config.Config, logger *log.Entry) RemoteStorage {
+	if cfg.General.StorageRetries <= 0 {
+		return s
+	}
+	return &retryStorage{
+		RemoteStorage: s,
+		retries:       cfg.General.StorageRetries,
+		pause:         cfg.General.StorageRetriesPauseDuration,
+		maxPause:      cfg.General.StorageRetriesMaxPauseDuration,
+		log:           logger.WithField("logger", "retryStorage").WithField("storage", s.Kind()),
+	}
+}
+
+// Classify - implements retrier.Classifier
+func (r *retryStorage) Classify(err error) retrier.Action {
+	if err == nil {
+		return retrier.Succeed
+	}
+	if !r.isRetryableError(err) {
+		return retrier.Fail
+	}
+	return retrier.Retry
+}
+
+func (r *retryStorage) isRetryableError(err error) bool {
+	var stop retrierStop
+	if errors.As(err, &stop) {
+		return false
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, os.ErrNotExist) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// data corrupted during transfer, backend doesn't keep object with wrong checksum
+	if errors.Is(err, ErrChecksumMismatch) {
+		return true
+	}
+	if classifier, ok := r.RemoteStorage.(retryableErrorClassifier); ok {
+		return classifier.IsRetryableError(err)
+	}
+	return isTransientError(err)
+}
+
+// isTransientError - network errors, used when error doesn't contain HTTP status or protocol code, other errors like access denied or not implemented repeat on each attempt
+func isTransientError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE)
+}
+
+// isRetryableHTTPStatus - server errors, timeout and throttling, 4xx client errors repeat on each attempt
+func isRetryableHTTPStatus(statusCode int) bool {
+	return statusCode >= 500 || statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests
+}
+
+func (r *retryStorage) run(ctx context.Context, operation, key string, work func(ctx context.Context) error) error {
+	attempt := 0
+	return retrier.New(retrier.LimitedExponentialBackoff(r.retries, r.pause, r.maxPause), r).RunCtx(ctx, func(ctx context.Context) error {
+		attempt++
+		err := work(ctx)
+		if err != nil && attempt <= r.retries && r.isRetryableError(err) {
+			r.log.Warnf("%s %s attempt %d/%d error: %v", operation, key, attempt, r.retries+1, err)
+		}
+		return err
+	})
+}
+
+func (r *retryStorage) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	var file RemoteFile
+	err := r.run(ctx, "StatFile", key, func(ctx context.Context) error {
+		var err error
+		file, err = r.RemoteStorage.StatFile(ctx, key)
+		return err
+	})
+	return file, err
+}
+
+func (r *retryStorage) DeleteFile(ctx context.Context, key string) error {
+	return r.run(ctx, "DeleteFile", key, func(ctx context.Context) error {
+		return r.RemoteStorage.DeleteFile(ctx, key)
+	})
+}
+
+func (r *retryStorage) DeleteFileFromObjectDiskBackup(ctx context.Context, key string) error {
+	return r.run(ctx, "DeleteFileFromObjectDiskBackup", key, func(ctx context.Context) error {
+		return r.RemoteStorage.DeleteFileFromObjectDiskBackup(ctx, key)
+	})
+}
+
+// Walk - retry only when error happens before first file processed, to avoid call fn twice for the same file
+func (r *retryStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(context.Context, RemoteFile) error) error {
+	processed := false
+	err := r.run(ctx, "Walk", prefix, func(ctx context.Context) error {
+		err := r.RemoteStorage.Walk(ctx, prefix, recursive, func(ctx context.Context, file RemoteFile) error {
+			processed = true
+			return fn(ctx, file)
+		})
+		if err != nil && processed {
+			return retrierStop{err}
+		}
+		return err
+	})
+	var stop retrierStop
+	if errors.As(err, &stop) {
+		return stop.err
+	}
+	return err
+}
+
+// PutFile - small bodies like metadata and sentinels are read into memory and retried, bigger body is uploaded without retry
+func (r *retryStorage) PutFile(ctx context.Context, key string, reader io.ReadCloser) error {
+	body, err := io.ReadAll(io.LimitReader(reader, putFileRetryBufferSize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > putFileRetryBufferSize {
+		return r.RemoteStorage.PutFile(ctx, key, &putFileReader{Reader: io.MultiReader(bytes.NewReader(body), reader), Closer: reader})
+	}
+	return r.run(ctx, "PutFile", key, func(ctx context.Context) error {
+		return r.RemoteStorage.PutFile(ctx, key, io.NopCloser(bytes.NewReader(body)))
+	})
+}
+
+// putFileReader - already buffered beginning of body and rest of source reader
+type putFileReader struct {
+	io.Reader
+	io.Closer
+}
+
+// GetFileReader - retry only open object, errors during read will retry with whole download
+func (r *retryStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	err := r.run(ctx, "GetFileReader", key, func(ctx context.Context) error {
+		var err error
+		reader, err = r.RemoteStorage.GetFileReader(ctx, key)
+		return err
+	})
+	return reader, err
+}
+
+func (r *retryStorage) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	err := r.run(ctx, "GetFileReaderWithLocalPath", key, func(ctx context.Context) error {
+		var err error
+		reader, err = r.RemoteStorage.GetFileReaderWithLocalPath(ctx, key, localPath)
+		return err
+	})
+	return reader, err
+}
+
+func (r *retryStorage) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey string) (int64, error) {
+	var size int64
+	err := r.run(ctx, "CopyObject", dstKey, func(ctx context.Context) error {
+		var err error
+		size, err = r.RemoteStorage.CopyObject(ctx, srcBucket, srcKey, dstKey)
+		return err
+	})
+	return size, err
+}
+
+// retrierStop - error which shall not retry regardless of backend classification
+type retrierStop struct {
+	err error
+}
+
+func (s retrierStop) Error() string {
+	return s.err.Error()
+}
+
+func (s retrierStop) Unwrap() error {
+	return s.err
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	libSFTP "github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+)
+
+type flakyStorage struct {
+	RemoteStorage
+	errs   []error
+	calls  int
+	bodies [][]byte
+}
+
+func (f *flakyStorage) Kind() string {
+	return "flaky"
+}
+
+func (f *flakyStorage) nextErr() error {
+	f.calls++
+	if f.calls <= len(f.errs) {
+		return f.errs[f.calls-1]
+	}
+	return nil
+}
+
+func (f *flakyStorage) DeleteFile(ctx context.Context, key string) error {
+	return f.nextErr()
+}
+
+func (f *flakyStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.bodies = append(f.bodies, body)
+	return f.nextErr()
+}
+
+func (f *flakyStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(context.Context, RemoteFile) error) error {
+	if err := fn(ctx, nil); err != nil {
+		return err
+	}
+	return f.nextErr()
+}
+
+func TestRetryStorage(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.StorageRetries = 2
+	cfg.General.StorageRetriesPauseDuration = time.Millisecond
+	cfg.General.StorageRetriesMaxPauseDuration = time.Millisecond
+	transient := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+	flaky := &flakyStorage{errs: []error{transient, transient}}
+	assert.NoError(t, newRetryStorage(flaky, cfg, apexLog.WithField("logger", "test")).DeleteFile(context.Background(), "key"))
+	assert.Equal(t, 3, flaky.calls)
+
+	flaky = &flakyStorage{errs: []error{ErrNotFound}}
+	assert.ErrorIs(t, newRetryStorage(flaky, cfg, apexLog.WithField("logger", "test")).DeleteFile(context.Background(), "key"), ErrNotFound)
+	assert.Equal(t, 1, flaky.calls)
+
+	flaky = &flakyStorage{errs: []error{transient}}
+	walked := 0
+	err := newRetryStorage(flaky, cfg, apexLog.WithField("logger", "test")).Walk(context.Background(), "/", true, func(ctx context.Context, f RemoteFile) error {
+		walked++
+		return nil
+	})
+	assert.Equal(t, transient, err, "Walk shall not retry after files processed")
+	assert.Equal(t, 1, walked)
+
+	flaky = &flakyStorage{errs: []error{transient, transient}}
+	assert.NoError(t, newRetryStorage(flaky, cfg, apexLog.WithField("logger", "test")).PutFile(context.Background(), "key", io.NopCloser(strings.NewReader("metadata"))))
+	assert.Equal(t, [][]byte{[]byte("metadata"), []byte("metadata"), []byte("metadata")}, flaky.bodies, "small body shall be uploaded again on each attempt")
+
+	bigBody := bytes.Repeat([]byte{1}, putFileRetryBufferSize+1)
+	flaky = &flakyStorage{errs: []error{transient}}
+	assert.Equal(t, transient, newRetryStorage(flaky, cfg, apexLog.WithField("logger", "test")).PutFile(context.Background(), "key", io.NopCloser(bytes.NewReader(bigBody))))
+	assert.Equal(t, [][]byte{bigBody}, flaky.bodies, "source reader which doesn't fit into buffer can't rewind")
+
+	flaky = &flakyStorage{errs: []error{errors.New("access denied")}}
+	assert.Error(t, newRetryStorage(flaky, cfg, apexLog.WithField("logger", "test")).DeleteFile(context.Background(), "key"))
+	assert.Equal(t, 1, flaky.calls, "storage without classifier retries only network errors")
+
+	cfg.General.StorageRetries = 0
+	flaky = &flakyStorage{}
+	assert.Equal(t, flaky, newRetryStorage(flaky, cfg, apexLog.WithField("logger", "test")))
+}
+
+func TestIsRetryableError(t *testing.T) {
+	networkErr := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	assert.True(t, isTransientError(networkErr))
+	assert.True(t, isTransientError(fmt.Errorf("upload: %w", io.ErrUnexpectedEOF)))
+	assert.False(t, isTransientError(errors.New("invalid argument")))
+
+	assert.True(t, (&S3{}).IsRetryableError(networkErr))
+	assert.False(t, (&S3{}).IsRetryableError(errors.New("invalid checksum algorithm")))
+
+	assert.True(t, (&FTP{}).IsRetryableError(&textproto.Error{Code: 421, Msg: "service not available"}))
+	assert.False(t, (&FTP{}).IsRetryableError(&textproto.Error{Code: 550, Msg: "permission denied"}))
+
+	assert.True(t, (&SFTP{}).IsRetryableError(libSFTP.ErrSSHFxConnectionLost))
+	assert.False(t, (&SFTP{}).IsRetryableError(&libSFTP.StatusError{Code: uint32(libSFTP.ErrSSHFxPermissionDenied)}))
+
+	temporaryErr := exec.Command("sh", "-c", "exit 5").Run()
+	fatalErr := exec.Command("sh", "-c", "exit 7").Run()
+	assert.True(t, (&Rclone{}).IsRetryableError((&Rclone{}).wrapError(temporaryErr, "", []string{"rcat"})))
+	assert.False(t, (&Rclone{}).IsRetryableError((&Rclone{}).wrapError(fatalErr, "", []string{"rcat"})))
+}
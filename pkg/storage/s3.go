@@ -2,7 +2,6 @@ package storage
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
@@ -101,6 +100,15 @@ func (s *S3) Kind() string {
 	return "S3"
 }
 
+func (s *S3) newAssumeRoleProvider(awsConfig aws.Config, roleARN string) aws.CredentialsProvider {
+	stsClient := sts.NewFromConfig(awsConfig)
+	return aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, roleARN, func(o *stscreds.AssumeRoleOptions) {
+		if s.Config.AssumeRoleExternalID != "" {
+			o.ExternalID = aws.String(s.Config.AssumeRoleExternalID)
+		}
+	}))
+}
+
 // Connect - connect to s3
 func (s *S3) Connect(ctx context.Context) error {
 	var err error
@@ -116,21 +124,21 @@ func (s *S3) Connect(ctx context.Context) error {
 		awsConfig.Region = s.Config.Region
 	}
 	awsRoleARN := os.Getenv("AWS_ROLE_ARN")
-	if s.Config.AssumeRoleARN != "" || awsRoleARN != "" {
-		stsClient := sts.NewFromConfig(awsConfig)
-		if awsRoleARN != "" {
-			awsConfig.Credentials = stscreds.NewAssumeRoleProvider(stsClient, awsRoleARN)
-		} else {
-			awsConfig.Credentials = stscreds.NewAssumeRoleProvider(stsClient, s.Config.AssumeRoleARN)
-		}
-	}
-
 	awsWebIdentityTokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
 	if awsRoleARN != "" && awsWebIdentityTokenFile != "" {
 		stsClient := sts.NewFromConfig(awsConfig)
-		awsConfig.Credentials = stscreds.NewWebIdentityRoleProvider(
+		awsConfig.Credentials = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(
 			stsClient, awsRoleARN, stscreds.IdentityTokenFile(awsWebIdentityTokenFile),
-		)
+		))
+		// role chaining, web identity role assume secondary role, for example, to access bucket in another AWS account
+		if s.Config.AssumeRoleARN != "" && s.Config.AssumeRoleARN != awsRoleARN {
+			s.Log.Debugf("chain AssumeRole %s after AssumeRoleWithWebIdentity %s", s.Config.AssumeRoleARN, awsRoleARN)
+			awsConfig.Credentials = s.newAssumeRoleProvider(awsConfig, s.Config.AssumeRoleARN)
+		}
+	} else if awsRoleARN != "" {
+		awsConfig.Credentials = s.newAssumeRoleProvider(awsConfig, awsRoleARN)
+	} else if s.Config.AssumeRoleARN != "" {
+		awsConfig.Credentials = s.newAssumeRoleProvider(awsConfig, s.Config.AssumeRoleARN)
 	}
 
 	if s.Config.AccessKey != "" && s.Config.SecretKey != "" {
@@ -148,10 +156,15 @@ func (s *S3) Connect(ctx context.Context) error {
 	}
 
 	httpTransport := http.DefaultTransport
-	if s.Config.DisableCertVerification {
-		httpTransport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	if isCustomHTTPTransport(s.Config.CACert, s.Config.DisableCertVerification, s.Config.Proxy) {
+		if s.Config.DisableCertVerification {
+			s.Log.Warn("disable_cert_verification: true, TLS certificate of S3 endpoint will not be verified")
 		}
+		customTransport, err := newHTTPTransport(s.Config.CACert, s.Config.DisableCertVerification, s.Config.Proxy)
+		if err != nil {
+			return err
+		}
+		httpTransport = customTransport
 		awsConfig.HTTPClient = &http.Client{Transport: httpTransport}
 	}
 
@@ -173,8 +186,12 @@ func (s *S3) Connect(ctx context.Context) error {
 		awsConfig.HTTPClient = &http.Client{Transport: &RecalculateV4Signature{httpTransport, v4.NewSigner(), awsConfig}}
 	}
 	s.client = s3.NewFromConfig(awsConfig, func(o *s3.Options) {
-		o.UsePathStyle = s.Config.ForcePathStyle
+		o.UsePathStyle = s.Config.UsePathStyle(s.Config.Bucket, s.Config.ForcePathStyle)
 		o.EndpointOptions.DisableHTTPS = s.Config.DisableSSL
+		// requester-pays bucket reject each request without header, including CopyObject, multipart upload and download parts
+		if s.Config.RequestPayer != "" {
+			o.APIOptions = append(o.APIOptions, awsV2http.SetHeaderValue("x-amz-request-payer", s.Config.RequestPayer))
+		}
 	})
 
 	s.uploader = s3manager.NewUploader(s.client)
@@ -266,7 +283,7 @@ func (s *S3) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
 		Bucket:       aws.String(s.Config.Bucket),
 		Key:          aws.String(path.Join(s.Config.Path, key)),
 		Body:         r,
-		StorageClass: s3types.StorageClass(strings.ToUpper(s.Config.StorageClass)),
+		StorageClass: s3types.StorageClass(strings.ToUpper(getStorageClass(ctx, s.Config.StorageClass))),
 	}
 	// https://github.com/Altinity/clickhouse-backup/issues/588
 	if len(s.Config.ObjectLabels) > 0 {
@@ -297,8 +314,49 @@ func (s *S3) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
 	if s.Config.SSEKMSEncryptionContext != "" {
 		params.SSEKMSEncryptionContext = aws.String(s.Config.SSEKMSEncryptionContext)
 	}
-	_, err := s.uploader.Upload(ctx, &params)
-	return err
+	if s.Config.ChecksumAlgorithm == "" {
+		_, err := s.uploader.Upload(ctx, &params)
+		return err
+	}
+	return s.putFileWithChecksum(ctx, &params, r)
+}
+
+// putFileWithChecksum - uploader sends checksum for each part, S3 verifies it for each part and calculate composite checksum for whole object,
+// we compare it with checksum of data which we read, to detect data corruption between our process and S3
+func (s *S3) putFileWithChecksum(ctx context.Context, params *s3.PutObjectInput, r io.Reader) error {
+	newHash, err := newChecksumHash(s.Config.ChecksumAlgorithm)
+	if err != nil {
+		return err
+	}
+	params.ChecksumAlgorithm = s3types.ChecksumAlgorithm(strings.ToUpper(s.Config.ChecksumAlgorithm))
+	// checksumReader hide io.Seeker, so uploader will not change PartSize and part boundaries will the same with partChecksumReader
+	checksumReader := newPartChecksumReader(r, newHash, s.uploader.PartSize)
+	params.Body = checksumReader
+	out, err := s.uploader.Upload(ctx, params)
+	if err != nil {
+		return err
+	}
+	remoteChecksum := out.ChecksumCRC32C
+	if params.ChecksumAlgorithm == s3types.ChecksumAlgorithmSha256 {
+		remoteChecksum = out.ChecksumSHA256
+	}
+	if remoteChecksum == nil || *remoteChecksum == "" {
+		s.Log.Warnf("%s: S3 doesn't return %s checksum, can't verify upload", *params.Key, params.ChecksumAlgorithm)
+		return nil
+	}
+	if err = checksumReader.Verify(*remoteChecksum); err != nil {
+		return fmt.Errorf("%s: %w", *params.Key, err)
+	}
+	return nil
+}
+
+// IsRetryableError - client errors like access denied or invalid request will repeat on each attempt, except timeout and throttling
+func (s *S3) IsRetryableError(err error) bool {
+	var httpErr *awsV2http.ResponseError
+	if errors.As(err, &httpErr) {
+		return isRetryableHTTPStatus(httpErr.HTTPStatusCode())
+	}
+	return isTransientError(err)
 }
 
 func (s *S3) deleteKey(ctx context.Context, key string) error {
@@ -620,40 +678,82 @@ func (s *S3) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey string) (
 }
 
 func (s *S3) restoreObject(ctx context.Context, key string) error {
-	restoreRequest := s3.RestoreObjectInput{
-		Bucket: aws.String(s.Config.Bucket),
-		Key:    aws.String(path.Join(s.Config.Path, key)),
-		RestoreRequest: &s3types.RestoreRequest{
-			Days: 1,
-			GlacierJobParameters: &s3types.GlacierJobParameters{
-				Tier: s3types.Tier("Expedited"),
-			},
-		},
-	}
-	_, err := s.client.RestoreObject(ctx, &restoreRequest)
-	if err != nil {
+	if err := s.requestRehydration(ctx, key, string(s3types.StorageClassGlacier)); err != nil {
 		return err
 	}
 	i := 0
 	for {
-		restoreHeadParams := &s3.HeadObjectInput{
-			Bucket: aws.String(s.Config.Bucket),
-			Key:    aws.String(path.Join(s.Config.Path, key)),
-		}
-		s.enrichHeadParamsWithSSE(restoreHeadParams)
-		res, err := s.client.HeadObject(ctx, restoreHeadParams)
+		state, err := s.rehydrationState(ctx, key)
 		if err != nil {
-			return fmt.Errorf("restoreObject: failed to head %s object metadata, %v", path.Join(s.Config.Path, key), err)
+			return fmt.Errorf("restoreObject: %v", err)
 		}
-
-		if res.Restore != nil && *res.Restore == "ongoing-request=\"true\"" {
-			i += 1
-			s.Log.Warnf("%s still not restored, will wait %d seconds", key, i*5)
-			time.Sleep(time.Duration(i*5) * time.Second)
-		} else {
+		if state != RehydrationInProgress {
 			return nil
 		}
+		i += 1
+		s.Log.Warnf("%s still not restored, will wait %d seconds", key, i*5)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(i*5) * time.Second):
+		}
+	}
+}
+
+// isArchivalStorageClass - GLACIER_IR is instant retrieval, INTELLIGENT_TIERING objects require restore only when moved to archive access tiers, it checked via HeadObject
+func (s *S3) isArchivalStorageClass(storageClass string) bool {
+	switch s3types.StorageClass(strings.ToUpper(storageClass)) {
+	case s3types.StorageClassGlacier, s3types.StorageClassDeepArchive, s3types.StorageClassIntelligentTiering:
+		return true
+	}
+	return false
+}
+
+func (s *S3) rehydrationState(ctx context.Context, key string) (RehydrationState, error) {
+	headParams := &s3.HeadObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(path.Join(s.Config.Path, key)),
+	}
+	s.enrichHeadParamsWithSSE(headParams)
+	res, err := s.client.HeadObject(ctx, headParams)
+	if err != nil {
+		return RehydrationNotRequired, fmt.Errorf("failed to head %s object metadata, %v", path.Join(s.Config.Path, key), err)
+	}
+	if res.StorageClass != s3types.StorageClassGlacier && res.StorageClass != s3types.StorageClassDeepArchive && res.ArchiveStatus == "" {
+		return RehydrationNotRequired, nil
+	}
+	if res.Restore == nil {
+		return RehydrationRequired, nil
+	}
+	if strings.Contains(*res.Restore, "ongoing-request=\"true\"") {
+		return RehydrationInProgress, nil
+	}
+	return RehydrationNotRequired, nil
+}
+
+// requestRehydration - INTELLIGENT_TIERING archive tiers don't accept days and tier, DEEP_ARCHIVE doesn't support Expedited tier
+func (s *S3) requestRehydration(ctx context.Context, key, storageClass string) error {
+	restoreRequest := s3.RestoreObjectInput{
+		Bucket:         aws.String(s.Config.Bucket),
+		Key:            aws.String(path.Join(s.Config.Path, key)),
+		RestoreRequest: &s3types.RestoreRequest{},
+	}
+	if s3types.StorageClass(strings.ToUpper(storageClass)) != s3types.StorageClassIntelligentTiering {
+		tier := s3types.Tier(s.Config.RestoreTier)
+		if tier == s3types.TierExpedited && s3types.StorageClass(strings.ToUpper(storageClass)) == s3types.StorageClassDeepArchive {
+			tier = s3types.TierStandard
+		}
+		restoreRequest.RestoreRequest.Days = s.Config.RestoreDays
+		restoreRequest.RestoreRequest.GlacierJobParameters = &s3types.GlacierJobParameters{
+			Tier: tier,
+		}
+	}
+	_, err := s.client.RestoreObject(ctx, &restoreRequest)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
 	}
+	return err
 }
 
 func (s *S3) enrichHeadParamsWithSSE(headParams *s3.HeadObjectInput) {
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// objects which bigger than segment size upload as `key`, `key.segment.1`, `key.segment.2`, ..., each segment except last has exactly segment size,
+// so during download next segment is requested only when previous segment has exactly segment size
+const segmentSuffix = ".segment."
+
+var segmentKeyRE = regexp.MustCompile(`^(.+)\.segment\.\d+$`)
+
+// SegmentBaseKey - return key of first segment, when key is additional segment
+func SegmentBaseKey(key string) (string, bool) {
+	if matches := segmentKeyRE.FindStringSubmatch(key); matches != nil {
+		return matches[1], true
+	}
+	return key, false
+}
+
+func segmentKey(key string, segment int) string {
+	if segment == 0 {
+		return key
+	}
+	return fmt.Sprintf("%s%s%d", key, segmentSuffix, segment)
+}
+
+// SetSegmentSize - size for split big objects, 0 means upload each object as is, download apply segment size from backup metadata
+func (bd *BackupDestination) SetSegmentSize(segmentSize int64) {
+	bd.segmentSize = segmentSize
+}
+
+func (bd *BackupDestination) SegmentSize() int64 {
+	return bd.segmentSize
+}
+
+type segmentSizeKey struct{}
+
+// WithSegmentSize - objects read with returned ctx joined with segmentSize instead of SetSegmentSize value,
+// used for download parts of incremental backup from required backups, which could be uploaded with other segment size
+func WithSegmentSize(ctx context.Context, segmentSize int64) context.Context {
+	return context.WithValue(ctx, segmentSizeKey{}, segmentSize)
+}
+
+// getSegmentSize - SetSegmentSize value when ctx was not created via WithSegmentSize
+func (bd *BackupDestination) getSegmentSize(ctx context.Context) int64 {
+	if segmentSize, ok := ctx.Value(segmentSizeKey{}).(int64); ok {
+		return segmentSize
+	}
+	return bd.segmentSize
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (bd *BackupDestination) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	r = &throttledReader{ReadCloser: r, ctx: ctx, limiter: uploadLimiter}
+	if bd.segmentSize <= 0 {
+		return bd.RemoteStorage.PutFile(ctx, key, r)
+	}
+	bufReader := bufio.NewReader(r)
+	for segment := 0; ; segment++ {
+		if segment > 0 {
+			if _, err := bufReader.Peek(1); err == io.EOF {
+				return bd.deleteStaleSegment(ctx, key, segment)
+			} else if err != nil {
+				return err
+			}
+			bd.Log.Infof("%s is bigger than %d bytes, upload segment %d", key, bd.segmentSize, segment)
+		}
+		segmentReader := &countingReader{r: io.LimitReader(bufReader, bd.segmentSize)}
+		if err := bd.RemoteStorage.PutFile(ctx, segmentKey(key, segment), io.NopCloser(segmentReader)); err != nil {
+			return err
+		}
+		if segmentReader.n < bd.segmentSize {
+			return bd.deleteStaleSegment(ctx, key, segment+1)
+		}
+	}
+}
+
+// deleteStaleSegment - previous upload attempt could produce more segments, download reads segments until first not exists segment
+func (bd *BackupDestination) deleteStaleSegment(ctx context.Context, key string, segment int) error {
+	if segment == 0 {
+		return nil
+	}
+	staleKey := segmentKey(key, segment)
+	if _, err := bd.RemoteStorage.StatFile(ctx, staleKey); err != nil {
+		if err == ErrNotFound || os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return bd.RemoteStorage.DeleteFile(ctx, staleKey)
+}
+
+// StatFile - return total size of all segments
+func (bd *BackupDestination) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	segmentSize := bd.getSegmentSize(ctx)
+	remoteFile, err := bd.RemoteStorage.StatFile(ctx, key)
+	if err != nil || segmentSize <= 0 || remoteFile.Size() != segmentSize {
+		return remoteFile, err
+	}
+	totalSize := remoteFile.Size()
+	for segment := 1; ; segment++ {
+		segmentFile, err := bd.RemoteStorage.StatFile(ctx, segmentKey(key, segment))
+		if err == ErrNotFound || os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		totalSize += segmentFile.Size()
+		if segmentFile.Size() != segmentSize {
+			break
+		}
+	}
+	return &segmentedFile{RemoteFile: remoteFile, size: totalSize}, nil
+}
+
+func (bd *BackupDestination) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := bd.RemoteStorage.GetFileReader(ctx, key)
+	if err != nil {
+		return r, err
+	}
+	if segmentSize := bd.getSegmentSize(ctx); segmentSize > 0 {
+		r = &segmentsReader{ctx: ctx, bd: bd, key: key, segmentSize: segmentSize, current: r}
+	}
+	return &throttledReader{ReadCloser: r, ctx: ctx, limiter: downloadLimiter}, nil
+}
+
+// GetFileReaderWithLocalPath - only first segment could be downloaded to local file, temporary local file removed on Close
+// local file returned as is, already downloaded and DownloadCompressedStream removes it after read
+func (bd *BackupDestination) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error) {
+	r, err := bd.RemoteStorage.GetFileReaderWithLocalPath(ctx, key, localPath)
+	if err != nil {
+		return r, err
+	}
+	if segmentSize := bd.getSegmentSize(ctx); segmentSize > 0 {
+		r = &segmentsReader{ctx: ctx, bd: bd, key: key, segmentSize: segmentSize, current: r, removeLocalFile: true}
+	} else if _, isLocalFile := r.(*os.File); isLocalFile {
+		return r, nil
+	}
+	return &throttledReader{ReadCloser: r, ctx: ctx, limiter: downloadLimiter}, nil
+}
+
+// segmentsReader - read segments one by one, next segment opened only after current segment read exactly segment size bytes
+type segmentsReader struct {
+	ctx         context.Context
+	bd          *BackupDestination
+	key         string
+	segmentSize int64
+	segment     int
+	current     io.ReadCloser
+	read        int64
+	// removeLocalFile - BackupDestination.DownloadCompressedStream can't detect temporary *os.File inside segmentsReader
+	removeLocalFile bool
+}
+
+func (s *segmentsReader) Read(p []byte) (int, error) {
+	n, err := s.current.Read(p)
+	s.read += int64(n)
+	if err != io.EOF || s.read != s.segmentSize {
+		return n, err
+	}
+	nextKey := segmentKey(s.key, s.segment+1)
+	if _, statErr := s.bd.RemoteStorage.StatFile(s.ctx, nextKey); statErr != nil {
+		if statErr == ErrNotFound || os.IsNotExist(statErr) {
+			return n, io.EOF
+		}
+		return n, statErr
+	}
+	if closeErr := s.closeCurrent(); closeErr != nil {
+		return n, closeErr
+	}
+	next, openErr := s.bd.RemoteStorage.GetFileReader(s.ctx, nextKey)
+	if openErr != nil {
+		return n, openErr
+	}
+	s.current = next
+	s.segment += 1
+	s.read = 0
+	if n > 0 {
+		return n, nil
+	}
+	return s.Read(p)
+}
+
+func (s *segmentsReader) closeCurrent() error {
+	err := s.current.Close()
+	if localFile, isLocalFile := s.current.(*os.File); isLocalFile && s.removeLocalFile {
+		if removeErr := os.Remove(localFile.Name()); removeErr != nil {
+			s.bd.Log.Warnf("can't remove %s: %v", localFile.Name(), removeErr)
+		}
+	}
+	return err
+}
+
+func (s *segmentsReader) Close() error {
+	return s.closeCurrent()
+}
+
+type segmentedFile struct {
+	RemoteFile
+	size int64
+}
+
+func (f *segmentedFile) Size() int64 {
+	return f.size
+}
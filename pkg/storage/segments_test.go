@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	apexLog "github.com/apex/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryObject struct {
+	key  string
+	size int64
+}
+
+func (o memoryObject) Size() int64             { return o.size }
+func (o memoryObject) Name() string            { return o.key }
+func (o memoryObject) LastModified() time.Time { return time.Time{} }
+
+func (m *memoryStorage) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if body, exists := m.objects[key]; exists {
+		return memoryObject{key: key, size: int64(len(body))}, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if body, exists := m.objects[key]; exists {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil, ErrNotFound
+}
+
+func TestSegments(t *testing.T) {
+	ctx := context.Background()
+	remote := &memoryStorage{kind: "memory", objects: map[string][]byte{}}
+	bd := &BackupDestination{RemoteStorage: remote, Log: apexLog.WithField("logger", "test")}
+	bd.SetSegmentSize(4)
+	body := []byte("0123456789")
+	require.NoError(t, bd.PutFile(ctx, "backup1/shadow/default_all_1_1_0.tar", io.NopCloser(bytes.NewReader(body))))
+	assert.Equal(t, []byte("0123"), remote.objects["backup1/shadow/default_all_1_1_0.tar"])
+	assert.Equal(t, []byte("4567"), remote.objects["backup1/shadow/default_all_1_1_0.tar.segment.1"])
+	assert.Equal(t, []byte("89"), remote.objects["backup1/shadow/default_all_1_1_0.tar.segment.2"])
+
+	readAll := func(ctx context.Context) []byte {
+		r, err := bd.GetFileReader(ctx, "backup1/shadow/default_all_1_1_0.tar")
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		return data
+	}
+	assert.Equal(t, body, readAll(ctx))
+	remoteFile, err := bd.StatFile(ctx, "backup1/shadow/default_all_1_1_0.tar")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), remoteFile.Size())
+
+	// current backup uploaded with other segment size, required backup read with own segment size
+	bd.SetSegmentSize(8)
+	assert.Equal(t, []byte("0123"), readAll(ctx), "first segment shall be read only with wrong segment size")
+	segmentCtx := WithSegmentSize(ctx, 4)
+	assert.Equal(t, body, readAll(segmentCtx))
+	remoteFile, err = bd.StatFile(segmentCtx, "backup1/shadow/default_all_1_1_0.tar")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), remoteFile.Size())
+	assert.Equal(t, []byte("0123"), readAll(WithSegmentSize(ctx, 0)), "backup uploaded without segments")
+
+	// upload the same key again with less segments
+	bd.SetSegmentSize(4)
+	require.NoError(t, bd.PutFile(ctx, "backup1/shadow/default_all_1_1_0.tar", io.NopCloser(bytes.NewReader(body[:6]))))
+	assert.NotContains(t, remote.objects, "backup1/shadow/default_all_1_1_0.tar.segment.2")
+	assert.Equal(t, body[:6], readAll(ctx))
+}
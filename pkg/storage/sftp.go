@@ -14,15 +14,24 @@ import (
 	"time"
 
 	"github.com/apex/log"
+	"github.com/jolestar/go-commons-pool/v2"
 	libSFTP "github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
+// sftpPartialSuffix - file uploaded with this suffix and renamed after upload complete, so retry can resume upload from size of partial file
+const sftpPartialSuffix = ".partial"
+
 // SFTP Implement RemoteStorage
 type SFTP struct {
+	clients *pool.ObjectPool
+	Config  *config.SFTPConfig
+}
+
+// sftpSession - each pooled session use separate SSH connection, so parallel uploads and downloads don't share one TCP stream
+type sftpSession struct {
 	sshClient  *ssh.Client
 	sftpClient *libSFTP.Client
-	Config     *config.SFTPConfig
 }
 
 func (sftp *SFTP) Debug(msg string, v ...interface{}) {
@@ -64,12 +73,6 @@ func (sftp *SFTP) Connect(ctx context.Context) error {
 		Auth:            authMethods,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
-	addr := fmt.Sprintf("%s:%d", sftp.Config.Address, sftp.Config.Port)
-	sftp.Debug("[SFTP_DEBUG] try connect to tcp://%s", addr)
-	sshConnection, err := ssh.Dial("tcp", addr, sftpConfig)
-	if err != nil {
-		return err
-	}
 	clientOptions := make([]libSFTP.ClientOption, 0)
 	if sftp.Config.Concurrency > 0 {
 		clientOptions = append(
@@ -79,32 +82,73 @@ func (sftp *SFTP) Connect(ctx context.Context) error {
 			libSFTP.MaxConcurrentRequestsPerFile(sftp.Config.Concurrency),
 		)
 	}
-	sftpConnection, err := libSFTP.NewClient(sshConnection, clientOptions...)
+	sftp.clients = pool.NewObjectPoolWithDefaultConfig(ctx, &sftpPoolFactory{sshConfig: sftpConfig, clientOptions: clientOptions, sftp: sftp})
+	if sftp.Config.ClientPoolSize > 0 {
+		sftp.clients.Config.MaxTotal = sftp.Config.ClientPoolSize
+		sftp.clients.Config.MaxIdle = sftp.Config.ClientPoolSize
+	}
+	// session could be broken after disconnect, check it before use
+	sftp.clients.Config.TestOnBorrow = true
+
+	// check credentials and address during connect, like before pooling
+	session, err := sftp.getSessionFromPool(ctx, "Connect")
 	if err != nil {
 		return err
 	}
-
-	sftp.sftpClient = sftpConnection
-	sftp.sshClient = sshConnection
+	sftp.returnSessionToPool(ctx, "Connect", session, nil)
 	return nil
 }
 
 func (sftp *SFTP) Close(ctx context.Context) error {
-	sftp.Debug("[SFTP_DEBUG] sftpClient.Close()")
-	if err := sftp.sftpClient.Close(); err != nil {
-		return err
+	sftp.Debug("[SFTP_DEBUG] close all sessions")
+	sftp.clients.Close(ctx)
+	return nil
+}
+
+func (sftp *SFTP) getSessionFromPool(ctx context.Context, where string) (*sftpSession, error) {
+	sftp.Debug("[SFTP_DEBUG] getSessionFromPool(%s) active=%d idle=%d", where, sftp.clients.GetNumActive(), sftp.clients.GetNumIdle())
+	session, err := sftp.clients.BorrowObject(ctx)
+	if err != nil {
+		log.Errorf("can't BorrowObject from SFTP Session Pool: %v", err)
+		return nil, err
 	}
-	sftp.Debug("[SFTP_DEBUG] sshClient.Close()")
-	if err := sftp.sshClient.Close(); err != nil {
-		return err
+	return session.(*sftpSession), nil
+}
+
+// returnSessionToPool - session which got connection error is destroyed, next borrow will create new SSH connection
+func (sftp *SFTP) returnSessionToPool(ctx context.Context, where string, session *sftpSession, err error) {
+	sftp.Debug("[SFTP_DEBUG] returnSessionToPool(%s) active=%d idle=%d", where, sftp.clients.GetNumActive(), sftp.clients.GetNumIdle())
+	if session == nil {
+		return
 	}
-	return nil
+	if isSFTPConnectionError(err) {
+		if invalidateErr := sftp.clients.InvalidateObject(ctx, session); invalidateErr != nil {
+			log.Warnf("can't InvalidateObject in SFTP Session Pool: %v", invalidateErr)
+		}
+		return
+	}
+	if returnErr := sftp.clients.ReturnObject(ctx, session); returnErr != nil {
+		log.Errorf("can't ReturnObject to SFTP Session Pool: %v", returnErr)
+	}
+}
+
+// isSFTPConnectionError - sftp status errors like "not exist" or "permission denied" don't break session
+func isSFTPConnectionError(err error) bool {
+	if err == nil || os.IsNotExist(err) || os.IsPermission(err) || err == io.EOF {
+		return false
+	}
+	var statusErr *libSFTP.StatusError
+	return !errors.As(err, &statusErr)
 }
 
 func (sftp *SFTP) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 	filePath := path.Join(sftp.Config.Path, key)
-
-	stat, err := sftp.sftpClient.Stat(filePath)
+	session, err := sftp.getSessionFromPool(ctx, "StatFile")
+	if err != nil {
+		return nil, err
+	}
+	stat, err := session.sftpClient.Stat(filePath)
+	sftp.returnSessionToPool(ctx, "StatFile", session, err)
 	if err != nil {
 		sftp.Debug("[SFTP_DEBUG] StatFile::STAT %s return error %v", filePath, err)
 		if strings.Contains(err.Error(), "not exist") {
@@ -123,28 +167,44 @@ func (sftp *SFTP) StatFile(ctx context.Context, key string) (RemoteFile, error)
 func (sftp *SFTP) DeleteFile(ctx context.Context, key string) error {
 	sftp.Debug("[SFTP_DEBUG] Delete %s", key)
 	filePath := path.Join(sftp.Config.Path, key)
-
-	fileStat, err := sftp.sftpClient.Stat(filePath)
+	session, err := sftp.getSessionFromPool(ctx, "DeleteFile")
 	if err != nil {
+		return err
+	}
+	fileStat, err := session.sftpClient.Stat(filePath)
+	if err != nil {
+		sftp.returnSessionToPool(ctx, "DeleteFile", session, err)
 		sftp.Debug("[SFTP_DEBUG] Delete::STAT %s return error %v", filePath, err)
 		return err
 	}
 	if fileStat.IsDir() {
-		return sftp.DeleteDirectory(ctx, filePath)
+		err = sftp.deleteDirectory(ctx, session, filePath)
 	} else {
-		return sftp.sftpClient.Remove(filePath)
+		err = session.sftpClient.Remove(filePath)
 	}
+	sftp.returnSessionToPool(ctx, "DeleteFile", session, err)
+	return err
 }
 
 func (sftp *SFTP) DeleteDirectory(ctx context.Context, dirPath string) error {
+	session, err := sftp.getSessionFromPool(ctx, "DeleteDirectory")
+	if err != nil {
+		return err
+	}
+	err = sftp.deleteDirectory(ctx, session, dirPath)
+	sftp.returnSessionToPool(ctx, "DeleteDirectory", session, err)
+	return err
+}
+
+func (sftp *SFTP) deleteDirectory(ctx context.Context, session *sftpSession, dirPath string) error {
 	sftp.Debug("[SFTP_DEBUG] DeleteDirectory %s", dirPath)
 	defer func() {
-		if err := sftp.sftpClient.RemoveDirectory(dirPath); err != nil {
+		if err := session.sftpClient.RemoveDirectory(dirPath); err != nil {
 			log.Warnf("RemoveDirectory err=%v", err)
 		}
 	}()
 
-	files, err := sftp.sftpClient.ReadDir(dirPath)
+	files, err := session.sftpClient.ReadDir(dirPath)
 	if err != nil {
 		sftp.Debug("[SFTP_DEBUG] DeleteDirectory::ReadDir %s return error %v", dirPath, err)
 		return err
@@ -152,11 +212,11 @@ func (sftp *SFTP) DeleteDirectory(ctx context.Context, dirPath string) error {
 	for _, file := range files {
 		filePath := path.Join(dirPath, file.Name())
 		if file.IsDir() {
-			if err := sftp.DeleteDirectory(ctx, filePath); err != nil {
+			if err := sftp.deleteDirectory(ctx, session, filePath); err != nil {
 				log.Warnf("sftp.DeleteDirectory(%s) err=%v", filePath, err)
 			}
 		} else {
-			if err := sftp.sftpClient.Remove(filePath); err != nil {
+			if err := session.sftpClient.Remove(filePath); err != nil {
 				log.Warnf("sftp.Remove(%s) err=%v", filePath, err)
 			}
 		}
@@ -168,11 +228,16 @@ func (sftp *SFTP) DeleteDirectory(ctx context.Context, dirPath string) error {
 func (sftp *SFTP) Walk(ctx context.Context, remotePath string, recursive bool, process func(context.Context, RemoteFile) error) error {
 	dir := path.Join(sftp.Config.Path, remotePath)
 	sftp.Debug("[SFTP_DEBUG] Walk %s, recursive=%v", dir, recursive)
+	session, err := sftp.getSessionFromPool(ctx, "Walk")
+	if err != nil {
+		return err
+	}
 
 	if recursive {
-		walker := sftp.sftpClient.Walk(dir)
+		walker := session.sftpClient.Walk(dir)
 		for walker.Step() {
 			if err := walker.Err(); err != nil {
+				sftp.returnSessionToPool(ctx, "Walk", session, err)
 				return err
 			}
 			entry := walker.Stat()
@@ -186,11 +251,14 @@ func (sftp *SFTP) Walk(ctx context.Context, remotePath string, recursive bool, p
 				name:         relName,
 			})
 			if err != nil {
+				sftp.returnSessionToPool(ctx, "Walk", session, nil)
 				return err
 			}
 		}
+		sftp.returnSessionToPool(ctx, "Walk", session, nil)
 	} else {
-		entries, err := sftp.sftpClient.ReadDir(dir)
+		entries, err := session.sftpClient.ReadDir(dir)
+		sftp.returnSessionToPool(ctx, "Walk", session, err)
 		if err != nil {
 			sftp.Debug("[SFTP_DEBUG] Walk::NonRecursive::ReadDir %s return error %v", dir, err)
 			return err
@@ -211,33 +279,111 @@ func (sftp *SFTP) Walk(ctx context.Context, remotePath string, recursive bool, p
 
 func (sftp *SFTP) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
 	filePath := path.Join(sftp.Config.Path, key)
-	return sftp.sftpClient.OpenFile(filePath, syscall.O_RDWR)
+	session, err := sftp.getSessionFromPool(ctx, "GetFileReader")
+	if err != nil {
+		return nil, err
+	}
+	remoteFile, err := session.sftpClient.OpenFile(filePath, syscall.O_RDWR)
+	if err != nil {
+		sftp.returnSessionToPool(ctx, "GetFileReader", session, err)
+		return nil, err
+	}
+	return &sftpPooledReader{File: remoteFile, ctx: ctx, sftp: sftp, session: session}, nil
 }
 
 func (sftp *SFTP) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
 	return sftp.GetFileReader(ctx, key)
 }
 
+// PutFile - upload into `key.partial` and rename after upload complete,
+// when `key.partial` exists after previous failed attempt and local reader support Seek, upload continue from size of partial file
 func (sftp *SFTP) PutFile(ctx context.Context, key string, localFile io.ReadCloser) error {
 	filePath := path.Join(sftp.Config.Path, key)
-	if err := sftp.sftpClient.MkdirAll(path.Dir(filePath)); err != nil {
+	partialPath := filePath + sftpPartialSuffix
+	session, err := sftp.getSessionFromPool(ctx, "PutFile")
+	if err != nil {
+		return err
+	}
+	err = sftp.putFile(session, filePath, partialPath, localFile)
+	sftp.returnSessionToPool(ctx, "PutFile", session, err)
+	return err
+}
+
+func (sftp *SFTP) putFile(session *sftpSession, filePath, partialPath string, localFile io.ReadCloser) error {
+	if err := session.sftpClient.MkdirAll(path.Dir(filePath)); err != nil {
 		log.Warnf("sftp.sftpClient.MkdirAll(%s) err=%v", path.Dir(filePath), err)
 	}
-	remoteFile, err := sftp.sftpClient.Create(filePath)
+	remoteFile, err := sftp.openPartialFile(session, partialPath, localFile)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := remoteFile.Close(); err != nil {
-			log.Warnf("can't close %s err=%v", filePath, err)
-		}
-	}()
 	if _, err = remoteFile.ReadFrom(localFile); err != nil {
+		if closeErr := remoteFile.Close(); closeErr != nil {
+			log.Warnf("can't close %s err=%v", partialPath, closeErr)
+		}
 		return err
 	}
+	if err = remoteFile.Close(); err != nil {
+		return err
+	}
+	// posix-rename@openssh.com overwrite existing file, plain SSH_FXP_RENAME fails when destination exists
+	if err = session.sftpClient.PosixRename(partialPath, filePath); err != nil {
+		sftp.Debug("[SFTP_DEBUG] PosixRename %s return error %v, try Rename", partialPath, err)
+		if removeErr := session.sftpClient.Remove(filePath); removeErr != nil && !os.IsNotExist(removeErr) {
+			return removeErr
+		}
+		return session.sftpClient.Rename(partialPath, filePath)
+	}
 	return nil
 }
 
+// openPartialFile - compressed streams can't seek, so partial file from previous attempt is truncated for them
+func (sftp *SFTP) openPartialFile(session *sftpSession, partialPath string, localFile io.Reader) (*libSFTP.File, error) {
+	seeker, isSeeker := localFile.(io.Seeker)
+	// throttledReader implements io.Seeker, but return error when underlying reader is not local file
+	if isSeeker {
+		if _, err := seeker.Seek(0, io.SeekCurrent); err != nil {
+			isSeeker = false
+		}
+	}
+	if !isSeeker {
+		return session.sftpClient.Create(partialPath)
+	}
+	// retry in UploadPath pass the same local file, which already partially read
+	partialStat, err := session.sftpClient.Stat(partialPath)
+	if err != nil || partialStat.Size() == 0 {
+		if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return session.sftpClient.Create(partialPath)
+	}
+	localSize, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return session.sftpClient.Create(partialPath)
+	}
+	if partialStat.Size() > localSize {
+		log.Warnf("%s size %d bigger than local size %d, upload from scratch", partialPath, partialStat.Size(), localSize)
+		if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return session.sftpClient.Create(partialPath)
+	}
+	if _, err = seeker.Seek(partialStat.Size(), io.SeekStart); err != nil {
+		return nil, err
+	}
+	remoteFile, err := session.sftpClient.OpenFile(partialPath, os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		return nil, err
+	}
+	// some servers ignore SSH_FXF_APPEND, so set write offset explicitly
+	if _, err = remoteFile.Seek(partialStat.Size(), io.SeekStart); err != nil {
+		_ = remoteFile.Close()
+		return nil, err
+	}
+	log.Infof("resume upload %s from %d of %d bytes", partialPath, partialStat.Size(), localSize)
+	return remoteFile, nil
+}
+
 func (sftp *SFTP) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey string) (int64, error) {
 	return 0, fmt.Errorf("CopyObject not imlemented for %s", sftp.Kind())
 }
@@ -246,6 +392,88 @@ func (sftp *SFTP) DeleteFileFromObjectDiskBackup(ctx context.Context, key string
 	return fmt.Errorf("DeleteFileFromObjectDiskBackup not imlemented for %s", sftp.Kind())
 }
 
+// IsRetryableError - lost connection is transient, status errors like permission denied repeat on each attempt
+func (sftp *SFTP) IsRetryableError(err error) bool {
+	if errors.Is(err, libSFTP.ErrSSHFxConnectionLost) || errors.Is(err, libSFTP.ErrSSHFxNoConnection) {
+		return true
+	}
+	var statusErr *libSFTP.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.FxCode() == libSFTP.ErrSSHFxConnectionLost || statusErr.FxCode() == libSFTP.ErrSSHFxNoConnection
+	}
+	return isTransientError(err)
+}
+
+// sftpPooledReader - keep session borrowed until remote file closed
+type sftpPooledReader struct {
+	*libSFTP.File
+	ctx     context.Context
+	sftp    *SFTP
+	session *sftpSession
+	readErr error
+}
+
+func (r *sftpPooledReader) Read(p []byte) (int, error) {
+	n, err := r.File.Read(p)
+	if err != nil && err != io.EOF {
+		r.readErr = err
+	}
+	return n, err
+}
+
+func (r *sftpPooledReader) Close() error {
+	err := r.File.Close()
+	if r.readErr == nil {
+		r.readErr = err
+	}
+	r.sftp.returnSessionToPool(r.ctx, "GetFileReader", r.session, r.readErr)
+	return err
+}
+
+type sftpPoolFactory struct {
+	sshConfig     *ssh.ClientConfig
+	clientOptions []libSFTP.ClientOption
+	sftp          *SFTP
+}
+
+func (f *sftpPoolFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
+	addr := fmt.Sprintf("%s:%d", f.sftp.Config.Address, f.sftp.Config.Port)
+	f.sftp.Debug("[SFTP_DEBUG] try connect to tcp://%s", addr)
+	sshConnection, err := ssh.Dial("tcp", addr, f.sshConfig)
+	if err != nil {
+		return nil, err
+	}
+	sftpConnection, err := libSFTP.NewClient(sshConnection, f.clientOptions...)
+	if err != nil {
+		_ = sshConnection.Close()
+		return nil, err
+	}
+	return pool.NewPooledObject(&sftpSession{sshClient: sshConnection, sftpClient: sftpConnection}), nil
+}
+
+func (f *sftpPoolFactory) DestroyObject(ctx context.Context, object *pool.PooledObject) error {
+	session := object.Object.(*sftpSession)
+	f.sftp.Debug("[SFTP_DEBUG] sftpClient.Close()")
+	if err := session.sftpClient.Close(); err != nil {
+		f.sftp.Debug("[SFTP_DEBUG] sftpClient.Close() return error %v", err)
+	}
+	f.sftp.Debug("[SFTP_DEBUG] sshClient.Close()")
+	return session.sshClient.Close()
+}
+
+func (f *sftpPoolFactory) ValidateObject(ctx context.Context, object *pool.PooledObject) bool {
+	_, err := object.Object.(*sftpSession).sftpClient.Getwd()
+	return err == nil
+}
+
+func (f *sftpPoolFactory) ActivateObject(ctx context.Context, object *pool.PooledObject) error {
+	return nil
+}
+
+func (f *sftpPoolFactory) PassivateObject(ctx context.Context, object *pool.PooledObject) error {
+	return nil
+}
+
 // Implement RemoteFile
 type sftpFile struct {
 	size         int64
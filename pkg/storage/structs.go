@@ -10,6 +10,8 @@ import (
 var (
 	// ErrNotFound is returned when file/object cannot be found
 	ErrNotFound = errors.New("key not found")
+	// ErrChecksumMismatch is returned when checksum calculated by remote storage differs from checksum of uploaded or downloaded data
+	ErrChecksumMismatch = errors.New("checksum mismatch")
 )
 
 // RemoteFile - interface describe file on remote storage
@@ -0,0 +1,545 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+)
+
+// Swift - implement RemoteStorage for OpenStack Swift, auth via Keystone v3, objects bigger than segment_size upload as Static or Dynamic Large Object
+type Swift struct {
+	Config *config.SwiftConfig
+	Log    *apexLog.Entry
+
+	client      *http.Client
+	mx          sync.RWMutex
+	token       string
+	storageURL  string
+	deleteAfter int64
+}
+
+// swiftHTTPError - unexpected HTTP status from Keystone or Swift
+type swiftHTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *swiftHTTPError) Error() string {
+	return fmt.Sprintf("swift %s %s return %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// swiftObject - one item of container listing with format=json
+type swiftObject struct {
+	Name         string `json:"name"`
+	Subdir       string `json:"subdir"`
+	Bytes        int64  `json:"bytes"`
+	LastModified string `json:"last_modified"`
+}
+
+// swiftSegment - one item of Static Large Object manifest
+type swiftSegment struct {
+	Path      string `json:"path"`
+	Etag      string `json:"etag"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+const swiftListLimit = 10000
+
+func (s *Swift) Kind() string {
+	return "Swift"
+}
+
+func (s *Swift) Connect(ctx context.Context) error {
+	timeout, err := time.ParseDuration(s.Config.Timeout)
+	if err != nil {
+		return err
+	}
+	if s.Config.DeleteAfter != "" {
+		deleteAfter, err := time.ParseDuration(s.Config.DeleteAfter)
+		if err != nil {
+			return fmt.Errorf("invalid swift->delete_after: %v", err)
+		}
+		s.deleteAfter = int64(deleteAfter.Seconds())
+	}
+	if s.Config.SegmentContainer == "" {
+		s.Config.SegmentContainer = s.Config.Container + "_segments"
+	}
+	transport, err := newHTTPTransport(s.Config.CACert, s.Config.SkipTLSVerify, "")
+	if err != nil {
+		return err
+	}
+	s.client = &http.Client{Timeout: timeout, Transport: transport}
+	if err = s.authenticate(ctx); err != nil {
+		return err
+	}
+	for _, container := range []string{s.Config.Container, s.Config.SegmentContainer} {
+		if err = s.ensureContainer(ctx, container); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Swift) Close(ctx context.Context) error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// authenticate - request token from Keystone v3 with application credential or password, scoped to project, and find object-store endpoint in catalog
+func (s *Swift) authenticate(ctx context.Context) error {
+	identity := map[string]interface{}{}
+	if s.Config.ApplicationCredentialID != "" || s.Config.ApplicationCredentialName != "" {
+		credential := map[string]interface{}{"secret": s.Config.ApplicationCredentialSecret}
+		if s.Config.ApplicationCredentialID != "" {
+			credential["id"] = s.Config.ApplicationCredentialID
+		} else {
+			credential["name"] = s.Config.ApplicationCredentialName
+			credential["user"] = map[string]interface{}{
+				"name":   s.Config.Username,
+				"domain": map[string]string{"name": s.Config.UserDomainName},
+			}
+		}
+		identity["methods"] = []string{"application_credential"}
+		identity["application_credential"] = credential
+	} else {
+		identity["methods"] = []string{"password"}
+		identity["password"] = map[string]interface{}{
+			"user": map[string]interface{}{
+				"name":     s.Config.Username,
+				"password": s.Config.Password,
+				"domain":   map[string]string{"name": s.Config.UserDomainName},
+			},
+		}
+	}
+	auth := map[string]interface{}{"identity": identity}
+	// application credential is already scoped to project, Keystone reject explicit scope
+	if identity["methods"].([]string)[0] == "password" {
+		if s.Config.ProjectID != "" {
+			auth["scope"] = map[string]interface{}{"project": map[string]string{"id": s.Config.ProjectID}}
+		} else if s.Config.ProjectName != "" {
+			auth["scope"] = map[string]interface{}{"project": map[string]interface{}{
+				"name":   s.Config.ProjectName,
+				"domain": map[string]string{"name": s.Config.ProjectDomainName},
+			}}
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"auth": auth})
+	if err != nil {
+		return err
+	}
+	authURL := strings.TrimSuffix(s.Config.AuthURL, "/")
+	if !strings.HasSuffix(authURL, "/v3") {
+		authURL += "/v3"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, authURL+"/auth/tokens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			s.Log.Warnf("can't close keystone response body: %v", closeErr)
+		}
+	}()
+	if resp.StatusCode != http.StatusCreated {
+		return s.httpError(req, resp)
+	}
+	var tokenResp struct {
+		Token struct {
+			Catalog []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					RegionID  string `json:"region_id"`
+					URL       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return fmt.Errorf("can't parse keystone token response: %v", err)
+	}
+	storageURL := s.Config.StorageURL
+	if storageURL == "" {
+		for _, service := range tokenResp.Token.Catalog {
+			if service.Type != "object-store" {
+				continue
+			}
+			for _, endpoint := range service.Endpoints {
+				if endpoint.Interface == s.Config.EndpointType && (s.Config.Region == "" || endpoint.Region == s.Config.Region || endpoint.RegionID == s.Config.Region) {
+					storageURL = endpoint.URL
+					break
+				}
+			}
+		}
+	}
+	if storageURL == "" {
+		return fmt.Errorf("object-store endpoint with interface=%s region=%s not found in keystone catalog, define swift->storage_url", s.Config.EndpointType, s.Config.Region)
+	}
+	s.mx.Lock()
+	s.token = resp.Header.Get("X-Subject-Token")
+	s.storageURL = strings.TrimSuffix(storageURL, "/")
+	s.mx.Unlock()
+	return nil
+}
+
+func (s *Swift) objectURL(container, name string, query url.Values) string {
+	s.mx.RLock()
+	u := s.storageURL + "/" + url.PathEscape(container)
+	s.mx.RUnlock()
+	if name != "" {
+		u += "/" + swiftEscapePath(name)
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// swiftEscapePath - escape each path segment, keep `/` as is
+func swiftEscapePath(name string) string {
+	escaped := strings.Split(name, "/")
+	for i := range escaped {
+		escaped[i] = url.PathEscape(escaped[i])
+	}
+	return strings.Join(escaped, "/")
+}
+
+// do - execute request with current token, when token expired, authenticate again and repeat request once, body could be repeated only when getBody defined
+func (s *Swift) do(ctx context.Context, method, container, name string, query url.Values, headers http.Header, getBody func() io.Reader) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if getBody != nil {
+			body = getBody()
+		}
+		req, err := http.NewRequestWithContext(ctx, method, s.objectURL(container, name, query), body)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			req.Header[k] = v
+		}
+		s.mx.RLock()
+		req.Header.Set("X-Auth-Token", s.token)
+		s.mx.RUnlock()
+		if s.Config.Debug {
+			s.Log.Infof("[SWIFT_DEBUG] %s %s", method, req.URL.String())
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			s.closeBody(resp)
+			if err = s.authenticate(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return resp, nil
+	}
+}
+
+func (s *Swift) closeBody(resp *http.Response) {
+	// drain body to reuse connection
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if err := resp.Body.Close(); err != nil {
+		s.Log.Warnf("can't close swift response body: %v", err)
+	}
+}
+
+func (s *Swift) httpError(req *http.Request, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &swiftHTTPError{Method: req.Method, URL: req.URL.Redacted(), StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+}
+
+// request - execute request and check response status, response body is closed
+func (s *Swift) request(ctx context.Context, method, container, name string, query url.Values, headers http.Header, getBody func() io.Reader, expectedStatus ...int) (http.Header, error) {
+	resp, err := s.do(ctx, method, container, name, query, headers, getBody)
+	if err != nil {
+		return nil, err
+	}
+	defer s.closeBody(resp)
+	for _, status := range expectedStatus {
+		if resp.StatusCode == status {
+			return resp.Header, nil
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	return nil, s.httpError(resp.Request, resp)
+}
+
+// ensureContainer - check container exists, create it when swift->create_container: true, container could be created by operator with quota and lifecycle settings
+func (s *Swift) ensureContainer(ctx context.Context, container string) error {
+	_, err := s.request(ctx, http.MethodHead, container, "", nil, nil, nil, http.StatusNoContent, http.StatusOK)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if !s.Config.CreateContainer {
+		return fmt.Errorf("swift container %s doesn't exist, create it or set swift->create_container: true", container)
+	}
+	headers := http.Header{}
+	if s.Config.StoragePolicy != "" {
+		headers.Set("X-Storage-Policy", s.Config.StoragePolicy)
+	}
+	_, err = s.request(ctx, http.MethodPut, container, "", nil, headers, nil, http.StatusCreated, http.StatusAccepted, http.StatusNoContent)
+	return err
+}
+
+func (s *Swift) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	headers, err := s.request(ctx, http.MethodHead, s.Config.Container, path.Join(s.Config.Path, key), nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	size, _ := strconv.ParseInt(headers.Get("Content-Length"), 10, 64)
+	lastModified, _ := parseTime(headers.Get("Last-Modified"))
+	return &swiftFile{size: size, lastModified: lastModified, name: key}, nil
+}
+
+func (s *Swift) DeleteFile(ctx context.Context, key string) error {
+	return s.deleteObject(ctx, path.Join(s.Config.Path, key))
+}
+
+func (s *Swift) DeleteFileFromObjectDiskBackup(ctx context.Context, key string) error {
+	return fmt.Errorf("DeleteFileFromObjectDiskBackup not imlemented for %s", s.Kind())
+}
+
+// deleteObject - delete object with segments, SLO segments deleted by Swift via multipart-manifest=delete, DLO segments deleted by prefix
+func (s *Swift) deleteObject(ctx context.Context, name string) error {
+	headers, err := s.request(ctx, http.MethodHead, s.Config.Container, name, url.Values{"multipart-manifest": {"get"}}, nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	if strings.EqualFold(headers.Get("X-Static-Large-Object"), "true") {
+		query.Set("multipart-manifest", "delete")
+	}
+	if manifest := headers.Get("X-Object-Manifest"); manifest != "" {
+		container, prefix, _ := strings.Cut(manifest, "/")
+		container, _ = url.PathUnescape(container)
+		prefix, _ = url.PathUnescape(prefix)
+		if err = s.list(ctx, container, prefix, "", func(object swiftObject) error {
+			_, deleteErr := s.request(ctx, http.MethodDelete, container, object.Name, nil, nil, nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+			return deleteErr
+		}); err != nil {
+			return err
+		}
+	}
+	_, err = s.request(ctx, http.MethodDelete, s.Config.Container, name, query, nil, nil, http.StatusNoContent, http.StatusOK)
+	return err
+}
+
+// list - iterate over container listing pages with marker
+func (s *Swift) list(ctx context.Context, container, prefix, delimiter string, process func(swiftObject) error) error {
+	marker := ""
+	for {
+		query := url.Values{
+			"format": {"json"},
+			"prefix": {prefix},
+			"limit":  {strconv.Itoa(swiftListLimit)},
+		}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := s.do(ctx, http.MethodGet, container, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound {
+			s.closeBody(resp)
+			return nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = s.httpError(resp.Request, resp)
+			s.closeBody(resp)
+			return err
+		}
+		var objects []swiftObject
+		err = json.NewDecoder(resp.Body).Decode(&objects)
+		s.closeBody(resp)
+		if err != nil {
+			return fmt.Errorf("can't parse swift container %s listing: %v", container, err)
+		}
+		for _, object := range objects {
+			if err = process(object); err != nil {
+				return err
+			}
+			if object.Subdir != "" {
+				marker = object.Subdir
+			} else {
+				marker = object.Name
+			}
+		}
+		if len(objects) < swiftListLimit {
+			return nil
+		}
+	}
+}
+
+func (s *Swift) Walk(ctx context.Context, swiftPath string, recursive bool, process func(context.Context, RemoteFile) error) error {
+	prefix := path.Join(s.Config.Path, swiftPath)
+	if prefix != "" && prefix != "/" {
+		prefix += "/"
+	}
+	prefix = strings.TrimPrefix(prefix, "/")
+	delimiter := ""
+	if !recursive {
+		delimiter = "/"
+	}
+	return s.list(ctx, s.Config.Container, prefix, delimiter, func(object swiftObject) error {
+		if object.Subdir != "" {
+			return process(ctx, &swiftFile{name: strings.TrimPrefix(object.Subdir, prefix)})
+		}
+		lastModified, _ := time.Parse("2006-01-02T15:04:05.999999", object.LastModified)
+		return process(ctx, &swiftFile{
+			name:         strings.TrimPrefix(object.Name, prefix),
+			size:         object.Bytes,
+			lastModified: lastModified,
+		})
+	})
+}
+
+func (s *Swift) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.Config.Container, path.Join(s.Config.Path, key), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer s.closeBody(resp)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, s.httpError(resp.Request, resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *Swift) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return s.GetFileReader(ctx, key)
+}
+
+func (s *Swift) objectHeaders() http.Header {
+	headers := http.Header{}
+	if s.deleteAfter > 0 {
+		headers.Set("X-Delete-After", strconv.FormatInt(s.deleteAfter, 10))
+	}
+	return headers
+}
+
+// PutFile - read source by segment_size chunks, when source fits into one chunk, upload it as single object, otherwise upload segments into segment_container and create manifest
+func (s *Swift) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	name := path.Join(s.Config.Path, key)
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, r, s.Config.SegmentSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if n < s.Config.SegmentSize {
+		_, err = s.putSegment(ctx, s.Config.Container, name, buf.Bytes())
+		return err
+	}
+	// timestamp in segment prefix, so segments of overwritten object don't mix with new segments
+	segmentPrefix := fmt.Sprintf("%s/%d/", name, time.Now().UnixNano())
+	segments := make([]swiftSegment, 0)
+	for n > 0 {
+		segmentName := fmt.Sprintf("%s%08d", segmentPrefix, len(segments)+1)
+		etag, err := s.putSegment(ctx, s.Config.SegmentContainer, segmentName, buf.Bytes())
+		if err != nil {
+			return err
+		}
+		segments = append(segments, swiftSegment{Path: "/" + s.Config.SegmentContainer + "/" + segmentName, Etag: etag, SizeBytes: n})
+		buf.Reset()
+		n, err = io.CopyN(buf, r, s.Config.SegmentSize)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+	headers := s.objectHeaders()
+	if s.Config.LargeObjectType == "dlo" {
+		headers.Set("X-Object-Manifest", url.PathEscape(s.Config.SegmentContainer)+"/"+swiftEscapePath(segmentPrefix))
+		_, err = s.request(ctx, http.MethodPut, s.Config.Container, name, nil, headers, func() io.Reader { return bytes.NewReader(nil) }, http.StatusCreated)
+		return err
+	}
+	manifest, err := json.Marshal(segments)
+	if err != nil {
+		return err
+	}
+	headers.Set("Content-Type", "application/json")
+	_, err = s.request(ctx, http.MethodPut, s.Config.Container, name, url.Values{"multipart-manifest": {"put"}}, headers, func() io.Reader { return bytes.NewReader(manifest) }, http.StatusCreated)
+	return err
+}
+
+// putSegment - upload one object from memory with ETag, so Swift verify data integrity, return ETag for SLO manifest
+func (s *Swift) putSegment(ctx context.Context, container, name string, data []byte) (string, error) {
+	hash := md5.Sum(data)
+	etag := hex.EncodeToString(hash[:])
+	headers := s.objectHeaders()
+	headers.Set("ETag", etag)
+	_, err := s.request(ctx, http.MethodPut, container, name, nil, headers, func() io.Reader { return bytes.NewReader(data) }, http.StatusCreated)
+	return etag, err
+}
+
+func (s *Swift) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey string) (int64, error) {
+	return 0, fmt.Errorf("CopyObject not imlemented for %s", s.Kind())
+}
+
+// IsRetryableError - authentication, permission and quota (413, 507) errors repeat on each attempt
+func (s *Swift) IsRetryableError(err error) bool {
+	var httpErr *swiftHTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return isTransientError(err)
+}
+
+type swiftFile struct {
+	size         int64
+	lastModified time.Time
+	name         string
+}
+
+func (f *swiftFile) Size() int64 {
+	return f.size
+}
+
+func (f *swiftFile) Name() string {
+	return f.name
+}
+
+func (f *swiftFile) LastModified() time.Time {
+	return f.lastModified
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSwift - minimal Keystone v3 and Swift API, enough for Swift RemoteStorage
+type fakeSwift struct {
+	mx          sync.Mutex
+	url         string
+	tokens      int
+	authBody    map[string]interface{}
+	containers  map[string]map[string][]byte
+	headers     map[string]http.Header
+	deleteQuery []string
+}
+
+func newFakeSwift() (*fakeSwift, *httptest.Server) {
+	f := &fakeSwift{containers: map[string]map[string][]byte{}, headers: map[string]http.Header{}}
+	srv := httptest.NewServer(f)
+	f.url = srv.URL
+	return f, srv
+}
+
+func (f *fakeSwift) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if r.URL.Path == "/v3/auth/tokens" {
+		f.authBody = map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&f.authBody)
+		f.tokens++
+		w.Header().Set("X-Subject-Token", fmt.Sprintf("token%d", f.tokens))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"token":{"catalog":[{"type":"object-store","endpoints":[{"interface":"internal","region":"r1","url":"http://internal"},{"interface":"public","region":"r1","url":"%s/v1/AUTH_p"}]}]}}`, f.url)
+		return
+	}
+	if r.Header.Get("X-Auth-Token") != fmt.Sprintf("token%d", f.tokens) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	container, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/AUTH_p/"), "/")
+	objects, containerExists := f.containers[container]
+	switch {
+	case name == "" && r.Method == http.MethodPut:
+		f.containers[container] = map[string][]byte{}
+		w.WriteHeader(http.StatusCreated)
+	case !containerExists:
+		w.WriteHeader(http.StatusNotFound)
+	case name == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusNoContent)
+	case name == "" && r.Method == http.MethodGet:
+		prefix, delimiter := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+		list := make([]swiftObject, 0)
+		seen := map[string]bool{}
+		for key, data := range objects {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if delimiter != "" {
+				if idx := strings.Index(key[len(prefix):], delimiter); idx >= 0 {
+					subdir := key[:len(prefix)+idx+1]
+					if !seen[subdir] {
+						seen[subdir] = true
+						list = append(list, swiftObject{Subdir: subdir})
+					}
+					continue
+				}
+			}
+			list = append(list, swiftObject{Name: key, Bytes: int64(len(data)), LastModified: "2023-01-02T03:04:05.123456"})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name+list[i].Subdir < list[j].Name+list[j].Subdir })
+		_ = json.NewEncoder(w).Encode(list)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		objects[name] = data
+		f.headers[container+"/"+name] = r.Header.Clone()
+		if r.URL.Query().Get("multipart-manifest") == "put" {
+			f.headers[container+"/"+name].Set("X-Static-Large-Object", "true")
+		}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		data, exists := objects[name]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for _, h := range []string{"X-Static-Large-Object", "X-Object-Manifest"} {
+			if v := f.headers[container+"/"+name].Get(h); v != "" {
+				w.Header().Set(h, v)
+			}
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2023 03:04:05 GMT")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		f.deleteQuery = append(f.deleteQuery, container+"/"+name+"?"+r.URL.RawQuery)
+		delete(objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newTestSwift(t *testing.T, f *fakeSwift) *Swift {
+	cfg := config.DefaultConfig()
+	cfg.Swift.AuthURL = f.url
+	cfg.Swift.Container = "backups"
+	cfg.Swift.CreateContainer = true
+	cfg.Swift.SegmentSize = 4
+	cfg.Swift.Path = "shard1"
+	cfg.Swift.ApplicationCredentialID = "app-id"
+	cfg.Swift.ApplicationCredentialSecret = "app-secret"
+	s := &Swift{Config: &cfg.Swift, Log: apexLog.WithField("logger", "SWIFT")}
+	require.NoError(t, s.Connect(context.Background()))
+	return s
+}
+
+func TestSwiftConnectWithApplicationCredential(t *testing.T) {
+	f, srv := newFakeSwift()
+	defer srv.Close()
+	newTestSwift(t, f)
+	identity := f.authBody["auth"].(map[string]interface{})["identity"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"application_credential"}, identity["methods"])
+	assert.Equal(t, "app-id", identity["application_credential"].(map[string]interface{})["id"])
+	assert.NotContains(t, f.authBody["auth"], "scope")
+	assert.Contains(t, f.containers, "backups")
+	assert.Contains(t, f.containers, "backups_segments")
+}
+
+func TestSwiftPutFileAsStaticLargeObject(t *testing.T) {
+	f, srv := newFakeSwift()
+	defer srv.Close()
+	s := newTestSwift(t, f)
+	ctx := context.Background()
+
+	require.NoError(t, s.PutFile(ctx, "backup1/small", io.NopCloser(strings.NewReader("abc"))))
+	assert.Equal(t, []byte("abc"), f.containers["backups"]["shard1/backup1/small"])
+
+	require.NoError(t, s.PutFile(ctx, "backup1/big", io.NopCloser(strings.NewReader("0123456789"))))
+	var manifest []swiftSegment
+	require.NoError(t, json.Unmarshal(f.containers["backups"]["shard1/backup1/big"], &manifest))
+	require.Len(t, manifest, 3)
+	assert.Equal(t, []int64{4, 4, 2}, []int64{manifest[0].SizeBytes, manifest[1].SizeBytes, manifest[2].SizeBytes})
+	assert.True(t, strings.HasPrefix(manifest[0].Path, "/backups_segments/shard1/backup1/big/"))
+	assert.Len(t, f.containers["backups_segments"], 3)
+
+	names := make([]string, 0)
+	require.NoError(t, s.Walk(ctx, "/", false, func(ctx context.Context, file RemoteFile) error {
+		names = append(names, file.Name())
+		return nil
+	}))
+	assert.Equal(t, []string{"backup1/"}, names)
+
+	require.NoError(t, s.DeleteFile(ctx, "backup1/big"))
+	assert.Contains(t, f.deleteQuery, "backups/shard1/backup1/big?multipart-manifest=delete")
+}
+
+func TestSwiftDynamicLargeObjectDeleteSegments(t *testing.T) {
+	f, srv := newFakeSwift()
+	defer srv.Close()
+	s := newTestSwift(t, f)
+	s.Config.LargeObjectType = "dlo"
+	ctx := context.Background()
+
+	require.NoError(t, s.PutFile(ctx, "backup1/big", io.NopCloser(strings.NewReader("0123456789"))))
+	manifestHeader := f.headers["backups/shard1/backup1/big"].Get("X-Object-Manifest")
+	assert.True(t, strings.HasPrefix(manifestHeader, "backups_segments/shard1/backup1/big/"))
+	assert.Len(t, f.containers["backups_segments"], 3)
+
+	require.NoError(t, s.DeleteFile(ctx, "backup1/big"))
+	assert.Empty(t, f.containers["backups_segments"])
+	assert.Empty(t, f.containers["backups"])
+}
+
+func TestSwiftReauthenticateOnExpiredToken(t *testing.T) {
+	f, srv := newFakeSwift()
+	defer srv.Close()
+	s := newTestSwift(t, f)
+	ctx := context.Background()
+	require.NoError(t, s.PutFile(ctx, "key", io.NopCloser(strings.NewReader("abc"))))
+	// token revoked by keystone
+	f.tokens++
+	file, err := s.StatFile(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), file.Size())
+	_, err = s.StatFile(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestSwiftIsRetryableError(t *testing.T) {
+	s := &Swift{}
+	assert.True(t, s.IsRetryableError(&swiftHTTPError{StatusCode: http.StatusServiceUnavailable}))
+	assert.False(t, s.IsRetryableError(&swiftHTTPError{StatusCode: http.StatusForbidden}))
+	assert.False(t, s.IsRetryableError(&swiftHTTPError{StatusCode: http.StatusInsufficientStorage}))
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+)
+
+type storageClassKey struct{}
+
+// WithStorageClass - objects uploaded with returned ctx use storageClass instead of storage_class from config, used for general->table_storage_rules
+func WithStorageClass(ctx context.Context, storageClass string) context.Context {
+	if storageClass == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, storageClassKey{}, storageClass)
+}
+
+// getStorageClass - defaultStorageClass when ctx was not created via WithStorageClass
+func getStorageClass(ctx context.Context, defaultStorageClass string) string {
+	if storageClass, ok := ctx.Value(storageClassKey{}).(string); ok {
+		return storageClass
+	}
+	return defaultStorageClass
+}
+
+// newTablePathPrefixes - first level directories of general->table_storage_rules path_prefix, they are placed near backups and shall not be listed as backups
+func newTablePathPrefixes(cfg *config.Config) map[string]struct{} {
+	prefixes := make(map[string]struct{})
+	for _, rule := range cfg.General.TableStorageRules {
+		if rule.PathPrefix != "" {
+			prefixes[strings.SplitN(strings.Trim(rule.PathPrefix, "/"), "/", 2)[0]] = struct{}{}
+		}
+	}
+	return prefixes
+}
+
+// removeTablePathPrefixes - path_prefix could be removed from config or backup could be copied from other remote storage,
+// so first level directory of path_prefix from any listed metadata.json is not a broken backup
+func removeTablePathPrefixes(backups []Backup) []Backup {
+	prefixes := make(map[string]struct{})
+	for _, backup := range backups {
+		for _, pathPrefix := range backup.RemotePathPrefixes {
+			prefixes[strings.SplitN(pathPrefix, "/", 2)[0]] = struct{}{}
+		}
+	}
+	if len(prefixes) == 0 {
+		return backups
+	}
+	result := make([]Backup, 0, len(backups))
+	for _, backup := range backups {
+		if _, isPrefix := prefixes[backup.BackupName]; isPrefix && backup.Broken != "" {
+			continue
+		}
+		result = append(result, backup)
+	}
+	return result
+}
+
+// IsTablePathPrefix - name is first level directory of one of general->table_storage_rules path_prefix
+func (bd *BackupDestination) IsTablePathPrefix(name string) bool {
+	_, exists := bd.tablePathPrefixes[strings.Trim(name, "/")]
+	return exists
+}
+
+// GetRemoteBackupDataPaths - backup name and `<path_prefix>/<backup_name>` for each path_prefix used during upload
+func GetRemoteBackupDataPaths(backup metadata.BackupMetadata) []string {
+	dataPaths := []string{backup.BackupName}
+	for _, pathPrefix := range backup.RemotePathPrefixes {
+		dataPaths = append(dataPaths, path.Join(pathPrefix, backup.BackupName))
+	}
+	return dataPaths
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTablePathPrefixes(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.TableStorageRules = []config.TableStorageRule{
+		{Tables: "db.history_*", StorageClass: "GLACIER", PathPrefix: "archive/history"},
+		{Tables: "db.hot", StorageClass: "STANDARD"},
+	}
+	bd := &BackupDestination{tablePathPrefixes: newTablePathPrefixes(cfg)}
+	assert.True(t, bd.IsTablePathPrefix("archive/"))
+	assert.False(t, bd.IsTablePathPrefix("backup1"))
+
+	backup := metadata.BackupMetadata{BackupName: "backup1", RemotePathPrefixes: []string{"archive/history", "cold"}}
+	assert.Equal(t, []string{"backup1", "archive/history/backup1", "cold/backup1"}, GetRemoteBackupDataPaths(backup))
+
+	backups := removeTablePathPrefixes([]Backup{
+		{BackupMetadata: backup},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "archive"}, Broken: "broken (can't stat metadata.json)"},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "cold"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "broken1"}, Broken: "broken (can't stat metadata.json)"},
+	})
+	names := make([]string, 0)
+	for _, b := range backups {
+		names = append(names, b.BackupName)
+	}
+	assert.Equal(t, []string{"backup1", "cold", "broken1"}, names)
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// teeBufferSize - how many bytes are read from source before write into each destination
+const teeBufferSize = 256 * 1024
+
+// TeeStorage - RemoteStorage which writes each object into primary and all mirrors, BackupDestination compress data once and fan out compressed stream,
+// StatFile, Walk, GetFileReader and CopyObject use only primary, mirror which returned error is excluded from next writes, see MirrorError
+type TeeStorage struct {
+	RemoteStorage
+	mirrors      []RemoteStorage
+	mirrorErrors []error
+	mx           sync.RWMutex
+}
+
+// NewTeeStorage - primary and mirrors shall be already connected, TeeStorage doesn't close mirrors
+func NewTeeStorage(primary RemoteStorage, mirrors ...RemoteStorage) *TeeStorage {
+	return &TeeStorage{
+		RemoteStorage: primary,
+		mirrors:       mirrors,
+		mirrorErrors:  make([]error, len(mirrors)),
+	}
+}
+
+// Primary - RemoteStorage which was wrapped by NewTeeStorage
+func (t *TeeStorage) Primary() RemoteStorage {
+	return t.RemoteStorage
+}
+
+// MirrorError - first error returned by mirror with index i, nil when all writes into mirror succeeded
+func (t *TeeStorage) MirrorError(i int) error {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	return t.mirrorErrors[i]
+}
+
+func (t *TeeStorage) setMirrorError(i int, err error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.mirrorErrors[i] == nil {
+		t.mirrorErrors[i] = err
+	}
+}
+
+// activeMirrors - indexes of mirrors without error
+func (t *TeeStorage) activeMirrors() []int {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	active := make([]int, 0, len(t.mirrors))
+	for i := range t.mirrors {
+		if t.mirrorErrors[i] == nil {
+			active = append(active, i)
+		}
+	}
+	return active
+}
+
+// PutFile - read r once and write the same bytes into primary and each active mirror at the same time, the slowest destination defines speed,
+// when primary or r fails, error is returned and mirrors are not marked as failed, caller retry will overwrite partially written objects
+func (t *TeeStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	active := t.activeMirrors()
+	if len(active) == 0 {
+		return t.RemoteStorage.PutFile(ctx, key, r)
+	}
+	targets := []RemoteStorage{t.RemoteStorage}
+	for _, i := range active {
+		targets = append(targets, t.mirrors[i])
+	}
+	writers := make([]*io.PipeWriter, len(targets))
+	putErrors := make([]error, len(targets))
+	writeFailed := make([]bool, len(targets))
+	wg := sync.WaitGroup{}
+	for i := range targets {
+		pipeReader, pipeWriter := io.Pipe()
+		writers[i] = pipeWriter
+		wg.Add(1)
+		go func(i int, pipeReader *io.PipeReader) {
+			defer wg.Done()
+			putErrors[i] = targets[i].PutFile(ctx, key, pipeReader)
+			// unblock writer when destination stop to read
+			if putErrors[i] != nil {
+				_ = pipeReader.CloseWithError(putErrors[i])
+			} else {
+				_ = pipeReader.Close()
+			}
+		}(i, pipeReader)
+	}
+	var readErr error
+	buf := make([]byte, teeBufferSize)
+	for readErr == nil {
+		n, err := r.Read(buf)
+		if n > 0 {
+			for i, w := range writers {
+				if writeFailed[i] {
+					continue
+				}
+				if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+					writeFailed[i] = true
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+		} else if writeFailed[0] {
+			readErr = fmt.Errorf("%s stop to read %s", t.RemoteStorage.Kind(), key)
+		}
+	}
+	for _, w := range writers {
+		if readErr != nil {
+			_ = w.CloseWithError(readErr)
+		} else {
+			_ = w.Close()
+		}
+	}
+	wg.Wait()
+	if putErrors[0] != nil {
+		return putErrors[0]
+	}
+	if readErr != nil {
+		return readErr
+	}
+	for j, i := range active {
+		if err := putErrors[j+1]; err != nil {
+			t.setMirrorError(i, fmt.Errorf("can't put %s: %v", key, err))
+		} else if writeFailed[j+1] {
+			t.setMirrorError(i, fmt.Errorf("can't put %s: %s stop to read", key, t.mirrors[i].Kind()))
+		}
+	}
+	return nil
+}
+
+// DeleteFile - delete from primary and active mirrors, used during cleanup of canceled upload
+func (t *TeeStorage) DeleteFile(ctx context.Context, key string) error {
+	if err := t.RemoteStorage.DeleteFile(ctx, key); err != nil {
+		return err
+	}
+	for _, i := range t.activeMirrors() {
+		if err := t.mirrors[i].DeleteFile(ctx, key); err != nil {
+			t.setMirrorError(i, fmt.Errorf("can't delete %s: %v", key, err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStorage struct {
+	RemoteStorage
+	kind    string
+	putErr  error
+	objects map[string][]byte
+	mx      sync.Mutex
+}
+
+func (m *memoryStorage) Kind() string {
+	return m.kind
+}
+
+func (m *memoryStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	if m.putErr != nil {
+		return m.putErr
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.objects[key] = body
+	return nil
+}
+
+func (m *memoryStorage) DeleteFile(ctx context.Context, key string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func TestTeeStorage(t *testing.T) {
+	ctx := context.Background()
+	primary := &memoryStorage{kind: "primary", objects: map[string][]byte{}}
+	mirror := &memoryStorage{kind: "mirror", objects: map[string][]byte{}}
+	broken := &memoryStorage{kind: "broken", objects: map[string][]byte{}, putErr: errors.New("access denied")}
+	tee := NewTeeStorage(primary, mirror, broken)
+
+	body := bytes.Repeat([]byte("0123456789"), teeBufferSize/4)
+	require.NoError(t, tee.PutFile(ctx, "backup/part.tar", io.NopCloser(bytes.NewReader(body))))
+	assert.Equal(t, body, primary.objects["backup/part.tar"])
+	assert.Equal(t, body, mirror.objects["backup/part.tar"])
+	assert.NoError(t, tee.MirrorError(0))
+	assert.ErrorContains(t, tee.MirrorError(1), "access denied")
+
+	broken.putErr = nil
+	require.NoError(t, tee.PutFile(ctx, "backup/metadata.json", io.NopCloser(strings.NewReader("{}"))))
+	assert.Empty(t, broken.objects, "failed mirror shall be excluded from next writes")
+	require.NoError(t, tee.DeleteFile(ctx, "backup/part.tar"))
+	assert.NotContains(t, primary.objects, "backup/part.tar")
+	assert.NotContains(t, mirror.objects, "backup/part.tar")
+
+	primary.putErr = errors.New("connection reset")
+	assert.ErrorContains(t, tee.PutFile(ctx, "backup/other.tar", io.NopCloser(bytes.NewReader(body))), "connection reset")
+	assert.NoError(t, tee.MirrorError(0), "mirror shall not be failed when primary failed")
+	assert.Equal(t, primary, tee.Primary())
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthLimiter - token bucket shared by all remote storage readers or writers in one direction,
+// bucket capacity is one second of traffic, rate could be changed at runtime via API
+type bandwidthLimiter struct {
+	mx         sync.Mutex
+	configured int64
+	override   int64 // negative means use configured
+	tokens     float64
+	last       time.Time
+}
+
+var (
+	uploadLimiter   = &bandwidthLimiter{override: -1}
+	downloadLimiter = &bandwidthLimiter{override: -1}
+)
+
+// BandwidthLimits - effective and configured limits in bytes per second, 0 means unlimited
+type BandwidthLimits struct {
+	Upload             int64 `json:"upload_max_bytes_per_second"`
+	Download           int64 `json:"download_max_bytes_per_second"`
+	ConfiguredUpload   int64 `json:"configured_upload_max_bytes_per_second"`
+	ConfiguredDownload int64 `json:"configured_download_max_bytes_per_second"`
+}
+
+// SetBandwidthLimits - apply general->upload_max_bytes_per_second and general->download_max_bytes_per_second, limits changed via API have priority
+func SetBandwidthLimits(upload, download int64) {
+	uploadLimiter.setConfigured(upload)
+	downloadLimiter.setConfigured(download)
+}
+
+// OverrideBandwidthLimits - change limits at runtime for all running and next operations, negative value returns configured limit
+func OverrideBandwidthLimits(upload, download int64) {
+	uploadLimiter.setOverride(upload)
+	downloadLimiter.setOverride(download)
+}
+
+func GetBandwidthLimits() BandwidthLimits {
+	limits := BandwidthLimits{}
+	limits.Upload, limits.ConfiguredUpload = uploadLimiter.getRates()
+	limits.Download, limits.ConfiguredDownload = downloadLimiter.getRates()
+	return limits
+}
+
+func (l *bandwidthLimiter) setConfigured(rate int64) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.configured = rate
+}
+
+func (l *bandwidthLimiter) setOverride(rate int64) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.override = rate
+}
+
+func (l *bandwidthLimiter) getRates() (int64, int64) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.rate(), l.configured
+}
+
+func (l *bandwidthLimiter) rate() int64 {
+	if l.override >= 0 {
+		return l.override
+	}
+	return l.configured
+}
+
+// wait - take n bytes from bucket, bucket could go into debt, so caller waits until debt is paid and next callers wait in order
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mx.Lock()
+	rate := float64(l.rate())
+	if rate <= 0 || n <= 0 {
+		l.mx.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if l.last.IsZero() {
+		l.tokens = rate
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+	}
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(0)
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+	l.mx.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *bandwidthLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Seek - allow backends resume partially uploaded files, when underlying reader is local file
+func (r *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, isSeeker := r.ReadCloser.(io.Seeker)
+	if !isSeeker {
+		return 0, fmt.Errorf("%T doesn't support Seek", r.ReadCloser)
+	}
+	return seeker.Seek(offset, whence)
+}
@@ -2,65 +2,14 @@ package storage
 
 import (
 	"fmt"
-	"github.com/apex/log"
+	"io"
+	"strings"
+
 	"github.com/klauspost/compress/zstd"
 	"github.com/mholt/archiver/v4"
-	"sort"
-	"strings"
-	"time"
 )
 
-func GetBackupsToDelete(backups []Backup, keep int) []Backup {
-	if len(backups) > keep {
-		// sort backup ascending
-		sort.SliceStable(backups, func(i, j int) bool {
-			return backups[i].UploadDate.After(backups[j].UploadDate)
-		})
-		// KeepRemoteBackups should respect incremental backups sequences and don't deleteKey required backups
-		// fix https://github.com/Altinity/clickhouse-backup/issues/111
-		// fix https://github.com/Altinity/clickhouse-backup/issues/385
-		// fix https://github.com/Altinity/clickhouse-backup/issues/525
-		deletedBackups := make([]Backup, len(backups)-keep)
-		copied := copy(deletedBackups, backups[keep:])
-		if copied != len(backups)-keep {
-			log.Warnf("copied wrong items from backup list expected=%d, actual=%d", len(backups)-keep, copied)
-		}
-		keepBackups := make([]Backup, keep)
-		copied = copy(keepBackups, backups[:keep])
-		if copied != keep {
-			log.Warnf("copied wrong items from backup list expected=%d, actual=%d", keep, copied)
-		}
-		var findRequiredBackup func(b Backup)
-		findRequiredBackup = func(b Backup) {
-			if b.RequiredBackup != "" {
-				for i, deletedBackup := range deletedBackups {
-					if b.RequiredBackup == deletedBackup.BackupName {
-						deletedBackups = append(deletedBackups[:i], deletedBackups[i+1:]...)
-						findRequiredBackup(deletedBackup)
-						break
-					}
-				}
-			}
-		}
-		for _, b := range keepBackups {
-			findRequiredBackup(b)
-		}
-		// remove from old backup list backup with UploadDate `0001-01-01 00:00:00`, to avoid race condition for multiple shards copy
-		// fix https://github.com/Altinity/clickhouse-backup/issues/409
-		i := 0
-		for _, b := range deletedBackups {
-			if b.UploadDate != time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC) {
-				deletedBackups[i] = b
-				i++
-			}
-		}
-		deletedBackups = deletedBackups[:i]
-		return deletedBackups
-	}
-	return []Backup{}
-}
-
-func getArchiveWriter(format string, level int) (*archiver.CompressedArchive, error) {
+func getArchiveWriter(format string, level int, zstdOptions ...zstd.EOption) (*archiver.CompressedArchive, error) {
 	switch format {
 	case "tar":
 		return &archiver.CompressedArchive{Archival: archiver.Tar{}}, nil
@@ -77,12 +26,12 @@ func getArchiveWriter(format string, level int) (*archiver.CompressedArchive, er
 	case "br", "brotli":
 		return &archiver.CompressedArchive{Compression: archiver.Brotli{Quality: level}, Archival: archiver.Tar{}}, nil
 	case "zstd":
-		return &archiver.CompressedArchive{Compression: archiver.Zstd{EncoderOptions: []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}}, Archival: archiver.Tar{}}, nil
+		return &archiver.CompressedArchive{Compression: archiver.Zstd{EncoderOptions: append([]zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}, zstdOptions...)}, Archival: archiver.Tar{}}, nil
 	}
 	return nil, fmt.Errorf("wrong compression_format: %s, supported: 'tar', 'lz4', 'bzip2', 'bz2', 'gzip', 'gz', 'sz', 'xz', 'br', 'brotli', 'zstd'", format)
 }
 
-func getArchiveReader(format string) (*archiver.CompressedArchive, error) {
+func getArchiveReader(format string, zstdOptions ...zstd.DOption) (*archiver.CompressedArchive, error) {
 	switch format {
 	case "tar":
 		return &archiver.CompressedArchive{Archival: archiver.Tar{}}, nil
@@ -99,7 +48,7 @@ func getArchiveReader(format string) (*archiver.CompressedArchive, error) {
 	case "br", "brotli":
 		return &archiver.CompressedArchive{Compression: archiver.Brotli{}, Archival: archiver.Tar{}}, nil
 	case "zstd":
-		return &archiver.CompressedArchive{Compression: archiver.Zstd{}, Archival: archiver.Tar{}}, nil
+		return &archiver.CompressedArchive{Compression: archiver.Zstd{DecoderOptions: zstdOptions}, Archival: archiver.Tar{}}, nil
 	}
 	return nil, fmt.Errorf("wrong compression_format: %s, supported: 'tar', 'lz4', 'bzip2', 'bz2', 'gzip', 'gz', 'sz', 'xz', 'br', 'brotli', 'zstd'", format)
 }
@@ -128,3 +77,36 @@ func checkArchiveExtension(ext, format string) bool {
 	}
 	return false
 }
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// CompressedSize - size of data from r after compression with format and level, the same compressor as upload uses, `tar` and `none` formats don't compress data
+func CompressedSize(format string, level int, r io.Reader) (int64, error) {
+	if format == "none" || format == "tar" {
+		return io.Copy(io.Discard, r)
+	}
+	z, err := getArchiveWriter(format, level)
+	if err != nil {
+		return 0, err
+	}
+	counter := &countingWriter{}
+	w, err := z.Compression.OpenWriter(counter)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return 0, err
+	}
+	if err = w.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
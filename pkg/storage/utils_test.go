@@ -29,8 +29,8 @@ func TestGetBackupsToDelete(t *testing.T) {
 		{metadata.BackupMetadata{BackupName: "two"}, false, "", "", timeParse("2019-03-28T19-50-12")},
 		{metadata.BackupMetadata{BackupName: "one"}, false, "", "", timeParse("2019-03-28T19-50-11")},
 	}
-	assert.Equal(t, expectedData, GetBackupsToDelete(testData, 3))
-	assert.Equal(t, []Backup{}, GetBackupsToDelete([]Backup{testData[0]}, 3))
+	assert.Equal(t, expectedData, GetBackupsToDeleteByRetention(testData, BackupRetention{KeepLast: 3}, time.Now()))
+	assert.Equal(t, []Backup{}, GetBackupsToDeleteByRetention([]Backup{testData[0]}, BackupRetention{KeepLast: 3}, time.Now()))
 }
 
 func TestGetBackupsToDeleteWithRequiredBackup(t *testing.T) {
@@ -45,8 +45,8 @@ func TestGetBackupsToDeleteWithRequiredBackup(t *testing.T) {
 	expectedData := []Backup{
 		{metadata.BackupMetadata{BackupName: "1"}, false, "", "", timeParse("2019-03-28T19-50-11")},
 	}
-	assert.Equal(t, expectedData, GetBackupsToDelete(testData, 3))
-	assert.Equal(t, []Backup{}, GetBackupsToDelete([]Backup{testData[0]}, 3))
+	assert.Equal(t, expectedData, GetBackupsToDeleteByRetention(testData, BackupRetention{KeepLast: 3}, time.Now()))
+	assert.Equal(t, []Backup{}, GetBackupsToDeleteByRetention([]Backup{testData[0]}, BackupRetention{KeepLast: 3}, time.Now()))
 
 	// fix https://github.com/Altinity/clickhouse-backup/issues/385
 	testData = []Backup{
@@ -57,8 +57,8 @@ func TestGetBackupsToDeleteWithRequiredBackup(t *testing.T) {
 		{metadata.BackupMetadata{BackupName: "4", RequiredBackup: "3"}, false, "", "", timeParse("2019-03-28T19-50-14")},
 	}
 	expectedData = []Backup{}
-	assert.Equal(t, expectedData, GetBackupsToDelete(testData, 3))
-	assert.Equal(t, []Backup{}, GetBackupsToDelete([]Backup{testData[0]}, 3))
+	assert.Equal(t, expectedData, GetBackupsToDeleteByRetention(testData, BackupRetention{KeepLast: 3}, time.Now()))
+	assert.Equal(t, []Backup{}, GetBackupsToDeleteByRetention([]Backup{testData[0]}, BackupRetention{KeepLast: 3}, time.Now()))
 
 }
 
@@ -73,7 +73,7 @@ func TestGetBackupsToDeleteWithInvalidUploadDate(t *testing.T) {
 	expectedData := []Backup{
 		{metadata.BackupMetadata{BackupName: "1"}, false, "", "", timeParse("2022-03-03T18-08-01")},
 	}
-	assert.Equal(t, expectedData, GetBackupsToDelete(testData, 2))
+	assert.Equal(t, expectedData, GetBackupsToDeleteByRetention(testData, BackupRetention{KeepLast: 2}, time.Now()))
 
 }
 
@@ -123,5 +123,49 @@ func TestGetBackupsToDeleteWithRecursiveRequiredBackups(t *testing.T) {
 		{metadata.BackupMetadata{BackupName: "2022-09-01T21-00-03", RequiredBackup: "2022-09-01T05-00-01"}, false, "", "", timeParse("2022-09-01T21-00-03")},
 		{metadata.BackupMetadata{BackupName: "2022-09-01T05-00-01"}, false, "", "", timeParse("2022-09-01T05-00-01")},
 	}
-	assert.Equal(t, expectedData, GetBackupsToDelete(testData, 6))
+	assert.Equal(t, expectedData, GetBackupsToDeleteByRetention(testData, BackupRetention{KeepLast: 6}, time.Now()))
+}
+
+func TestGetBackupsToDeleteByRetention(t *testing.T) {
+	testData := []Backup{
+		{metadata.BackupMetadata{BackupName: "month1"}, false, "", "", timeParse("2023-01-15T01-00-00")},
+		{metadata.BackupMetadata{BackupName: "month2-base"}, false, "", "", timeParse("2023-02-01T01-00-00")},
+		{metadata.BackupMetadata{BackupName: "month2-last", RequiredBackup: "month2-base"}, false, "", "", timeParse("2023-02-20T01-00-00")},
+		{metadata.BackupMetadata{BackupName: "day1-first"}, false, "", "", timeParse("2023-03-01T01-00-00")},
+		{metadata.BackupMetadata{BackupName: "day1-last"}, false, "", "", timeParse("2023-03-01T13-00-00")},
+		{metadata.BackupMetadata{BackupName: "day2"}, false, "", "", timeParse("2023-03-02T01-00-00")},
+		{metadata.BackupMetadata{BackupName: "day3"}, false, "", "", timeParse("2023-03-03T01-00-00")},
+	}
+	now := timeParse("2023-03-03T02-00-00")
+	expectedData := []Backup{
+		{metadata.BackupMetadata{BackupName: "day1-first"}, false, "", "", timeParse("2023-03-01T01-00-00")},
+		{metadata.BackupMetadata{BackupName: "month1"}, false, "", "", timeParse("2023-01-15T01-00-00")},
+	}
+	assert.Equal(t, expectedData, GetBackupsToDeleteByRetention(testData, BackupRetention{KeepLast: 1, KeepDaily: 3, KeepMonthly: 2}, now))
+	assert.Equal(t, []Backup{}, GetBackupsToDeleteByRetention(testData, BackupRetention{KeepLast: 1, MinAge: 24 * 30 * 3 * time.Hour}, now))
+	assert.Equal(t, []Backup{}, GetBackupsToDeleteByRetention(testData, BackupRetention{MinAge: time.Hour}, now))
+}
+
+func TestGetBackupsToDeleteByRetentionWithGroups(t *testing.T) {
+	testData := []Backup{
+		{metadata.BackupMetadata{BackupName: "old-shard1"}, false, "", "", timeParse("2019-03-27T19-50-11")},
+		{metadata.BackupMetadata{BackupName: "old-shard2"}, false, "", "", timeParse("2019-03-27T19-50-12")},
+		{metadata.BackupMetadata{BackupName: "prev-shard1"}, false, "", "", timeParse("2019-03-28T19-50-11")},
+		{metadata.BackupMetadata{BackupName: "prev-shard2"}, false, "", "", timeParse("2019-03-28T19-50-12")},
+		{metadata.BackupMetadata{BackupName: "new-shard1", RequiredBackup: "prev-shard1"}, false, "", "", timeParse("2019-03-29T19-50-11")},
+		{metadata.BackupMetadata{BackupName: "new-shard2"}, false, "", "", time.Time{}},
+		{metadata.BackupMetadata{BackupName: "standalone"}, false, "", "", timeParse("2019-03-26T19-50-11")},
+	}
+	groups := map[string]string{
+		"old-shard1": "old", "old-shard2": "old",
+		"prev-shard1": "prev", "prev-shard2": "prev",
+		"new-shard1": "new", "new-shard2": "new",
+	}
+	deleted := GetBackupsToDeleteByRetentionWithGroups(testData, groups, BackupRetention{KeepLast: 1}, time.Now())
+	deletedNames := make([]string, 0, len(deleted))
+	for _, b := range deleted {
+		deletedNames = append(deletedNames, b.BackupName)
+	}
+	assert.ElementsMatch(t, []string{"old-shard1", "old-shard2", "standalone"}, deletedNames, "uploading shard keeps whole group, required group is kept")
+	assert.Equal(t, []Backup{}, GetBackupsToDeleteByRetentionWithGroups(testData[:2], groups, BackupRetention{KeepLast: 1}, time.Now()), "shards of one cluster backup counted as one backup")
 }
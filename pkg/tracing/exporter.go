@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// newProvider - TracerProvider with batch span processor and OTLP/HTTP exporter, tracing->otlp_endpoint contains scheme, host and optional path prefix before `/v1/traces`
+func newProvider(cfg config.TracingConfig) (*provider, error) {
+	exporterOptions, err := exporterOptionsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(context.Background(), exporterOptions...)
+	if err != nil {
+		return nil, err
+	}
+	interval, _ := time.ParseDuration(cfg.ExportInterval)
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+	hostname, _ := os.Hostname()
+	sdk := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(interval), sdktrace.WithExportTimeout(timeout)),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", cfg.ServiceName),
+			attribute.String("host.name", hostname),
+		)),
+	)
+	return &provider{
+		cfg:    cfg,
+		sdk:    sdk,
+		tracer: sdk.Tracer(instrumentationName),
+	}, nil
+}
+
+func exporterOptionsFromConfig(cfg config.TracingConfig) ([]otlptracehttp.Option, error) {
+	endpoint, err := url.Parse(cfg.OTLPEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracing otlp_endpoint: %v", err)
+	}
+	if endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid tracing otlp_endpoint: '%s', shall be like http://otel-collector:4318", cfg.OTLPEndpoint)
+	}
+	urlPath := strings.TrimSuffix(endpoint.Path, "/")
+	if !strings.HasSuffix(urlPath, "/v1/traces") {
+		urlPath += "/v1/traces"
+	}
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint.Host),
+		otlptracehttp.WithURLPath(urlPath),
+	}
+	if endpoint.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if len(cfg.OTLPHeaders) > 0 {
+		options = append(options, otlptracehttp.WithHeaders(cfg.OTLPHeaders))
+	}
+	if timeout, err := time.ParseDuration(cfg.Timeout); err == nil && timeout > 0 {
+		options = append(options, otlptracehttp.WithTimeout(timeout))
+	}
+	return options, nil
+}
+
+// shutdown - export queued spans and stop batch span processor
+func (p *provider) shutdown(ctx context.Context) {
+	if err := p.sdk.Shutdown(ctx); err != nil {
+		apexLog.WithField("logger", "tracing").Warnf("can't export spans to %s: %v", p.cfg.OTLPEndpoint, err)
+	}
+}
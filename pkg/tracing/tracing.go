@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/ClickHouse/clickhouse-go/v2"
+	apexLog "github.com/apex/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/Altinity/clickhouse-backup"
+
+var (
+	mx      sync.RWMutex
+	current *provider
+)
+
+// provider - TracerProvider created for tracing config, replaced when config changed after reload in `server` mode
+type provider struct {
+	cfg    config.TracingConfig
+	sdk    *sdktrace.TracerProvider
+	tracer trace.Tracer
+}
+
+// Span - one timed operation, nil Span is valid and does nothing, so callers don't check tracing->otlp_endpoint
+type Span struct {
+	span trace.Span
+}
+
+// Init - start background export when tracing->otlp_endpoint is not empty, called for each Backuper,
+// so changed `tracing` section after config reload replaces TracerProvider, previous provider exports already finished spans in background
+func Init(cfg *config.Config) {
+	mx.RLock()
+	unchanged := (current == nil && cfg.Tracing.OTLPEndpoint == "") || (current != nil && reflect.DeepEqual(current.cfg, cfg.Tracing))
+	mx.RUnlock()
+	if unchanged {
+		return
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	if (current == nil && cfg.Tracing.OTLPEndpoint == "") || (current != nil && reflect.DeepEqual(current.cfg, cfg.Tracing)) {
+		return
+	}
+	previous := current
+	current = nil
+	if cfg.Tracing.OTLPEndpoint != "" {
+		p, err := newProvider(cfg.Tracing)
+		if err != nil {
+			apexLog.WithField("logger", "tracing").Errorf("can't initialize tracing: %v", err)
+		} else {
+			current = p
+		}
+	}
+	if previous != nil {
+		go previous.shutdown(context.Background())
+	}
+}
+
+// Shutdown - export all finished spans, shall be called before process exit
+func Shutdown(ctx context.Context) {
+	mx.Lock()
+	previous := current
+	current = nil
+	mx.Unlock()
+	if previous != nil {
+		previous.shutdown(ctx)
+	}
+}
+
+// IsEnabled - tracing->otlp_endpoint is configured
+func IsEnabled() bool {
+	mx.RLock()
+	defer mx.RUnlock()
+	return current != nil
+}
+
+// Start - create child span of span from ctx, or new trace when ctx doesn't contain span,
+// returned context passes span to clickhouse-server, so query spans from system.opentelemetry_span_log have the same trace
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, *Span) {
+	mx.RLock()
+	p := current
+	mx.RUnlock()
+	if p == nil {
+		return ctx, nil
+	}
+	ctx, span := p.tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+	ctx = clickhouse.Context(ctx, clickhouse.WithSpan(span.SpanContext()))
+	return ctx, &Span{span: span}
+}
+
+// SetAttributes - add attributes which known only after operation, like uploaded bytes
+func (s *Span) SetAttributes(attributes ...attribute.KeyValue) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attributes...)
+}
+
+// End - finish span, not nil err marks span status as error
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	} else {
+		s.span.SetStatus(codes.Ok, "")
+	}
+	s.span.End()
+}
@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type collector struct {
+	mx      sync.Mutex
+	spans   map[string]string
+	headers http.Header
+	paths   []string
+}
+
+func newCollector(t *testing.T) (*collector, *httptest.Server) {
+	c := &collector{spans: map[string]string{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		request := &collectortrace.ExportTraceServiceRequest{}
+		require.NoError(t, proto.Unmarshal(body, request))
+		c.mx.Lock()
+		defer c.mx.Unlock()
+		c.headers = r.Header.Clone()
+		c.paths = append(c.paths, r.URL.Path)
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				for _, span := range scopeSpans.Spans {
+					c.spans[span.Name] = span.Status.GetMessage()
+				}
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+func TestTracing(t *testing.T) {
+	first, firstServer := newCollector(t)
+	second, secondServer := newCollector(t)
+	cfg := config.DefaultConfig()
+	cfg.Tracing.OTLPEndpoint = firstServer.URL + "/otlp"
+	cfg.Tracing.OTLPHeaders = map[string]string{"Authorization": "Bearer XXX"}
+	Init(cfg)
+	require.True(t, IsEnabled())
+
+	ctx, span := Start(context.Background(), "upload")
+	_, child := Start(ctx, "upload_table")
+	child.End(errors.New("access denied"))
+	span.End(nil)
+
+	// config reload in server mode
+	reloaded := config.DefaultConfig()
+	reloaded.Tracing.OTLPEndpoint = secondServer.URL
+	Init(reloaded)
+	_, span = Start(context.Background(), "download")
+	span.End(nil)
+	Shutdown(context.Background())
+	assert.False(t, IsEnabled())
+
+	require.Eventually(t, func() bool {
+		first.mx.Lock()
+		defer first.mx.Unlock()
+		return len(first.spans) == 2
+	}, 10*time.Second, 50*time.Millisecond, "previous provider shall export finished spans after reload")
+	first.mx.Lock()
+	assert.Equal(t, map[string]string{"upload": "", "upload_table": "access denied"}, first.spans)
+	assert.Equal(t, "Bearer XXX", first.headers.Get("Authorization"))
+	assert.Equal(t, "/otlp/v1/traces", first.paths[0])
+	first.mx.Unlock()
+	second.mx.Lock()
+	assert.Equal(t, map[string]string{"download": ""}, second.spans)
+	assert.Equal(t, "/v1/traces", second.paths[0])
+	second.mx.Unlock()
+
+	var nilSpan *Span
+	nilSpan.End(nil)
+	_, nilSpan = Start(context.Background(), "disabled")
+	assert.Nil(t, nilSpan, "without otlp_endpoint spans shall not be created")
+}
+
+func TestExporterOptionsFromConfig(t *testing.T) {
+	for _, endpoint := range []string{"otel-collector:4318", "ftp://otel-collector", ""} {
+		_, err := exporterOptionsFromConfig(config.TracingConfig{OTLPEndpoint: endpoint})
+		assert.Error(t, err, endpoint)
+	}
+	options, err := exporterOptionsFromConfig(config.TracingConfig{OTLPEndpoint: "https://otel-collector:4318/v1/traces", Timeout: "10s"})
+	require.NoError(t, err)
+	assert.Len(t, options, 3)
+}
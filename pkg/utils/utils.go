@@ -1,9 +1,12 @@
 package utils
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/apex/log"
+	"io"
+	"os"
 	"os/exec"
 	"regexp"
 	"strings"
@@ -72,3 +75,18 @@ func ExecCmdOut(ctx context.Context, timeout time.Duration, cmd string, args ...
 	cancel()
 	return string(out), err
 }
+
+// ExecCmdWithInput - run command with additional environment variables and stdin, return stdout and stderr separately, so stdout could be parsed
+func ExecCmdWithInput(ctx context.Context, timeout time.Duration, env []string, stdin io.Reader, cmd string, args ...string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	log.Infof("%s %s", cmd, strings.Join(args, " "))
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, cmd, args...)
+	c.Env = append(os.Environ(), env...)
+	c.Stdin = stdin
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err := c.Run()
+	return stdout.String(), stderr.String(), err
+}